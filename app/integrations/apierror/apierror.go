@@ -0,0 +1,370 @@
+/*
+Package apierror định nghĩa taxonomy lỗi có kiểu cho các integration (FolkForm, Pancake, Pancake POS, Firebase, AI provider).
+Thay vì trả về chuỗi lỗi tự do, các integration trả về một trong các kiểu lỗi dưới đây để caller
+(jobs, MetricsCollector) có thể phân loại lỗi và quyết định retry hay bỏ qua:
+- ErrUnauthorized: token hết hạn / không có quyền (401, 403) → cần đăng nhập lại, không retry
+- ErrRateLimited: bị giới hạn tốc độ (429 hoặc error_code 429) → retry sau RetryAfter
+- ErrNotFound: tài nguyên không tồn tại (404) → bỏ qua, không retry
+- ErrValidation: request không hợp lệ (400, 409, 422) → bỏ qua, không retry
+- ErrNetwork: lỗi mạng / timeout / không đọc được response → retry
+- ErrUpstream: server lỗi (5xx) hoặc response không thành công khác → retry
+- ErrRetryExhausted: đã retry hết số lần cho phép, bọc lỗi cuối cùng
+Dùng errors.As để lấy chi tiết, hoặc Classify/IsRetryable để phân loại nhanh.
+*/
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Category là nhóm lỗi dùng để báo cáo (check-in, log) và quyết định retry
+type Category string
+
+const (
+	CategoryUnauthorized Category = "unauthorized"
+	CategoryRateLimited  Category = "rate_limited"
+	CategoryNotFound     Category = "not_found"
+	CategoryValidation   Category = "validation"
+	CategoryNetwork      Category = "network"
+	CategoryUpstream     Category = "upstream"
+	CategoryUnknown      Category = "unknown"
+)
+
+// Tên các hệ thống upstream (dùng cho field System của các lỗi)
+const (
+	SystemFolkForm   = "FolkForm"
+	SystemPancake    = "Pancake"
+	SystemPancakePos = "PancakePOS"
+	SystemFirebase   = "Firebase"
+	SystemAI         = "AI" // AI provider, dùng dạng "AI/<provider>" (ví dụ: "AI/openai")
+)
+
+// ErrUnauthorized: token không hợp lệ / hết hạn hoặc không có quyền truy cập
+type ErrUnauthorized struct {
+	System   string
+	Endpoint string
+	Status   int
+	Code     interface{} // error_code / code từ response body (nếu có)
+	Message  string
+}
+
+func (e *ErrUnauthorized) Error() string {
+	return fmt.Sprintf("%s %s: chưa xác thực hoặc không có quyền (status: %d, code: %v): %s", e.System, e.Endpoint, e.Status, e.Code, e.Message)
+}
+
+// ErrRateLimited: upstream từ chối vì gửi quá nhiều request
+type ErrRateLimited struct {
+	System     string
+	Endpoint   string
+	RetryAfter time.Duration // Thời gian nên đợi trước khi thử lại (0 nếu server không gửi Retry-After)
+	Code       interface{}
+	Message    string
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("%s %s: bị giới hạn tốc độ (retry after: %v): %s", e.System, e.Endpoint, e.RetryAfter, e.Message)
+}
+
+// ErrNotFound: tài nguyên không tồn tại
+type ErrNotFound struct {
+	System   string
+	Endpoint string
+	Code     interface{}
+	Message  string
+}
+
+func (e *ErrNotFound) Error() string {
+	return fmt.Sprintf("%s %s: không tìm thấy (code: %v): %s", e.System, e.Endpoint, e.Code, e.Message)
+}
+
+// ErrValidation: request không hợp lệ (dữ liệu sai, trùng lặp, thiếu field...)
+type ErrValidation struct {
+	System   string
+	Endpoint string
+	Status   int
+	Code     interface{}
+	Message  string
+}
+
+func (e *ErrValidation) Error() string {
+	return fmt.Sprintf("%s %s: request không hợp lệ (status: %d, code: %v): %s", e.System, e.Endpoint, e.Status, e.Code, e.Message)
+}
+
+// ErrNetwork: lỗi kết nối, timeout hoặc không đọc/parse được response
+type ErrNetwork struct {
+	System   string
+	Endpoint string
+	Err      error
+}
+
+func (e *ErrNetwork) Error() string {
+	return fmt.Sprintf("%s %s: lỗi mạng: %v", e.System, e.Endpoint, e.Err)
+}
+
+func (e *ErrNetwork) Unwrap() error { return e.Err }
+
+// ErrUpstream: upstream trả về lỗi server (5xx) hoặc response không thành công không thuộc các nhóm trên
+type ErrUpstream struct {
+	System   string
+	Endpoint string
+	Status   int
+	Code     interface{}
+	Message  string
+}
+
+func (e *ErrUpstream) Error() string {
+	return fmt.Sprintf("%s %s: upstream lỗi (status: %d, code: %v): %s", e.System, e.Endpoint, e.Status, e.Code, e.Message)
+}
+
+// ErrRetryExhausted: đã thử hết số lần cho phép, Last là lỗi của lần thử cuối cùng
+type ErrRetryExhausted struct {
+	System   string
+	Endpoint string
+	Attempts int
+	Last     error
+}
+
+func (e *ErrRetryExhausted) Error() string {
+	if e.Last == nil {
+		return fmt.Sprintf("Đã thử quá nhiều lần (%d). Thoát vòng lặp. (%s %s)", e.Attempts, e.System, e.Endpoint)
+	}
+	return fmt.Sprintf("Đã thử quá nhiều lần (%d). Thoát vòng lặp. Lỗi cuối: %v", e.Attempts, e.Last)
+}
+
+func (e *ErrRetryExhausted) Unwrap() error { return e.Last }
+
+// NewNetwork tạo ErrNetwork
+func NewNetwork(system, endpoint string, err error) error {
+	return &ErrNetwork{System: system, Endpoint: endpoint, Err: err}
+}
+
+// NewValidation tạo ErrValidation cho lỗi dữ liệu đầu vào phát hiện ở phía agent (trước khi gửi request)
+func NewValidation(system, endpoint, message string) error {
+	return &ErrValidation{System: system, Endpoint: endpoint, Message: message}
+}
+
+// NewRetryExhausted tạo ErrRetryExhausted bọc lỗi của lần thử cuối
+func NewRetryExhausted(system, endpoint string, attempts int, last error) error {
+	return &ErrRetryExhausted{System: system, Endpoint: endpoint, Attempts: attempts, Last: last}
+}
+
+// FromResponse tạo lỗi có kiểu từ HTTP response không thành công
+// Tham số:
+//   - status: HTTP status code
+//   - header: Response header (dùng để đọc Retry-After, có thể nil)
+//   - body: Response body (raw), dùng để lấy error_code/code/message nếu là JSON
+func FromResponse(system, endpoint string, status int, header http.Header, body []byte) error {
+	var parsed map[string]interface{}
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &parsed)
+	}
+	code, message := extractCodeAndMessage(parsed)
+	if message == "" && len(body) > 0 && parsed == nil {
+		message = truncate(string(body), 200)
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	var retryAfter time.Duration
+	if header != nil {
+		retryAfter = parseRetryAfter(header.Get("Retry-After"))
+	}
+
+	return build(system, endpoint, status, code, message, retryAfter)
+}
+
+// FromResult tạo lỗi có kiểu từ response body đã parse có status 200 nhưng báo không thành công
+// (ví dụ Pancake: {"success": false, "error_code": 429}, FolkForm: {"status": "error", "code": ...})
+func FromResult(system, endpoint string, status int, result map[string]interface{}) error {
+	code, message := extractCodeAndMessage(result)
+	if message == "" {
+		message = "response không thành công"
+	}
+	// Ưu tiên error code dạng số (Pancake dùng mã HTTP-like trong error_code)
+	effective := status
+	if n, ok := codeAsInt(code); ok && n >= 400 && n < 600 {
+		effective = n
+	} else if effective < 400 {
+		// Status 200 nhưng không thành công và không có mã HTTP-like → coi là lỗi upstream
+		return &ErrUpstream{System: system, Endpoint: endpoint, Status: status, Code: code, Message: message}
+	}
+	return build(system, endpoint, effective, code, message, 0)
+}
+
+// build chọn kiểu lỗi theo status code
+func build(system, endpoint string, status int, code interface{}, message string, retryAfter time.Duration) error {
+	if n, ok := codeAsInt(code); ok && n == http.StatusTooManyRequests {
+		status = http.StatusTooManyRequests
+	}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return &ErrUnauthorized{System: system, Endpoint: endpoint, Status: status, Code: code, Message: message}
+	case status == http.StatusTooManyRequests:
+		return &ErrRateLimited{System: system, Endpoint: endpoint, RetryAfter: retryAfter, Code: code, Message: message}
+	case status == http.StatusNotFound:
+		return &ErrNotFound{System: system, Endpoint: endpoint, Code: code, Message: message}
+	case status == http.StatusBadRequest || status == http.StatusConflict || status == http.StatusUnprocessableEntity:
+		return &ErrValidation{System: system, Endpoint: endpoint, Status: status, Code: code, Message: message}
+	default:
+		return &ErrUpstream{System: system, Endpoint: endpoint, Status: status, Code: code, Message: message}
+	}
+}
+
+// Classify trả về nhóm lỗi của err (đi xuyên qua các lớp wrap bằng errors.As)
+func Classify(err error) Category {
+	if err == nil {
+		return ""
+	}
+	var (
+		unauthorized *ErrUnauthorized
+		rateLimited  *ErrRateLimited
+		notFound     *ErrNotFound
+		validation   *ErrValidation
+		network      *ErrNetwork
+		upstream     *ErrUpstream
+	)
+	switch {
+	case errors.As(err, &unauthorized):
+		return CategoryUnauthorized
+	case errors.As(err, &rateLimited):
+		return CategoryRateLimited
+	case errors.As(err, &notFound):
+		return CategoryNotFound
+	case errors.As(err, &validation):
+		return CategoryValidation
+	case errors.As(err, &network):
+		return CategoryNetwork
+	case errors.As(err, &upstream):
+		return CategoryUpstream
+	}
+	return CategoryUnknown
+}
+
+// IsRetryable cho biết có nên thử lại request sau lỗi này không
+// Retry: rate limited, network, upstream (5xx). Không retry: unauthorized, not found, validation, đã hết số lần retry
+func IsRetryable(err error) bool {
+	var exhausted *ErrRetryExhausted
+	if errors.As(err, &exhausted) {
+		return false
+	}
+	switch Classify(err) {
+	case CategoryRateLimited, CategoryNetwork, CategoryUpstream:
+		return true
+	}
+	return false
+}
+
+// IsUnauthorized cho biết lỗi có phải do chưa xác thực / token hết hạn không
+// Jobs nên dừng cả lượt chạy (thay vì bỏ qua từng item) khi gặp lỗi này vì mọi request tiếp theo đều sẽ thất bại
+func IsUnauthorized(err error) bool {
+	return Classify(err) == CategoryUnauthorized
+}
+
+// IsNotFound cho biết lỗi có phải do tài nguyên không tồn tại không
+func IsNotFound(err error) bool {
+	return Classify(err) == CategoryNotFound
+}
+
+// RetryAfter trả về thời gian nên đợi nếu err là ErrRateLimited (0 nếu không có)
+func RetryAfter(err error) time.Duration {
+	var rateLimited *ErrRateLimited
+	if errors.As(err, &rateLimited) {
+		return rateLimited.RetryAfter
+	}
+	return 0
+}
+
+// StatusCode trả về HTTP status của lỗi (0 nếu không xác định)
+func StatusCode(err error) int {
+	var (
+		unauthorized *ErrUnauthorized
+		validation   *ErrValidation
+		upstream     *ErrUpstream
+	)
+	switch {
+	case errors.As(err, &unauthorized):
+		return unauthorized.Status
+	case errors.As(err, &validation):
+		return validation.Status
+	case errors.As(err, &upstream):
+		return upstream.Status
+	}
+	switch Classify(err) {
+	case CategoryRateLimited:
+		return http.StatusTooManyRequests
+	case CategoryNotFound:
+		return http.StatusNotFound
+	}
+	return 0
+}
+
+// extractCodeAndMessage lấy error code và message từ response body đã parse
+// Hỗ trợ các format: {"error_code": ..., "message": ...}, {"code": ..., "message": ...},
+// {"error": {"code": ..., "message": ...}} (Firebase)
+func extractCodeAndMessage(result map[string]interface{}) (interface{}, string) {
+	if result == nil {
+		return nil, ""
+	}
+	var code interface{}
+	if ec, ok := result["error_code"]; ok {
+		code = ec
+	} else if c, ok := result["code"]; ok {
+		code = c
+	}
+	message, _ := result["message"].(string)
+	if errMap, ok := result["error"].(map[string]interface{}); ok {
+		if code == nil {
+			code = errMap["code"]
+		}
+		if message == "" {
+			message, _ = errMap["message"].(string)
+		}
+	}
+	return code, message
+}
+
+// codeAsInt chuyển error code (float64 từ JSON, int, string số) sang int
+func codeAsInt(code interface{}) (int, bool) {
+	switch v := code.(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// parseRetryAfter đọc header Retry-After (số giây hoặc HTTP date)
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "...[truncated]"
+}
@@ -5,6 +5,7 @@ File bridge.go chứa các hàm bridge để đồng bộ dữ liệu giữa Pan
 package integrations
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -12,6 +13,7 @@ import (
 
 	apputility "agent_pancake/app/utility"
 	"agent_pancake/global"
+	"agent_pancake/utility/secrets"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -83,7 +85,7 @@ func parseResponseData(response map[string]interface{}) (items []interface{}, it
 // Hàm Bridge_SyncPages(access_token string) sẽ đồng bộ danh sách trang Facebook từ server Pancake về server FolkForm
 // - Lấy danh sách trang từ server Pancake
 // - Đẩy danh sách trang vào server FolkForm
-func bridge_SyncPagesOfAccessToken(access_token string) (resultErr error) {
+func bridge_SyncPagesOfAccessToken(ctx context.Context, access_token string) (resultErr error) {
 
 	log.Println("Đang đồng bộ trang với access token:", access_token)
 
@@ -103,7 +105,7 @@ func bridge_SyncPagesOfAccessToken(access_token string) (resultErr error) {
 
 		log.Println("Đang tạo trang trên FolkForm với access token:", access_token)
 
-		FolkForm_CreateFbPage(access_token, page)
+		FolkForm_CreateFbPage(ctx, access_token, page)
 
 	}
 
@@ -115,7 +117,7 @@ func bridge_SyncPagesOfAccessToken(access_token string) (resultErr error) {
 // Hàm Bridge_SyncPages sẽ đồng bộ danh sách trang Facebook từ server Pancake về server FolkForm
 // - Lấy danh sách access token từ server FolkForm
 // - Gọi hàm Bridge_SyncPagesOfAccessToken để đồng bộ trang của từng access token
-func Bridge_SyncPages() (resultErr error) {
+func Bridge_SyncPages(ctx context.Context) (resultErr error) {
 
 	log.Println("Bắt đầu đồng bộ trang Facebook từ server Pancake về server FolkForm...")
 
@@ -130,7 +132,7 @@ func Bridge_SyncPages() (resultErr error) {
 		// Lấy danh sách access token với filter system: "Pancake"
 		// Filter được xử lý ở server để chỉ lấy tokens có system: "Pancake"
 		filter := `{"system":"Pancake"}`
-		accessTokens, err := FolkForm_GetAccessTokens(page, limit, filter)
+		accessTokens, err := FolkForm_GetAccessTokens(ctx, page, limit, filter)
 		if err != nil {
 			logError("Lỗi khi lấy danh sách access token: %v", err)
 			return errors.New("Lỗi khi lấy danh sách access token")
@@ -165,7 +167,7 @@ func Bridge_SyncPages() (resultErr error) {
 
 				// Gọi hàm bridge_SyncPagesOfAccessToken để đồng bộ trang với access token
 				log.Printf("[Bridge_SyncPages] Đang đồng bộ trang với access token (system: Pancake): %s", access_token)
-				bridge_SyncPagesOfAccessToken(access_token)
+				bridge_SyncPagesOfAccessToken(ctx, access_token)
 			}
 
 		} else {
@@ -185,7 +187,7 @@ func Bridge_SyncPages() (resultErr error) {
 // Hàm FolkForm_UpdarePageAccessToken sẽ cập nhật page_access_token của trang Facebook trên server FolkForm bằng cách:
 // - Gửi yêu cầu tạo page_access_token lên server PanCake
 // - Lấy page_access_token từ phản hồi và cập nhật lên server FolkForm
-func Bridge_UpdatePagesAccessToken_toFolkForm() (resultErr error) {
+func Bridge_UpdatePagesAccessToken_toFolkForm(ctx context.Context) (resultErr error) {
 
 	limit := 50
 	page := 1
@@ -195,7 +197,7 @@ func Bridge_UpdatePagesAccessToken_toFolkForm() (resultErr error) {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("Lỗi khi lấy danh sách trang Facebook: %v", err)
 			return errors.New("Lỗi khi lấy danh sách trang Facebook")
@@ -239,7 +241,7 @@ func Bridge_UpdatePagesAccessToken_toFolkForm() (resultErr error) {
 					// chuyển resultGeneratePageAccessToken từ interface{} sang dạng map[string]interface{}
 					page_access_token := resultGeneratePageAccessToken["page_access_token"].(string)
 					// Gọi hàm FolkForm_UpdatePageAccessToken để cập nhật page_access_token
-					_, err = FolkForm_UpdatePageAccessToken(page_id, page_access_token)
+					_, err = FolkForm_UpdatePageAccessToken(ctx, page_id, page_access_token)
 					if err != nil {
 						logError("Lỗi khi cập nhật page access token: %v", err)
 						continue
@@ -263,7 +265,7 @@ func Bridge_UpdatePagesAccessToken_toFolkForm() (resultErr error) {
 // Hàm Bridge_SyncPagesFolkformToLocal sẽ đồng bộ danh sách trang Facebook từ server FolkForm về server local
 // - Lấy danh sách trang từ server FolkForm
 // - Đẩy danh sách trang vào server local
-func Bridge_SyncPagesFolkformToLocal() (resultErr error) {
+func Bridge_SyncPagesFolkformToLocal(ctx context.Context) (resultErr error) {
 	limit := 50
 	page := 1
 
@@ -272,7 +274,7 @@ func Bridge_SyncPagesFolkformToLocal() (resultErr error) {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("Lỗi khi lấy danh sách trang Facebook: %v", err)
 			return errors.New("Lỗi khi lấy danh sách trang Facebook")
@@ -309,6 +311,7 @@ func Bridge_SyncPagesFolkformToLocal() (resultErr error) {
 
 					// Append cloudFbPage to global.PanCake_FbPages
 					global.PanCake_FbPages = append(global.PanCake_FbPages, cloudFbPage)
+					secrets.Register(cloudFbPage.PageAccessToken, cloudFbPage.AccessToken)
 				}
 				global.PanCake_FbPagesMu.Unlock()
 			}
@@ -323,7 +326,7 @@ func Bridge_SyncPagesFolkformToLocal() (resultErr error) {
 // Hàm bridge_SyncConversationsOfPage sẽ đồng bộ danh sách hội thoại của trang Facebook từ server Pancake về server FolkForm
 // - Lấy danh sách hội thoại của page từ server Pancake
 // - Đẩy danh sách hội thoại vào server FolkForm
-func bridge_SyncConversationsOfPage(page_id string, page_username string) (resultErr error) {
+func bridge_SyncConversationsOfPage(ctx context.Context, page_id string, page_username string) (resultErr error) {
 
 	last_conversation_id := ""
 	conversationCount := 0
@@ -352,7 +355,7 @@ func bridge_SyncConversationsOfPage(page_id string, page_username string) (resul
 				log.Printf("[Bridge] [Batch %d] Lấy được %d conversations từ Pancake", batchCount, len(conversations))
 
 				for _, conversation := range conversations {
-					_, err = FolkForm_CreateConversation(page_id, page_username, conversation)
+					_, err = FolkForm_CreateConversation(ctx, page_id, page_username, conversation)
 					if err != nil {
 						logError("[Bridge] Lỗi khi tạo hội thoại (batch=%d): %v", batchCount, err)
 						continue
@@ -389,7 +392,7 @@ func bridge_SyncConversationsOfPage(page_id string, page_username string) (resul
 // Hàm Bridge_SyncConversations sẽ đồng bộ danh sách hội thoại của trang Facebook từ server Pancake về server FolkForm
 // - Lấy danh sách trang từ server FolkForm
 // - Gọi hàm bridge_SyncConversationsOfPage để đồng bộ hội thoại của từng trang
-func Bridge_SyncConversationsFromCloud() (resultErr error) {
+func Bridge_SyncConversationsFromCloud(ctx context.Context) (resultErr error) {
 
 	limit := 50
 	page := 1
@@ -400,7 +403,7 @@ func Bridge_SyncConversationsFromCloud() (resultErr error) {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("Lỗi khi lấy danh sách trang Facebook: %v", err)
 			return errors.New("Lỗi khi lấy danh sách trang Facebook")
@@ -426,7 +429,7 @@ func Bridge_SyncConversationsFromCloud() (resultErr error) {
 					is_sync := page["isSync"].(bool)
 					if page_access_token != "" && is_sync == true {
 						// Gọi hàm bridge_SyncConversationsOfPage để đồng bộ hội thoại của từng trang
-						err = bridge_SyncConversationsOfPage(page_id, page_username)
+						err = bridge_SyncConversationsOfPage(ctx, page_id, page_username)
 						if err != nil {
 							logError("Lỗi khi đồng bộ hội thoại: %v", err)
 							continue
@@ -450,16 +453,27 @@ func Bridge_SyncConversationsFromCloud() (resultErr error) {
 // Hàm bridge_SyncMessageOfConversation sẽ đồng bộ danh sách tin nhắn của hội thoại từ server Pancake về server FolkForm
 // Sử dụng pagination với current_count để lấy hết messages (không chỉ 30 đầu tiên)
 // Tối ưu: Chỉ sync messages mới hơn message mới nhất đã có trong FolkForm
-func bridge_SyncMessageOfConversation(page_id string, page_username string, conversation_id string, customer_id string) (resultErr error) {
-	log.Printf("[Bridge] Bắt đầu sync messages cho conversation: conversation_id=%s, page_id=%s, customer_id=%s", conversation_id, page_id, customer_id)
+func bridge_SyncMessageOfConversation(ctx context.Context, page_id string, page_username string, conversation_id string, customer_id string) (resultErr error) {
+	_, resultErr = bridge_SyncMessagesOfConversation(ctx, page_id, page_username, conversation_id, customer_id, false)
+	return resultErr
+}
+
+// Hàm bridge_SyncMessagesOfConversation đồng bộ tin nhắn của hội thoại, trả về số messages đã upsert
+// fullResync = true: bỏ qua message mới nhất trong FolkForm, upsert lại toàn bộ messages (command resync_conversation)
+func bridge_SyncMessagesOfConversation(ctx context.Context, page_id string, page_username string, conversation_id string, customer_id string, fullResync bool) (totalMessagesSynced int, resultErr error) {
+	log.Printf("[Bridge] Bắt đầu sync messages cho conversation: conversation_id=%s, page_id=%s, customer_id=%s, fullResync=%v", conversation_id, page_id, customer_id, fullResync)
 
 	// Lấy message mới nhất từ FolkForm để so sánh insertedAt
 	// Pancake messages được sắp xếp theo thời gian (mới nhất trước, index 0 là mới nhất)
 	// So sánh insertedAt: nếu message từ Pancake cũ hơn message mới nhất trong FolkForm → dừng
-	latestInsertedAt, err := FolkForm_GetLatestMessageItem(conversation_id)
-	if err != nil {
-		log.Printf("[Bridge] CẢNH BÁO: Không thể lấy latest message từ FolkForm, sẽ sync từ đầu - conversation_id=%s, error=%v", conversation_id, err)
-		latestInsertedAt = 0 // Fallback: sync từ đầu
+	var latestInsertedAt int64
+	var err error
+	if !fullResync {
+		latestInsertedAt, err = FolkForm_GetLatestMessageItem(ctx, conversation_id)
+		if err != nil {
+			log.Printf("[Bridge] CẢNH BÁO: Không thể lấy latest message từ FolkForm, sẽ sync từ đầu - conversation_id=%s, error=%v", conversation_id, err)
+			latestInsertedAt = 0 // Fallback: sync từ đầu
+		}
 	}
 
 	if latestInsertedAt > 0 {
@@ -474,11 +488,14 @@ func bridge_SyncMessageOfConversation(page_id string, page_username string, conv
 	// Sử dụng adaptive rate limiter để tránh rate limit
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
-	totalMessagesSynced := 0 // Số messages đã sync trong lần này
 	batchCount := 0
 	const maxMessagesPerBatch = 30 // Pancake API trả về tối đa 30 messages mỗi lần
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return totalMessagesSynced, err
+		}
+
 		// Sử dụng rate limiter trước khi gọi API
 		rateLimiter.Wait()
 
@@ -488,7 +505,7 @@ func bridge_SyncMessageOfConversation(page_id string, page_username string, conv
 		resultGetMessages, err := Pancake_GetMessages(page_id, conversation_id, customer_id, current_count)
 		if err != nil {
 			logError("[Bridge] Lỗi khi lấy danh sách tin nhắn từ server Pancake (conversation_id=%s, current_count=%d, batch=%d): %v", conversation_id, current_count, batchCount, err)
-			return fmt.Errorf("Lỗi khi lấy danh sách tin nhắn từ server Pancake: %v", err)
+			return totalMessagesSynced, fmt.Errorf("Lỗi khi lấy danh sách tin nhắn từ server Pancake: %v", err)
 		}
 
 		// Kiểm tra xem có messages không
@@ -559,10 +576,10 @@ func bridge_SyncMessageOfConversation(page_id string, page_username string, conv
 		hasMore := len(messages) >= maxMessagesPerBatch && !shouldStop
 
 		// Gọi endpoint mới /upsert-messages với dữ liệu nguyên gốc từ Pancake
-		_, err = FolkForm_UpsertMessages(page_id, page_username, conversation_id, customer_id, panCakeData, hasMore)
+		_, err = FolkForm_UpsertMessages(ctx, page_id, page_username, conversation_id, customer_id, panCakeData, hasMore)
 		if err != nil {
 			logError("[Bridge] Lỗi khi upsert messages lên server FolkForm (conversation_id=%s, batch=%d): %v", conversation_id, batchCount, err)
-			return fmt.Errorf("Lỗi khi upsert messages lên server FolkForm: %v", err)
+			return totalMessagesSynced, fmt.Errorf("Lỗi khi upsert messages lên server FolkForm: %v", err)
 		}
 
 		totalMessagesSynced += len(messagesToSync)
@@ -596,11 +613,11 @@ func bridge_SyncMessageOfConversation(page_id string, page_username string, conv
 		}
 	}
 
-	return nil
+	return totalMessagesSynced, nil
 }
 
 // Hàm Bridge_SyncMessages sẽ đồng bộ danh sách tin nhắn của trang Facebook từ server Pancake về server FolkForm
-func Bridge_SyncMessages() (resultErr error) {
+func Bridge_SyncMessages(ctx context.Context) (resultErr error) {
 
 	limit := 50
 	page := 1
@@ -611,7 +628,7 @@ func Bridge_SyncMessages() (resultErr error) {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách các Conversations từ server FolkForm
-		resultGetConversations, err := FolkForm_GetConversations(page, limit)
+		resultGetConversations, err := FolkForm_GetConversations(ctx, page, limit)
 		if err != nil {
 			logError("Lỗi khi lấy danh sách trang Facebook: %v", err)
 			return errors.New("Lỗi khi lấy danh sách trang Facebook")
@@ -659,7 +676,7 @@ func Bridge_SyncMessages() (resultErr error) {
 
 					log.Printf("[Bridge] Xử lý conversation: conversationId=%s, pageId=%s, customerId=%s", conversationId, pageId, customerId)
 
-					resultGetPageByPageId, err := FolkForm_GetFbPageByPageId(pageId)
+					resultGetPageByPageId, err := FolkForm_GetFbPageByPageId(ctx, pageId)
 					if err != nil {
 						logError("[Bridge] Lỗi khi lấy trang theo pageId (%s): %v", pageId, err)
 						skippedCount++
@@ -679,7 +696,7 @@ func Bridge_SyncMessages() (resultErr error) {
 					}
 
 					// Gọi hàm bridge_SyncMessageOfConversation để đồng bộ tin nhắn
-					err = bridge_SyncMessageOfConversation(pageId, pageUsername, conversationId, customerId)
+					err = bridge_SyncMessageOfConversation(ctx, pageId, pageUsername, conversationId, customerId)
 					if err != nil {
 						logError("[Bridge] Lỗi khi đồng bộ tin nhắn (conversationId=%s): %v", conversationId, err)
 						skippedCount++
@@ -708,12 +725,12 @@ func Bridge_SyncMessages() (resultErr error) {
 
 // getLastPanCakeUpdatedAt lấy panCakeUpdatedAt cuối cùng từ FolkForm cho một page
 // Trả về Unix timestamp (giây), hoặc 0 nếu không tìm thấy
-func getLastPanCakeUpdatedAt(page_id string) int64 {
+func getLastPanCakeUpdatedAt(ctx context.Context, page_id string) int64 {
 	log.Printf("[Bridge] Lấy panCakeUpdatedAt cuối cùng từ FolkForm cho page_id: %s", page_id)
 
 	// Lấy conversations từ FolkForm (sắp xếp theo panCakeUpdatedAt giảm dần với -1)
 	// Có thể dùng limit=1 vì items[0] đã là conversation mới nhất
-	resultGetConversations, err := FolkForm_GetConversationsWithPageId(1, 1, page_id)
+	resultGetConversations, err := FolkForm_GetConversationsWithPageId(ctx, 1, 1, page_id)
 	if err != nil {
 		logError("[Bridge] Lỗi khi lấy conversations từ FolkForm: %v", err)
 		return 0
@@ -766,11 +783,11 @@ func getLastPanCakeUpdatedAt(page_id string) int64 {
 
 // ========================================================================================================
 // Hàm đồng bộ dữ liệu mới nhất từ server Pancake về server FolkForm của 1 trang Facebook
-func Sync_NewMessagesOfPage(page_id string, page_username string) (resultErr error) {
+func Sync_NewMessagesOfPage(ctx context.Context, page_id string, page_username string) (resultErr error) {
 	log.Printf("[Bridge] Bắt đầu sync conversations mới cho page_id: %s", page_id)
 
 	// Bước 1: Lấy panCakeUpdatedAt cuối cùng từ FolkForm
-	lastUpdatedAt := getLastPanCakeUpdatedAt(page_id)
+	lastUpdatedAt := getLastPanCakeUpdatedAt(ctx, page_id)
 
 	// Bước 2: Tính since và until
 	var since int64
@@ -857,7 +874,7 @@ func Sync_NewMessagesOfPage(page_id string, page_username string) (resultErr err
 				}
 
 				// Tạo/update conversation trong FolkForm
-				_, err = FolkForm_CreateConversation(page_id, page_username, conversation)
+				_, err = FolkForm_CreateConversation(ctx, page_id, page_username, conversation)
 				if err != nil {
 					logError("[Bridge] Lỗi khi tạo/cập nhật hội thoại: %v", err)
 					continue
@@ -866,7 +883,7 @@ func Sync_NewMessagesOfPage(page_id string, page_username string) (resultErr err
 				conversationCount++
 
 				// Sync messages của conversation này
-				err = bridge_SyncMessageOfConversation(page_id, page_username, conversation_id, customerId)
+				err = bridge_SyncMessageOfConversation(ctx, page_id, page_username, conversation_id, customerId)
 				if err != nil {
 					logError("[Bridge] Lỗi khi đồng bộ tin nhắn: %v", err)
 					continue
@@ -900,7 +917,7 @@ func Sync_NewMessagesOfPage(page_id string, page_username string) (resultErr err
 }
 
 // Hàm Sync_NewMessages sẽ đồng bộ dữ liệu mới nhất từ server Pancake về server FolkForm
-func Sync_NewMessagesOfAllPages() (resultErr error) {
+func Sync_NewMessagesOfAllPages(ctx context.Context) (resultErr error) {
 
 	limit := 50
 	page := 1
@@ -911,7 +928,7 @@ func Sync_NewMessagesOfAllPages() (resultErr error) {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("Lỗi khi lấy danh sách trang Facebook: %v", err)
 			return errors.New("Lỗi khi lấy danh sách trang Facebook")
@@ -958,7 +975,7 @@ func Sync_NewMessagesOfAllPages() (resultErr error) {
 
 				if is_sync == true {
 					// Gọi hàm Sync_NewMessagesOfPage để đồng bộ tin nhắn của từng trang
-					err = Sync_NewMessagesOfPage(page_id, page_username)
+					err = Sync_NewMessagesOfPage(ctx, page_id, page_username)
 					if err != nil {
 						logError("Lỗi khi đồng bộ tin nhắn: %v", err)
 						continue
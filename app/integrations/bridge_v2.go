@@ -1,19 +1,68 @@
 package integrations
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
+	"agent_pancake/app/integrations/apierror"
 	apputility "agent_pancake/app/utility"
+	"agent_pancake/utility/secrets"
 )
 
+// shouldAbortSync cho biết lỗi có nên dừng cả lượt sync (thay vì bỏ qua page/item hiện tại) không
+// Lỗi xác thực FolkForm (token hết hạn / không có quyền) làm mọi request tiếp theo cũng thất bại,
+// nên dừng ngay và trả lỗi về job để CheckInJob đăng nhập lại. Lỗi xác thực Pancake/POS chỉ ảnh hưởng
+// một page/shop (token của page đó) nên vẫn bỏ qua item như các lỗi khác
+func shouldAbortSync(err error) bool {
+	var unauthorized *apierror.ErrUnauthorized
+	return errors.As(err, &unauthorized) && unauthorized.System == apierror.SystemFolkForm
+}
+
+// syncCancelled trả về lỗi khi ctx đã bị hủy hoặc hết hạn (params.timeoutSeconds của command, agent dừng)
+// Các vòng lặp sync kiểm tra trước mỗi page/batch để dừng giữa chừng thay vì chạy tới hết
+func syncCancelled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("dừng sync giữa chừng: %w", err)
+	}
+	return nil
+}
+
+// pageInScope kiểm tra page có nằm trong danh sách pageIds cần sync không (danh sách rỗng = tất cả pages)
+func pageInScope(pageIds []string, pageId string) bool {
+	if len(pageIds) == 0 {
+		return true
+	}
+	for _, id := range pageIds {
+		if id == pageId {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyPageSynced gọi callback onPage (nếu có) sau khi sync xong một page
+func notifyPageSynced(onPage func(pageId string, err error), pageId string, err error) {
+	if onPage != nil {
+		onPage(pageId, err)
+	}
+}
+
 // BridgeV2_SyncNewData sync conversations mới từ Pancake về FolkForm (incremental sync)
 // Logic: Ưu tiên sync tất cả conversations unseen trước, sau đó sync conversations đã đọc mới hơn lastConversationId
 // Lưu ý: Chỉ sync từ Pancake → FolkForm, không verify ngược lại (verify được tách ra job riêng)
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 50 nếu <= 0)
-func BridgeV2_SyncNewData(pageSize int) error {
+func BridgeV2_SyncNewData(ctx context.Context, pageSize int) error {
+	return BridgeV2_SyncNewDataForPages(ctx, pageSize, nil, nil)
+}
+
+// BridgeV2_SyncNewDataForPages giống BridgeV2_SyncNewData nhưng chỉ sync các page trong pageIds
+// (dùng cho job instance tạo từ server, ví dụ job riêng cho nhóm page VIP). pageIds rỗng = tất cả pages
+// onPage (có thể nil) được gọi sau mỗi page đã sync, err là lỗi của page đó (nil nếu thành công)
+func BridgeV2_SyncNewDataForPages(ctx context.Context, pageSize int, pageIds []string, onPage func(pageId string, err error)) error {
 	log.Println("[BridgeV2] Bắt đầu sync conversations mới (incremental sync)")
 
 	// Lấy tất cả pages từ FolkForm
@@ -25,11 +74,14 @@ func BridgeV2_SyncNewData(pageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -48,6 +100,9 @@ func BridgeV2_SyncNewData(pageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -68,32 +123,56 @@ func BridgeV2_SyncNewData(pageSize int) error {
 				continue
 			}
 
+			if !pageInScope(pageIds, pageId) {
+				continue
+			}
+
 			// Lấy conversation mới nhất từ FolkForm
-			lastConversationId, err := FolkForm_GetLastConversationId(pageId)
+			lastConversationId, err := FolkForm_GetLastConversationId(ctx, pageId)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi lấy lastConversationId cho page %s: %v", pageId, err)
+				notifyPageSynced(onPage, pageId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				continue
 			}
 
 			log.Printf("[BridgeV2] Page %s - lastConversationId: %s", pageId, lastConversationId)
 
+			// Mốc do server đặt lại (command reset_checkpoint) → sync conversations đã đọc cập nhật từ mốc đó
+			checkpointSince, hasCheckpoint := syncCheckpointOverride(SyncCheckpointConversations, pageId)
+
 			// BƯỚC 1: Sync tất cả conversations unseen trước (không check lastConversationId)
 			// Đảm bảo tất cả conversations unseen được sync, kể cả những conversation có updated_at cũ
 			log.Printf("[BridgeV2] Page %s - Bước 1: Sync tất cả conversations unseen từ Pancake", pageId)
-			err = bridgeV2_SyncUnseenConversations(pageId, pageUsername)
+			err = bridgeV2_SyncUnseenConversations(ctx, pageId, pageUsername)
+			pageErr := err
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync unseen conversations cho page %s: %v", pageId, err)
+				if shouldAbortSync(err) {
+					notifyPageSynced(onPage, pageId, err)
+					return err
+				}
 				// Tiếp tục với bước 2, không dừng
 			}
 
 			// BƯỚC 2: Sync conversations đã đọc mới hơn lastConversationId
 			// Sync conversations đã đọc (seen=true) có updated_at mới hơn lastConversationId
 			log.Printf("[BridgeV2] Page %s - Bước 2: Sync conversations đã đọc mới hơn lastConversationId", pageId)
-			err = bridgeV2_SyncReadConversationsNewerThan(pageId, pageUsername, lastConversationId)
+			err = bridgeV2_SyncReadConversationsNewerThan(ctx, pageId, pageUsername, lastConversationId, checkpointSince)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync read conversations cho page %s: %v", pageId, err)
-				// Tiếp tục với page tiếp theo, không dừng
+				pageErr = err
+			}
+			if hasCheckpoint && pageErr == nil {
+				clearSyncCheckpoint(SyncCheckpointConversations, pageId, checkpointSince)
 			}
+			notifyPageSynced(onPage, pageId, pageErr)
+			if err != nil && shouldAbortSync(err) {
+				return err
+			}
+			// Lỗi khác → tiếp tục với page tiếp theo, không dừng
 		}
 
 		page++
@@ -110,7 +189,7 @@ func BridgeV2_SyncNewData(pageSize int) error {
 // - Conversations unseen ở FolkForm được cập nhật đúng trạng thái từ Pancake
 // - Nếu Pancake đã đánh dấu conversation là seen, FolkForm sẽ được cập nhật là seen
 // - Nếu có lỗi trong lần sync trước, conversation sẽ được sync lại ở lần này
-func bridgeV2_SyncUnseenConversations(pageId string, pageUsername string) error {
+func bridgeV2_SyncUnseenConversations(ctx context.Context, pageId string, pageUsername string) error {
 	log.Printf("[BridgeV2] Bắt đầu sync unseen conversations cho page %s", pageId)
 
 	last_conversation_id := ""
@@ -121,6 +200,9 @@ func bridgeV2_SyncUnseenConversations(pageId string, pageUsername string) error
 	maxBatches := 100 // Giới hạn số batches để tránh vòng lặp vô hạn
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Giới hạn số batches để tránh vòng lặp vô hạn
 		if batchCount >= maxBatches {
 			log.Printf("[BridgeV2] Page %s - Đã đạt giới hạn %d batches, dừng sync unseen conversations", pageId, maxBatches)
@@ -137,6 +219,9 @@ func bridgeV2_SyncUnseenConversations(pageId string, pageUsername string) error
 		resultGetConversations, err := Pancake_GetConversations_v2(pageId, last_conversation_id, 0, 0, "", true)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy unseen conversations: %v", err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -159,6 +244,9 @@ func bridgeV2_SyncUnseenConversations(pageId string, pageUsername string) error
 
 		// Sync từng conversation
 		for _, conv := range conversations {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			convMap, ok := conv.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Conversation không phải là map, bỏ qua")
@@ -193,16 +281,22 @@ func bridgeV2_SyncUnseenConversations(pageId string, pageUsername string) error
 
 			// Sync conversation (upsert - tự động update nếu đã tồn tại)
 			// FolkForm_CreateConversation sẽ cập nhật field "seen" từ Pancake về FolkForm
-			_, err = FolkForm_CreateConversation(pageId, pageUsername, conv)
+			_, err = FolkForm_CreateConversation(ctx, pageId, pageUsername, conv)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi tạo/cập nhật unseen conversation %s: %v", convId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				continue
 			}
 
 			// Sync messages mới
-			err = bridge_SyncMessageOfConversation(pageId, pageUsername, convId, customerId)
+			err = bridge_SyncMessageOfConversation(ctx, pageId, pageUsername, convId, customerId)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync messages cho unseen conversation %s: %v", convId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với conversation tiếp theo, không dừng
 			}
 
@@ -241,14 +335,19 @@ func bridgeV2_SyncUnseenConversations(pageId string, pageUsername string) error
 }
 
 // bridgeV2_SyncReadConversationsNewerThan sync conversations đã đọc mới hơn lastConversationId
-func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string, lastConversationId string) error {
+// sinceUnix > 0 (mốc đặt lại bằng reset_checkpoint): bỏ qua lastConversationId, sync tới khi gặp conversation có updated_at cũ hơn mốc
+func bridgeV2_SyncReadConversationsNewerThan(ctx context.Context, pageId string, pageUsername string, lastConversationId string, sinceUnix int64) error {
 	// Nếu chưa có conversation nào trong FolkForm → không cần sync conversations đã đọc
-	if lastConversationId == "" {
+	if lastConversationId == "" && sinceUnix <= 0 {
 		log.Printf("[BridgeV2] Page %s - Chưa có conversation nào, bỏ qua sync conversations đã đọc", pageId)
 		return nil
 	}
 
-	log.Printf("[BridgeV2] Bắt đầu sync conversations đã đọc mới hơn %s cho page %s", lastConversationId, pageId)
+	if sinceUnix > 0 {
+		log.Printf("[BridgeV2] Bắt đầu sync conversations đã đọc cập nhật từ %s cho page %s (mốc đặt lại)", time.Unix(sinceUnix, 0).Format("2006-01-02 15:04:05"), pageId)
+	} else {
+		log.Printf("[BridgeV2] Bắt đầu sync conversations đã đọc mới hơn %s cho page %s", lastConversationId, pageId)
+	}
 
 	last_conversation_id := ""
 	rateLimiter := apputility.GetPancakeRateLimiter()
@@ -256,6 +355,9 @@ func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string,
 	batchCount := 0
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Áp dụng Rate Limiter: Gọi Wait() trước mỗi API call
 		rateLimiter.Wait()
 
@@ -265,6 +367,9 @@ func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string,
 		resultGetConversations, err := Pancake_GetConversations_v2(pageId, last_conversation_id, 0, 0, "updated_at", false)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy read conversations: %v", err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -286,6 +391,9 @@ func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string,
 
 		// Sync từng conversation
 		for _, conv := range conversations {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			convMap, ok := conv.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Conversation không phải là map, bỏ qua")
@@ -298,8 +406,15 @@ func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string,
 				continue
 			}
 
-			// Kiểm tra: Đã gặp conversation cuối cùng chưa?
-			if convId == lastConversationId {
+			// Kiểm tra: Đã gặp conversation cuối cùng chưa? (mốc đặt lại → so updated_at với mốc)
+			if sinceUnix > 0 {
+				updatedAtStr, _ := convMap["updated_at"].(string)
+				if updatedAt, err := parseCustomerUpdatedAt(updatedAtStr); err == nil && updatedAt < sinceUnix {
+					foundLastConversation = true
+					log.Printf("[BridgeV2] Page %s - Gặp conversation cập nhật trước mốc (%d < %d), dừng sync read conversations", pageId, updatedAt, sinceUnix)
+					break
+				}
+			} else if convId == lastConversationId {
 				foundLastConversation = true
 				log.Printf("[BridgeV2] Page %s - Đã gặp lastConversationId (%s), dừng sync read conversations", pageId, lastConversationId)
 				break
@@ -320,16 +435,22 @@ func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string,
 			}
 
 			// Sync conversation
-			_, err = FolkForm_CreateConversation(pageId, pageUsername, conv)
+			_, err = FolkForm_CreateConversation(ctx, pageId, pageUsername, conv)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi tạo/cập nhật read conversation %s: %v", convId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				continue
 			}
 
 			// Sync messages mới
-			err = bridge_SyncMessageOfConversation(pageId, pageUsername, convId, customerId)
+			err = bridge_SyncMessageOfConversation(ctx, pageId, pageUsername, convId, customerId)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync messages cho read conversation %s: %v", convId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với conversation tiếp theo, không dừng
 			}
 
@@ -341,8 +462,8 @@ func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string,
 			break // Dừng pagination cho page này
 		}
 
-		// Nếu không có read conversation nào trong batch này → dừng
-		if batchReadCount == 0 && len(conversations) > 0 {
+		// Nếu không có read conversation nào trong batch này → dừng (mốc đặt lại thì chỉ dừng khi gặp conversation cũ hơn mốc)
+		if batchReadCount == 0 && len(conversations) > 0 && sinceUnix <= 0 {
 			// Có thể đã gặp hết conversations đã đọc mới hơn lastConversationId
 			log.Printf("[BridgeV2] Page %s - Không còn read conversations mới hơn lastConversationId (tổng %d read conversations đã sync)", pageId, readCount)
 			break
@@ -373,7 +494,7 @@ func bridgeV2_SyncReadConversationsNewerThan(pageId string, pageUsername string,
 //   - pageId: ID của page
 //   - pageUsername: Username của page
 //   - pageSize: Số lượng conversations lấy mỗi lần (mặc định 50 nếu <= 0)
-func bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId string, pageUsername string, pageSize int) error {
+func bridgeV2_VerifyUnseenConversationsFromFolkForm(ctx context.Context, pageId string, pageUsername string, pageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu verify unseen conversations từ FolkForm cho page %s", pageId)
 
 	// Lấy danh sách conversations unseen từ FolkForm với filter MongoDB
@@ -390,10 +511,16 @@ func bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId string, pageUsername
 	updatedCount := 0 // Đếm số conversations đã được cập nhật từ unseen → seen
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy conversations unseen từ FolkForm với filter (panCakeData.seen = false)
-		result, err := FolkForm_GetUnseenConversationsWithPageId(page, limit, pageId)
+		result, err := FolkForm_GetUnseenConversationsWithPageId(ctx, page, limit, pageId)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy conversations unseen từ FolkForm: %v", err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -420,6 +547,9 @@ func bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId string, pageUsername
 
 		// Lấy conversationId từ mỗi item (tất cả đã là unseen)
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			itemMap, ok := item.(map[string]interface{})
 			if !ok {
 				continue
@@ -465,6 +595,9 @@ func bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId string, pageUsername
 			resultGetConversations, err := Pancake_GetConversations_v2(pageId, last_conversation_id, 0, 0, "updated_at", false)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi lấy conversations từ Pancake để verify: %v", err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				break
 			}
 
@@ -481,6 +614,9 @@ func bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId string, pageUsername
 
 			// Kiểm tra từng conversation từ Pancake
 			for _, conv := range conversations {
+				if err := syncCancelled(ctx); err != nil {
+					return err
+				}
 				convMap, ok := conv.(map[string]interface{})
 				if !ok {
 					continue
@@ -499,7 +635,7 @@ func bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId string, pageUsername
 						log.Printf("[BridgeV2] Page %s - Conversation %s đang unseen ở FolkForm nhưng đã seen ở Pancake, đang cập nhật...", pageId, convId)
 
 						// Sync conversation từ Pancake về FolkForm (sẽ cập nhật seen=true)
-						_, err = FolkForm_CreateConversation(pageId, pageUsername, conv)
+						_, err = FolkForm_CreateConversation(ctx, pageId, pageUsername, conv)
 						if err != nil {
 							logError("[BridgeV2] Lỗi khi cập nhật conversation %s từ unseen → seen: %v", convId, err)
 						} else {
@@ -559,7 +695,7 @@ func bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId string, pageUsername
 // Logic: Verify conversations unseen và đã đọc từ FolkForm với Pancake để đảm bảo trạng thái đồng bộ
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 50 nếu <= 0)
-func BridgeV2_VerifyConversations(pageSize int) error {
+func BridgeV2_VerifyConversations(ctx context.Context, pageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu verify conversations từ FolkForm với Pancake")
 
 	// Lấy tất cả pages từ FolkForm
@@ -571,11 +707,14 @@ func BridgeV2_VerifyConversations(pageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -594,6 +733,9 @@ func BridgeV2_VerifyConversations(pageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -619,9 +761,12 @@ func BridgeV2_VerifyConversations(pageSize int) error {
 			log.Printf("[BridgeV2] Page %s - Bước 1: Verify unseen conversations từ FolkForm với Pancake", pageId)
 			// Sử dụng pageSize cho conversations (có thể khác với pageSize cho pages)
 			conversationPageSize := pageSize // Có thể tách riêng nếu cần
-			err = bridgeV2_VerifyUnseenConversationsFromFolkForm(pageId, pageUsername, conversationPageSize)
+			err = bridgeV2_VerifyUnseenConversationsFromFolkForm(ctx, pageId, pageUsername, conversationPageSize)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi verify unseen conversations cho page %s: %v", pageId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với page tiếp theo, không dừng
 			}
 
@@ -640,7 +785,12 @@ func BridgeV2_VerifyConversations(pageSize int) error {
 // Sử dụng order_by=updated_at và bắt đầu từ oldestConversationId từ FolkForm
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 50 nếu <= 0)
-func BridgeV2_SyncAllData(pageSize int) error {
+func BridgeV2_SyncAllData(ctx context.Context, pageSize int) error {
+	return BridgeV2_SyncAllDataForPages(ctx, pageSize, nil)
+}
+
+// BridgeV2_SyncAllDataForPages giống BridgeV2_SyncAllData nhưng chỉ sync các page trong pageIds (rỗng = tất cả pages)
+func BridgeV2_SyncAllDataForPages(ctx context.Context, pageSize int, pageIds []string) error {
 	log.Println("[BridgeV2] Bắt đầu sync tất cả conversations (full sync)")
 
 	// Lấy tất cả pages từ FolkForm
@@ -652,11 +802,14 @@ func BridgeV2_SyncAllData(pageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -675,6 +828,9 @@ func BridgeV2_SyncAllData(pageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -695,10 +851,17 @@ func BridgeV2_SyncAllData(pageSize int) error {
 				continue
 			}
 
+			if !pageInScope(pageIds, pageId) {
+				continue
+			}
+
 			// Lấy conversation cũ nhất từ FolkForm
-			oldestConversationId, err := FolkForm_GetOldestConversationId(pageId)
+			oldestConversationId, err := FolkForm_GetOldestConversationId(ctx, pageId)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi lấy oldestConversationId cho page %s: %v", pageId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				continue
 			}
 
@@ -718,14 +881,20 @@ func BridgeV2_SyncAllData(pageSize int) error {
 			const REFRESH_OLDEST_AFTER_BATCHES = 10 // Lấy lại oldestConversationId sau mỗi 10 batches
 
 			for {
+				if err := syncCancelled(ctx); err != nil {
+					return err
+				}
 				// Áp dụng Rate Limiter: Gọi Wait() trước mỗi API call
 				rateLimiter.Wait()
 
 				// Lấy lại oldestConversationId sau mỗi N batches để cập nhật mốc
 				if batchCount > 0 && batchCount%REFRESH_OLDEST_AFTER_BATCHES == 0 {
-					newOldestConversationId, err := FolkForm_GetOldestConversationId(pageId)
+					newOldestConversationId, err := FolkForm_GetOldestConversationId(ctx, pageId)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi lấy lại oldestConversationId cho page %s: %v", pageId, err)
+						if shouldAbortSync(err) {
+							return err
+						}
 						// Tiếp tục với oldestConversationId cũ
 					} else if newOldestConversationId != "" && newOldestConversationId != oldestConversationId {
 						log.Printf("[BridgeV2] Page %s - Cập nhật oldestConversationId: %s -> %s (đã sync %d conversations)", pageId, oldestConversationId, newOldestConversationId, conversationCount)
@@ -743,6 +912,9 @@ func BridgeV2_SyncAllData(pageSize int) error {
 				resultGetConversations, err := Pancake_GetConversations_v2(pageId, last_conversation_id, 0, 0, "updated_at", false)
 				if err != nil {
 					logError("[BridgeV2] Lỗi khi lấy danh sách hội thoại: %v", err)
+					if shouldAbortSync(err) {
+						return err
+					}
 					break
 				}
 
@@ -761,6 +933,9 @@ func BridgeV2_SyncAllData(pageSize int) error {
 
 				// Sync từng conversation
 				for _, conv := range conversations {
+					if err := syncCancelled(ctx); err != nil {
+						return err
+					}
 					conversationCount++
 					convMap, ok := conv.(map[string]interface{})
 					if !ok {
@@ -780,18 +955,24 @@ func BridgeV2_SyncAllData(pageSize int) error {
 					}
 
 					// Sync conversation
-					_, err = FolkForm_CreateConversation(pageId, pageUsername, conv)
+					_, err = FolkForm_CreateConversation(ctx, pageId, pageUsername, conv)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi tạo/cập nhật conversation %s: %v", convId, err)
+						if shouldAbortSync(err) {
+							return err
+						}
 						continue
 					}
 
 					// Sync TẤT CẢ messages
 					// Lưu ý: bridge_SyncMessageOfConversation đã có rate limiter bên trong
 					// Và đã có logic để sync tất cả messages (không chỉ mới)
-					err = bridge_SyncMessageOfConversation(pageId, pageUsername, convId, customerId)
+					err = bridge_SyncMessageOfConversation(ctx, pageId, pageUsername, convId, customerId)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi sync messages cho conversation %s: %v", convId, err)
+						if shouldAbortSync(err) {
+							return err
+						}
 						// Tiếp tục với conversation tiếp theo, không dừng
 					}
 				}
@@ -843,7 +1024,13 @@ func parsePostInsertedAt(insertedAtStr string) (int64, error) {
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 50 nếu <= 0)
 //   - postPageSize: Số lượng posts lấy mỗi lần (mặc định 30 nếu <= 0)
-func BridgeV2_SyncNewPosts(pageSize int, postPageSize int) error {
+func BridgeV2_SyncNewPosts(ctx context.Context, pageSize int, postPageSize int) error {
+	return BridgeV2_SyncNewPostsForPages(ctx, pageSize, postPageSize, nil, nil)
+}
+
+// BridgeV2_SyncNewPostsForPages giống BridgeV2_SyncNewPosts nhưng chỉ sync các page trong pageIds (rỗng = tất cả pages)
+// onPage (có thể nil) được gọi sau mỗi page đã sync, err là lỗi của page đó (nil nếu thành công)
+func BridgeV2_SyncNewPostsForPages(ctx context.Context, pageSize int, postPageSize int, pageIds []string, onPage func(pageId string, err error)) error {
 	log.Println("[BridgeV2] Bắt đầu sync posts mới (incremental sync)")
 
 	// Lấy tất cả pages từ FolkForm
@@ -859,11 +1046,14 @@ func BridgeV2_SyncNewPosts(pageSize int, postPageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response
@@ -882,6 +1072,9 @@ func BridgeV2_SyncNewPosts(pageSize int, postPageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -902,8 +1095,13 @@ func BridgeV2_SyncNewPosts(pageSize int, postPageSize int) error {
 				continue
 			}
 
+			if !pageInScope(pageIds, pageId) {
+				continue
+			}
+
 			// Sync posts mới cho page này (sử dụng postPageSize từ config)
-			err = bridgeV2_SyncNewPostsOfPage(pageId, pageUsername, postPageSize)
+			err = bridgeV2_SyncNewPostsOfPage(ctx, pageId, pageUsername, postPageSize)
+			notifyPageSynced(onPage, pageId, err)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync posts mới cho page %s: %v", pageId, err)
 				// Tiếp tục với page tiếp theo
@@ -923,11 +1121,11 @@ func BridgeV2_SyncNewPosts(pageSize int, postPageSize int) error {
 //   - pageId: ID của page
 //   - pageUsername: Username của page
 //   - postPageSize: Số lượng posts lấy mỗi lần (mặc định 30 nếu <= 0)
-func bridgeV2_SyncNewPostsOfPage(pageId string, pageUsername string, postPageSize int) error {
+func bridgeV2_SyncNewPostsOfPage(ctx context.Context, pageId string, pageUsername string, postPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu sync posts mới cho page %s", pageId)
 
 	// 1. Lấy mốc từ FolkForm
-	_, lastInsertedAtMs, err := FolkForm_GetLastPostId(pageId)
+	_, lastInsertedAtMs, err := FolkForm_GetLastPostId(ctx, pageId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy lastPostId cho page %s: %v", pageId, err)
 		return err
@@ -946,6 +1144,13 @@ func bridgeV2_SyncNewPostsOfPage(pageId string, pageUsername string, postPageSiz
 		log.Printf("[BridgeV2] Page %s - Sync posts từ %d đến %d", pageId, since, until)
 	}
 
+	// Mốc do server đặt lại (command reset_checkpoint) thay cho mốc lấy từ FolkForm
+	checkpointSince, hasCheckpoint := syncCheckpointOverride(SyncCheckpointPosts, pageId)
+	if hasCheckpoint {
+		since = checkpointSince
+		log.Printf("[BridgeV2] Page %s - Dùng mốc đặt lại: sync posts từ %d", pageId, since)
+	}
+
 	// 3. Pagination loop
 	pageNumber := 1
 	// Sử dụng postPageSize từ config, mặc định 30 nếu không có
@@ -956,6 +1161,9 @@ func bridgeV2_SyncNewPostsOfPage(pageId string, pageUsername string, postPageSiz
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Rate limiter
 		rateLimiter.Wait()
 
@@ -963,6 +1171,9 @@ func bridgeV2_SyncNewPostsOfPage(pageId string, pageUsername string, postPageSiz
 		result, err := Pancake_GetPosts(pageId, pageNumber, pageSize, since, until, "")
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy posts cho page %s: %v", pageId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -1005,9 +1216,12 @@ func bridgeV2_SyncNewPostsOfPage(pageId string, pageUsername string, postPageSiz
 			}
 
 			// ✅ Upsert post (tự động xử lý duplicate theo postId)
-			_, err = FolkForm_CreateFbPost(post)
+			_, err = FolkForm_CreateFbPost(ctx, post)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert post: %v", err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với post tiếp theo
 			}
 		}
@@ -1027,6 +1241,9 @@ func bridgeV2_SyncNewPostsOfPage(pageId string, pageUsername string, postPageSiz
 		pageNumber++
 	}
 
+	if hasCheckpoint {
+		clearSyncCheckpoint(SyncCheckpointPosts, pageId, checkpointSince)
+	}
 	log.Printf("[BridgeV2] ✅ Hoàn thành sync posts mới cho page %s", pageId)
 	return nil
 }
@@ -1035,7 +1252,12 @@ func bridgeV2_SyncNewPostsOfPage(pageId string, pageUsername string, postPageSiz
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 50 nếu <= 0)
 //   - postPageSize: Số lượng posts lấy mỗi lần (mặc định 30 nếu <= 0)
-func BridgeV2_SyncAllPosts(pageSize int, postPageSize int) error {
+func BridgeV2_SyncAllPosts(ctx context.Context, pageSize int, postPageSize int) error {
+	return BridgeV2_SyncAllPostsForPages(ctx, pageSize, postPageSize, nil)
+}
+
+// BridgeV2_SyncAllPostsForPages giống BridgeV2_SyncAllPosts nhưng chỉ sync các page trong pageIds (rỗng = tất cả pages)
+func BridgeV2_SyncAllPostsForPages(ctx context.Context, pageSize int, postPageSize int, pageIds []string) error {
 	log.Println("[BridgeV2] Bắt đầu sync posts cũ (backfill sync)")
 
 	// Lấy tất cả pages từ FolkForm
@@ -1051,11 +1273,14 @@ func BridgeV2_SyncAllPosts(pageSize int, postPageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response
@@ -1074,6 +1299,9 @@ func BridgeV2_SyncAllPosts(pageSize int, postPageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -1094,8 +1322,12 @@ func BridgeV2_SyncAllPosts(pageSize int, postPageSize int) error {
 				continue
 			}
 
+			if !pageInScope(pageIds, pageId) {
+				continue
+			}
+
 			// Sync posts cũ cho page này (sử dụng postPageSize từ config)
-			err = bridgeV2_SyncAllPostsOfPage(pageId, pageUsername, postPageSize)
+			err = bridgeV2_SyncAllPostsOfPage(ctx, pageId, pageUsername, postPageSize)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync posts cũ cho page %s: %v", pageId, err)
 				// Tiếp tục với page tiếp theo
@@ -1115,11 +1347,11 @@ func BridgeV2_SyncAllPosts(pageSize int, postPageSize int) error {
 //   - pageId: ID của page
 //   - pageUsername: Username của page
 //   - postPageSize: Số lượng posts lấy mỗi lần (mặc định 30 nếu <= 0)
-func bridgeV2_SyncAllPostsOfPage(pageId string, pageUsername string, postPageSize int) error {
+func bridgeV2_SyncAllPostsOfPage(ctx context.Context, pageId string, pageUsername string, postPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu sync posts cũ cho page %s", pageId)
 
 	// 1. Lấy mốc từ FolkForm
-	_, oldestInsertedAtMs, err := FolkForm_GetOldestPostId(pageId)
+	_, oldestInsertedAtMs, err := FolkForm_GetOldestPostId(ctx, pageId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy oldestPostId cho page %s: %v", pageId, err)
 		return err
@@ -1150,9 +1382,12 @@ func bridgeV2_SyncAllPostsOfPage(pageId string, pageUsername string, postPageSiz
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Refresh oldestPostId sau mỗi N batches
 		if batchCount > 0 && batchCount%REFRESH_OLDEST_AFTER_BATCHES == 0 {
-			_, newOldestMs, _ := FolkForm_GetOldestPostId(pageId)
+			_, newOldestMs, _ := FolkForm_GetOldestPostId(ctx, pageId)
 			newOldestSeconds := newOldestMs / 1000
 			if newOldestSeconds > 0 && newOldestSeconds < until {
 				// Có post cũ hơn → cập nhật until
@@ -1171,6 +1406,9 @@ func bridgeV2_SyncAllPostsOfPage(pageId string, pageUsername string, postPageSiz
 		result, err := Pancake_GetPosts(pageId, pageNumber, pageSize, since, until, "")
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy posts cho page %s: %v", pageId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -1210,7 +1448,7 @@ func bridgeV2_SyncAllPostsOfPage(pageId string, pageUsername string, postPageSiz
 			}
 
 			// ✅ Upsert post (tự động xử lý duplicate)
-			_, err = FolkForm_CreateFbPost(post)
+			_, err = FolkForm_CreateFbPost(ctx, post)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert post: %v", err)
 			}
@@ -1258,7 +1496,7 @@ func parseCustomerUpdatedAt(updatedAtStr string) (int64, error) {
 // BridgeV2_SyncNewCustomers sync customers đã cập nhật gần đây (incremental sync) cho tất cả pages
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 50 nếu <= 0)
-func BridgeV2_SyncNewCustomers(pageSize int) error {
+func BridgeV2_SyncNewCustomers(ctx context.Context, pageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu sync customers đã cập nhật gần đây (incremental sync)")
 
 	// Lấy tất cả pages từ FolkForm
@@ -1270,11 +1508,14 @@ func BridgeV2_SyncNewCustomers(pageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -1293,6 +1534,9 @@ func BridgeV2_SyncNewCustomers(pageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -1314,9 +1558,12 @@ func BridgeV2_SyncNewCustomers(pageSize int) error {
 
 			// Sync customers mới cho page này (sử dụng pageSize cho customers)
 			customerPageSize := 50 // Có thể được truyền từ config nếu cần, hiện tại dùng default
-			err = bridgeV2_SyncNewCustomersOfPage(pageId, customerPageSize)
+			err = bridgeV2_SyncNewCustomersOfPage(ctx, pageId, customerPageSize)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync customers mới cho page %s: %v", pageId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với page tiếp theo, không dừng toàn bộ job
 			}
 		}
@@ -1337,11 +1584,11 @@ func BridgeV2_SyncNewCustomers(pageSize int) error {
 // Tham số:
 //   - pageId: ID của page
 //   - customerPageSize: Số lượng customers lấy mỗi lần (mặc định 50 nếu <= 0)
-func bridgeV2_SyncNewCustomersOfPage(pageId string, customerPageSize int) error {
+func bridgeV2_SyncNewCustomersOfPage(ctx context.Context, pageId string, customerPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu sync customers đã cập nhật gần đây cho page %s", pageId)
 
 	// 1. Lấy mốc từ FolkForm (FB customer collection)
-	lastUpdatedAt, err := FolkForm_GetLastFbCustomerUpdatedAt(pageId)
+	lastUpdatedAt, err := FolkForm_GetLastFbCustomerUpdatedAt(ctx, pageId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy lastUpdatedAt cho page %s: %v", pageId, err)
 		return err
@@ -1370,6 +1617,9 @@ func bridgeV2_SyncNewCustomersOfPage(pageId string, customerPageSize int) error
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Rate limiter
 		rateLimiter.Wait()
 
@@ -1377,6 +1627,9 @@ func bridgeV2_SyncNewCustomersOfPage(pageId string, customerPageSize int) error
 		result, err := Pancake_GetCustomers(pageId, pageNumber, pageSize, since, until, "updated_at")
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy customers cho page %s: %v", pageId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -1424,9 +1677,12 @@ func bridgeV2_SyncNewCustomersOfPage(pageId string, customerPageSize int) error
 			}
 
 			// ✅ Upsert FB customer (tự động xử lý duplicate theo customerId)
-			_, err = FolkForm_UpsertFbCustomer(customer)
+			_, err = FolkForm_UpsertFbCustomer(ctx, customer)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert FB customer: %v", err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với customer tiếp theo
 			}
 		}
@@ -1453,7 +1709,7 @@ func bridgeV2_SyncNewCustomersOfPage(pageId string, customerPageSize int) error
 // BridgeV2_SyncAllCustomers sync customers cập nhật cũ (backfill sync) cho tất cả pages
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 50 nếu <= 0)
-func BridgeV2_SyncAllCustomers(pageSize int) error {
+func BridgeV2_SyncAllCustomers(ctx context.Context, pageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu sync customers cập nhật cũ (backfill sync)")
 
 	// Lấy tất cả pages từ FolkForm
@@ -1465,11 +1721,14 @@ func BridgeV2_SyncAllCustomers(pageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -1488,6 +1747,9 @@ func BridgeV2_SyncAllCustomers(pageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -1509,9 +1771,12 @@ func BridgeV2_SyncAllCustomers(pageSize int) error {
 
 			// Sync customers cũ cho page này (sử dụng pageSize cho customers)
 			customerPageSize := 30 // Có thể được truyền từ config nếu cần, hiện tại dùng default
-			err = bridgeV2_SyncAllCustomersOfPage(pageId, customerPageSize)
+			err = bridgeV2_SyncAllCustomersOfPage(ctx, pageId, customerPageSize)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi sync customers cũ cho page %s: %v", pageId, err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với page tiếp theo, không dừng toàn bộ job
 			}
 		}
@@ -1532,11 +1797,11 @@ func BridgeV2_SyncAllCustomers(pageSize int) error {
 // Tham số:
 //   - pageId: ID của page
 //   - customerPageSize: Số lượng customers lấy mỗi lần (mặc định 30 nếu <= 0)
-func bridgeV2_SyncAllCustomersOfPage(pageId string, customerPageSize int) error {
+func bridgeV2_SyncAllCustomersOfPage(ctx context.Context, pageId string, customerPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu sync customers cập nhật cũ cho page %s", pageId)
 
 	// 1. Lấy mốc từ FolkForm (FB customer collection)
-	oldestUpdatedAt, err := FolkForm_GetOldestFbCustomerUpdatedAt(pageId)
+	oldestUpdatedAt, err := FolkForm_GetOldestFbCustomerUpdatedAt(ctx, pageId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy oldestUpdatedAt cho page %s: %v", pageId, err)
 		return err
@@ -1567,9 +1832,12 @@ func bridgeV2_SyncAllCustomersOfPage(pageId string, customerPageSize int) error
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Refresh oldestUpdatedAt sau mỗi N batches
 		if batchCount > 0 && batchCount%REFRESH_OLDEST_AFTER_BATCHES == 0 {
-			newOldest, _ := FolkForm_GetOldestFbCustomerUpdatedAt(pageId)
+			newOldest, _ := FolkForm_GetOldestFbCustomerUpdatedAt(ctx, pageId)
 			if newOldest > 0 && newOldest < until {
 				// Có customer cũ hơn → cập nhật until
 				log.Printf("[BridgeV2] Page %s - Cập nhật until: %d -> %d (có customer cũ hơn)", pageId, until, newOldest)
@@ -1587,6 +1855,9 @@ func bridgeV2_SyncAllCustomersOfPage(pageId string, customerPageSize int) error
 		result, err := Pancake_GetCustomers(pageId, pageNumber, pageSize, since, until, "updated_at")
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy customers cho page %s: %v", pageId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -1633,9 +1904,12 @@ func bridgeV2_SyncAllCustomersOfPage(pageId string, customerPageSize int) error
 			}
 
 			// ✅ Upsert FB customer (tự động xử lý duplicate theo customerId)
-			_, err = FolkForm_UpsertFbCustomer(customer)
+			_, err = FolkForm_UpsertFbCustomer(ctx, customer)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert FB customer: %v", err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với customer tiếp theo
 			}
 		}
@@ -1664,7 +1938,7 @@ func bridgeV2_SyncAllCustomersOfPage(pageId string, customerPageSize int) error
 // Tham số:
 //   - pageSize: Số lượng access tokens/pages lấy mỗi lần (mặc định 50 nếu <= 0)
 //   - customerPageSize: Số lượng customers lấy mỗi lần (mặc định 50 nếu <= 0)
-func BridgeV2_SyncNewCustomersFromPos(pageSize int, customerPageSize int) error {
+func BridgeV2_SyncNewCustomersFromPos(ctx context.Context, pageSize int, customerPageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu sync customers mới từ POS (incremental sync)")
 
 	// Sử dụng pageSize từ config, mặc định 50 nếu không có
@@ -1682,14 +1956,17 @@ func BridgeV2_SyncNewCustomersFromPos(pageSize int, customerPageSize int) error
 	limit := pageSize
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Dừng nửa giây trước khi tiếp tục
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách access token với filter system: "Pancake POS"
-		accessTokens, err := FolkForm_GetAccessTokens(page, limit, filter)
+		accessTokens, err := FolkForm_GetAccessTokens(ctx, page, limit, filter)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách access token: %v", err)
-			return errors.New("Lỗi khi lấy danh sách access token")
+			return fmt.Errorf("Lỗi khi lấy danh sách access token: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -1703,6 +1980,9 @@ func BridgeV2_SyncNewCustomersFromPos(pageSize int, customerPageSize int) error
 		if itemCount > 0 && len(items) > 0 {
 			// Với mỗi token
 			for _, item := range items {
+				if err := syncCancelled(ctx); err != nil {
+					return err
+				}
 				// Dừng nửa giây trước khi tiếp tục
 				time.Sleep(100 * time.Millisecond)
 
@@ -1720,17 +2000,23 @@ func BridgeV2_SyncNewCustomersFromPos(pageSize int, customerPageSize int) error
 					continue
 				}
 
-				log.Printf("[BridgeV2] Đang đồng bộ customers mới với API key (system: Pancake POS, length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đang đồng bộ customers mới với API key (system: Pancake POS, fp: %s)", secrets.Fingerprint(apiKey))
 
 				// 1. Lấy danh sách shops
 				shops, err := PancakePos_GetShops(apiKey)
 				if err != nil {
 					logError("[BridgeV2] Lỗi khi lấy danh sách shops: %v", err)
+					if shouldAbortSync(err) {
+						return err
+					}
 					continue
 				}
 
 				// 2. Với mỗi shop
 				for _, shop := range shops {
+					if err := syncCancelled(ctx); err != nil {
+						return err
+					}
 					// Dừng nửa giây trước khi tiếp tục
 					time.Sleep(100 * time.Millisecond)
 
@@ -1760,7 +2046,7 @@ func BridgeV2_SyncNewCustomersFromPos(pageSize int, customerPageSize int) error
 					}
 
 					// 3. Đồng bộ customers mới cho shop này (sử dụng customerPageSize từ config)
-					err = bridgeV2_SyncNewCustomersFromPosForShop(apiKey, shopId, customerPageSize)
+					err = bridgeV2_SyncNewCustomersFromPosForShop(ctx, apiKey, shopId, customerPageSize)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi đồng bộ customers mới cho shop %d: %v", shopId, err)
 						// Tiếp tục với shop tiếp theo
@@ -1768,7 +2054,7 @@ func BridgeV2_SyncNewCustomersFromPos(pageSize int, customerPageSize int) error
 					}
 				}
 
-				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ customers mới cho API key (length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ customers mới cho API key (fp: %s)", secrets.Fingerprint(apiKey))
 			}
 		} else {
 			log.Println("[BridgeV2] Không còn access token nào. Kết thúc.")
@@ -1792,13 +2078,13 @@ func BridgeV2_SyncNewCustomersFromPos(pageSize int, customerPageSize int) error
 //   - apiKey: API key của Pancake POS
 //   - shopId: ID của shop
 //   - customerPageSize: Số lượng customers lấy mỗi lần (mặc định 50 nếu <= 0)
-func bridgeV2_SyncNewCustomersFromPosForShop(apiKey string, shopId int, customerPageSize int) error {
+func bridgeV2_SyncNewCustomersFromPosForShop(ctx context.Context, apiKey string, shopId int, customerPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu đồng bộ customers mới từ POS cho shop %d (incremental sync)", shopId)
 
 	// 1. Lấy mốc từ FolkForm
 	// Filter: customers có posCustomerId (từ POS) và thuộc shop này
 	// Sort theo updatedAt desc, limit 1 → lấy customer mới nhất
-	lastUpdatedAt, err := FolkForm_GetLastPosCustomerUpdatedAt(shopId)
+	lastUpdatedAt, err := FolkForm_GetLastPosCustomerUpdatedAt(ctx, shopId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy lastUpdatedAt cho shop %d: %v", shopId, err)
 		return err
@@ -1827,6 +2113,9 @@ func bridgeV2_SyncNewCustomersFromPosForShop(apiKey string, shopId int, customer
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Rate limiter
 		rateLimiter.Wait()
 
@@ -1834,6 +2123,9 @@ func bridgeV2_SyncNewCustomersFromPosForShop(apiKey string, shopId int, customer
 		customers, err := PancakePos_GetCustomers(apiKey, shopId, pageNumber, pageSize, startTime, endTime)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy customers cho shop %d: %v", shopId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -1882,7 +2174,7 @@ func bridgeV2_SyncNewCustomersFromPosForShop(apiKey string, shopId int, customer
 			}
 
 			// ✅ Upsert customer từ POS (tự động xử lý duplicate theo posCustomerId hoặc phone/email)
-			_, err = FolkForm_UpsertCustomerFromPos(customerMap)
+			_, err = FolkForm_UpsertCustomerFromPos(ctx, customerMap)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert customer từ POS: %v", err)
 				// Tiếp tục với customer tiếp theo
@@ -1913,7 +2205,7 @@ func bridgeV2_SyncNewCustomersFromPosForShop(apiKey string, shopId int, customer
 // Tham số:
 //   - pageSize: Số lượng access tokens/pages lấy mỗi lần (mặc định 50 nếu <= 0)
 //   - customerPageSize: Số lượng customers lấy mỗi lần (mặc định 30 nếu <= 0)
-func BridgeV2_SyncAllCustomersFromPos(pageSize int, customerPageSize int) error {
+func BridgeV2_SyncAllCustomersFromPos(ctx context.Context, pageSize int, customerPageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu sync customers cũ từ POS (backfill sync)")
 
 	// Sử dụng pageSize từ config, mặc định 50 nếu không có
@@ -1931,14 +2223,17 @@ func BridgeV2_SyncAllCustomersFromPos(pageSize int, customerPageSize int) error
 	limit := pageSize
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Dừng nửa giây trước khi tiếp tục
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách access token với filter system: "Pancake POS"
-		accessTokens, err := FolkForm_GetAccessTokens(page, limit, filter)
+		accessTokens, err := FolkForm_GetAccessTokens(ctx, page, limit, filter)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách access token: %v", err)
-			return errors.New("Lỗi khi lấy danh sách access token")
+			return fmt.Errorf("Lỗi khi lấy danh sách access token: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -1952,6 +2247,9 @@ func BridgeV2_SyncAllCustomersFromPos(pageSize int, customerPageSize int) error
 		if itemCount > 0 && len(items) > 0 {
 			// Với mỗi token
 			for _, item := range items {
+				if err := syncCancelled(ctx); err != nil {
+					return err
+				}
 				// Dừng nửa giây trước khi tiếp tục
 				time.Sleep(100 * time.Millisecond)
 
@@ -1969,17 +2267,23 @@ func BridgeV2_SyncAllCustomersFromPos(pageSize int, customerPageSize int) error
 					continue
 				}
 
-				log.Printf("[BridgeV2] Đang đồng bộ customers cũ với API key (system: Pancake POS, length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đang đồng bộ customers cũ với API key (system: Pancake POS, fp: %s)", secrets.Fingerprint(apiKey))
 
 				// 1. Lấy danh sách shops
 				shops, err := PancakePos_GetShops(apiKey)
 				if err != nil {
 					logError("[BridgeV2] Lỗi khi lấy danh sách shops: %v", err)
+					if shouldAbortSync(err) {
+						return err
+					}
 					continue
 				}
 
 				// 2. Với mỗi shop
 				for _, shop := range shops {
+					if err := syncCancelled(ctx); err != nil {
+						return err
+					}
 					// Dừng nửa giây trước khi tiếp tục
 					time.Sleep(100 * time.Millisecond)
 
@@ -2009,7 +2313,7 @@ func BridgeV2_SyncAllCustomersFromPos(pageSize int, customerPageSize int) error
 					}
 
 					// 3. Đồng bộ customers cũ cho shop này (sử dụng customerPageSize từ config)
-					err = bridgeV2_SyncAllCustomersFromPosForShop(apiKey, shopId, customerPageSize)
+					err = bridgeV2_SyncAllCustomersFromPosForShop(ctx, apiKey, shopId, customerPageSize)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi đồng bộ customers cũ cho shop %d: %v", shopId, err)
 						// Tiếp tục với shop tiếp theo
@@ -2017,7 +2321,7 @@ func BridgeV2_SyncAllCustomersFromPos(pageSize int, customerPageSize int) error
 					}
 				}
 
-				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ customers cũ cho API key (length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ customers cũ cho API key (fp: %s)", secrets.Fingerprint(apiKey))
 			}
 		} else {
 			log.Println("[BridgeV2] Không còn access token nào. Kết thúc.")
@@ -2041,13 +2345,13 @@ func BridgeV2_SyncAllCustomersFromPos(pageSize int, customerPageSize int) error
 //   - apiKey: API key của Pancake POS
 //   - shopId: ID của shop
 //   - customerPageSize: Số lượng customers lấy mỗi lần (mặc định 30 nếu <= 0)
-func bridgeV2_SyncAllCustomersFromPosForShop(apiKey string, shopId int, customerPageSize int) error {
+func bridgeV2_SyncAllCustomersFromPosForShop(ctx context.Context, apiKey string, shopId int, customerPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu đồng bộ customers cũ từ POS cho shop %d (backfill sync)", shopId)
 
 	// 1. Lấy mốc từ FolkForm
 	// Filter: customers có posCustomerId (từ POS) và thuộc shop này
 	// Sort theo updatedAt asc, limit 1 → lấy customer cũ nhất
-	oldestUpdatedAt, err := FolkForm_GetOldestPosCustomerUpdatedAt(shopId)
+	oldestUpdatedAt, err := FolkForm_GetOldestPosCustomerUpdatedAt(ctx, shopId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy oldestUpdatedAt cho shop %d: %v", shopId, err)
 		return err
@@ -2078,9 +2382,12 @@ func bridgeV2_SyncAllCustomersFromPosForShop(apiKey string, shopId int, customer
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Refresh oldestUpdatedAt sau mỗi N batches
 		if batchCount > 0 && batchCount%REFRESH_OLDEST_AFTER_BATCHES == 0 {
-			newOldest, _ := FolkForm_GetOldestPosCustomerUpdatedAt(shopId)
+			newOldest, _ := FolkForm_GetOldestPosCustomerUpdatedAt(ctx, shopId)
 			if newOldest > 0 && newOldest < endTime {
 				// Có customer cũ hơn → cập nhật endTime
 				log.Printf("[BridgeV2] Shop %d - Cập nhật endTime: %d -> %d (có customer cũ hơn)", shopId, endTime, newOldest)
@@ -2098,6 +2405,9 @@ func bridgeV2_SyncAllCustomersFromPosForShop(apiKey string, shopId int, customer
 		customers, err := PancakePos_GetCustomers(apiKey, shopId, pageNumber, pageSize, startTime, endTime)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy customers cho shop %d: %v", shopId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -2145,7 +2455,7 @@ func bridgeV2_SyncAllCustomersFromPosForShop(apiKey string, shopId int, customer
 			}
 
 			// ✅ Upsert customer từ POS (tự động xử lý duplicate theo posCustomerId hoặc phone/email)
-			_, err = FolkForm_UpsertCustomerFromPos(customerMap)
+			_, err = FolkForm_UpsertCustomerFromPos(ctx, customerMap)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert customer từ POS: %v", err)
 				// Tiếp tục với customer tiếp theo
@@ -2198,7 +2508,7 @@ func parseOrderUpdatedAt(updatedAtStr string) (int64, error) {
 // Tham số:
 //   - pageSize: Số lượng access tokens/pages lấy mỗi lần (mặc định 50 nếu <= 0)
 //   - orderPageSize: Số lượng orders lấy mỗi lần (mặc định 50 nếu <= 0)
-func BridgeV2_SyncNewOrders(pageSize int, orderPageSize int) error {
+func BridgeV2_SyncNewOrders(ctx context.Context, pageSize int, orderPageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu sync orders mới từ POS (incremental sync)")
 
 	// Sử dụng pageSize từ config, mặc định 50 nếu không có
@@ -2216,14 +2526,17 @@ func BridgeV2_SyncNewOrders(pageSize int, orderPageSize int) error {
 	limit := pageSize
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Dừng nửa giây trước khi tiếp tục
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách access token với filter system: "Pancake POS"
-		accessTokens, err := FolkForm_GetAccessTokens(page, limit, filter)
+		accessTokens, err := FolkForm_GetAccessTokens(ctx, page, limit, filter)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách access token: %v", err)
-			return errors.New("Lỗi khi lấy danh sách access token")
+			return fmt.Errorf("Lỗi khi lấy danh sách access token: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -2237,6 +2550,9 @@ func BridgeV2_SyncNewOrders(pageSize int, orderPageSize int) error {
 		if itemCount > 0 && len(items) > 0 {
 			// Với mỗi token
 			for _, item := range items {
+				if err := syncCancelled(ctx); err != nil {
+					return err
+				}
 				// Dừng nửa giây trước khi tiếp tục
 				time.Sleep(100 * time.Millisecond)
 
@@ -2254,17 +2570,23 @@ func BridgeV2_SyncNewOrders(pageSize int, orderPageSize int) error {
 					continue
 				}
 
-				log.Printf("[BridgeV2] Đang đồng bộ orders mới với API key (system: Pancake POS, length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đang đồng bộ orders mới với API key (system: Pancake POS, fp: %s)", secrets.Fingerprint(apiKey))
 
 				// 1. Lấy danh sách shops
 				shops, err := PancakePos_GetShops(apiKey)
 				if err != nil {
 					logError("[BridgeV2] Lỗi khi lấy danh sách shops: %v", err)
+					if shouldAbortSync(err) {
+						return err
+					}
 					continue
 				}
 
 				// 2. Với mỗi shop
 				for _, shop := range shops {
+					if err := syncCancelled(ctx); err != nil {
+						return err
+					}
 					// Dừng nửa giây trước khi tiếp tục
 					time.Sleep(100 * time.Millisecond)
 
@@ -2294,7 +2616,7 @@ func BridgeV2_SyncNewOrders(pageSize int, orderPageSize int) error {
 					}
 
 					// 3. Đồng bộ orders mới cho shop này (sử dụng orderPageSize từ config)
-					err = bridgeV2_SyncNewOrdersForShop(apiKey, shopId, orderPageSize)
+					err = bridgeV2_SyncNewOrdersForShop(ctx, apiKey, shopId, orderPageSize)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi đồng bộ orders mới cho shop %d: %v", shopId, err)
 						// Tiếp tục với shop tiếp theo
@@ -2302,7 +2624,7 @@ func BridgeV2_SyncNewOrders(pageSize int, orderPageSize int) error {
 					}
 				}
 
-				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ orders mới cho API key (length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ orders mới cho API key (fp: %s)", secrets.Fingerprint(apiKey))
 			}
 		} else {
 			log.Println("[BridgeV2] Không còn access token nào. Kết thúc.")
@@ -2326,11 +2648,11 @@ func BridgeV2_SyncNewOrders(pageSize int, orderPageSize int) error {
 //   - apiKey: API key của Pancake POS
 //   - shopId: ID của shop
 //   - orderPageSize: Số lượng orders lấy mỗi lần (mặc định 50 nếu <= 0)
-func bridgeV2_SyncNewOrdersForShop(apiKey string, shopId int, orderPageSize int) error {
+func bridgeV2_SyncNewOrdersForShop(ctx context.Context, apiKey string, shopId int, orderPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu sync orders đã cập nhật gần đây cho shop %d", shopId)
 
 	// 1. Lấy mốc từ FolkForm
-	lastUpdatedAt, err := FolkForm_GetLastOrderUpdatedAt(shopId)
+	lastUpdatedAt, err := FolkForm_GetLastOrderUpdatedAt(ctx, shopId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy lastUpdatedAt cho shop %d: %v", shopId, err)
 		return err
@@ -2359,6 +2681,9 @@ func bridgeV2_SyncNewOrdersForShop(apiKey string, shopId int, orderPageSize int)
 	rateLimiter := apputility.GetPancakeRateLimiter()
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Rate limiter
 		rateLimiter.Wait()
 
@@ -2366,6 +2691,9 @@ func bridgeV2_SyncNewOrdersForShop(apiKey string, shopId int, orderPageSize int)
 		result, err := PancakePos_GetOrders(apiKey, shopId, pageNumber, pageSize, "updated_at")
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy orders cho shop %d: %v", shopId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -2408,9 +2736,12 @@ func bridgeV2_SyncNewOrdersForShop(apiKey string, shopId int, orderPageSize int)
 			}
 
 			// ✅ Upsert order (tự động xử lý duplicate theo orderId + shopId)
-			_, err = FolkForm_CreatePcPosOrder(order)
+			_, err = FolkForm_CreatePcPosOrder(ctx, order)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert order: %v", err)
+				if shouldAbortSync(err) {
+					return err
+				}
 				// Tiếp tục với order tiếp theo
 			}
 		}
@@ -2438,7 +2769,7 @@ func bridgeV2_SyncNewOrdersForShop(apiKey string, shopId int, orderPageSize int)
 // Tham số:
 //   - pageSize: Số lượng access tokens/pages lấy mỗi lần (mặc định 50 nếu <= 0)
 //   - orderPageSize: Số lượng orders lấy mỗi lần (mặc định 30 nếu <= 0)
-func BridgeV2_SyncAllOrders(pageSize int, orderPageSize int) error {
+func BridgeV2_SyncAllOrders(ctx context.Context, pageSize int, orderPageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu sync orders cũ từ POS (backfill sync)")
 
 	// Sử dụng pageSize từ config, mặc định 50 nếu không có
@@ -2456,14 +2787,17 @@ func BridgeV2_SyncAllOrders(pageSize int, orderPageSize int) error {
 	limit := pageSize
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Dừng nửa giây trước khi tiếp tục
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách access token với filter system: "Pancake POS"
-		accessTokens, err := FolkForm_GetAccessTokens(page, limit, filter)
+		accessTokens, err := FolkForm_GetAccessTokens(ctx, page, limit, filter)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách access token: %v", err)
-			return errors.New("Lỗi khi lấy danh sách access token")
+			return fmt.Errorf("Lỗi khi lấy danh sách access token: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -2477,6 +2811,9 @@ func BridgeV2_SyncAllOrders(pageSize int, orderPageSize int) error {
 		if itemCount > 0 && len(items) > 0 {
 			// Với mỗi token
 			for _, item := range items {
+				if err := syncCancelled(ctx); err != nil {
+					return err
+				}
 				// Dừng nửa giây trước khi tiếp tục
 				time.Sleep(100 * time.Millisecond)
 
@@ -2494,17 +2831,23 @@ func BridgeV2_SyncAllOrders(pageSize int, orderPageSize int) error {
 					continue
 				}
 
-				log.Printf("[BridgeV2] Đang đồng bộ orders cũ với API key (system: Pancake POS, length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đang đồng bộ orders cũ với API key (system: Pancake POS, fp: %s)", secrets.Fingerprint(apiKey))
 
 				// 1. Lấy danh sách shops
 				shops, err := PancakePos_GetShops(apiKey)
 				if err != nil {
 					logError("[BridgeV2] Lỗi khi lấy danh sách shops: %v", err)
+					if shouldAbortSync(err) {
+						return err
+					}
 					continue
 				}
 
 				// 2. Với mỗi shop
 				for _, shop := range shops {
+					if err := syncCancelled(ctx); err != nil {
+						return err
+					}
 					// Dừng nửa giây trước khi tiếp tục
 					time.Sleep(100 * time.Millisecond)
 
@@ -2534,7 +2877,7 @@ func BridgeV2_SyncAllOrders(pageSize int, orderPageSize int) error {
 					}
 
 					// 3. Đồng bộ orders cũ cho shop này (sử dụng orderPageSize từ config)
-					err = bridgeV2_SyncAllOrdersForShop(apiKey, shopId, orderPageSize)
+					err = bridgeV2_SyncAllOrdersForShop(ctx, apiKey, shopId, orderPageSize)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi đồng bộ orders cũ cho shop %d: %v", shopId, err)
 						// Tiếp tục với shop tiếp theo
@@ -2542,7 +2885,7 @@ func BridgeV2_SyncAllOrders(pageSize int, orderPageSize int) error {
 					}
 				}
 
-				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ orders cũ cho API key (length: %d)", len(apiKey))
+				log.Printf("[BridgeV2] Đã hoàn thành đồng bộ orders cũ cho API key (fp: %s)", secrets.Fingerprint(apiKey))
 			}
 		} else {
 			log.Println("[BridgeV2] Không còn access token nào. Kết thúc.")
@@ -2566,11 +2909,11 @@ func BridgeV2_SyncAllOrders(pageSize int, orderPageSize int) error {
 //   - apiKey: API key của Pancake POS
 //   - shopId: ID của shop
 //   - orderPageSize: Số lượng orders lấy mỗi lần (mặc định 30 nếu <= 0)
-func bridgeV2_SyncAllOrdersForShop(apiKey string, shopId int, orderPageSize int) error {
+func bridgeV2_SyncAllOrdersForShop(ctx context.Context, apiKey string, shopId int, orderPageSize int) error {
 	log.Printf("[BridgeV2] Bắt đầu sync orders cập nhật cũ cho shop %d", shopId)
 
 	// 1. Lấy mốc từ FolkForm
-	oldestUpdatedAt, err := FolkForm_GetOldestOrderUpdatedAt(shopId)
+	oldestUpdatedAt, err := FolkForm_GetOldestOrderUpdatedAt(ctx, shopId)
 	if err != nil {
 		logError("[BridgeV2] Lỗi khi lấy oldestUpdatedAt cho shop %d: %v", shopId, err)
 		return err
@@ -2600,12 +2943,15 @@ func bridgeV2_SyncAllOrdersForShop(apiKey string, shopId int, orderPageSize int)
 	batchCount := 0
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Rate limiter
 		rateLimiter.Wait()
 
 		// Refresh oldestUpdatedAt sau mỗi 10 batches
 		if batchCount > 0 && batchCount%10 == 0 {
-			newOldestUpdatedAt, err := FolkForm_GetOldestOrderUpdatedAt(shopId)
+			newOldestUpdatedAt, err := FolkForm_GetOldestOrderUpdatedAt(ctx, shopId)
 			if err == nil && newOldestUpdatedAt > 0 && newOldestUpdatedAt < endTime {
 				endTime = newOldestUpdatedAt
 				log.Printf("[BridgeV2] Shop %d - Đã refresh oldestUpdatedAt: %d", shopId, endTime)
@@ -2616,6 +2962,9 @@ func bridgeV2_SyncAllOrdersForShop(apiKey string, shopId int, orderPageSize int)
 		result, err := PancakePos_GetOrders(apiKey, shopId, pageNumber, pageSize, "updated_at")
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy orders cho shop %d: %v", shopId, err)
+			if shouldAbortSync(err) {
+				return err
+			}
 			break
 		}
 
@@ -2660,7 +3009,7 @@ func bridgeV2_SyncAllOrdersForShop(apiKey string, shopId int, orderPageSize int)
 			}
 
 			// ✅ Upsert order (tự động xử lý duplicate theo orderId + shopId)
-			_, err = FolkForm_CreatePcPosOrder(order)
+			_, err = FolkForm_CreatePcPosOrder(ctx, order)
 			if err != nil {
 				logError("[BridgeV2] Lỗi khi upsert order: %v", err)
 				// Tiếp tục với order tiếp theo
@@ -2695,7 +3044,7 @@ func bridgeV2_SyncAllOrdersForShop(apiKey string, shopId int, orderPageSize int)
 // Chạy chậm cũng được, quan trọng là đảm bảo đầy đủ dữ liệu
 // Tham số:
 //   - pageSize: Số lượng pages lấy mỗi lần (mặc định 20 nếu <= 0)
-func BridgeV2_SyncFullRecovery(pageSize int) error {
+func BridgeV2_SyncFullRecovery(ctx context.Context, pageSize int) error {
 	log.Println("[BridgeV2] Bắt đầu sync lại TOÀN BỘ conversations (full recovery sync)")
 
 	// Lấy tất cả pages từ FolkForm
@@ -2707,11 +3056,14 @@ func BridgeV2_SyncFullRecovery(pageSize int) error {
 	page := 1
 
 	for {
+		if err := syncCancelled(ctx); err != nil {
+			return err
+		}
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			logError("[BridgeV2] Lỗi khi lấy danh sách trang Facebook: %v", err)
-			return errors.New("Lỗi khi lấy danh sách trang Facebook")
+			return fmt.Errorf("Lỗi khi lấy danh sách trang Facebook: %w", err)
 		}
 
 		// Xử lý response - có thể là pagination object hoặc array trực tiếp
@@ -2730,6 +3082,9 @@ func BridgeV2_SyncFullRecovery(pageSize int) error {
 
 		// Với mỗi page
 		for _, item := range items {
+			if err := syncCancelled(ctx); err != nil {
+				return err
+			}
 			pageMap, ok := item.(map[string]interface{})
 			if !ok {
 				logError("[BridgeV2] Page không phải là map, bỏ qua")
@@ -2764,6 +3119,9 @@ func BridgeV2_SyncFullRecovery(pageSize int) error {
 			const MAX_BATCHES_PER_PAGE = 1000 // Giới hạn để tránh chạy quá lâu
 
 			for {
+				if err := syncCancelled(ctx); err != nil {
+					return err
+				}
 				// Giới hạn số batches để tránh chạy quá lâu
 				if batchCount >= MAX_BATCHES_PER_PAGE {
 					log.Printf("[BridgeV2] Page %s - Đã đạt giới hạn %d batches, dừng sync (đã sync %d conversations)", pageId, MAX_BATCHES_PER_PAGE, conversationCount)
@@ -2781,6 +3139,9 @@ func BridgeV2_SyncFullRecovery(pageSize int) error {
 				resultGetConversations, err := Pancake_GetConversations_v2(pageId, last_conversation_id, 0, 0, "inserted_at", false)
 				if err != nil {
 					logError("[BridgeV2] Lỗi khi lấy conversations từ Pancake: %v", err)
+					if shouldAbortSync(err) {
+						return err
+					}
 					break
 				}
 
@@ -2799,6 +3160,9 @@ func BridgeV2_SyncFullRecovery(pageSize int) error {
 
 				// Sync từng conversation
 				for _, conv := range conversations {
+					if err := syncCancelled(ctx); err != nil {
+						return err
+					}
 					conversationCount++
 					convMap, ok := conv.(map[string]interface{})
 					if !ok {
@@ -2819,7 +3183,7 @@ func BridgeV2_SyncFullRecovery(pageSize int) error {
 
 					// Sync conversation (upsert - tự động update nếu đã tồn tại)
 					// QUAN TRỌNG: Sync lại tất cả conversations để đảm bảo không bỏ sót
-					_, err = FolkForm_CreateConversation(pageId, pageUsername, conv)
+					_, err = FolkForm_CreateConversation(ctx, pageId, pageUsername, conv)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi sync conversation %s: %v", convId, err)
 						// Tiếp tục với conversation tiếp theo, không dừng
@@ -2827,9 +3191,12 @@ func BridgeV2_SyncFullRecovery(pageSize int) error {
 					}
 
 					// Sync TẤT CẢ messages
-					err = bridge_SyncMessageOfConversation(pageId, pageUsername, convId, customerId)
+					err = bridge_SyncMessageOfConversation(ctx, pageId, pageUsername, convId, customerId)
 					if err != nil {
 						logError("[BridgeV2] Lỗi khi sync messages cho conversation %s: %v", convId, err)
+						if shouldAbortSync(err) {
+							return err
+						}
 						// Tiếp tục với conversation tiếp theo, không dừng
 					}
 				}
@@ -0,0 +1,297 @@
+/*
+Package integrations chứa các hàm tích hợp với các hệ thống bên ngoài.
+File này chứa các hàm sync theo đối tượng cụ thể, dùng cho command từ server:
+  - BridgeV2_SyncPage: chạy incremental hoặc backfill (conversations + posts) cho một page
+  - BridgeV2_ResyncConversation: sync lại một conversation và toàn bộ messages
+  - BridgeV2_ResyncOrder: sync lại một order của Pancake POS
+  - BridgeV2_ResetCheckpoint: đặt lại mốc sync incremental của một page, lần sync tiếp theo bắt đầu từ mốc này
+    thay cho mốc lấy từ FolkForm (mốc chỉ giữ trong bộ nhớ, xóa sau khi sync page thành công)
+*/
+package integrations
+
+import (
+	apputility "agent_pancake/app/utility"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Loại mốc sync incremental có thể đặt lại
+const (
+	SyncCheckpointConversations = "conversations"
+	SyncCheckpointPosts         = "posts"
+)
+
+// syncCheckpoints lưu mốc đặt lại theo "<loại>:<pageId>" → Unix giây
+var (
+	syncCheckpoints   = make(map[string]int64)
+	syncCheckpointsMu sync.Mutex
+)
+
+// BridgeV2_ResetCheckpoint đặt lại mốc sync incremental của page (since là Unix giây)
+func BridgeV2_ResetCheckpoint(kind string, pageId string, since int64) error {
+	if kind != SyncCheckpointConversations && kind != SyncCheckpointPosts {
+		return fmt.Errorf("loại mốc không hợp lệ: %s (chỉ hỗ trợ %s, %s)", kind, SyncCheckpointConversations, SyncCheckpointPosts)
+	}
+	if pageId == "" {
+		return errors.New("pageId không được để trống")
+	}
+	if since <= 0 || since > time.Now().Unix() {
+		return fmt.Errorf("mốc since không hợp lệ: %d", since)
+	}
+	syncCheckpointsMu.Lock()
+	defer syncCheckpointsMu.Unlock()
+	syncCheckpoints[kind+":"+pageId] = since
+	log.Printf("[BridgeV2] 📍 Đặt lại mốc sync %s của page %s về %s", kind, pageId, time.Unix(since, 0).Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// SyncCheckpoints trả về các mốc đặt lại chưa được dùng (key "<loại>:<pageId>")
+func SyncCheckpoints() map[string]int64 {
+	syncCheckpointsMu.Lock()
+	defer syncCheckpointsMu.Unlock()
+	checkpoints := make(map[string]int64, len(syncCheckpoints))
+	for key, since := range syncCheckpoints {
+		checkpoints[key] = since
+	}
+	return checkpoints
+}
+
+// syncCheckpointOverride trả về mốc đặt lại của page (nếu có)
+func syncCheckpointOverride(kind string, pageId string) (int64, bool) {
+	syncCheckpointsMu.Lock()
+	defer syncCheckpointsMu.Unlock()
+	since, exists := syncCheckpoints[kind+":"+pageId]
+	return since, exists
+}
+
+// clearSyncCheckpoint xóa mốc đã dùng (giữ lại nếu trong lúc sync server đã đặt mốc khác)
+func clearSyncCheckpoint(kind string, pageId string, since int64) {
+	syncCheckpointsMu.Lock()
+	defer syncCheckpointsMu.Unlock()
+	if syncCheckpoints[kind+":"+pageId] == since {
+		delete(syncCheckpoints, kind+":"+pageId)
+	}
+}
+
+// BridgeV2_SyncPage chạy sync conversations và posts cho một page
+// mode: "incremental" (dữ liệu mới) hoặc "backfill" (dữ liệu cũ)
+// Trả về kết quả theo từng bước ("ok" hoặc lỗi), lỗi của các bước được gộp lại
+func BridgeV2_SyncPage(ctx context.Context, pageId string, mode string, pageSize int, postPageSize int, onPage func(pageId string, err error)) (map[string]interface{}, error) {
+	if pageId == "" {
+		return nil, errors.New("pageId không được để trống")
+	}
+	pageData, err := bridgeV2_GetFbPage(ctx, pageId)
+	if err != nil {
+		return nil, err
+	}
+	if isSync, _ := pageData["isSync"].(bool); !isSync {
+		return nil, fmt.Errorf("page %s không bật sync (isSync=false)", pageId)
+	}
+
+	pageIds := []string{pageId}
+	var conversationsErr, postsErr error
+	switch mode {
+	case "", "incremental":
+		mode = "incremental"
+		conversationsErr = BridgeV2_SyncNewDataForPages(ctx, pageSize, pageIds, onPage)
+		postsErr = BridgeV2_SyncNewPostsForPages(ctx, pageSize, postPageSize, pageIds, nil)
+	case "backfill":
+		conversationsErr = BridgeV2_SyncAllDataForPages(ctx, pageSize, pageIds)
+		postsErr = BridgeV2_SyncAllPostsForPages(ctx, pageSize, postPageSize, pageIds)
+	default:
+		return nil, fmt.Errorf("mode không hợp lệ: %s (chỉ hỗ trợ incremental, backfill)", mode)
+	}
+
+	stepResult := func(err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+	result := map[string]interface{}{
+		"pageId":        pageId,
+		"mode":          mode,
+		"conversations": stepResult(conversationsErr),
+		"posts":         stepResult(postsErr),
+	}
+	return result, errors.Join(conversationsErr, postsErr)
+}
+
+// BridgeV2_ResyncConversation sync lại conversation từ Pancake và upsert lại toàn bộ messages
+// Conversation được tìm trong tối đa maxBatches batch conversations gần nhất của page (mặc định 10)
+func BridgeV2_ResyncConversation(ctx context.Context, pageId string, conversationId string, maxBatches int) (map[string]interface{}, error) {
+	if pageId == "" || conversationId == "" {
+		return nil, errors.New("pageId và conversationId không được để trống")
+	}
+
+	pageUsername := pageId // Fallback giống sync-priority-conversations-job
+	if pageData, err := bridgeV2_GetFbPage(ctx, pageId); err == nil {
+		if username, _ := pageData["pageUsername"].(string); username != "" {
+			pageUsername = username
+		}
+	} else if shouldAbortSync(err) {
+		return nil, err
+	}
+
+	conversation, err := BridgeV2_FindPancakeConversation(ctx, pageId, conversationId, maxBatches)
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi lấy conversation từ Pancake: %w", err)
+	}
+	if conversation == nil {
+		return nil, fmt.Errorf("không tìm thấy conversation %s trong các conversations gần nhất của page %s", conversationId, pageId)
+	}
+
+	if _, err := FolkForm_CreateConversation(ctx, pageId, pageUsername, conversation); err != nil {
+		return nil, fmt.Errorf("lỗi khi sync conversation về FolkForm: %w", err)
+	}
+
+	customerId, _ := conversation["customer_id"].(string)
+	messagesSynced, err := bridge_SyncMessagesOfConversation(ctx, pageId, pageUsername, conversationId, customerId, true)
+	result := map[string]interface{}{
+		"pageId":         pageId,
+		"conversationId": conversationId,
+		"messagesSynced": messagesSynced,
+	}
+	if err != nil {
+		return result, fmt.Errorf("lỗi khi sync messages: %w", err)
+	}
+	log.Printf("[BridgeV2] ✅ Đã sync lại conversation %s (page %s, %d messages)", conversationId, pageId, messagesSynced)
+	return result, nil
+}
+
+// BridgeV2_FindPancakeConversation tìm conversation theo ID trong danh sách conversations của page trên Pancake
+// Pancake không có API lấy một conversation nên tìm trong tối đa maxBatches batch (mặc định 10), không thấy → nil
+func BridgeV2_FindPancakeConversation(ctx context.Context, pageId string, conversationId string, maxBatches int) (map[string]interface{}, error) {
+	if maxBatches <= 0 {
+		maxBatches = 10
+	}
+	rateLimiter := apputility.GetPancakeRateLimiter()
+	lastConversationId := ""
+
+	for batch := 0; batch < maxBatches; batch++ {
+		if err := syncCancelled(ctx); err != nil {
+			return nil, err
+		}
+		rateLimiter.Wait()
+
+		result, err := Pancake_GetConversations_v2(pageId, lastConversationId, 0, 0, "", false)
+		if err != nil {
+			return nil, err
+		}
+
+		conversations, _ := result["conversations"].([]interface{})
+		if len(conversations) == 0 {
+			break
+		}
+
+		for _, conv := range conversations {
+			convMap, ok := conv.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if id, _ := convMap["id"].(string); id == conversationId {
+				return convMap, nil
+			}
+		}
+
+		lastConvMap, _ := conversations[len(conversations)-1].(map[string]interface{})
+		lastId, _ := lastConvMap["id"].(string)
+		if lastId == "" {
+			break
+		}
+		lastConversationId = lastId
+	}
+	return nil, nil
+}
+
+// BridgeV2_ResyncOrder lấy lại order từ Pancake POS và upsert vào FolkForm
+// API key được tìm trong các token Pancake POS trên FolkForm (token có quyền với shopId)
+func BridgeV2_ResyncOrder(ctx context.Context, shopId int, orderId string) (map[string]interface{}, error) {
+	if shopId <= 0 || orderId == "" {
+		return nil, errors.New("shopId và orderId không được để trống")
+	}
+	apiKey, err := bridgeV2_FindPosApiKeyForShop(ctx, shopId)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := PancakePos_GetOrder(apiKey, shopId, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi lấy order từ Pancake POS: %w", err)
+	}
+	if _, err := FolkForm_CreatePcPosOrder(ctx, order); err != nil {
+		return nil, fmt.Errorf("lỗi khi sync order về FolkForm: %w", err)
+	}
+
+	result := map[string]interface{}{
+		"shopId":  shopId,
+		"orderId": orderId,
+	}
+	if status, ok := order["status"]; ok {
+		result["orderStatus"] = status
+	}
+	if updatedAt, ok := order["updated_at"]; ok {
+		result["updatedAt"] = updatedAt
+	}
+	log.Printf("[BridgeV2] ✅ Đã sync lại order %s (shop %d)", orderId, shopId)
+	return result, nil
+}
+
+// bridgeV2_GetFbPage lấy thông tin page từ FolkForm theo pageId
+func bridgeV2_GetFbPage(ctx context.Context, pageId string) (map[string]interface{}, error) {
+	result, err := FolkForm_GetFbPageByPageId(ctx, pageId)
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi lấy page %s từ FolkForm: %w", pageId, err)
+	}
+	pageData, ok := result["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("không tìm thấy page %s trên FolkForm", pageId)
+	}
+	return pageData, nil
+}
+
+// bridgeV2_FindPosApiKeyForShop tìm API key Pancake POS (token trên FolkForm) quản lý shopId
+func bridgeV2_FindPosApiKeyForShop(ctx context.Context, shopId int) (string, error) {
+	filter := `{"system":"Pancake POS"}`
+	limit := 50
+	for page := 1; ; page++ {
+		accessTokens, err := FolkForm_GetAccessTokens(ctx, page, limit, filter)
+		if err != nil {
+			return "", fmt.Errorf("lỗi khi lấy danh sách access token: %w", err)
+		}
+		items, _, err := parseResponseData(accessTokens)
+		if err != nil {
+			return "", err
+		}
+
+		for _, item := range items {
+			itemMap, _ := item.(map[string]interface{})
+			apiKey, _ := itemMap["value"].(string)
+			if apiKey == "" {
+				continue
+			}
+			shops, err := PancakePos_GetShops(apiKey)
+			if err != nil {
+				if shouldAbortSync(err) {
+					return "", err
+				}
+				continue
+			}
+			for _, shop := range shops {
+				shopMap, _ := shop.(map[string]interface{})
+				if id, ok := shopMap["id"].(float64); ok && int(id) == shopId {
+					return apiKey, nil
+				}
+			}
+		}
+
+		if len(items) < limit {
+			break
+		}
+	}
+	return "", fmt.Errorf("không tìm thấy API key Pancake POS của shop %d", shopId)
+}
@@ -1,10 +1,13 @@
 package integrations
 
 import (
+	"agent_pancake/app/integrations/apierror"
 	apputility "agent_pancake/app/utility"
 	"agent_pancake/global"
 	"agent_pancake/utility/httpclient"
 	"agent_pancake/utility/hwid"
+	"agent_pancake/utility/secrets"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,29 +30,54 @@ const (
 )
 
 // Helper function: Kiểm tra ApiToken
+// Nếu token sắp hết hạn thì làm mới trước (single-flight, xem folkform_auth.go)
+// Làm mới thất bại nhưng token cũ chưa hết hạn → vẫn dùng token cũ
 func checkApiToken() error {
-	if global.ApiToken == "" {
-		return errors.New("Chưa đăng nhập. Thoát vòng lặp.")
+	token, expiresAt := global.GetApiTokenWithExpiry()
+	if token == "" {
+		return &apierror.ErrUnauthorized{System: apierror.SystemFolkForm, Message: "Chưa đăng nhập. Thoát vòng lặp."}
+	}
+	if !expiresAt.IsZero() && time.Until(expiresAt) < folkFormTokenRefreshSkew {
+		log.Printf("[FolkForm] Token sắp hết hạn (lúc %s), làm mới trước khi gọi API...", expiresAt.Format(time.RFC3339))
+		if _, err := reauthenticateFolkForm(token); err != nil {
+			if time.Now().Before(expiresAt) {
+				log.Printf("[FolkForm] ⚠️ Chưa làm mới được token, tạm dùng token cũ: %v", err)
+				return nil
+			}
+			return err
+		}
 	}
 	return nil
 }
 
 // Helper function: Tạo HTTP client với authorization header và organization context
 // Thêm header X-Active-Role-ID để xác định context làm việc (Organization Context System - Version 3.2)
-// Tự động lấy role đầu tiên nếu chưa có ActiveRoleId (backend yêu cầu header này bắt buộc)
-func createAuthorizedClient(timeout time.Duration) *httpclient.HttpClient {
+// Dùng role của organization context trong ctx (sync job chạy theo từng organization, xem folkform_org.go),
+// ctx không mang role thì dùng role mặc định. Tự động lấy role mặc định nếu chưa có (backend yêu cầu header này bắt buộc)
+func createAuthorizedClient(ctx context.Context, timeout time.Duration) *httpclient.HttpClient {
+	return newFolkFormClient(timeout, func() string { return activeRoleId(ctx) })
+}
+
+// Helper function: Tạo HTTP client cho các API cấp agent (check-in, config, command, workflow)
+// Luôn dùng role mặc định của agent, không bị ảnh hưởng bởi organization context của sync job đang chạy song song
+func createAgentClient(timeout time.Duration) *httpclient.HttpClient {
+	return newFolkFormClient(timeout, global.GetPrimaryRoleId)
+}
+
+// newFolkFormClient tạo client FolkForm với token hiện tại và header X-Active-Role-ID lấy từ roleIdFn
+func newFolkFormClient(timeout time.Duration, roleIdFn func() string) *httpclient.HttpClient {
 	client := httpclient.NewHttpClient(global.GlobalConfig.ApiBaseUrl, timeout)
-	client.SetHeader("Authorization", "Bearer "+global.ApiToken)
+	client.SetHeader("Authorization", "Bearer "+global.GetApiToken())
 
-	// Đảm bảo có ActiveRoleId trước khi gọi API (backend yêu cầu header X-Active-Role-ID bắt buộc)
-	if global.ActiveRoleId == "" {
+	// Đảm bảo có role mặc định trước khi gọi API (backend yêu cầu header X-Active-Role-ID bắt buộc)
+	if global.GetPrimaryRoleId() == "" {
 		// Tự động lấy role đầu tiên nếu chưa có
 		ensureActiveRoleId()
 	}
 
 	// Thêm header X-Active-Role-ID (bắt buộc theo API v3.2+)
-	if global.ActiveRoleId != "" {
-		client.SetHeader("X-Active-Role-ID", global.ActiveRoleId)
+	if roleId := roleIdFn(); roleId != "" {
+		client.SetHeader("X-Active-Role-ID", roleId)
 	} else {
 		// Nếu vẫn không có role sau khi thử lấy → log warning
 		// Backend sẽ trả về lỗi AUTH_003 nếu không có header này
@@ -59,16 +87,22 @@ func createAuthorizedClient(timeout time.Duration) *httpclient.HttpClient {
 	return client
 }
 
-// ensureActiveRoleId đảm bảo có ActiveRoleId bằng cách lấy role đầu tiên từ backend
-// Hàm này được gọi tự động trong createAuthorizedClient nếu chưa có ActiveRoleId
+// ensureActiveRoleId đảm bảo có role mặc định bằng cách lấy role đầu tiên từ backend
+// (hoặc role đầu tiên trong AGENT_ROLE_IDS nếu có cấu hình)
+// Hàm này được gọi tự động trong createAuthorizedClient nếu chưa có role mặc định
 // Lưu ý: Phải tạo client trực tiếp để tránh vòng lặp đệ quy với createAuthorizedClient
 func ensureActiveRoleId() {
-	if global.ActiveRoleId != "" {
+	if global.GetPrimaryRoleId() != "" {
 		return // Đã có rồi, không cần làm gì
 	}
+	if roleId := configuredPrimaryRoleId(); roleId != "" {
+		useRoleId(roleId)
+		return
+	}
 
 	// Kiểm tra xem đã đăng nhập chưa
-	if global.ApiToken == "" {
+	token := global.GetApiToken()
+	if token == "" {
 		log.Printf("[FolkForm] Chưa đăng nhập, không thể lấy Active Role ID")
 		return
 	}
@@ -78,7 +112,7 @@ func ensureActiveRoleId() {
 	// Tạo client trực tiếp (KHÔNG dùng createAuthorizedClient để tránh vòng lặp đệ quy)
 	// Endpoint /v1/auth/roles có thể không yêu cầu X-Active-Role-ID
 	tempClient := httpclient.NewHttpClient(global.GlobalConfig.ApiBaseUrl, defaultTimeout)
-	tempClient.SetHeader("Authorization", "Bearer "+global.ApiToken)
+	tempClient.SetHeader("Authorization", "Bearer "+token)
 
 	// Gọi API lấy roles trực tiếp (không qua executeGetRequest để tránh vòng lặp)
 	systemName := "[FolkForm]"
@@ -127,15 +161,15 @@ func ensureActiveRoleId() {
 		if firstRole, ok := roles[0].(map[string]interface{}); ok {
 			// Thử lấy roleId từ các field có thể có
 			if roleId, ok := firstRole["id"].(string); ok && roleId != "" {
-				global.ActiveRoleId = roleId
+				useRoleId(roleId)
 				log.Printf("[FolkForm] ✅ Đã lấy Active Role ID: %s", roleId)
 				return
 			} else if roleId, ok := firstRole["roleId"].(string); ok && roleId != "" {
-				global.ActiveRoleId = roleId
+				useRoleId(roleId)
 				log.Printf("[FolkForm] ✅ Đã lấy Active Role ID: %s", roleId)
 				return
 			} else if roleId, ok := firstRole["_id"].(string); ok && roleId != "" {
-				global.ActiveRoleId = roleId
+				useRoleId(roleId)
 				log.Printf("[FolkForm] ✅ Đã lấy Active Role ID: %s", roleId)
 				return
 			}
@@ -160,11 +194,13 @@ func ensureActiveRoleId() {
 func executeGetRequest(client *httpclient.HttpClient, endpoint string, params map[string]string, logMessage string) (map[string]interface{}, error) {
 	systemName := "[FolkForm]"
 	requestCount := 0
+	var lastErr error        // Lỗi có kiểu của lần thử gần nhất (apierror), trả về khi hết số lần retry
+	reauthenticated := false // Đã đăng nhập lại vì 401 trong lần gọi này chưa (chỉ làm một lần)
 	for {
 		requestCount++
 		if requestCount > maxRetries {
 			log.Printf("%s LỖI: Đã thử quá nhiều lần (%d/%d). Thoát vòng lặp.", systemName, requestCount, maxRetries)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemFolkForm, endpoint, maxRetries, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter cho FolkForm
@@ -173,6 +209,7 @@ func executeGetRequest(client *httpclient.HttpClient, endpoint string, params ma
 
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, endpoint, err)
 			if requestCount >= 3 {
 				log.Printf("%s ❌ LỖI khi gọi API GET (lần thử %d/%d): %v", systemName, requestCount, maxRetries, err)
 			}
@@ -226,11 +263,28 @@ func executeGetRequest(client *httpclient.HttpClient, endpoint string, params ma
 
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+
+			// Phân loại lỗi: 401/403/404/400/409/422 không retry (retry cũng không thay đổi kết quả)
+			lastErr = apierror.FromResponse(apierror.SystemFolkForm, endpoint, statusCode, resp.Header, bodyBytes)
+			// 401: token hết hạn/bị thu hồi → đăng nhập lại (single-flight) và gửi lại request một lần
+			if !reauthenticated && isTokenRejected(lastErr) {
+				reauthenticated = true
+				if newToken, err := reauthenticateFolkForm(bearerToken(client)); err == nil {
+					client.SetHeader("Authorization", "Bearer "+newToken)
+					requestCount-- // Lần gửi lại sau khi đăng nhập không tính vào maxRetries
+					continue
+				}
+			}
+			if !apierror.IsRetryable(lastErr) {
+				log.Printf("%s ❌ Lỗi không thể retry (%s): %v", systemName, apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			continue
 		}
 
 		var result map[string]interface{}
 		if err := httpclient.ParseJSONResponse(resp, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, endpoint, err)
 			if requestCount >= 3 {
 				log.Printf("%s ❌ LỖI khi phân tích phản hồi JSON (lần thử %d/%d): %v", systemName, requestCount, maxRetries, err)
 			}
@@ -245,6 +299,8 @@ func executeGetRequest(client *httpclient.HttpClient, endpoint string, params ma
 			return result, nil
 		}
 
+		lastErr = apierror.FromResult(apierror.SystemFolkForm, endpoint, statusCode, result)
+
 		// Chỉ log lỗi khi thử nhiều lần
 		if requestCount >= 3 {
 			if message, ok := result["message"].(string); ok {
@@ -256,7 +312,7 @@ func executeGetRequest(client *httpclient.HttpClient, endpoint string, params ma
 
 		// Kiểm tra lại ở cuối vòng lặp (không cần thiết nhưng giữ để tương thích)
 		if requestCount > maxRetries {
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemFolkForm, endpoint, maxRetries, lastErr)
 		}
 	}
 }
@@ -279,11 +335,13 @@ func executeGetRequest(client *httpclient.HttpClient, endpoint string, params ma
 func executePostRequest(client *httpclient.HttpClient, endpoint string, data interface{}, params map[string]string, logMessage string, errorLogMessage string, withSleep bool) (map[string]interface{}, error) {
 	systemName := "[FolkForm]"
 	requestCount := 0
+	var lastErr error        // Lỗi có kiểu của lần thử gần nhất (apierror), trả về khi hết số lần retry
+	reauthenticated := false // Đã đăng nhập lại vì 401 trong lần gọi này chưa (chỉ làm một lần)
 	for {
 		requestCount++
 		if requestCount > maxRetries {
 			log.Printf("%s LỖI: Đã thử quá nhiều lần (%d/%d). Thoát vòng lặp.", systemName, requestCount, maxRetries)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemFolkForm, endpoint, maxRetries, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter cho FolkForm
@@ -294,6 +352,7 @@ func executePostRequest(client *httpclient.HttpClient, endpoint string, data int
 
 		resp, err := client.POST(endpoint, data, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, endpoint, err)
 			if requestCount >= 3 {
 				log.Printf("%s ❌ LỖI khi gọi API POST (lần thử %d/%d): %v", systemName, requestCount, maxRetries, err)
 			}
@@ -348,11 +407,28 @@ func executePostRequest(client *httpclient.HttpClient, endpoint string, data int
 
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+
+			// Phân loại lỗi: 401/403/404/400/409/422 không retry (retry cũng không thay đổi kết quả)
+			lastErr = apierror.FromResponse(apierror.SystemFolkForm, endpoint, statusCode, resp.Header, bodyBytes)
+			// 401: token hết hạn/bị thu hồi → đăng nhập lại (single-flight) và gửi lại request một lần
+			if !reauthenticated && isTokenRejected(lastErr) {
+				reauthenticated = true
+				if newToken, err := reauthenticateFolkForm(bearerToken(client)); err == nil {
+					client.SetHeader("Authorization", "Bearer "+newToken)
+					requestCount-- // Lần gửi lại sau khi đăng nhập không tính vào maxRetries
+					continue
+				}
+			}
+			if !apierror.IsRetryable(lastErr) {
+				log.Printf("%s ❌ Lỗi không thể retry (%s): %v", systemName, apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			continue
 		}
 
 		var result map[string]interface{}
 		if err := httpclient.ParseJSONResponse(resp, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, endpoint, err)
 			if requestCount >= 3 {
 				log.Printf("%s ❌ LỖI khi phân tích phản hồi JSON (lần thử %d/%d): %v", systemName, requestCount, maxRetries, err)
 			}
@@ -378,6 +454,7 @@ func executePostRequest(client *httpclient.HttpClient, endpoint string, data int
 			return result, nil
 		}
 
+		lastErr = apierror.FromResult(apierror.SystemFolkForm, endpoint, statusCode, result)
 		log.Printf("%s [Bước %d/%d] Response status không phải 'success': %v", systemName, requestCount, maxRetries, result["status"])
 		if result["message"] != nil {
 			log.Printf("%s [Bước %d/%d] Response message: %v", systemName, requestCount, maxRetries, result["message"])
@@ -386,7 +463,7 @@ func executePostRequest(client *httpclient.HttpClient, endpoint string, data int
 
 		// Kiểm tra lại ở cuối vòng lặp (không cần thiết nhưng giữ để tương thích)
 		if requestCount > maxRetries {
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemFolkForm, endpoint, maxRetries, lastErr)
 		}
 	}
 }
@@ -395,11 +472,13 @@ func executePostRequest(client *httpclient.HttpClient, endpoint string, data int
 func executePutRequest(client *httpclient.HttpClient, endpoint string, data interface{}, params map[string]string, logMessage string, errorLogMessage string, withSleep bool) (map[string]interface{}, error) {
 	systemName := "[FolkForm]"
 	requestCount := 0
+	var lastErr error        // Lỗi có kiểu của lần thử gần nhất (apierror), trả về khi hết số lần retry
+	reauthenticated := false // Đã đăng nhập lại vì 401 trong lần gọi này chưa (chỉ làm một lần)
 	for {
 		requestCount++
 		if requestCount > maxRetries {
 			log.Printf("%s LỖI: Đã thử quá nhiều lần (%d/%d). Thoát vòng lặp.", systemName, requestCount, maxRetries)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemFolkForm, endpoint, maxRetries, lastErr)
 		}
 
 		log.Printf("%s [Bước %d/%d] Gửi PUT request đến endpoint: %s", systemName, requestCount, maxRetries, endpoint)
@@ -435,6 +514,7 @@ func executePutRequest(client *httpclient.HttpClient, endpoint string, data inte
 
 		resp, err := client.PUT(endpoint, data, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, endpoint, err)
 			log.Printf("%s [Bước %d/%d] LỖI khi gọi API PUT: %v", systemName, requestCount, maxRetries, err)
 			log.Printf("%s [Bước %d/%d] Request endpoint: %s", systemName, requestCount, maxRetries, endpoint)
 			continue
@@ -489,6 +569,22 @@ func executePutRequest(client *httpclient.HttpClient, endpoint string, data inte
 
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+
+			// Phân loại lỗi: 401/403/404/400/409/422 không retry (retry cũng không thay đổi kết quả)
+			lastErr = apierror.FromResponse(apierror.SystemFolkForm, endpoint, statusCode, resp.Header, bodyBytes)
+			// 401: token hết hạn/bị thu hồi → đăng nhập lại (single-flight) và gửi lại request một lần
+			if !reauthenticated && isTokenRejected(lastErr) {
+				reauthenticated = true
+				if newToken, err := reauthenticateFolkForm(bearerToken(client)); err == nil {
+					client.SetHeader("Authorization", "Bearer "+newToken)
+					requestCount-- // Lần gửi lại sau khi đăng nhập không tính vào maxRetries
+					continue
+				}
+			}
+			if !apierror.IsRetryable(lastErr) {
+				log.Printf("%s ❌ Lỗi không thể retry (%s): %v", systemName, apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			if errorLogMessage != "" {
 				log.Printf("%s [Bước %d/%d] %s %d", systemName, requestCount, maxRetries, errorLogMessage, requestCount)
 			}
@@ -497,6 +593,7 @@ func executePutRequest(client *httpclient.HttpClient, endpoint string, data inte
 
 		var result map[string]interface{}
 		if err := httpclient.ParseJSONResponse(resp, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, endpoint, err)
 			log.Printf("%s [Bước %d/%d] LỖI khi phân tích phản hồi JSON: %v", systemName, requestCount, maxRetries, err)
 			// Đọc lại response body để log
 			bodyBytes, readErr := io.ReadAll(resp.Body)
@@ -526,6 +623,7 @@ func executePutRequest(client *httpclient.HttpClient, endpoint string, data inte
 			return result, nil
 		}
 
+		lastErr = apierror.FromResult(apierror.SystemFolkForm, endpoint, statusCode, result)
 		log.Printf("%s [Bước %d/%d] Response status không phải 'success': %v", systemName, requestCount, maxRetries, result["status"])
 		if result["message"] != nil {
 			log.Printf("%s [Bước %d/%d] Response message: %v", systemName, requestCount, maxRetries, result["message"])
@@ -534,7 +632,7 @@ func executePutRequest(client *httpclient.HttpClient, endpoint string, data inte
 
 		// Kiểm tra lại ở cuối vòng lặp (không cần thiết nhưng giữ để tương thích)
 		if requestCount > maxRetries {
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemFolkForm, endpoint, maxRetries, lastErr)
 		}
 	}
 }
@@ -543,7 +641,7 @@ func executePutRequest(client *httpclient.HttpClient, endpoint string, data inte
 // Sử dụng endpoint /facebook/message-item/find-by-conversation/:conversationId với page=1, limit=1
 // Backend sẽ tự động sort theo insertedAt desc để lấy message mới nhất
 // Trả về insertedAt (Unix timestamp) của message mới nhất, hoặc 0 nếu chưa có messages
-func FolkForm_GetLatestMessageItem(conversationId string) (latestInsertedAt int64, err error) {
+func FolkForm_GetLatestMessageItem(ctx context.Context, conversationId string) (latestInsertedAt int64, err error) {
 	log.Printf("[FolkForm] Bắt đầu lấy message_item mới nhất - conversationId: %s", conversationId)
 
 	if err := checkApiToken(); err != nil {
@@ -551,7 +649,7 @@ func FolkForm_GetLatestMessageItem(conversationId string) (latestInsertedAt int6
 		return 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Sử dụng endpoint đặc biệt /find-by-conversation với page=1, limit=1
 	// Backend sẽ tự động sort theo insertedAt desc để lấy message mới nhất
@@ -617,7 +715,9 @@ func FolkForm_GetLatestMessageItem(conversationId string) (latestInsertedAt int6
 // - fb_messages: Metadata (không có messages[])
 // - fb_message_items: Từng message riêng lẻ (mỗi message là 1 document)
 // Tự động tránh duplicate theo messageId và cập nhật totalMessages, lastSyncedAt
-func FolkForm_UpsertMessages(pageId string, pageUsername string, conversationId string, customerId string, panCakeData interface{}, hasMore bool) (result map[string]interface{}, err error) {
+func FolkForm_UpsertMessages(ctx context.Context, pageId string, pageUsername string, conversationId string, customerId string, panCakeData interface{}, hasMore bool) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "messages", countPancakeMessages(panCakeData), err) }()
+
 	log.Printf("[FolkForm] Bắt đầu upsert messages - pageId: %s, conversationId: %s, customerId: %s, hasMore: %v", pageId, conversationId, customerId, hasMore)
 
 	if err := checkApiToken(); err != nil {
@@ -625,7 +725,7 @@ func FolkForm_UpsertMessages(pageId string, pageUsername string, conversationId
 		return nil, err
 	}
 
-	client := createAuthorizedClient(longTimeout)
+	client := createAuthorizedClient(ctx, longTimeout)
 	data := map[string]interface{}{
 		"pageId":         pageId,
 		"pageUsername":   pageUsername,
@@ -655,7 +755,9 @@ func FolkForm_UpsertMessages(pageId string, pageUsername string, conversationId
 // Upsert sẽ tự động insert nếu chưa có, hoặc update nếu đã có dựa trên unique field
 // Lưu ý: messageData có thể là object chứa array messages hoặc single message
 // Filter nên dựa trên messageId (từ panCakeData.id hoặc panCakeData.message_id) để tránh đè mất messages cũ
-func FolkForm_CreateMessage(pageId string, pageUsername string, conversationId string, customerId string, messageData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_CreateMessage(ctx context.Context, pageId string, pageUsername string, conversationId string, customerId string, messageData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "messages", countPancakeMessages(messageData), err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật tin nhắn - pageId: %s, conversationId: %s, customerId: %s", pageId, conversationId, customerId)
 
 	if err := checkApiToken(); err != nil {
@@ -663,7 +765,7 @@ func FolkForm_CreateMessage(pageId string, pageUsername string, conversationId s
 		return nil, err
 	}
 
-	client := createAuthorizedClient(longTimeout)
+	client := createAuthorizedClient(ctx, longTimeout)
 
 	// Tìm messageId từ messageData để tạo filter chính xác
 	// Mỗi message trong panCakeData.messages có field "id" (không phải "messageId")
@@ -757,7 +859,7 @@ func FolkForm_CreateMessage(pageId string, pageUsername string, conversationId s
 // Hàm FolkForm_GetConversations sẽ gửi yêu cầu lấy danh sách hội thoại từ server
 // Hàm FolkForm_GetConversations sẽ gửi yêu cầu lấy danh sách hội thoại từ server
 // Hàm này sử dụng endpoint phân trang với page và limit
-func FolkForm_GetConversations(page int, limit int) (result map[string]interface{}, err error) {
+func FolkForm_GetConversations(ctx context.Context, page int, limit int) (result map[string]interface{}, err error) {
 
 	log.Printf("[FolkForm] Bắt đầu lấy danh sách hội thoại với phân trang - page: %d, limit: %d", page, limit)
 
@@ -766,7 +868,7 @@ func FolkForm_GetConversations(page int, limit int) (result map[string]interface
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	// Đảm bảo params phân trang luôn được gửi
 	params := map[string]string{
 		"page":  strconv.Itoa(page),
@@ -786,7 +888,7 @@ func FolkForm_GetConversations(page int, limit int) (result map[string]interface
 
 // Hàm FolkForm_GetConversationsWithPageId sẽ gửi yêu cầu lấy danh sách hội thoại từ server với pageId
 // Hàm này sử dụng endpoint phân trang với page và limit
-func FolkForm_GetConversationsWithPageId(page int, limit int, pageId string) (result map[string]interface{}, err error) {
+func FolkForm_GetConversationsWithPageId(ctx context.Context, page int, limit int, pageId string) (result map[string]interface{}, err error) {
 
 	log.Printf("[FolkForm] Bắt đầu lấy danh sách hội thoại theo pageId với phân trang - page: %d, limit: %d, pageId: %s", page, limit, pageId)
 
@@ -795,7 +897,7 @@ func FolkForm_GetConversationsWithPageId(page int, limit int, pageId string) (re
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	// Đảm bảo params phân trang luôn được gửi
 	params := map[string]string{
 		"page":   strconv.Itoa(page),
@@ -824,7 +926,7 @@ func FolkForm_GetConversationsWithPageId(page int, limit int, pageId string) (re
 // - minMinutesAgo: Số phút tối thiểu trước (ví dụ: 5 phút)
 // - maxMinutesAgo: Số phút tối đa trước (ví dụ: 300 phút)
 // Trả về result map và error
-func FolkForm_GetUnrepliedConversationsWithPageId(page int, limit int, pageId string, minMinutesAgo int, maxMinutesAgo int) (result map[string]interface{}, err error) {
+func FolkForm_GetUnrepliedConversationsWithPageId(ctx context.Context, page int, limit int, pageId string, minMinutesAgo int, maxMinutesAgo int) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu lấy danh sách conversations chưa trả lời theo pageId với filter - page: %d, limit: %d, pageId: %s, minMinutesAgo: %d, maxMinutesAgo: %d", page, limit, pageId, minMinutesAgo, maxMinutesAgo)
 
 	if err := checkApiToken(); err != nil {
@@ -832,7 +934,7 @@ func FolkForm_GetUnrepliedConversationsWithPageId(page int, limit int, pageId st
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tính toán thời gian min và max (Unix timestamp milliseconds)
 	now := time.Now()
@@ -903,7 +1005,7 @@ func FolkForm_GetUnrepliedConversationsWithPageId(page int, limit int, pageId st
 // FolkForm_GetUnseenConversationsWithPageId lấy conversations unseen từ FolkForm với filter MongoDB
 // Sử dụng endpoint find-with-pagination với filter để chỉ lấy conversations unseen (panCakeData.seen = false)
 // Tối ưu hơn so với việc lấy tất cả rồi filter ở code
-func FolkForm_GetUnseenConversationsWithPageId(page int, limit int, pageId string) (result map[string]interface{}, err error) {
+func FolkForm_GetUnseenConversationsWithPageId(ctx context.Context, page int, limit int, pageId string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu lấy danh sách conversations unseen theo pageId với filter - page: %d, limit: %d, pageId: %s", page, limit, pageId)
 
 	if err := checkApiToken(); err != nil {
@@ -911,7 +1013,7 @@ func FolkForm_GetUnseenConversationsWithPageId(page int, limit int, pageId strin
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo MongoDB filter để chỉ lấy conversations unseen
 	// Filter: panCakeData.seen = false hoặc panCakeData.seen không tồn tại
@@ -953,12 +1055,12 @@ func FolkForm_GetUnseenConversationsWithPageId(page int, limit int, pageId strin
 // FolkForm_GetLastConversationId lấy conversation mới nhất từ FolkForm
 // Sử dụng endpoint sort-by-api-update (sort desc - mới nhất trước)
 // Endpoint này tự động filter theo pageId và sort theo panCakeUpdatedAt desc
-func FolkForm_GetLastConversationId(pageId string) (conversationId string, err error) {
+func FolkForm_GetLastConversationId(ctx context.Context, pageId string) (conversationId string, err error) {
 	log.Printf("[FolkForm] Lấy conversation mới nhất - pageId: %s", pageId)
 
 	// Endpoint: GET /facebook/conversation/sort-by-api-update?page=1&limit=1&pageId={pageId}
 	// Tự động filter theo pageId và sort theo panCakeUpdatedAt desc (mới nhất trước)
-	result, err := FolkForm_GetConversationsWithPageId(1, 1, pageId)
+	result, err := FolkForm_GetConversationsWithPageId(ctx, 1, 1, pageId)
 	if err != nil {
 		return "", err
 	}
@@ -994,7 +1096,7 @@ func FolkForm_GetLastConversationId(pageId string) (conversationId string, err e
 // - page: Số trang
 // - limit: Số lượng items mỗi trang
 // Trả về result map và error
-func FolkForm_GetPrioritySyncConversations(page int, limit int) (result map[string]interface{}, err error) {
+func FolkForm_GetPrioritySyncConversations(ctx context.Context, page int, limit int) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu lấy danh sách conversations cần ưu tiên sync - page: %d, limit: %d", page, limit)
 
 	if err := checkApiToken(); err != nil {
@@ -1002,7 +1104,7 @@ func FolkForm_GetPrioritySyncConversations(page int, limit int) (result map[stri
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo MongoDB filter để chỉ lấy conversations có needsPrioritySync=true
 	filter := map[string]interface{}{
@@ -1041,7 +1143,7 @@ func FolkForm_GetPrioritySyncConversations(page int, limit int) (result map[stri
 // - conversationId: ID của conversation
 // - needsPrioritySync: Giá trị mới của flag
 // Trả về result map và error
-func FolkForm_UpdateConversationNeedsPrioritySync(conversationId string, needsPrioritySync bool) (result map[string]interface{}, err error) {
+func FolkForm_UpdateConversationNeedsPrioritySync(ctx context.Context, conversationId string, needsPrioritySync bool) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu cập nhật flag needsPrioritySync - conversationId: %s, needsPrioritySync: %v", conversationId, needsPrioritySync)
 
 	if err := checkApiToken(); err != nil {
@@ -1049,7 +1151,7 @@ func FolkForm_UpdateConversationNeedsPrioritySync(conversationId string, needsPr
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter để tìm conversation theo conversationId
 	filter := map[string]interface{}{
@@ -1088,14 +1190,14 @@ func FolkForm_UpdateConversationNeedsPrioritySync(conversationId string, needsPr
 
 // FolkForm_GetOldestConversationId lấy conversation cũ nhất từ FolkForm
 // Filter theo pageId và sort theo panCakeUpdatedAt asc (cũ nhất trước)
-func FolkForm_GetOldestConversationId(pageId string) (conversationId string, err error) {
+func FolkForm_GetOldestConversationId(ctx context.Context, pageId string) (conversationId string, err error) {
 	log.Printf("[FolkForm] Lấy conversation cũ nhất - pageId: %s", pageId)
 
 	if err := checkApiToken(); err != nil {
 		return "", err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Dùng GET với query string
 	// GET /facebook/conversation/find?filter={"pageId":"..."}&options={"sort":{"panCakeUpdatedAt":1},"limit":1}
@@ -1146,7 +1248,9 @@ func FolkForm_GetOldestConversationId(pageId string) (conversationId string, err
 
 // Hàm FolkForm_CreateConversation sẽ gửi yêu cầu tạo/cập nhật hội thoại lên server (sử dụng upsert)
 // Upsert sẽ tự động insert nếu chưa có, hoặc update nếu đã có dựa trên conversationId (unique)
-func FolkForm_CreateConversation(pageId string, pageUsername string, conversation_data interface{}) (result map[string]interface{}, err error) {
+func FolkForm_CreateConversation(ctx context.Context, pageId string, pageUsername string, conversation_data interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "conversations", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật hội thoại - pageId: %s, pageUsername: %s", pageId, pageUsername)
 
 	if err := checkApiToken(); err != nil {
@@ -1154,7 +1258,7 @@ func FolkForm_CreateConversation(pageId string, pageUsername string, conversatio
 		return nil, err
 	}
 
-	client := createAuthorizedClient(longTimeout)
+	client := createAuthorizedClient(ctx, longTimeout)
 
 	// Tạo bản copy của conversation_data và loại bỏ messages[] để tránh đè mất messages cũ
 	// Messages sẽ được upsert riêng lẻ thông qua FolkForm_CreateMessage
@@ -1239,7 +1343,7 @@ func FolkForm_CreateConversation(pageId string, pageUsername string, conversatio
 }
 
 // Hàm FolkForm_GetFbPageById sẽ gửi yêu cầu lấy thông tin trang Facebook từ server
-func FolkForm_GetFbPageById(id string) (result map[string]interface{}, err error) {
+func FolkForm_GetFbPageById(ctx context.Context, id string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu lấy thông tin trang Facebook theo ID - id: %s", id)
 
 	if err := checkApiToken(); err != nil {
@@ -1247,7 +1351,7 @@ func FolkForm_GetFbPageById(id string) (result map[string]interface{}, err error
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	log.Printf("[FolkForm] Đang gửi request GET page (find-by-id) đến FolkForm backend...")
 	result, err = executeGetRequest(client, "/v1/facebook/page/find-by-id/"+id, nil, "")
 	if err != nil {
@@ -1260,7 +1364,7 @@ func FolkForm_GetFbPageById(id string) (result map[string]interface{}, err error
 
 // Hàm FolkForm_GetFbPageByPageId sẽ gửi yêu cầu lấy thông tin trang Facebook từ server
 // Sử dụng endpoint đặc biệt /facebook/page/find-by-page-id/:id thay vì endpoint CRUD thông thường
-func FolkForm_GetFbPageByPageId(pageId string) (result map[string]interface{}, err error) {
+func FolkForm_GetFbPageByPageId(ctx context.Context, pageId string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu lấy thông tin trang Facebook theo pageId - pageId: %s", pageId)
 
 	if err := checkApiToken(); err != nil {
@@ -1268,7 +1372,7 @@ func FolkForm_GetFbPageByPageId(pageId string) (result map[string]interface{}, e
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	log.Printf("[FolkForm] Đang gửi request GET page (find-by-page-id) đến FolkForm backend...")
 	// Sử dụng endpoint đặc biệt /facebook/page/find-by-page-id/:id thay vì find-one với filter
 	result, err = executeGetRequest(client, "/v1/facebook/page/find-by-page-id/"+pageId, nil, "")
@@ -1283,7 +1387,7 @@ func FolkForm_GetFbPageByPageId(pageId string) (result map[string]interface{}, e
 // Hàm FolkForm_GetFbPages sẽ gửi yêu cầu lấy danh sách trang Facebook từ server
 // Hàm FolkForm_GetFbPages sẽ gửi yêu cầu lấy danh sách trang Facebook từ server
 // Hàm này sử dụng endpoint phân trang với page và limit
-func FolkForm_GetFbPages(page int, limit int) (result map[string]interface{}, err error) {
+func FolkForm_GetFbPages(ctx context.Context, page int, limit int) (result map[string]interface{}, err error) {
 
 	log.Printf("[FolkForm] Bắt đầu lấy danh sách trang Facebook với phân trang - page: %d, limit: %d", page, limit)
 
@@ -1292,7 +1396,7 @@ func FolkForm_GetFbPages(page int, limit int) (result map[string]interface{}, er
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	// Đảm bảo params phân trang luôn được gửi
 	params := map[string]string{
 		"page":  strconv.Itoa(page),
@@ -1312,7 +1416,7 @@ func FolkForm_GetFbPages(page int, limit int) (result map[string]interface{}, er
 
 // Hàm FolkForm_UpdatePageAccessToken sẽ gửi yêu cầu cập nhật access token của trang Facebook lên server
 // Sử dụng endpoint đặc biệt /facebook/page/update-token thay vì endpoint CRUD thông thường
-func FolkForm_UpdatePageAccessToken(page_id string, page_access_token string) (result map[string]interface{}, err error) {
+func FolkForm_UpdatePageAccessToken(ctx context.Context, page_id string, page_access_token string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu cập nhật page access token - page_id: %s", page_id)
 
 	if err := checkApiToken(); err != nil {
@@ -1320,7 +1424,7 @@ func FolkForm_UpdatePageAccessToken(page_id string, page_access_token string) (r
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	// Endpoint đặc biệt yêu cầu cả pageId và pageAccessToken trong body
 	updateData := map[string]interface{}{
 		"pageId":          page_id,
@@ -1341,7 +1445,7 @@ func FolkForm_UpdatePageAccessToken(page_id string, page_access_token string) (r
 // Hàm FolkForm_CreateFbPage sẽ gửi yêu cầu lưu/cập nhật trang Facebook lên server (sử dụng upsert)
 // Upsert sẽ tự động insert nếu chưa có, hoặc update nếu đã có dựa trên pageId (unique)
 // Lưu ý: Hàm này sẽ lấy page hiện tại trước để giữ lại các field như isSync nếu page đã tồn tại
-func FolkForm_CreateFbPage(access_token string, page_data interface{}) (result map[string]interface{}, err error) {
+func FolkForm_CreateFbPage(ctx context.Context, access_token string, page_data interface{}) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật trang Facebook")
 
 	if err := checkApiToken(); err != nil {
@@ -1349,7 +1453,7 @@ func FolkForm_CreateFbPage(access_token string, page_data interface{}) (result m
 		return nil, err
 	}
 
-	client := createAuthorizedClient(longTimeout)
+	client := createAuthorizedClient(ctx, longTimeout)
 
 	// Tạo filter cho upsert dựa trên pageId từ panCakeData
 	params := make(map[string]string)
@@ -1381,7 +1485,7 @@ func FolkForm_CreateFbPage(access_token string, page_data interface{}) (result m
 	var existingPageData map[string]interface{}
 	if pageId != "" {
 		log.Printf("[FolkForm] Lấy thông tin page hiện tại để giữ lại các field không có trong input...")
-		existingPage, err := FolkForm_GetFbPageByPageId(pageId)
+		existingPage, err := FolkForm_GetFbPageByPageId(ctx, pageId)
 		if err == nil && existingPage != nil {
 			if existingPageDataMap, ok := existingPage["data"].(map[string]interface{}); ok {
 				existingPageData = existingPageDataMap
@@ -1439,7 +1543,7 @@ func FolkForm_CreateFbPage(access_token string, page_data interface{}) (result m
 // Hàm FolkForm_GetAccessTokens sẽ gửi yêu cầu lấy danh sách access token từ server
 // Hàm này sử dụng endpoint phân trang với page và limit
 // filter: JSON string của MongoDB filter (optional), ví dụ: `{"system":"Pancake"}`
-func FolkForm_GetAccessTokens(page int, limit int, filter string) (result map[string]interface{}, err error) {
+func FolkForm_GetAccessTokens(ctx context.Context, page int, limit int, filter string) (result map[string]interface{}, err error) {
 
 	log.Printf("[FolkForm] Bắt đầu lấy danh sách access token với phân trang - page: %d, limit: %d", page, limit)
 	if filter != "" {
@@ -1451,7 +1555,7 @@ func FolkForm_GetAccessTokens(page int, limit int, filter string) (result map[st
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	// Đảm bảo params phân trang luôn được gửi
 	params := map[string]string{
 		"page":  strconv.Itoa(page),
@@ -1472,13 +1576,31 @@ func FolkForm_GetAccessTokens(page int, limit int, filter string) (result map[st
 		log.Printf("[FolkForm] LỖI khi lấy danh sách access token (page=%d, limit=%d): %v", page, limit, err)
 	} else {
 		log.Printf("[FolkForm] Lấy danh sách access token thành công với phân trang - page: %d, limit: %d", page, limit)
+		registerAccessTokenSecrets(result)
 	}
 	return result, err
 }
 
-// Hàm Firebase_GetIdToken đăng nhập vào Firebase và lấy ID Token
+// registerAccessTokenSecrets đăng ký các access token (value của từng item) để logger che đi khi in ra
+// data có thể là array trực tiếp hoặc object có pagination (data.items), xem parseResponseData
+func registerAccessTokenSecrets(result map[string]interface{}) {
+	items, _, err := parseResponseData(result)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			if value, ok := itemMap["value"].(string); ok {
+				secrets.Register(value)
+			}
+		}
+	}
+}
+
+// Hàm firebaseSignInWithPassword đăng nhập vào Firebase và lấy ID Token
 // Sử dụng Firebase REST API để đăng nhập bằng email/password
-func Firebase_GetIdToken() (string, error) {
+// ID Token và refresh token được cache lại (xem Firebase_GetIdToken trong folkform_auth.go)
+func firebaseSignInWithPassword() (string, error) {
 	log.Println("[Firebase] ========================================")
 	log.Println("[Firebase] Bắt đầu đăng nhập Firebase...")
 
@@ -1504,16 +1626,12 @@ func Firebase_GetIdToken() (string, error) {
 
 	log.Println("[Firebase] [Bước 0/3] ✅ Cấu hình Firebase đầy đủ")
 	log.Printf("[Firebase] [Bước 0/3] Email: %s", global.GlobalConfig.FirebaseEmail)
-	log.Printf("[Firebase] [Bước 0/3] API Key: %s...%s (length: %d)",
-		global.GlobalConfig.FirebaseApiKey[:min(10, len(global.GlobalConfig.FirebaseApiKey))],
-		global.GlobalConfig.FirebaseApiKey[max(0, len(global.GlobalConfig.FirebaseApiKey)-10):],
-		len(global.GlobalConfig.FirebaseApiKey))
-	log.Printf("[Firebase] [Bước 0/3] Password: %s (length: %d)",
-		maskPassword(global.GlobalConfig.FirebasePassword),
-		len(global.GlobalConfig.FirebasePassword))
+	log.Printf("[Firebase] [Bước 0/3] API Key: %s (fp: %s)", secrets.RedactedPlaceholder, secrets.Fingerprint(global.GlobalConfig.FirebaseApiKey))
+	log.Printf("[Firebase] [Bước 0/3] Password: %s (fp: %s)", secrets.RedactedPlaceholder, secrets.Fingerprint(global.GlobalConfig.FirebasePassword))
 
 	// Tạo HTTP client cho Firebase
-	firebaseBaseURL := "https://identitytoolkit.googleapis.com"
+	// Base URL lấy từ config (FIREBASE_AUTH_BASE_URL / preset theo AGENT_ENV) để có thể trỏ sang Auth Emulator
+	firebaseBaseURL := global.GlobalConfig.FirebaseAuthBaseUrl
 	log.Printf("[Firebase] [Bước 1/3] Tạo HTTP client với base URL: %s", firebaseBaseURL)
 	firebaseClient := httpclient.NewHttpClient(firebaseBaseURL, defaultTimeout)
 
@@ -1535,7 +1653,7 @@ func Firebase_GetIdToken() (string, error) {
 	if err != nil {
 		log.Printf("[Firebase] [Bước 3/3] LỖI khi gọi Firebase API: %v", err)
 		log.Printf("[Firebase] [Bước 3/3] Request data: email=%s, returnSecureToken=true", global.GlobalConfig.FirebaseEmail)
-		return "", errors.New("Lỗi khi gọi Firebase API: " + err.Error())
+		return "", apierror.NewNetwork(apierror.SystemFirebase, "/v1/accounts:signInWithPassword", err)
 	}
 
 	log.Printf("[Firebase] [Bước 3/3] Response Status Code: %d", resp.StatusCode)
@@ -1577,11 +1695,16 @@ func Firebase_GetIdToken() (string, error) {
 		}
 		log.Printf("[Firebase] [Bước 3/3] LỖI: %s", errorMessage)
 
-		// Trả về error với prefix đặc biệt để FolkForm_Login có thể nhận biết
+		// Trả về lỗi có kiểu để FolkForm_Login quyết định đợi / thử lại / dừng
+		// QUOTA_EXCEEDED → ErrRateLimited (đợi quotaExceededWait)
+		// Sai thông tin đăng nhập → ErrUnauthorized (thử lại vô ích)
 		if isQuotaExceeded {
-			return "", errors.New("QUOTA_EXCEEDED: " + errorMessage)
+			return "", &apierror.ErrRateLimited{System: apierror.SystemFirebase, Endpoint: "/v1/accounts:signInWithPassword", RetryAfter: quotaExceededWait, Message: "QUOTA_EXCEEDED: " + errorMessage}
 		}
-		return "", errors.New(errorMessage)
+		if isFirebaseCredentialError(errorMessage) {
+			return "", &apierror.ErrUnauthorized{System: apierror.SystemFirebase, Endpoint: "/v1/accounts:signInWithPassword", Status: resp.StatusCode, Message: errorMessage}
+		}
+		return "", apierror.FromResponse(apierror.SystemFirebase, "/v1/accounts:signInWithPassword", resp.StatusCode, resp.Header, bodyBytes)
 	}
 
 	// Parse response để lấy ID Token
@@ -1594,7 +1717,7 @@ func Firebase_GetIdToken() (string, error) {
 		if readErr == nil {
 			log.Printf("[Firebase] [Bước 3/3] Response Body (raw): %s", string(bodyBytes))
 		}
-		return "", errors.New("Lỗi khi phân tích phản hồi từ Firebase: " + err.Error())
+		return "", apierror.NewNetwork(apierror.SystemFirebase, "/v1/accounts:signInWithPassword", err)
 	}
 
 	log.Printf("[Firebase] [Bước 3/3] Response Body (thành công): có %d keys", len(result))
@@ -1607,12 +1730,11 @@ func Firebase_GetIdToken() (string, error) {
 		log.Printf("[Firebase] [Bước 3/3] Response keys: %+v", getMapKeys(result))
 		log.Printf("[Firebase] [Bước 3/3] Response Body: %+v", result)
 		log.Println("[Firebase] ========================================")
-		return "", errors.New("Không tìm thấy ID Token trong phản hồi từ Firebase")
+		return "", &apierror.ErrUpstream{System: apierror.SystemFirebase, Endpoint: "/v1/accounts:signInWithPassword", Status: http.StatusOK, Message: "Không tìm thấy ID Token trong phản hồi từ Firebase"}
 	}
 
 	log.Println("[Firebase] [Bước 3/3] ✅ Đăng nhập Firebase thành công!")
-	log.Printf("[Firebase] [Bước 3/3] ID Token length: %d", len(idToken))
-	log.Printf("[Firebase] [Bước 3/3] ID Token preview: %s...%s", idToken[:min(20, len(idToken))], idToken[max(0, len(idToken)-20):])
+	log.Printf("[Firebase] [Bước 3/3] ID Token fp: %s", secrets.Fingerprint(idToken))
 
 	// Log thêm thông tin từ response nếu có
 	if localId, ok := result["localId"].(string); ok {
@@ -1621,14 +1743,27 @@ func Firebase_GetIdToken() (string, error) {
 	if email, ok := result["email"].(string); ok {
 		log.Printf("[Firebase] [Bước 3/3] Email: %s", email)
 	}
-	if expiresIn, ok := result["expiresIn"].(string); ok {
+	expiresIn, _ := result["expiresIn"].(string)
+	if expiresIn != "" {
 		log.Printf("[Firebase] [Bước 3/3] Token expires in: %s", expiresIn)
 	}
+	refreshToken, _ := result["refreshToken"].(string)
+	storeFirebaseSession(idToken, refreshToken, expiresIn)
 
 	log.Println("[Firebase] ========================================")
 	return idToken, nil
 }
 
+// isFirebaseCredentialError kiểm tra message lỗi Firebase có phải do sai email/password không
+func isFirebaseCredentialError(message string) bool {
+	for _, code := range []string{"INVALID_PASSWORD", "EMAIL_NOT_FOUND", "INVALID_LOGIN_CREDENTIALS", "USER_DISABLED", "INVALID_EMAIL"} {
+		if strings.Contains(message, code) {
+			return true
+		}
+	}
+	return false
+}
+
 // Helper function để lấy keys của map
 func getMapKeys(m map[string]interface{}) []string {
 	keys := make([]string, 0, len(m))
@@ -1654,17 +1789,6 @@ func max(a, b int) int {
 	return b
 }
 
-// Helper function để mask password (ẩn password trong log)
-func maskPassword(pwd string) string {
-	if len(pwd) == 0 {
-		return "(empty)"
-	}
-	if len(pwd) <= 4 {
-		return "****"
-	}
-	return pwd[:2] + "****" + pwd[len(pwd)-2:]
-}
-
 // Helper function để xác định config được đọc từ đâu
 func getConfigSource() string {
 	// Kiểm tra xem có file .env trong working directory không
@@ -1689,7 +1813,7 @@ func FolkForm_GetRoles() ([]interface{}, error) {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	result, err := executeGetRequest(client, "/v1/auth/roles", nil, "Lấy danh sách roles thành công")
 	if err != nil {
 		log.Printf("[FolkForm] LỖI khi lấy danh sách roles: %v", err)
@@ -1719,9 +1843,10 @@ func FolkForm_GetRoles() ([]interface{}, error) {
 	return roles, nil
 }
 
-// Hàm FolkForm_Login để Agent login vào hệ thống bằng Firebase
+// Hàm folkFormLogin để Agent login vào hệ thống bằng Firebase
 // Tự động đăng nhập Firebase để lấy ID Token, sau đó dùng token đó để đăng nhập backend
-func FolkForm_Login() (result map[string]interface{}, resultError error) {
+// Không gọi trực tiếp, dùng FolkForm_Login (single-flight) trong folkform_auth.go
+func folkFormLogin() (result map[string]interface{}, resultError error) {
 	log.Println("[FolkForm] [Login] ========================================")
 	log.Println("[FolkForm] [Login] Bắt đầu quá trình đăng nhập vào FolkForm backend...")
 	log.Printf("[FolkForm] [Login] API Base URL: %s", global.GlobalConfig.ApiBaseUrl)
@@ -1730,13 +1855,14 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 	client := httpclient.NewHttpClient(global.GlobalConfig.ApiBaseUrl, defaultTimeout)
 
 	requestCount := 0
+	var lastErr error // Lỗi có kiểu của lần thử gần nhất, trả về khi hết số lần retry
 	for {
 		requestCount++
 		log.Printf("[FolkForm] [Login] [Lần thử %d/%d] Bắt đầu quá trình đăng nhập", requestCount, maxRetries)
 
 		if requestCount > maxRetries {
 			log.Printf("[FolkForm] [Login] LỖI: Đã thử quá nhiều lần (%d/%d). Thoát vòng lặp.", requestCount, maxRetries)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemFolkForm, "/v1/auth/login/firebase", maxRetries, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter cho FolkForm
@@ -1757,9 +1883,16 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 		firebaseIdToken, err := Firebase_GetIdToken()
 		if err != nil {
 			log.Printf("[FolkForm] [Login] [Bước 2/3] LỖI khi đăng nhập Firebase: %v", err)
+			lastErr = err
+
+			// Sai thông tin đăng nhập → thử lại cũng vô ích, dừng ngay
+			if apierror.IsUnauthorized(err) {
+				log.Printf("[FolkForm] [Login] [Bước 2/3] ❌ Thông tin đăng nhập Firebase không hợp lệ, dừng đăng nhập")
+				return nil, err
+			}
 
 			// Kiểm tra xem có phải lỗi QUOTA_EXCEEDED không
-			if strings.Contains(err.Error(), "QUOTA_EXCEEDED") {
+			if apierror.Classify(err) == apierror.CategoryRateLimited {
 				log.Printf("[FolkForm] [Login] [Bước 2/3] ⚠️  Firebase đã vượt quá quota verify password")
 				log.Printf("[FolkForm] [Login] [Bước 2/3] ⚠️  Đợi %v trước khi thử lại...", quotaExceededWait)
 				log.Printf("[FolkForm] [Login] [Bước 2/3] ⚠️  Lưu ý: Quota thường được reset sau một khoảng thời gian (thường là 1 giờ)")
@@ -1774,7 +1907,7 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 			}
 			continue
 		}
-		log.Printf("[FolkForm] [Login] [Bước 2/3] Đã lấy được Firebase ID Token (length: %d)", len(firebaseIdToken))
+		log.Printf("[FolkForm] [Login] [Bước 2/3] Đã lấy được Firebase ID Token (fp: %s)", secrets.Fingerprint(firebaseIdToken))
 
 		// Gửi Firebase ID Token và HWID đến endpoint /auth/login/firebase
 		data := map[string]interface{}{
@@ -1783,13 +1916,14 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 		}
 		log.Printf("[FolkForm] [Login] [Bước 3/3] Gửi POST request đăng nhập đến FolkForm backend...")
 		log.Printf("[FolkForm] [Login] [Bước 3/3] Endpoint: /v1/auth/login/firebase")
-		log.Printf("[FolkForm] [Login] [Bước 3/3] Request data: idToken (length: %d), hwid: %s", len(firebaseIdToken), hwid)
+		log.Printf("[FolkForm] [Login] [Bước 3/3] Request data: idToken (fp: %s), hwid: %s", secrets.Fingerprint(firebaseIdToken), hwid)
 
 		resp, err := client.POST("/v1/auth/login/firebase", data, nil)
 		if err != nil {
 			log.Printf("[FolkForm] [Login] [Bước 3/3] LỖI khi gọi API POST: %v", err)
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, "/v1/auth/login/firebase", err)
 			log.Printf("[FolkForm] [Login] [Bước 3/3] Request endpoint: /auth/login/firebase")
-			log.Printf("[FolkForm] [Login] [Bước 3/3] Request data: idToken (length: %d), hwid: %s", len(firebaseIdToken), hwid)
+			log.Printf("[FolkForm] [Login] [Bước 3/3] Request data: idToken (fp: %s), hwid: %s", secrets.Fingerprint(firebaseIdToken), hwid)
 			continue
 		}
 
@@ -1828,6 +1962,11 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 				}
 			}
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemFolkForm, "/v1/auth/login/firebase", statusCode, resp.Header, bodyBytes)
+			if statusCode == http.StatusUnauthorized {
+				// Backend từ chối Firebase ID Token → bỏ token đã cache, lần thử sau lấy token mới
+				invalidateFirebaseIdToken()
+			}
 			log.Printf("[FolkForm] [Login] [Bước 3/3] Đăng nhập thất bại. Thử lại lần thứ %d", requestCount)
 			continue
 		}
@@ -1835,6 +1974,7 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 		var result map[string]interface{}
 		if err := httpclient.ParseJSONResponse(resp, &result); err != nil {
 			log.Printf("[FolkForm] [Login] [Bước 3/3] LỖI khi phân tích phản hồi JSON: %v", err)
+			lastErr = apierror.NewNetwork(apierror.SystemFolkForm, "/v1/auth/login/firebase", err)
 			// Đọc lại response body để log
 			bodyBytes, readErr := io.ReadAll(resp.Body)
 			resp.Body.Close()
@@ -1863,8 +2003,14 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 			// Lưu token vào biến toàn cục
 			if dataMap, ok := result["data"].(map[string]interface{}); ok {
 				if token, ok := dataMap["token"].(string); ok {
-					global.ApiToken = token
-					log.Printf("[FolkForm] [Login] Đã lưu JWT token (length: %d)", len(token))
+					expiresAt := parseJwtExpiry(token)
+					secrets.Register(token)
+					global.SetApiToken(token, expiresAt)
+					if expiresAt.IsZero() {
+						log.Printf("[FolkForm] [Login] Đã lưu JWT token (length: %d, không xác định được thời điểm hết hạn)", len(token))
+					} else {
+						log.Printf("[FolkForm] [Login] Đã lưu JWT token (length: %d, hết hạn lúc %s)", len(token), expiresAt.Format(time.RFC3339))
+					}
 				} else {
 					log.Printf("[FolkForm] [Login] CẢNH BÁO: Không tìm thấy token trong response data")
 					log.Printf("[FolkForm] [Login] Response data: %+v", dataMap)
@@ -1896,10 +2042,10 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 					// Lấy role đầu tiên
 					if firstRole, ok := roles[0].(map[string]interface{}); ok {
 						if roleId, ok := firstRole["id"].(string); ok && roleId != "" {
-							global.ActiveRoleId = roleId
+							useRoleId(roleId)
 							log.Printf("[FolkForm] [Login] Đã lưu Active Role ID từ login response: %s", roleId)
 						} else if roleId, ok := firstRole["roleId"].(string); ok && roleId != "" {
-							global.ActiveRoleId = roleId
+							useRoleId(roleId)
 							log.Printf("[FolkForm] [Login] Đã lưu Active Role ID từ login response: %s", roleId)
 						}
 					}
@@ -1908,10 +2054,10 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 					if roles, ok := user["roles"].([]interface{}); ok && len(roles) > 0 {
 						if firstRole, ok := roles[0].(map[string]interface{}); ok {
 							if roleId, ok := firstRole["id"].(string); ok && roleId != "" {
-								global.ActiveRoleId = roleId
+								useRoleId(roleId)
 								log.Printf("[FolkForm] [Login] Đã lưu Active Role ID từ user.roles: %s", roleId)
 							} else if roleId, ok := firstRole["roleId"].(string); ok && roleId != "" {
-								global.ActiveRoleId = roleId
+								useRoleId(roleId)
 								log.Printf("[FolkForm] [Login] Đã lưu Active Role ID từ user.roles: %s", roleId)
 							}
 						}
@@ -1923,16 +2069,17 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 			}
 
 			// Nếu chưa có ActiveRoleId, sẽ được lấy sau trong SyncBaseAuth()
-			if global.ActiveRoleId == "" {
+			if global.GetPrimaryRoleId() == "" {
 				log.Printf("[FolkForm] [Login] [Bước 3/3] Chưa có Active Role ID, sẽ lấy sau trong SyncBaseAuth()")
 			} else {
-				log.Printf("[FolkForm] [Login] [Bước 3/3] Active Role ID: %s", global.ActiveRoleId)
+				log.Printf("[FolkForm] [Login] [Bước 3/3] Active Role ID: %s", global.GetPrimaryRoleId())
 			}
 
 			log.Println("[FolkForm] [Login] ========================================")
 			return result, nil
 		} else {
 			log.Printf("[FolkForm] [Login] [Bước 3/3] ❌ Response status không phải 'success': %v", result["status"])
+			lastErr = apierror.FromResult(apierror.SystemFolkForm, "/v1/auth/login/firebase", statusCode, result)
 			if result["message"] != nil {
 				log.Printf("[FolkForm] [Login] [Bước 3/3] Response message: %v", result["message"])
 			}
@@ -1942,7 +2089,7 @@ func FolkForm_Login() (result map[string]interface{}, resultError error) {
 		// Kiểm tra lại ở cuối vòng lặp (không cần thiết nhưng giữ để tương thích)
 		if requestCount > maxRetries {
 			log.Printf("[FolkForm] [Login] LỖI: Đã thử quá nhiều lần. Response: %+v", result)
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemFolkForm, "/v1/auth/login/firebase", maxRetries, lastErr)
 		}
 	}
 }
@@ -1956,7 +2103,7 @@ func FolkForm_CheckIn() (result map[string]interface{}, err error) {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	log.Printf("[FolkForm] Đang gửi request POST check-in đến FolkForm backend...")
 	// Sử dụng endpoint đúng theo tài liệu: /api/v1/agent/check-in/:id
 	result, err = executePostRequest(client, "/v1/agent/check-in/"+global.GlobalConfig.AgentId, nil, nil, "Điểm danh thành công", "Điểm danh thất bại. Thử lại lần thứ", true)
@@ -1971,7 +2118,9 @@ func FolkForm_CheckIn() (result map[string]interface{}, err error) {
 // Hàm FolkForm_CreateFbPost sẽ gửi yêu cầu tạo/cập nhật post lên server (sử dụng upsert)
 // postData: Dữ liệu post từ Pancake API (sẽ được gửi trong panCakeData)
 // Backend sẽ tự động extract pageId, postId, insertedAt từ panCakeData
-func FolkForm_CreateFbPost(postData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_CreateFbPost(ctx context.Context, postData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "posts", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật post Facebook")
 
 	if err := checkApiToken(); err != nil {
@@ -1979,7 +2128,7 @@ func FolkForm_CreateFbPost(postData interface{}) (result map[string]interface{},
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter cho upsert dựa trên postId từ panCakeData
 	params := make(map[string]string)
@@ -2017,14 +2166,14 @@ func FolkForm_CreateFbPost(postData interface{}) (result map[string]interface{},
 
 // Hàm FolkForm_GetLastPostId lấy postId và insertedAt (milliseconds) của post mới nhất
 // Trả về: postId, insertedAtMs (milliseconds), error
-func FolkForm_GetLastPostId(pageId string) (postId string, insertedAtMs int64, err error) {
+func FolkForm_GetLastPostId(ctx context.Context, pageId string) (postId string, insertedAtMs int64, err error) {
 	log.Printf("[FolkForm] Lấy post mới nhất - pageId: %s", pageId)
 
 	if err := checkApiToken(); err != nil {
 		return "", 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo pageId, sort theo insertedAt DESC, limit 1
 	params := map[string]string{
@@ -2074,14 +2223,14 @@ func FolkForm_GetLastPostId(pageId string) (postId string, insertedAtMs int64, e
 
 // Hàm FolkForm_GetOldestPostId lấy postId và insertedAt (milliseconds) của post cũ nhất
 // Trả về: postId, insertedAtMs (milliseconds), error
-func FolkForm_GetOldestPostId(pageId string) (postId string, insertedAtMs int64, err error) {
+func FolkForm_GetOldestPostId(ctx context.Context, pageId string) (postId string, insertedAtMs int64, err error) {
 	log.Printf("[FolkForm] Lấy post cũ nhất - pageId: %s", pageId)
 
 	if err := checkApiToken(); err != nil {
 		return "", 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo pageId, sort theo insertedAt ASC, limit 1
 	params := map[string]string{
@@ -2134,14 +2283,16 @@ func FolkForm_GetOldestPostId(pageId string) (postId string, insertedAtMs int64,
 // Chỉ cần gửi đúng DTO: {panCakeData: customerData}
 // Backend sẽ tự động extract dữ liệu từ panCakeData
 // Filter: customerId (từ id) - ID để identify customer
-func FolkForm_UpsertFbCustomer(customerData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_UpsertFbCustomer(ctx context.Context, customerData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "customers", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu upsert FB customer")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2177,14 +2328,14 @@ func FolkForm_UpsertFbCustomer(customerData interface{}) (result map[string]inte
 
 // FolkForm_GetLastFbCustomerUpdatedAt lấy updatedAt (Unix timestamp giây) của FB customer cập nhật gần nhất
 // Trả về: updatedAt (seconds), error
-func FolkForm_GetLastFbCustomerUpdatedAt(pageId string) (updatedAt int64, err error) {
+func FolkForm_GetLastFbCustomerUpdatedAt(ctx context.Context, pageId string) (updatedAt int64, err error) {
 	log.Printf("[FolkForm] Lấy FB customer cập nhật gần nhất - pageId: %s", pageId)
 
 	if err := checkApiToken(); err != nil {
 		return 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo pageId, sort theo updatedAt DESC, limit 1
 	params := map[string]string{
@@ -2234,14 +2385,14 @@ func FolkForm_GetLastFbCustomerUpdatedAt(pageId string) (updatedAt int64, err er
 
 // FolkForm_GetOldestFbCustomerUpdatedAt lấy updatedAt (Unix timestamp giây) của FB customer cập nhật cũ nhất
 // Trả về: updatedAt (seconds), error
-func FolkForm_GetOldestFbCustomerUpdatedAt(pageId string) (updatedAt int64, err error) {
+func FolkForm_GetOldestFbCustomerUpdatedAt(ctx context.Context, pageId string) (updatedAt int64, err error) {
 	log.Printf("[FolkForm] Lấy FB customer cập nhật cũ nhất - pageId: %s", pageId)
 
 	if err := checkApiToken(); err != nil {
 		return 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo pageId, sort theo updatedAt ASC, limit 1
 	params := map[string]string{
@@ -2295,14 +2446,16 @@ func FolkForm_GetOldestFbCustomerUpdatedAt(pageId string) (updatedAt int64, err
 // Server sẽ tự động extract dữ liệu từ posData
 // Filter: customerId (từ id) - ID để identify customer
 // Trả về: map[string]interface{} response từ FolkForm
-func FolkForm_UpsertCustomerFromPos(customerData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_UpsertCustomerFromPos(ctx context.Context, customerData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "posCustomers", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu upsert POS customer")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2340,14 +2493,14 @@ func FolkForm_UpsertCustomerFromPos(customerData interface{}) (result map[string
 
 // FolkForm_GetLastPosCustomerUpdatedAt lấy updatedAt (Unix timestamp giây) của POS customer cập nhật gần nhất
 // Trả về: updatedAt (seconds), error
-func FolkForm_GetLastPosCustomerUpdatedAt(shopId int) (updatedAt int64, err error) {
+func FolkForm_GetLastPosCustomerUpdatedAt(ctx context.Context, shopId int) (updatedAt int64, err error) {
 	log.Printf("[FolkForm] Lấy POS customer cập nhật gần nhất - shopId: %d", shopId)
 
 	if err := checkApiToken(); err != nil {
 		return 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo shopId, sort theo updatedAt DESC, limit 1
 	params := map[string]string{
@@ -2397,14 +2550,14 @@ func FolkForm_GetLastPosCustomerUpdatedAt(shopId int) (updatedAt int64, err erro
 
 // FolkForm_GetOldestPosCustomerUpdatedAt lấy updatedAt (Unix timestamp giây) của POS customer cập nhật cũ nhất
 // Trả về: updatedAt (seconds), error
-func FolkForm_GetOldestPosCustomerUpdatedAt(shopId int) (updatedAt int64, err error) {
+func FolkForm_GetOldestPosCustomerUpdatedAt(ctx context.Context, shopId int) (updatedAt int64, err error) {
 	log.Printf("[FolkForm] Lấy POS customer cập nhật cũ nhất - shopId: %d", shopId)
 
 	if err := checkApiToken(); err != nil {
 		return 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo shopId, sort theo updatedAt ASC, limit 1
 	params := map[string]string{
@@ -2455,14 +2608,16 @@ func FolkForm_GetOldestPosCustomerUpdatedAt(shopId int) (updatedAt int64, err er
 // FolkForm_UpsertShop tạo/cập nhật shop trong FolkForm
 // shopData: Dữ liệu shop từ Pancake POS API (map[string]interface{})
 // Trả về: map[string]interface{} response từ FolkForm
-func FolkForm_UpsertShop(shopData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_UpsertShop(ctx context.Context, shopData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "shops", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật shop")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2494,11 +2649,11 @@ func FolkForm_UpsertShop(shopData interface{}) (result map[string]interface{}, e
 			}
 		} else {
 			log.Printf("[FolkForm] LỖI: Không tìm thấy field 'id' trong shop data từ Pancake POS, không thể upsert")
-			return nil, errors.New("Không tìm thấy field 'id' trong shop data")
+			return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "Không tìm thấy field 'id' trong shop data")
 		}
 	} else {
 		log.Printf("[FolkForm] LỖI: shopData không phải là map[string]interface{}")
-		return nil, errors.New("shopData không phải là map[string]interface{}")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "shopData không phải là map[string]interface{}")
 	}
 
 	// Tạo data đúng DTO: ShopCreateInput {panCakeData: shopData}
@@ -2520,14 +2675,16 @@ func FolkForm_UpsertShop(shopData interface{}) (result map[string]interface{}, e
 // FolkForm_UpsertWarehouse tạo/cập nhật warehouse trong FolkForm
 // warehouseData: Dữ liệu warehouse từ Pancake POS API (map[string]interface{})
 // Trả về: map[string]interface{} response từ FolkForm
-func FolkForm_UpsertWarehouse(warehouseData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_UpsertWarehouse(ctx context.Context, warehouseData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "warehouses", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật warehouse")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2572,17 +2729,17 @@ func FolkForm_UpsertWarehouse(warehouseData interface{}) (result map[string]inte
 					log.Printf("[FolkForm] Tạo filter cho upsert warehouse (warehouseId được convert từ %T sang string): %s", idRaw, filter)
 				} else {
 					log.Printf("[FolkForm] LỖI: warehouseId rỗng sau khi convert")
-					return nil, errors.New("warehouseId rỗng sau khi convert")
+					return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "warehouseId rỗng sau khi convert")
 				}
 			} else {
 				log.Printf("[FolkForm] LỖI: Không tìm thấy field 'id' trong warehouse data, không thể upsert")
 				log.Printf("[FolkForm] Warehouse data: %+v", warehouseMap)
-				return nil, errors.New("Không tìm thấy field 'id' trong warehouse data")
+				return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "Không tìm thấy field 'id' trong warehouse data")
 			}
 		}
 	} else {
 		log.Printf("[FolkForm] LỖI: warehouseData không phải là map[string]interface{}, type: %T", warehouseData)
-		return nil, errors.New("warehouseData không phải là map[string]interface{}")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "warehouseData không phải là map[string]interface{}")
 	}
 
 	// Tạo data đúng DTO: WarehouseCreateInput {panCakeData: warehouseData}
@@ -2605,14 +2762,16 @@ func FolkForm_UpsertWarehouse(warehouseData interface{}) (result map[string]inte
 // productData: Dữ liệu product từ Pancake POS API (map[string]interface{})
 // shopId: ID của shop (integer) - được truyền từ context vì product data không có shop_id
 // Trả về: map[string]interface{} response từ FolkForm
-func FolkForm_UpsertProductFromPos(productData interface{}, shopId int) (result map[string]interface{}, err error) {
+func FolkForm_UpsertProductFromPos(ctx context.Context, productData interface{}, shopId int) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "products", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật product")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2672,11 +2831,11 @@ func FolkForm_UpsertProductFromPos(productData interface{}, shopId int) (result
 			if shopId <= 0 {
 				log.Printf("[FolkForm] LỖI: shopId không hợp lệ: %d", shopId)
 			}
-			return nil, errors.New("Không tìm thấy productId hoặc shopId không hợp lệ")
+			return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "Không tìm thấy productId hoặc shopId không hợp lệ")
 		}
 	} else {
 		log.Printf("[FolkForm] LỖI: productData không phải là map[string]interface{}")
-		return nil, errors.New("productData không phải là map[string]interface{}")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "productData không phải là map[string]interface{}")
 	}
 
 	// Tạo data đúng DTO: {posData: productData}
@@ -2698,14 +2857,16 @@ func FolkForm_UpsertProductFromPos(productData interface{}, shopId int) (result
 // FolkForm_UpsertVariationFromPos tạo/cập nhật variation trong FolkForm
 // variationData: Dữ liệu variation từ Pancake POS API (map[string]interface{})
 // Trả về: map[string]interface{} response từ FolkForm
-func FolkForm_UpsertVariationFromPos(variationData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_UpsertVariationFromPos(ctx context.Context, variationData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "variations", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật variation")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2742,17 +2903,17 @@ func FolkForm_UpsertVariationFromPos(variationData interface{}) (result map[stri
 					log.Printf("[FolkForm] Tạo filter cho upsert variation (variationId được convert từ %T sang string): %s", idRaw, filter)
 				} else {
 					log.Printf("[FolkForm] LỖI: variationId rỗng sau khi convert")
-					return nil, errors.New("variationId rỗng sau khi convert")
+					return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "variationId rỗng sau khi convert")
 				}
 			} else {
 				log.Printf("[FolkForm] LỖI: Không tìm thấy field 'id' trong variation data, không thể upsert")
 				log.Printf("[FolkForm] Variation data: %+v", variationMap)
-				return nil, errors.New("Không tìm thấy field 'id' trong variation data")
+				return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "Không tìm thấy field 'id' trong variation data")
 			}
 		}
 	} else {
 		log.Printf("[FolkForm] LỖI: variationData không phải là map[string]interface{}, type: %T", variationData)
-		return nil, errors.New("variationData không phải là map[string]interface{}")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "variationData không phải là map[string]interface{}")
 	}
 
 	// Tạo data đúng DTO: {posData: variationData}
@@ -2774,14 +2935,16 @@ func FolkForm_UpsertVariationFromPos(variationData interface{}) (result map[stri
 // FolkForm_UpsertCategoryFromPos tạo/cập nhật category trong FolkForm
 // categoryData: Dữ liệu category từ Pancake POS API (map[string]interface{})
 // Trả về: map[string]interface{} response từ FolkForm
-func FolkForm_UpsertCategoryFromPos(categoryData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_UpsertCategoryFromPos(ctx context.Context, categoryData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "categories", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật category")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2838,11 +3001,11 @@ func FolkForm_UpsertCategoryFromPos(categoryData interface{}) (result map[string
 			if !hasShopId {
 				log.Printf("[FolkForm] LỖI: Không tìm thấy field 'shop_id' trong category data")
 			}
-			return nil, errors.New("Không tìm thấy field 'id' hoặc 'shop_id' trong category data")
+			return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "Không tìm thấy field 'id' hoặc 'shop_id' trong category data")
 		}
 	} else {
 		log.Printf("[FolkForm] LỖI: categoryData không phải là map[string]interface{}")
-		return nil, errors.New("categoryData không phải là map[string]interface{}")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "categoryData không phải là map[string]interface{}")
 	}
 
 	// Tạo data đúng DTO: {posData: categoryData}
@@ -2864,14 +3027,16 @@ func FolkForm_UpsertCategoryFromPos(categoryData interface{}) (result map[string
 // FolkForm_CreatePcPosOrder tạo/cập nhật order trong FolkForm
 // orderData: Dữ liệu order từ Pancake POS API (map[string]interface{})
 // Trả về: map[string]interface{} response từ FolkForm
-func FolkForm_CreatePcPosOrder(orderData interface{}) (result map[string]interface{}, err error) {
+func FolkForm_CreatePcPosOrder(ctx context.Context, orderData interface{}) (result map[string]interface{}, err error) {
+	defer func() { recordSyncWrite(ctx, "orders", 1, err) }()
+
 	log.Printf("[FolkForm] Bắt đầu tạo/cập nhật order")
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo params với filter cho upsert
 	params := map[string]string{}
@@ -2928,11 +3093,11 @@ func FolkForm_CreatePcPosOrder(orderData interface{}) (result map[string]interfa
 			if !hasShopId {
 				log.Printf("[FolkForm] LỖI: Không tìm thấy field 'shop_id' trong order data")
 			}
-			return nil, errors.New("Không tìm thấy field 'id' hoặc 'shop_id' trong order data")
+			return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "Không tìm thấy field 'id' hoặc 'shop_id' trong order data")
 		}
 	} else {
 		log.Printf("[FolkForm] LỖI: orderData không phải là map[string]interface{}")
-		return nil, errors.New("orderData không phải là map[string]interface{}")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "orderData không phải là map[string]interface{}")
 	}
 
 	// Tạo data đúng DTO: {posData: orderData}
@@ -2954,14 +3119,14 @@ func FolkForm_CreatePcPosOrder(orderData interface{}) (result map[string]interfa
 // FolkForm_GetLastOrderUpdatedAt lấy posUpdatedAt (Unix timestamp giây) của order cập nhật gần nhất
 // shopId: ID của shop (integer)
 // Trả về: posUpdatedAt (seconds), error
-func FolkForm_GetLastOrderUpdatedAt(shopId int) (updatedAt int64, err error) {
+func FolkForm_GetLastOrderUpdatedAt(ctx context.Context, shopId int) (updatedAt int64, err error) {
 	log.Printf("[FolkForm] Lấy order cập nhật gần nhất - shopId: %d", shopId)
 
 	if err := checkApiToken(); err != nil {
 		return 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo shopId, sort theo posUpdatedAt DESC, limit 1
 	filter := fmt.Sprintf(`{"shopId":%d}`, shopId)
@@ -3020,14 +3185,14 @@ func FolkForm_GetLastOrderUpdatedAt(shopId int) (updatedAt int64, err error) {
 // FolkForm_GetOldestOrderUpdatedAt lấy posUpdatedAt (Unix timestamp giây) của order cập nhật cũ nhất
 // shopId: ID của shop (integer)
 // Trả về: posUpdatedAt (seconds), error
-func FolkForm_GetOldestOrderUpdatedAt(shopId int) (updatedAt int64, err error) {
+func FolkForm_GetOldestOrderUpdatedAt(ctx context.Context, shopId int) (updatedAt int64, err error) {
 	log.Printf("[FolkForm] Lấy order cập nhật cũ nhất - shopId: %d", shopId)
 
 	if err := checkApiToken(); err != nil {
 		return 0, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Query: filter theo shopId, sort theo posUpdatedAt ASC, limit 1
 	filter := fmt.Sprintf(`{"shopId":%d}`, shopId)
@@ -3086,7 +3251,7 @@ func FolkForm_GetOldestOrderUpdatedAt(shopId int) (updatedAt int64, err error) {
 // - eventType: Loại event (ví dụ: "conversation_unreplied")
 // - payload: Dữ liệu cho template variables (map[string]interface{})
 // Trả về result map và error
-func FolkForm_TriggerNotification(eventType string, payload map[string]interface{}) (result map[string]interface{}, err error) {
+func FolkForm_TriggerNotification(ctx context.Context, eventType string, payload map[string]interface{}) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu trigger notification - eventType: %s", eventType)
 
 	if err := checkApiToken(); err != nil {
@@ -3094,7 +3259,7 @@ func FolkForm_TriggerNotification(eventType string, payload map[string]interface
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	data := map[string]interface{}{
 		"eventType": eventType,
 		"payload":   payload,
@@ -3129,7 +3294,7 @@ func FolkForm_TriggerNotification(eventType string, payload map[string]interface
 // - ctaCodes: Danh sách CTA codes (optional)
 // - description: Mô tả về template để người dùng hiểu được mục đích sử dụng (optional, Version 3.11+)
 // Trả về result map và error
-func FolkForm_CreateNotificationTemplate(eventType string, channelType string, subject string, content string, variables []string, ctaCodes []string, description string) (result map[string]interface{}, err error) {
+func FolkForm_CreateNotificationTemplate(ctx context.Context, eventType string, channelType string, subject string, content string, variables []string, ctaCodes []string, description string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu tạo notification template - eventType: %s, channelType: %s", eventType, channelType)
 
 	if err := checkApiToken(); err != nil {
@@ -3137,7 +3302,7 @@ func FolkForm_CreateNotificationTemplate(eventType string, channelType string, s
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	data := map[string]interface{}{
 		"eventType":   eventType,
 		"channelType": channelType,
@@ -3185,7 +3350,7 @@ func FolkForm_CreateNotificationTemplate(eventType string, channelType string, s
 // Trả về result map và error
 // Lưu ý: Routing rule chỉ cần eventType và organizationIds. Channels sẽ được tự động lấy từ organizations khi trigger.
 // Nếu organizations chưa có channels, notification sẽ không được gửi (nhưng routing rule vẫn được tạo thành công).
-func FolkForm_CreateNotificationRoutingRule(eventType string, organizationIds []string, channelTypes []string) (result map[string]interface{}, err error) {
+func FolkForm_CreateNotificationRoutingRule(ctx context.Context, eventType string, organizationIds []string, channelTypes []string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu tạo notification routing rule - eventType: %s, organizationIds: %v", eventType, organizationIds)
 
 	if err := checkApiToken(); err != nil {
@@ -3196,11 +3361,15 @@ func FolkForm_CreateNotificationRoutingRule(eventType string, organizationIds []
 	// Lấy ownerOrganizationId từ role hiện tại (Version 3.9+ - REQUIRED)
 	// Routing rule giờ cần ownerOrganizationId để phân quyền dữ liệu
 	var ownerOrganizationId string
-	if global.ActiveRoleId != "" {
+	if activeRoleId := activeRoleId(ctx); activeRoleId != "" {
 		roles, err := FolkForm_GetRoles()
 		if err == nil && len(roles) > 0 {
-			// Lấy role đầu tiên để lấy ownerOrganizationId
-			if roleMap, ok := roles[0].(map[string]interface{}); ok {
+			// Lấy role đang làm việc (organization context), không có thì lấy role đầu tiên
+			roleMap := findRoleById(roles, activeRoleId)
+			if roleMap == nil {
+				roleMap, _ = roles[0].(map[string]interface{})
+			}
+			if roleMap != nil {
 				if ownerOrgId, ok := roleMap["ownerOrganizationId"].(string); ok && ownerOrgId != "" {
 					ownerOrganizationId = ownerOrgId
 					log.Printf("[FolkForm] Lấy ownerOrganizationId từ role: %s", ownerOrganizationId)
@@ -3215,7 +3384,7 @@ func FolkForm_CreateNotificationRoutingRule(eventType string, organizationIds []
 		log.Printf("[FolkForm] Sử dụng organizationId đầu tiên làm ownerOrganizationId: %s", ownerOrganizationId)
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	data := map[string]interface{}{
 		"eventType":       eventType,
 		"organizationIds": organizationIds,
@@ -3255,10 +3424,10 @@ func FolkForm_CreateNotificationRoutingRule(eventType string, organizationIds []
 
 // FolkForm_GetOrganizationIdsFromRole lấy danh sách organization IDs từ role hiện tại
 // Trả về danh sách organization IDs (có thể nhiều nếu role có quyền với nhiều organizations)
-func FolkForm_GetOrganizationIdsFromRole() ([]string, error) {
+func FolkForm_GetOrganizationIdsFromRole(ctx context.Context) ([]string, error) {
 	log.Printf("[FolkForm] Bắt đầu lấy organization IDs từ role hiện tại")
 
-	if global.ActiveRoleId == "" {
+	if activeRoleId(ctx) == "" {
 		log.Printf("[FolkForm] Chưa có Active Role ID, đang lấy roles...")
 		roles, err := FolkForm_GetRoles()
 		if err != nil {
@@ -3267,15 +3436,16 @@ func FolkForm_GetOrganizationIdsFromRole() ([]string, error) {
 		if len(roles) > 0 {
 			if firstRole, ok := roles[0].(map[string]interface{}); ok {
 				if roleId, ok := firstRole["id"].(string); ok && roleId != "" {
-					global.ActiveRoleId = roleId
+					useRoleId(roleId)
 				} else if roleId, ok := firstRole["roleId"].(string); ok && roleId != "" {
-					global.ActiveRoleId = roleId
+					useRoleId(roleId)
 				}
 			}
 		}
 	}
 
-	if global.ActiveRoleId == "" {
+	activeRoleId := activeRoleId(ctx)
+	if activeRoleId == "" {
 		return nil, errors.New("Không thể lấy Active Role ID")
 	}
 
@@ -3296,7 +3466,7 @@ func FolkForm_GetOrganizationIdsFromRole() ([]string, error) {
 			}
 
 			// Nếu là role hiện tại hoặc tất cả roles (nếu cần)
-			if roleId == global.ActiveRoleId || global.ActiveRoleId == "" {
+			if roleId == activeRoleId {
 				if ownerOrgId, ok := roleMap["ownerOrganizationId"].(string); ok && ownerOrgId != "" {
 					organizationIds = append(organizationIds, ownerOrgId)
 					log.Printf("[FolkForm] Tìm thấy organization ID: %s từ role: %s", ownerOrgId, roleId)
@@ -3317,12 +3487,12 @@ func FolkForm_GetOrganizationIdsFromRole() ([]string, error) {
 // - eventType: Loại event
 // - channelType: Loại kênh
 // Trả về true nếu đã tồn tại, false nếu chưa có, error nếu có lỗi
-func FolkForm_CheckNotificationTemplateExists(eventType string, channelType string) (bool, error) {
+func FolkForm_CheckNotificationTemplateExists(ctx context.Context, eventType string, channelType string) (bool, error) {
 	if err := checkApiToken(); err != nil {
 		return false, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter để tìm template
 	filter := map[string]interface{}{
@@ -3372,7 +3542,7 @@ func FolkForm_CheckNotificationTemplateExists(eventType string, channelType stri
 // - recipients: Danh sách recipients (email addresses cho email, chat IDs cho telegram, webhook URL cho webhook)
 // - description: Mô tả về channel để người dùng hiểu được mục đích sử dụng (optional, Version 3.11+)
 // Trả về result map và error
-func FolkForm_CreateNotificationChannel(organizationId string, channelType string, name string, recipients []string, description string) (result map[string]interface{}, err error) {
+func FolkForm_CreateNotificationChannel(ctx context.Context, organizationId string, channelType string, name string, recipients []string, description string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu tạo notification channel - organizationId: %s, channelType: %s, name: %s", organizationId, channelType, name)
 
 	if err := checkApiToken(); err != nil {
@@ -3380,7 +3550,7 @@ func FolkForm_CreateNotificationChannel(organizationId string, channelType strin
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	data := map[string]interface{}{
 		"organizationId": organizationId,
 		"channelType":    channelType,
@@ -3430,12 +3600,12 @@ func FolkForm_CreateNotificationChannel(organizationId string, channelType strin
 // - organizationId: Organization ID
 // - channelType: Loại channel ("email", "telegram", "webhook")
 // Trả về true nếu đã tồn tại, false nếu chưa có, error nếu có lỗi
-func FolkForm_CheckNotificationChannelExists(organizationId string, channelType string) (bool, error) {
+func FolkForm_CheckNotificationChannelExists(ctx context.Context, organizationId string, channelType string) (bool, error) {
 	if err := checkApiToken(); err != nil {
 		return false, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter để tìm channel
 	filter := map[string]interface{}{
@@ -3482,12 +3652,12 @@ func FolkForm_CheckNotificationChannelExists(organizationId string, channelType
 // Tham số:
 // - eventType: Loại event
 // Trả về true nếu đã tồn tại, false nếu chưa có, error nếu có lỗi
-func FolkForm_CheckNotificationRoutingRuleExists(eventType string) (bool, error) {
+func FolkForm_CheckNotificationRoutingRuleExists(ctx context.Context, eventType string) (bool, error) {
 	if err := checkApiToken(); err != nil {
 		return false, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter để tìm routing rule
 	filter := map[string]interface{}{
@@ -3538,7 +3708,7 @@ func FolkForm_CheckNotificationRoutingRuleExists(eventType string) (bool, error)
 // - organizationId: Organization ID (optional, nếu rỗng sẽ lấy từ role)
 // - description: Mô tả về CTA để người dùng hiểu được mục đích sử dụng (optional, Version 3.11+)
 // Trả về result map và error
-func FolkForm_CreateCTALibrary(code string, label string, action string, style string, variables []string, organizationId string, description string) (result map[string]interface{}, err error) {
+func FolkForm_CreateCTALibrary(ctx context.Context, code string, label string, action string, style string, variables []string, organizationId string, description string) (result map[string]interface{}, err error) {
 	log.Printf("[FolkForm] Bắt đầu tạo CTA Library - code: %s", code)
 
 	if err := checkApiToken(); err != nil {
@@ -3546,7 +3716,7 @@ func FolkForm_CreateCTALibrary(code string, label string, action string, style s
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 	data := map[string]interface{}{
 		"code":     code,
 		"label":    label,
@@ -3588,12 +3758,12 @@ func FolkForm_CreateCTALibrary(code string, label string, action string, style s
 // - code: Mã CTA
 // - organizationId: Organization ID (optional)
 // Trả về true nếu đã tồn tại, false nếu chưa có, error nếu có lỗi
-func FolkForm_CheckCTALibraryExists(code string, organizationId string) (bool, error) {
+func FolkForm_CheckCTALibraryExists(ctx context.Context, code string, organizationId string) (bool, error) {
 	if err := checkApiToken(); err != nil {
 		return false, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter để tìm CTA Library
 	filter := map[string]interface{}{
@@ -3645,13 +3815,13 @@ func FolkForm_CheckCTALibraryExists(code string, organizationId string) (bool, e
 // - eventType: Loại event (ví dụ: "conversation_unreplied")
 // - organizationIds: Danh sách organization IDs sẽ nhận notification (optional, nếu rỗng sẽ lấy từ role)
 // Trả về error nếu có lỗi
-func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string) error {
+func FolkForm_EnsureNotificationSetup(ctx context.Context, eventType string, organizationIds []string) error {
 	log.Printf("[FolkForm] 🔧 Bắt đầu đảm bảo notification setup cho eventType: %s", eventType)
 
 	// Lấy organizationIds từ role nếu chưa có (để tạo CTA Library)
 	if len(organizationIds) == 0 {
 		log.Printf("[FolkForm] 🔍 Đang lấy organization IDs từ role hiện tại để tạo CTA Library...")
-		orgIds, err := FolkForm_GetOrganizationIdsFromRole()
+		orgIds, err := FolkForm_GetOrganizationIdsFromRole(ctx)
 		if err != nil {
 			log.Printf("[FolkForm] ⚠️ Lưu ý: Không thể lấy organization IDs từ role: %v", err)
 		} else {
@@ -3672,7 +3842,7 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 	// Kiểm tra CTA đã tồn tại chưa (tìm trong tất cả organizations hoặc system)
 	var ctaExists bool
 	var ctaErr error
-	ctaExists, ctaErr = FolkForm_CheckCTALibraryExists(ctaCode, "")
+	ctaExists, ctaErr = FolkForm_CheckCTALibraryExists(ctx, ctaCode, "")
 	if ctaErr != nil {
 		log.Printf("[FolkForm] ⚠️ Lỗi khi kiểm tra CTA Library: %v", ctaErr)
 	} else if !ctaExists {
@@ -3682,7 +3852,7 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 		if len(organizationIds) > 0 {
 			orgId = organizationIds[0]
 		}
-		_, ctaErr = FolkForm_CreateCTALibrary(ctaCode, ctaLabel, ctaAction, ctaStyle, ctaVariables, orgId, ctaDescription)
+		_, ctaErr = FolkForm_CreateCTALibrary(ctx, ctaCode, ctaLabel, ctaAction, ctaStyle, ctaVariables, orgId, ctaDescription)
 		if ctaErr != nil {
 			log.Printf("[FolkForm] ❌ Lỗi khi tạo CTA Library: %v", ctaErr)
 		} else {
@@ -3710,13 +3880,13 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 	telegramCtaCodes := []string{"view_detail"}
 
 	// Kiểm tra xem template đã tồn tại chưa
-	exists, err := FolkForm_CheckNotificationTemplateExists(eventType, "telegram")
+	exists, err := FolkForm_CheckNotificationTemplateExists(ctx, eventType, "telegram")
 	if err != nil {
 		log.Printf("[FolkForm] ⚠️ Lỗi khi kiểm tra template Telegram: %v", err)
 	} else if !exists {
 		log.Printf("[FolkForm] 📝 Tạo mới template Telegram cho eventType: %s", eventType)
 		templateDescription := fmt.Sprintf("Template Telegram cho event %s - Cảnh báo hội thoại chưa được trả lời", eventType)
-		_, err := FolkForm_CreateNotificationTemplate(
+		_, err := FolkForm_CreateNotificationTemplate(ctx,
 			eventType,
 			"telegram",
 			"", // Telegram không cần subject
@@ -3753,13 +3923,13 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 	emailCtaCodes := []string{"view_detail"}
 
 	// Kiểm tra xem template đã tồn tại chưa
-	exists, err = FolkForm_CheckNotificationTemplateExists(eventType, "email")
+	exists, err = FolkForm_CheckNotificationTemplateExists(ctx, eventType, "email")
 	if err != nil {
 		log.Printf("[FolkForm] ⚠️ Lỗi khi kiểm tra template Email: %v", err)
 	} else if !exists {
 		log.Printf("[FolkForm] 📝 Tạo mới template Email cho eventType: %s", eventType)
 		templateDescription := fmt.Sprintf("Template Email cho event %s - Cảnh báo hội thoại chưa được trả lời", eventType)
-		_, err = FolkForm_CreateNotificationTemplate(
+		_, err = FolkForm_CreateNotificationTemplate(ctx,
 			eventType,
 			"email",
 			emailSubject,
@@ -3794,13 +3964,13 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 	webhookVariables := []string{"eventType", "conversationId", "pageId", "pageUsername", "customerName", "conversationType", "minutes", "updatedAt", "conversationLink", "tags"}
 
 	// Kiểm tra xem template đã tồn tại chưa
-	exists, err = FolkForm_CheckNotificationTemplateExists(eventType, "webhook")
+	exists, err = FolkForm_CheckNotificationTemplateExists(ctx, eventType, "webhook")
 	if err != nil {
 		log.Printf("[FolkForm] ⚠️ Lỗi khi kiểm tra template Webhook: %v", err)
 	} else if !exists {
 		log.Printf("[FolkForm] 📝 Tạo mới template Webhook cho eventType: %s", eventType)
 		templateDescription := fmt.Sprintf("Template Webhook cho event %s - Cảnh báo hội thoại chưa được trả lời", eventType)
-		_, err = FolkForm_CreateNotificationTemplate(
+		_, err = FolkForm_CreateNotificationTemplate(ctx,
 			eventType,
 			"webhook",
 			"", // Webhook không cần subject
@@ -3821,7 +3991,7 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 	// Lấy organizationIds từ role nếu chưa có
 	if len(organizationIds) == 0 {
 		log.Printf("[FolkForm] 🔍 Đang lấy organization IDs từ role hiện tại...")
-		orgIds, err := FolkForm_GetOrganizationIdsFromRole()
+		orgIds, err := FolkForm_GetOrganizationIdsFromRole(ctx)
 		if err != nil {
 			log.Printf("[FolkForm] ⚠️ Lưu ý: Không thể lấy organization IDs từ role: %v", err)
 		} else {
@@ -3843,7 +4013,7 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 			//
 			// Vẫn check trước để tránh gọi API không cần thiết, nhưng nếu check fails
 			// vẫn thử tạo (backend sẽ trả về 409 nếu duplicate, không sao)
-			exists, err := FolkForm_CheckNotificationChannelExists(orgId, "telegram")
+			exists, err := FolkForm_CheckNotificationChannelExists(ctx, orgId, "telegram")
 			if err != nil {
 				// Nếu check fails, vẫn thử tạo (backend sẽ validate)
 				log.Printf("[FolkForm] ⚠️ Lỗi khi kiểm tra Telegram channel cho organization %s: %v", orgId, err)
@@ -3856,7 +4026,7 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 			// Tạo channel (backend sẽ trả về 409 Conflict nếu duplicate)
 			log.Printf("[FolkForm] 📝 Tạo mới Telegram channel cho organization: %s với chatId: %s", orgId, telegramChatId)
 			channelDescription := fmt.Sprintf("Telegram channel cho organization %s để nhận notifications", orgId)
-			_, err = FolkForm_CreateNotificationChannel(orgId, "telegram", "Telegram Channel", []string{telegramChatId}, channelDescription)
+			_, err = FolkForm_CreateNotificationChannel(ctx, orgId, "telegram", "Telegram Channel", []string{telegramChatId}, channelDescription)
 			if err != nil {
 				// Kiểm tra xem có phải lỗi duplicate không (409 Conflict)
 				if strings.Contains(err.Error(), "409") || strings.Contains(err.Error(), "Conflict") || strings.Contains(err.Error(), "duplicate") {
@@ -3878,7 +4048,7 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 		// Không chỉ định channelTypes để lấy tất cả channels của organizations
 		// Nếu muốn filter, có thể chỉ định: channelTypes := []string{"telegram", "email", "webhook"}
 		channelTypes := []string{} // Empty = lấy tất cả channels
-		_, err = FolkForm_CreateNotificationRoutingRule(eventType, organizationIds, channelTypes)
+		_, err = FolkForm_CreateNotificationRoutingRule(ctx, eventType, organizationIds, channelTypes)
 		if err != nil {
 			log.Printf("[FolkForm] ❌ Lỗi khi tạo routing rule: %v", err)
 		} else {
@@ -3898,12 +4068,12 @@ func FolkForm_EnsureNotificationSetup(eventType string, organizationIds []string
 // - conversationId: ID của conversation (để kiểm tra notification đã được tạo cho conversation này chưa)
 // Trả về true nếu đã tồn tại, false nếu chưa có, error nếu có lỗi
 // Lưu ý: Kiểm tra dựa trên eventType và payload.conversationId trong queue item
-func FolkForm_CheckNotificationQueueItemExists(eventType string, conversationId string) (bool, error) {
+func FolkForm_CheckNotificationQueueItemExists(ctx context.Context, eventType string, conversationId string) (bool, error) {
 	if err := checkApiToken(); err != nil {
 		return false, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter để tìm queue item với eventType và payload.conversationId
 	// Backend lưu payload trong queue item, cần filter theo payload.conversationId
@@ -3960,12 +4130,12 @@ func FolkForm_CheckNotificationQueueItemExists(eventType string, conversationId
 // - conversationId: ID của conversation (optional, nếu có sẽ filter theo payload.conversationId)
 // - limit: Số lượng items tối đa (default: 20)
 // Trả về danh sách notification history items
-func FolkForm_GetNotificationHistory(eventType string, conversationId string, limit int) (items []interface{}, err error) {
+func FolkForm_GetNotificationHistory(ctx context.Context, eventType string, conversationId string, limit int) (items []interface{}, err error) {
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter
 	filter := map[string]interface{}{
@@ -4014,12 +4184,12 @@ func FolkForm_GetNotificationHistory(eventType string, conversationId string, li
 // - conversationId: ID của conversation (optional, nếu có sẽ filter theo payload.conversationId)
 // - limit: Số lượng items tối đa (default: 20)
 // Trả về danh sách notification queue items
-func FolkForm_GetNotificationQueueItems(eventType string, conversationId string, limit int) (items []interface{}, err error) {
+func FolkForm_GetNotificationQueueItems(ctx context.Context, eventType string, conversationId string, limit int) (items []interface{}, err error) {
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAuthorizedClient(ctx, defaultTimeout)
 
 	// Tạo filter
 	filter := map[string]interface{}{
@@ -4074,7 +4244,7 @@ func FolkForm_EnhancedCheckIn(agentId string, data interface{}) (map[string]inte
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	// Sử dụng endpoint: /v1/agent-management/check-in (theo API v3.12)
 	// agentId được gửi trong request body, không cần trong URL
@@ -4124,7 +4294,7 @@ func FolkForm_SubmitConfig(agentId string, configData map[string]interface{}, co
 	// QUAN TRỌNG: Kiểm tra agentId có hợp lệ không
 	if agentId == "" {
 		log.Printf("[FolkForm] [SubmitConfig] ❌ LỖI: agentId rỗng!")
-		return nil, errors.New("agentId không được để trống")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "agentId không được để trống")
 	}
 
 	if err := checkApiToken(); err != nil {
@@ -4132,7 +4302,7 @@ func FolkForm_SubmitConfig(agentId string, configData map[string]interface{}, co
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	// Build request body
 	// QUAN TRỌNG: Set isActive=true để đảm bảo config này là active config cho agent
@@ -4278,7 +4448,7 @@ func FolkForm_GetCurrentConfig(agentId string) (*AgentConfig, error) {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	log.Printf("[FolkForm] [GetCurrentConfig] Đang gửi request GET current config đến FolkForm backend...")
 
@@ -4371,7 +4541,7 @@ func FolkForm_UpdateCommand(commandID string, updateData map[string]interface{})
 
 	if commandID == "" {
 		log.Printf("[FolkForm] [UpdateCommand] ❌ LỖI: commandID rỗng!")
-		return nil, errors.New("commandID không được để trống")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "commandID không được để trống")
 	}
 
 	if err := checkApiToken(); err != nil {
@@ -4379,7 +4549,7 @@ func FolkForm_UpdateCommand(commandID string, updateData map[string]interface{})
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	log.Printf("[FolkForm] [UpdateCommand] Đang gửi request PUT update command đến FolkForm backend...")
 	log.Printf("[FolkForm] [UpdateCommand] Command ID: %s", commandID)
@@ -4441,7 +4611,7 @@ func FolkForm_ClaimWorkflowCommands(agentId string, limit int, logToJob ClaimDeb
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	// Validate limit
 	if limit <= 0 {
@@ -4460,7 +4630,7 @@ func FolkForm_ClaimWorkflowCommands(agentId string, limit int, logToJob ClaimDeb
 
 	// ---------- In chi tiết REQUEST (một block để dễ thấy) ----------
 	requestBlock := fmt.Sprintf("Method: POST\nURL: %s\nHeaders: Authorization: Bearer ***, X-Active-Role-ID: %s\nBody: %s",
-		fullURL, global.ActiveRoleId, string(requestBodyJSON))
+		fullURL, global.GetPrimaryRoleId(), string(requestBodyJSON))
 	writeBlock("========== REQUEST (Claim Workflow Commands) ==========", requestBlock)
 
 	// Gọi API claim-pending
@@ -4540,7 +4710,7 @@ func FolkForm_StartWorkflowRun(workflowId, rootRefId, rootRefType string, params
 		return nil, err
 	}
 
-	client := createAuthorizedClient(longTimeout) // Dùng longTimeout vì workflow có thể chạy lâu
+	client := createAgentClient(longTimeout) // Dùng longTimeout vì workflow có thể chạy lâu
 
 	// Chuẩn bị request body
 	requestBody := map[string]interface{}{
@@ -4575,7 +4745,7 @@ func FolkForm_UpdateWorkflowCommand(commandID string, status string, result map[
 
 	if commandID == "" {
 		log.Printf("[FolkForm] [UpdateWorkflowCommand] ❌ LỖI: commandID rỗng!")
-		return nil, errors.New("commandID không được để trống")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "commandID không được để trống")
 	}
 
 	if err := checkApiToken(); err != nil {
@@ -4583,7 +4753,7 @@ func FolkForm_UpdateWorkflowCommand(commandID string, status string, result map[
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	// Chuẩn bị update data
 	updateData := map[string]interface{}{
@@ -4616,7 +4786,7 @@ func FolkForm_GetWorkflow(workflowId string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	endpoint := fmt.Sprintf("/v1/ai/workflows/find-by-id/%s", workflowId)
 	result, err := executeGetRequest(client, endpoint, nil, "Get workflow thành công")
 	return result, err
@@ -4629,7 +4799,7 @@ func FolkForm_GetStep(stepId string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	endpoint := fmt.Sprintf("/v1/ai/steps/find-by-id/%s", stepId)
 	result, err := executeGetRequest(client, endpoint, nil, "Get step thành công")
 	return result, err
@@ -4642,7 +4812,7 @@ func FolkForm_GetPromptTemplate(templateId string) (map[string]interface{}, erro
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	endpoint := fmt.Sprintf("/v1/ai/prompt-templates/find-by-id/%s", templateId)
 	result, err := executeGetRequest(client, endpoint, nil, "Get prompt template thành công")
 	return result, err
@@ -4655,7 +4825,7 @@ func FolkForm_GetProviderProfile(profileId string) (map[string]interface{}, erro
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	endpoint := fmt.Sprintf("/v1/ai/provider-profiles/find-by-id/%s", profileId)
 	result, err := executeGetRequest(client, endpoint, nil, "Get provider profile thành công")
 	return result, err
@@ -4673,7 +4843,7 @@ func FolkForm_RenderPromptForStep(stepId string, variables map[string]interface{
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	// Chuẩn bị request body
 	requestBody := map[string]interface{}{
@@ -4699,7 +4869,7 @@ func FolkForm_GetContentNode(nodeId string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	endpoint := fmt.Sprintf("/v1/content/nodes/find-by-id/%s", nodeId)
 	result, err := executeGetRequest(client, endpoint, nil, "Get content node thành công")
 	return result, err
@@ -4712,25 +4882,29 @@ func FolkForm_GetDraftNode(nodeId string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	endpoint := fmt.Sprintf("/v1/content/drafts/nodes/find-by-id/%s", nodeId)
 	result, err := executeGetRequest(client, endpoint, nil, "Get draft node thành công")
 	return result, err
 }
 
 // FolkForm_CreateWorkflowRun tạo workflow run record trong Module 2
-func FolkForm_CreateWorkflowRun(workflowId, rootRefId, rootRefType string, params map[string]interface{}) (map[string]interface{}, error) {
+// commandId (có thể rỗng) được lưu vào run để agent tìm lại run khi command được claim lại sau khi agent khởi động lại
+func FolkForm_CreateWorkflowRun(workflowId, rootRefId, rootRefType, commandId string, params map[string]interface{}) (map[string]interface{}, error) {
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	requestBody := map[string]interface{}{
 		"workflowId":  workflowId,
 		"rootRefId":   rootRefId,
 		"rootRefType": rootRefType,
 		"status":      "running",
 	}
+	if commandId != "" {
+		requestBody["commandId"] = commandId
+	}
 	if params != nil {
 		requestBody["params"] = params
 	}
@@ -4747,7 +4921,7 @@ func FolkForm_CreateStepRun(workflowRunId, stepId string, input map[string]inter
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	requestBody := map[string]interface{}{
 		"workflowRunId": workflowRunId,
 		"stepId":        stepId,
@@ -4769,7 +4943,7 @@ func FolkForm_UpdateStepRun(stepRunId string, output map[string]interface{}, sta
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	updateData := map[string]interface{}{
 		"status": status,
 	}
@@ -4790,7 +4964,7 @@ func FolkForm_CreateAIRun(stepRunId, workflowRunId, promptTemplateId, providerPr
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	requestBody := map[string]interface{}{
 		"stepRunId":         stepRunId,
 		"promptTemplateId":  promptTemplateId,
@@ -4809,18 +4983,23 @@ func FolkForm_CreateAIRun(stepRunId, workflowRunId, promptTemplateId, providerPr
 }
 
 // FolkForm_UpdateAIRun update AI run record
-func FolkForm_UpdateAIRun(aiRunId string, response string, cost float64, latencyMs int64, status string) (map[string]interface{}, error) {
+// extra (optional) chứa các field bổ sung của lần gọi, ví dụ provider/model thực sự đã dùng khi có fallback
+// (provider, providerProfileId, model, attempts, fallbackUsed)
+func FolkForm_UpdateAIRun(aiRunId string, response string, cost float64, latencyMs int64, status string, extra map[string]interface{}) (map[string]interface{}, error) {
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	updateData := map[string]interface{}{
 		"status":   status,
 		"response": response,
 		"cost":     cost,
 		"latency":  latencyMs,
 	}
+	for key, value := range extra {
+		updateData[key] = value
+	}
 
 	// Sử dụng endpoint: PUT /api/v1/ai/ai-runs/update-by-id/:id (theo pattern CRUD chuẩn)
 	endpoint := fmt.Sprintf("/v1/ai/ai-runs/update-by-id/%s", aiRunId)
@@ -4835,7 +5014,7 @@ func FolkForm_CreateGenerationBatch(stepRunId string, targetCount int) (map[stri
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	requestBody := map[string]interface{}{
 		"stepRunId":   stepRunId,
 		"targetCount": targetCount,
@@ -4853,7 +5032,7 @@ func FolkForm_CreateCandidate(generationBatchId, aiRunId, text string) (map[stri
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	requestBody := map[string]interface{}{
 		"generationBatchId": generationBatchId,
 		"createdByAIRunID":  aiRunId,
@@ -4873,7 +5052,7 @@ func FolkForm_CreateDraftNode(nodeType, text, parentDraftId, workflowRunId, cand
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	requestBody := map[string]interface{}{
 		"type": nodeType,
 		"text": text,
@@ -4895,16 +5074,20 @@ func FolkForm_CreateDraftNode(nodeType, text, parentDraftId, workflowRunId, cand
 }
 
 // FolkForm_UpdateWorkflowRun update workflow run status
+// extra: các field bổ sung (totalCost, aiCalls, budgetExceeded...), nil nếu chỉ update status
 // Sử dụng endpoint: PUT /api/v1/ai/workflow-runs/update-by-id/:id (theo pattern CRUD chuẩn)
-func FolkForm_UpdateWorkflowRun(workflowRunId string, status string) (map[string]interface{}, error) {
+func FolkForm_UpdateWorkflowRun(workflowRunId string, status string, extra map[string]interface{}) (map[string]interface{}, error) {
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 	updateData := map[string]interface{}{
 		"status": status,
 	}
+	for key, value := range extra {
+		updateData[key] = value
+	}
 
 	endpoint := fmt.Sprintf("/v1/ai/workflow-runs/update-by-id/%s", workflowRunId)
 	result, err := executePutRequest(client, endpoint, updateData, nil,
@@ -4912,6 +5095,86 @@ func FolkForm_UpdateWorkflowRun(workflowRunId string, status string) (map[string
 	return result, err
 }
 
+// FolkForm_FindRunningWorkflowRun tìm workflow run đang "running" hoặc "waiting_review" của command (run mới nhất)
+// Dùng khi command được claim lại sau khi agent chết giữa chừng hoặc sau khi người duyệt quyết định, để chạy tiếp thay vì chạy lại từ đầu
+// Sử dụng endpoint: GET /api/v1/ai/workflow-runs/find?filter={"commandId":"...","status":{"$in":["running","waiting_review"]}}
+// Trả về nil, nil nếu không có run nào
+func FolkForm_FindRunningWorkflowRun(commandId string) (map[string]interface{}, error) {
+	if err := checkApiToken(); err != nil {
+		return nil, err
+	}
+
+	filter, _ := json.Marshal(map[string]interface{}{
+		"commandId": commandId,
+		"status":    map[string]interface{}{"$in": []string{"running", "waiting_review"}},
+	})
+	params := map[string]string{
+		"filter":  string(filter),
+		"options": `{"sort":{"createdAt":-1},"limit":1}`,
+	}
+
+	client := createAgentClient(defaultTimeout)
+	result, err := executeGetRequest(client, "/v1/ai/workflow-runs/find", params, "")
+	if err != nil {
+		return nil, err
+	}
+	items := findResultItems(result)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	run, _ := items[0].(map[string]interface{})
+	return run, nil
+}
+
+// FolkForm_GetStepRuns lấy tất cả step run của một workflow run
+// Sử dụng endpoint: GET /api/v1/ai/step-runs/find?filter={"workflowRunId":"..."}
+func FolkForm_GetStepRuns(workflowRunId string) ([]interface{}, error) {
+	if err := checkApiToken(); err != nil {
+		return nil, err
+	}
+
+	filter, _ := json.Marshal(map[string]interface{}{"workflowRunId": workflowRunId})
+	params := map[string]string{
+		"filter":  string(filter),
+		"options": `{"sort":{"createdAt":1}}`,
+	}
+
+	client := createAgentClient(defaultTimeout)
+	result, err := executeGetRequest(client, "/v1/ai/step-runs/find", params, "")
+	if err != nil {
+		return nil, err
+	}
+	return findResultItems(result), nil
+}
+
+// FolkForm_GetStepRun lấy một step run (dùng để đọc kết quả duyệt của HUMAN_REVIEW step khi workflow chạy tiếp)
+// Sử dụng endpoint: GET /api/v1/ai/step-runs/find-by-id/:id (theo pattern CRUD chuẩn)
+func FolkForm_GetStepRun(stepRunId string) (map[string]interface{}, error) {
+	if err := checkApiToken(); err != nil {
+		return nil, err
+	}
+
+	client := createAgentClient(defaultTimeout)
+	endpoint := fmt.Sprintf("/v1/ai/step-runs/find-by-id/%s", stepRunId)
+	result, err := executeGetRequest(client, endpoint, nil, "")
+	return result, err
+}
+
+// findResultItems lấy danh sách item từ response của endpoint find (data là mảng, hoặc data.items / data.data)
+func findResultItems(result map[string]interface{}) []interface{} {
+	if dataMap, ok := result["data"].(map[string]interface{}); ok {
+		if items, ok := dataMap["items"].([]interface{}); ok {
+			return items
+		}
+		if items, ok := dataMap["data"].([]interface{}); ok {
+			return items
+		}
+		return nil
+	}
+	items, _ := result["data"].([]interface{})
+	return items
+}
+
 // FolkForm_UpdateWorkflowCommandHeartbeat update heartbeat và progress của workflow command
 // Tham số:
 // - agentId: ID của agent
@@ -4920,14 +5183,14 @@ func FolkForm_UpdateWorkflowRun(workflowRunId string, status string) (map[string
 // Trả về result map và error
 func FolkForm_UpdateWorkflowCommandHeartbeat(agentId string, commandID string, progress map[string]interface{}) (map[string]interface{}, error) {
 	if commandID == "" {
-		return nil, errors.New("commandID không được để trống")
+		return nil, apierror.NewValidation(apierror.SystemFolkForm, "", "commandID không được để trống")
 	}
 
 	if err := checkApiToken(); err != nil {
 		return nil, err
 	}
 
-	client := createAuthorizedClient(defaultTimeout)
+	client := createAgentClient(defaultTimeout)
 
 	// Chuẩn bị request body
 	requestBody := map[string]interface{}{
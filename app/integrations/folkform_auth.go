@@ -0,0 +1,270 @@
+package integrations
+
+import (
+	"agent_pancake/app/integrations/apierror"
+	"agent_pancake/global"
+	"agent_pancake/utility/httpclient"
+	"agent_pancake/utility/secrets"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quản lý phiên đăng nhập FolkForm:
+// - Token FolkForm (JWT) được lưu trong global (GetApiToken/SetApiToken) kèm thời điểm hết hạn
+// - Firebase ID Token được cache và làm mới bằng refresh token trước khi hết hạn
+// - Đăng nhập lại (khi token hết hạn hoặc server trả 401) dùng single-flight:
+//   nhiều job cùng gặp 401 chỉ tạo ra một lần đăng nhập, các job còn lại đợi và dùng chung kết quả
+
+const (
+	folkFormTokenRefreshSkew = 2 * time.Minute // Làm mới token FolkForm khi còn ít hơn khoảng này
+	firebaseTokenRefreshSkew = 5 * time.Minute // Làm mới Firebase ID Token khi còn ít hơn khoảng này
+)
+
+// loginCall là một lần đăng nhập đang chạy, các luồng đến sau đợi done rồi dùng chung result/err
+type loginCall struct {
+	done   chan struct{}
+	result map[string]interface{}
+	err    error
+}
+
+var (
+	loginFlight   *loginCall
+	loginFlightMu sync.Mutex
+)
+
+// firebaseSession cache Firebase ID Token và refresh token của lần đăng nhập gần nhất
+type firebaseSession struct {
+	mu           sync.Mutex
+	idToken      string
+	refreshToken string
+	expiresAt    time.Time
+}
+
+var firebaseAuth = &firebaseSession{}
+
+// FolkForm_Login đăng nhập vào FolkForm backend (Firebase → /v1/auth/login/firebase)
+// Nếu đang có một luồng đăng nhập khác chạy, hàm đợi luồng đó xong và trả về cùng kết quả
+// thay vì đăng nhập thêm lần nữa (tránh nhiều job cùng đăng nhập khi token hết hạn)
+func FolkForm_Login() (map[string]interface{}, error) {
+	loginFlightMu.Lock()
+	if call := loginFlight; call != nil {
+		loginFlightMu.Unlock()
+		log.Printf("[FolkForm] [Login] Đang có luồng đăng nhập khác, đợi kết quả...")
+		<-call.done
+		return call.result, call.err
+	}
+	call := &loginCall{done: make(chan struct{})}
+	loginFlight = call
+	loginFlightMu.Unlock()
+
+	defer func() {
+		loginFlightMu.Lock()
+		loginFlight = nil
+		loginFlightMu.Unlock()
+		close(call.done)
+	}()
+
+	call.result, call.err = folkFormLogin()
+	return call.result, call.err
+}
+
+// reauthenticateFolkForm đăng nhập lại khi staleToken bị server từ chối hoặc sắp hết hạn
+// Nếu token hiện tại đã khác staleToken (luồng khác vừa đăng nhập lại) thì dùng luôn token đó
+// Trả về token mới để request gốc gửi lại
+func reauthenticateFolkForm(staleToken string) (string, error) {
+	if current := global.GetApiToken(); current != "" && current != staleToken {
+		return current, nil
+	}
+
+	log.Printf("[FolkForm] 🔑 Token không còn hợp lệ, đăng nhập lại...")
+	if _, err := FolkForm_Login(); err != nil {
+		// Xóa token cũ để các job bỏ qua (EnsureApiToken) cho đến khi CheckInJob đăng nhập lại được
+		global.ClearApiToken(staleToken)
+		log.Printf("[FolkForm] ❌ Đăng nhập lại thất bại: %v", err)
+		return "", err
+	}
+
+	token := global.GetApiToken()
+	if token == "" {
+		return "", &apierror.ErrUnauthorized{System: apierror.SystemFolkForm, Message: "Đăng nhập lại thành công nhưng không nhận được token"}
+	}
+	log.Printf("[FolkForm] ✅ Đã đăng nhập lại, dùng token mới")
+	return token, nil
+}
+
+// isTokenRejected kiểm tra lỗi có phải FolkForm từ chối token (401) không
+// 403 là thiếu quyền, đăng nhập lại cũng không thay đổi kết quả nên không tính
+func isTokenRejected(err error) bool {
+	var unauthorized *apierror.ErrUnauthorized
+	return errors.As(err, &unauthorized) && unauthorized.System == apierror.SystemFolkForm && unauthorized.Status == http.StatusUnauthorized
+}
+
+// bearerToken lấy token đang gắn trong header Authorization của client
+func bearerToken(client *httpclient.HttpClient) string {
+	return strings.TrimPrefix(client.Headers["Authorization"], "Bearer ")
+}
+
+// FolkForm_EnsureLoggedIn đảm bảo đang có token FolkForm dùng được
+// - Chưa có token → đăng nhập
+// - Token sắp hết hạn → làm mới trước
+// - Token bị server từ chối (401/403 ở /v1/auth/roles) → đăng nhập lại
+// Lỗi mạng khi kiểm tra không xóa token (lần gọi sau sẽ kiểm tra lại)
+func FolkForm_EnsureLoggedIn() error {
+	token := global.GetApiToken()
+	if token == "" {
+		log.Printf("[FolkForm] Chưa có token, tiến hành đăng nhập...")
+		_, err := FolkForm_Login()
+		return err
+	}
+
+	if err := checkApiToken(); err != nil {
+		return err
+	}
+	token = global.GetApiToken()
+
+	status, err := verifyFolkFormToken(token)
+	if err != nil {
+		log.Printf("[FolkForm] ⚠️ Không kiểm tra được token (giữ token hiện tại): %v", err)
+		return err
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		log.Printf("[FolkForm] Token không hợp lệ hoặc đã hết hạn (status: %d)", status)
+		_, err := reauthenticateFolkForm(token)
+		return err
+	}
+	return nil
+}
+
+// verifyFolkFormToken gọi endpoint nhẹ /v1/auth/roles để kiểm tra token còn được chấp nhận không
+// Trả về status code của response (lỗi chỉ khi không gọi được API)
+func verifyFolkFormToken(token string) (int, error) {
+	client := httpclient.NewHttpClient(global.GlobalConfig.ApiBaseUrl, 5*time.Second) // Timeout ngắn vì chỉ verify
+	client.SetHeader("Authorization", "Bearer "+token)
+
+	resp, err := client.GET("/v1/auth/roles", nil)
+	if err != nil {
+		return 0, apierror.NewNetwork(apierror.SystemFolkForm, "/v1/auth/roles", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// parseJwtExpiry đọc claim "exp" trong payload của JWT (không verify chữ ký)
+// Trả về zero time nếu token không phải JWT hoặc không có exp
+func parseJwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0)
+}
+
+// Firebase_GetIdToken trả về Firebase ID Token để đăng nhập FolkForm
+// Thứ tự: dùng token đã cache nếu còn hạn → làm mới bằng refresh token → đăng nhập lại bằng email/password
+// Làm mới bằng refresh token không tính vào quota verify password của Firebase
+func Firebase_GetIdToken() (string, error) {
+	firebaseAuth.mu.Lock()
+	idToken, refreshToken, expiresAt := firebaseAuth.idToken, firebaseAuth.refreshToken, firebaseAuth.expiresAt
+	firebaseAuth.mu.Unlock()
+
+	if idToken != "" && time.Until(expiresAt) > firebaseTokenRefreshSkew {
+		log.Printf("[Firebase] Dùng ID Token đã cache (hết hạn lúc %s)", expiresAt.Format(time.RFC3339))
+		return idToken, nil
+	}
+
+	if refreshToken != "" {
+		idToken, err := firebaseRefreshIdToken(refreshToken)
+		if err == nil {
+			return idToken, nil
+		}
+		log.Printf("[Firebase] ⚠️ Không làm mới được ID Token, đăng nhập lại bằng email/password: %v", err)
+	}
+
+	return firebaseSignInWithPassword()
+}
+
+// firebaseRefreshIdToken đổi refresh token lấy ID Token mới qua Secure Token API
+func firebaseRefreshIdToken(refreshToken string) (string, error) {
+	endpoint := "/v1/token"
+	log.Printf("[Firebase] Làm mới ID Token bằng refresh token...")
+
+	client := httpclient.NewHttpClient(global.GlobalConfig.FirebaseTokenBaseUrl, defaultTimeout)
+	data := map[string]interface{}{
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+	}
+	resp, err := client.POST(endpoint, data, map[string]string{"key": global.GlobalConfig.FirebaseApiKey})
+	if err != nil {
+		return "", apierror.NewNetwork(apierror.SystemFirebase, endpoint, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		// Refresh token bị thu hồi / hết hạn → xóa để lần sau đăng nhập bằng password
+		firebaseAuth.mu.Lock()
+		firebaseAuth.refreshToken = ""
+		firebaseAuth.mu.Unlock()
+		return "", apierror.FromResponse(apierror.SystemFirebase, endpoint, resp.StatusCode, resp.Header, bodyBytes)
+	}
+
+	var result map[string]interface{}
+	if err := httpclient.ParseJSONResponse(resp, &result); err != nil {
+		return "", apierror.NewNetwork(apierror.SystemFirebase, endpoint, err)
+	}
+
+	idToken, _ := result["id_token"].(string)
+	if idToken == "" {
+		return "", &apierror.ErrUpstream{System: apierror.SystemFirebase, Endpoint: endpoint, Status: http.StatusOK, Message: "Không tìm thấy id_token trong phản hồi từ Firebase"}
+	}
+	newRefreshToken, _ := result["refresh_token"].(string)
+	expiresIn, _ := result["expires_in"].(string)
+	storeFirebaseSession(idToken, newRefreshToken, expiresIn)
+
+	log.Printf("[Firebase] ✅ Đã làm mới ID Token (expires in: %ss)", expiresIn)
+	return idToken, nil
+}
+
+// invalidateFirebaseIdToken bỏ ID Token đã cache (giữ refresh token để làm mới)
+func invalidateFirebaseIdToken() {
+	firebaseAuth.mu.Lock()
+	defer firebaseAuth.mu.Unlock()
+	firebaseAuth.idToken = ""
+	firebaseAuth.expiresAt = time.Time{}
+}
+
+// storeFirebaseSession lưu ID Token, refresh token và thời điểm hết hạn vào cache
+// expiresIn là số giây dạng string (định dạng Firebase trả về), mặc định 1 giờ nếu không parse được
+func storeFirebaseSession(idToken, refreshToken, expiresIn string) {
+	secrets.Register(idToken, refreshToken)
+	ttl := time.Hour
+	if seconds, err := strconv.Atoi(expiresIn); err == nil && seconds > 0 {
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	firebaseAuth.mu.Lock()
+	defer firebaseAuth.mu.Unlock()
+	firebaseAuth.idToken = idToken
+	if refreshToken != "" {
+		firebaseAuth.refreshToken = refreshToken
+	}
+	firebaseAuth.expiresAt = time.Now().Add(ttl)
+}
@@ -0,0 +1,153 @@
+package integrations
+
+import (
+	"agent_pancake/global"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Organization context (multi-role):
+// Agent có thể phục vụ nhiều organization (mỗi role ↔ một organization) theo cấu hình AGENT_ROLE_IDS.
+// Sync job chạy lần lượt cho từng role với ctx mang role đó (FolkForm_WithRoleId): createAuthorizedClient(ctx, ...)
+// gửi X-Active-Role-ID của role trong ctx, ctx không mang role thì dùng role mặc định.
+// Role đi theo ctx của từng lần chạy nên các job của các organization khác nhau chạy song song được.
+// Các API cấp agent dùng createAgentClient (role mặc định).
+
+const roleIdsCacheTTL = 5 * time.Minute // Thời gian cache danh sách roles khi cấu hình "all"
+
+// roleIdContextKey là key lưu role của organization context trong context.Context
+type roleIdContextKey struct{}
+
+var (
+	// Cache danh sách role ID khi AGENT_ROLE_IDS=all (tránh gọi /v1/auth/roles mỗi lần job chạy)
+	resolvedRoleIds   []string
+	resolvedRoleIdsAt time.Time
+	resolvedRoleIdsMu sync.Mutex
+)
+
+// configuredPrimaryRoleId trả về role đầu tiên trong AGENT_ROLE_IDS (rỗng nếu không cấu hình danh sách cụ thể)
+func configuredPrimaryRoleId() string {
+	if global.GlobalConfig == nil {
+		return ""
+	}
+	if all, roleIds := global.GlobalConfig.RoleIdList(); !all && len(roleIds) > 0 {
+		return roleIds[0]
+	}
+	return ""
+}
+
+// useRoleId lưu role mặc định của agent
+// Nếu AGENT_ROLE_IDS có danh sách cụ thể thì role đầu tiên trong danh sách luôn được ưu tiên
+// (role lấy từ login response / backend chỉ dùng khi không cấu hình)
+func useRoleId(roleId string) {
+	if configured := configuredPrimaryRoleId(); configured != "" {
+		roleId = configured
+	}
+	global.SetActiveRoleId(roleId)
+}
+
+// roleIdOf lấy role ID từ một phần tử trong danh sách roles (id, roleId hoặc _id)
+func roleIdOf(role interface{}) string {
+	roleMap, ok := role.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	for _, key := range []string{"id", "roleId", "_id"} {
+		if roleId, ok := roleMap[key].(string); ok && roleId != "" {
+			return roleId
+		}
+	}
+	return ""
+}
+
+// findRoleById tìm role có ID roleId trong danh sách roles (nil nếu không có)
+func findRoleById(roles []interface{}, roleId string) map[string]interface{} {
+	for _, role := range roles {
+		if roleIdOf(role) == roleId {
+			roleMap, _ := role.(map[string]interface{})
+			return roleMap
+		}
+	}
+	return nil
+}
+
+// FolkForm_EnsureActiveRoleId đảm bảo đã có role mặc định (lấy từ AGENT_ROLE_IDS hoặc role đầu tiên của backend)
+func FolkForm_EnsureActiveRoleId() string {
+	ensureActiveRoleId()
+	return global.GetPrimaryRoleId()
+}
+
+// FolkForm_ResolveRoleIds trả về danh sách role mà sync job cần chạy theo AGENT_ROLE_IDS
+// - Không cấu hình: chỉ role mặc định
+// - "all": tất cả roles của tài khoản (cache roleIdsCacheTTL)
+// - Danh sách cụ thể: đúng danh sách đó (cảnh báo nếu role không có trong tài khoản)
+func FolkForm_ResolveRoleIds() ([]string, error) {
+	all, configured := global.GlobalConfig.RoleIdList()
+	if !all && len(configured) == 0 {
+		if roleId := FolkForm_EnsureActiveRoleId(); roleId != "" {
+			return []string{roleId}, nil
+		}
+		return nil, nil
+	}
+
+	resolvedRoleIdsMu.Lock()
+	defer resolvedRoleIdsMu.Unlock()
+	if resolvedRoleIds != nil && time.Since(resolvedRoleIdsAt) < roleIdsCacheTTL {
+		return resolvedRoleIds, nil
+	}
+
+	roles, err := FolkForm_GetRoles()
+	if err != nil {
+		if !all {
+			// Không kiểm tra được với backend → vẫn dùng danh sách cấu hình
+			return configured, nil
+		}
+		return nil, err
+	}
+
+	var roleIds []string
+	if all {
+		for _, role := range roles {
+			if roleId := roleIdOf(role); roleId != "" {
+				roleIds = append(roleIds, roleId)
+			}
+		}
+		log.Printf("[FolkForm] AGENT_ROLE_IDS=all → %d roles: %v", len(roleIds), roleIds)
+	} else {
+		for _, roleId := range configured {
+			if findRoleById(roles, roleId) == nil {
+				log.Printf("[FolkForm] ⚠️ CẢNH BÁO: Role %s trong AGENT_ROLE_IDS không có trong danh sách roles của tài khoản", roleId)
+			}
+		}
+		roleIds = configured
+	}
+
+	resolvedRoleIds = roleIds
+	resolvedRoleIdsAt = time.Now()
+	return roleIds, nil
+}
+
+// FolkForm_WithRoleId trả về ctx mang organization context của roleId:
+// mọi request tạo bởi createAuthorizedClient(ctx, ...) gửi X-Active-Role-ID = roleId
+func FolkForm_WithRoleId(ctx context.Context, roleId string) context.Context {
+	return context.WithValue(ctx, roleIdContextKey{}, roleId)
+}
+
+// FolkForm_RoleIdFromContext trả về role của organization context trong ctx (rỗng nếu ctx không mang role)
+func FolkForm_RoleIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	roleId, _ := ctx.Value(roleIdContextKey{}).(string)
+	return roleId
+}
+
+// activeRoleId trả về role dùng cho header X-Active-Role-ID: role trong ctx, ngoài context là role mặc định
+func activeRoleId(ctx context.Context) string {
+	if roleId := FolkForm_RoleIdFromContext(ctx); roleId != "" {
+		return roleId
+	}
+	return global.GetPrimaryRoleId()
+}
@@ -6,11 +6,13 @@ Dữ liệu được lưu trong global.PanCake_FbPages và được bảo vệ b
 package integrations
 
 import (
+	"context"
 	"errors"
 	"log"
 	"time"
 
 	"agent_pancake/global"
+	"agent_pancake/utility/secrets"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -20,7 +22,7 @@ import (
 // Dữ liệu được bảo vệ bởi mutex để đảm bảo thread-safe
 // Trả về:
 //   - error: Lỗi nếu có trong quá trình đồng bộ
-func Local_SyncPagesFolkformToLocal() (resultErr error) {
+func Local_SyncPagesFolkformToLocal(ctx context.Context) (resultErr error) {
 	limit := 50
 	page := 0
 
@@ -29,7 +31,7 @@ func Local_SyncPagesFolkformToLocal() (resultErr error) {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := FolkForm_GetFbPages(page, limit)
+		resultPages, err := FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			return errors.New("Lỗi khi lấy danh sách trang Facebook")
 		}
@@ -62,6 +64,7 @@ func Local_SyncPagesFolkformToLocal() (resultErr error) {
 
 					// Append cloudFbPage to global.PanCake_FbPages
 					global.PanCake_FbPages = append(global.PanCake_FbPages, cloudFbPage)
+					secrets.Register(cloudFbPage.PageAccessToken, cloudFbPage.AccessToken)
 				}
 				global.PanCake_FbPagesMu.Unlock()
 			}
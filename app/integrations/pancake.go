@@ -11,17 +11,35 @@ Tất cả các hàm đều có retry logic và sử dụng adaptive rate limite
 package integrations
 
 import (
+	"agent_pancake/app/integrations/apierror"
 	apputility "agent_pancake/app/utility"
 	"agent_pancake/global"
 	"agent_pancake/utility/httpclient"
+	"agent_pancake/utility/secrets"
 	"encoding/json"
-	"errors"
 	"io"
 	"log"
 	"strconv"
 	"time"
 )
 
+// maxRetryAfter giới hạn thời gian đợi theo Retry-After trước khi thử lại (server có thể gửi Retry-After rất dài)
+const maxRetryAfter = 60 * time.Second
+
+// waitRetryAfter đợi theo Retry-After của lỗi rate limit (tối đa maxRetryAfter) trước lần thử tiếp theo
+// Lỗi khác hoặc server không gửi Retry-After → không đợi thêm, rate limiter tự giãn nhịp gọi API
+func waitRetryAfter(tag string, err error) {
+	delay := apierror.RetryAfter(err)
+	if delay <= 0 {
+		return
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	log.Printf("%s ⏳ Bị giới hạn tốc độ, đợi %v theo Retry-After trước khi thử lại", tag, delay)
+	time.Sleep(delay)
+}
+
 // PanCake_GetFbPages lấy danh sách pages từ server Pancake
 // Tham số:
 //   - access_token: Access token của user để truy cập Pancake API
@@ -40,13 +58,16 @@ func PanCake_GetFbPages(access_token string) (result map[string]interface{}, err
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/v1/pages"
+	var lastErr error
 	for {
 		requestCount++
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[Pancake] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -56,6 +77,7 @@ func PanCake_GetFbPages(access_token string) (result map[string]interface{}, err
 		// Gửi yêu cầu GET
 		resp, err := client.GET("/v1/pages", params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			if requestCount >= 3 {
 				logError("[Pancake] ❌ LỖI khi gọi API GET (lần thử %d/5): %v", requestCount, err)
 			}
@@ -88,6 +110,12 @@ func PanCake_GetFbPages(access_token string) (result map[string]interface{}, err
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancake, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[Pancake] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
+			waitRetryAfter("[Pancake]", lastErr)
 			continue
 		}
 
@@ -95,6 +123,7 @@ func PanCake_GetFbPages(access_token string) (result map[string]interface{}, err
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, readErr)
 			if requestCount >= 3 {
 				logError("[Pancake] ❌ Không thể đọc response body (lần thử %d/5): %v", requestCount, readErr)
 			}
@@ -103,6 +132,7 @@ func PanCake_GetFbPages(access_token string) (result map[string]interface{}, err
 
 		var result map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			if requestCount >= 3 {
 				logError("[Pancake] ❌ LỖI khi phân tích phản hồi JSON (lần thử %d/5): %v", requestCount, err)
 			}
@@ -116,6 +146,9 @@ func PanCake_GetFbPages(access_token string) (result map[string]interface{}, err
 		}
 		success := result["success"] == true
 		rateLimiter.RecordResponse(statusCode, success, errorCode)
+		if !success {
+			lastErr = apierror.FromResult(apierror.SystemPancake, apiPath, statusCode, result)
+		}
 
 		if result["success"] == true {
 			return result, nil
@@ -132,7 +165,7 @@ func PanCake_GetFbPages(access_token string) (result map[string]interface{}, err
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 	}
 }
@@ -157,13 +190,16 @@ func PanCake_GeneratePageAccessToken(page_id string, access_token string) (resul
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/v1/pages/:page_id/generate_page_access_token"
+	var lastErr error
 	for {
 		requestCount++
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[Pancake] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -175,6 +211,7 @@ func PanCake_GeneratePageAccessToken(page_id string, access_token string) (resul
 		// Gửi yêu cầu POST
 		resp, err := client.POST(endpoint, nil, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			if requestCount >= 3 {
 				logError("[Pancake] ❌ LỖI khi gọi API POST (lần thử %d/5): %v", requestCount, err)
 			}
@@ -207,6 +244,12 @@ func PanCake_GeneratePageAccessToken(page_id string, access_token string) (resul
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancake, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[Pancake] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
+			waitRetryAfter("[Pancake]", lastErr)
 			continue
 		}
 
@@ -214,6 +257,7 @@ func PanCake_GeneratePageAccessToken(page_id string, access_token string) (resul
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, readErr)
 			if requestCount >= 3 {
 				logError("[Pancake] ❌ Không thể đọc response body (lần thử %d/5): %v", requestCount, readErr)
 			}
@@ -222,6 +266,7 @@ func PanCake_GeneratePageAccessToken(page_id string, access_token string) (resul
 
 		var result map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			if requestCount >= 3 {
 				logError("[Pancake] ❌ LỖI khi phân tích phản hồi JSON (lần thử %d/5): %v", requestCount, err)
 			}
@@ -235,8 +280,14 @@ func PanCake_GeneratePageAccessToken(page_id string, access_token string) (resul
 		}
 		success := result["success"] == true
 		rateLimiter.RecordResponse(statusCode, success, errorCode)
+		if !success {
+			lastErr = apierror.FromResult(apierror.SystemPancake, apiPath, statusCode, result)
+		}
 
 		if result["success"] == true {
+			if pageAccessToken, ok := result["page_access_token"].(string); ok {
+				secrets.Register(pageAccessToken)
+			}
 			return result, nil
 		}
 
@@ -255,7 +306,7 @@ func PanCake_GeneratePageAccessToken(page_id string, access_token string) (resul
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 	}
 }
@@ -272,6 +323,9 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/public_api/v2/pages/:page_id/conversations"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[Pancake] [Lần thử %d/5] Bắt đầu lấy danh sách conversations", requestCount)
@@ -279,7 +333,7 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[Pancake] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 	Start:
@@ -296,7 +350,7 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 			Local_UpdatePagesAccessToken(page_id)
 			goto Start
 		}
-		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (length: %d)", requestCount, len(page_access_token))
+		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (fp: %s)", requestCount, secrets.Fingerprint(page_access_token))
 
 		// Thiết lập params
 		params := map[string]string{
@@ -326,7 +380,7 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -335,11 +389,12 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 
 		endpoint := "/public_api/v2/pages/" + page_id + "/conversations"
 		log.Printf("[Pancake] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[Pancake] [Lần thử %d/5] Request params: page_access_token (length: %d), last_conversation_id: %s", requestCount, len(page_access_token), last_conversation_id)
+		log.Printf("[Pancake] [Lần thử %d/5] Request params: page_access_token (fp: %s), last_conversation_id: %s", requestCount, secrets.Fingerprint(page_access_token), last_conversation_id)
 
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -374,7 +429,13 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancake, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[Pancake] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[Pancake] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách cuộc trò chuyện thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[Pancake]", lastErr)
 			continue
 		}
 
@@ -383,12 +444,14 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, readErr)
 			log.Printf("[Pancake] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
 
 		var result map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 			continue
@@ -401,6 +464,9 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 		}
 		success := result["success"] == true
 		rateLimiter.RecordResponse(statusCode, success, errorCode)
+		if !success {
+			lastErr = apierror.FromResult(apierror.SystemPancake, apiPath, statusCode, result)
+		}
 
 		if result["success"] == true {
 			log.Printf("[Pancake] Lấy danh sách conversations thành công - page_id: %s", page_id)
@@ -432,7 +498,7 @@ func Pancake_GetConversations_v2(page_id string, last_conversation_id string, si
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 	}
@@ -450,6 +516,9 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/public_api/v1/pages/:page_id/conversations/:conversation_id/messages"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[Pancake] [Lần thử %d/5] Bắt đầu lấy danh sách messages", requestCount)
@@ -457,7 +526,7 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[Pancake] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 	Start:
@@ -477,7 +546,7 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 			Local_UpdatePagesAccessToken(page_id)
 			goto Start
 		}
-		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (length: %d)", requestCount, len(page_access_token))
+		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (fp: %s)", requestCount, secrets.Fingerprint(page_access_token))
 
 		// Thiết lập params
 		params := map[string]string{
@@ -493,11 +562,12 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 
 		endpoint := "/public_api/v1/pages/" + page_id + "/conversations/" + conversation_id + "/messages"
 		log.Printf("[Pancake] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[Pancake] [Lần thử %d/5] Request params: page_access_token (length: %d), customer_id: %s", requestCount, len(page_access_token), customer_id)
+		log.Printf("[Pancake] [Lần thử %d/5] Request params: page_access_token (fp: %s), customer_id: %s", requestCount, secrets.Fingerprint(page_access_token), customer_id)
 
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -532,7 +602,13 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancake, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[Pancake] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[Pancake] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách tin nhắn thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[Pancake]", lastErr)
 			continue
 		}
 
@@ -541,12 +617,14 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, readErr)
 			log.Printf("[Pancake] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
 
 		var result map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 			continue
@@ -559,6 +637,9 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 		}
 		success := result["success"] == true
 		rateLimiter.RecordResponse(statusCode, success, errorCode)
+		if !success {
+			lastErr = apierror.FromResult(apierror.SystemPancake, apiPath, statusCode, result)
+		}
 
 		if result["success"] == true {
 			log.Printf("[Pancake] Lấy danh sách messages thành công - page_id: %s, conversation_id: %s", page_id, conversation_id)
@@ -588,7 +669,7 @@ func Pancake_GetMessages(page_id string, conversation_id string, customer_id str
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
-			return result, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return result, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 	}
@@ -609,6 +690,9 @@ func Pancake_GetPosts(page_id string, page_number int, page_size int, since int6
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/public_api/v1/pages/:page_id/posts"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[Pancake] [Lần thử %d/5] Bắt đầu lấy danh sách posts", requestCount)
@@ -616,7 +700,7 @@ func Pancake_GetPosts(page_id string, page_number int, page_size int, since int6
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[Pancake] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 	Start:
@@ -632,7 +716,7 @@ func Pancake_GetPosts(page_id string, page_number int, page_size int, since int6
 			Local_UpdatePagesAccessToken(page_id)
 			goto Start
 		}
-		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (length: %d)", requestCount, len(page_access_token))
+		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (fp: %s)", requestCount, secrets.Fingerprint(page_access_token))
 
 		// Thiết lập params (since và until là REQUIRED)
 		params := map[string]string{
@@ -660,6 +744,7 @@ func Pancake_GetPosts(page_id string, page_number int, page_size int, since int6
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -694,7 +779,13 @@ func Pancake_GetPosts(page_id string, page_number int, page_size int, since int6
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancake, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[Pancake] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[Pancake] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách posts thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[Pancake]", lastErr)
 			continue
 		}
 
@@ -702,12 +793,14 @@ func Pancake_GetPosts(page_id string, page_number int, page_size int, since int6
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, readErr)
 			log.Printf("[Pancake] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
 
 		var result map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 			continue
@@ -727,6 +820,7 @@ func Pancake_GetPosts(page_id string, page_number int, page_size int, since int6
 			return result, nil
 		} else {
 			logError("[Pancake] [Lần thử %d/5] ❌ Response không thành công: %+v", requestCount, result)
+			lastErr = apierror.FromResult(apierror.SystemPancake, apiPath, statusCode, result)
 			continue
 		}
 	}
@@ -747,6 +841,9 @@ func Pancake_GetCustomers(page_id string, page_number int, page_size int, since
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/public_api/v1/pages/:page_id/page_customers"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[Pancake] [Lần thử %d/5] Bắt đầu lấy danh sách customers", requestCount)
@@ -754,7 +851,7 @@ func Pancake_GetCustomers(page_id string, page_number int, page_size int, since
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[Pancake] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancake, apiPath, 5, lastErr)
 		}
 
 	Start:
@@ -770,7 +867,7 @@ func Pancake_GetCustomers(page_id string, page_number int, page_size int, since
 			Local_UpdatePagesAccessToken(page_id)
 			goto Start
 		}
-		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (length: %d)", requestCount, len(page_access_token))
+		log.Printf("[Pancake] [Lần thử %d/5] Đã lấy được page_access_token (fp: %s)", requestCount, secrets.Fingerprint(page_access_token))
 
 		// Thiết lập params (since và until là REQUIRED)
 		params := map[string]string{
@@ -798,6 +895,7 @@ func Pancake_GetCustomers(page_id string, page_number int, page_size int, since
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -832,7 +930,13 @@ func Pancake_GetCustomers(page_id string, page_number int, page_size int, since
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancake, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[Pancake] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[Pancake] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách customers thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[Pancake]", lastErr)
 			continue
 		}
 
@@ -840,12 +944,14 @@ func Pancake_GetCustomers(page_id string, page_number int, page_size int, since
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, readErr)
 			log.Printf("[Pancake] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
 
 		var result map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancake, apiPath, err)
 			logError("[Pancake] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 			log.Printf("[Pancake] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 			continue
@@ -865,6 +971,7 @@ func Pancake_GetCustomers(page_id string, page_number int, page_size int, since
 			return result, nil
 		} else {
 			logError("[Pancake] [Lần thử %d/5] ❌ Response không thành công: %+v", requestCount, result)
+			lastErr = apierror.FromResult(apierror.SystemPancake, apiPath, statusCode, result)
 			continue
 		}
 	}
@@ -13,10 +13,12 @@ Tất cả các hàm đều có retry logic và sử dụng adaptive rate limite
 package integrations
 
 import (
+	"agent_pancake/app/integrations/apierror"
 	apputility "agent_pancake/app/utility"
+	"agent_pancake/global"
 	"agent_pancake/utility/httpclient"
+	"agent_pancake/utility/secrets"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -28,7 +30,7 @@ import (
 // Trả về: []interface{} chứa danh sách shops
 func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 	// Khởi tạo client
-	client := httpclient.NewHttpClient("https://pos.pages.fm/api/v1", 60*time.Second)
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
 
 	// Thiết lập params
 	params := map[string]string{
@@ -37,13 +39,16 @@ func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/shops"
+	var lastErr error
 	for {
 		requestCount++
 
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -53,6 +58,7 @@ func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 		// Gửi yêu cầu GET
 		resp, err := client.GET("/shops", params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 			if requestCount >= 3 {
 				logError("[PancakePOS] ❌ LỖI khi gọi API GET (lần thử %d/5): %v", requestCount, err)
 			}
@@ -85,6 +91,12 @@ func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
+			waitRetryAfter("[PancakePOS]", lastErr)
 			continue
 		}
 
@@ -92,6 +104,7 @@ func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
 			if requestCount >= 3 {
 				logError("[PancakePOS] ❌ Không thể đọc response body (lần thử %d/5): %v", requestCount, readErr)
 			}
@@ -113,6 +126,7 @@ func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 				// Nếu không có field "shops", có thể là array trực tiếp
 				// Thử parse lại như array
 				if err := json.Unmarshal(bodyBytes, &shopsArray); err != nil {
+					lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 					logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 					log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 					continue
@@ -121,6 +135,7 @@ func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 		} else {
 			// Nếu không parse được như object, thử parse như array
 			if err := json.Unmarshal(bodyBytes, &shopsArray); err != nil {
+				lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 				logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 				log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 				continue
@@ -141,10 +156,10 @@ func PancakePos_GetShops(apiKey string) (shops []interface{}, err error) {
 // Trả về: []interface{} chứa danh sách warehouses
 func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface{}, err error) {
 	log.Printf("[PancakePOS] Bắt đầu lấy danh sách warehouses từ Pancake POS - shopId: %d", shopId)
-	log.Printf("[PancakePOS] Pancake POS Base URL: https://pos.pages.fm/api/v1")
+	log.Printf("[PancakePOS] Pancake POS Base URL: %s", global.GlobalConfig.PancakePosBaseUrl)
 
 	// Khởi tạo client
-	client := httpclient.NewHttpClient("https://pos.pages.fm/api/v1", 60*time.Second)
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
 
 	// Thiết lập params
 	params := map[string]string{
@@ -153,6 +168,9 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/shops/:shop_id/warehouses"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[PancakePOS] [Lần thử %d/5] Bắt đầu lấy danh sách warehouses cho shopId: %d", requestCount, shopId)
@@ -160,7 +178,7 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -169,11 +187,12 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 
 		endpoint := fmt.Sprintf("/shops/%d/warehouses", shopId)
 		log.Printf("[PancakePOS] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (length: %d)", requestCount, len(apiKey))
+		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (fp: %s)", requestCount, secrets.Fingerprint(apiKey))
 
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[PancakePOS] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -208,7 +227,13 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[PancakePOS] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách warehouses thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[PancakePOS]", lastErr)
 			continue
 		}
 
@@ -216,6 +241,7 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
 			log.Printf("[PancakePOS] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
@@ -244,6 +270,7 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 				// Nếu không có field "data" hoặc "warehouses", có thể là array trực tiếp
 				// Thử parse lại như array
 				if err := json.Unmarshal(bodyBytes, &warehousesArray); err != nil {
+					lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 					logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 					log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 					continue
@@ -252,6 +279,7 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 		} else {
 			// Nếu không parse được như object, thử parse như array
 			if err := json.Unmarshal(bodyBytes, &warehousesArray); err != nil {
+				lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 				logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 				log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 				continue
@@ -276,10 +304,10 @@ func PancakePos_GetWarehouses(apiKey string, shopId int) (warehouses []interface
 // Trả về: []interface{} chứa danh sách customers
 func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize int, startTimeUpdatedAt int64, endTimeUpdatedAt int64) (customers []interface{}, err error) {
 	log.Printf("[PancakePOS] Bắt đầu lấy danh sách customers từ Pancake POS - shopId: %d, page: %d, size: %d, startTime: %d, endTime: %d", shopId, pageNumber, pageSize, startTimeUpdatedAt, endTimeUpdatedAt)
-	log.Printf("[PancakePOS] Pancake POS Base URL: https://pos.pages.fm/api/v1")
+	log.Printf("[PancakePOS] Pancake POS Base URL: %s", global.GlobalConfig.PancakePosBaseUrl)
 
 	// Khởi tạo client
-	client := httpclient.NewHttpClient("https://pos.pages.fm/api/v1", 60*time.Second)
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
 
 	// Thiết lập params
 	params := map[string]string{
@@ -300,6 +328,9 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/shops/:shop_id/customers"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[PancakePOS] [Lần thử %d/5] Bắt đầu lấy danh sách customers cho shopId: %d", requestCount, shopId)
@@ -307,7 +338,7 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -316,7 +347,7 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 
 		endpoint := fmt.Sprintf("/shops/%d/customers", shopId)
 		log.Printf("[PancakePOS] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (length: %d), page_number=%d, page_size=%d", requestCount, len(apiKey), pageNumber, pageSize)
+		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (fp: %s), page_number=%d, page_size=%d", requestCount, secrets.Fingerprint(apiKey), pageNumber, pageSize)
 		if startTimeUpdatedAt > 0 {
 			log.Printf("[PancakePOS] [Lần thử %d/5] start_time_updated_at: %d", requestCount, startTimeUpdatedAt)
 		}
@@ -327,6 +358,7 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[PancakePOS] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -361,7 +393,13 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[PancakePOS] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách customers thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[PancakePOS]", lastErr)
 			continue
 		}
 
@@ -369,6 +407,7 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
 			log.Printf("[PancakePOS] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
@@ -393,6 +432,7 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 				// Nếu không có field "customers" hoặc "data", có thể là array trực tiếp
 				// Thử parse lại như array
 				if err := json.Unmarshal(bodyBytes, &customersArray); err != nil {
+					lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 					logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 					log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 					continue
@@ -401,6 +441,7 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 		} else {
 			// Nếu không parse được như object, thử parse như array
 			if err := json.Unmarshal(bodyBytes, &customersArray); err != nil {
+				lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 				logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 				log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 				continue
@@ -423,10 +464,10 @@ func PancakePos_GetCustomers(apiKey string, shopId int, pageNumber int, pageSize
 // Trả về: []interface{} chứa danh sách products
 func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize int) (products []interface{}, err error) {
 	log.Printf("[PancakePOS] Bắt đầu lấy danh sách products từ Pancake POS - shopId: %d, page: %d, size: %d", shopId, pageNumber, pageSize)
-	log.Printf("[PancakePOS] Pancake POS Base URL: https://pos.pages.fm/api/v1")
+	log.Printf("[PancakePOS] Pancake POS Base URL: %s", global.GlobalConfig.PancakePosBaseUrl)
 
 	// Khởi tạo client
-	client := httpclient.NewHttpClient("https://pos.pages.fm/api/v1", 60*time.Second)
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
 
 	// Thiết lập params
 	params := map[string]string{
@@ -437,6 +478,9 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/shops/:shop_id/products"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[PancakePOS] [Lần thử %d/5] Bắt đầu lấy danh sách products cho shopId: %d", requestCount, shopId)
@@ -444,7 +488,7 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -453,11 +497,12 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 
 		endpoint := fmt.Sprintf("/shops/%d/products", shopId)
 		log.Printf("[PancakePOS] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (length: %d), page_number=%d, page_size=%d", requestCount, len(apiKey), pageNumber, pageSize)
+		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (fp: %s), page_number=%d, page_size=%d", requestCount, secrets.Fingerprint(apiKey), pageNumber, pageSize)
 
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[PancakePOS] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -492,7 +537,13 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[PancakePOS] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách products thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[PancakePOS]", lastErr)
 			continue
 		}
 
@@ -500,6 +551,7 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
 			log.Printf("[PancakePOS] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
@@ -524,6 +576,7 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 				// Nếu không có field "products" hoặc "data", có thể là array trực tiếp
 				// Thử parse lại như array
 				if err := json.Unmarshal(bodyBytes, &productsArray); err != nil {
+					lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 					logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 					log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 					continue
@@ -532,6 +585,7 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 		} else {
 			// Nếu không parse được như object, thử parse như array
 			if err := json.Unmarshal(bodyBytes, &productsArray); err != nil {
+				lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 				logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 				log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 				continue
@@ -555,10 +609,10 @@ func PancakePos_GetProducts(apiKey string, shopId int, pageNumber int, pageSize
 // Trả về: []interface{} chứa danh sách variations
 func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumber int, pageSize int) (variations []interface{}, err error) {
 	log.Printf("[PancakePOS] Bắt đầu lấy danh sách variations từ Pancake POS - shopId: %d, productId: %d, page: %d, size: %d", shopId, productId, pageNumber, pageSize)
-	log.Printf("[PancakePOS] Pancake POS Base URL: https://pos.pages.fm/api/v1")
+	log.Printf("[PancakePOS] Pancake POS Base URL: %s", global.GlobalConfig.PancakePosBaseUrl)
 
 	// Khởi tạo client
-	client := httpclient.NewHttpClient("https://pos.pages.fm/api/v1", 60*time.Second)
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
 
 	// Thiết lập params
 	params := map[string]string{
@@ -574,6 +628,9 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/shops/:shop_id/products/variations"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[PancakePOS] [Lần thử %d/5] Bắt đầu lấy danh sách variations cho shopId: %d, productId: %d", requestCount, shopId, productId)
@@ -581,7 +638,7 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -590,7 +647,7 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 
 		endpoint := fmt.Sprintf("/shops/%d/products/variations", shopId)
 		log.Printf("[PancakePOS] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (length: %d), page_number=%d, page_size=%d", requestCount, len(apiKey), pageNumber, pageSize)
+		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (fp: %s), page_number=%d, page_size=%d", requestCount, secrets.Fingerprint(apiKey), pageNumber, pageSize)
 		if productId > 0 {
 			log.Printf("[PancakePOS] [Lần thử %d/5] product_id: %d", requestCount, productId)
 		}
@@ -598,6 +655,7 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[PancakePOS] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -632,7 +690,13 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[PancakePOS] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách variations thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[PancakePOS]", lastErr)
 			continue
 		}
 
@@ -640,6 +704,7 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
 			log.Printf("[PancakePOS] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
@@ -664,6 +729,7 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 				// Nếu không có field "variations" hoặc "data", có thể là array trực tiếp
 				// Thử parse lại như array
 				if err := json.Unmarshal(bodyBytes, &variationsArray); err != nil {
+					lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 					logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 					log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 					continue
@@ -672,6 +738,7 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 		} else {
 			// Nếu không parse được như object, thử parse như array
 			if err := json.Unmarshal(bodyBytes, &variationsArray); err != nil {
+				lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 				logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 				log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 				continue
@@ -692,10 +759,10 @@ func PancakePos_GetVariations(apiKey string, shopId int, productId int, pageNumb
 // Trả về: []interface{} chứa danh sách categories
 func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface{}, err error) {
 	log.Printf("[PancakePOS] Bắt đầu lấy danh sách categories từ Pancake POS - shopId: %d", shopId)
-	log.Printf("[PancakePOS] Pancake POS Base URL: https://pos.pages.fm/api/v1")
+	log.Printf("[PancakePOS] Pancake POS Base URL: %s", global.GlobalConfig.PancakePosBaseUrl)
 
 	// Khởi tạo client
-	client := httpclient.NewHttpClient("https://pos.pages.fm/api/v1", 60*time.Second)
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
 
 	// Thiết lập params
 	params := map[string]string{
@@ -704,6 +771,9 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/shops/:shop_id/categories"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[PancakePOS] [Lần thử %d/5] Bắt đầu lấy danh sách categories cho shopId: %d", requestCount, shopId)
@@ -711,7 +781,7 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -720,11 +790,12 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 
 		endpoint := fmt.Sprintf("/shops/%d/categories", shopId)
 		log.Printf("[PancakePOS] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (length: %d)", requestCount, len(apiKey))
+		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (fp: %s)", requestCount, secrets.Fingerprint(apiKey))
 
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[PancakePOS] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -759,7 +830,13 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[PancakePOS] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách categories thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[PancakePOS]", lastErr)
 			continue
 		}
 
@@ -767,6 +844,7 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
 			log.Printf("[PancakePOS] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
@@ -791,6 +869,7 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 				// Nếu không có field "categories" hoặc "data", có thể là array trực tiếp
 				// Thử parse lại như array
 				if err := json.Unmarshal(bodyBytes, &categoriesArray); err != nil {
+					lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 					logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 					log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 					continue
@@ -799,6 +878,7 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 		} else {
 			// Nếu không parse được như object, thử parse như array
 			if err := json.Unmarshal(bodyBytes, &categoriesArray); err != nil {
+				lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 				logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 				log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 				continue
@@ -822,10 +902,10 @@ func PancakePos_GetCategories(apiKey string, shopId int) (categories []interface
 // Trả về: map[string]interface{} chứa orders và pagination
 func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize int, updateStatus string) (result map[string]interface{}, err error) {
 	log.Printf("[PancakePOS] Bắt đầu lấy danh sách orders từ Pancake POS - shopId: %d, page: %d, size: %d, updateStatus: %s", shopId, pageNumber, pageSize, updateStatus)
-	log.Printf("[PancakePOS] Pancake POS Base URL: https://pos.pages.fm/api/v1")
+	log.Printf("[PancakePOS] Pancake POS Base URL: %s", global.GlobalConfig.PancakePosBaseUrl)
 
 	// Khởi tạo client
-	client := httpclient.NewHttpClient("https://pos.pages.fm/api/v1", 60*time.Second)
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
 
 	// Thiết lập params
 	params := map[string]string{
@@ -839,6 +919,9 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 
 	// Số lần thử request
 	requestCount := 0
+	// apiPath dùng cho lỗi có kiểu (apierror), lastErr giữ lỗi của lần thử gần nhất để trả về khi hết số lần retry
+	apiPath := "/shops/:shop_id/orders"
+	var lastErr error
 	for {
 		requestCount++
 		log.Printf("[PancakePOS] [Lần thử %d/5] Bắt đầu lấy danh sách orders cho shopId: %d", requestCount, shopId)
@@ -846,7 +929,7 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 		// Nếu số lần thử vượt quá 5 lần thì thoát vòng lặp
 		if requestCount > 5 {
 			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
-			return nil, errors.New("Đã thử quá nhiều lần. Thoát vòng lặp.")
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
 		}
 
 		// Sử dụng adaptive rate limiter để nghỉ trước khi gửi request
@@ -855,11 +938,12 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 
 		endpoint := fmt.Sprintf("/shops/%d/orders", shopId)
 		log.Printf("[PancakePOS] [Lần thử %d/5] Gửi GET request đến endpoint: %s", requestCount, endpoint)
-		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (length: %d), page_number=%d, page_size=%d, updateStatus=%s", requestCount, len(apiKey), pageNumber, pageSize, updateStatus)
+		log.Printf("[PancakePOS] [Lần thử %d/5] Request params: api_key (fp: %s), page_number=%d, page_size=%d, updateStatus=%s", requestCount, secrets.Fingerprint(apiKey), pageNumber, pageSize, updateStatus)
 
 		// Gửi yêu cầu GET
 		resp, err := client.GET(endpoint, params)
 		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi gọi API GET: %v", requestCount, err)
 			log.Printf("[PancakePOS] [Lần thử %d/5] Request endpoint: %s", requestCount, endpoint)
 			log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Chi tiết lỗi: %s", requestCount, err.Error())
@@ -894,7 +978,13 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 			}
 			// Ghi nhận lỗi để điều chỉnh rate limiter
 			rateLimiter.RecordFailure(statusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, statusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
 			log.Printf("[PancakePOS] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy danh sách orders thất bại. Thử lại", requestCount, statusCode)
+			waitRetryAfter("[PancakePOS]", lastErr)
 			continue
 		}
 
@@ -902,6 +992,7 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
 			log.Printf("[PancakePOS] [Lần thử %d/5] ❌ Không thể đọc response body: %v", requestCount, readErr)
 			continue
 		}
@@ -921,6 +1012,7 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 				log.Printf("[PancakePOS] [Lần thử %d/5] Không tìm thấy field 'data' trong response object, thử parse như array", requestCount)
 				// Thử parse lại như array
 				if err := json.Unmarshal(bodyBytes, &ordersArray); err != nil {
+					lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 					logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 					log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 					continue
@@ -929,6 +1021,7 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 		} else {
 			// Nếu không parse được như object, thử parse như array
 			if err := json.Unmarshal(bodyBytes, &ordersArray); err != nil {
+				lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
 				logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
 				log.Printf("[PancakePOS] [Lần thử %d/5] 📝 Response Body (raw): %s", requestCount, string(bodyBytes))
 				continue
@@ -959,3 +1052,78 @@ func PancakePos_GetOrders(apiKey string, shopId int, pageNumber int, pageSize in
 		return result, nil
 	}
 }
+
+// PancakePos_GetOrder lấy chi tiết một order từ Pancake POS API (dùng cho command resync_order)
+// apiKey: API key từ FolkForm (system: "Pancake POS")
+// shopId: ID của shop (integer)
+// orderId: ID của order trong shop
+// Trả về: map[string]interface{} là dữ liệu order (cùng cấu trúc với phần tử của PancakePos_GetOrders)
+func PancakePos_GetOrder(apiKey string, shopId int, orderId string) (order map[string]interface{}, err error) {
+	log.Printf("[PancakePOS] Bắt đầu lấy order từ Pancake POS - shopId: %d, orderId: %s", shopId, orderId)
+
+	client := httpclient.NewHttpClient(global.GlobalConfig.PancakePosBaseUrl, 60*time.Second)
+	params := map[string]string{
+		"api_key": apiKey,
+	}
+
+	requestCount := 0
+	apiPath := "/shops/:shop_id/orders/:order_id"
+	var lastErr error
+	for {
+		requestCount++
+		if requestCount > 5 {
+			logError("[PancakePOS] LỖI: Đã thử quá nhiều lần (%d/5). Thoát vòng lặp.", requestCount)
+			return nil, apierror.NewRetryExhausted(apierror.SystemPancakePos, apiPath, 5, lastErr)
+		}
+
+		rateLimiter := apputility.GetPancakeRateLimiter()
+		rateLimiter.Wait()
+
+		endpoint := fmt.Sprintf("/shops/%d/orders/%s", shopId, orderId)
+		resp, err := client.GET(endpoint, params)
+		if err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
+			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi gọi API GET %s: %v", requestCount, endpoint, err)
+			continue
+		}
+
+		bodyBytes, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			var errorCode interface{}
+			var errorResult map[string]interface{}
+			if readErr == nil && json.Unmarshal(bodyBytes, &errorResult) == nil {
+				errorCode = errorResult["error_code"]
+			}
+			rateLimiter.RecordFailure(resp.StatusCode, errorCode)
+			lastErr = apierror.FromResponse(apierror.SystemPancakePos, apiPath, resp.StatusCode, resp.Header, bodyBytes)
+			if !apierror.IsRetryable(lastErr) {
+				logError("[PancakePOS] ❌ Lỗi không thể retry (%s): %v", apierror.Classify(lastErr), lastErr)
+				return nil, lastErr
+			}
+			log.Printf("[PancakePOS] [Lần thử %d/5] ⚠️ Status Code: %d - Lấy order thất bại. Thử lại", requestCount, resp.StatusCode)
+			waitRetryAfter("[PancakePOS]", lastErr)
+			continue
+		}
+		if readErr != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, readErr)
+			continue
+		}
+
+		// Response dạng {"data": {...order}, "success": true} hoặc order trực tiếp
+		var resultMap map[string]interface{}
+		if err := json.Unmarshal(bodyBytes, &resultMap); err != nil {
+			lastErr = apierror.NewNetwork(apierror.SystemPancakePos, apiPath, err)
+			logError("[PancakePOS] [Lần thử %d/5] ❌ LỖI khi phân tích phản hồi JSON: %v", requestCount, err)
+			continue
+		}
+		rateLimiter.RecordSuccess()
+
+		order = resultMap
+		if data, ok := resultMap["data"].(map[string]interface{}); ok {
+			order = data
+		}
+		log.Printf("[PancakePOS] Lấy order thành công - shopId: %d, orderId: %s", shopId, orderId)
+		return order, nil
+	}
+}
@@ -0,0 +1,61 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+)
+
+// Số liệu sync của một lần chạy job:
+// Job chạy qua command run_job gắn RunStats của lần chạy vào ctx (WithSyncStats), các hàm ghi dữ liệu lên FolkForm
+// (conversation, message, post, customer, dữ liệu POS, order) tự cộng số item đã ghi và lỗi theo ctx đó.
+// Nhờ vậy mọi luồng sync (incremental, backfill, verify, POS) đều có số liệu mà không cần truyền callback qua bridge.
+
+// SyncStatsRecorder nhận số item đã sync và lỗi (scheduler.RunStats thỏa mãn interface này)
+type SyncStatsRecorder interface {
+	AddItems(kind string, count int)
+	AddError(err error)
+}
+
+// syncStatsContextKey là key lưu SyncStatsRecorder trong context.Context
+type syncStatsContextKey struct{}
+
+// WithSyncStats trả về ctx mang recorder, các lần ghi dữ liệu sync theo ctx này được ghi vào recorder
+func WithSyncStats(ctx context.Context, recorder SyncStatsRecorder) context.Context {
+	if recorder == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, syncStatsContextKey{}, recorder)
+}
+
+// recordSyncWrite ghi kết quả một lần ghi dữ liệu lên FolkForm: thành công → cộng count item loại kind, lỗi → ghi lỗi
+func recordSyncWrite(ctx context.Context, kind string, count int, err error) {
+	if ctx == nil {
+		return
+	}
+	recorder, ok := ctx.Value(syncStatsContextKey{}).(SyncStatsRecorder)
+	if !ok {
+		return
+	}
+	if err != nil {
+		recorder.AddError(fmt.Errorf("%s: %w", kind, err))
+		return
+	}
+	if count > 0 {
+		recorder.AddItems(kind, count)
+	}
+}
+
+// countPancakeMessages đếm số message trong panCakeData.messages (1 nếu là một message đơn lẻ)
+func countPancakeMessages(data interface{}) int {
+	dataMap, ok := data.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	if messages, ok := dataMap["messages"].([]interface{}); ok {
+		return len(messages)
+	}
+	if _, ok := dataMap["id"]; ok {
+		return 1
+	}
+	return 0
+}
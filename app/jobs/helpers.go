@@ -6,10 +6,12 @@ package jobs
 
 import (
 	"agent_pancake/app/integrations"
+	"agent_pancake/app/scheduler"
 	"agent_pancake/app/services"
 	"agent_pancake/global"
-	"agent_pancake/utility/httpclient"
-	"net/http"
+	"context"
+	"errors"
+	"fmt"
 	"time"
 )
 
@@ -109,37 +111,6 @@ func GetJobConfigString(jobName, fieldName string, defaultValue string) string {
 	return configManager.GetJobConfigString(jobName, fieldName, defaultValue)
 }
 
-// verifyApiToken kiểm tra xem token còn hợp lệ không bằng cách gọi endpoint nhẹ
-// Sử dụng endpoint /v1/auth/roles để verify token (endpoint này nhẹ, chỉ cần GET)
-// Trả về:
-//   - true: Token hợp lệ
-//   - false: Token không hợp lệ hoặc chưa có token
-func verifyApiToken() bool {
-	if global.ApiToken == "" {
-		return false
-	}
-
-	// Tạo client với token hiện tại
-	client := httpclient.NewHttpClient(global.GlobalConfig.ApiBaseUrl, 5*time.Second) // Timeout ngắn vì chỉ verify
-	client.SetHeader("Authorization", "Bearer "+global.ApiToken)
-
-	// Gọi endpoint nhẹ để verify token
-	resp, err := client.GET("/v1/auth/roles", nil)
-	if err != nil {
-		// Network error → coi như token invalid để login lại
-		return false
-	}
-	defer resp.Body.Close()
-
-	// Nếu status code là 401 (Unauthorized) hoặc 403 (Forbidden) → token invalid
-	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-		return false
-	}
-
-	// Nếu status code là 200 → token hợp lệ
-	return resp.StatusCode == http.StatusOK
-}
-
 // EnsureFolkFormLoggedIn chỉ đảm bảo đã đăng nhập FolkForm (token + role ID).
 // Dùng cho CheckInJob vì job này chỉ làm việc với server FolkForm, không cần đồng bộ token/page.
 // Thực hiện: kiểm tra token còn hợp lệ → nếu chưa thì login → lấy Active Role ID nếu thiếu.
@@ -150,57 +121,22 @@ func EnsureFolkFormLoggedIn() {
 	}
 
 	// Kiểm tra token còn hợp lệ không (bao gồm cả trường hợp token hết hạn)
-	needLogin := false
-	if global.ApiToken == "" {
-		JobLogger.Info("Chưa có token, cần đăng nhập...")
-		needLogin = true
-	} else {
-		JobLogger.Debug("Đang kiểm tra token còn hợp lệ không...")
-		if !verifyApiToken() {
-			JobLogger.Info("Token không hợp lệ hoặc đã hết hạn, cần đăng nhập lại...")
-			global.ApiToken = "" // Xóa token cũ
-			needLogin = true
-		} else {
-			JobLogger.Debug("✅ Token còn hợp lệ")
-		}
-	}
-
-	// Nếu cần đăng nhập thì đăng nhập
-	if needLogin {
-		JobLogger.Info("Tiến hành đăng nhập...")
-		_, err := integrations.FolkForm_Login()
-		if err != nil {
-			JobLogger.WithError(err).Error("❌ Lỗi khi đăng nhập")
+	// Đăng nhập / làm mới / đăng nhập lại đều đi qua token manager của integrations (single-flight)
+	if err := integrations.FolkForm_EnsureLoggedIn(); err != nil {
+		JobLogger.WithError(err).Error("❌ Lỗi khi đảm bảo đăng nhập FolkForm")
+		if global.GetApiToken() == "" {
 			return
 		}
-		JobLogger.Info("✅ Đăng nhập thành công")
+	} else {
+		JobLogger.Debug("✅ Token còn hợp lệ")
 	}
 
-	// Lấy role ID nếu chưa có (Organization Context System - Version 3.2)
-	if global.ActiveRoleId == "" {
-		JobLogger.Info("Chưa có Active Role ID, đang lấy danh sách roles...")
-		roles, err := integrations.FolkForm_GetRoles()
-		if err != nil {
-			JobLogger.WithError(err).Warn("Lỗi khi lấy roles (Backend sẽ tự động detect role đầu tiên)")
-		} else if len(roles) > 0 {
-			if firstRole, ok := roles[0].(map[string]interface{}); ok {
-				if roleId, ok := firstRole["id"].(string); ok && roleId != "" {
-					global.ActiveRoleId = roleId
-					JobLogger.WithField("role_id", roleId).Info("✅ Đã lưu Active Role ID")
-				} else if roleId, ok := firstRole["roleId"].(string); ok && roleId != "" {
-					global.ActiveRoleId = roleId
-					JobLogger.WithField("role_id", roleId).Info("✅ Đã lưu Active Role ID")
-				} else {
-					JobLogger.Warn("⚠️  Không tìm thấy id hoặc roleId trong role đầu tiên")
-				}
-			} else {
-				JobLogger.Warn("⚠️  Role đầu tiên không phải là map")
-			}
-		} else {
-			JobLogger.Warn("⚠️  Không tìm thấy roles nào (Backend sẽ tự động detect)")
-		}
+	// Lấy role ID mặc định nếu chưa có (Organization Context System - Version 3.2)
+	// Ưu tiên role đầu tiên trong AGENT_ROLE_IDS, không cấu hình thì lấy role đầu tiên backend trả về
+	if roleId := integrations.FolkForm_EnsureActiveRoleId(); roleId != "" {
+		JobLogger.WithField("role_id", roleId).Debug("✅ Đã có Active Role ID")
 	} else {
-		JobLogger.WithField("role_id", global.ActiveRoleId).Debug("✅ Đã có Active Role ID")
+		JobLogger.Warn("⚠️  Không tìm thấy roles nào (Backend sẽ tự động detect)")
 	}
 }
 
@@ -208,12 +144,12 @@ func EnsureFolkFormLoggedIn() {
 // Dùng cho các luồng cần đồng bộ page/token (ví dụ sync từ Pancake, local). CheckInJob dùng EnsureFolkFormLoggedIn().
 // Cập nhật: Thêm logic lấy Active Role ID cho Organization Context System (Version 3.2)
 // Cập nhật: Kiểm tra token còn hợp lệ không (không chỉ kiểm tra rỗng)
-func SyncBaseAuth() {
+func SyncBaseAuth(ctx context.Context) {
 	// Đảm bảo đã đăng nhập FolkForm (token + role ID)
 	EnsureFolkFormLoggedIn()
 
 	// Đồng bộ danh sách các pages từ pancake sang folkform
-	err := integrations.Bridge_SyncPages()
+	err := integrations.Bridge_SyncPages(ctx)
 	if err != nil {
 		JobLogger.WithError(err).Error("Lỗi khi đồng bộ trang")
 	} else {
@@ -221,7 +157,7 @@ func SyncBaseAuth() {
 	}
 
 	// Đồng bộ danh sách các pages từ pancake sang folkform
-	err = integrations.Bridge_UpdatePagesAccessToken_toFolkForm()
+	err = integrations.Bridge_UpdatePagesAccessToken_toFolkForm(ctx)
 	if err != nil {
 		JobLogger.WithError(err).Error("Lỗi khi đồng bộ trang")
 	} else {
@@ -229,7 +165,7 @@ func SyncBaseAuth() {
 	}
 
 	// Đồng bộ danh sách các pages từ folkform sang local
-	err = integrations.Local_SyncPagesFolkformToLocal()
+	err = integrations.Local_SyncPagesFolkformToLocal(ctx)
 	if err != nil {
 		JobLogger.WithError(err).Error("Lỗi khi đồng bộ trang")
 	} else {
@@ -244,7 +180,7 @@ func SyncBaseAuth() {
 //   - true: Đã có token, job có thể tiếp tục
 //   - false: Chưa có token, job nên bỏ qua và đợi
 func EnsureApiToken() bool {
-	if global.ApiToken == "" {
+	if global.GetApiToken() == "" {
 		if JobLogger != nil {
 			JobLogger.Debug("Chưa có token, bỏ qua job này. Đợi CheckInJob login...")
 		}
@@ -252,3 +188,64 @@ func EnsureApiToken() bool {
 	}
 	return true
 }
+
+// RunPerOrganization chạy fn cho từng organization (role) mà agent phục vụ (AGENT_ROLE_IDS).
+// Mỗi lần chạy nhận ctx mang role đó (integrations.FolkForm_WithRoleId) → request gửi X-Active-Role-ID của role,
+// role đi theo lần chạy của job nên job của các organization khác nhau không chặn nhau.
+// Kết quả từng role được ghi nhận để gửi trong check-in.
+// Chỉ có một role (cấu hình mặc định) → chạy fn trực tiếp với role mặc định như trước.
+// Lỗi của một role không chặn các role còn lại, lỗi trả về gộp lỗi của tất cả role thất bại.
+// Lần chạy từ command run_job: ctx mang RunStats của lần chạy (integrations.WithSyncStats) → số conversation, message,
+// post, customer, order... đã ghi lên FolkForm và lỗi ghi của mọi luồng sync được cộng vào số liệu của command.
+func RunPerOrganization(ctx context.Context, jobName string, fn func(ctx context.Context) error) error {
+	// Số liệu của lần chạy từ command run_job (nil khi chạy theo lịch)
+	runStats := scheduler.RunStatsFor(jobName)
+	if runStats != nil {
+		ctx = integrations.WithSyncStats(ctx, runStats)
+	}
+
+	roleIds, err := integrations.FolkForm_ResolveRoleIds()
+	if err != nil {
+		GetJobLoggerByName(jobName).WithError(err).Warn("⚠️  Không lấy được danh sách roles, chạy với role mặc định")
+	}
+	if len(roleIds) <= 1 {
+		return fn(ctx)
+	}
+
+	jobLogger := GetJobLoggerByName(jobName)
+	var errs []error
+	for i, roleId := range roleIds {
+		if ctx.Err() != nil {
+			// Lần chạy bị hủy/hết hạn (command run_job) → không chạy các organization còn lại
+			errs = append(errs, fmt.Errorf("dừng trước role %s: %w", roleId, ctx.Err()))
+			break
+		}
+		jobLogger.WithField("role_id", roleId).Info("🏢 Chạy job cho organization")
+		runStats.SetProgress(i*100/len(roleIds), fmt.Sprintf("organization %d/%d (role %s)", i+1, len(roleIds), roleId))
+		startTime := time.Now()
+		runErr := fn(integrations.FolkForm_WithRoleId(ctx, roleId))
+		scheduler.RecordOrganizationResult(jobName, roleId, runErr, time.Since(startTime))
+		runStats.AddItems("organizations", 1)
+		if runErr != nil {
+			jobLogger.WithError(runErr).WithField("role_id", roleId).Error("❌ Job thất bại cho organization")
+			errs = append(errs, fmt.Errorf("role %s: %w", roleId, runErr))
+			runStats.AddError(fmt.Errorf("role %s: %w", roleId, runErr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recordPageSynced trả về callback ghi số page đã sync và lỗi theo page vào số liệu của lần chạy (command run_job)
+func recordPageSynced(jobName string) func(pageId string, err error) {
+	runStats := scheduler.RunStatsFor(jobName)
+	if runStats == nil {
+		return nil
+	}
+	return func(pageId string, err error) {
+		runStats.AddItems("pages", 1)
+		if err != nil {
+			runStats.AddError(fmt.Errorf("page %s: %w", pageId, err))
+		}
+		runStats.SetProgress(0, fmt.Sprintf("đã sync page %s", pageId))
+	}
+}
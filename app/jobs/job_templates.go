@@ -0,0 +1,66 @@
+/*
+Package jobs chứa các job cụ thể của ứng dụng.
+File này chứa các loại job mà server có thể tạo thêm instance qua config (job có field "type"),
+ví dụ job incremental conversations riêng cho một nhóm page với lịch chạy nhanh hơn.
+Đăng ký với ConfigManager trong main: configManager.SetJobTemplates(jobs.JobTemplates()).
+*/
+package jobs
+
+import (
+	"agent_pancake/app/scheduler"
+	"agent_pancake/app/services"
+)
+
+// pageIdsParamsSchema là JSON Schema của params cho các job sync theo page
+var pageIdsParamsSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"pageIds": map[string]interface{}{
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string", "minLength": 1},
+			"minItems":    1,
+			"uniqueItems": true,
+			"description": "Danh sách pageId job này sync (chỉ các page có isSync=true)",
+		},
+	},
+	"required":             []interface{}{"pageIds"},
+	"additionalProperties": false,
+}
+
+// JobTemplates trả về các loại job có thể tạo động, key là type trong config của job (trùng tên job gốc)
+func JobTemplates() map[string]*services.JobTemplate {
+	return map[string]*services.JobTemplate{
+		"sync-incremental-conversations-job": {
+			Description:     "Đồng bộ conversations mới cho một nhóm page (params.pageIds)",
+			DefaultSchedule: "0 */1 * * * *",
+			ParamsSchema:    pageIdsParamsSchema,
+			New: func(name, schedule string, params map[string]interface{}) scheduler.Job {
+				job := NewSyncIncrementalConversationsJob(name, schedule)
+				job.pageIds = stringListParam(params, "pageIds")
+				return job
+			},
+		},
+		"sync-incremental-posts-job": {
+			Description:     "Đồng bộ posts mới cho một nhóm page (params.pageIds)",
+			DefaultSchedule: "0 */10 * * * *",
+			ParamsSchema:    pageIdsParamsSchema,
+			New: func(name, schedule string, params map[string]interface{}) scheduler.Job {
+				job := NewSyncIncrementalPostsJob(name, schedule)
+				job.pageIds = stringListParam(params, "pageIds")
+				return job
+			},
+		},
+	}
+}
+
+// stringListParam đọc params[key] dạng array string (phần tử không phải string bị bỏ qua)
+func stringListParam(params map[string]interface{}, key string) []string {
+	list, _ := params[key].([]interface{})
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		if value, ok := item.(string); ok && value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -5,6 +5,7 @@ File này chứa các helper functions để sử dụng logger trong jobs.
 package jobs
 
 import (
+	"agent_pancake/app/integrations/apierror"
 	"agent_pancake/utility/logger"
 	"log"
 	"sync"
@@ -53,7 +54,7 @@ func GetJobLoggerByName(jobName string) *logrus.Logger {
 			return JobLogger
 		}
 		// Fallback: tạo logger mặc định
-		return logrus.New()
+		return logger.NewFallbackLogger()
 	}
 
 	// Kiểm tra xem logger đã được tạo chưa (với mutex để tránh race condition)
@@ -82,7 +83,7 @@ func GetJobLoggerByName(jobName string) *logrus.Logger {
 			if r := recover(); r != nil {
 				log.Printf("[LoggerHelper] 🚨 PANIC khi gọi logger.GetLogger(%s): %v", jobName, r)
 				// Fallback: tạo logger mặc định
-				loggerInstance = logrus.New()
+				loggerInstance = logger.NewFallbackLogger()
 				if JobLogger != nil {
 					JobLogger.WithField("job_name", jobName).Errorf("Lỗi khi tạo logger riêng, dùng logger mặc định: %v", r)
 				}
@@ -101,7 +102,7 @@ func GetJobLoggerByName(jobName string) *logrus.Logger {
 			return JobLogger
 		}
 		// Fallback cuối cùng: tạo logger mặc định
-		loggerInstance = logrus.New()
+		loggerInstance = logger.NewFallbackLogger()
 		jobLoggers[jobName] = loggerInstance
 	}
 
@@ -136,11 +137,12 @@ func LogJobEnd(jobName string, duration string, durationMs int64) {
 func LogJobError(jobName string, err error, duration string, durationMs int64) {
 	logger := getLoggerForJob(jobName)
 	logger.WithFields(logrus.Fields{
-		"job_name":    jobName,
-		"status":      "failed",
-		"error":       err.Error(),
-		"duration":    duration,
-		"duration_ms": durationMs,
+		"job_name":       jobName,
+		"status":         "failed",
+		"error":          err.Error(),
+		"error_category": string(apierror.Classify(err)),
+		"duration":       duration,
+		"duration_ms":    durationMs,
 	}).Error("❌ JOB THẤT BẠI")
 }
 
@@ -223,7 +225,7 @@ func getLoggerForJob(jobName string) *logrus.Logger {
 			return JobLogger
 		}
 		// Fallback: tạo logger mặc định
-		return logrus.New()
+		return logger.NewFallbackLogger()
 	}
 
 	// Kiểm tra xem logger đã được tạo chưa (với mutex để tránh race condition)
@@ -246,7 +248,7 @@ func getLoggerForJob(jobName string) *logrus.Logger {
 			return JobLogger
 		}
 		// Fallback cuối cùng: tạo logger mặc định
-		return logrus.New()
+		return logger.NewFallbackLogger()
 	}
 
 	return loggerInstance
@@ -44,7 +44,7 @@ func (j *SyncBackfillConversationsJob) ExecuteInternal(ctx context.Context) erro
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncBackfillConversations_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncBackfillConversations_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncBackfillConversationsJob) ExecuteInternal(ctx context.Context) erro
 // Hàm này đồng bộ các conversations cũ hơn oldestConversationId và messages của chúng.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncBackfillConversations_v2() error {
+func DoSyncBackfillConversations_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-backfill-conversations-job.log
 	jobLogger := GetJobLoggerByName("sync-backfill-conversations-job")
@@ -80,7 +80,7 @@ func DoSyncBackfillConversations_v2() error {
 
 	// Đồng bộ conversations cũ (backfill sync)
 	jobLogger.Info("Bắt đầu đồng bộ conversations cũ (backfill sync)...")
-	err := integrations.BridgeV2_SyncAllData(pageSize)
+	err := integrations.BridgeV2_SyncAllData(ctx, pageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ conversations cũ")
 		return err
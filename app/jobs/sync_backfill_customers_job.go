@@ -44,7 +44,7 @@ func (j *SyncBackfillCustomersJob) ExecuteInternal(ctx context.Context) error {
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncBackfillCustomers_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncBackfillCustomers_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncBackfillCustomersJob) ExecuteInternal(ctx context.Context) error {
 // Hàm này đồng bộ các customers cũ hơn oldestUpdatedAt.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncBackfillCustomers_v2() error {
+func DoSyncBackfillCustomers_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-backfill-customers-job.log
 	jobLogger := GetJobLoggerByName("sync-backfill-customers-job")
@@ -81,7 +81,7 @@ func DoSyncBackfillCustomers_v2() error {
 	// Đồng bộ customers cập nhật cũ (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ customers cập nhật cũ (backfill sync)...")
-	err := integrations.BridgeV2_SyncAllCustomers(pageSize)
+	err := integrations.BridgeV2_SyncAllCustomers(ctx, pageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ customers cập nhật cũ")
 		return err
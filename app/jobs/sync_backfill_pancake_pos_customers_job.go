@@ -44,7 +44,7 @@ func (j *SyncBackfillPancakePosCustomersJob) ExecuteInternal(ctx context.Context
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncBackfillPancakePosCustomers_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncBackfillPancakePosCustomers_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncBackfillPancakePosCustomersJob) ExecuteInternal(ctx context.Context
 // Hàm này đồng bộ các customers có updated_at từ 0 đến oldestUpdatedAt.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncBackfillPancakePosCustomers_v2() error {
+func DoSyncBackfillPancakePosCustomers_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-backfill-pancake-pos-customers-job.log
 	jobLogger := GetJobLoggerByName("sync-backfill-pancake-pos-customers-job")
@@ -87,7 +87,7 @@ func DoSyncBackfillPancakePosCustomers_v2() error {
 	// Đồng bộ customers cũ từ POS (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ customers cũ từ Pancake POS (backfill sync)...")
-	err := integrations.BridgeV2_SyncAllCustomersFromPos(pageSize, customerPageSize)
+	err := integrations.BridgeV2_SyncAllCustomersFromPos(ctx, pageSize, customerPageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ customers cũ từ Pancake POS")
 		return err
@@ -44,7 +44,7 @@ func (j *SyncBackfillPancakePosOrdersJob) ExecuteInternal(ctx context.Context) e
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncBackfillPancakePosOrders_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncBackfillPancakePosOrders_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncBackfillPancakePosOrdersJob) ExecuteInternal(ctx context.Context) e
 // Hàm này đồng bộ các orders cũ hơn oldestUpdatedAt.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncBackfillPancakePosOrders_v2() error {
+func DoSyncBackfillPancakePosOrders_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-backfill-pancake-pos-orders-job.log
 	jobLogger := GetJobLoggerByName("sync-backfill-pancake-pos-orders-job")
@@ -87,7 +87,7 @@ func DoSyncBackfillPancakePosOrders_v2() error {
 	// Đồng bộ orders cũ từ POS (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ orders cũ từ Pancake POS (backfill sync)...")
-	err := integrations.BridgeV2_SyncAllOrders(pageSize, orderPageSize)
+	err := integrations.BridgeV2_SyncAllOrders(ctx, pageSize, orderPageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ orders cũ từ Pancake POS")
 		return err
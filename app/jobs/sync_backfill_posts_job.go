@@ -44,7 +44,7 @@ func (j *SyncBackfillPostsJob) ExecuteInternal(ctx context.Context) error {
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncBackfillPosts_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncBackfillPosts_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncBackfillPostsJob) ExecuteInternal(ctx context.Context) error {
 // Hàm này đồng bộ các posts cũ hơn oldestInsertedAt.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncBackfillPosts_v2() error {
+func DoSyncBackfillPosts_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-backfill-posts-job.log
 	jobLogger := GetJobLoggerByName("sync-backfill-posts-job")
@@ -86,7 +86,7 @@ func DoSyncBackfillPosts_v2() error {
 
 	// Đồng bộ posts cũ (backfill sync)
 	jobLogger.Info("Bắt đầu đồng bộ posts cũ (backfill sync)...")
-	err := integrations.BridgeV2_SyncAllPosts(pageSize, postPageSize)
+	err := integrations.BridgeV2_SyncAllPosts(ctx, pageSize, postPageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ posts cũ")
 		return err
@@ -45,7 +45,7 @@ func (j *SyncFullRecoveryConversationsJob) ExecuteInternal(ctx context.Context)
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncFullRecoveryConversations()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncFullRecoveryConversations)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -62,7 +62,7 @@ func (j *SyncFullRecoveryConversationsJob) ExecuteInternal(ctx context.Context)
 // Hàm này sync lại tất cả conversations từ Pancake về FolkForm, không dựa vào checkpoint.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncFullRecoveryConversations() error {
+func DoSyncFullRecoveryConversations(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-full-recovery-conversations-job.log
 	jobLogger := GetJobLoggerByName("sync-full-recovery-conversations-job")
@@ -81,7 +81,7 @@ func DoSyncFullRecoveryConversations() error {
 
 	// Sync lại TOÀN BỘ conversations (full recovery sync)
 	jobLogger.Info("Bắt đầu sync lại TOÀN BỘ conversations (full recovery sync)...")
-	err := integrations.BridgeV2_SyncFullRecovery(pageSize)
+	err := integrations.BridgeV2_SyncFullRecovery(ctx, pageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi sync lại TOÀN BỘ conversations")
 		return err
@@ -16,6 +16,7 @@ import (
 // Sử dụng order_by=updated_at và dừng khi gặp lastConversationId từ FolkForm.
 type SyncIncrementalConversationsJob struct {
 	*scheduler.BaseJob
+	pageIds []string // Chỉ sync các page này (job tạo động từ server), rỗng = tất cả pages
 }
 
 // NewSyncIncrementalConversationsJob tạo một instance mới của SyncIncrementalConversationsJob.
@@ -47,7 +48,9 @@ func (j *SyncIncrementalConversationsJob) ExecuteInternal(ctx context.Context) e
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncIncrementalConversations_v2()
+	err := RunPerOrganization(ctx, j.GetName(), func(ctx context.Context) error {
+		return doSyncIncrementalConversations(ctx, j.GetName(), j.pageIds)
+	})
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -64,10 +67,18 @@ func (j *SyncIncrementalConversationsJob) ExecuteInternal(ctx context.Context) e
 // Hàm này đồng bộ các conversations mới/cập nhật gần đây và messages của chúng.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncIncrementalConversations_v2() error {
+func DoSyncIncrementalConversations_v2(ctx context.Context) error {
+	return doSyncIncrementalConversations(ctx, "sync-incremental-conversations-job", nil)
+}
+
+// doSyncIncrementalConversations đồng bộ conversations mới cho job jobName (đọc config và ghi log theo tên job).
+// Tham số:
+// - jobName: Tên job (job tạo động từ server có tên riêng, config riêng)
+// - pageIds: Chỉ sync các page này, rỗng = tất cả pages
+func doSyncIncrementalConversations(ctx context.Context, jobName string, pageIds []string) error {
 	// Lấy logger riêng cho job này
-	// File log sẽ là: logs/sync-incremental-conversations-job.log
-	jobLogger := GetJobLoggerByName("sync-incremental-conversations-job")
+	// File log sẽ là: logs/<jobName>.log
+	jobLogger := GetJobLoggerByName(jobName)
 
 	// Kiểm tra token - nếu chưa có thì bỏ qua, đợi CheckInJob login
 	if !EnsureApiToken() {
@@ -78,13 +89,13 @@ func DoSyncIncrementalConversations_v2() error {
 	// Lấy pageSize từ config động (có thể thay đổi từ server)
 	// Nếu không có config, sử dụng default value 50
 	// Config này có thể được thay đổi từ server mà không cần restart bot
-	pageSize := GetJobConfigInt("sync-incremental-conversations-job", "pageSize", 50)
+	pageSize := GetJobConfigInt(jobName, "pageSize", 50)
 	jobLogger.WithField("pageSize", pageSize).Info("📋 Sử dụng pageSize từ config")
 
 	// Đồng bộ conversations mới nhất (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ conversations mới (incremental sync)...")
-	err := integrations.BridgeV2_SyncNewData(pageSize)
+	err := integrations.BridgeV2_SyncNewDataForPages(ctx, pageSize, pageIds, recordPageSynced(jobName))
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ conversations mới")
 		return err
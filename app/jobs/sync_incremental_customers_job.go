@@ -44,7 +44,7 @@ func (j *SyncIncrementalCustomersJob) ExecuteInternal(ctx context.Context) error
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncIncrementalCustomers_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncIncrementalCustomers_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncIncrementalCustomersJob) ExecuteInternal(ctx context.Context) error
 // Hàm này đồng bộ các customers mới hơn lastUpdatedAt.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncIncrementalCustomers_v2() error {
+func DoSyncIncrementalCustomers_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-incremental-customers-job.log
 	jobLogger := GetJobLoggerByName("sync-incremental-customers-job")
@@ -81,7 +81,7 @@ func DoSyncIncrementalCustomers_v2() error {
 	// Đồng bộ customers đã cập nhật gần đây (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ customers đã cập nhật gần đây (incremental sync)...")
-	err := integrations.BridgeV2_SyncNewCustomers(pageSize)
+	err := integrations.BridgeV2_SyncNewCustomers(ctx, pageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ customers đã cập nhật gần đây")
 		return err
@@ -44,7 +44,7 @@ func (j *SyncIncrementalPancakePosCustomersJob) ExecuteInternal(ctx context.Cont
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncIncrementalPancakePosCustomers_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncIncrementalPancakePosCustomers_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncIncrementalPancakePosCustomersJob) ExecuteInternal(ctx context.Cont
 // Hàm này đồng bộ các customers có updated_at từ lastUpdatedAt đến now.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncIncrementalPancakePosCustomers_v2() error {
+func DoSyncIncrementalPancakePosCustomers_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-incremental-pancake-pos-customers-job.log
 	jobLogger := GetJobLoggerByName("sync-incremental-pancake-pos-customers-job")
@@ -87,7 +87,7 @@ func DoSyncIncrementalPancakePosCustomers_v2() error {
 	// Đồng bộ customers mới từ POS (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ customers mới từ Pancake POS (incremental sync)...")
-	err := integrations.BridgeV2_SyncNewCustomersFromPos(pageSize, customerPageSize)
+	err := integrations.BridgeV2_SyncNewCustomersFromPos(ctx, pageSize, customerPageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ customers mới từ Pancake POS")
 		return err
@@ -44,7 +44,7 @@ func (j *SyncIncrementalPancakePosOrdersJob) ExecuteInternal(ctx context.Context
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncIncrementalPancakePosOrders_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncIncrementalPancakePosOrders_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncIncrementalPancakePosOrdersJob) ExecuteInternal(ctx context.Context
 // Hàm này đồng bộ các orders có updated_at từ lastUpdatedAt đến now.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncIncrementalPancakePosOrders_v2() error {
+func DoSyncIncrementalPancakePosOrders_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-incremental-pancake-pos-orders-job.log
 	jobLogger := GetJobLoggerByName("sync-incremental-pancake-pos-orders-job")
@@ -87,7 +87,7 @@ func DoSyncIncrementalPancakePosOrders_v2() error {
 	// Đồng bộ orders mới từ POS (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ orders mới từ Pancake POS (incremental sync)...")
-	err := integrations.BridgeV2_SyncNewOrders(pageSize, orderPageSize)
+	err := integrations.BridgeV2_SyncNewOrders(ctx, pageSize, orderPageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ orders mới từ Pancake POS")
 		return err
@@ -16,6 +16,7 @@ import (
 // Sử dụng since/until và dừng khi gặp post với inserted_at < since.
 type SyncIncrementalPostsJob struct {
 	*scheduler.BaseJob
+	pageIds []string // Chỉ sync các page này (job tạo động từ server), rỗng = tất cả pages
 }
 
 // NewSyncIncrementalPostsJob tạo một instance mới của SyncIncrementalPostsJob.
@@ -44,7 +45,9 @@ func (j *SyncIncrementalPostsJob) ExecuteInternal(ctx context.Context) error {
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncIncrementalPosts_v2()
+	err := RunPerOrganization(ctx, j.GetName(), func(ctx context.Context) error {
+		return doSyncIncrementalPosts(ctx, j.GetName(), j.pageIds)
+	})
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,10 +64,18 @@ func (j *SyncIncrementalPostsJob) ExecuteInternal(ctx context.Context) error {
 // Hàm này đồng bộ các posts mới hơn lastInsertedAt.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncIncrementalPosts_v2() error {
+func DoSyncIncrementalPosts_v2(ctx context.Context) error {
+	return doSyncIncrementalPosts(ctx, "sync-incremental-posts-job", nil)
+}
+
+// doSyncIncrementalPosts đồng bộ posts mới cho job jobName (đọc config và ghi log theo tên job).
+// Tham số:
+// - jobName: Tên job (job tạo động từ server có tên riêng, config riêng)
+// - pageIds: Chỉ sync các page này, rỗng = tất cả pages
+func doSyncIncrementalPosts(ctx context.Context, jobName string, pageIds []string) error {
 	// Lấy logger riêng cho job này
-	// File log sẽ là: logs/sync-incremental-posts-job.log
-	jobLogger := GetJobLoggerByName("sync-incremental-posts-job")
+	// File log sẽ là: logs/<jobName>.log
+	jobLogger := GetJobLoggerByName(jobName)
 
 	// Kiểm tra token - nếu chưa có thì bỏ qua, đợi CheckInJob login
 	if !EnsureApiToken() {
@@ -77,8 +88,8 @@ func DoSyncIncrementalPosts_v2() error {
 	// postPageSize: Số lượng posts lấy mỗi lần (có thể khác với pageSize)
 	// Nếu không có config, sử dụng default values
 	// Config này có thể được thay đổi từ server mà không cần restart bot
-	pageSize := GetJobConfigInt("sync-incremental-posts-job", "pageSize", 50)
-	postPageSize := GetJobConfigInt("sync-incremental-posts-job", "pageSize", 30) // Có thể tách riêng nếu cần
+	pageSize := GetJobConfigInt(jobName, "pageSize", 50)
+	postPageSize := GetJobConfigInt(jobName, "pageSize", 30) // Có thể tách riêng nếu cần
 	jobLogger.WithFields(map[string]interface{}{
 		"pageSize":     pageSize,
 		"postPageSize": postPageSize,
@@ -87,7 +98,7 @@ func DoSyncIncrementalPosts_v2() error {
 	// Đồng bộ posts mới nhất (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu đồng bộ posts mới (incremental sync)...")
-	err := integrations.BridgeV2_SyncNewPosts(pageSize, postPageSize)
+	err := integrations.BridgeV2_SyncNewPostsForPages(ctx, pageSize, postPageSize, pageIds, recordPageSynced(jobName))
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi đồng bộ posts mới")
 		return err
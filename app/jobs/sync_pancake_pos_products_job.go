@@ -7,6 +7,7 @@ package jobs
 import (
 	"agent_pancake/app/integrations"
 	"agent_pancake/app/scheduler"
+	"agent_pancake/utility/secrets"
 	"context"
 	"errors"
 	"fmt"
@@ -50,7 +51,7 @@ func (j *SyncPancakePosProductsJob) ExecuteInternal(ctx context.Context) error {
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncPancakePosProducts_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncPancakePosProducts_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -74,7 +75,7 @@ func (j *SyncPancakePosProductsJob) ExecuteInternal(ctx context.Context) error {
 //
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncPancakePosProducts_v2() error {
+func DoSyncPancakePosProducts_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-pancake-pos-products-job.log
 	jobLogger := GetJobLoggerByName("sync-pancake-pos-products-job")
@@ -100,7 +101,7 @@ func DoSyncPancakePosProducts_v2() error {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách access token với filter system: "Pancake POS"
-		accessTokens, err := integrations.FolkForm_GetAccessTokens(page, limit, filter)
+		accessTokens, err := integrations.FolkForm_GetAccessTokens(ctx, page, limit, filter)
 		if err != nil {
 			jobLogger.WithError(err).Error("Lỗi khi lấy danh sách access token")
 			return errors.New("Lỗi khi lấy danh sách access token")
@@ -138,7 +139,7 @@ func DoSyncPancakePosProducts_v2() error {
 					continue
 				}
 
-				jobLogger.WithField("api_key_length", len(apiKey)).Info("Đang đồng bộ với API key (system: Pancake POS)")
+				jobLogger.WithField("api_key_fp", secrets.Fingerprint(apiKey)).Info("Đang đồng bộ với API key (system: Pancake POS)")
 
 				// 1. Lấy danh sách shops
 				shops, err := integrations.PancakePos_GetShops(apiKey)
@@ -245,7 +246,7 @@ func DoSyncPancakePosProducts_v2() error {
 								jobLogger.WithField("shop_id", shopId).Debug("Thêm shop_id vào product data")
 							}
 
-							_, err := integrations.FolkForm_UpsertProductFromPos(productMap, shopId)
+							_, err := integrations.FolkForm_UpsertProductFromPos(ctx, productMap, shopId)
 							if err != nil {
 								jobLogger.WithError(err).WithFields(logrus.Fields{
 									"index":   idx + 1,
@@ -288,7 +289,7 @@ func DoSyncPancakePosProducts_v2() error {
 											variationMap["shop_id"] = shopId
 										}
 
-										_, err := integrations.FolkForm_UpsertVariationFromPos(variationMap)
+										_, err := integrations.FolkForm_UpsertVariationFromPos(ctx, variationMap)
 										if err != nil {
 											jobLogger.WithError(err).WithFields(logrus.Fields{
 												"index":   varIdx + 1,
@@ -395,7 +396,7 @@ func DoSyncPancakePosProducts_v2() error {
 							}).Warn("CẢNH BÁO: Category không có field 'id'")
 						}
 
-						_, err := integrations.FolkForm_UpsertCategoryFromPos(categoryMap)
+						_, err := integrations.FolkForm_UpsertCategoryFromPos(ctx, categoryMap)
 						if err != nil {
 							jobLogger.WithError(err).WithFields(logrus.Fields{
 								"index":   idx + 1,
@@ -418,7 +419,7 @@ func DoSyncPancakePosProducts_v2() error {
 					}).Info("Đã đồng bộ categories cho shop")
 				}
 
-				jobLogger.WithField("api_key_length", len(apiKey)).Info("Đã hoàn thành đồng bộ cho API key")
+				jobLogger.WithField("api_key_fp", secrets.Fingerprint(apiKey)).Info("Đã hoàn thành đồng bộ cho API key")
 			}
 
 		} else {
@@ -7,6 +7,7 @@ package jobs
 import (
 	"agent_pancake/app/integrations"
 	"agent_pancake/app/scheduler"
+	"agent_pancake/utility/secrets"
 	"context"
 	"errors"
 	"fmt"
@@ -49,7 +50,7 @@ func (j *SyncPancakePosShopsWarehousesJob) ExecuteInternal(ctx context.Context)
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncPancakePosShopsWarehouses_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncPancakePosShopsWarehouses_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -71,7 +72,7 @@ func (j *SyncPancakePosShopsWarehousesJob) ExecuteInternal(ctx context.Context)
 // 5. Upsert từng warehouse vào FolkForm
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncPancakePosShopsWarehouses_v2() error {
+func DoSyncPancakePosShopsWarehouses_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-pancake-pos-shops-warehouses-job.log
 	jobLogger := GetJobLoggerByName("sync-pancake-pos-shops-warehouses-job")
@@ -97,7 +98,7 @@ func DoSyncPancakePosShopsWarehouses_v2() error {
 		time.Sleep(100 * time.Millisecond)
 
 		// Lấy danh sách access token với filter system: "Pancake POS"
-		accessTokens, err := integrations.FolkForm_GetAccessTokens(page, limit, filter)
+		accessTokens, err := integrations.FolkForm_GetAccessTokens(ctx, page, limit, filter)
 		if err != nil {
 			jobLogger.WithError(err).Error("Lỗi khi lấy danh sách access token")
 			return errors.New("Lỗi khi lấy danh sách access token")
@@ -135,7 +136,7 @@ func DoSyncPancakePosShopsWarehouses_v2() error {
 					continue
 				}
 
-				jobLogger.WithField("api_key_length", len(apiKey)).Info("Đang đồng bộ với API key (system: Pancake POS)")
+				jobLogger.WithField("api_key_fp", secrets.Fingerprint(apiKey)).Info("Đang đồng bộ với API key (system: Pancake POS)")
 
 				// 1. Đồng bộ Shops
 				jobLogger.Info("Bắt đầu đồng bộ shops...")
@@ -159,7 +160,7 @@ func DoSyncPancakePosShopsWarehouses_v2() error {
 						continue
 					}
 
-					_, err := integrations.FolkForm_UpsertShop(shopMap)
+					_, err := integrations.FolkForm_UpsertShop(ctx, shopMap)
 					if err != nil {
 						jobLogger.WithError(err).Error("LỖI khi upsert shop")
 						// Tiếp tục với shop tiếp theo nếu lỗi
@@ -240,7 +241,7 @@ func DoSyncPancakePosShopsWarehouses_v2() error {
 							}).Warn("CẢNH BÁO: Warehouse không có field 'id'")
 						}
 
-						_, err := integrations.FolkForm_UpsertWarehouse(warehouseMap)
+						_, err := integrations.FolkForm_UpsertWarehouse(ctx, warehouseMap)
 						if err != nil {
 							jobLogger.WithError(err).WithFields(logrus.Fields{
 								"index": idx + 1,
@@ -261,7 +262,7 @@ func DoSyncPancakePosShopsWarehouses_v2() error {
 					}).Info("Đã đồng bộ warehouses cho shop")
 				}
 
-				jobLogger.WithField("api_key_length", len(apiKey)).Info("Đã hoàn thành đồng bộ cho API key")
+				jobLogger.WithField("api_key_fp", secrets.Fingerprint(apiKey)).Info("Đã hoàn thành đồng bộ cho API key")
 			}
 
 		} else {
@@ -49,7 +49,7 @@ func (j *SyncPriorityConversationsJob) ExecuteInternal(ctx context.Context) erro
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoSyncPriorityConversations()
+	err := RunPerOrganization(ctx, j.GetName(), DoSyncPriorityConversations)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -69,7 +69,7 @@ func (j *SyncPriorityConversationsJob) ExecuteInternal(ctx context.Context) erro
 // - Sau khi sync xong, set needsPrioritySync=false
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoSyncPriorityConversations() error {
+func DoSyncPriorityConversations(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-priority-conversations-job.log
 	jobLogger := GetJobLoggerByName("sync-priority-conversations-job")
@@ -96,7 +96,7 @@ func DoSyncPriorityConversations() error {
 		rateLimiter.Wait()
 
 		// Lấy conversations có needsPrioritySync=true từ FolkForm
-		result, err := integrations.FolkForm_GetPrioritySyncConversations(page, limit)
+		result, err := integrations.FolkForm_GetPrioritySyncConversations(ctx, page, limit)
 		if err != nil {
 			jobLogger.WithError(err).Error("Lỗi khi lấy conversations cần ưu tiên sync từ FolkForm")
 			return err
@@ -164,9 +164,8 @@ func DoSyncPriorityConversations() error {
 			rateLimiterPancake.Wait()
 
 			// Lấy conversation từ Pancake bằng conversationId
-			// Sử dụng Pancake_GetConversationById nếu có, hoặc dùng Pancake_GetConversations_v2 với filter
-			// Tạm thời dùng cách lấy từ page và tìm conversationId trong danh sách
-			conversationData, err := getConversationFromPancake(pageId, conversationId)
+			// Pancake không có API lấy một conversation → tìm trong danh sách conversations của page (tối đa 10 batches)
+			conversationData, err := integrations.BridgeV2_FindPancakeConversation(ctx, pageId, conversationId, 10)
 			if err != nil {
 				jobLogger.WithError(err).WithFields(map[string]interface{}{
 					"conversationId": conversationId,
@@ -182,12 +181,12 @@ func DoSyncPriorityConversations() error {
 					"pageId":         pageId,
 				}).Warn("⚠️ Không tìm thấy conversation trong Pancake, có thể đã bị xóa")
 				// Vẫn set needsPrioritySync=false để không sync lại nữa
-				_, _ = integrations.FolkForm_UpdateConversationNeedsPrioritySync(conversationId, false)
+				_, _ = integrations.FolkForm_UpdateConversationNeedsPrioritySync(ctx, conversationId, false)
 				continue
 			}
 
 			// Sync conversation từ Pancake về FolkForm
-			_, err = integrations.FolkForm_CreateConversation(pageId, pageUsername, conversationData)
+			_, err = integrations.FolkForm_CreateConversation(ctx, pageId, pageUsername, conversationData)
 			if err != nil {
 				jobLogger.WithError(err).WithFields(map[string]interface{}{
 					"conversationId": conversationId,
@@ -208,7 +207,7 @@ func DoSyncPriorityConversations() error {
 
 			// Sau khi sync xong, set needsPrioritySync=false
 			rateLimiter.Wait()
-			_, err = integrations.FolkForm_UpdateConversationNeedsPrioritySync(conversationId, false)
+			_, err = integrations.FolkForm_UpdateConversationNeedsPrioritySync(ctx, conversationId, false)
 			if err != nil {
 				jobLogger.WithError(err).WithFields(map[string]interface{}{
 					"conversationId": conversationId,
@@ -236,56 +235,3 @@ func DoSyncPriorityConversations() error {
 	jobLogger.WithField("total_synced", totalSynced).Info("✅ Hoàn thành sync conversations ưu tiên")
 	return nil
 }
-
-// getConversationFromPancake lấy conversation từ Pancake bằng conversationId
-// Hàm này tìm conversation trong danh sách conversations của page
-// Tìm trong tối đa 10 batches để đảm bảo tìm thấy conversation
-func getConversationFromPancake(pageId string, conversationId string) (interface{}, error) {
-	rateLimiter := apputility.GetPancakeRateLimiter()
-	lastConversationId := ""
-	maxBatches := 10 // Tìm trong tối đa 10 batches
-
-	for batch := 0; batch < maxBatches; batch++ {
-		rateLimiter.Wait()
-
-		// Lấy conversations từ Pancake
-		result, err := integrations.Pancake_GetConversations_v2(pageId, lastConversationId, 0, 0, "", false)
-		if err != nil {
-			return nil, err
-		}
-
-		// Parse conversations từ response
-		var conversations []interface{}
-		if convs, ok := result["conversations"].([]interface{}); ok {
-			conversations = convs
-		}
-
-		if len(conversations) == 0 {
-			// Không còn conversations nào
-			break
-		}
-
-		// Tìm conversation có id = conversationId trong batch này
-		for _, conv := range conversations {
-			convMap, ok := conv.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			if id, ok := convMap["id"].(string); ok && id == conversationId {
-				return conv, nil
-			}
-		}
-
-		// Cập nhật lastConversationId để lấy batch tiếp theo
-		lastConv := conversations[len(conversations)-1]
-		if lastConvMap, ok := lastConv.(map[string]interface{}); ok {
-			if lastId, ok := lastConvMap["id"].(string); ok {
-				lastConversationId = lastId
-			}
-		}
-	}
-
-	// Không tìm thấy sau khi tìm trong tất cả batches
-	return nil, nil
-}
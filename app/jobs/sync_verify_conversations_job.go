@@ -44,7 +44,7 @@ func (j *SyncVerifyConversationsJob) ExecuteInternal(ctx context.Context) error
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoVerifyConversations_v2()
+	err := RunPerOrganization(ctx, j.GetName(), DoVerifyConversations_v2)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -61,7 +61,7 @@ func (j *SyncVerifyConversationsJob) ExecuteInternal(ctx context.Context) error
 // Hàm này verify conversations unseen và đã đọc từ FolkForm với Pancake để đảm bảo đồng bộ 2 chiều.
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoVerifyConversations_v2() error {
+func DoVerifyConversations_v2(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-verify-conversations-job.log
 	jobLogger := GetJobLoggerByName("sync-verify-conversations-job")
@@ -81,7 +81,7 @@ func DoVerifyConversations_v2() error {
 	// Verify conversations từ FolkForm với Pancake (chỉ chạy 1 lần, không có vòng lặp)
 	// Scheduler sẽ tự động gọi lại job theo lịch
 	jobLogger.Info("Bắt đầu verify conversations từ FolkForm với Pancake...")
-	err := integrations.BridgeV2_VerifyConversations(pageSize)
+	err := integrations.BridgeV2_VerifyConversations(ctx, pageSize)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi verify conversations")
 		return err
@@ -68,7 +68,7 @@ func (j *SyncWarnUnrepliedConversationsJob) ExecuteInternal(ctx context.Context)
 	}).Info("🚀 JOB ĐÃ BẮT ĐẦU CHẠY")
 
 	// Gọi hàm logic thực sự
-	err := DoWarnUnrepliedConversations()
+	err := RunPerOrganization(ctx, j.GetName(), DoWarnUnrepliedConversations)
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
 
@@ -115,7 +115,7 @@ func (j *SyncWarnUnrepliedConversationsJob) ExecuteInternal(ctx context.Context)
 // - Gửi cảnh báo qua notification system của FolkForm
 // Hàm này có thể được gọi độc lập mà không cần thông qua job interface.
 // Trả về error nếu có lỗi xảy ra
-func DoWarnUnrepliedConversations() error {
+func DoWarnUnrepliedConversations(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-warn-unreplied-conversations-job.log
 	jobLogger := GetJobLoggerByName("sync-warn-unreplied-conversations-job")
@@ -199,7 +199,7 @@ func DoWarnUnrepliedConversations() error {
 	// Đảm bảo notification template và routing rule đã được tạo
 	// Sẽ tự động lấy organizationIds từ role hiện tại
 	eventType := "conversation_unreplied"
-	err := integrations.FolkForm_EnsureNotificationSetup(eventType, []string{})
+	err := integrations.FolkForm_EnsureNotificationSetup(ctx, eventType, []string{})
 	if err != nil {
 		jobLogger.WithError(err).Warn("Lưu ý: Có thể notification setup đã tồn tại hoặc có lỗi khi tạo")
 		// Không return error, tiếp tục chạy job
@@ -219,7 +219,7 @@ func DoWarnUnrepliedConversations() error {
 
 	for {
 		// Lấy danh sách các pages từ server FolkForm
-		resultPages, err := integrations.FolkForm_GetFbPages(page, limit)
+		resultPages, err := integrations.FolkForm_GetFbPages(ctx, page, limit)
 		if err != nil {
 			jobLogger.WithError(err).Error("❌ Lỗi khi lấy danh sách trang Facebook")
 			return errors.New("Lỗi khi lấy danh sách trang Facebook")
@@ -280,7 +280,7 @@ func DoWarnUnrepliedConversations() error {
 			// Nếu vẫn không có, thử lấy từ API
 			if pageUsername == "" {
 				jobLogger.WithField("pageId", pageId).Info("Page không có pageUsername trong response, đang lấy từ API...")
-				pageData, err := integrations.FolkForm_GetFbPageByPageId(pageId)
+				pageData, err := integrations.FolkForm_GetFbPageByPageId(ctx, pageId)
 				if err == nil {
 					if dataMap, ok := pageData["data"].(map[string]interface{}); ok {
 						if username, ok := dataMap["pageUsername"].(string); ok && username != "" {
@@ -304,7 +304,7 @@ func DoWarnUnrepliedConversations() error {
 			}
 
 			// Kiểm tra và cảnh báo conversations chưa trả lời cho page này
-			warnedCount, err := warnUnrepliedConversationsForPage(pageId, pageUsername, minDelayMinutes, maxDelayMinutes, notificationRateLimitMinutes, jobLogger)
+			warnedCount, err := warnUnrepliedConversationsForPage(ctx, pageId, pageUsername, minDelayMinutes, maxDelayMinutes, notificationRateLimitMinutes, jobLogger)
 			if err != nil {
 				jobLogger.WithError(err).WithField("pageId", pageId).Error("Lỗi khi kiểm tra conversations cho page")
 				// Tiếp tục với page tiếp theo, không dừng
@@ -335,7 +335,7 @@ func DoWarnUnrepliedConversations() error {
 // - notificationRateLimitMinutes: Thời gian tối thiểu giữa các lần gửi notification (phút)
 // - jobLogger: Logger riêng cho job
 // Trả về số lượng conversations đã cảnh báo và error
-func warnUnrepliedConversationsForPage(pageId string, pageUsername string, delayWarningMinMinutes int, delayWarningMaxMinutes int, notificationRateLimitMinutes int, jobLogger *logrus.Logger) (int, error) {
+func warnUnrepliedConversationsForPage(ctx context.Context, pageId string, pageUsername string, delayWarningMinMinutes int, delayWarningMaxMinutes int, notificationRateLimitMinutes int, jobLogger *logrus.Logger) (int, error) {
 	jobLogger.WithFields(map[string]interface{}{
 		"pageId":                 pageId,
 		"pageUsername":           pageUsername,
@@ -358,7 +358,7 @@ func warnUnrepliedConversationsForPage(pageId string, pageUsername string, delay
 
 		// Lấy conversations chưa trả lời từ FolkForm với filter tối ưu
 		// Chỉ lấy conversations có updated_at trong khoảng 5-300 phút trước
-		result, err := integrations.FolkForm_GetUnrepliedConversationsWithPageId(page, limit, pageId, delayWarningMinMinutes, delayWarningMaxMinutes)
+		result, err := integrations.FolkForm_GetUnrepliedConversationsWithPageId(ctx, page, limit, pageId, delayWarningMinMinutes, delayWarningMaxMinutes)
 		if err != nil {
 			jobLogger.WithError(err).Error("Lỗi khi lấy conversations từ FolkForm")
 			return warnedCount, err
@@ -718,7 +718,7 @@ func warnUnrepliedConversationsForPage(pageId string, pageUsername string, delay
 			}).Info("📤 Đang gửi notification cho conversationId")
 
 			// Gửi notification qua FolkForm notification system
-			result, err := integrations.FolkForm_TriggerNotification("conversation_unreplied", payload)
+			result, err := integrations.FolkForm_TriggerNotification(ctx, "conversation_unreplied", payload)
 
 			// Log response từ API để debug
 			jobLogger.WithFields(map[string]interface{}{
@@ -825,7 +825,7 @@ func warnUnrepliedConversationsForPage(pageId string, pageUsername string, delay
 
 // DoTestNotification gửi một notification test để kiểm tra hệ thống
 // Hàm này có thể được gọi từ main.go hoặc test để kiểm tra notification system
-func DoTestNotification() error {
+func DoTestNotification(ctx context.Context) error {
 	// Lấy logger riêng cho job này
 	// File log sẽ là: logs/sync-warn-unreplied-conversations-job.log
 	jobLogger := GetJobLoggerByName("sync-warn-unreplied-conversations-job")
@@ -840,7 +840,7 @@ func DoTestNotification() error {
 
 	// Đảm bảo notification setup đã được tạo
 	eventType := "conversation_unreplied"
-	err := integrations.FolkForm_EnsureNotificationSetup(eventType, []string{})
+	err := integrations.FolkForm_EnsureNotificationSetup(ctx, eventType, []string{})
 	if err != nil {
 		jobLogger.WithError(err).Warn("Lưu ý: Có thể notification setup đã tồn tại hoặc có lỗi khi tạo")
 		// Không return error, tiếp tục test
@@ -871,7 +871,7 @@ func DoTestNotification() error {
 		"payload": payload,
 	}).Info("🧪 Payload sẽ được gửi:")
 
-	result, err := integrations.FolkForm_TriggerNotification(eventType, payload)
+	result, err := integrations.FolkForm_TriggerNotification(ctx, eventType, payload)
 	if err != nil {
 		jobLogger.WithError(err).Error("❌ Lỗi khi gửi notification test")
 		jobLogger.Error("⚠️ Kiểm tra logs từ [FolkForm] trong console hoặc app.log để xem chi tiết lỗi")
@@ -3,7 +3,7 @@ Package jobs chứa các job cụ thể của ứng dụng.
 File này chứa WorkflowCommandsJob - job xử lý workflow commands từ Module 2 (AI Service).
 Theo docs-shared/ai-context/folkform/api-context.md (backend CRUD insert-one, update-by-id):
 1. Claim pending: POST /api/v1/ai/workflow-commands/claim-pending
-2. Tạo worker (goroutine) để xử lý từng command
+2. Tạo worker (goroutine) để xử lý từng command qua services.WorkflowWorkerPool (chỉ claim đúng số slot còn trống)
 3. Worker gọi API Module 2 (workflow-runs/insert-one, step-runs, ...) để start workflow run hoặc execute step
 4. Update heartbeat định kỳ: POST /api/v1/ai/workflow-commands/update-heartbeat
 5. Update command status: PUT /api/v1/ai/workflow-commands/update-by-id/:id
@@ -17,20 +17,16 @@ import (
 	"agent_pancake/global"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Global variable để track job instance (dùng để track active workers)
-var globalWorkflowCommandsJob *WorkflowCommandsJob
-var globalWorkflowCommandsJobMu sync.RWMutex
-
 // WorkflowCommandsJob là job xử lý workflow commands từ Module 2
+// Các worker đang chạy được quản lý bởi services.GetWorkflowWorkerPool() (dùng chung, tránh xử lý duplicate commands)
 type WorkflowCommandsJob struct {
 	*scheduler.BaseJob
-	// Map để track các workers đang chạy (tránh xử lý duplicate commands)
-	activeWorkers sync.Map // map[string]bool - key là commandID
 }
 
 // NewWorkflowCommandsJob tạo một instance mới của WorkflowCommandsJob
@@ -44,12 +40,6 @@ func NewWorkflowCommandsJob(name, schedule string) *WorkflowCommandsJob {
 	}
 	// Set callback function để BaseJob.Execute có thể gọi ExecuteInternal đúng cách
 	job.BaseJob.SetExecuteInternalCallback(job.ExecuteInternal)
-
-	// Lưu job instance vào global variable để có thể truy cập từ worker
-	globalWorkflowCommandsJobMu.Lock()
-	globalWorkflowCommandsJob = job
-	globalWorkflowCommandsJobMu.Unlock()
-
 	return job
 }
 
@@ -102,15 +92,28 @@ func DoProcessWorkflowCommands() error {
 	}
 	jobLogger.WithField("agent_id", agentId).Debug("AgentId đã có, chuẩn bị claim commands")
 
-	// Lấy limit từ config (default: 5, max: 100)
+	// Lấy limit từ config (default: 5, max: 100), chỉ claim tối đa số slot còn trống của worker pool
 	limit := GetJobConfigInt("workflow-commands-job", "claimLimit", 5)
 	if limit > 100 {
 		limit = 100
 	}
+	pool := services.GetWorkflowWorkerPool()
+	freeSlots := pool.FreeSlots()
+	if freeSlots == 0 {
+		jobLogger.WithFields(map[string]interface{}{
+			"agent_id":       agentId,
+			"max_concurrent": pool.MaxConcurrent(),
+		}).Debug("Worker pool đã đầy, bỏ qua claim lần này")
+		return nil
+	}
+	if limit > freeSlots {
+		limit = freeSlots
+	}
 	jobLogger.WithFields(map[string]interface{}{
-		"agent_id": agentId,
-		"limit":    limit,
-		"endpoint": "/v1/ai/workflow-commands/claim-pending",
+		"agent_id":   agentId,
+		"limit":      limit,
+		"free_slots": freeSlots,
+		"endpoint":   "/v1/ai/workflow-commands/claim-pending",
 	}).Info("Đang claim workflow commands từ server...")
 	// Log chi tiết REQUEST/RESPONSE sẽ ghi qua logToJob → xuất hiện ở đây (console + file workflow-commands-job.log)
 	jobLogger.Info("🔍 [Claim] Log chi tiết REQUEST và RESPONSE bên dưới (source=claim_api)")
@@ -162,45 +165,26 @@ func DoProcessWorkflowCommands() error {
 			continue
 		}
 
-		// Kiểm tra xem command này đã có worker đang xử lý chưa
-		// (tránh xử lý duplicate nếu job chạy lại trước khi worker hoàn thành)
-		jobInstance := getWorkflowCommandsJobInstance()
-		if jobInstance != nil {
-			if _, exists := jobInstance.activeWorkers.Load(commandID); exists {
-				jobLogger.WithField("command_id", commandID).Debug("Command đang được xử lý bởi worker khác, bỏ qua (tránh duplicate)")
-				continue
-			}
-			// Đánh dấu command đang được xử lý
-			jobInstance.activeWorkers.Store(commandID, true)
-			jobLogger.WithField("command_id", commandID).Debug("Đã đánh dấu command vào activeWorkers, spawn worker")
-		}
-
-		// Tạo worker để xử lý command (chạy trong goroutine riêng)
+		// Giao command cho worker pool (chạy ngay nếu còn slot, ngược lại xếp hàng)
+		// Pool bỏ qua command đang chạy/xếp hàng (tránh xử lý duplicate nếu server trả lại command trước khi worker hoàn thành)
 		jobLogger.WithFields(map[string]interface{}{
 			"command_id": commandID,
 			"index":      idx + 1,
 			"total":      len(commands),
-		}).Debug("Spawning goroutine xử lý command")
-		go processWorkflowCommand(commandID, cmdMap, agentId)
+		}).Debug("Giao command cho worker pool")
+		if !pool.Submit(commandID, func() { processWorkflowCommand(commandID, cmdMap, agentId) }) {
+			jobLogger.WithField("command_id", commandID).Debug("Command đang được xử lý bởi worker khác, bỏ qua (tránh duplicate)")
+		}
 	}
 
 	return nil
 }
 
 // processWorkflowCommand xử lý một workflow command cụ thể
-// Hàm này chạy trong goroutine riêng để không block job chính
+// Hàm này chạy trong goroutine của worker pool để không block job chính, slot được giải phóng khi hàm trả về
 func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, agentId string) {
 	jobLogger := GetJobLoggerByName("workflow-commands-job")
 
-	// Đảm bảo cleanup activeWorkers khi xong
-	defer func() {
-		jobInstance := getWorkflowCommandsJobInstance()
-		if jobInstance != nil {
-			jobInstance.activeWorkers.Delete(commandID)
-			jobLogger.WithField("command_id", commandID).Debug("Đã xóa command khỏi activeWorkers (cleanup)")
-		}
-	}()
-
 	jobLogger.WithField("command_id", commandID).Info("🔄 Bắt đầu xử lý workflow command")
 
 	// Parse command data
@@ -285,9 +269,11 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 		}
 	}
 
-	// Tạo context với timeout để có thể cancel heartbeat khi xong
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Context của command: bị hủy khi xong (dừng heartbeat) hoặc khi server yêu cầu hủy
+	// (qua phản hồi heartbeat hoặc command cancel_workflow_command khi check-in)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	defer services.GetWorkflowWorkerPool().RegisterCancel(commandID, cancel)()
 
 	// Tạo heartbeat ticker (update mỗi 45 giây - giữa 30-60 giây)
 	heartbeatInterval := GetJobConfigInt("workflow-commands-job", "heartbeatInterval", 45)
@@ -300,9 +286,26 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 	heartbeatTicker := time.NewTicker(time.Duration(heartbeatInterval) * time.Second)
 	defer heartbeatTicker.Stop()
 
+	// Tiến độ thực tế (step, % sinh của AI khi stream) do executor cập nhật vào tracker,
+	// progressTicker gửi tiến độ lên server ngay khi có thay đổi thay vì đợi heartbeat định kỳ
+	progressInterval := GetJobConfigInt("workflow-commands-job", "progressInterval", 5)
+	if progressInterval < 2 {
+		progressInterval = 2
+	}
+	if progressInterval > heartbeatInterval {
+		progressInterval = heartbeatInterval
+	}
+	progressTicker := time.NewTicker(time.Duration(progressInterval) * time.Second)
+	defer progressTicker.Stop()
+
+	tracker := services.NewProgressTracker()
+	tracker.Update("processing", 0, fmt.Sprintf("Đang xử lý %s...", commandType))
+	var sentVersion atomic.Uint64
+
 	jobLogger.WithFields(map[string]interface{}{
 		"command_id":           commandID,
 		"heartbeat_interval_s": heartbeatInterval,
+		"progress_interval_s":  progressInterval,
 	}).Debug("Đã tạo heartbeat ticker, bắt đầu goroutine heartbeat")
 
 	// Channel để signal khi worker hoàn thành
@@ -313,15 +316,23 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 		heartbeatCount := 0
 		for {
 			select {
+			case <-progressTicker.C:
+				// Chỉ gửi khi tiến độ thay đổi kể từ lần gửi trước
+				if progress, version := tracker.Snapshot(); version != sentVersion.Load() {
+					if resp, err := integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, progress); err == nil {
+						sentVersion.Store(version)
+						checkWorkflowCommandCancel(resp, commandID, cancel)
+					}
+				}
 			case <-heartbeatTicker.C:
 				heartbeatCount++
-				// Update heartbeat với progress
-				progress := map[string]interface{}{
-					"step":       "processing",
-					"percentage": 0,
-					"message":    fmt.Sprintf("Đang xử lý %s...", commandType),
+				// Update heartbeat với tiến độ hiện tại (gửi cả khi không đổi để server biết command còn sống)
+				progress, version := tracker.Snapshot()
+				resp, err := integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, progress)
+				if err == nil {
+					sentVersion.Store(version)
+					checkWorkflowCommandCancel(resp, commandID, cancel)
 				}
-				_, err := integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, progress)
 				if err != nil {
 					jobLogger.WithError(err).WithFields(map[string]interface{}{
 						"command_id":      commandID,
@@ -349,11 +360,7 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 
 	if commandType == "START_WORKFLOW" {
 		// Update progress: starting workflow
-		integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, map[string]interface{}{
-			"step":       "starting_workflow",
-			"percentage": 10,
-			"message":    fmt.Sprintf("Đang khởi động workflow: %s", workflowId),
-		})
+		sendWorkflowProgress(agentId, commandID, tracker, &sentVersion, "starting_workflow", 10, fmt.Sprintf("Đang khởi động workflow: %s", workflowId))
 
 		jobLogger.WithFields(map[string]interface{}{
 			"command_id":    commandID,
@@ -365,8 +372,32 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 		jobLogger.WithField("command_id", commandID).Debug("Gọi executor.ExecuteWorkflow...")
 
 		// Tạo workflow executor và thực thi workflow
-		executor := services.NewWorkflowExecutor()
+		executor := services.NewWorkflowExecutor().WithProgress(tracker).WithContext(ctx)
 		workflowRunID, err = executor.ExecuteWorkflow(workflowId, rootRefId, rootRefType, params, agentId, commandID)
+		if err != nil && errors.Is(err, context.Canceled) {
+			jobLogger.WithField("command_id", commandID).Warnf("⛔ Workflow bị hủy: %v", context.Cause(ctx))
+			integrations.FolkForm_UpdateWorkflowCommand(commandID, "cancelled", map[string]interface{}{
+				"error":         context.Cause(ctx).Error(),
+				"workflowRunId": workflowRunID,
+			})
+			done <- true
+			return
+		}
+		// Step HUMAN_REVIEW đang chờ duyệt: kết thúc command (giải phóng slot), server giao lại command khi có kết quả duyệt
+		var waitingErr *services.StepWaitingReviewError
+		if errors.As(err, &waitingErr) {
+			jobLogger.WithFields(map[string]interface{}{
+				"command_id":      commandID,
+				"workflow_run_id": workflowRunID,
+				"step_run_ids":    waitingErr.StepRunIDs,
+			}).Info("⏸️  Workflow tạm dừng chờ duyệt, giải phóng worker")
+			integrations.FolkForm_UpdateWorkflowCommand(commandID, "waiting_review", map[string]interface{}{
+				"workflowRunId": workflowRunID,
+				"stepRunIds":    waitingErr.StepRunIDs,
+			})
+			done <- true
+			return
+		}
 		if err != nil {
 			jobLogger.WithError(err).WithField("command_id", commandID).Error("❌ Lỗi khi execute workflow")
 			// Update command status = "failed"
@@ -384,11 +415,8 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 		}).Debug("ExecuteWorkflow trả về thành công, chuẩn bị update heartbeat và command completed")
 
 		// Update progress: completed
-		integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, map[string]interface{}{
-			"step":       "completed",
-			"percentage": 100,
-			"message":    fmt.Sprintf("Workflow đã hoàn thành: %s", workflowRunID),
-		})
+		tracker.SetRange(0, 100)
+		sendWorkflowProgress(agentId, commandID, tracker, &sentVersion, "completed", 100, fmt.Sprintf("Workflow đã hoàn thành: %s", workflowRunID))
 
 		// Update command status = "completed"
 		resultData := map[string]interface{}{
@@ -414,11 +442,7 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 
 	} else if commandType == "EXECUTE_STEP" {
 		// Update progress: starting step
-		integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, map[string]interface{}{
-			"step":       "starting_step",
-			"percentage": 10,
-			"message":    fmt.Sprintf("Đang khởi động step: %s", stepId),
-		})
+		sendWorkflowProgress(agentId, commandID, tracker, &sentVersion, "starting_step", 10, fmt.Sprintf("Đang khởi động step: %s", stepId))
 
 		jobLogger.WithFields(map[string]interface{}{
 			"command_id":    commandID,
@@ -449,8 +473,17 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 		}).Debug("loadRootContentForStep thành công, gọi ExecuteStep...")
 
 		// Tạo step executor và thực thi step
-		stepExecutor := services.NewStepExecutor(services.NewAIClientService())
+		tracker.SetRange(10, 95)
+		stepExecutor := services.NewStepExecutor(services.NewAIClientService()).WithProgress(tracker).WithCacheMode(services.AICacheModeFromParams(params)).WithContext(ctx)
 		stepResult, err := stepExecutor.ExecuteStep(stepId, rootRefId, rootRefType, "", rootContent)
+		if err != nil && errors.Is(err, context.Canceled) {
+			jobLogger.WithField("command_id", commandID).Warnf("⛔ Step bị hủy: %v", context.Cause(ctx))
+			integrations.FolkForm_UpdateWorkflowCommand(commandID, "cancelled", map[string]interface{}{
+				"error": context.Cause(ctx).Error(),
+			})
+			done <- true
+			return
+		}
 		if err != nil {
 			jobLogger.WithError(err).WithField("command_id", commandID).Error("❌ Lỗi khi execute step")
 			jobLogger.WithField("command_id", commandID).Debug("Gọi FolkForm_UpdateWorkflowCommand status=failed (execute step)")
@@ -467,11 +500,8 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 		}).Debug("ExecuteStep trả về thành công")
 
 		// Update progress: completed
-		integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, map[string]interface{}{
-			"step":       "completed",
-			"percentage": 100,
-			"message":    fmt.Sprintf("Step đã hoàn thành: %s", stepId),
-		})
+		tracker.SetRange(0, 100)
+		sendWorkflowProgress(agentId, commandID, tracker, &sentVersion, "completed", 100, fmt.Sprintf("Step đã hoàn thành: %s", stepId))
 
 		// Update command status = "completed"
 		resultData := map[string]interface{}{
@@ -506,6 +536,30 @@ func processWorkflowCommand(commandID string, cmdMap map[string]interface{}, age
 	done <- true
 }
 
+// checkWorkflowCommandCancel hủy context của command nếu phản hồi heartbeat báo server yêu cầu hủy
+// (data.cancelRequested = true hoặc data.status = "cancelling"/"cancelled")
+func checkWorkflowCommandCancel(resp map[string]interface{}, commandID string, cancel context.CancelCauseFunc) {
+	data, ok := resp["data"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	requested, _ := data["cancelRequested"].(bool)
+	status, _ := data["status"].(string)
+	if requested || status == "cancelling" || status == "cancelled" {
+		GetJobLoggerByName("workflow-commands-job").WithField("command_id", commandID).Warn("⛔ Server yêu cầu hủy command, đang dừng...")
+		cancel(errors.New("server yêu cầu hủy command"))
+	}
+}
+
+// sendWorkflowProgress cập nhật tracker và gửi tiến độ lên server ngay (dùng cho các mốc chính của command)
+func sendWorkflowProgress(agentId, commandID string, tracker *services.ProgressTracker, sentVersion *atomic.Uint64, step string, percentage int, message string) {
+	tracker.Update(step, percentage, message)
+	progress, version := tracker.Snapshot()
+	if _, err := integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, progress); err == nil {
+		sentVersion.Store(version)
+	}
+}
+
 // loadRootContentForStep load root content cho step execution.
 // Thử GetContentNode (production) trước, nếu lỗi thì thử GetDraftNode.
 // commandID dùng cho log debug.
@@ -572,11 +626,3 @@ func loadRootContentForStep(commandID, rootRefId, rootRefType string) (map[strin
 	}).Warn("GetDraftNode trả về nhưng không có data map")
 	return nil, fmt.Errorf("không thể parse content node response")
 }
-
-// getWorkflowCommandsJobInstance lấy instance của WorkflowCommandsJob từ global variable
-// Hàm này dùng để truy cập activeWorkers map
-func getWorkflowCommandsJobInstance() *WorkflowCommandsJob {
-	globalWorkflowCommandsJobMu.RLock()
-	defer globalWorkflowCommandsJobMu.RUnlock()
-	return globalWorkflowCommandsJob
-}
@@ -8,10 +8,13 @@ File này cung cấp các thành phần cơ bản để xây dựng một job:
 package scheduler
 
 import (
+	"agent_pancake/app/integrations/apierror"
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
@@ -82,6 +85,12 @@ type JobMetrics struct {
 	LastRunDuration float64   `json:"lastRunDuration"`     // Thời gian chạy lần cuối (giây)
 	LastRunStatus   string    `json:"lastRunStatus"`       // "success" hoặc "failed"
 	LastError       string    `json:"lastError,omitempty"` // Lỗi lần cuối (nếu có)
+	// LastErrorCategory là nhóm lỗi của lần chạy cuối (apierror.Category: unauthorized, rate_limited, network...)
+	LastErrorCategory string `json:"lastErrorCategory,omitempty"`
+	// ConsecutiveFailures là số lần thất bại liên tiếp gần nhất (reset về 0 khi chạy thành công)
+	ConsecutiveFailures int64 `json:"consecutiveFailures"`
+	// LastSuccessAt là thời điểm chạy thành công lần cuối (zero nếu chưa thành công lần nào)
+	LastSuccessAt time.Time `json:"lastSuccessAt"`
 
 	// Thống kê duration (giữ 100 lần chạy gần nhất để tính avg/max)
 	durations    []float64
@@ -189,10 +198,15 @@ func (j *BaseJob) updateMetrics(err error, duration float64) {
 		j.metrics.ErrorCount++
 		j.metrics.LastRunStatus = "failed"
 		j.metrics.LastError = err.Error()
+		j.metrics.LastErrorCategory = string(apierror.Classify(err))
+		j.metrics.ConsecutiveFailures++
 	} else {
 		j.metrics.SuccessCount++
+		j.metrics.ConsecutiveFailures = 0
+		j.metrics.LastSuccessAt = j.metrics.LastRunAt
 		j.metrics.LastRunStatus = "success"
 		j.metrics.LastError = "" // Clear error nếu thành công
+		j.metrics.LastErrorCategory = ""
 	}
 }
 
@@ -229,6 +243,10 @@ func (j *BaseJob) GetMetrics() JobMetrics {
 		LastRunDuration: j.metrics.LastRunDuration,
 		LastRunStatus:   j.metrics.LastRunStatus,
 		LastError:       j.metrics.LastError,
+
+		LastErrorCategory:   j.metrics.LastErrorCategory,
+		ConsecutiveFailures: j.metrics.ConsecutiveFailures,
+		LastSuccessAt:       j.metrics.LastSuccessAt,
 	}
 
 	// Copy durations
@@ -273,6 +291,26 @@ func (j *BaseJob) GetMaxDuration() float64 {
 	return max
 }
 
+// DurationPercentile trả về duration (giây) ở percentile p (0-100) của các lần chạy gần nhất
+// Trả về 0 nếu chưa có lần chạy nào
+func (m JobMetrics) DurationPercentile(p float64) float64 {
+	if len(m.durations) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(m.durations))
+	copy(sorted, m.durations)
+	sort.Float64s(sorted)
+
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
 // IsRunning kiểm tra xem job có đang chạy không (thread-safe)
 func (j *BaseJob) IsRunning() bool {
 	j.mu.Lock()
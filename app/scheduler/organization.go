@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// OrganizationResult là kết quả lần chạy gần nhất của một job trong một organization context (role)
+// Dùng khi agent phục vụ nhiều organization (AGENT_ROLE_IDS), được gửi kèm jobStatus trong check-in
+type OrganizationResult struct {
+	RoleId          string  `json:"roleId"`
+	LastRunAt       int64   `json:"lastRunAt"`       // Unix timestamp
+	LastRunDuration float64 `json:"lastRunDuration"` // Giây
+	LastRunStatus   string  `json:"lastRunStatus"`   // "success" hoặc "failed"
+	LastError       string  `json:"lastError,omitempty"`
+}
+
+// organizationResults lưu kết quả theo job → role
+var (
+	organizationResults   = make(map[string]map[string]OrganizationResult)
+	organizationResultsMu sync.RWMutex
+)
+
+// RecordOrganizationResult ghi nhận kết quả chạy job trong một organization context
+func RecordOrganizationResult(jobName, roleId string, err error, duration time.Duration) {
+	result := OrganizationResult{
+		RoleId:          roleId,
+		LastRunAt:       time.Now().Unix(),
+		LastRunDuration: duration.Seconds(),
+		LastRunStatus:   "success",
+	}
+	if err != nil {
+		result.LastRunStatus = "failed"
+		result.LastError = err.Error()
+	}
+
+	organizationResultsMu.Lock()
+	defer organizationResultsMu.Unlock()
+	if organizationResults[jobName] == nil {
+		organizationResults[jobName] = make(map[string]OrganizationResult)
+	}
+	organizationResults[jobName][roleId] = result
+}
+
+// GetOrganizationResults trả về kết quả theo organization của job (sắp xếp theo roleId, nil nếu chưa có)
+func GetOrganizationResults(jobName string) []OrganizationResult {
+	organizationResultsMu.RLock()
+	defer organizationResultsMu.RUnlock()
+
+	byRole := organizationResults[jobName]
+	if len(byRole) == 0 {
+		return nil
+	}
+	results := make([]OrganizationResult, 0, len(byRole))
+	for _, result := range byRole {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, k int) bool { return results[i].RoleId < results[k].RoleId })
+	return results
+}
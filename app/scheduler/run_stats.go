@@ -0,0 +1,222 @@
+package scheduler
+
+import (
+	"agent_pancake/app/integrations/apierror"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxRunStatsErrors giới hạn số lỗi chi tiết giữ lại cho một lần chạy (ErrorCount vẫn đếm đủ)
+const maxRunStatsErrors = 20
+
+// RunStats là số liệu của một lần chạy job cụ thể (chạy qua RunJobNowContext, ví dụ command run_job).
+// Job ghi vào trong lúc chạy qua RunStatsFor(jobName): số item đã sync, lỗi, tiến độ.
+// Các method an toàn khi RunStats là nil (job chạy theo lịch cron không có RunStats).
+type RunStats struct {
+	mu         sync.Mutex
+	items      map[string]int64
+	errors     []string
+	errorCount int
+	percentage int
+	message    string
+}
+
+// RunStatsSnapshot là bản chụp RunStats (gửi lên server trong progress và result của command)
+type RunStatsSnapshot struct {
+	ItemsSynced map[string]int64 `json:"itemsSynced,omitempty"` // Số item theo loại (pages, conversations, organizations...)
+	Errors      []string         `json:"errors,omitempty"`      // Tối đa maxRunStatsErrors lỗi đầu tiên
+	ErrorCount  int              `json:"errorCount"`
+	Percentage  int              `json:"percentage"`
+	Message     string           `json:"message,omitempty"`
+}
+
+// JobRunResult là kết quả của đúng một lần chạy job (không lấy từ metrics tổng của job)
+type JobRunResult struct {
+	JobExecutionResult
+	RunStatsSnapshot
+	ErrorCategory string               `json:"errorCategory,omitempty"`
+	TimedOut      bool                 `json:"timedOut,omitempty"`      // Quá timeout, job đã dừng giữa chừng
+	Organizations []OrganizationResult `json:"organizations,omitempty"` // Kết quả theo organization của lần chạy này
+}
+
+// activeRunStats lưu RunStats của lần chạy đang diễn ra theo tên job
+// (BaseJob không cho một job chạy song song nên mỗi job có tối đa một lần chạy)
+var (
+	activeRunStats   = make(map[string]*RunStats)
+	activeRunStatsMu sync.RWMutex
+)
+
+// RunStatsFor trả về RunStats của lần chạy đang diễn ra của job (nil nếu job không chạy qua RunJobNowContext)
+func RunStatsFor(jobName string) *RunStats {
+	activeRunStatsMu.RLock()
+	defer activeRunStatsMu.RUnlock()
+	return activeRunStats[jobName]
+}
+
+// AddItems cộng số item đã sync theo loại
+func (r *RunStats) AddItems(kind string, count int) {
+	if r == nil || count == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.items == nil {
+		r.items = make(map[string]int64)
+	}
+	r.items[kind] += int64(count)
+}
+
+// AddError ghi nhận một lỗi của lần chạy (job vẫn có thể tiếp tục)
+func (r *RunStats) AddError(err error) {
+	if r == nil || err == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errorCount++
+	if len(r.errors) < maxRunStatsErrors {
+		r.errors = append(r.errors, err.Error())
+	}
+}
+
+// SetProgress cập nhật tiến độ (0-100, không giảm) và mô tả bước đang chạy
+func (r *RunStats) SetProgress(percentage int, message string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if percentage > 100 {
+		percentage = 100
+	}
+	if percentage > r.percentage {
+		r.percentage = percentage
+	}
+	r.message = message
+}
+
+// Snapshot trả về bản chụp số liệu hiện tại
+func (r *RunStats) Snapshot() RunStatsSnapshot {
+	if r == nil {
+		return RunStatsSnapshot{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := RunStatsSnapshot{
+		ErrorCount: r.errorCount,
+		Percentage: r.percentage,
+		Message:    r.message,
+		Errors:     append([]string(nil), r.errors...),
+	}
+	if len(r.items) > 0 {
+		snapshot.ItemsSynced = make(map[string]int64, len(r.items))
+		for kind, count := range r.items {
+			snapshot.ItemsSynced[kind] = count
+		}
+	}
+	return snapshot
+}
+
+// RunJobNowContext chạy job ngay và đợi kết quả của đúng lần chạy này, có timeout theo ctx.
+// - onProgress (có thể nil) được gọi mỗi progressInterval với số liệu hiện tại của lần chạy
+// - Job đang chạy (theo lịch) → trả về lỗi thay vì báo thành công cho lần chạy bị bỏ qua
+// - ctx hết hạn → job dừng giữa các page/batch, hàm đợi job trả về rồi mới trả kết quả TimedOut
+func (s *Scheduler) RunJobNowContext(ctx context.Context, name string, onProgress func(RunStatsSnapshot), progressInterval time.Duration) (*JobRunResult, error) {
+	s.mu.RLock()
+	job, exists := s.jobObjects[name]
+	s.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("job không tồn tại: %s", name)
+	}
+	if running, ok := job.(RunningProvider); ok && running.IsRunning() {
+		return nil, fmt.Errorf("job %s đang chạy, thử lại sau khi lần chạy hiện tại kết thúc", name)
+	}
+
+	var runCountBefore int64
+	metricsProvider, hasMetrics := job.(MetricsProvider)
+	if hasMetrics {
+		runCountBefore = metricsProvider.GetMetrics().RunCount
+	}
+
+	stats := &RunStats{}
+	activeRunStatsMu.Lock()
+	activeRunStats[name] = stats
+	activeRunStatsMu.Unlock()
+
+	startTime := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		err := job.Execute(ctx)
+		activeRunStatsMu.Lock()
+		if activeRunStats[name] == stats {
+			delete(activeRunStats, name)
+		}
+		activeRunStatsMu.Unlock()
+		done <- err
+	}()
+
+	if progressInterval <= 0 {
+		progressInterval = 10 * time.Second
+	}
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	// ctx hết hạn → job dừng ở lần kiểm tra ctx tiếp theo; vẫn đợi job trả về để giữ slot của command tới khi job thực sự dừng
+	var err error
+	timedOut := false
+	ctxDone := ctx.Done()
+wait:
+	for {
+		select {
+		case err = <-done:
+			break wait
+		case <-ticker.C:
+			if onProgress != nil {
+				onProgress(stats.Snapshot())
+			}
+		case <-ctxDone:
+			timedOut = true
+			ctxDone = nil
+		}
+	}
+	if timedOut {
+		err = fmt.Errorf("job %s chạy quá thời gian cho phép, đã dừng giữa chừng sau %v: %w", name, time.Since(startTime).Round(time.Second), context.Cause(ctx))
+	}
+
+	// Execute trả về ngay khi job đang chạy theo lịch (race với cron) → lần chạy này không thực sự diễn ra
+	if !timedOut && err == nil && hasMetrics && metricsProvider.GetMetrics().RunCount == runCountBefore {
+		err = errors.New("job đang chạy theo lịch nên lần chạy này bị bỏ qua")
+	}
+
+	duration := time.Since(startTime)
+	result := &JobRunResult{
+		JobExecutionResult: JobExecutionResult{
+			JobName:     name,
+			Success:     err == nil,
+			Duration:    duration.Seconds(),
+			StartedAt:   startTime.Unix(),
+			CompletedAt: time.Now().Unix(),
+		},
+		RunStatsSnapshot: stats.Snapshot(),
+		TimedOut:         timedOut,
+	}
+	if hasMetrics {
+		metrics := metricsProvider.GetMetrics()
+		result.RunCount = metrics.RunCount
+		result.LastRunStatus = metrics.LastRunStatus
+		result.LastRunDuration = metrics.LastRunDuration
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorCategory = string(apierror.Classify(err))
+	}
+	for _, org := range GetOrganizationResults(name) {
+		if org.LastRunAt >= startTime.Unix() {
+			result.Organizations = append(result.Organizations, org)
+		}
+	}
+	return result, err
+}
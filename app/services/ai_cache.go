@@ -0,0 +1,272 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa cache response của AI trên đĩa (tắt mặc định, bật bằng config "aiCacheEnabled" của workflow-commands-job):
+  - Key là SHA256 của (provider, base URL, model, system prompt, messages, prompt, temperature, max tokens, response schema)
+    sau khi resolve giá trị mặc định của profile, nên cùng một request luôn ra cùng key
+  - Mỗi entry là một file JSON trong AGENT_AI_CACHE_DIR (mặc định ./cache/ai), hết hạn theo "aiCacheTTLHours"
+  - Tổng dung lượng giới hạn bởi "aiCacheMaxSizeMB", vượt thì xóa các entry cũ nhất
+  - Command có thể bỏ qua cache qua params: {"aiCache": "refresh"} (không đọc, vẫn ghi) hoặc {"aiCache": "off"}
+*/
+package services
+
+import (
+	"agent_pancake/global"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AICacheMode là cách một request dùng cache
+type AICacheMode string
+
+const (
+	AICacheModeUse     AICacheMode = ""        // Mặc định: đọc cache nếu có, ghi response mới vào cache
+	AICacheModeRefresh AICacheMode = "refresh" // Không đọc cache (luôn gọi provider), vẫn ghi response mới
+	AICacheModeOff     AICacheMode = "off"     // Không đọc, không ghi
+)
+
+// Giá trị mặc định của config cache
+const (
+	defaultAICacheTTLHours  = 24
+	defaultAICacheMaxSizeMB = 200
+	aiCachePruneInterval    = time.Minute // Khoảng cách tối thiểu giữa hai lần quét dung lượng
+)
+
+// AICacheModeFromParams đọc cache mode từ params của command:
+// "aiCache": "refresh" | "off" | false, hoặc "bypassCache": true (tương đương "refresh")
+func AICacheModeFromParams(params map[string]interface{}) AICacheMode {
+	switch value := params["aiCache"].(type) {
+	case string:
+		switch AICacheMode(strings.ToLower(value)) {
+		case AICacheModeRefresh:
+			return AICacheModeRefresh
+		case AICacheModeOff:
+			return AICacheModeOff
+		}
+	case bool:
+		if !value {
+			return AICacheModeOff
+		}
+	}
+	if bypass, ok := params["bypassCache"].(bool); ok && bypass {
+		return AICacheModeRefresh
+	}
+	return AICacheModeUse
+}
+
+// aiCacheEntry là nội dung một file cache
+type aiCacheEntry struct {
+	Key          string    `json:"key"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	ProfileID    string    `json:"providerProfileId"`
+	Content      string    `json:"content"`
+	FinishReason string    `json:"finishReason"`
+	Usage        *AIUsage  `json:"usage,omitempty"`
+}
+
+// aiCacheSettings là config cache đọc từ workflow-commands-job (server cập nhật được)
+type aiCacheSettings struct {
+	enabled bool
+	ttl     time.Duration
+	maxSize int64
+}
+
+func currentAICacheSettings() aiCacheSettings {
+	settings := aiCacheSettings{
+		ttl:     defaultAICacheTTLHours * time.Hour,
+		maxSize: defaultAICacheMaxSizeMB << 20,
+	}
+	cm := GetGlobalConfigManager()
+	if cm == nil {
+		return settings
+	}
+	settings.enabled = cm.GetJobConfigBool(workflowCommandsJobName, "aiCacheEnabled", false)
+	settings.ttl = time.Duration(cm.GetJobConfigInt(workflowCommandsJobName, "aiCacheTTLHours", defaultAICacheTTLHours)) * time.Hour
+	settings.maxSize = int64(cm.GetJobConfigInt(workflowCommandsJobName, "aiCacheMaxSizeMB", defaultAICacheMaxSizeMB)) << 20
+	return settings
+}
+
+// AIResponseCache là cache response AI trên đĩa, dùng chung cho mọi AIClientService
+type AIResponseCache struct {
+	dir       string
+	mu        sync.Mutex // Bảo vệ việc ghi/xóa file và lastPrune
+	lastPrune time.Time
+}
+
+var (
+	globalAICache     *AIResponseCache
+	globalAICacheOnce sync.Once
+)
+
+// getAIResponseCache trả về cache dùng chung (thư mục từ AGENT_AI_CACHE_DIR)
+func getAIResponseCache() *AIResponseCache {
+	globalAICacheOnce.Do(func() {
+		dir := "./cache/ai"
+		if global.GlobalConfig != nil && global.GlobalConfig.AICacheDir != "" {
+			dir = global.GlobalConfig.AICacheDir
+		}
+		globalAICache = &AIResponseCache{dir: dir}
+	})
+	return globalAICache
+}
+
+// aiCacheKey tính key của request (giá trị đã resolve theo profile để default thay đổi thì key cũng đổi)
+func aiCacheKey(req AICallRequest) string {
+	profile := req.ProviderProfile
+	keyData := map[string]interface{}{
+		"provider":       profile.Provider,
+		"baseUrl":        profile.BaseURL,
+		"model":          resolveModel(req, ""),
+		"systemPrompt":   req.SystemPrompt,
+		"messages":       req.Messages,
+		"prompt":         req.Prompt,
+		"temperature":    resolveTemperature(req),
+		"maxTokens":      resolveMaxTokens(req),
+		"responseSchema": req.ResponseSchema,
+	}
+	// json.Marshal sắp xếp key của map nên kết quả ổn định
+	data, _ := json.Marshal(keyData)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *AIResponseCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// Get đọc entry còn hạn, entry hết hạn hoặc hỏng bị xóa
+func (c *AIResponseCache) Get(key string, ttl time.Duration) (*aiCacheEntry, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry aiCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key {
+		log.Printf("[AIClient] [Cache] ⚠️  Entry %s hỏng, xóa", key[:12])
+		c.remove(key)
+		return nil, false
+	}
+	if ttl > 0 && time.Since(entry.CreatedAt) > ttl {
+		c.remove(key)
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Put ghi entry (ghi file tạm rồi rename để không đọc phải file ghi dở), sau đó dọn cache nếu vượt dung lượng
+func (c *AIResponseCache) Put(key string, resp *AICallResponse, maxSize int64) error {
+	entry := aiCacheEntry{
+		Key:          key,
+		CreatedAt:    time.Now(),
+		Provider:     resp.Provider,
+		Model:        resp.Model,
+		ProfileID:    resp.ProviderProfileID,
+		Content:      resp.Content,
+		FinishReason: resp.FinishReason,
+		Usage:        resp.Usage,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("không tạo được thư mục cache: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("không ghi được cache: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("không ghi được cache: %w", err)
+	}
+
+	if maxSize > 0 && time.Since(c.lastPrune) >= aiCachePruneInterval {
+		c.lastPrune = time.Now()
+		c.prune(maxSize)
+	}
+	return nil
+}
+
+func (c *AIResponseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	os.Remove(c.path(key))
+}
+
+// prune xóa các entry cũ nhất (theo thời gian sửa file) đến khi tổng dung lượng còn 90% maxSize
+// Gọi khi đang giữ c.mu
+func (c *AIResponseCache) prune(maxSize int64) {
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cacheFile
+	var total int64
+	_ = filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if total <= maxSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	target := maxSize * 9 / 10
+	removed := 0
+	for _, file := range files {
+		if total <= target {
+			break
+		}
+		if os.Remove(file.path) == nil {
+			total -= file.size
+			removed++
+		}
+	}
+	log.Printf("[AIClient] [Cache] 🧹 Cache vượt %d MB, đã xóa %d entry cũ nhất", maxSize>>20, removed)
+}
+
+// response tạo response từ entry cache (Latency ~0, CacheHit = true)
+func (e *aiCacheEntry) response(startTime time.Time) *AICallResponse {
+	var usage *AIUsage
+	if e.Usage != nil {
+		copied := *e.Usage
+		usage = &copied
+	}
+	return &AICallResponse{
+		Content:           e.Content,
+		Model:             e.Model,
+		Usage:             usage,
+		FinishReason:      e.FinishReason,
+		Latency:           time.Since(startTime),
+		Provider:          e.Provider,
+		ProviderProfileID: e.ProfileID,
+		CacheHit:          true,
+		CacheKey:          e.Key,
+	}
+}
@@ -5,7 +5,9 @@ File này chứa AI Client Service - service để gọi AI provider APIs (OpenA
 package services
 
 import (
+	"agent_pancake/app/integrations/apierror"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,27 +28,44 @@ const (
 
 // AIProviderProfile là cấu trúc provider profile từ backend
 type AIProviderProfile struct {
-	ID                string                 `json:"id"`
-	Name              string                 `json:"name"`
-	Provider          string                 `json:"provider"`
-	APIKey            string                 `json:"apiKey"`
-	BaseURL           string                 `json:"baseUrl,omitempty"`
-	OrganizationID    string                 `json:"organizationId,omitempty"`
-	DefaultModel      string                 `json:"defaultModel,omitempty"`
-	DefaultTemperature *float64              `json:"defaultTemperature,omitempty"`
-	DefaultMaxTokens   *int                  `json:"defaultMaxTokens,omitempty"`
-	Config            map[string]interface{} `json:"config,omitempty"`
+	ID                 string                 `json:"id"`
+	Name               string                 `json:"name"`
+	Provider           string                 `json:"provider"`
+	APIKey             string                 `json:"apiKey"`
+	BaseURL            string                 `json:"baseUrl,omitempty"`
+	OrganizationID     string                 `json:"organizationId,omitempty"`
+	DefaultModel       string                 `json:"defaultModel,omitempty"`
+	DefaultTemperature *float64               `json:"defaultTemperature,omitempty"`
+	DefaultMaxTokens   *int                   `json:"defaultMaxTokens,omitempty"`
+	Config             map[string]interface{} `json:"config,omitempty"`
 }
 
 // AICallRequest là request để gọi AI API
 type AICallRequest struct {
 	ProviderProfile *AIProviderProfile
-	Model           string  // Model cụ thể (nếu không có thì dùng DefaultModel)
-	Prompt          string  // Prompt text
-	Temperature     *float64 // Temperature (nếu không có thì dùng DefaultTemperature)
-	MaxTokens       *int    // Max tokens (nếu không có thì dùng DefaultMaxTokens)
-	SystemPrompt    string  // System prompt (optional)
+	Model           string      // Model cụ thể (nếu không có thì dùng DefaultModel)
+	Prompt          string      // Prompt text
+	Temperature     *float64    // Temperature (nếu không có thì dùng DefaultTemperature)
+	MaxTokens       *int        // Max tokens (nếu không có thì dùng DefaultMaxTokens)
+	SystemPrompt    string      // System prompt (optional)
 	Messages        []AIMessage // Conversation history (optional)
+
+	// JSON Schema của output (optional). Có schema → bật structured output của provider nếu hỗ trợ
+	// (OpenAI response_format, Anthropic tool use, Gemini JSON mode, Cohere json_object), xem nativeStructuredOutput
+	ResponseSchema map[string]interface{}
+
+	CacheMode AICacheMode // Cách dùng cache response (xem ai_cache.go), chỉ áp dụng trong CallWithFallback
+
+	// Context để hủy request đang chạy (workflow command bị hủy từ server), nil = context.Background()
+	Context context.Context
+}
+
+// ctx trả về context của request (context.Background() nếu không gắn)
+func (r AICallRequest) ctx() context.Context {
+	if r.Context != nil {
+		return r.Context
+	}
+	return context.Background()
 }
 
 // AIMessage là message trong conversation
@@ -60,26 +79,41 @@ type AICallResponse struct {
 	Content      string        // Response text
 	Model        string        // Model được sử dụng
 	Usage        *AIUsage      // Token usage
-	FinishReason string      // Finish reason
+	FinishReason string        // Finish reason
 	Latency      time.Duration // Thời gian gọi API
 	Error        error         // Lỗi nếu có
+
+	// Provider thực sự đã trả lời (khác provider chính khi dùng fallback, xem CallWithFallback)
+	Provider          string
+	ProviderProfileID string
+
+	CacheHit bool   // true nếu response lấy từ cache (không gọi provider, không tốn chi phí)
+	CacheKey string // Key cache của request (rỗng nếu cache tắt)
 }
 
-// AIUsage là thông tin token usage
+// AIUsage là thông tin token usage (đã chuẩn hóa giữa các provider, xem ai_usage.go)
 type AIUsage struct {
-	PromptTokens     int `json:"promptTokens"`
-	CompletionTokens int `json:"completionTokens"`
-	TotalTokens      int `json:"totalTokens"`
+	PromptTokens           int  `json:"promptTokens"`                     // Tổng token input (kể cả phần đọc/ghi prompt cache)
+	CompletionTokens       int  `json:"completionTokens"`                 // Tổng token output (kể cả thinking/reasoning)
+	TotalTokens            int  `json:"totalTokens"`                      // PromptTokens + CompletionTokens
+	CachedPromptTokens     int  `json:"cachedPromptTokens,omitempty"`     // Phần input đọc từ prompt cache (tính giá riêng nếu pricing có)
+	CacheWritePromptTokens int  `json:"cacheWritePromptTokens,omitempty"` // Phần input ghi vào prompt cache (Anthropic, tính giá cacheWrite)
+	Estimated              bool `json:"estimated,omitempty"`              // true nếu provider không trả usage, số token được ước lượng theo ký tự
 }
 
 // AIClient là interface cho AI client
 type AIClient interface {
 	Call(req AICallRequest) (*AICallResponse, error)
+	CallStream(req AICallRequest, onDelta func(AIStreamDelta)) (*AICallResponse, error)
 }
 
 // AIClientService là service để gọi AI provider APIs
 type AIClientService struct {
 	httpClient *http.Client
+
+	// streamClient dùng cho CallStream: không đặt Timeout tổng (response dài có thể stream vài phút),
+	// thay vào đó giới hạn thời gian chờ header và thời gian không nhận được dữ liệu (xem ai_stream.go)
+	streamClient *http.Client
 }
 
 // NewAIClientService tạo một instance mới của AIClientService
@@ -88,7 +122,85 @@ func NewAIClientService() *AIClientService {
 		httpClient: &http.Client{
 			Timeout: 120 * time.Second, // Timeout 2 phút cho AI calls
 		},
+		streamClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: aiStreamHeaderTimeout,
+			},
+		},
+	}
+}
+
+// aiHTTPRequest là HTTP request đã chuẩn bị cho một provider (dùng chung cho Call và CallStream)
+type aiHTTPRequest struct {
+	provider  string // Provider type (openai, anthropic...), dùng cho lỗi có kiểu
+	httpReq   *http.Request
+	model     string // Model đã resolve (request → profile → default của provider)
+	maxTokens int    // Max tokens đã resolve (dùng để ước lượng tiến độ khi stream)
+}
+
+// aiErrorFromResponse tạo lỗi có kiểu (apierror) từ response lỗi của provider
+// 429 → ErrRateLimited (kèm Retry-After), 5xx → ErrUpstream, 401/403 → ErrUnauthorized, 400/422 → ErrValidation...
+func aiErrorFromResponse(prepared *aiHTTPRequest, resp *http.Response, body []byte) error {
+	return apierror.FromResponse(aiSystemName(prepared.provider), prepared.httpReq.URL.Path, resp.StatusCode, resp.Header, body)
+}
+
+// aiNetworkError tạo lỗi mạng có kiểu khi không gọi được provider (timeout, connection refused...)
+func aiNetworkError(prepared *aiHTTPRequest, err error) error {
+	return apierror.NewNetwork(aiSystemName(prepared.provider), prepared.httpReq.URL.Path, err)
+}
+
+// aiSystemName là tên hệ thống của provider trong lỗi có kiểu (ví dụ: "AI/openai")
+func aiSystemName(provider string) string {
+	return apierror.SystemAI + "/" + provider
+}
+
+// resolveModel lấy model theo thứ tự: request → DefaultModel của profile → default của provider
+func resolveModel(req AICallRequest, providerDefault string) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	if req.ProviderProfile.DefaultModel != "" {
+		return req.ProviderProfile.DefaultModel
 	}
+	return providerDefault
+}
+
+// resolveTemperature lấy temperature theo thứ tự: request → DefaultTemperature của profile → 0.7
+func resolveTemperature(req AICallRequest) float64 {
+	if req.Temperature != nil {
+		return *req.Temperature
+	}
+	if req.ProviderProfile.DefaultTemperature != nil {
+		return *req.ProviderProfile.DefaultTemperature
+	}
+	return 0.7
+}
+
+// resolveMaxTokens lấy max tokens theo thứ tự: request → DefaultMaxTokens của profile → 2000
+func resolveMaxTokens(req AICallRequest) int {
+	if req.MaxTokens != nil {
+		return *req.MaxTokens
+	}
+	if req.ProviderProfile.DefaultMaxTokens != nil {
+		return *req.ProviderProfile.DefaultMaxTokens
+	}
+	return 2000
+}
+
+// newJSONRequest tạo HTTP POST request với body JSON
+func newJSONRequest(url string, requestBody map[string]interface{}) (*http.Request, error) {
+	bodyBytes, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi marshal request body: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi tạo HTTP request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return httpReq, nil
 }
 
 // Call gọi AI provider API dựa trên provider type
@@ -99,48 +211,83 @@ func (s *AIClientService) Call(req AICallRequest) (*AICallResponse, error) {
 
 	startTime := time.Now()
 
+	var resp *AICallResponse
+	var err error
 	switch req.ProviderProfile.Provider {
 	case AIProviderTypeOpenAI:
-		return s.callOpenAI(req, startTime)
+		resp, err = s.callOpenAI(req, startTime)
 	case AIProviderTypeAnthropic:
-		return s.callAnthropic(req, startTime)
+		resp, err = s.callAnthropic(req, startTime)
 	case AIProviderTypeGoogle:
-		return s.callGoogle(req, startTime)
+		resp, err = s.callGoogle(req, startTime)
 	case AIProviderTypeCohere:
-		return s.callCohere(req, startTime)
+		resp, err = s.callCohere(req, startTime)
 	case AIProviderTypeCustom:
-		return s.callCustom(req, startTime)
+		resp, err = s.callCustom(req, startTime)
 	default:
 		return nil, fmt.Errorf("provider type không được hỗ trợ: %s", req.ProviderProfile.Provider)
 	}
+
+	if err == nil && resp != nil && resp.Error == nil {
+		resp.Usage = finalizeUsage(resp.Usage, req, resp.Content)
+	}
+	return resp, err
 }
 
-// callOpenAI gọi OpenAI API
-func (s *AIClientService) callOpenAI(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+// buildOpenAIRequest chuẩn bị request cho OpenAI Chat Completions API
+func buildOpenAIRequest(req AICallRequest, stream bool) (*aiHTTPRequest, error) {
 	profile := req.ProviderProfile
-	model := req.Model
-	if model == "" {
-		model = profile.DefaultModel
+	model := resolveModel(req, "gpt-4")
+	temperature := resolveTemperature(req)
+	maxTokens := resolveMaxTokens(req)
+
+	// Chuẩn bị request body
+	requestBody := map[string]interface{}{
+		"model":       model,
+		"messages":    buildOpenAIMessages(req),
+		"temperature": temperature,
+		"max_tokens":  maxTokens,
+	}
+	if nativeStructuredOutput(req) {
+		requestBody["response_format"] = openAIResponseFormat(req.ResponseSchema)
 	}
-	if model == "" {
-		model = "gpt-4" // Default model
+	if stream {
+		requestBody["stream"] = true
+		// Yêu cầu chunk cuối chứa usage (mặc định OpenAI không trả usage khi stream)
+		requestBody["stream_options"] = map[string]interface{}{"include_usage": true}
 	}
 
-	temperature := 0.7
-	if req.Temperature != nil {
-		temperature = *req.Temperature
-	} else if profile.DefaultTemperature != nil {
-		temperature = *profile.DefaultTemperature
+	url := "https://api.openai.com/v1/chat/completions"
+	if profile.BaseURL != "" {
+		url = profile.BaseURL + "/v1/chat/completions"
 	}
 
-	maxTokens := 2000
-	if req.MaxTokens != nil {
-		maxTokens = *req.MaxTokens
-	} else if profile.DefaultMaxTokens != nil {
-		maxTokens = *profile.DefaultMaxTokens
+	httpReq, err := newJSONRequest(url, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+profile.APIKey)
+	if profile.OrganizationID != "" {
+		httpReq.Header.Set("OpenAI-Organization", profile.OrganizationID)
 	}
+	return &aiHTTPRequest{provider: profile.Provider, httpReq: httpReq, model: model, maxTokens: maxTokens}, nil
+}
 
-	// Chuẩn bị messages
+// openAIResponseFormat là response_format json_schema của OpenAI Chat Completions
+// strict=false vì outputSchema của step không bắt buộc additionalProperties=false ở mọi object (output vẫn được validate lại)
+func openAIResponseFormat(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   aiStructuredOutputName,
+			"schema": schema,
+			"strict": false,
+		},
+	}
+}
+
+// buildOpenAIMessages chuẩn bị messages theo format OpenAI (system → history → user prompt)
+func buildOpenAIMessages(req AICallRequest) []map[string]interface{} {
 	messages := []map[string]interface{}{}
 	if req.SystemPrompt != "" {
 		messages = append(messages, map[string]interface{}{
@@ -148,58 +295,50 @@ func (s *AIClientService) callOpenAI(req AICallRequest, startTime time.Time) (*A
 			"content": req.SystemPrompt,
 		})
 	}
-	
+
 	// Thêm conversation history nếu có
-	if len(req.Messages) > 0 {
-		for _, msg := range req.Messages {
-			messages = append(messages, map[string]interface{}{
-				"role":    msg.Role,
-				"content": msg.Content,
-			})
-		}
+	for _, msg := range req.Messages {
+		messages = append(messages, map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
 	}
-	
+
 	// Thêm user prompt
 	messages = append(messages, map[string]interface{}{
 		"role":    "user",
 		"content": req.Prompt,
 	})
+	return messages
+}
 
-	// Chuẩn bị request body
-	requestBody := map[string]interface{}{
-		"model":       model,
-		"messages":    messages,
-		"temperature": temperature,
-		"max_tokens":  maxTokens,
-	}
-
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("lỗi khi marshal request body: %v", err)
-	}
-
-	// Tạo HTTP request
-	url := "https://api.openai.com/v1/chat/completions"
-	if profile.BaseURL != "" {
-		url = profile.BaseURL + "/v1/chat/completions"
-	}
+// openAIChatResponse là response (không stream) của OpenAI-compatible Chat Completions API
+type openAIChatResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage openAIUsage `json:"usage"`
+}
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
+// callOpenAI gọi OpenAI API
+func (s *AIClientService) callOpenAI(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+	prepared, err := buildOpenAIRequest(req, false)
 	if err != nil {
-		return nil, fmt.Errorf("lỗi khi tạo HTTP request: %v", err)
-	}
-
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+profile.APIKey)
-	if profile.OrganizationID != "" {
-		httpReq.Header.Set("OpenAI-Organization", profile.OrganizationID)
+		return nil, err
 	}
 
 	// Gọi API
-	resp, err := s.httpClient.Do(httpReq)
+	resp, err := s.httpClient.Do(prepared.httpReq.WithContext(req.ctx()))
 	if err != nil {
-		return nil, fmt.Errorf("lỗi khi gọi OpenAI API: %v", err)
+		return nil, aiNetworkError(prepared, err)
 	}
 	defer resp.Body.Close()
 
@@ -214,30 +353,12 @@ func (s *AIClientService) callOpenAI(req AICallRequest, startTime time.Time) (*A
 	if resp.StatusCode != http.StatusOK {
 		return &AICallResponse{
 			Latency: latency,
-			Error:   fmt.Errorf("OpenAI API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody)),
+			Error:   aiErrorFromResponse(prepared, resp, respBody),
 		}, nil
 	}
 
 	// Parse response
-	var openAIResp struct {
-		ID      string `json:"id"`
-		Object  string `json:"object"`
-		Created int64  `json:"created"`
-		Model   string `json:"model"`
-		Choices []struct {
-			Message struct {
-				Role    string `json:"role"`
-				Content string `json:"content"`
-			} `json:"message"`
-			FinishReason string `json:"finish_reason"`
-		} `json:"choices"`
-		Usage struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			TotalTokens      int `json:"total_tokens"`
-		} `json:"usage"`
-	}
-
+	var openAIResp openAIChatResponse
 	if err := json.Unmarshal(respBody, &openAIResp); err != nil {
 		return nil, fmt.Errorf("lỗi khi parse OpenAI response: %v", err)
 	}
@@ -250,49 +371,31 @@ func (s *AIClientService) callOpenAI(req AICallRequest, startTime time.Time) (*A
 	finishReason := openAIResp.Choices[0].FinishReason
 
 	return &AICallResponse{
-		Content:       content,
-		Model:         openAIResp.Model,
-		Usage:         &AIUsage{
-			PromptTokens:     openAIResp.Usage.PromptTokens,
-			CompletionTokens: openAIResp.Usage.CompletionTokens,
-			TotalTokens:      openAIResp.Usage.TotalTokens,
-		},
+		Content:      content,
+		Model:        openAIResp.Model,
+		Usage:        usagePtr(openAIResp.Usage.normalize()),
 		FinishReason: finishReason,
 		Latency:      latency,
 	}, nil
 }
 
-// callAnthropic gọi Anthropic (Claude) API
-func (s *AIClientService) callAnthropic(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+// buildAnthropicRequest chuẩn bị request cho Anthropic Messages API
+func buildAnthropicRequest(req AICallRequest, stream bool) (*aiHTTPRequest, error) {
 	profile := req.ProviderProfile
-	model := req.Model
-	if model == "" {
-		model = profile.DefaultModel
-	}
-	if model == "" {
-		model = "claude-3-opus-20240229" // Default model
-	}
-
-	maxTokens := 2000
-	if req.MaxTokens != nil {
-		maxTokens = *req.MaxTokens
-	} else if profile.DefaultMaxTokens != nil {
-		maxTokens = *profile.DefaultMaxTokens
-	}
+	model := resolveModel(req, "claude-3-opus-20240229")
+	maxTokens := resolveMaxTokens(req)
 
-	// Chuẩn bị messages (Anthropic dùng format khác)
+	// Chuẩn bị messages (Anthropic dùng format khác, system prompt là field riêng)
 	messages := []map[string]interface{}{}
-	
+
 	// Thêm conversation history nếu có
-	if len(req.Messages) > 0 {
-		for _, msg := range req.Messages {
-			messages = append(messages, map[string]interface{}{
-				"role":    msg.Role,
-				"content": msg.Content,
-			})
-		}
+	for _, msg := range req.Messages {
+		messages = append(messages, map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
 	}
-	
+
 	// Thêm user prompt
 	messages = append(messages, map[string]interface{}{
 		"role":    "user",
@@ -301,46 +404,60 @@ func (s *AIClientService) callAnthropic(req AICallRequest, startTime time.Time)
 
 	// Chuẩn bị request body
 	requestBody := map[string]interface{}{
-		"model":     model,
+		"model":      model,
 		"max_tokens": maxTokens,
-		"messages":  messages,
+		"messages":   messages,
 	}
 
 	if req.SystemPrompt != "" {
 		requestBody["system"] = req.SystemPrompt
 	}
 
+	// Structured output: ép model gọi tool có input_schema là outputSchema, input của tool chính là output
+	if nativeStructuredOutput(req) {
+		requestBody["tools"] = []map[string]interface{}{{
+			"name":         aiStructuredOutputName,
+			"description":  "Trả kết quả của step theo đúng schema",
+			"input_schema": req.ResponseSchema,
+		}}
+		requestBody["tool_choice"] = map[string]interface{}{"type": "tool", "name": aiStructuredOutputName}
+	}
+
 	if req.Temperature != nil {
 		requestBody["temperature"] = *req.Temperature
 	} else if profile.DefaultTemperature != nil {
 		requestBody["temperature"] = *profile.DefaultTemperature
 	}
 
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("lỗi khi marshal request body: %v", err)
+	if stream {
+		requestBody["stream"] = true
 	}
 
-	// Tạo HTTP request
 	url := "https://api.anthropic.com/v1/messages"
 	if profile.BaseURL != "" {
 		url = profile.BaseURL + "/v1/messages"
 	}
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
+	httpReq, err := newJSONRequest(url, requestBody)
 	if err != nil {
-		return nil, fmt.Errorf("lỗi khi tạo HTTP request: %v", err)
+		return nil, err
 	}
-
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("x-api-key", profile.APIKey)
 	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	return &aiHTTPRequest{provider: profile.Provider, httpReq: httpReq, model: model, maxTokens: maxTokens}, nil
+}
+
+// callAnthropic gọi Anthropic (Claude) API
+func (s *AIClientService) callAnthropic(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+	prepared, err := buildAnthropicRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
 
 	// Gọi API
-	resp, err := s.httpClient.Do(httpReq)
+	resp, err := s.httpClient.Do(prepared.httpReq.WithContext(req.ctx()))
 	if err != nil {
-		return nil, fmt.Errorf("lỗi khi gọi Anthropic API: %v", err)
+		return nil, aiNetworkError(prepared, err)
 	}
 	defer resp.Body.Close()
 
@@ -355,24 +472,23 @@ func (s *AIClientService) callAnthropic(req AICallRequest, startTime time.Time)
 	if resp.StatusCode != http.StatusOK {
 		return &AICallResponse{
 			Latency: latency,
-			Error:   fmt.Errorf("Anthropic API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody)),
+			Error:   aiErrorFromResponse(prepared, resp, respBody),
 		}, nil
 	}
 
 	// Parse response
 	var anthropicResp struct {
-		ID    string `json:"id"`
-		Type  string `json:"type"`
-		Model string `json:"model"`
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Model   string `json:"model"`
 		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
 		} `json:"content"`
-		StopReason string `json:"stop_reason"`
-		Usage      struct {
-			InputTokens  int `json:"input_tokens"`
-			OutputTokens int `json:"output_tokens"`
-		} `json:"usage"`
+		StopReason string         `json:"stop_reason"`
+		Usage      anthropicUsage `json:"usage"`
 	}
 
 	if err := json.Unmarshal(respBody, &anthropicResp); err != nil {
@@ -383,73 +499,60 @@ func (s *AIClientService) callAnthropic(req AICallRequest, startTime time.Time)
 		return nil, errors.New("Anthropic response không có content")
 	}
 
-	content := anthropicResp.Content[0].Text
+	// Có tool_use của structured output → input của tool là output; không có thì ghép các block text
+	var content string
+	for _, block := range anthropicResp.Content {
+		if block.Type == "tool_use" && block.Name == aiStructuredOutputName {
+			content = string(block.Input)
+			break
+		}
+		if block.Type == "text" {
+			content += block.Text
+		}
+	}
 
 	return &AICallResponse{
-		Content:       content,
-		Model:         anthropicResp.Model,
-		Usage:         &AIUsage{
-			PromptTokens:     anthropicResp.Usage.InputTokens,
-			CompletionTokens: anthropicResp.Usage.OutputTokens,
-			TotalTokens:      anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens,
-		},
+		Content:      content,
+		Model:        anthropicResp.Model,
+		Usage:        usagePtr(anthropicResp.Usage.normalize()),
 		FinishReason: anthropicResp.StopReason,
 		Latency:      latency,
 	}, nil
 }
 
-// callGoogle gọi Google (Gemini) API
-func (s *AIClientService) callGoogle(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+// buildGoogleRequest chuẩn bị request cho Google Gemini API
+// stream=true dùng streamGenerateContent với alt=sse để nhận Server-Sent Events
+func buildGoogleRequest(req AICallRequest, stream bool) (*aiHTTPRequest, error) {
 	profile := req.ProviderProfile
-	model := req.Model
-	if model == "" {
-		model = profile.DefaultModel
-	}
-	if model == "" {
-		model = "gemini-1.5-pro" // Default model
-	}
-
-	temperature := 0.7
-	if req.Temperature != nil {
-		temperature = *req.Temperature
-	} else if profile.DefaultTemperature != nil {
-		temperature = *profile.DefaultTemperature
-	}
+	model := resolveModel(req, "gemini-1.5-pro")
+	temperature := resolveTemperature(req)
+	maxTokens := resolveMaxTokens(req)
 
-	maxTokens := 2000
-	if req.MaxTokens != nil {
-		maxTokens = *req.MaxTokens
-	} else if profile.DefaultMaxTokens != nil {
-		maxTokens = *profile.DefaultMaxTokens
-	}
-
-	log.Printf("[AIClient] [Google] Bắt đầu gọi Google Gemini API - Model: %s, Temperature: %.2f, MaxTokens: %d", model, temperature, maxTokens)
+	log.Printf("[AIClient] [Google] Bắt đầu gọi Google Gemini API - Model: %s, Temperature: %.2f, MaxTokens: %d, Stream: %v", model, temperature, maxTokens, stream)
 
 	// Chuẩn bị contents (Gemini dùng contents thay vì messages)
 	contents := []map[string]interface{}{}
-	
+
 	// Thêm system prompt nếu có (Gemini không có system role riêng, thêm vào user message)
 	userPrompt := req.Prompt
 	if req.SystemPrompt != "" {
 		userPrompt = req.SystemPrompt + "\n\n" + req.Prompt
 	}
-	
+
 	// Thêm conversation history nếu có
-	if len(req.Messages) > 0 {
-		for _, msg := range req.Messages {
-			role := "user"
-			if msg.Role == "assistant" {
-				role = "model"
-			}
-			contents = append(contents, map[string]interface{}{
-				"role": role,
-				"parts": []map[string]interface{}{
-					{"text": msg.Content},
-				},
-			})
+	for _, msg := range req.Messages {
+		role := "user"
+		if msg.Role == "assistant" {
+			role = "model"
 		}
+		contents = append(contents, map[string]interface{}{
+			"role": role,
+			"parts": []map[string]interface{}{
+				{"text": msg.Content},
+			},
+		})
 	}
-	
+
 	// Thêm user prompt
 	contents = append(contents, map[string]interface{}{
 		"role": "user",
@@ -459,47 +562,58 @@ func (s *AIClientService) callGoogle(req AICallRequest, startTime time.Time) (*A
 	})
 
 	// Chuẩn bị request body
+	generationConfig := map[string]interface{}{
+		"temperature":     temperature,
+		"maxOutputTokens": maxTokens,
+	}
+	// JSON mode: responseSchema của Gemini chỉ hỗ trợ một phần OpenAPI nên không gửi schema, output được validate lại sau
+	if nativeStructuredOutput(req) {
+		generationConfig["responseMimeType"] = "application/json"
+	}
 	requestBody := map[string]interface{}{
-		"contents": contents,
-		"generationConfig": map[string]interface{}{
-			"temperature":    temperature,
-			"maxOutputTokens": maxTokens,
-		},
+		"contents":         contents,
+		"generationConfig": generationConfig,
 	}
 
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		log.Printf("[AIClient] [Google] ❌ Lỗi khi marshal request body: %v", err)
-		return nil, fmt.Errorf("lỗi khi marshal request body: %v", err)
+	method := "generateContent"
+	if stream {
+		method = "streamGenerateContent"
 	}
-
-	log.Printf("[AIClient] [Google] Request body size: %d bytes", len(bodyBytes))
-
-	// Tạo HTTP request
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent", model)
+	path := fmt.Sprintf("/v1beta/models/%s:%s", model, method)
+	if stream {
+		path += "?alt=sse"
+	}
+	url := "https://generativelanguage.googleapis.com" + path
 	if profile.BaseURL != "" {
-		url = profile.BaseURL + fmt.Sprintf("/v1beta/models/%s:generateContent", model)
+		url = profile.BaseURL + path
 	}
 
 	log.Printf("[AIClient] [Google] URL: %s", url)
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
+	httpReq, err := newJSONRequest(url, requestBody)
 	if err != nil {
-		log.Printf("[AIClient] [Google] ❌ Lỗi khi tạo HTTP request: %v", err)
-		return nil, fmt.Errorf("lỗi khi tạo HTTP request: %v", err)
+		log.Printf("[AIClient] [Google] ❌ %v", err)
+		return nil, err
 	}
-
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("x-goog-api-key", profile.APIKey)
+	return &aiHTTPRequest{provider: profile.Provider, httpReq: httpReq, model: model, maxTokens: maxTokens}, nil
+}
+
+// callGoogle gọi Google (Gemini) API
+func (s *AIClientService) callGoogle(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+	prepared, err := buildGoogleRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
+	model := prepared.model
 
 	log.Printf("[AIClient] [Google] Đang gửi request...")
 
 	// Gọi API
-	resp, err := s.httpClient.Do(httpReq)
+	resp, err := s.httpClient.Do(prepared.httpReq.WithContext(req.ctx()))
 	if err != nil {
 		log.Printf("[AIClient] [Google] ❌ Lỗi khi gọi API: %v", err)
-		return nil, fmt.Errorf("lỗi khi gọi Google API: %v", err)
+		return nil, aiNetworkError(prepared, err)
 	}
 	defer resp.Body.Close()
 
@@ -517,7 +631,7 @@ func (s *AIClientService) callGoogle(req AICallRequest, startTime time.Time) (*A
 		log.Printf("[AIClient] [Google] ❌ API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody))
 		return &AICallResponse{
 			Latency: latency,
-			Error:   fmt.Errorf("Google API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody)),
+			Error:   aiErrorFromResponse(prepared, resp, respBody),
 		}, nil
 	}
 
@@ -531,11 +645,7 @@ func (s *AIClientService) callGoogle(req AICallRequest, startTime time.Time) (*A
 			} `json:"content"`
 			FinishReason string `json:"finishReason"`
 		} `json:"candidates"`
-		UsageMetadata struct {
-			PromptTokenCount     int `json:"promptTokenCount"`
-			CandidatesTokenCount int `json:"candidatesTokenCount"`
-			TotalTokenCount      int `json:"totalTokenCount"`
-		} `json:"usageMetadata"`
+		UsageMetadata googleUsage `json:"usageMetadata"`
 	}
 
 	if err := json.Unmarshal(respBody, &googleResp); err != nil {
@@ -543,7 +653,7 @@ func (s *AIClientService) callGoogle(req AICallRequest, startTime time.Time) (*A
 		return nil, fmt.Errorf("lỗi khi parse Google response: %v", err)
 	}
 
-	if len(googleResp.Candidates) == 0 {
+	if len(googleResp.Candidates) == 0 || len(googleResp.Candidates[0].Content.Parts) == 0 {
 		log.Printf("[AIClient] [Google] ❌ Response không có candidates")
 		return nil, errors.New("Google response không có candidates")
 	}
@@ -552,69 +662,45 @@ func (s *AIClientService) callGoogle(req AICallRequest, startTime time.Time) (*A
 	finishReason := googleResp.Candidates[0].FinishReason
 
 	log.Printf("[AIClient] [Google] ✅ Thành công - Content length: %d chars, FinishReason: %s", len(content), finishReason)
-	log.Printf("[AIClient] [Google] Usage - Prompt: %d, Completion: %d, Total: %d", 
-		googleResp.UsageMetadata.PromptTokenCount, 
+	log.Printf("[AIClient] [Google] Usage - Prompt: %d, Completion: %d, Total: %d",
+		googleResp.UsageMetadata.PromptTokenCount,
 		googleResp.UsageMetadata.CandidatesTokenCount,
 		googleResp.UsageMetadata.TotalTokenCount)
 
 	return &AICallResponse{
-		Content:       content,
-		Model:         model,
-		Usage:         &AIUsage{
-			PromptTokens:     googleResp.UsageMetadata.PromptTokenCount,
-			CompletionTokens: googleResp.UsageMetadata.CandidatesTokenCount,
-			TotalTokens:      googleResp.UsageMetadata.TotalTokenCount,
-		},
+		Content:      content,
+		Model:        model,
+		Usage:        usagePtr(googleResp.UsageMetadata.normalize()),
 		FinishReason: finishReason,
 		Latency:      latency,
 	}, nil
 }
 
-// callCohere gọi Cohere API
-func (s *AIClientService) callCohere(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+// buildCohereRequest chuẩn bị request cho Cohere Chat API
+func buildCohereRequest(req AICallRequest, stream bool) (*aiHTTPRequest, error) {
 	profile := req.ProviderProfile
-	model := req.Model
-	if model == "" {
-		model = profile.DefaultModel
-	}
-	if model == "" {
-		model = "command-nightly" // Default model
-	}
+	model := resolveModel(req, "command-nightly")
+	temperature := resolveTemperature(req)
+	maxTokens := resolveMaxTokens(req)
 
-	temperature := 0.7
-	if req.Temperature != nil {
-		temperature = *req.Temperature
-	} else if profile.DefaultTemperature != nil {
-		temperature = *profile.DefaultTemperature
-	}
-
-	maxTokens := 2000
-	if req.MaxTokens != nil {
-		maxTokens = *req.MaxTokens
-	} else if profile.DefaultMaxTokens != nil {
-		maxTokens = *profile.DefaultMaxTokens
-	}
-
-	log.Printf("[AIClient] [Cohere] Bắt đầu gọi Cohere API - Model: %s, Temperature: %.2f, MaxTokens: %d", model, temperature, maxTokens)
+	log.Printf("[AIClient] [Cohere] Bắt đầu gọi Cohere API - Model: %s, Temperature: %.2f, MaxTokens: %d, Stream: %v", model, temperature, maxTokens, stream)
 
 	// Chuẩn bị chat history (Cohere dùng chat_history)
 	chatHistory := []map[string]interface{}{}
-	
+
 	// Thêm conversation history nếu có
-	if len(req.Messages) > 0 {
-		for _, msg := range req.Messages {
-			role := "USER"
-			if msg.Role == "assistant" {
-				role = "CHATBOT"
-			} else if msg.Role == "system" {
-				// Cohere không có system role, thêm vào message
-				continue
-			}
-			chatHistory = append(chatHistory, map[string]interface{}{
-				"role":    role,
-				"message": msg.Content,
-			})
+	for _, msg := range req.Messages {
+		role := "USER"
+		if msg.Role == "assistant" {
+			role = "CHATBOT"
+		} else if msg.Role == "system" {
+			// Cohere không có system role, thêm vào message
+			continue
 		}
+		chatHistory = append(chatHistory, map[string]interface{}{
+			"role":    role,
+			"message": msg.Content,
+		})
 	}
 
 	// Chuẩn bị message (kết hợp system prompt và user prompt)
@@ -625,30 +711,29 @@ func (s *AIClientService) callCohere(req AICallRequest, startTime time.Time) (*A
 
 	// Chuẩn bị request body
 	requestBody := map[string]interface{}{
-		"model":        model,
-		"message":      message,
-		"temperature":  temperature,
-		"max_tokens":   maxTokens,
+		"model":       model,
+		"message":     message,
+		"temperature": temperature,
+		"max_tokens":  maxTokens,
 	}
 
 	if len(chatHistory) > 0 {
 		requestBody["chat_history"] = chatHistory
 	}
 
-	// Thêm response_format nếu cần JSON
-	requestBody["response_format"] = map[string]interface{}{
+	// Thêm response_format nếu cần JSON (kèm schema khi có outputSchema)
+	responseFormat := map[string]interface{}{
 		"type": "json_object",
 	}
-
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		log.Printf("[AIClient] [Cohere] ❌ Lỗi khi marshal request body: %v", err)
-		return nil, fmt.Errorf("lỗi khi marshal request body: %v", err)
+	if nativeStructuredOutput(req) {
+		responseFormat["schema"] = req.ResponseSchema
 	}
+	requestBody["response_format"] = responseFormat
 
-	log.Printf("[AIClient] [Cohere] Request body size: %d bytes", len(bodyBytes))
+	if stream {
+		requestBody["stream"] = true
+	}
 
-	// Tạo HTTP request
 	url := "https://api.cohere.ai/v1/chat"
 	if profile.BaseURL != "" {
 		url = profile.BaseURL + "/v1/chat"
@@ -656,24 +741,31 @@ func (s *AIClientService) callCohere(req AICallRequest, startTime time.Time) (*A
 
 	log.Printf("[AIClient] [Cohere] URL: %s", url)
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
+	httpReq, err := newJSONRequest(url, requestBody)
 	if err != nil {
-		log.Printf("[AIClient] [Cohere] ❌ Lỗi khi tạo HTTP request: %v", err)
-		return nil, fmt.Errorf("lỗi khi tạo HTTP request: %v", err)
+		log.Printf("[AIClient] [Cohere] ❌ %v", err)
+		return nil, err
 	}
-
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Bearer "+profile.APIKey)
 	httpReq.Header.Set("Accept", "application/json")
+	return &aiHTTPRequest{provider: profile.Provider, httpReq: httpReq, model: model, maxTokens: maxTokens}, nil
+}
+
+// callCohere gọi Cohere API
+func (s *AIClientService) callCohere(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+	prepared, err := buildCohereRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
+	model := prepared.model
 
 	log.Printf("[AIClient] [Cohere] Đang gửi request...")
 
 	// Gọi API
-	resp, err := s.httpClient.Do(httpReq)
+	resp, err := s.httpClient.Do(prepared.httpReq.WithContext(req.ctx()))
 	if err != nil {
 		log.Printf("[AIClient] [Cohere] ❌ Lỗi khi gọi API: %v", err)
-		return nil, fmt.Errorf("lỗi khi gọi Cohere API: %v", err)
+		return nil, aiNetworkError(prepared, err)
 	}
 	defer resp.Body.Close()
 
@@ -691,7 +783,7 @@ func (s *AIClientService) callCohere(req AICallRequest, startTime time.Time) (*A
 		log.Printf("[AIClient] [Cohere] ❌ API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody))
 		return &AICallResponse{
 			Latency: latency,
-			Error:   fmt.Errorf("Cohere API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody)),
+			Error:   aiErrorFromResponse(prepared, resp, respBody),
 		}, nil
 	}
 
@@ -704,10 +796,7 @@ func (s *AIClientService) callCohere(req AICallRequest, startTime time.Time) (*A
 			APIVersion struct {
 				Version string `json:"version"`
 			} `json:"api_version"`
-			BilledUnits struct {
-				InputTokens  int `json:"input_tokens"`
-				OutputTokens int `json:"output_tokens"`
-			} `json:"billed_units"`
+			BilledUnits cohereUsage `json:"billed_units"`
 		} `json:"meta"`
 	}
 
@@ -717,150 +806,128 @@ func (s *AIClientService) callCohere(req AICallRequest, startTime time.Time) (*A
 	}
 
 	log.Printf("[AIClient] [Cohere] ✅ Thành công - Content length: %d chars, FinishReason: %s", len(cohereResp.Text), cohereResp.FinishReason)
-	log.Printf("[AIClient] [Cohere] Usage - Input: %d, Output: %d", 
-		cohereResp.Meta.BilledUnits.InputTokens, 
+	log.Printf("[AIClient] [Cohere] Usage - Input: %d, Output: %d",
+		cohereResp.Meta.BilledUnits.InputTokens,
 		cohereResp.Meta.BilledUnits.OutputTokens)
 
 	return &AICallResponse{
-		Content:       cohereResp.Text,
-		Model:         model,
-		Usage:         &AIUsage{
-			PromptTokens:     cohereResp.Meta.BilledUnits.InputTokens,
-			CompletionTokens: cohereResp.Meta.BilledUnits.OutputTokens,
-			TotalTokens:      cohereResp.Meta.BilledUnits.InputTokens + cohereResp.Meta.BilledUnits.OutputTokens,
-		},
+		Content:      cohereResp.Text,
+		Model:        model,
+		Usage:        usagePtr(cohereResp.Meta.BilledUnits.normalize()),
 		FinishReason: cohereResp.FinishReason,
 		Latency:      latency,
 	}, nil
 }
 
-// callCustom gọi Custom provider API
-// Custom provider có thể có format riêng, tạm thời dùng OpenAI-compatible format
-func (s *AIClientService) callCustom(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+// aiProfileReservedConfigKeys là các key trong profile.Config dành cho agent (endpoint, xác thực, stream, fallback...)
+// Các key này không được ghép vào request body của Custom provider
+var aiProfileReservedConfigKeys = map[string]bool{
+	"endpoint":          true,
+	"authHeaderName":    true,
+	"authHeaderFormat":  true,
+	"stream":            true,
+	"fallbacks":         true,
+	"modelMap":          true,
+	"maxAttempts":       true,
+	"pricing":           true,
+	"structuredOutput":  true,
+	"embeddingModel":    true,
+	"embeddingEndpoint": true,
+}
+
+// buildCustomRequest chuẩn bị request cho Custom provider (OpenAI-compatible format)
+// profile.Config có thể đổi endpoint ("endpoint") và header xác thực ("authHeaderName", "authHeaderFormat"),
+// các key còn lại trong Config được ghép vào request body
+func buildCustomRequest(req AICallRequest, stream bool) (*aiHTTPRequest, error) {
 	profile := req.ProviderProfile
-	
+
 	if profile.BaseURL == "" {
 		log.Printf("[AIClient] [Custom] ❌ Custom provider cần BaseURL")
 		return nil, errors.New("Custom provider cần BaseURL trong config")
 	}
 
-	model := req.Model
-	if model == "" {
-		model = profile.DefaultModel
-	}
-	if model == "" {
-		model = "default" // Default model
-	}
-
-	temperature := 0.7
-	if req.Temperature != nil {
-		temperature = *req.Temperature
-	} else if profile.DefaultTemperature != nil {
-		temperature = *profile.DefaultTemperature
-	}
-
-	maxTokens := 2000
-	if req.MaxTokens != nil {
-		maxTokens = *req.MaxTokens
-	} else if profile.DefaultMaxTokens != nil {
-		maxTokens = *profile.DefaultMaxTokens
-	}
+	model := resolveModel(req, "default")
+	temperature := resolveTemperature(req)
+	maxTokens := resolveMaxTokens(req)
 
-	log.Printf("[AIClient] [Custom] Bắt đầu gọi Custom provider API - BaseURL: %s, Model: %s, Temperature: %.2f, MaxTokens: %d", 
-		profile.BaseURL, model, temperature, maxTokens)
-
-	// Chuẩn bị messages (dùng OpenAI-compatible format)
-	messages := []map[string]interface{}{}
-	if req.SystemPrompt != "" {
-		messages = append(messages, map[string]interface{}{
-			"role":    "system",
-			"content": req.SystemPrompt,
-		})
-	}
-	
-	// Thêm conversation history nếu có
-	if len(req.Messages) > 0 {
-		for _, msg := range req.Messages {
-			messages = append(messages, map[string]interface{}{
-				"role":    msg.Role,
-				"content": msg.Content,
-			})
-		}
-	}
-	
-	// Thêm user prompt
-	messages = append(messages, map[string]interface{}{
-		"role":    "user",
-		"content": req.Prompt,
-	})
+	log.Printf("[AIClient] [Custom] Bắt đầu gọi Custom provider API - BaseURL: %s, Model: %s, Temperature: %.2f, MaxTokens: %d, Stream: %v",
+		profile.BaseURL, model, temperature, maxTokens, stream)
 
 	// Chuẩn bị request body (OpenAI-compatible format)
 	requestBody := map[string]interface{}{
 		"model":       model,
-		"messages":    messages,
+		"messages":    buildOpenAIMessages(req),
 		"temperature": temperature,
 		"max_tokens":  maxTokens,
 	}
 
-	// Thêm custom config nếu có
-	if profile.Config != nil {
-		for k, v := range profile.Config {
-			requestBody[k] = v
+	// Thêm custom config nếu có (bỏ qua các key agent dùng để cấu hình, không phải tham số của API)
+	for k, v := range profile.Config {
+		if aiProfileReservedConfigKeys[k] {
+			continue
 		}
+		requestBody[k] = v
 	}
 
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		log.Printf("[AIClient] [Custom] ❌ Lỗi khi marshal request body: %v", err)
-		return nil, fmt.Errorf("lỗi khi marshal request body: %v", err)
+	if nativeStructuredOutput(req) {
+		requestBody["response_format"] = openAIResponseFormat(req.ResponseSchema)
 	}
 
-	log.Printf("[AIClient] [Custom] Request body size: %d bytes", len(bodyBytes))
+	if stream {
+		requestBody["stream"] = true
+	}
 
-	// Tạo HTTP request
 	// Custom provider có thể dùng endpoint khác, mặc định dùng /v1/chat/completions
 	endpoint := "/v1/chat/completions"
-	if profile.Config != nil {
-		if customEndpoint, ok := profile.Config["endpoint"].(string); ok && customEndpoint != "" {
-			endpoint = customEndpoint
-		}
+	if customEndpoint, ok := profile.Config["endpoint"].(string); ok && customEndpoint != "" {
+		endpoint = customEndpoint
 	}
 
 	url := profile.BaseURL + endpoint
 	log.Printf("[AIClient] [Custom] URL: %s", url)
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
+	httpReq, err := newJSONRequest(url, requestBody)
 	if err != nil {
-		log.Printf("[AIClient] [Custom] ❌ Lỗi khi tạo HTTP request: %v", err)
-		return nil, fmt.Errorf("lỗi khi tạo HTTP request: %v", err)
+		log.Printf("[AIClient] [Custom] ❌ %v", err)
+		return nil, err
 	}
 
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	if profile.APIKey != "" {
-		// Custom provider có thể dùng header khác, mặc định dùng Authorization
-		authHeader := "Bearer " + profile.APIKey
-		if profile.Config != nil {
-			if authHeaderFormat, ok := profile.Config["authHeaderFormat"].(string); ok && authHeaderFormat != "" {
-				authHeader = authHeaderFormat
-			}
-			if authHeaderName, ok := profile.Config["authHeaderName"].(string); ok && authHeaderName != "" {
-				httpReq.Header.Set(authHeaderName, authHeader)
-			} else {
-				httpReq.Header.Set("Authorization", authHeader)
-			}
-		} else {
-			httpReq.Header.Set("Authorization", authHeader)
-		}
+	setCustomAuthHeader(httpReq, profile)
+	return &aiHTTPRequest{provider: profile.Provider, httpReq: httpReq, model: model, maxTokens: maxTokens}, nil
+}
+
+// setCustomAuthHeader đặt header xác thực cho Custom provider
+// Custom provider có thể dùng header khác (config "authHeaderName"/"authHeaderFormat"), mặc định dùng Authorization
+func setCustomAuthHeader(httpReq *http.Request, profile *AIProviderProfile) {
+	if profile.APIKey == "" {
+		return
+	}
+	authHeader := "Bearer " + profile.APIKey
+	if authHeaderFormat, ok := profile.Config["authHeaderFormat"].(string); ok && authHeaderFormat != "" {
+		authHeader = authHeaderFormat
+	}
+	if authHeaderName, ok := profile.Config["authHeaderName"].(string); ok && authHeaderName != "" {
+		httpReq.Header.Set(authHeaderName, authHeader)
+	} else {
+		httpReq.Header.Set("Authorization", authHeader)
+	}
+}
+
+// callCustom gọi Custom provider API
+// Custom provider có thể có format riêng, tạm thời dùng OpenAI-compatible format
+func (s *AIClientService) callCustom(req AICallRequest, startTime time.Time) (*AICallResponse, error) {
+	prepared, err := buildCustomRequest(req, false)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Printf("[AIClient] [Custom] Đang gửi request...")
 
 	// Gọi API
-	resp, err := s.httpClient.Do(httpReq)
+	resp, err := s.httpClient.Do(prepared.httpReq.WithContext(req.ctx()))
 	if err != nil {
 		log.Printf("[AIClient] [Custom] ❌ Lỗi khi gọi API: %v", err)
-		return nil, fmt.Errorf("lỗi khi gọi Custom API: %v", err)
+		return nil, aiNetworkError(prepared, err)
 	}
 	defer resp.Body.Close()
 
@@ -878,30 +945,12 @@ func (s *AIClientService) callCustom(req AICallRequest, startTime time.Time) (*A
 		log.Printf("[AIClient] [Custom] ❌ API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody))
 		return &AICallResponse{
 			Latency: latency,
-			Error:   fmt.Errorf("Custom API trả về lỗi (status %d): %s", resp.StatusCode, string(respBody)),
+			Error:   aiErrorFromResponse(prepared, resp, respBody),
 		}, nil
 	}
 
 	// Parse response (OpenAI-compatible format)
-	var customResp struct {
-		ID      string `json:"id"`
-		Object  string `json:"object"`
-		Created int64  `json:"created"`
-		Model   string `json:"model"`
-		Choices []struct {
-			Message struct {
-				Role    string `json:"role"`
-				Content string `json:"content"`
-			} `json:"message"`
-			FinishReason string `json:"finish_reason"`
-		} `json:"choices"`
-		Usage struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			TotalTokens      int `json:"total_tokens"`
-		} `json:"usage"`
-	}
-
+	var customResp openAIChatResponse
 	if err := json.Unmarshal(respBody, &customResp); err != nil {
 		log.Printf("[AIClient] [Custom] ❌ Lỗi khi parse response: %v", err)
 		return nil, fmt.Errorf("lỗi khi parse Custom response: %v", err)
@@ -916,19 +965,15 @@ func (s *AIClientService) callCustom(req AICallRequest, startTime time.Time) (*A
 	finishReason := customResp.Choices[0].FinishReason
 
 	log.Printf("[AIClient] [Custom] ✅ Thành công - Content length: %d chars, FinishReason: %s", len(content), finishReason)
-	log.Printf("[AIClient] [Custom] Usage - Prompt: %d, Completion: %d, Total: %d", 
-		customResp.Usage.PromptTokens, 
+	log.Printf("[AIClient] [Custom] Usage - Prompt: %d, Completion: %d, Total: %d",
+		customResp.Usage.PromptTokens,
 		customResp.Usage.CompletionTokens,
 		customResp.Usage.TotalTokens)
 
 	return &AICallResponse{
-		Content:       content,
-		Model:         customResp.Model,
-		Usage:         &AIUsage{
-			PromptTokens:     customResp.Usage.PromptTokens,
-			CompletionTokens: customResp.Usage.CompletionTokens,
-			TotalTokens:      customResp.Usage.TotalTokens,
-		},
+		Content:      content,
+		Model:        customResp.Model,
+		Usage:        usagePtr(customResp.Usage.normalize()),
 		FinishReason: finishReason,
 		Latency:      latency,
 	}, nil
@@ -0,0 +1,432 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần tính chi phí AI:
+  - Bảng giá theo provider/model (USD cho 1 triệu token input/output, đọc/ghi prompt cache), mặc định có sẵn giá của các model phổ biến
+  - Bảng giá có thể ghi đè qua config "aiPricing" của workflow-commands-job (server cập nhật được)
+    hoặc qua config "pricing" của provider profile (ưu tiên cao nhất)
+  - AIBudget: giới hạn chi phí của một workflow run, vượt ngưỡng thì dừng run
+  - Thống kê chi phí cộng dồn từ lúc agent khởi động (gửi lên server trong check-in metrics)
+*/
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aiPricingJobName là job chứa config bảng giá và ngân sách AI (các AI call đều chạy trong workflow-commands-job)
+const aiPricingJobName = workflowCommandsJobName
+
+// aiCacheWriteMultiplier là hệ số giá ghi prompt cache so với input khi bảng giá không có cacheWrite
+// (Anthropic tính phí ghi cache 5 phút bằng 1.25 lần giá input)
+const aiCacheWriteMultiplier = 1.25
+
+// AIModelPrice là giá của một model, đơn vị USD cho 1 triệu token
+type AIModelPrice struct {
+	Input       float64 `json:"input"`                 // Giá token input
+	Output      float64 `json:"output"`                // Giá token output
+	CachedInput float64 `json:"cachedInput,omitempty"` // Giá token input đọc từ cache (0 = tính như Input)
+	CacheWrite  float64 `json:"cacheWrite,omitempty"`  // Giá token input ghi vào cache (0 = aiCacheWriteMultiplier × Input)
+}
+
+// defaultAIPricing là bảng giá mặc định, key là "provider/model"
+// Model có hậu tố phiên bản (ví dụ "gpt-4o-2024-08-06") khớp theo prefix dài nhất
+// Giá thay đổi theo thời gian → cập nhật qua config "aiPricing" thay vì sửa code
+var defaultAIPricing = map[string]AIModelPrice{
+	"openai/gpt-4o":               {Input: 2.5, Output: 10, CachedInput: 1.25},
+	"openai/gpt-4o-mini":          {Input: 0.15, Output: 0.6, CachedInput: 0.075},
+	"openai/gpt-4.1":              {Input: 2, Output: 8, CachedInput: 0.5},
+	"openai/gpt-4.1-mini":         {Input: 0.4, Output: 1.6, CachedInput: 0.1},
+	"openai/gpt-4.1-nano":         {Input: 0.1, Output: 0.4, CachedInput: 0.025},
+	"openai/gpt-4-turbo":          {Input: 10, Output: 30},
+	"openai/gpt-4":                {Input: 30, Output: 60},
+	"openai/gpt-3.5-turbo":        {Input: 0.5, Output: 1.5},
+	"openai/o3-mini":              {Input: 1.1, Output: 4.4, CachedInput: 0.55},
+	"anthropic/claude-3-5-sonnet": {Input: 3, Output: 15, CachedInput: 0.3, CacheWrite: 3.75},
+	"anthropic/claude-3-7-sonnet": {Input: 3, Output: 15, CachedInput: 0.3, CacheWrite: 3.75},
+	"anthropic/claude-sonnet-4":   {Input: 3, Output: 15, CachedInput: 0.3, CacheWrite: 3.75},
+	"anthropic/claude-3-5-haiku":  {Input: 0.8, Output: 4, CachedInput: 0.08, CacheWrite: 1},
+	"anthropic/claude-3-haiku":    {Input: 0.25, Output: 1.25, CachedInput: 0.03, CacheWrite: 0.3},
+	"anthropic/claude-3-sonnet":   {Input: 3, Output: 15},
+	"anthropic/claude-3-opus":     {Input: 15, Output: 75, CachedInput: 1.5, CacheWrite: 18.75},
+	"anthropic/claude-opus-4":     {Input: 15, Output: 75, CachedInput: 1.5, CacheWrite: 18.75},
+	"google/gemini-1.5-pro":       {Input: 1.25, Output: 5},
+	"google/gemini-1.5-flash":     {Input: 0.075, Output: 0.3},
+	"google/gemini-2.0-flash":     {Input: 0.1, Output: 0.4, CachedInput: 0.025},
+	"google/gemini-2.5-pro":       {Input: 1.25, Output: 10, CachedInput: 0.31},
+	"google/gemini-2.5-flash":     {Input: 0.3, Output: 2.5, CachedInput: 0.075},
+	"cohere/command-r-plus":       {Input: 2.5, Output: 10},
+	"cohere/command-r":            {Input: 0.15, Output: 0.6},
+	"cohere/command-r7b":          {Input: 0.0375, Output: 0.15},
+	"cohere/command-a":            {Input: 2.5, Output: 10},
+	"cohere/command-light":        {Input: 0.3, Output: 0.6},
+	"cohere/command":              {Input: 1, Output: 2},
+}
+
+// AICost là chi phí của một lần gọi AI
+type AICost struct {
+	Amount     float64      // USD
+	Price      AIModelPrice // Giá đã áp dụng
+	PriceKey   string       // Key của bảng giá đã khớp ("profile", "openai/gpt-4o"...), rỗng nếu không có giá
+	Priced     bool         // false nếu không tìm thấy giá (Amount = 0)
+	Estimated  bool         // true nếu usage là ước lượng
+	TokensUsed AIUsage
+}
+
+// CalculateAICost tính chi phí của một lần gọi theo bảng giá hiện tại
+func CalculateAICost(profile *AIProviderProfile, provider, model string, usage *AIUsage) AICost {
+	cost := AICost{}
+	if usage == nil {
+		return cost
+	}
+	cost.TokensUsed = *usage
+	cost.Estimated = usage.Estimated
+
+	price, key, ok := lookupAIPrice(profile, provider, model)
+	if !ok {
+		return cost
+	}
+	cost.Price, cost.PriceKey, cost.Priced = price, key, true
+
+	cachedPrice := price.CachedInput
+	if cachedPrice <= 0 {
+		cachedPrice = price.Input
+	}
+	cacheWritePrice := price.CacheWrite
+	if cacheWritePrice <= 0 {
+		cacheWritePrice = price.Input * aiCacheWriteMultiplier
+	}
+	cached := usage.CachedPromptTokens
+	if cached > usage.PromptTokens {
+		cached = usage.PromptTokens
+	}
+	cacheWrite := usage.CacheWritePromptTokens
+	if cacheWrite > usage.PromptTokens-cached {
+		cacheWrite = usage.PromptTokens - cached
+	}
+	cost.Amount = (float64(usage.PromptTokens-cached-cacheWrite)*price.Input +
+		float64(cached)*cachedPrice +
+		float64(cacheWrite)*cacheWritePrice +
+		float64(usage.CompletionTokens)*price.Output) / 1_000_000
+	return cost
+}
+
+// lookupAIPrice tìm giá theo thứ tự: config "pricing" của provider profile → config "aiPricing" → bảng giá mặc định
+func lookupAIPrice(profile *AIProviderProfile, provider, model string) (AIModelPrice, string, bool) {
+	if profile != nil && profile.Config != nil {
+		if pricing, ok := profile.Config["pricing"].(map[string]interface{}); ok {
+			// {"input": 1, "output": 2} áp dụng cho mọi model của profile
+			if price, ok := parseAIModelPrice(pricing); ok {
+				return price, "profile", true
+			}
+			// {"<model>": {"input": 1, "output": 2}} theo từng model
+			if price, key, ok := matchAIPrice(parseAIPricingTable(pricing), "", model); ok {
+				return price, "profile:" + key, true
+			}
+		}
+	}
+	if price, key, ok := matchAIPrice(configuredAIPricing(), provider, model); ok {
+		return price, key, true
+	}
+	return matchAIPrice(defaultAIPricing, provider, model)
+}
+
+// matchAIPrice khớp "provider/model" (hoặc "model" khi provider rỗng) với bảng giá:
+// khớp chính xác → prefix dài nhất (model có hậu tố phiên bản/ngày) → "provider/*"
+func matchAIPrice(table map[string]AIModelPrice, provider, model string) (AIModelPrice, string, bool) {
+	if len(table) == 0 {
+		return AIModelPrice{}, "", false
+	}
+	prefix := ""
+	if provider != "" {
+		prefix = strings.ToLower(provider) + "/"
+	}
+	target := prefix + strings.ToLower(model)
+
+	if model != "" {
+		if price, ok := table[target]; ok {
+			return price, target, true
+		}
+		bestKey := ""
+		for key := range table {
+			lowerKey := strings.ToLower(key)
+			if strings.HasPrefix(target, lowerKey) && len(key) > len(bestKey) && strings.HasPrefix(lowerKey, prefix) && lowerKey != prefix+"*" {
+				bestKey = key
+			}
+		}
+		if bestKey != "" {
+			return table[bestKey], bestKey, true
+		}
+	}
+	if price, ok := table[prefix+"*"]; ok {
+		return price, prefix + "*", true
+	}
+	return AIModelPrice{}, "", false
+}
+
+// configuredAIPricing đọc bảng giá từ config "aiPricing" của workflow-commands-job
+// Format: {"openai/gpt-4o": {"input": 2.5, "output": 10, "cachedInput": 1.25}, "anthropic/claude-sonnet-4": {..., "cacheWrite": 3.75}, "custom/*": {...}}
+func configuredAIPricing() map[string]AIModelPrice {
+	cm := GetGlobalConfigManager()
+	if cm == nil {
+		return nil
+	}
+	value, ok := cm.GetJobConfigValue(aiPricingJobName, "aiPricing")
+	if !ok {
+		return nil
+	}
+	pricing, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return parseAIPricingTable(pricing)
+}
+
+// parseAIPricingTable parse bảng giá dạng {"key": {"input": .., "output": ..}}, bỏ qua entry không hợp lệ
+func parseAIPricingTable(raw map[string]interface{}) map[string]AIModelPrice {
+	table := make(map[string]AIModelPrice, len(raw))
+	for key, entry := range raw {
+		entryMap, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if price, ok := parseAIModelPrice(entryMap); ok {
+			table[strings.ToLower(key)] = price
+		}
+	}
+	return table
+}
+
+// parseAIModelPrice parse {"input": .., "output": .., "cachedInput": .., "cacheWrite": ..}
+func parseAIModelPrice(raw map[string]interface{}) (AIModelPrice, bool) {
+	input, hasInput := toFloat64(raw["input"])
+	output, hasOutput := toFloat64(raw["output"])
+	if !hasInput && !hasOutput {
+		return AIModelPrice{}, false
+	}
+	cachedInput, _ := toFloat64(raw["cachedInput"])
+	cacheWrite, _ := toFloat64(raw["cacheWrite"])
+	return AIModelPrice{Input: input, Output: output, CachedInput: cachedInput, CacheWrite: cacheWrite}, true
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// ========================================
+// NGÂN SÁCH CỦA WORKFLOW RUN
+// ========================================
+
+// AIBudgetExceededError là lỗi khi chi phí của workflow run vượt ngân sách
+type AIBudgetExceededError struct {
+	Limit float64
+	Spent float64
+}
+
+func (e *AIBudgetExceededError) Error() string {
+	return fmt.Sprintf("workflow run vượt ngân sách AI: đã dùng $%.4f / giới hạn $%.4f", e.Spent, e.Limit)
+}
+
+// AIBudget theo dõi chi phí AI của một workflow run
+// Limit <= 0 là không giới hạn. Các method an toàn khi budget là nil.
+type AIBudget struct {
+	mu    sync.Mutex
+	limit float64
+	spent float64
+	calls int
+}
+
+// NewAIBudget tạo budget với giới hạn limit (USD)
+func NewAIBudget(limit float64) *AIBudget {
+	return &AIBudget{limit: limit}
+}
+
+// ResolveAIBudgetLimit lấy giới hạn chi phí của một run: params["maxCost"] của command → config "maxRunCost" → không giới hạn
+func ResolveAIBudgetLimit(params map[string]interface{}) float64 {
+	if limit, ok := toFloat64(params["maxCost"]); ok {
+		return limit
+	}
+	if cm := GetGlobalConfigManager(); cm != nil {
+		if value, ok := cm.GetJobConfigValue(aiPricingJobName, "maxRunCost"); ok {
+			if limit, ok := toFloat64(value); ok {
+				return limit
+			}
+		}
+	}
+	return 0
+}
+
+// Check trả về lỗi nếu run đã dùng hết ngân sách (gọi trước mỗi AI call)
+func (b *AIBudget) Check() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.spent >= b.limit {
+		return &AIBudgetExceededError{Limit: b.limit, Spent: b.spent}
+	}
+	return nil
+}
+
+// Add cộng chi phí của một AI call, trả về lỗi nếu sau khi cộng vượt ngân sách
+func (b *AIBudget) Add(amount float64) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent += amount
+	b.calls++
+	if b.limit > 0 && b.spent > b.limit {
+		return &AIBudgetExceededError{Limit: b.limit, Spent: b.spent}
+	}
+	return nil
+}
+
+// Restore khôi phục chi phí đã dùng của run trước khi agent khởi động lại (run được chạy tiếp)
+func (b *AIBudget) Restore(spent float64, calls int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent += spent
+	b.calls += calls
+}
+
+// Spent trả về tổng chi phí và số AI call của run
+func (b *AIBudget) Spent() (float64, int) {
+	if b == nil {
+		return 0, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spent, b.calls
+}
+
+// Limit trả về giới hạn của run (0 = không giới hạn)
+func (b *AIBudget) Limit() float64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// ========================================
+// THỐNG KÊ CHI PHÍ CỘNG DỒN (CHECK-IN METRICS)
+// ========================================
+
+// AISpendModelMetrics là chi phí cộng dồn của một provider/model
+type AISpendModelMetrics struct {
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens"`
+	Cost             float64 `json:"cost"`
+	UnpricedCalls    int64   `json:"unpricedCalls,omitempty"` // Số call không tìm thấy giá (cost = 0)
+}
+
+// AISpendMetrics là chi phí AI cộng dồn từ lúc agent khởi động
+type AISpendMetrics struct {
+	Since            int64                 `json:"since"` // Unix timestamp lúc bắt đầu đếm
+	TotalCalls       int64                 `json:"totalCalls"`
+	PromptTokens     int64                 `json:"promptTokens"`
+	CompletionTokens int64                 `json:"completionTokens"`
+	TotalCost        float64               `json:"totalCost"` // USD
+	UnpricedCalls    int64                 `json:"unpricedCalls"`
+	EstimatedCalls   int64                 `json:"estimatedCalls"` // Số call có usage ước lượng
+	BudgetAborts     int64                 `json:"budgetAborts"`   // Số workflow run bị dừng vì vượt ngân sách
+	CacheHits        int64                 `json:"cacheHits"`      // Số AI call lấy từ cache (không tốn chi phí)
+	ByModel          []AISpendModelMetrics `json:"byModel"`
+}
+
+type aiSpendTracker struct {
+	mu      sync.Mutex
+	since   time.Time
+	total   AISpendMetrics
+	byModel map[string]*AISpendModelMetrics
+}
+
+var globalAISpend = &aiSpendTracker{
+	since:   time.Now(),
+	byModel: make(map[string]*AISpendModelMetrics),
+}
+
+// RecordAISpend ghi nhận chi phí của một AI call vào thống kê cộng dồn
+func RecordAISpend(provider, model string, cost AICost) {
+	globalAISpend.mu.Lock()
+	defer globalAISpend.mu.Unlock()
+
+	key := provider + "/" + model
+	entry, ok := globalAISpend.byModel[key]
+	if !ok {
+		entry = &AISpendModelMetrics{Provider: provider, Model: model}
+		globalAISpend.byModel[key] = entry
+	}
+	entry.Calls++
+	entry.PromptTokens += int64(cost.TokensUsed.PromptTokens)
+	entry.CompletionTokens += int64(cost.TokensUsed.CompletionTokens)
+	entry.Cost += cost.Amount
+
+	total := &globalAISpend.total
+	total.TotalCalls++
+	total.PromptTokens += int64(cost.TokensUsed.PromptTokens)
+	total.CompletionTokens += int64(cost.TokensUsed.CompletionTokens)
+	total.TotalCost += cost.Amount
+	if !cost.Priced {
+		entry.UnpricedCalls++
+		total.UnpricedCalls++
+	}
+	if cost.Estimated {
+		total.EstimatedCalls++
+	}
+}
+
+// RecordAICacheHit ghi nhận một AI call lấy từ cache
+func RecordAICacheHit() {
+	globalAISpend.mu.Lock()
+	defer globalAISpend.mu.Unlock()
+	globalAISpend.total.CacheHits++
+}
+
+// RecordAIBudgetAbort ghi nhận một workflow run bị dừng vì vượt ngân sách
+func RecordAIBudgetAbort() {
+	globalAISpend.mu.Lock()
+	defer globalAISpend.mu.Unlock()
+	globalAISpend.total.BudgetAborts++
+}
+
+// GetAISpendMetrics trả về thống kê chi phí AI cộng dồn (nil nếu chưa có AI call nào)
+func GetAISpendMetrics() *AISpendMetrics {
+	globalAISpend.mu.Lock()
+	defer globalAISpend.mu.Unlock()
+	if globalAISpend.total.TotalCalls == 0 && globalAISpend.total.BudgetAborts == 0 && globalAISpend.total.CacheHits == 0 {
+		return nil
+	}
+
+	metrics := globalAISpend.total
+	metrics.Since = globalAISpend.since.Unix()
+	metrics.ByModel = make([]AISpendModelMetrics, 0, len(globalAISpend.byModel))
+	for _, entry := range globalAISpend.byModel {
+		metrics.ByModel = append(metrics.ByModel, *entry)
+	}
+	sort.Slice(metrics.ByModel, func(i, j int) bool {
+		return metrics.ByModel[i].Cost > metrics.ByModel[j].Cost
+	})
+	return &metrics
+}
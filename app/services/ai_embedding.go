@@ -0,0 +1,157 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần gọi Embeddings API của AI provider (dùng cho step EMBED):
+  - Hỗ trợ OpenAI và custom provider OpenAI-compatible (endpoint /v1/embeddings, đổi bằng config "embeddingEndpoint")
+  - Provider khác trả lỗi không hỗ trợ
+*/
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// AIEmbeddingRequest là request để lấy embedding của các đoạn text
+type AIEmbeddingRequest struct {
+	ProviderProfile *AIProviderProfile
+	Model           string   // Model embedding (nếu không có thì dùng config "embeddingModel" của profile)
+	Inputs          []string // Các đoạn text cần embedding
+	Dimensions      int      // Số chiều vector (0 = mặc định của model)
+	Context         context.Context
+}
+
+// AIEmbeddingResponse là response của Embeddings API
+type AIEmbeddingResponse struct {
+	Vectors [][]float64
+	Model   string
+	Usage   *AIUsage
+	Latency time.Duration
+}
+
+// openAIEmbeddingResponse là response của OpenAI-compatible Embeddings API
+type openAIEmbeddingResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Usage openAIUsage `json:"usage"`
+}
+
+// Embed gọi Embeddings API của provider, vector trả về theo đúng thứ tự Inputs
+func (s *AIClientService) Embed(req AIEmbeddingRequest) (*AIEmbeddingResponse, error) {
+	profile := req.ProviderProfile
+	if profile == nil {
+		return nil, errors.New("provider profile không được để trống")
+	}
+	if len(req.Inputs) == 0 {
+		return nil, errors.New("không có text để embedding")
+	}
+
+	model := req.Model
+	if model == "" {
+		model, _ = profile.Config["embeddingModel"].(string)
+	}
+
+	var url string
+	switch profile.Provider {
+	case AIProviderTypeOpenAI:
+		if model == "" {
+			model = "text-embedding-3-small"
+		}
+		url = "https://api.openai.com/v1/embeddings"
+		if profile.BaseURL != "" {
+			url = profile.BaseURL + "/v1/embeddings"
+		}
+	case AIProviderTypeCustom:
+		if profile.BaseURL == "" {
+			return nil, errors.New("Custom provider cần BaseURL trong config")
+		}
+		if model == "" {
+			model = "default"
+		}
+		endpoint := "/v1/embeddings"
+		if customEndpoint, ok := profile.Config["embeddingEndpoint"].(string); ok && customEndpoint != "" {
+			endpoint = customEndpoint
+		}
+		url = profile.BaseURL + endpoint
+	default:
+		return nil, fmt.Errorf("provider %s không hỗ trợ embedding", profile.Provider)
+	}
+
+	requestBody := map[string]interface{}{
+		"model": model,
+		"input": req.Inputs,
+	}
+	if req.Dimensions > 0 {
+		requestBody["dimensions"] = req.Dimensions
+	}
+	httpReq, err := newJSONRequest(url, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Provider == AIProviderTypeCustom {
+		setCustomAuthHeader(httpReq, profile)
+	} else {
+		httpReq.Header.Set("Authorization", "Bearer "+profile.APIKey)
+		if profile.OrganizationID != "" {
+			httpReq.Header.Set("OpenAI-Organization", profile.OrganizationID)
+		}
+	}
+	prepared := &aiHTTPRequest{provider: profile.Provider, httpReq: httpReq, model: model}
+
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	release, err := acquireProviderSlot(ctx, profile)
+	if err != nil {
+		return nil, fmt.Errorf("embedding bị hủy khi đang đợi slot của provider: %w", err)
+	}
+	defer release()
+
+	startTime := time.Now()
+	resp, err := s.httpClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, aiNetworkError(prepared, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi đọc response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, aiErrorFromResponse(prepared, resp, respBody)
+	}
+
+	var embeddingResp openAIEmbeddingResponse
+	if err := json.Unmarshal(respBody, &embeddingResp); err != nil {
+		return nil, fmt.Errorf("lỗi khi parse embedding response: %v", err)
+	}
+	if len(embeddingResp.Data) != len(req.Inputs) {
+		return nil, fmt.Errorf("embedding response có %d vector, cần %d", len(embeddingResp.Data), len(req.Inputs))
+	}
+
+	vectors := make([][]float64, len(req.Inputs))
+	for _, item := range embeddingResp.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding response có index không hợp lệ: %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	if embeddingResp.Model != "" {
+		model = embeddingResp.Model
+	}
+	return &AIEmbeddingResponse{
+		Vectors: vectors,
+		Model:   model,
+		Usage:   usagePtr(embeddingResp.Usage.normalize()),
+		Latency: time.Since(startTime),
+	}, nil
+}
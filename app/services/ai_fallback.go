@@ -0,0 +1,269 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa chuỗi fallback giữa các AI provider (CallWithFallback):
+- Gọi lần lượt từng target trong chuỗi (ví dụ: Anthropic → OpenAI → custom)
+- Lỗi tạm thời (429, 5xx, lỗi mạng) được retry trên cùng target với backoff, hết lượt thì chuyển target tiếp theo
+- Lỗi của riêng provider (401/403 sai API key, 404 model không tồn tại...) chuyển ngay sang target tiếp theo
+- Lỗi do chính request (400/422) dừng cả chuỗi vì provider khác cũng sẽ từ chối
+*/
+package services
+
+import (
+	"agent_pancake/app/integrations/apierror"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// AIFallbackTarget là một mắt xích trong chuỗi provider
+type AIFallbackTarget struct {
+	ProviderProfile *AIProviderProfile
+	Model           string // Model cho target này (rỗng = routing theo modelMap / DefaultModel của profile)
+}
+
+// AIRetryPolicy cấu hình retry trên cùng một target
+type AIRetryPolicy struct {
+	MaxAttempts int           // Số lần gọi tối đa trên mỗi target (tính cả lần đầu)
+	BaseDelay   time.Duration // Thời gian chờ trước lần retry đầu, nhân đôi sau mỗi lần
+	MaxDelay    time.Duration // Thời gian chờ tối đa giữa hai lần retry (kể cả khi provider gửi Retry-After dài hơn)
+}
+
+// DefaultAIRetryPolicy là policy mặc định: 3 lần/target, chờ 2s → 4s, tối đa 30s
+func DefaultAIRetryPolicy() AIRetryPolicy {
+	return AIRetryPolicy{MaxAttempts: 3, BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second}
+}
+
+// delay trả về thời gian chờ trước lần thử thứ attempt+1 (attempt bắt đầu từ 1)
+func (p AIRetryPolicy) delay(attempt int, err error) time.Duration {
+	wait := p.BaseDelay << (attempt - 1)
+	if retryAfter := apierror.RetryAfter(err); retryAfter > 0 {
+		wait = retryAfter
+	}
+	if p.MaxDelay > 0 && wait > p.MaxDelay {
+		wait = p.MaxDelay
+	}
+	return wait
+}
+
+// AIAttempt ghi lại một lần gọi provider trong chuỗi (để log và lưu vào AI run)
+type AIAttempt struct {
+	ProviderProfileID string        `json:"providerProfileId"`
+	Provider          string        `json:"provider"`
+	Model             string        `json:"model"`
+	Attempt           int           `json:"attempt"`
+	Category          string        `json:"category,omitempty"` // Nhóm lỗi (apierror.Category), rỗng nếu thành công
+	Error             string        `json:"error,omitempty"`
+	CacheHit          bool          `json:"cacheHit,omitempty"`
+	Latency           time.Duration `json:"-"`
+}
+
+// AIFallbackError là lỗi khi mọi target trong chuỗi đều thất bại (hoặc chuỗi bị dừng vì lỗi không thể fallback)
+// Unwrap trả về lỗi của lần thử cuối để Classify/IsRetryable hoạt động như bình thường
+type AIFallbackError struct {
+	Attempts []AIAttempt
+	Last     error
+	Aborted  bool // true nếu dừng sớm vì lỗi do request (không thử các target còn lại)
+}
+
+func (e *AIFallbackError) Error() string {
+	if e.Aborted {
+		return fmt.Sprintf("AI request không hợp lệ, dừng chuỗi fallback sau %d lần thử: %v", len(e.Attempts), e.Last)
+	}
+	return fmt.Sprintf("tất cả AI provider đều thất bại (%d lần thử): %v", len(e.Attempts), e.Last)
+}
+
+func (e *AIFallbackError) Unwrap() error { return e.Last }
+
+// aiFailureAction là cách xử lý một lần gọi thất bại
+type aiFailureAction int
+
+const (
+	aiRetrySameTarget aiFailureAction = iota // Lỗi tạm thời → thử lại cùng target
+	aiNextTarget                             // Lỗi của riêng provider → chuyển target tiếp theo
+	aiAbortChain                             // Lỗi do request → dừng cả chuỗi
+)
+
+// classifyAIFailure quyết định xử lý lỗi của một lần gọi provider
+func classifyAIFailure(err error) aiFailureAction {
+	switch apierror.Classify(err) {
+	case apierror.CategoryRateLimited, apierror.CategoryNetwork, apierror.CategoryUpstream:
+		return aiRetrySameTarget
+	case apierror.CategoryValidation:
+		return aiAbortChain
+	default:
+		// Unauthorized (sai/hết hạn API key), NotFound (model không có ở provider này), lỗi parse response...
+		return aiNextTarget
+	}
+}
+
+// routeModel chọn model cho một target:
+// target.Model → profile.Config["modelMap"][requestedModel] → requestedModel (nếu là target chính) → DefaultModel của profile
+func routeModel(target AIFallbackTarget, requestedModel string, primary bool) string {
+	if target.Model != "" {
+		return target.Model
+	}
+	if requestedModel != "" && target.ProviderProfile.Config != nil {
+		if modelMap, ok := target.ProviderProfile.Config["modelMap"].(map[string]interface{}); ok {
+			if mapped, ok := modelMap[requestedModel].(string); ok && mapped != "" {
+				return mapped
+			}
+		}
+	}
+	if primary {
+		return requestedModel
+	}
+	// Model của target chính thường không tồn tại ở provider khác → dùng model mặc định của profile
+	return ""
+}
+
+// CallWithFallback gọi AI lần lượt qua chuỗi target: req.ProviderProfile/req.Model là target chính, fallbacks là các target dự phòng
+// onDelta khác nil → gọi ở chế độ stream (CallStream)
+// Trả về response của lần gọi thành công (Provider/ProviderProfileID/Model là target thực sự đã dùng) và danh sách các lần thử
+func (s *AIClientService) CallWithFallback(req AICallRequest, fallbacks []AIFallbackTarget, policy AIRetryPolicy, onDelta func(AIStreamDelta)) (*AICallResponse, []AIAttempt, error) {
+	if req.ProviderProfile == nil {
+		return nil, nil, errors.New("provider profile không được để trống")
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	// Cache theo request của target chính: response từ provider dự phòng cũng được dùng lại cho lần chạy sau
+	cacheSettings := currentAICacheSettings()
+	cacheKey := ""
+	if cacheSettings.enabled && req.CacheMode != AICacheModeOff {
+		cacheKey = aiCacheKey(req)
+		if req.CacheMode != AICacheModeRefresh {
+			if entry, ok := getAIResponseCache().Get(cacheKey, cacheSettings.ttl); ok {
+				resp := entry.response(time.Now())
+				log.Printf("[AIClient] [Cache] ✅ Cache hit %s (%s, model: %s, tạo lúc %s)", cacheKey[:12], resp.Provider, resp.Model, entry.CreatedAt.Format(time.RFC3339))
+				return resp, []AIAttempt{{
+					ProviderProfileID: resp.ProviderProfileID,
+					Provider:          resp.Provider,
+					Model:             resp.Model,
+					Attempt:           1,
+					CacheHit:          true,
+				}}, nil
+			}
+		}
+	}
+
+	targets := append([]AIFallbackTarget{{ProviderProfile: req.ProviderProfile, Model: req.Model}}, fallbacks...)
+	var attempts []AIAttempt
+	var lastErr error
+
+	for targetIndex, target := range targets {
+		if target.ProviderProfile == nil {
+			continue
+		}
+		targetReq := req
+		targetReq.ProviderProfile = target.ProviderProfile
+		targetReq.Model = routeModel(target, req.Model, targetIndex == 0)
+
+		maxAttempts := policy.MaxAttempts
+		if n := getIntPtr(target.ProviderProfile.Config, "maxAttempts"); n != nil && *n > 0 {
+			maxAttempts = *n
+		}
+
+		if targetIndex > 0 {
+			log.Printf("[AIClient] [Fallback] ↪️  Chuyển sang provider dự phòng %d/%d: %s (%s), model: %s",
+				targetIndex, len(targets)-1, target.ProviderProfile.Name, target.ProviderProfile.Provider, displayModel(targetReq.Model))
+		}
+
+		for attempt := 1; attempt <= maxAttempts; attempt++ {
+			var resp *AICallResponse
+			var err error
+			release, err := acquireProviderSlot(req.ctx(), target.ProviderProfile)
+			if err != nil {
+				log.Printf("[AIClient] [Fallback] ⛔ AI call bị hủy khi đang đợi slot của provider: %v", err)
+				return nil, attempts, req.ctx().Err()
+			}
+			if onDelta != nil {
+				resp, err = s.CallStream(targetReq, onDelta)
+			} else {
+				resp, err = s.Call(targetReq)
+			}
+			release()
+			if err == nil && resp != nil && resp.Error != nil {
+				err = resp.Error
+			}
+
+			record := AIAttempt{
+				ProviderProfileID: target.ProviderProfile.ID,
+				Provider:          target.ProviderProfile.Provider,
+				Model:             targetReq.Model,
+				Attempt:           attempt,
+			}
+			if resp != nil {
+				record.Latency = resp.Latency
+				if resp.Model != "" {
+					record.Model = resp.Model
+				}
+			}
+
+			if err == nil {
+				attempts = append(attempts, record)
+				resp.Provider = target.ProviderProfile.Provider
+				resp.ProviderProfileID = target.ProviderProfile.ID
+				if resp.Model == "" {
+					resp.Model = targetReq.Model
+				}
+				if len(attempts) > 1 {
+					log.Printf("[AIClient] [Fallback] ✅ Thành công với %s (%s), model: %s sau %d lần thử", target.ProviderProfile.Name, resp.Provider, resp.Model, len(attempts))
+				}
+				if cacheKey != "" {
+					resp.CacheKey = cacheKey
+					if err := getAIResponseCache().Put(cacheKey, resp, cacheSettings.maxSize); err != nil {
+						log.Printf("[AIClient] [Cache] ⚠️  %v", err)
+					}
+				}
+				return resp, attempts, nil
+			}
+
+			record.Category = string(apierror.Classify(err))
+			record.Error = err.Error()
+			attempts = append(attempts, record)
+			lastErr = err
+
+			// Request bị hủy (command bị hủy từ server) → dừng ngay, không thử lại hay chuyển provider
+			if ctxErr := req.ctx().Err(); ctxErr != nil {
+				log.Printf("[AIClient] [Fallback] ⛔ AI call bị hủy: %v", context.Cause(req.ctx()))
+				return nil, attempts, ctxErr
+			}
+
+			action := classifyAIFailure(err)
+			log.Printf("[AIClient] [Fallback] ❌ %s (%s) lần %d/%d thất bại [%s]: %v",
+				target.ProviderProfile.Name, target.ProviderProfile.Provider, attempt, maxAttempts, record.Category, err)
+
+			if action == aiAbortChain {
+				return nil, attempts, &AIFallbackError{Attempts: attempts, Last: err, Aborted: true}
+			}
+			if action == aiNextTarget || attempt == maxAttempts {
+				break
+			}
+
+			wait := policy.delay(attempt, err)
+			log.Printf("[AIClient] [Fallback] Đợi %v trước khi thử lại %s...", wait, target.ProviderProfile.Name)
+			select {
+			case <-time.After(wait):
+			case <-req.ctx().Done():
+				return nil, attempts, req.ctx().Err()
+			}
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("chuỗi provider không có target hợp lệ")
+	}
+	return nil, attempts, &AIFallbackError{Attempts: attempts, Last: lastErr}
+}
+
+// displayModel hiển thị model trong log ("(mặc định)" nếu để provider tự chọn)
+func displayModel(model string) string {
+	if strings.TrimSpace(model) == "" {
+		return "(mặc định)"
+	}
+	return model
+}
@@ -0,0 +1,398 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần streaming của AI Client Service (CallStream):
+- OpenAI / Custom (OpenAI-compatible): SSE "data: {...}" kết thúc bằng "data: [DONE]"
+- Anthropic: SSE với các event message_start, content_block_delta, message_delta, message_stop
+- Google Gemini: streamGenerateContent?alt=sse, mỗi event là một GenerateContentResponse
+- Cohere: JSON theo dòng (text-generation, stream-end)
+*/
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	aiStreamHeaderTimeout = 60 * time.Second // Thời gian chờ tối đa đến khi provider trả header
+	aiStreamIdleTimeout   = 60 * time.Second // Không nhận được dữ liệu trong khoảng này → hủy stream
+	aiStreamMaxDuration   = 10 * time.Minute // Thời gian tối đa của cả một lần stream
+	aiStreamMaxLineSize   = 1024 * 1024      // Kích thước tối đa của một dòng SSE
+)
+
+// AIStreamDelta là một đoạn text mới nhận được trong lúc stream
+type AIStreamDelta struct {
+	Content          string // Đoạn text mới
+	AccumulatedChars int    // Tổng số ký tự đã nhận (tính cả Content)
+	CompletionTokens int    // Số token output provider đã báo (0 nếu provider chỉ báo ở cuối stream)
+	MaxTokens        int    // Max tokens của request (để ước lượng tiến độ)
+}
+
+// EstimatedProgress ước lượng tiến độ sinh (0.0 - 1.0) dựa trên token đã sinh so với MaxTokens
+// Provider không báo token trong lúc stream thì ước lượng ~4 ký tự/token
+func (d AIStreamDelta) EstimatedProgress() float64 {
+	if d.MaxTokens <= 0 {
+		return 0
+	}
+	tokens := d.CompletionTokens
+	if tokens == 0 {
+		tokens = d.AccumulatedChars / 4
+	}
+	progress := float64(tokens) / float64(d.MaxTokens)
+	if progress > 1 {
+		progress = 1
+	}
+	return progress
+}
+
+// streamAccumulator gom các delta thành response cuối cùng và gọi callback cho từng delta
+type streamAccumulator struct {
+	content      strings.Builder
+	model        string
+	finishReason string
+	usage        AIUsage
+	maxTokens    int
+	onDelta      func(AIStreamDelta)
+	toolOutput   bool // Anthropic structured output: content là input JSON của tool
+}
+
+func (a *streamAccumulator) appendText(text string) {
+	if text == "" {
+		return
+	}
+	a.content.WriteString(text)
+	if a.onDelta != nil {
+		a.onDelta(AIStreamDelta{
+			Content:          text,
+			AccumulatedChars: a.content.Len(),
+			CompletionTokens: a.usage.CompletionTokens,
+			MaxTokens:        a.maxTokens,
+		})
+	}
+}
+
+// streamChunkParser xử lý payload của một event, trả về done=true khi provider báo kết thúc stream
+type streamChunkParser func(data []byte, acc *streamAccumulator) (done bool, err error)
+
+// CallStream gọi AI provider API ở chế độ streaming
+// onDelta được gọi (tuần tự, trên goroutine gọi hàm) cho mỗi đoạn text mới, có thể nil
+// Kết quả trả về giống Call: response đầy đủ sau khi stream kết thúc, lỗi HTTP của provider nằm trong AICallResponse.Error
+func (s *AIClientService) CallStream(req AICallRequest, onDelta func(AIStreamDelta)) (*AICallResponse, error) {
+	if req.ProviderProfile == nil {
+		return nil, errors.New("provider profile không được để trống")
+	}
+
+	startTime := time.Now()
+	providerName := req.ProviderProfile.Provider
+
+	var prepared *aiHTTPRequest
+	var parser streamChunkParser
+	var err error
+	switch providerName {
+	case AIProviderTypeOpenAI:
+		prepared, err = buildOpenAIRequest(req, true)
+		parser = parseOpenAIStreamChunk
+	case AIProviderTypeAnthropic:
+		prepared, err = buildAnthropicRequest(req, true)
+		parser = parseAnthropicStreamChunk
+	case AIProviderTypeGoogle:
+		prepared, err = buildGoogleRequest(req, true)
+		parser = parseGoogleStreamChunk
+	case AIProviderTypeCohere:
+		prepared, err = buildCohereRequest(req, true)
+		parser = parseCohereStreamChunk
+	case AIProviderTypeCustom:
+		prepared, err = buildCustomRequest(req, true)
+		parser = parseOpenAIStreamChunk
+	default:
+		return nil, fmt.Errorf("provider type không được hỗ trợ: %s", providerName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(req.ctx(), aiStreamMaxDuration)
+	defer cancel()
+
+	// Hủy request nếu quá aiStreamIdleTimeout không nhận được dòng nào
+	var idledOut atomic.Bool
+	idleTimer := time.AfterFunc(aiStreamIdleTimeout, func() {
+		idledOut.Store(true)
+		cancel()
+	})
+	defer idleTimer.Stop()
+
+	httpReq := prepared.httpReq.WithContext(ctx)
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	log.Printf("[AIClient] [Stream] Bắt đầu stream %s - Model: %s, MaxTokens: %d", providerName, prepared.model, prepared.maxTokens)
+
+	resp, err := s.streamClient.Do(httpReq)
+	if err != nil {
+		if idledOut.Load() {
+			err = fmt.Errorf("không nhận được phản hồi trong %v", aiStreamIdleTimeout)
+		}
+		return nil, aiNetworkError(prepared, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		latency := time.Since(startTime)
+		log.Printf("[AIClient] [Stream] ❌ %s API trả về lỗi (status %d): %s", providerName, resp.StatusCode, string(respBody))
+		return &AICallResponse{
+			Latency: latency,
+			Error:   aiErrorFromResponse(prepared, resp, respBody),
+		}, nil
+	}
+
+	acc := &streamAccumulator{
+		model:     prepared.model,
+		maxTokens: prepared.maxTokens,
+		onDelta:   onDelta,
+	}
+	err = readStreamEvents(resp.Body, func() { idleTimer.Reset(aiStreamIdleTimeout) }, func(data []byte) (bool, error) {
+		return parser(data, acc)
+	})
+	latency := time.Since(startTime)
+	if err != nil {
+		if idledOut.Load() {
+			err = fmt.Errorf("stream bị hủy vì không nhận được dữ liệu trong %v", aiStreamIdleTimeout)
+		}
+		log.Printf("[AIClient] [Stream] ❌ Lỗi khi đọc stream %s sau %v (%d chars đã nhận): %v", providerName, latency, acc.content.Len(), err)
+		// Stream đứt giữa chừng coi như lỗi mạng (retry được), lỗi provider báo trong stream giữ nguyên
+		if idledOut.Load() || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, aiNetworkError(prepared, err)
+		}
+		return nil, fmt.Errorf("lỗi khi đọc stream %s: %w", providerName, err)
+	}
+
+	usage := finalizeUsage(&acc.usage, req, acc.content.String())
+
+	log.Printf("[AIClient] [Stream] ✅ %s stream hoàn thành - Content length: %d chars, FinishReason: %s, Latency: %v",
+		providerName, acc.content.Len(), acc.finishReason, latency)
+	log.Printf("[AIClient] [Stream] Usage - Prompt: %d, Completion: %d, Total: %d",
+		usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+
+	return &AICallResponse{
+		Content:      acc.content.String(),
+		Model:        acc.model,
+		Usage:        usage,
+		FinishReason: acc.finishReason,
+		Latency:      latency,
+	}, nil
+}
+
+// readStreamEvents đọc body dạng SSE ("data: ...") hoặc JSON theo dòng và gọi handle cho từng payload
+// Dòng "event:", "id:", comment (":") và dòng trống được bỏ qua; "data: [DONE]" kết thúc stream
+// onLine được gọi mỗi khi nhận được một dòng (dùng để gia hạn idle timeout)
+func readStreamEvents(body io.Reader, onLine func(), handle func(data []byte) (bool, error)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), aiStreamMaxLineSize)
+
+	for scanner.Scan() {
+		onLine()
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ":") || strings.HasPrefix(line, "event:") || strings.HasPrefix(line, "id:") {
+			continue
+		}
+
+		payload := line
+		if strings.HasPrefix(line, "data:") {
+			payload = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+		if payload == "[DONE]" {
+			return nil
+		}
+
+		done, err := handle([]byte(payload))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	// Một số provider đóng kết nối mà không gửi event kết thúc, coi như stream đã xong
+	return scanner.Err()
+}
+
+// parseOpenAIStreamChunk xử lý chunk của OpenAI-compatible Chat Completions (stream=true)
+func parseOpenAIStreamChunk(data []byte, acc *streamAccumulator) (bool, error) {
+	var chunk struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+			FinishReason *string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *openAIUsage `json:"usage"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return false, fmt.Errorf("không parse được chunk: %v", err)
+	}
+	if chunk.Error != nil {
+		return false, fmt.Errorf("provider báo lỗi trong stream: %s", chunk.Error.Message)
+	}
+
+	if chunk.Model != "" {
+		acc.model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		acc.usage = chunk.Usage.normalize()
+	}
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			acc.finishReason = *choice.FinishReason
+		}
+		acc.appendText(choice.Delta.Content)
+	}
+	// Không kết thúc ở finish_reason: chunk usage (include_usage) đến sau, đợi "data: [DONE]"
+	return false, nil
+}
+
+// parseAnthropicStreamChunk xử lý event của Anthropic Messages API (stream=true)
+func parseAnthropicStreamChunk(data []byte, acc *streamAccumulator) (bool, error) {
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			Model string         `json:"model"`
+			Usage anthropicUsage `json:"usage"`
+		} `json:"message"`
+		ContentBlock struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+		} `json:"content_block"`
+		Delta struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage struct {
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return false, fmt.Errorf("không parse được event: %v", err)
+	}
+
+	switch event.Type {
+	case "message_start":
+		if event.Message.Model != "" {
+			acc.model = event.Message.Model
+		}
+		acc.usage = event.Message.Usage.normalize()
+	case "content_block_start":
+		// Structured output (tool use): chỉ lấy input JSON của tool, bỏ text mở đầu nếu có
+		if event.ContentBlock.Type == "tool_use" && event.ContentBlock.Name == aiStructuredOutputName {
+			acc.content.Reset()
+			acc.toolOutput = true
+		}
+	case "content_block_delta":
+		if event.Delta.Type == "text_delta" && !acc.toolOutput {
+			acc.appendText(event.Delta.Text)
+		}
+		if event.Delta.Type == "input_json_delta" {
+			acc.appendText(event.Delta.PartialJSON)
+		}
+	case "message_delta":
+		if event.Delta.StopReason != "" {
+			acc.finishReason = event.Delta.StopReason
+		}
+		if event.Usage.OutputTokens > 0 {
+			acc.usage.CompletionTokens = event.Usage.OutputTokens
+		}
+	case "message_stop":
+		return true, nil
+	case "error":
+		return false, fmt.Errorf("provider báo lỗi trong stream (%s): %s", event.Error.Type, event.Error.Message)
+	}
+	return false, nil
+}
+
+// parseGoogleStreamChunk xử lý chunk của Gemini streamGenerateContent (alt=sse)
+func parseGoogleStreamChunk(data []byte, acc *streamAccumulator) (bool, error) {
+	var chunk struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+			FinishReason string `json:"finishReason"`
+		} `json:"candidates"`
+		UsageMetadata *googleUsage `json:"usageMetadata"`
+		Error         *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return false, fmt.Errorf("không parse được chunk: %v", err)
+	}
+	if chunk.Error != nil {
+		return false, fmt.Errorf("provider báo lỗi trong stream: %s", chunk.Error.Message)
+	}
+
+	// usageMetadata trong mỗi chunk là số cộng dồn, chunk sau ghi đè chunk trước
+	if chunk.UsageMetadata != nil {
+		acc.usage = chunk.UsageMetadata.normalize()
+	}
+	if len(chunk.Candidates) > 0 {
+		candidate := chunk.Candidates[0]
+		for _, part := range candidate.Content.Parts {
+			acc.appendText(part.Text)
+		}
+		if candidate.FinishReason != "" {
+			acc.finishReason = candidate.FinishReason
+		}
+	}
+	return false, nil
+}
+
+// parseCohereStreamChunk xử lý event của Cohere Chat API (stream=true, JSON theo dòng)
+func parseCohereStreamChunk(data []byte, acc *streamAccumulator) (bool, error) {
+	var event struct {
+		EventType    string `json:"event_type"`
+		Text         string `json:"text"`
+		FinishReason string `json:"finish_reason"`
+		Response     struct {
+			Meta struct {
+				BilledUnits cohereUsage `json:"billed_units"`
+			} `json:"meta"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return false, fmt.Errorf("không parse được event: %v", err)
+	}
+
+	switch event.EventType {
+	case "text-generation":
+		acc.appendText(event.Text)
+	case "stream-end":
+		acc.finishReason = event.FinishReason
+		acc.usage = event.Response.Meta.BilledUnits.normalize()
+		if event.FinishReason == "ERROR" || event.FinishReason == "ERROR_TOXIC" {
+			return true, fmt.Errorf("Cohere kết thúc stream với lỗi: %s", event.FinishReason)
+		}
+		return true, nil
+	}
+	return false, nil
+}
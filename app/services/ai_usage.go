@@ -0,0 +1,129 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chuẩn hóa token usage của các AI provider về AIUsage:
+- OpenAI / Custom (OpenAI-compatible): prompt_tokens, completion_tokens, prompt_tokens_details.cached_tokens (hoặc input_tokens/output_tokens)
+- Anthropic: input_tokens + cache_creation_input_tokens (ghi cache, giá riêng) + cache_read_input_tokens, output_tokens
+- Google: promptTokenCount, candidatesTokenCount + thoughtsTokenCount (thinking tính giá output), cachedContentTokenCount
+- Cohere: meta.billed_units.input_tokens / output_tokens
+Provider không trả usage (một số custom provider, stream bị cắt usage) → ước lượng theo số ký tự, đánh dấu Estimated
+*/
+package services
+
+import "unicode/utf8"
+
+// aiCharsPerToken là số ký tự trung bình của một token, dùng để ước lượng khi provider không trả usage
+const aiCharsPerToken = 4
+
+// openAIUsage là usage của OpenAI Chat Completions (dùng chung cho Custom provider OpenAI-compatible)
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	// Một số server OpenAI-compatible dùng tên field kiểu Anthropic
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (u openAIUsage) normalize() AIUsage {
+	usage := AIUsage{
+		PromptTokens:       u.PromptTokens,
+		CompletionTokens:   u.CompletionTokens,
+		TotalTokens:        u.TotalTokens,
+		CachedPromptTokens: u.PromptTokensDetails.CachedTokens,
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		usage.PromptTokens = u.InputTokens
+		usage.CompletionTokens = u.OutputTokens
+	}
+	return usage
+}
+
+// anthropicUsage là usage của Anthropic Messages API
+// input_tokens không gồm phần prompt ghi/đọc từ cache nên phải cộng lại để ra tổng prompt,
+// phần ghi cache được giữ riêng vì giá cao hơn input thường
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+func (u anthropicUsage) normalize() AIUsage {
+	return AIUsage{
+		PromptTokens:           u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+		CompletionTokens:       u.OutputTokens,
+		CachedPromptTokens:     u.CacheReadInputTokens,
+		CacheWritePromptTokens: u.CacheCreationInputTokens,
+	}
+}
+
+// googleUsage là usageMetadata của Gemini API
+type googleUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+}
+
+func (u googleUsage) normalize() AIUsage {
+	return AIUsage{
+		PromptTokens:       u.PromptTokenCount,
+		CompletionTokens:   u.CandidatesTokenCount + u.ThoughtsTokenCount,
+		TotalTokens:        u.TotalTokenCount,
+		CachedPromptTokens: u.CachedContentTokenCount,
+	}
+}
+
+// cohereUsage là meta.billed_units của Cohere Chat API
+type cohereUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (u cohereUsage) normalize() AIUsage {
+	return AIUsage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+}
+
+// finalizeUsage hoàn thiện usage của một lần gọi thành công:
+// tính TotalTokens nếu provider không trả, ước lượng theo số ký tự nếu provider không trả usage
+func finalizeUsage(usage *AIUsage, req AICallRequest, content string) *AIUsage {
+	if usage == nil {
+		usage = &AIUsage{}
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 && usage.TotalTokens == 0 {
+		usage.PromptTokens = estimateTokens(promptChars(req))
+		usage.CompletionTokens = estimateTokens(utf8.RuneCountInString(content))
+		usage.Estimated = true
+	}
+	if usage.TotalTokens < usage.PromptTokens+usage.CompletionTokens {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage
+}
+
+// promptChars đếm số ký tự gửi lên provider (system prompt, history, prompt)
+func promptChars(req AICallRequest) int {
+	chars := utf8.RuneCountInString(req.SystemPrompt) + utf8.RuneCountInString(req.Prompt)
+	for _, message := range req.Messages {
+		chars += utf8.RuneCountInString(message.Content)
+	}
+	return chars
+}
+
+func estimateTokens(chars int) int {
+	if chars <= 0 {
+		return 0
+	}
+	return (chars + aiCharsPerToken - 1) / aiCharsPerToken
+}
+
+func usagePtr(usage AIUsage) *AIUsage {
+	return &usage
+}
@@ -0,0 +1,148 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa boundedPool: pool goroutine có giới hạn dùng chung cho CommandExecutor và WorkflowWorkerPool
+  - Giới hạn đọc lại qua hàm limit mỗi lần cần (config thay đổi có hiệu lực ngay)
+  - Task vượt giới hạn xếp hàng FIFO, task xong thì lấy task đầu hàng đợi chạy tiếp
+  - Task trùng ID (đang chạy hoặc đang xếp hàng) không được submit lại
+*/
+package services
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// queuedPoolTask là task đã submit nhưng chưa có slot
+type queuedPoolTask struct {
+	id  string
+	run func()
+}
+
+// boundedPool chạy task trong goroutine riêng, tối đa limit() task cùng lúc
+type boundedPool struct {
+	tag   string // Tag log, ví dụ "WorkerPool"
+	limit func() int
+
+	mu     sync.Mutex
+	active map[string]time.Time // taskID → thời điểm bắt đầu
+	queue  []queuedPoolTask
+}
+
+// newBoundedPool tạo pool với tag log và hàm đọc giới hạn
+func newBoundedPool(tag string, limit func() int) *boundedPool {
+	return &boundedPool{
+		tag:    tag,
+		limit:  limit,
+		active: make(map[string]time.Time),
+	}
+}
+
+// maxConcurrent trả về số task chạy đồng thời tối đa (tối thiểu 1)
+func (p *boundedPool) maxConcurrent() int {
+	limit := 1
+	if p.limit != nil {
+		limit = p.limit()
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// freeSlots trả về số task có thể nhận thêm (slot trống trừ đi task đang xếp hàng)
+func (p *boundedPool) freeSlots() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	free := p.maxConcurrent() - len(p.active) - len(p.queue)
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// has kiểm tra task đang chạy hoặc đang xếp hàng
+func (p *boundedPool) has(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hasLocked(id)
+}
+
+func (p *boundedPool) hasLocked(id string) bool {
+	if _, ok := p.active[id]; ok {
+		return true
+	}
+	for _, task := range p.queue {
+		if task.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// submit chạy run trong goroutine riêng nếu còn slot, ngược lại xếp hàng
+// Trả về false nếu task đã có trong pool (không submit lại)
+func (p *boundedPool) submit(id string, run func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasLocked(id) {
+		return false
+	}
+	if len(p.active) >= p.maxConcurrent() {
+		p.queue = append(p.queue, queuedPoolTask{id: id, run: run})
+		log.Printf("[%s] ⏳ Hết slot (%d đang chạy), command %s xếp hàng (queue: %d)", p.tag, len(p.active), id, len(p.queue))
+		return true
+	}
+	p.start(id, run)
+	return true
+}
+
+// start chạy task, gọi khi đang giữ p.mu
+func (p *boundedPool) start(id string, run func()) {
+	p.active[id] = time.Now()
+	go func() {
+		defer p.finish(id)
+		run()
+	}()
+}
+
+// finish giải phóng slot và chạy các task đang xếp hàng nếu còn slot
+func (p *boundedPool) finish(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, id)
+	for len(p.queue) > 0 && len(p.active) < p.maxConcurrent() {
+		next := p.queue[0]
+		p.queue = p.queue[1:]
+		p.start(next.id, next.run)
+	}
+}
+
+// dequeue bỏ task đang xếp hàng khỏi hàng đợi, trả về false nếu task không xếp hàng
+func (p *boundedPool) dequeue(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, task := range p.queue {
+		if task.id == id {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot trả về ID các task đang chạy (đã sắp xếp), số task xếp hàng và thời gian chạy lâu nhất
+func (p *boundedPool) snapshot() (activeIDs []string, queueDepth int, longestRunning time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for id, startedAt := range p.active {
+		activeIDs = append(activeIDs, id)
+		if running := now.Sub(startedAt); running > longestRunning {
+			longestRunning = running
+		}
+	}
+	sort.Strings(activeIDs)
+	return activeIDs, len(p.queue), longestRunning
+}
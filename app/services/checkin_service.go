@@ -9,8 +9,12 @@ import (
 	"agent_pancake/app/scheduler"
 	"agent_pancake/global"
 	"agent_pancake/utility/logger"
+	"context"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -24,7 +28,12 @@ type CheckInService struct {
 	configManager       *ConfigManager
 	checkInInterval     time.Duration
 	stopChan            chan struct{}
-	logger              *logrus.Logger // Logger để ghi log vào file
+	logger              *logrus.Logger   // Logger để ghi log vào file
+	commandJournal      *CommandJournal  // Command đã nhận (chống thực thi trùng, gửi lại trạng thái)
+	commandExecutor     *CommandExecutor // Thực thi command chạy lâu (run_job) không chặn check-in
+	checkInFailures     atomic.Int64     // Số lần check-in lên server thất bại liên tiếp (rule upstream của health)
+	lastHealthMu        sync.Mutex
+	lastHealthStatus    string // Health status của lần check-in trước (log khi thay đổi)
 }
 
 // NewCheckInService tạo một instance mới của CheckInService
@@ -42,7 +51,13 @@ func NewCheckInService(s *scheduler.Scheduler, cm *ConfigManager) *CheckInServic
 	// Tạo logger riêng cho check-in service để log vào file
 	checkInLogger := logger.GetLogger("check-in-service")
 
-	return &CheckInService{
+	// Journal nằm cùng thư mục với file config local
+	journalDir := "./config"
+	if cm != nil {
+		journalDir = filepath.Dir(cm.localConfigPath)
+	}
+
+	service := &CheckInService{
 		scheduler:           s,
 		metricsCollector:    NewMetricsCollector(s),
 		systemInfoCollector: NewSystemInfoCollector(),
@@ -50,7 +65,12 @@ func NewCheckInService(s *scheduler.Scheduler, cm *ConfigManager) *CheckInServic
 		configManager:       cm,
 		stopChan:            make(chan struct{}),
 		logger:              checkInLogger,
+		commandJournal:      NewCommandJournal(filepath.Join(journalDir, "command-journal.json")),
 	}
+	service.commandExecutor = NewCommandExecutor(func() int {
+		return service.commandsConfig().MaxConcurrent
+	})
+	return service
 }
 
 // AgentCheckInRequest chứa dữ liệu check-in từ bot
@@ -59,13 +79,18 @@ type AgentCheckInRequest struct {
 	AgentID       string                 `json:"agentId"`
 	Timestamp     int64                  `json:"timestamp"`
 	SystemInfo    SystemInfo             `json:"systemInfo"`
-	Status        string                 `json:"status"`       // "online", "offline", "error", "maintenance"
-	HealthStatus  string                 `json:"healthStatus"` // "healthy", "degraded", "unhealthy"
+	Status        string                 `json:"status"`                  // "online", "offline", "error", "maintenance"
+	HealthStatus  string                 `json:"healthStatus"`            // "healthy", "degraded", "unhealthy"
+	HealthReasons []HealthReason         `json:"healthReasons,omitempty"` // Lý do health không "healthy" (xem health.go)
 	Metrics       AgentMetrics           `json:"metrics"`
 	JobStatus     []JobStatus            `json:"jobStatus"`
 	ConfigVersion int64                  `json:"configVersion"` // Unix timestamp (server tự động quyết định)
 	ConfigHash    string                 `json:"configHash"`
-	ConfigData    map[string]interface{} `json:"configData,omitempty"` // Chỉ gửi khi cần submit full config
+	ConfigData    map[string]interface{} `json:"configData,omitempty"`   // Chỉ gửi khi cần submit full config
+	ConfigSchema  map[string]interface{} `json:"configSchema,omitempty"` // JSON Schema của config, gửi kèm full config
+	ConfigApply   *ConfigApplyResult     `json:"configApply,omitempty"`  // Kết quả apply config update gần nhất (appliedStatus: "applied"/"failed")
+	ConfigLocks   []*ConfigOverride      `json:"configLocks,omitempty"`  // Field bị khóa bởi ENV/CLI, server không đổi được từ xa
+	JobTypes      []*JobTypeInfo         `json:"jobTypes,omitempty"`     // Loại job server có thể tạo thêm instance, gửi kèm full config
 	Errors        []ErrorReport          `json:"errors,omitempty"`
 	// Metadata fields (theo API v3.14 - Agent UI-Friendly Metadata Updates)
 	DisplayName string   `json:"displayName,omitempty"` // Tên hiển thị của agent (ví dụ: "Pancake Sync Agent")
@@ -95,7 +120,7 @@ type CheckInData struct {
 type AgentCommand struct {
 	ID          string                 `json:"id"`                    // Command ID (bắt buộc để update status)
 	AgentID     string                 `json:"agentId"`               // Agent ID (string, không phải ObjectID)
-	Type        string                 `json:"type"`                  // "stop", "start", "restart", "reload_config", "shutdown", "run_job", "pause_job", "resume_job", "disable_job", "enable_job", "update_job_schedule"
+	Type        string                 `json:"type"`                  // "stop", "start", "restart", "reload_config", "shutdown", "run_job", "pause_job", "resume_job", "disable_job", "enable_job", "update_job_schedule", "cancel_workflow_command", "rollback_config"
 	Target      string                 `json:"target"`                // "bot" hoặc job name
 	Params      map[string]interface{} `json:"params,omitempty"`      // Parameters cho command
 	Status      string                 `json:"status"`                // "pending", "executing", "completed", "failed", "cancelled"
@@ -110,17 +135,19 @@ type AgentCommand struct {
 // AgentConfig chứa config từ server
 // Có thể là full config (configData) hoặc diff (configDiff) tùy theo backend
 type AgentConfig struct {
-	ID             string                 `json:"id,omitempty"`             // ID của config (nếu có)
-	AgentID        string                 `json:"agentId,omitempty"`        // Agent ID (nếu có)
-	Version        int64                  `json:"version"`                  // Unix timestamp (server tự động quyết định)
-	ConfigHash     string                 `json:"configHash"`               // Hash của config
-	ConfigData     map[string]interface{} `json:"configData,omitempty"`     // Full config data (nếu backend trả về full config)
-	ConfigDiff     map[string]interface{} `json:"configDiff,omitempty"`     // Config diff (nếu backend trả về diff)
-	NeedFullConfig bool                   `json:"needFullConfig,omitempty"` // true nếu server cần bot gửi full config
-	ChangeLog      string                 `json:"changeLog,omitempty"`      // Ghi chú về thay đổi
-	HasUpdate      bool                   `json:"hasUpdate"`                // Có update không
-	IsActive       bool                   `json:"isActive,omitempty"`       // Config này có active không
-	AppliedStatus  string                 `json:"appliedStatus,omitempty"`  // "pending", "applied", "failed"
+	ID             string                 `json:"id,omitempty"`               // ID của config (nếu có)
+	AgentID        string                 `json:"agentId,omitempty"`          // Agent ID (nếu có)
+	Version        int64                  `json:"version"`                    // Unix timestamp (server tự động quyết định)
+	ConfigHash     string                 `json:"configHash"`                 // Hash của config
+	ConfigData     map[string]interface{} `json:"configData,omitempty"`       // Full config data (nếu backend trả về full config)
+	ConfigDiff     map[string]interface{} `json:"configDiff,omitempty"`       // Config diff (nếu backend trả về diff)
+	ConfigMerge    map[string]interface{} `json:"configMergePatch,omitempty"` // RFC 7396 JSON Merge Patch trên toàn bộ configData
+	ConfigPatch    []interface{}          `json:"configPatch,omitempty"`      // RFC 6902 JSON Patch trên toàn bộ configData
+	NeedFullConfig bool                   `json:"needFullConfig,omitempty"`   // true nếu server cần bot gửi full config
+	ChangeLog      string                 `json:"changeLog,omitempty"`        // Ghi chú về thay đổi
+	HasUpdate      bool                   `json:"hasUpdate"`                  // Có update không
+	IsActive       bool                   `json:"isActive,omitempty"`         // Config này có active không
+	AppliedStatus  string                 `json:"appliedStatus,omitempty"`    // "pending", "applied", "failed"
 }
 
 // CollectCheckInData thu thập tất cả thông tin cho check-in
@@ -138,15 +165,21 @@ func (s *CheckInService) CollectCheckInData() (*AgentCheckInRequest, error) {
 	// Chỉ thu thập system errors (nếu có) - tạm thời để trống vì chưa có system error tracking
 	errors := []ErrorReport{}
 
+	// Kiểm tra lỗi của jobs sau config update gần nhất (có thể tự động rollback trước khi báo version cho server)
+	s.configManager.CheckConfigProbation()
+
 	// Lấy config version và hash (từ config manager)
 	configVersion, configHash := s.configManager.GetVersionAndHash()
 
 	// Tối ưu: Chỉ gửi full config khi cần thiết
-	var configData map[string]interface{}
+	var configData, configSchema map[string]interface{}
+	var jobTypes []*JobTypeInfo
 	shouldSubmit := s.configManager.ShouldSubmitFullConfig()
 	if shouldSubmit {
 		// Lần đầu hoặc config thay đổi → Gửi full config (theo API v3.14: không có metadata chung của job)
 		configData = s.configManager.CollectCurrentConfig()
+		configSchema = s.configManager.ConfigSchema()
+		jobTypes = s.configManager.JobTypes()
 		s.logger.WithField("config_size", len(configData)).Info("📤 Sẽ gửi full config trong check-in request")
 	} else {
 		s.logger.Info("📤 Chỉ gửi config version và hash (config không thay đổi hoặc đã có trên server)")
@@ -157,17 +190,26 @@ func (s *CheckInService) CollectCheckInData() (*AgentCheckInRequest, error) {
 	// Metadata có thể được set từ config hoặc default values
 	metadata := s.collectAgentMetadata()
 
+	// Đánh giá health từ job metrics, upstream, đăng nhập và system info
+	health := s.evaluateHealth(systemInfo)
+	s.logHealthChange(health)
+
 	return &AgentCheckInRequest{
 		AgentID:       global.GlobalConfig.AgentId,
 		Timestamp:     time.Now().Unix(),
 		SystemInfo:    systemInfo,
-		Status:        s.getBotStatus(),
-		HealthStatus:  s.calculateHealthStatus(),
+		Status:        s.getBotStatus(health),
+		HealthStatus:  health.Status,
+		HealthReasons: health.Reasons,
 		Metrics:       metrics,
 		JobStatus:     jobStatuses,
 		ConfigVersion: configVersion,
 		ConfigHash:    configHash,
 		ConfigData:    configData, // Chỉ có khi cần submit full config
+		ConfigSchema:  configSchema,
+		ConfigApply:   s.configManager.LastConfigApplyResult(),
+		ConfigLocks:   s.configManager.ConfigOverrides(),
+		JobTypes:      jobTypes,
 		Errors:        errors,
 		// Metadata fields (theo API v3.14)
 		DisplayName: metadata.DisplayName,
@@ -188,8 +230,10 @@ func (s *CheckInService) SendCheckIn() (*AgentCheckInResponse, error) {
 	// Gửi lên server
 	response, err := integrations.FolkForm_EnhancedCheckIn(global.GlobalConfig.AgentId, data)
 	if err != nil {
+		s.checkInFailures.Add(1)
 		return nil, err
 	}
+	s.checkInFailures.Store(0)
 
 	// Parse response theo API v3.12: {code, message, data: {commands: [], configUpdate: {}}, status}
 	// Version trong configUpdate là Unix timestamp (int64), không phải string
@@ -293,102 +337,14 @@ func (s *CheckInService) handleCheckInResponse(response *AgentCheckInResponse) {
 		s.logger.Info("ℹ️  Không có command nào từ server trong check-in response")
 	}
 
-	// Xử lý commands (có thể có nhiều commands) - theo API mới
-	if len(response.Data.Commands) > 0 {
-		for _, cmd := range response.Data.Commands {
-			// Gọi command handler để xử lý từng command
-			// Theo tài liệu: Bot nên execute commands theo thứ tự và update status qua endpoint update
-			if s.scheduler != nil {
-				// Tạo command handler với scheduler và configManager
-				commandHandler := NewCommandHandler(s.scheduler, s.configManager)
-				agentCmd := &AgentCommand{
-					ID:        cmd.ID,
-					AgentID:   cmd.AgentID,
-					Type:      cmd.Type,
-					Target:    cmd.Target,
-					Params:    cmd.Params,
-					Status:    cmd.Status, // Thường là "pending" khi nhận từ server
-					CreatedAt: cmd.CreatedAt,
-				}
-
-				// Thực thi command và báo kết quả về server
-				// Theo tài liệu: Bot update status khi execute command và trả về result/error
-				executedAt := time.Now().Unix()
-
-				// Update command status thành "executing" trước khi thực thi
-				// Endpoint: PUT /api/v1/agent-management/command/update-by-id/:id
-				s.updateCommandStatus(cmd.ID, "executing", nil, executedAt, 0)
-
-				// Thực thi command
-				err := commandHandler.ExecuteCommand(agentCmd)
-				completedAt := time.Now().Unix()
-
-				// Thu thập thông tin về job execution nếu là command run_job
-				var resultData map[string]interface{}
-				if cmd.Type == "run_job" && err == nil {
-					// Lấy job object để lấy metrics (nếu job implement MetricsProvider)
-					jobObj := s.scheduler.GetJobObject(cmd.Target)
-					if jobObj != nil {
-						// Type assertion để lấy metrics nếu job implement MetricsProvider
-						if metricsProvider, ok := jobObj.(scheduler.MetricsProvider); ok {
-							metrics := metricsProvider.GetMetrics()
-							resultData = map[string]interface{}{
-								"success":         true,
-								"type":            cmd.Type,
-								"target":          cmd.Target,
-								"jobRunCount":     metrics.RunCount,
-								"lastRunStatus":   metrics.LastRunStatus,
-								"lastRunDuration": metrics.LastRunDuration,
-								"lastRunAt":       metrics.LastRunAt.Unix(),
-							}
-							if metrics.LastError != "" {
-								resultData["lastError"] = metrics.LastError
-							}
-						} else {
-							// Job không implement MetricsProvider
-							resultData = map[string]interface{}{
-								"success": true,
-								"type":    cmd.Type,
-								"target":  cmd.Target,
-							}
-						}
-					} else {
-						resultData = map[string]interface{}{
-							"success": true,
-							"type":    cmd.Type,
-							"target":  cmd.Target,
-						}
-					}
-				} else if err == nil {
-					// Command khác (không phải run_job)
-					resultData = map[string]interface{}{
-						"success": true,
-						"type":    cmd.Type,
-						"target":  cmd.Target,
-					}
-				}
+	// Gửi lại trạng thái các command trước đó chưa gửi được (server vừa phản hồi → đang kết nối được)
+	s.retryCommandReports()
 
-				// Update command status và kết quả về server sau khi execute xong
-				if err != nil {
-					s.logger.WithFields(logrus.Fields{
-						"command_id":   cmd.ID,
-						"command_type": cmd.Type,
-						"error":        err.Error(),
-					}).Error("❌ Lỗi khi thực thi command")
-					// Update status = "failed" và gửi error message
-					s.updateCommandStatus(cmd.ID, "failed", map[string]interface{}{
-						"error": err.Error(),
-					}, executedAt, completedAt)
-				} else {
-					s.logger.WithFields(logrus.Fields{
-						"command_id":   cmd.ID,
-						"command_type": cmd.Type,
-						"target":       cmd.Target,
-					}).Info("✅ Đã thực thi command thành công")
-					// Update status = "completed" và gửi result (có thông tin về job nếu là run_job)
-					s.updateCommandStatus(cmd.ID, "completed", resultData, executedAt, completedAt)
-				}
-			}
+	// Xử lý commands (có thể có nhiều commands) - theo API mới
+	// Theo tài liệu: Bot nên execute commands theo thứ tự và update status qua endpoint update
+	if s.scheduler != nil {
+		for i := range response.Data.Commands {
+			s.processCommand(&response.Data.Commands[i])
 		}
 	}
 
@@ -401,13 +357,26 @@ func (s *CheckInService) handleCheckInResponse(response *AgentCheckInResponse) {
 			s.configManager.MarkNeedSubmitFullConfig()
 		} else if configUpdate.HasUpdate {
 			// Apply config update thông qua config manager
-			if s.configManager != nil {
+			if s.configManager != nil && s.configManager.IsRejectedConfigUpdate(configUpdate.Version, configUpdate.ConfigHash) {
+				// Update này đã bị từ chối, lý do đã gửi trong configApply → không validate/log lại
+				s.logger.WithField("version", configUpdate.Version).Debug("Bỏ qua config update đã bị từ chối trước đó")
+			} else if s.configManager != nil {
+				s.configManager.updateMu.Lock()
+				defer s.configManager.updateMu.Unlock()
+
 				var err error
+				previous := s.configManager.snapshotConfig()
 
 				// Backend có thể trả về full config (configData) hoặc diff (configDiff)
 				if configUpdate.ConfigData != nil {
 					// Backend trả về full config → replace toàn bộ
 					err = s.configManager.ApplyFullConfig(configUpdate.ConfigData, configUpdate.Version, configUpdate.ConfigHash)
+				} else if configUpdate.ConfigPatch != nil {
+					// RFC 6902 JSON Patch → apply nguyên vẹn, configHash sau patch phải khớp server
+					err = s.configManager.ApplyConfigJSONPatch(configUpdate.ConfigPatch, configUpdate.Version, configUpdate.ConfigHash)
+				} else if configUpdate.ConfigMerge != nil {
+					// RFC 7396 JSON Merge Patch (null = xóa field)
+					err = s.configManager.ApplyConfigMergePatch(configUpdate.ConfigMerge, configUpdate.Version, configUpdate.ConfigHash)
 				} else if configUpdate.ConfigDiff != nil {
 					// Backend trả về config diff → merge vào config hiện tại
 					err = s.configManager.ApplyConfigDiff(configUpdate.ConfigDiff)
@@ -417,9 +386,13 @@ func (s *CheckInService) handleCheckInResponse(response *AgentCheckInResponse) {
 					}
 				}
 
+				// Lưu kết quả để báo lại server trong lần check-in tiếp theo
+				s.configManager.RecordConfigApplyResult(configUpdate.Version, configUpdate.ConfigHash, err)
 				if err != nil {
-					s.logger.WithError(err).Error("❌ Lỗi khi apply config update")
+					s.logger.WithError(err).WithField("version", configUpdate.Version).Error("❌ Từ chối config update từ server")
 				} else {
+					// Lưu lịch sử (để rollback) và theo dõi lỗi của jobs trong thời gian thử
+					s.configManager.recordAppliedConfig(previous, "server")
 					s.logger.WithField("version", configUpdate.Version).Info("✅ Đã apply config update thành công từ server")
 					s.logger.Info("💡 Các jobs sẽ đọc config mới khi chạy lần tiếp theo")
 				}
@@ -428,16 +401,216 @@ func (s *CheckInService) handleCheckInResponse(response *AgentCheckInResponse) {
 	}
 }
 
+// processCommand thực thi một command từ server và báo trạng thái (chống thực thi trùng qua command journal)
+func (s *CheckInService) processCommand(cmd *AgentCommand) {
+	if cmd.ID == "" {
+		s.logger.WithField("command_type", cmd.Type).Warn("⚠️  Command không có ID, bỏ qua (không thể chống trùng và update status)")
+		return
+	}
+
+	// Command đã nhận trước đó (server gửi lại vì chưa nhận được trạng thái) → không thực thi lại, gửi lại trạng thái
+	if entry, exists := s.commandJournal.Get(cmd.ID); exists {
+		s.logger.WithFields(logrus.Fields{
+			"command_id":   cmd.ID,
+			"command_type": cmd.Type,
+			"status":       entry.Status,
+		}).Warn("🔁 Command đã được xử lý trước đó hoặc đang thực thi, không thực thi lại")
+		if entry.isFinal() {
+			s.commandJournal.Requeue(cmd.ID)
+			s.reportCommand(cmd.ID)
+		}
+		return
+	}
+
+	commandsConfig := s.commandsConfig()
+	if commandExpired(cmd.CreatedAt, commandsConfig.ExpirySeconds, time.Now()) {
+		now := time.Now().Unix()
+		s.logger.WithFields(logrus.Fields{
+			"command_id":   cmd.ID,
+			"command_type": cmd.Type,
+			"created_at":   cmd.CreatedAt,
+		}).Warn("⌛ Command đã hết hạn, không thực thi")
+		s.commandJournal.Begin(cmd, 0)
+		s.commandJournal.Finish(cmd.ID, commandStatusExpired, nil, fmt.Sprintf("command hết hạn (quá %d giây từ lúc tạo), không thực thi", commandsConfig.ExpirySeconds), now)
+		s.reportCommand(cmd.ID)
+		return
+	}
+
+	agentCmd := &AgentCommand{
+		ID:        cmd.ID,
+		AgentID:   cmd.AgentID,
+		Type:      cmd.Type,
+		Target:    cmd.Target,
+		Params:    cmd.Params,
+		Status:    cmd.Status, // Thường là "pending" khi nhận từ server
+		CreatedAt: cmd.CreatedAt,
+	}
+
+	// Thực thi command và báo kết quả về server
+	// Theo tài liệu: Bot update status khi execute command và trả về result/error
+	executedAt := time.Now().Unix()
+
+	// Ghi journal trước khi thực thi: server gửi lại command này thì không thực thi lần hai
+	s.commandJournal.Begin(agentCmd, executedAt)
+
+	// Update command status thành "executing" trước khi thực thi (không gửi lại nếu lỗi, trạng thái cuối mới cần chắc chắn tới server)
+	// Endpoint: PUT /api/v1/agent-management/command/update-by-id/:id
+	s.updateCommandStatus(cmd.ID, "executing", nil, executedAt, 0)
+
+	// Command chạy lâu (run_job...) → thực thi trong CommandExecutor, không chặn check-in
+	if asyncCommandTypes[agentCmd.Type] {
+		s.commandExecutor.Submit(agentCmd.ID, func() {
+			s.executeAsyncCommand(agentCmd, executedAt)
+		})
+		return
+	}
+
+	// Tạo command handler với scheduler và configManager
+	commandHandler := NewCommandHandler(s.scheduler, s.configManager)
+	err := commandHandler.ExecuteCommand(agentCmd)
+	var resultData map[string]interface{}
+	if err == nil {
+		resultData = map[string]interface{}{
+			"success": true,
+			"type":    cmd.Type,
+			"target":  cmd.Target,
+		}
+	}
+	s.finishCommand(agentCmd, resultData, err)
+}
+
+// executeAsyncCommand thực thi command chạy lâu (trong goroutine của CommandExecutor):
+// gửi progress định kỳ, áp dụng params.timeoutSeconds rồi ghi journal và báo kết quả
+func (s *CheckInService) executeAsyncCommand(cmd *AgentCommand, executedAt int64) {
+	ctx := context.Background()
+	if timeout := commandTimeout(cmd); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	progressInterval := defaultCommandProgressInterval
+	if seconds := s.commandsConfig().ProgressIntervalSeconds; seconds > 0 {
+		progressInterval = time.Duration(seconds) * time.Second
+	}
+	onProgress := func(progress map[string]interface{}) {
+		s.updateCommandStatus(cmd.ID, "executing", progress, executedAt, 0)
+	}
+
+	commandHandler := NewCommandHandler(s.scheduler, s.configManager)
+	resultData, err := commandHandler.ExecuteCommandContext(ctx, cmd, progressInterval, onProgress)
+	if err == nil && resultData == nil {
+		resultData = map[string]interface{}{
+			"success": true,
+			"type":    cmd.Type,
+			"target":  cmd.Target,
+		}
+	}
+	s.finishCommand(cmd, resultData, err)
+}
+
+// finishCommand ghi trạng thái cuối của command vào journal và báo server (lỗi → gửi lại ở lần check-in sau)
+func (s *CheckInService) finishCommand(cmd *AgentCommand, resultData map[string]interface{}, err error) {
+	completedAt := time.Now().Unix()
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"command_id":   cmd.ID,
+			"command_type": cmd.Type,
+			"error":        err.Error(),
+		}).Error("❌ Lỗi khi thực thi command")
+		// Result vẫn giữ lại (ví dụ run_job thất bại vẫn có số item đã sync và danh sách lỗi)
+		s.commandJournal.Finish(cmd.ID, commandStatusFailed, resultData, err.Error(), completedAt)
+	} else {
+		s.logger.WithFields(logrus.Fields{
+			"command_id":   cmd.ID,
+			"command_type": cmd.Type,
+			"target":       cmd.Target,
+		}).Info("✅ Đã thực thi command thành công")
+		s.commandJournal.Finish(cmd.ID, commandStatusCompleted, resultData, "", completedAt)
+	}
+
+	// Update command status và kết quả về server sau khi execute xong
+	s.reportCommand(cmd.ID)
+}
+
+// reportCommand gửi trạng thái cuối của command trong journal lên server, lỗi thì hẹn gửi lại
+func (s *CheckInService) reportCommand(commandID string) {
+	entry, exists := s.commandJournal.Get(commandID)
+	if !exists || !entry.isFinal() {
+		return
+	}
+	result := entry.Result
+	if entry.serverStatus() == commandStatusFailed {
+		result = map[string]interface{}{"error": entry.Error}
+		if len(entry.Result) > 0 {
+			result["result"] = entry.Result
+		}
+	}
+	err := s.updateCommandStatus(entry.ID, entry.serverStatus(), result, entry.ExecutedAt, entry.CompletedAt)
+	maxBackoff := time.Duration(s.commandsConfig().ReportRetryMaxSeconds) * time.Second
+	s.commandJournal.MarkReported(entry.ID, err, maxBackoff)
+}
+
+// commandsConfig trả về agent.commands (ConfigManager chưa có → không hết hạn, không giới hạn backoff)
+func (s *CheckInService) commandsConfig() CommandsConfig {
+	if s.configManager == nil {
+		return CommandsConfig{}
+	}
+	return s.configManager.TypedConfig().Commands
+}
+
+// retryCommandReports gửi lại trạng thái các command chưa gửi được và dọn journal
+func (s *CheckInService) retryCommandReports() {
+	commandsConfig := s.commandsConfig()
+	s.commandJournal.Prune(time.Duration(commandsConfig.JournalRetentionHours) * time.Hour)
+
+	pending := s.commandJournal.PendingReports(time.Now())
+	if len(pending) == 0 {
+		return
+	}
+	s.logger.WithField("count", len(pending)).Info("📤 Gửi lại trạng thái các command chưa gửi được")
+	for _, entry := range pending {
+		s.reportCommand(entry.ID)
+	}
+}
+
 // getBotStatus trả về trạng thái bot
-func (s *CheckInService) getBotStatus() string {
-	// TODO: Implement logic kiểm tra trạng thái bot
+// "maintenance" khi tất cả job đang paused/disabled, "error" khi health unhealthy, còn lại "online"
+func (s *CheckInService) getBotStatus(health HealthReport) string {
+	if s.scheduler != nil {
+		allJobs := s.scheduler.GetAllJobObjects()
+		if len(allJobs) > 0 && len(s.scheduler.GetJobs()) == 0 {
+			return "maintenance"
+		}
+	}
+	if health.Status == HealthUnhealthy {
+		return "error"
+	}
 	return "online"
 }
 
-// calculateHealthStatus tính toán health status
-func (s *CheckInService) calculateHealthStatus() string {
-	// TODO: Implement logic tính toán health status
-	return "healthy"
+// logHealthChange ghi log khi health status thay đổi so với lần check-in trước
+func (s *CheckInService) logHealthChange(health HealthReport) {
+	s.lastHealthMu.Lock()
+	previous := s.lastHealthStatus
+	s.lastHealthStatus = health.Status
+	s.lastHealthMu.Unlock()
+
+	if previous == health.Status || (previous == "" && health.Status == HealthHealthy) {
+		return
+	}
+	fields := logrus.Fields{
+		"previous": previous,
+		"status":   health.Status,
+	}
+	for i, reason := range health.Reasons {
+		fields[fmt.Sprintf("reason_%d", i+1)] = fmt.Sprintf("[%s] %s: %s", reason.Check, reason.Target, reason.Message)
+	}
+	if health.Status == HealthHealthy {
+		s.logger.WithFields(fields).Info("💚 Health status trở lại healthy")
+	} else {
+		s.logger.WithFields(fields).Warn("⚠️  Health status thay đổi")
+	}
 }
 
 // AgentMetadata chứa metadata của agent (theo API v3.14)
@@ -483,10 +656,10 @@ func (s *CheckInService) Stop() {
 // updateCommandStatus cập nhật trạng thái command lên server
 // Theo tài liệu API: PUT /api/v1/agent-management/command/update-by-id/:id
 // Bot update status khi execute command và trả về result hoặc error sau khi execute xong
-func (s *CheckInService) updateCommandStatus(commandID string, status string, result map[string]interface{}, executedAt int64, completedAt int64) {
+func (s *CheckInService) updateCommandStatus(commandID string, status string, result map[string]interface{}, executedAt int64, completedAt int64) error {
 	if commandID == "" {
 		s.logger.Warn("⚠️  Command ID rỗng, không thể update status")
-		return
+		return fmt.Errorf("command ID rỗng")
 	}
 
 	s.logger.WithFields(logrus.Fields{
@@ -520,6 +693,13 @@ func (s *CheckInService) updateCommandStatus(commandID string, status string, re
 				updateData["error"] = errorMsg
 				// Không log Debug để giảm log
 			}
+			// Kết quả một phần của lần chạy thất bại (run_job: item đã sync, lỗi)
+			if partial, ok := result["result"].(map[string]interface{}); ok {
+				updateData["result"] = partial
+			}
+		} else if status == "executing" {
+			// Command chạy lâu gửi tiến độ (percentage, message, itemsSynced, errorCount...)
+			updateData["progress"] = result
 		} else if status == "completed" {
 			// Nếu completed, lưu result (theo tài liệu: result?: Record<string, any>)
 			updateData["result"] = result
@@ -538,6 +718,7 @@ func (s *CheckInService) updateCommandStatus(commandID string, status string, re
 			"status":     status,
 			"error":      err.Error(),
 		}).Error("❌ Lỗi khi update command status")
+		return err
 	} else {
 		s.logger.WithFields(logrus.Fields{
 			"command_id": commandID,
@@ -547,4 +728,5 @@ func (s *CheckInService) updateCommandStatus(commandID string, status string, re
 			// Không log Debug để giảm log
 		}
 	}
+	return nil
 }
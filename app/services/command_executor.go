@@ -0,0 +1,74 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần thực thi command chạy lâu (run_job, sync_page, resync_*, dump_diagnostics) từ server:
+  - CommandExecutor chạy command trong goroutine riêng (tối đa agent.commands.maxConcurrent command cùng lúc,
+    command vượt giới hạn xếp hàng) → check-in không bị chặn khi job chạy lâu
+  - Trong lúc chạy, trạng thái "executing" được gửi lại kèm progress mỗi agent.commands.progressIntervalSeconds
+  - params.timeoutSeconds giới hạn thời gian đợi command, quá hạn thì job dừng giữa các page/batch và command báo failed
+  - Kết quả (item đã sync, lỗi, kết quả theo organization) lấy từ đúng lần chạy của command, không lấy từ metrics tổng của job
+*/
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// asyncCommandTypes là các loại command chạy lâu, thực thi qua CommandExecutor
+var asyncCommandTypes = map[string]bool{
+	"run_job":             true,
+	"sync_page":           true,
+	"resync_conversation": true,
+	"resync_order":        true,
+	"dump_diagnostics":    true,
+}
+
+// Giá trị mặc định khi chưa có config agent.commands
+const (
+	defaultMaxConcurrentCommands   = 2
+	defaultCommandProgressInterval = 15 * time.Second
+)
+
+// CommandProgressFunc nhận tiến độ của command đang thực thi (gửi lên server trong field progress)
+type CommandProgressFunc func(progress map[string]interface{})
+
+// CommandExecutor giới hạn số command chạy lâu thực thi đồng thời
+type CommandExecutor struct {
+	pool *boundedPool
+}
+
+// NewCommandExecutor tạo executor, maxConcurrent được đọc lại mỗi lần cần (config thay đổi có hiệu lực ngay)
+func NewCommandExecutor(maxConcurrent func() int) *CommandExecutor {
+	if maxConcurrent == nil {
+		maxConcurrent = func() int { return defaultMaxConcurrentCommands }
+	}
+	return &CommandExecutor{pool: newBoundedPool("CommandExecutor", maxConcurrent)}
+}
+
+// Submit chạy run trong goroutine riêng nếu còn slot, ngược lại xếp hàng
+// Command đang chạy hoặc đang xếp hàng không được submit lại
+func (e *CommandExecutor) Submit(id string, run func()) {
+	if !e.pool.submit(id, run) {
+		log.Printf("[CommandExecutor] ⚠️  Command %s đang chạy hoặc đang xếp hàng, bỏ qua", id)
+	}
+}
+
+// commandTimeout đọc params.timeoutSeconds của command (0 = không giới hạn)
+func commandTimeout(cmd *AgentCommand) time.Duration {
+	if seconds, ok := toFloat64(cmd.Params["timeoutSeconds"]); ok && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return 0
+}
+
+// toResultMap chuyển struct kết quả sang map để gửi trong result/progress của command
+func toResultMap(value interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return result
+	}
+	json.Unmarshal(data, &result)
+	return result
+}
@@ -1,11 +1,14 @@
 /*
 Package services chứa các services hỗ trợ cho agent.
 File này xử lý commands từ server (stop, start, restart, reload_config, run_job, etc.).
+Các command vận hành theo page/conversation/order và dump_diagnostics nằm trong command_operations.go.
 */
 package services
 
 import (
+	"agent_pancake/app/integrations"
 	"agent_pancake/app/scheduler"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -54,6 +57,16 @@ func (h *CommandHandler) ExecuteCommand(cmd *AgentCommand) error {
 		return h.handleEnableJobCommand(cmd)
 	case "update_job_schedule":
 		return h.handleUpdateJobScheduleCommand(cmd)
+	case "cancel_workflow_command":
+		return h.handleCancelWorkflowCommand(cmd)
+	case "rollback_config":
+		return h.handleRollbackConfigCommand(cmd)
+	case "reset_checkpoint":
+		return h.handleResetCheckpointCommand(cmd)
+	case "sync_page", "resync_conversation", "resync_order", "dump_diagnostics":
+		// Command chạy lâu/có result, bình thường thực thi qua CommandExecutor (ExecuteCommandContext)
+		_, err := h.ExecuteCommandContext(context.Background(), cmd, 0, nil)
+		return err
 	default:
 		log.Printf("[CommandHandler] ❌ Command type không hợp lệ: %s", cmd.Type)
 		return nil
@@ -167,38 +180,69 @@ func (h *CommandHandler) handleShutdownCommand(cmd *AgentCommand) error {
 	return nil
 }
 
-// handleRunJobCommand xử lý command run job ngay lập tức
-// Cải thiện: Chạy job sync và theo dõi trạng thái để báo lại server
+// ExecuteCommandContext thực thi command chạy lâu (asyncCommandTypes) và trả về kết quả của lần thực thi
+// ctx hết hạn khi quá params.timeoutSeconds, onProgress (có thể nil) nhận tiến độ mỗi progressInterval
+func (h *CommandHandler) ExecuteCommandContext(ctx context.Context, cmd *AgentCommand, progressInterval time.Duration, onProgress CommandProgressFunc) (map[string]interface{}, error) {
+	log.Printf("[CommandHandler] Thực thi command: %s (type: %s, target: %s)", cmd.ID, cmd.Type, cmd.Target)
+
+	switch cmd.Type {
+	case "run_job":
+		return h.runJob(ctx, cmd, progressInterval, onProgress)
+	case "sync_page":
+		return h.handleSyncPageCommand(ctx, cmd)
+	case "resync_conversation":
+		return h.handleResyncConversationCommand(ctx, cmd)
+	case "resync_order":
+		return h.handleResyncOrderCommand(ctx, cmd)
+	case "dump_diagnostics":
+		return h.handleDumpDiagnosticsCommand(cmd)
+	default:
+		return nil, h.ExecuteCommand(cmd)
+	}
+}
+
+// handleRunJobCommand xử lý command run job ngay lập tức (đợi job chạy xong, không timeout)
 func (h *CommandHandler) handleRunJobCommand(cmd *AgentCommand) error {
+	_, err := h.runJob(context.Background(), cmd, 0, nil)
+	return err
+}
+
+// runJob chạy job ngay và trả về kết quả của đúng lần chạy này (item đã sync, lỗi, kết quả theo organization)
+// Job thất bại, đang chạy sẵn hoặc quá timeout → trả về lỗi kèm result
+func (h *CommandHandler) runJob(ctx context.Context, cmd *AgentCommand, progressInterval time.Duration, onProgress CommandProgressFunc) (map[string]interface{}, error) {
 	jobName := cmd.Target
 	if jobName == "" {
-		return fmt.Errorf("tên job không được để trống")
+		return nil, fmt.Errorf("tên job không được để trống")
 	}
-	
+
 	log.Printf("[CommandHandler] ▶️  Chạy job ngay: %s", jobName)
 	if h.scheduler == nil {
-		return fmt.Errorf("scheduler không tồn tại")
+		return nil, fmt.Errorf("scheduler không tồn tại")
 	}
-	
-	// Chạy job sync để đợi kết quả và theo dõi trạng thái
-	// Lưu ý: Có thể block nếu job chạy lâu, nhưng cần để báo lại server về kết quả
-	err, result := h.scheduler.RunJobNowSync(jobName)
-	if err != nil {
+
+	var reportProgress func(scheduler.RunStatsSnapshot)
+	if onProgress != nil {
+		reportProgress = func(snapshot scheduler.RunStatsSnapshot) {
+			onProgress(toResultMap(snapshot))
+		}
+	}
+	result, err := h.scheduler.RunJobNowContext(ctx, jobName, reportProgress, progressInterval)
+	if result == nil {
 		log.Printf("[CommandHandler] ❌ Lỗi khi chạy job %s: %v", jobName, err)
-		return fmt.Errorf("lỗi khi chạy job %s: %v", jobName, err)
+		return nil, fmt.Errorf("lỗi khi chạy job %s: %v", jobName, err)
 	}
-	
-	if result != nil {
-		if result.Success {
-			log.Printf("[CommandHandler] ✅ Job %s đã hoàn thành thành công (duration: %.2fs)", jobName, result.Duration)
-		} else {
-			log.Printf("[CommandHandler] ❌ Job %s thực thi thất bại: %s (duration: %.2fs)", jobName, result.Error, result.Duration)
-		}
-	} else {
-		log.Printf("[CommandHandler] ✅ Đã khởi động job %s", jobName)
+
+	resultData := toResultMap(result)
+	resultData["success"] = result.Success
+	resultData["type"] = cmd.Type
+	resultData["target"] = cmd.Target
+	if err != nil {
+		log.Printf("[CommandHandler] ❌ Job %s thực thi thất bại: %v (duration: %.2fs)", jobName, err, result.Duration)
+		return resultData, fmt.Errorf("job %s thực thi thất bại: %v", jobName, err)
 	}
-	
-	return nil
+
+	log.Printf("[CommandHandler] ✅ Job %s đã hoàn thành thành công (duration: %.2fs, lỗi trong lúc chạy: %d)", jobName, result.Duration, result.ErrorCount)
+	return resultData, nil
 }
 
 // handlePauseJobCommand xử lý command pause job
@@ -316,3 +360,59 @@ func (h *CommandHandler) handleUpdateJobScheduleCommand(cmd *AgentCommand) error
 	log.Printf("[CommandHandler] ✅ Đã cập nhật schedule cho job %s", jobName)
 	return nil
 }
+
+// handleCancelWorkflowCommand xử lý command hủy workflow command đang chạy hoặc đang xếp hàng
+// Target là ID của workflow command (hoặc params.commandId), params.reason là lý do hủy (optional)
+func (h *CommandHandler) handleCancelWorkflowCommand(cmd *AgentCommand) error {
+	commandID := cmd.Target
+	if commandID == "" {
+		commandID, _ = cmd.Params["commandId"].(string)
+	}
+	if commandID == "" {
+		return fmt.Errorf("ID workflow command không được để trống")
+	}
+	reason, _ := cmd.Params["reason"].(string)
+	if reason == "" {
+		reason = "hủy từ server"
+	}
+
+	log.Printf("[CommandHandler] ⛔ Hủy workflow command: %s (%s)", commandID, reason)
+	found, queued := GetWorkflowWorkerPool().Cancel(commandID, reason)
+	if !found {
+		return fmt.Errorf("workflow command %s không chạy trên agent này", commandID)
+	}
+	// Command chưa chạy (đang xếp hàng) thì không có worker cập nhật trạng thái
+	if queued {
+		if _, err := integrations.FolkForm_UpdateWorkflowCommand(commandID, "cancelled", map[string]interface{}{"error": reason}); err != nil {
+			log.Printf("[CommandHandler] ⚠️  Lỗi khi update workflow command %s = cancelled: %v", commandID, err)
+		}
+	}
+	log.Printf("[CommandHandler] ✅ Đã gửi yêu cầu hủy workflow command %s", commandID)
+	return nil
+}
+
+// handleRollbackConfigCommand xử lý command rollback config về một version trong lịch sử
+// params.version là version cần quay lại (không có = version ngay trước config đang chạy), params.reason là lý do (optional)
+func (h *CommandHandler) handleRollbackConfigCommand(cmd *AgentCommand) error {
+	if h.configManager == nil {
+		return fmt.Errorf("config manager không tồn tại")
+	}
+	var version int64
+	if v, ok := toFloat64(cmd.Params["version"]); ok {
+		version = int64(v)
+	}
+	reason, _ := cmd.Params["reason"].(string)
+	if reason == "" {
+		reason = "rollback từ server"
+	}
+
+	log.Printf("[CommandHandler] ⏪ Rollback config (version: %d)...", version)
+	entry, err := h.configManager.RollbackConfig(version, reason)
+	if err != nil {
+		log.Printf("[CommandHandler] ❌ Lỗi khi rollback config: %v", err)
+		return fmt.Errorf("lỗi khi rollback config: %v", err)
+	}
+
+	log.Printf("[CommandHandler] ✅ Đã rollback config về version %d", entry.Version)
+	return nil
+}
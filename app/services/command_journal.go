@@ -0,0 +1,256 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa journal của command nhận từ server (./config/command-journal.json):
+  - Ghi command ID trước khi thực thi và kết quả sau khi thực thi → command bị server gửi lại (vì update status
+    lỗi) không bị thực thi lần hai, chỉ gửi lại trạng thái đã có
+  - Trạng thái cuối (completed/failed) được gửi lên server ít nhất một lần: gửi lỗi thì nằm trong hàng đợi
+    và gửi lại ở các lần check-in sau (backoff tăng dần, tối đa agent.commands.reportRetryMaxSeconds)
+  - Command nhận được quá agent.commands.expirySeconds sau CreatedAt thì không thực thi (expired)
+  - Command đang thực thi khi agent dừng được đánh dấu failed lúc khởi động (không tự chạy lại)
+  - Giữ entry trong agent.commands.journalRetentionHours
+*/
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Trạng thái command trong journal
+const (
+	commandStatusExecuting = "executing"
+	commandStatusCompleted = "completed"
+	commandStatusFailed    = "failed"
+	commandStatusExpired   = "expired" // Báo server là "failed" kèm lý do
+)
+
+// CommandJournalEntry là một command đã nhận và kết quả xử lý
+type CommandJournalEntry struct {
+	ID              string                 `json:"id"`
+	Type            string                 `json:"type"`
+	Target          string                 `json:"target,omitempty"`
+	Status          string                 `json:"status"` // "executing", "completed", "failed", "expired"
+	Result          map[string]interface{} `json:"result,omitempty"`
+	Error           string                 `json:"error,omitempty"`
+	ReceivedAt      int64                  `json:"receivedAt"`
+	ExecutedAt      int64                  `json:"executedAt,omitempty"`
+	CompletedAt     int64                  `json:"completedAt,omitempty"`
+	Reported        bool                   `json:"reported"` // Trạng thái cuối đã gửi lên server thành công
+	ReportAttempts  int                    `json:"reportAttempts,omitempty"`
+	NextReportAt    int64                  `json:"nextReportAt,omitempty"`
+	LastReportError string                 `json:"lastReportError,omitempty"`
+}
+
+// isFinal kiểm tra command đã xử lý xong (có trạng thái cuối để báo server)
+func (e *CommandJournalEntry) isFinal() bool {
+	return e.Status != commandStatusExecuting
+}
+
+// serverStatus là trạng thái gửi lên server (server không có "expired")
+func (e *CommandJournalEntry) serverStatus() string {
+	if e.Status == commandStatusExpired {
+		return commandStatusFailed
+	}
+	return e.Status
+}
+
+// CommandJournal lưu command đã nhận để chống thực thi trùng và gửi lại trạng thái
+type CommandJournal struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]*CommandJournalEntry
+}
+
+// NewCommandJournal đọc journal từ file (file chưa có hoặc hỏng → journal rỗng)
+func NewCommandJournal(path string) *CommandJournal {
+	j := &CommandJournal{path: path, entries: make(map[string]*CommandJournalEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return j
+	}
+	var entries []*CommandJournalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("[CommandJournal] ⚠️  File journal %s không hợp lệ, bắt đầu journal mới: %v", path, err)
+		return j
+	}
+
+	interrupted := 0
+	now := time.Now().Unix()
+	for _, entry := range entries {
+		if entry == nil || entry.ID == "" {
+			continue
+		}
+		// Agent dừng khi command đang chạy → không biết đã có tác dụng tới đâu, không tự chạy lại
+		if entry.Status == commandStatusExecuting {
+			entry.Status = commandStatusFailed
+			entry.Error = "agent dừng khi đang thực thi command, không thực thi lại"
+			entry.CompletedAt = now
+			entry.Reported = false
+			interrupted++
+		}
+		j.entries[entry.ID] = entry
+	}
+	if interrupted > 0 {
+		log.Printf("[CommandJournal] ⚠️  %d command bị gián đoạn do agent dừng, đánh dấu failed", interrupted)
+		j.save()
+	}
+	return j
+}
+
+// Get trả về bản copy của entry theo command ID
+func (j *CommandJournal) Get(id string) (CommandJournalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, exists := j.entries[id]
+	if !exists {
+		return CommandJournalEntry{}, false
+	}
+	return *entry, true
+}
+
+// Begin ghi command trước khi thực thi (lưu file ngay để agent dừng giữa chừng cũng không chạy lại)
+func (j *CommandJournal) Begin(cmd *AgentCommand, executedAt int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[cmd.ID] = &CommandJournalEntry{
+		ID:         cmd.ID,
+		Type:       cmd.Type,
+		Target:     cmd.Target,
+		Status:     commandStatusExecuting,
+		ReceivedAt: time.Now().Unix(),
+		ExecutedAt: executedAt,
+	}
+	j.save()
+}
+
+// Finish ghi trạng thái cuối của command (chưa gửi server)
+func (j *CommandJournal) Finish(id, status string, result map[string]interface{}, errMsg string, completedAt int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, exists := j.entries[id]
+	if !exists {
+		return
+	}
+	entry.Status = status
+	entry.Result = result
+	entry.Error = errMsg
+	entry.CompletedAt = completedAt
+	entry.Reported = false
+	entry.ReportAttempts = 0
+	entry.NextReportAt = 0
+	j.save()
+}
+
+// MarkReported ghi kết quả gửi trạng thái lên server, gửi lỗi thì hẹn gửi lại với backoff tăng dần
+func (j *CommandJournal) MarkReported(id string, reportErr error, maxBackoff time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, exists := j.entries[id]
+	if !exists {
+		return
+	}
+	if reportErr == nil {
+		entry.Reported = true
+		entry.LastReportError = ""
+		entry.NextReportAt = 0
+	} else {
+		entry.ReportAttempts++
+		entry.LastReportError = reportErr.Error()
+		backoff := 5 * time.Second << uint(min(entry.ReportAttempts-1, 16))
+		if maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		entry.NextReportAt = time.Now().Add(backoff).Unix()
+	}
+	j.save()
+}
+
+// Requeue đánh dấu cần gửi lại trạng thái ngay (server gửi lại command → chưa nhận được trạng thái)
+func (j *CommandJournal) Requeue(id string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if entry, exists := j.entries[id]; exists && entry.isFinal() {
+		entry.Reported = false
+		entry.NextReportAt = 0
+		j.save()
+	}
+}
+
+// PendingReports trả về các command có trạng thái cuối chưa gửi được và đã tới lúc gửi lại (cũ trước)
+func (j *CommandJournal) PendingReports(now time.Time) []CommandJournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	pending := []CommandJournalEntry{}
+	for _, entry := range j.entries {
+		if entry.isFinal() && !entry.Reported && entry.NextReportAt <= now.Unix() {
+			pending = append(pending, *entry)
+		}
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].ReceivedAt < pending[b].ReceivedAt })
+	return pending
+}
+
+// Prune xóa entry nhận quá thời gian giữ (entry chưa gửi được trạng thái cũng bị xóa, kèm cảnh báo)
+func (j *CommandJournal) Prune(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	cutoff := time.Now().Add(-retention).Unix()
+	removed := 0
+	for id, entry := range j.entries {
+		if entry.ReceivedAt >= cutoff || !entry.isFinal() {
+			continue
+		}
+		if !entry.Reported {
+			log.Printf("[CommandJournal] ⚠️  Bỏ command %s (%s): quá hạn giữ journal nhưng chưa gửi được trạng thái: %s", id, entry.Type, entry.LastReportError)
+		}
+		delete(j.entries, id)
+		removed++
+	}
+	if removed > 0 {
+		j.save()
+	}
+}
+
+// save ghi journal ra file (ghi file tạm rồi rename để không hỏng file khi agent dừng giữa chừng)
+// Gọi khi đang giữ j.mu
+func (j *CommandJournal) save() {
+	entries := make([]*CommandJournalEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].ReceivedAt < entries[b].ReceivedAt })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		log.Printf("[CommandJournal] ❌ Lỗi khi encode journal: %v", err)
+		return
+	}
+	os.MkdirAll(filepath.Dir(j.path), 0755)
+	tmpPath := j.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Printf("[CommandJournal] ❌ Lỗi khi lưu journal: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		log.Printf("[CommandJournal] ❌ Lỗi khi lưu journal: %v", err)
+	}
+}
+
+// commandExpired kiểm tra command đã quá hạn thực thi (createdAt là Unix giây hoặc mili giây)
+func commandExpired(createdAt int64, expirySeconds int, now time.Time) bool {
+	if createdAt <= 0 || expirySeconds <= 0 {
+		return false
+	}
+	created := time.Unix(createdAt, 0)
+	if createdAt > 1e12 {
+		created = time.UnixMilli(createdAt)
+	}
+	return now.Sub(created) > time.Duration(expirySeconds)*time.Second
+}
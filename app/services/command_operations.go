@@ -0,0 +1,242 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa các command vận hành nhắm vào một đối tượng cụ thể:
+  - sync_page: chạy incremental/backfill cho một page (target = pageId, params.mode = "incremental" | "backfill")
+  - resync_conversation: sync lại một conversation và toàn bộ messages (target = conversationId, params.pageId)
+  - resync_order: sync lại một order Pancake POS (target = orderId, params.shopId)
+  - reset_checkpoint: đặt lại mốc sync incremental của page (target = pageId, params.kind = "conversations" | "posts",
+    không có = cả hai; params.since = Unix giây/mili giây hoặc params.hoursAgo, mặc định 24 giờ trước)
+  - dump_diagnostics: goroutine dump, thống kê rate limiter, config, lỗi gần đây (gửi lên server trong result)
+
+sync_page, resync_conversation và resync_order đọc/ghi dữ liệu FolkForm của một organization: params.roleId chọn organization
+(phải thuộc AGENT_ROLE_IDS), bắt buộc khi agent phục vụ nhiều organization.
+*/
+package services
+
+import (
+	"agent_pancake/app/integrations"
+	apputility "agent_pancake/app/utility"
+	"agent_pancake/utility/secrets"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// defaultMaxGoroutineDumpBytes giới hạn kích thước goroutine dump trong result của dump_diagnostics
+const defaultMaxGoroutineDumpBytes = 256 * 1024
+
+// withTimeoutResult đánh dấu timedOut khi ctx của command hết hạn (params.timeoutSeconds)
+// Các hàm sync dừng giữa các page/batch khi ctx hết hạn và chỉ trả về sau khi đã dừng, nên command kết thúc
+// cùng lúc với lần sync: chạy lại command không tạo lần sync song song
+func withTimeoutResult(ctx context.Context, name string, result map[string]interface{}, err error) (map[string]interface{}, error) {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return result, err
+	}
+	if result == nil {
+		result = make(map[string]interface{})
+	}
+	result["timedOut"] = true
+	return result, fmt.Errorf("%s chạy quá thời gian cho phép, đã dừng giữa chừng: %w", name, context.Cause(ctx))
+}
+
+// stringParam đọc params[key] dạng string (số được chuyển thành chuỗi, ví dụ orderId/shopId)
+func stringParam(params map[string]interface{}, key string) string {
+	switch v := params[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// organizationContext trả về ctx mang organization của params.roleId (role phải thuộc các role agent phục vụ)
+// Không có params.roleId: chỉ hợp lệ khi agent phục vụ một organization
+func organizationContext(ctx context.Context, cmd *AgentCommand) (context.Context, string, error) {
+	roleIds, err := integrations.FolkForm_ResolveRoleIds()
+	if err != nil {
+		return nil, "", fmt.Errorf("lỗi khi lấy danh sách organization của agent: %w", err)
+	}
+	roleId := stringParam(cmd.Params, "roleId")
+	if roleId == "" {
+		if len(roleIds) > 1 {
+			return nil, "", fmt.Errorf("agent phục vụ %d organization, cần params.roleId để chọn organization", len(roleIds))
+		}
+		if len(roleIds) == 1 {
+			roleId = roleIds[0]
+		}
+		return integrations.FolkForm_WithRoleId(ctx, roleId), roleId, nil
+	}
+	for _, id := range roleIds {
+		if id == roleId {
+			return integrations.FolkForm_WithRoleId(ctx, roleId), roleId, nil
+		}
+	}
+	return nil, "", fmt.Errorf("params.roleId %s không thuộc các organization agent phục vụ (AGENT_ROLE_IDS)", roleId)
+}
+
+// handleSyncPageCommand chạy sync conversations + posts cho một page
+func (h *CommandHandler) handleSyncPageCommand(ctx context.Context, cmd *AgentCommand) (map[string]interface{}, error) {
+	pageId := cmd.Target
+	if pageId == "" {
+		pageId = stringParam(cmd.Params, "pageId")
+	}
+	mode := stringParam(cmd.Params, "mode")
+	orgCtx, roleId, err := organizationContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	pageSize, postPageSize := 50, 30
+	if h.configManager != nil {
+		pageSize = h.configManager.GetJobConfigInt("sync-incremental-conversations-job", "pageSize", 50)
+		postPageSize = h.configManager.GetJobConfigInt("sync-incremental-posts-job", "pageSize", 30)
+	}
+
+	log.Printf("[CommandHandler] 📄 Sync page %s (mode: %s, role: %s)", pageId, mode, roleId)
+	result, err := integrations.BridgeV2_SyncPage(orgCtx, pageId, mode, pageSize, postPageSize, nil)
+	return withTimeoutResult(ctx, "sync_page", result, err)
+}
+
+// handleResyncConversationCommand sync lại một conversation và toàn bộ messages
+func (h *CommandHandler) handleResyncConversationCommand(ctx context.Context, cmd *AgentCommand) (map[string]interface{}, error) {
+	conversationId := cmd.Target
+	if conversationId == "" {
+		conversationId = stringParam(cmd.Params, "conversationId")
+	}
+	pageId := stringParam(cmd.Params, "pageId")
+	maxBatches := 0
+	if v, ok := toFloat64(cmd.Params["maxBatches"]); ok {
+		maxBatches = int(v)
+	}
+	orgCtx, roleId, err := organizationContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[CommandHandler] 💬 Sync lại conversation %s (page %s, role: %s)", conversationId, pageId, roleId)
+	result, err := integrations.BridgeV2_ResyncConversation(orgCtx, pageId, conversationId, maxBatches)
+	return withTimeoutResult(ctx, "resync_conversation", result, err)
+}
+
+// handleResyncOrderCommand sync lại một order Pancake POS
+func (h *CommandHandler) handleResyncOrderCommand(ctx context.Context, cmd *AgentCommand) (map[string]interface{}, error) {
+	orderId := cmd.Target
+	if orderId == "" {
+		orderId = stringParam(cmd.Params, "orderId")
+	}
+	shopId, err := strconv.Atoi(stringParam(cmd.Params, "shopId"))
+	if err != nil {
+		return nil, fmt.Errorf("params.shopId không hợp lệ: %v", cmd.Params["shopId"])
+	}
+	orgCtx, roleId, err := organizationContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[CommandHandler] 🧾 Sync lại order %s (shop %d, role: %s)", orderId, shopId, roleId)
+	result, err := integrations.BridgeV2_ResyncOrder(orgCtx, shopId, orderId)
+	return withTimeoutResult(ctx, "resync_order", result, err)
+}
+
+// handleResetCheckpointCommand đặt lại mốc sync incremental của page
+func (h *CommandHandler) handleResetCheckpointCommand(cmd *AgentCommand) error {
+	pageId := cmd.Target
+	if pageId == "" {
+		pageId = stringParam(cmd.Params, "pageId")
+	}
+
+	since := time.Now().Add(-24 * time.Hour).Unix()
+	if v, ok := toFloat64(cmd.Params["since"]); ok && v > 0 {
+		since = int64(v)
+		if since > 1e12 {
+			since /= 1000 // Mili giây
+		}
+	} else if hours, ok := toFloat64(cmd.Params["hoursAgo"]); ok && hours > 0 {
+		since = time.Now().Add(-time.Duration(hours * float64(time.Hour))).Unix()
+	}
+
+	kinds := []string{integrations.SyncCheckpointConversations, integrations.SyncCheckpointPosts}
+	if kind := stringParam(cmd.Params, "kind"); kind != "" && kind != "all" {
+		kinds = []string{kind}
+	}
+	for _, kind := range kinds {
+		if err := integrations.BridgeV2_ResetCheckpoint(kind, pageId, since); err != nil {
+			return fmt.Errorf("lỗi khi đặt lại mốc sync: %v", err)
+		}
+	}
+
+	log.Printf("[CommandHandler] ✅ Đã đặt lại mốc sync %v của page %s, áp dụng từ lần sync incremental tiếp theo", kinds, pageId)
+	return nil
+}
+
+// handleDumpDiagnosticsCommand thu thập thông tin chẩn đoán (secret đã đăng ký được che)
+func (h *CommandHandler) handleDumpDiagnosticsCommand(cmd *AgentCommand) (map[string]interface{}, error) {
+	maxDumpBytes := defaultMaxGoroutineDumpBytes
+	if v, ok := toFloat64(cmd.Params["maxGoroutineDumpBytes"]); ok && v > 0 {
+		maxDumpBytes = int(v)
+	}
+
+	var dump bytes.Buffer
+	if profile := pprof.Lookup("goroutine"); profile != nil {
+		profile.WriteTo(&dump, 1)
+	}
+	goroutineDump := secrets.Redact(dump.String())
+	truncated := len(goroutineDump) > maxDumpBytes
+	if truncated {
+		goroutineDump = goroutineDump[:maxDumpBytes]
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	diagnostics := map[string]interface{}{
+		"collectedAt":            time.Now().Unix(),
+		"goroutines":             runtime.NumGoroutine(),
+		"goroutineDump":          goroutineDump,
+		"goroutineDumpTruncated": truncated,
+		"memory": map[string]interface{}{
+			"allocBytes": memStats.Alloc,
+			"sysBytes":   memStats.Sys,
+			"heapInuse":  memStats.HeapInuse,
+			"numGC":      memStats.NumGC,
+		},
+		"rateLimiters": map[string]interface{}{
+			"pancake":  apputility.GetPancakeRateLimiter().GetStats(),
+			"folkform": apputility.GetFolkFormRateLimiter().GetStats(),
+		},
+		"workflowWorkers": GetWorkflowWorkerPool().Metrics(),
+		"syncCheckpoints": integrations.SyncCheckpoints(),
+	}
+	if h.scheduler != nil {
+		diagnostics["recentErrors"] = NewMetricsCollector(h.scheduler).CollectErrors()
+	}
+	if h.configManager != nil {
+		version, hash := h.configManager.GetVersionAndHash()
+		diagnostics["configVersion"] = version
+		diagnostics["configHash"] = hash
+		diagnostics["config"] = redactedValue(h.configManager.runtimeConfig())
+	}
+
+	log.Printf("[CommandHandler] 🩺 Đã thu thập diagnostics (%d goroutines, dump %d bytes)", runtime.NumGoroutine(), len(goroutineDump))
+	return diagnostics, nil
+}
+
+// redactedValue che các secret đã đăng ký trong value (qua JSON) trước khi gửi lên server
+func redactedValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var redacted interface{}
+	if err := json.Unmarshal([]byte(secrets.Redact(string(data))), &redacted); err != nil {
+		return nil
+	}
+	return redacted
+}
@@ -0,0 +1,177 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần tạo job động từ config của server:
+  - Package jobs đăng ký các loại job có thể tạo thêm instance (JobTemplate) qua SetJobTemplates
+  - Job trong config có field "type" (tên template) là job động, applyConfig tạo instance với tên, schedule và params riêng
+    Ví dụ: {"name": "sync-incremental-conversations-vip-job", "type": "sync-incremental-conversations-job",
+    "schedule": "0,20,40 * * * * *", "params": {"pageIds": ["123", "456"]}}
+  - Đổi type/params → tạo lại instance, enabled=false → gỡ khỏi scheduler,
+    job bị xóa khỏi config (deletedJobs hoặc full config không còn job) → gỡ instance
+  - Field config của instance (pageSize, timeout...) đọc theo tên instance, mặc định theo job template
+  - Job đăng ký sẵn trong main.go không thể bị thay bằng job động cùng tên
+*/
+package services
+
+import (
+	"agent_pancake/app/scheduler"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// JobTemplate là một loại job mà server có thể tạo thêm instance từ config
+type JobTemplate struct {
+	Description     string
+	DefaultSchedule string                 // Dùng khi config của instance không có schedule
+	ParamsSchema    map[string]interface{} // JSON Schema của params
+	New             func(name, schedule string, params map[string]interface{}) scheduler.Job
+}
+
+// JobTypeInfo là thông tin một loại job gửi lên server (server UI dùng để tạo job instance)
+type JobTypeInfo struct {
+	Type            string                 `json:"type"`
+	Description     string                 `json:"description"`
+	DefaultSchedule string                 `json:"defaultSchedule"`
+	ParamsSchema    map[string]interface{} `json:"paramsSchema,omitempty"`
+}
+
+// dynamicJob là job instance đã tạo từ template
+type dynamicJob struct {
+	jobType    string
+	paramsHash string // Hash của type + params, đổi thì tạo lại instance
+}
+
+// SetJobTemplates đăng ký các loại job có thể tạo động (gọi trong main trước khi load config)
+func (cm *ConfigManager) SetJobTemplates(templates map[string]*JobTemplate) {
+	cm.jobTemplates = templates
+}
+
+// JobTypes trả về danh sách loại job có thể tạo động (sắp xếp theo tên)
+func (cm *ConfigManager) JobTypes() []*JobTypeInfo {
+	types := make([]*JobTypeInfo, 0, len(cm.jobTemplates))
+	for jobType, template := range cm.jobTemplates {
+		types = append(types, &JobTypeInfo{
+			Type:            jobType,
+			Description:     template.Description,
+			DefaultSchedule: template.DefaultSchedule,
+			ParamsSchema:    template.ParamsSchema,
+		})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Type < types[j].Type })
+	return types
+}
+
+// jobTemplateName trả về tên job template của job động (job thường → chính tên job)
+func (cm *ConfigManager) jobTemplateName(jobName string) string {
+	if job, ok := cm.dynamicJobs[jobName]; ok {
+		return job.jobType
+	}
+	return jobName
+}
+
+// isStaticJob kiểm tra job được đăng ký sẵn trong code (không phải job động)
+func (cm *ConfigManager) isStaticJob(jobName string) bool {
+	if cm.scheduler == nil {
+		return false
+	}
+	if _, dynamic := cm.dynamicJobs[jobName]; dynamic {
+		return false
+	}
+	return cm.scheduler.GetJobObject(jobName) != nil
+}
+
+// validateDynamicJobs kiểm tra các job động trong config (đã bỏ metadata): type đã đăng ký, không trùng job có sẵn, params đúng schema
+func (cm *ConfigManager) validateDynamicJobs(jobs map[string]interface{}) []string {
+	errs := []string{}
+	for jobName, raw := range jobs {
+		values, _ := raw.(map[string]interface{})
+		jobType, hasType := values["type"]
+		if !hasType {
+			continue
+		}
+		typeName, _ := jobType.(string)
+		template, exists := cm.jobTemplates[typeName]
+		if !exists {
+			errs = append(errs, fmt.Sprintf("jobs.%s.type: loại job %q chưa được đăng ký", jobName, typeName))
+			continue
+		}
+		if cm.isStaticJob(jobName) {
+			errs = append(errs, fmt.Sprintf("jobs.%s: trùng tên job có sẵn, không thể tạo job động", jobName))
+		}
+		if template.ParamsSchema != nil {
+			params, _ := values["params"].(map[string]interface{})
+			if params == nil {
+				params = map[string]interface{}{}
+			}
+			for _, err := range validateJSONSchema(template.ParamsSchema, params) {
+				errs = append(errs, fmt.Sprintf("jobs.%s.params: %s", jobName, err))
+			}
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// syncDynamicJobs tạo/tạo lại/gỡ các job động theo config (gọi trong applyConfig, trước khi apply enabled/schedule)
+func (cm *ConfigManager) syncDynamicJobs(jobsConfig map[string]map[string]interface{}) {
+	if cm.scheduler == nil {
+		return
+	}
+	if cm.dynamicJobs == nil {
+		cm.dynamicJobs = make(map[string]*dynamicJob)
+	}
+
+	// Gỡ job động không còn trong config (hoặc không còn type)
+	for jobName := range cm.dynamicJobs {
+		jobConfig, exists := jobsConfig[jobName]
+		if exists && cm.extractValue(jobConfig["type"]) != nil {
+			continue
+		}
+		log.Printf("[ConfigManager] 🗑️  Gỡ job động: %s", jobName)
+		cm.scheduler.RemoveJob(jobName)
+		delete(cm.dynamicJobs, jobName)
+	}
+
+	for jobName, jobConfigRaw := range jobsConfig {
+		jobConfig, _ := cm.extractValue(jobConfigRaw).(map[string]interface{})
+		jobType, _ := jobConfig["type"].(string)
+		if jobType == "" {
+			continue
+		}
+		template, exists := cm.jobTemplates[jobType]
+		if !exists {
+			log.Printf("[ConfigManager] ⚠️  Job %s có loại %q chưa được đăng ký, bỏ qua", jobName, jobType)
+			continue
+		}
+		if cm.isStaticJob(jobName) {
+			log.Printf("[ConfigManager] ⚠️  Job %s trùng tên job có sẵn, không tạo job động", jobName)
+			continue
+		}
+		if enabled, ok := jobConfig["enabled"].(bool); ok && !enabled {
+			continue // applyConfig gỡ job khỏi scheduler
+		}
+
+		params, _ := jobConfig["params"].(map[string]interface{})
+		paramsHash := canonicalHash(map[string]interface{}{"type": jobType, "params": params})
+		current, tracked := cm.dynamicJobs[jobName]
+		if tracked && cm.scheduler.GetJobObject(jobName) != nil {
+			if current.paramsHash == paramsHash {
+				continue
+			}
+			log.Printf("[ConfigManager] 🔁 Tạo lại job động %s (type/params thay đổi)", jobName)
+			cm.scheduler.RemoveJob(jobName)
+		}
+
+		schedule, _ := jobConfig["schedule"].(string)
+		if schedule == "" {
+			schedule = template.DefaultSchedule
+		}
+		if err := cm.scheduler.AddJobObject(template.New(jobName, schedule, params)); err != nil {
+			log.Printf("[ConfigManager] ❌ Lỗi khi tạo job động %s (%s): %v", jobName, jobType, err)
+			delete(cm.dynamicJobs, jobName)
+			continue
+		}
+		cm.dynamicJobs[jobName] = &dynamicJob{jobType: jobType, paramsHash: paramsHash}
+		log.Printf("[ConfigManager] ➕ Đã tạo job động %s (type: %s, schedule: %s)", jobName, jobType, schedule)
+	}
+}
@@ -0,0 +1,329 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa lịch sử config và rollback:
+  - Mỗi lần apply config update từ server (hoặc rollback), config được lưu thành một file trong ./config/history
+    kèm version, hash, nguồn và changelog (các field thay đổi so với config trước đó)
+  - Chỉ giữ agent.configHistory.maxVersions bản gần nhất
+  - Rollback về version trước bằng command "rollback_config" hoặc tự động khi tỉ lệ lỗi của jobs tăng vọt
+    trong thời gian thử (probation) sau khi apply
+  - Version bị rollback được đánh dấu rolled_back để không apply lại khi server gửi lại cùng update
+*/
+package services
+
+import (
+	"agent_pancake/app/scheduler"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ConfigHistoryEntry là một version config đã apply (lưu trong ./config/history)
+type ConfigHistoryEntry struct {
+	Version    int64                  `json:"version"`
+	ConfigHash string                 `json:"configHash"`
+	AppliedAt  int64                  `json:"appliedAt"` // Unix nano, dùng làm thứ tự và tên file
+	Source     string                 `json:"source"`    // "local", "server", "rollback", "local_file"
+	Changelog  []string               `json:"changelog,omitempty"`
+	ConfigData map[string]interface{} `json:"configData"`
+}
+
+// configSnapshot là config đang chạy tại một thời điểm (trước khi apply update)
+type configSnapshot struct {
+	version    int64
+	configHash string
+	configData map[string]interface{}
+}
+
+// configProbation theo dõi lỗi của jobs sau khi apply config update
+type configProbation struct {
+	version    int64
+	configHash string
+	until      time.Time
+	baseline   map[string]scheduler.JobMetrics // Metrics của jobs lúc apply
+}
+
+// maxConfigChangelogLines giới hạn số dòng changelog của một version
+const maxConfigChangelogLines = 50
+
+// historyDir trả về thư mục lưu lịch sử config (cùng thư mục với file config local)
+func (cm *ConfigManager) historyDir() string {
+	return filepath.Join(filepath.Dir(cm.localConfigPath), "history")
+}
+
+// snapshotConfig lấy config đang chạy (gọi trước khi apply update để ghi lịch sử)
+func (cm *ConfigManager) snapshotConfig() configSnapshot {
+	return configSnapshot{version: cm.currentVersion, configHash: cm.currentHash, configData: cm.configData}
+}
+
+// recordAppliedConfig ghi config vừa apply vào lịch sử và bắt đầu thời gian thử (probation)
+// previous là config trước khi apply, được ghi vào lịch sử nếu lịch sử đang trống (để luôn có bản để rollback)
+func (cm *ConfigManager) recordAppliedConfig(previous configSnapshot, source string) {
+	history, _ := cm.ConfigHistory()
+	if len(history) == 0 && len(previous.configData) > 0 {
+		if err := cm.saveConfigHistoryEntry(&ConfigHistoryEntry{
+			Version:    previous.version,
+			ConfigHash: previous.configHash,
+			AppliedAt:  time.Now().UnixNano() - 1,
+			Source:     "local",
+			ConfigData: previous.configData,
+		}); err != nil {
+			log.Printf("[ConfigManager] ⚠️  Lỗi khi lưu lịch sử config: %v", err)
+		}
+	}
+
+	entry := &ConfigHistoryEntry{
+		Version:    cm.currentVersion,
+		ConfigHash: cm.currentHash,
+		AppliedAt:  time.Now().UnixNano(),
+		Source:     source,
+		Changelog:  diffConfigValues("", cm.normalizeConfigValues(previous.configData), cm.normalizeConfigValues(cm.configData)),
+		ConfigData: cm.configData,
+	}
+	if err := cm.saveConfigHistoryEntry(entry); err != nil {
+		log.Printf("[ConfigManager] ⚠️  Lỗi khi lưu lịch sử config: %v", err)
+	}
+	cm.pruneConfigHistory()
+
+	if source == "server" {
+		cm.startConfigProbation()
+	}
+}
+
+// saveConfigHistoryEntry lưu một version config vào thư mục lịch sử
+func (cm *ConfigManager) saveConfigHistoryEntry(entry *ConfigHistoryEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := cm.historyDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fmt.Sprintf("config-%d.json", entry.AppliedAt)), data, 0644)
+}
+
+// ConfigHistory trả về các version config đã lưu, mới nhất trước
+func (cm *ConfigManager) ConfigHistory() ([]*ConfigHistoryEntry, error) {
+	files, err := filepath.Glob(filepath.Join(cm.historyDir(), "config-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	history := make([]*ConfigHistoryEntry, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var entry ConfigHistoryEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.ConfigData == nil {
+			log.Printf("[ConfigManager] ⚠️  Bỏ qua file lịch sử config không hợp lệ: %s", file)
+			continue
+		}
+		history = append(history, &entry)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].AppliedAt > history[j].AppliedAt })
+	return history, nil
+}
+
+// pruneConfigHistory xóa các version cũ, chỉ giữ agent.configHistory.maxVersions bản gần nhất
+func (cm *ConfigManager) pruneConfigHistory() {
+	maxVersions := cm.TypedConfig().ConfigHistory.MaxVersions
+	if maxVersions <= 0 {
+		return
+	}
+	history, err := cm.ConfigHistory()
+	if err != nil || len(history) <= maxVersions {
+		return
+	}
+	for _, entry := range history[maxVersions:] {
+		os.Remove(filepath.Join(cm.historyDir(), fmt.Sprintf("config-%d.json", entry.AppliedAt)))
+	}
+}
+
+// RollbackConfig quay lại một version config trong lịch sử
+// version = 0 → version gần nhất khác config đang chạy
+// Version đang chạy bị đánh dấu rolled_back (server gửi lại cùng update sẽ bị bỏ qua) và server được báo bằng full config
+func (cm *ConfigManager) RollbackConfig(version int64, reason string) (*ConfigHistoryEntry, error) {
+	cm.updateMu.Lock()
+	defer cm.updateMu.Unlock()
+
+	history, err := cm.ConfigHistory()
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi đọc lịch sử config: %w", err)
+	}
+
+	var target *ConfigHistoryEntry
+	for _, entry := range history {
+		if version != 0 && entry.Version == version {
+			target = entry
+			break
+		}
+		if version == 0 && entry.ConfigHash != cm.currentHash {
+			target = entry
+			break
+		}
+	}
+	if target == nil {
+		if version != 0 {
+			return nil, fmt.Errorf("không tìm thấy config version %d trong lịch sử", version)
+		}
+		return nil, fmt.Errorf("không có version config nào trước đó để rollback")
+	}
+	if err := cm.ValidateConfig(target.ConfigData); err != nil {
+		return nil, fmt.Errorf("config version %d trong lịch sử không hợp lệ: %w", target.Version, err)
+	}
+
+	previous := cm.snapshotConfig()
+	cm.configData = target.ConfigData
+	cm.currentVersion = target.Version
+	cm.currentHash = target.ConfigHash
+	cm.applyConfig()
+	if err := cm.SaveLocalConfig(); err != nil {
+		log.Printf("[ConfigManager] Warning: Failed to save local config after rollback: %v", err)
+	}
+
+	cm.probation = nil
+	cm.lastApplyResult = &ConfigApplyResult{
+		Version:       previous.version,
+		ConfigHash:    previous.configHash,
+		AppliedStatus: "rolled_back",
+		Reason:        reason,
+		AppliedAt:     time.Now().Unix(),
+	}
+	cm.recordAppliedConfig(previous, "rollback")
+	cm.MarkNeedSubmitFullConfig()
+
+	log.Printf("[ConfigManager] ⏪ Đã rollback config từ version %d về version %d (%s)", previous.version, target.Version, reason)
+	return target, nil
+}
+
+// startConfigProbation bắt đầu theo dõi lỗi của jobs sau khi apply config update từ server
+func (cm *ConfigManager) startConfigProbation() {
+	settings := cm.TypedConfig().ConfigHistory
+	if !settings.AutoRollback || settings.ProbationMinutes <= 0 {
+		cm.probation = nil
+		return
+	}
+	cm.probation = &configProbation{
+		version:    cm.currentVersion,
+		configHash: cm.currentHash,
+		until:      time.Now().Add(time.Duration(settings.ProbationMinutes) * time.Minute),
+		baseline:   cm.collectJobMetrics(),
+	}
+}
+
+// CheckConfigProbation kiểm tra tỉ lệ lỗi của jobs trong thời gian thử sau khi apply config (gọi mỗi lần check-in)
+// Tỉ lệ lỗi vượt ngưỡng (và đủ số lần chạy tối thiểu) → tự động rollback về version trước
+func (cm *ConfigManager) CheckConfigProbation() {
+	probation := cm.probation
+	if probation == nil {
+		return
+	}
+	// Config đã thay đổi (update mới hoặc rollback) → probation cũ không còn ý nghĩa
+	if probation.configHash != cm.currentHash {
+		cm.probation = nil
+		return
+	}
+
+	var runs, errs int64
+	for jobName, metrics := range cm.collectJobMetrics() {
+		base := probation.baseline[jobName]
+		runs += metrics.RunCount - base.RunCount
+		errs += metrics.ErrorCount - base.ErrorCount
+	}
+
+	settings := cm.TypedConfig().ConfigHistory
+	if runs > 0 && runs >= int64(settings.MinProbationRuns) {
+		errorRate := float64(errs) / float64(runs)
+		if errorRate >= settings.ErrorRateThreshold {
+			reason := fmt.Sprintf("tỉ lệ lỗi của jobs %.0f%% (%d/%d lần chạy) sau khi apply config version %d", errorRate*100, errs, runs, probation.version)
+			log.Printf("[ConfigManager] 🚨 %s, tự động rollback", reason)
+			if _, err := cm.RollbackConfig(0, reason); err != nil {
+				log.Printf("[ConfigManager] ❌ Lỗi khi tự động rollback config: %v", err)
+				cm.probation = nil
+			}
+			return
+		}
+	}
+
+	if time.Now().After(probation.until) {
+		log.Printf("[ConfigManager] ✅ Config version %d qua thời gian thử (%d/%d lần chạy lỗi)", probation.version, errs, runs)
+		cm.probation = nil
+	}
+}
+
+// collectJobMetrics lấy metrics hiện tại của các job (job implement MetricsProvider)
+func (cm *ConfigManager) collectJobMetrics() map[string]scheduler.JobMetrics {
+	result := make(map[string]scheduler.JobMetrics)
+	if cm.scheduler == nil {
+		return result
+	}
+	for jobName, job := range cm.scheduler.GetAllJobObjects() {
+		if provider, ok := job.(scheduler.MetricsProvider); ok {
+			result[jobName] = provider.GetMetrics()
+		}
+	}
+	return result
+}
+
+// diffConfigValues liệt kê các field thay đổi giữa hai config (đã qua normalizeConfigValues), dạng "path: cũ → mới"
+func diffConfigValues(path string, oldValue, newValue interface{}) []string {
+	changes := []string{}
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make(map[string]bool)
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		sortedKeys := make([]string, 0, len(keys))
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+		for _, key := range sortedKeys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			changes = append(changes, diffConfigValues(childPath, oldMap[key], newMap[key])...)
+			if len(changes) > maxConfigChangelogLines {
+				return append(changes[:maxConfigChangelogLines], "...")
+			}
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(normalizeConfigNumber(oldValue), normalizeConfigNumber(newValue)) {
+		return changes
+	}
+	return append(changes, fmt.Sprintf("%s: %s → %s", path, formatConfigValue(oldValue), formatConfigValue(newValue)))
+}
+
+// normalizeConfigNumber đưa số về float64 để so sánh (config đọc từ file là float64, từ code là int)
+func normalizeConfigNumber(value interface{}) interface{} {
+	if number, ok := toFloat64(value); ok {
+		return number
+	}
+	return value
+}
+
+// formatConfigValue hiển thị giá trị trong changelog
+func formatConfigValue(value interface{}) string {
+	if value == nil {
+		return "(không có)"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return truncateString(string(data), 100)
+}
@@ -8,8 +8,6 @@ import (
 	"agent_pancake/app/integrations"
 	"agent_pancake/app/scheduler"
 	"agent_pancake/global"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -27,9 +25,17 @@ type ConfigManager struct {
 	currentHash          string
 	configData           map[string]interface{}
 	scheduler            *scheduler.Scheduler
-	needSubmitFullConfig bool       // Flag: Server yêu cầu gửi full config
-	submitMutex          sync.Mutex // Mutex để tránh submit config trùng lặp
-	isSubmitting         bool       // Flag: Đang trong quá trình submit
+	needSubmitFullConfig bool                    // Flag: Server yêu cầu gửi full config
+	submitMutex          sync.Mutex              // Mutex để tránh submit config trùng lặp
+	isSubmitting         bool                    // Flag: Đang trong quá trình submit
+	typedConfig          *TypedAgentConfig       // Config có kiểu, build lại mỗi lần apply config
+	lastApplyResult      *ConfigApplyResult      // Kết quả apply config update gần nhất từ server
+	probation            *configProbation        // Theo dõi lỗi của jobs sau khi apply config update (nil = không theo dõi)
+	updateMu             sync.Mutex              // Tuần tự hóa các lần thay config (update từ server, rollback, hot reload file)
+	overrides            []*ConfigOverride       // Field bị ghi đè bởi ENV/CLI (đọc một lần khi khởi động)
+	effectiveConfig      map[string]interface{}  // configData + overrides, là config jobs thực sự dùng
+	jobTemplates         map[string]*JobTemplate // Loại job server có thể tạo thêm instance (đăng ký bởi package jobs)
+	dynamicJobs          map[string]*dynamicJob  // Job instance đã tạo từ template theo config
 }
 
 // ========================================
@@ -76,12 +82,15 @@ func GetGlobalConfigManager() *ConfigManager {
 
 // NewConfigManager tạo một instance mới của ConfigManager
 func NewConfigManager(s *scheduler.Scheduler) *ConfigManager {
-	return &ConfigManager{
+	cm := &ConfigManager{
 		localConfigPath:      "./config/agent-config.json",
 		configData:           make(map[string]interface{}),
 		scheduler:            s,
 		needSubmitFullConfig: false,
 	}
+	// Override từ ENV/CLI (cần scheduler đã đăng ký jobs để khớp tên field)
+	cm.initConfigOverrides()
+	return cm
 }
 
 // LoadLocalConfig đọc config từ file local (ưu tiên khi khởi động)
@@ -129,6 +138,11 @@ func (cm *ConfigManager) LoadLocalConfig() error {
 		return fmt.Errorf("invalid local config: missing configData")
 	}
 
+	// Config local không hợp lệ vẫn dùng (để bot chạy được khi server offline), chỉ log cảnh báo
+	if err := cm.ValidateConfig(localConfig.ConfigData); err != nil {
+		log.Printf("[ConfigManager] ⚠️  Local config có giá trị không hợp lệ: %v", err)
+	}
+
 	cm.currentVersion = version
 	cm.currentHash = localConfig.ConfigHash
 	cm.configData = localConfig.ConfigData
@@ -150,62 +164,7 @@ func (cm *ConfigManager) InitializeDefaultConfig() error {
 	cm.configData = make(map[string]interface{})
 
 	// Agent-level default config - với metadata đầy đủ
-	// Lưu ý: Chỉ giữ lại các config thực sự được sử dụng và hợp logic cho agent-level
-	agentConfig := make(map[string]interface{})
-
-	// Check-In Config (HOẠT ĐỘNG - được dùng trong main.go và checkin_service.go)
-	checkInConfig := make(map[string]interface{})
-	checkInConfig["interval"] = cm.createConfigField(
-		60,
-		"interval",
-		"Khoảng thời gian giữa các lần check-in với server (giây). Giảm giá trị để monitoring realtime hơn nhưng tốn tài nguyên hơn.",
-	)
-	checkInConfig["enabled"] = cm.createConfigField(
-		true,
-		"enabled",
-		"Bật/tắt check-in service. Nếu tắt, server sẽ không nhận được thông tin trạng thái của bot.",
-	)
-	checkInConfig["systemMetricsCacheInterval"] = cm.createConfigField(
-		300,
-		"systemMetricsCacheInterval",
-		"Khoảng thời gian cache system metrics (CPU, Memory, Disk) - giây. Giảm tải hệ thống bằng cách không thu thập metrics mỗi check-in.",
-	)
-	agentConfig["checkIn"] = checkInConfig
-
-	// Health Check Config (Đề xuất: Config cho health status calculation)
-	healthCheckConfig := make(map[string]interface{})
-	healthCheckConfig["cpuThreshold"] = cm.createConfigField(
-		90.0,
-		"cpuThreshold",
-		"Ngưỡng CPU usage (%) để đánh giá health. Nếu CPU > threshold → 'degraded' hoặc 'unhealthy'.",
-	)
-	healthCheckConfig["memoryThreshold"] = cm.createConfigField(
-		90.0,
-		"memoryThreshold",
-		"Ngưỡng Memory usage (%) để đánh giá health. Nếu Memory > threshold → 'degraded' hoặc 'unhealthy'.",
-	)
-	healthCheckConfig["diskThreshold"] = cm.createConfigField(
-		90.0,
-		"diskThreshold",
-		"Ngưỡng Disk usage (%) để đánh giá health. Nếu Disk > threshold → 'degraded' hoặc 'unhealthy'.",
-	)
-	agentConfig["healthCheck"] = healthCheckConfig
-
-	// Error Reporting Config (Đề xuất: Config cho error reporting trong check-in)
-	errorReportingConfig := make(map[string]interface{})
-	errorReportingConfig["maxErrorsPerCheckIn"] = cm.createConfigField(
-		10,
-		"maxErrorsPerCheckIn",
-		"Số lượng errors tối đa được gửi trong mỗi check-in. Giảm để tránh payload quá lớn.",
-	)
-	errorReportingConfig["errorRetentionHours"] = cm.createConfigField(
-		24,
-		"errorRetentionHours",
-		"Thời gian giữ lại errors để báo cáo (giờ). Chỉ báo cáo errors xảy ra trong khoảng thời gian này.",
-	)
-	agentConfig["errorReporting"] = errorReportingConfig
-
-	cm.configData["agent"] = agentConfig
+	cm.configData["agent"] = cm.createAgentConfigWithMetadata()
 
 	// Job-level default config (từ scheduler) - với metadata đầy đủ
 	// QUAN TRỌNG: Theo API v3.14, jobs phải là array, không phải object
@@ -353,54 +312,76 @@ func (cm *ConfigManager) ApplyConfigDiff(configDiff map[string]interface{}) erro
 		}
 	}
 
+	// Merge vào bản copy của config hiện tại, chỉ thay config khi bản merge hợp lệ
+	merged := make(map[string]interface{})
+	data, _ := json.Marshal(cm.configData)
+	json.Unmarshal(data, &merged)
+
 	// Deep merge config diff vào config hiện tại
 	// Agent-level config diff
 	if agentDiff, ok := configDiff["agent"].(map[string]interface{}); ok {
 		// Không log merge config để giảm log
-		if agentConfig, ok := cm.configData["agent"].(map[string]interface{}); ok {
+		if agentConfig, ok := merged["agent"].(map[string]interface{}); ok {
 			cm.mergeMap(agentConfig, agentDiff)
 		} else {
-			cm.configData["agent"] = agentDiff
+			merged["agent"] = agentDiff
 		}
 	}
 
-	// Job-level config diff
-	updatedJobs := []string{}
+	// Job-level config diff (jobs hiện tại có thể là array theo API v3.14 hoặc object)
 	if jobsDiff, ok := configDiff["jobs"].(map[string]interface{}); ok {
 		// Không log merge jobs config để giảm log
-		if jobsConfig, ok := cm.configData["jobs"].(map[string]interface{}); ok {
-			// Merge từng job config
-			for jobName, jobDiffRaw := range jobsDiff {
-				if jobDiff, ok := jobDiffRaw.(map[string]interface{}); ok {
-					if jobConfig, ok := jobsConfig[jobName].(map[string]interface{}); ok {
-						cm.mergeMap(jobConfig, jobDiff)
-						updatedJobs = append(updatedJobs, jobName)
-					} else {
-						// Job mới → tạo config mới
-						jobsConfig[jobName] = jobDiff
-						updatedJobs = append(updatedJobs, jobName)
-					}
-				}
+		jobsConfig := jobConfigsByName(merged["jobs"])
+		jobsArray, isArray := merged["jobs"].([]interface{})
+		jobsMap, isMap := merged["jobs"].(map[string]interface{})
+		if !isArray && !isMap {
+			jobsMap = make(map[string]interface{})
+			merged["jobs"] = jobsMap
+			isMap = true
+		}
+		// Merge từng job config
+		for jobName, jobDiffRaw := range jobsDiff {
+			jobDiff, ok := jobDiffRaw.(map[string]interface{})
+			if !ok {
+				continue
 			}
-		} else {
-			cm.configData["jobs"] = jobsDiff
+			if jobConfig, exists := jobsConfig[jobName]; exists {
+				cm.mergeMap(jobConfig, jobDiff)
+			} else if isMap {
+				// Job mới → tạo config mới
+				jobsMap[jobName] = jobDiff
+			} else {
+				jobDiff["name"] = jobName
+				jobsArray = append(jobsArray, jobDiff)
+			}
+		}
+		if isArray {
+			merged["jobs"] = jobsArray
 		}
 	}
 
 	// Xóa jobs bị disable
+	deletedJobNames := []string{}
 	if deletedJobs, ok := configDiff["deletedJobs"].([]interface{}); ok {
 		// Không log disable jobs để giảm log
-		if jobsConfig, ok := cm.configData["jobs"].(map[string]interface{}); ok {
-			for _, jobNameRaw := range deletedJobs {
-				if jobName, ok := jobNameRaw.(string); ok {
-					delete(jobsConfig, jobName)
-					// Disable job trong scheduler
-					if cm.scheduler != nil {
-						cm.scheduler.RemoveJob(jobName)
-					}
-				}
+		for _, jobNameRaw := range deletedJobs {
+			if jobName, ok := jobNameRaw.(string); ok {
+				deletedJobNames = append(deletedJobNames, jobName)
 			}
 		}
+		merged["jobs"] = removeJobConfigs(merged["jobs"], deletedJobNames)
+	}
+
+	if err := cm.ValidateConfig(merged); err != nil {
+		return err
+	}
+	cm.configData = merged
+
+	// Disable jobs bị xóa trong scheduler
+	if cm.scheduler != nil {
+		for _, jobName := range deletedJobNames {
+			cm.scheduler.RemoveJob(jobName)
+		}
 	}
 
 	// Tính lại hash sau khi merge
@@ -431,7 +412,10 @@ func (cm *ConfigManager) ApplyFullConfig(configData map[string]interface{}, vers
 
 	// Không log nhận full config để giảm log
 
-	// Không đếm số jobs để giảm log
+	// Config không hợp lệ → từ chối toàn bộ, giữ nguyên config đang chạy
+	if err := cm.ValidateConfig(configData); err != nil {
+		return err
+	}
 
 	// Replace toàn bộ config
 	cm.configData = configData
@@ -451,9 +435,40 @@ func (cm *ConfigManager) ApplyFullConfig(configData map[string]interface{}, vers
 	return nil
 }
 
+// removeJobConfigs xóa config của các job theo tên (jobs có thể là array hoặc object)
+func removeJobConfigs(jobs interface{}, jobNames []string) interface{} {
+	deleted := make(map[string]bool, len(jobNames))
+	for _, jobName := range jobNames {
+		deleted[jobName] = true
+	}
+	switch list := jobs.(type) {
+	case map[string]interface{}:
+		for jobName := range deleted {
+			delete(list, jobName)
+		}
+	case []interface{}:
+		kept := make([]interface{}, 0, len(list))
+		for _, raw := range list {
+			if jobConfig, ok := raw.(map[string]interface{}); ok {
+				if jobName, _ := jobConfig["name"].(string); deleted[jobName] {
+					continue
+				}
+			}
+			kept = append(kept, raw)
+		}
+		return kept
+	}
+	return jobs
+}
+
 // mergeMap merge map2 vào map1 (deep merge)
+// Giá trị null trong map2 xóa field khỏi map1 (giống RFC 7396 JSON Merge Patch)
 func (cm *ConfigManager) mergeMap(map1, map2 map[string]interface{}) {
 	for key, value2 := range map2 {
+		if value2 == nil {
+			delete(map1, key)
+			continue
+		}
 		if value1, exists := map1[key]; exists {
 			// Nếu cả 2 đều là map → merge recursive
 			if map1Value, ok1 := value1.(map[string]interface{}); ok1 {
@@ -607,6 +622,8 @@ func (cm *ConfigManager) CollectCurrentConfig() map[string]interface{} {
 	config := cm.collectCurrentConfig()
 	// Cleanup metadata chung của job trước khi submit (theo API v3.14)
 	cm.cleanupJobMetadata(config)
+	// Đánh dấu field bị ghi đè bởi ENV/CLI (server UI không cho sửa)
+	markLockedConfigFields(config, cm.overrides)
 	return config
 }
 
@@ -623,64 +640,11 @@ func (cm *ConfigManager) collectCurrentConfig() map[string]interface{} {
 	config := make(map[string]interface{})
 
 	// Agent-level config - với metadata đầy đủ
-	// Lưu ý: Chỉ giữ lại các config thực sự được sử dụng và hợp logic cho agent-level
-	agentConfig := make(map[string]interface{})
+	agentConfig := cm.createAgentConfigWithMetadata()
 
 	// Mô tả tổng quan về agent
 	agentConfig["description"] = "Cấu hình chung cho FolkForm Agent. Agent này quản lý việc đồng bộ dữ liệu giữa Pancake và FolkForm, bao gồm conversations, posts, customers, và Pancake POS data. Tất cả các jobs được quản lý và lập lịch tự động."
 
-	// Check-In Config (HOẠT ĐỘNG - được dùng trong main.go và checkin_service.go)
-	checkInConfig := make(map[string]interface{})
-	checkInConfig["interval"] = cm.createConfigField(
-		60,
-		"interval",
-		"Khoảng thời gian giữa các lần check-in với server (giây). Giảm giá trị để monitoring realtime hơn nhưng tốn tài nguyên hơn.",
-	)
-	checkInConfig["enabled"] = cm.createConfigField(
-		true,
-		"enabled",
-		"Bật/tắt check-in service. Nếu tắt, server sẽ không nhận được thông tin trạng thái của bot.",
-	)
-	checkInConfig["systemMetricsCacheInterval"] = cm.createConfigField(
-		300,
-		"systemMetricsCacheInterval",
-		"Khoảng thời gian cache system metrics (CPU, Memory, Disk) - giây. Giảm tải hệ thống bằng cách không thu thập metrics mỗi check-in.",
-	)
-	agentConfig["checkIn"] = checkInConfig
-
-	// Health Check Config (Đề xuất: Config cho health status calculation)
-	healthCheckConfig := make(map[string]interface{})
-	healthCheckConfig["cpuThreshold"] = cm.createConfigField(
-		90.0,
-		"cpuThreshold",
-		"Ngưỡng CPU usage (%) để đánh giá health. Nếu CPU > threshold → 'degraded' hoặc 'unhealthy'.",
-	)
-	healthCheckConfig["memoryThreshold"] = cm.createConfigField(
-		90.0,
-		"memoryThreshold",
-		"Ngưỡng Memory usage (%) để đánh giá health. Nếu Memory > threshold → 'degraded' hoặc 'unhealthy'.",
-	)
-	healthCheckConfig["diskThreshold"] = cm.createConfigField(
-		90.0,
-		"diskThreshold",
-		"Ngưỡng Disk usage (%) để đánh giá health. Nếu Disk > threshold → 'degraded' hoặc 'unhealthy'.",
-	)
-	agentConfig["healthCheck"] = healthCheckConfig
-
-	// Error Reporting Config (Đề xuất: Config cho error reporting trong check-in)
-	errorReportingConfig := make(map[string]interface{})
-	errorReportingConfig["maxErrorsPerCheckIn"] = cm.createConfigField(
-		10,
-		"maxErrorsPerCheckIn",
-		"Số lượng errors tối đa được gửi trong mỗi check-in. Giảm để tránh payload quá lớn.",
-	)
-	errorReportingConfig["errorRetentionHours"] = cm.createConfigField(
-		24,
-		"errorRetentionHours",
-		"Thời gian giữ lại errors để báo cáo (giờ). Chỉ báo cáo errors xảy ra trong khoảng thời gian này.",
-	)
-	agentConfig["errorReporting"] = errorReportingConfig
-
 	config["agent"] = agentConfig
 
 	// Job-level config (từ scheduler) - với metadata đầy đủ
@@ -756,11 +720,10 @@ func (cm *ConfigManager) mergeWithRuntime(configData map[string]interface{}) map
 	// Merge jobs từ scheduler với jobs từ configData
 	jobsConfig := make(map[string]interface{})
 
-	// Copy jobs từ configData nếu có
-	if existingJobs, ok := merged["jobs"].(map[string]interface{}); ok {
-		// Deep copy existing jobs
-		existingJobsData, _ := json.Marshal(existingJobs)
-		json.Unmarshal(existingJobsData, &jobsConfig)
+	// Copy jobs từ configData nếu có (merged đã là bản deep copy, jobs có thể là array hoặc object)
+	// Giữ cả job không có trong scheduler (job động đang tắt, type/params của job động)
+	for jobName, jobConfig := range jobConfigsByName(merged["jobs"]) {
+		jobsConfig[jobName] = jobConfig
 	}
 
 	// Thêm/update jobs từ scheduler (runtime) - đảm bảo có đầy đủ config cho tất cả jobs
@@ -831,23 +794,25 @@ func (cm *ConfigManager) mergeWithRuntime(configData map[string]interface{}) map
 	return merged
 }
 
-// calculateHash tính SHA256 hash của config
+// calculateHash tính SHA256 hash của config theo canonical JSON (RFC 8785, xem config_patch.go)
+// để hash không phụ thuộc cách serialize của agent/server
 func (cm *ConfigManager) calculateHash(configData map[string]interface{}) string {
-	data, _ := json.Marshal(configData)
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
+	return canonicalHash(configData)
 }
 
 // applyConfig áp dụng config vào runtime
-// Priority: ENV > Config từ server > Local config > Default
+// Priority: ENV/CLI (config_overrides.go) > Config từ server > Local config > Default
 // Lưu ý: configData có thể có metadata inline (field.value) hoặc giá trị trực tiếp
 func (cm *ConfigManager) applyConfig() {
 	if cm.configData == nil {
 		return
 	}
 
+	// Config jobs thực sự dùng = configData + override ENV/CLI
+	cm.effectiveConfig = applyConfigOverrides(cm.configData, cm.overrides)
+
 	// Extract giá trị từ config (có thể có metadata inline)
-	agentConfig := cm.extractValue(cm.configData["agent"])
+	agentConfig := cm.extractValue(cm.effectiveConfig["agent"])
 	if agentConfigMap, ok := agentConfig.(map[string]interface{}); ok {
 		// Apply job execution config (dùng chung)
 		jobExecConfig := cm.extractValue(agentConfigMap["jobExecution"])
@@ -856,9 +821,14 @@ func (cm *ConfigManager) applyConfig() {
 		}
 	}
 
-	// Apply job-level config
-	jobsConfig := cm.extractValue(cm.configData["jobs"])
-	if jobsConfigMap, ok := jobsConfig.(map[string]interface{}); ok {
+	// Config có kiểu (đọc bởi GetCheckInInterval, TypedConfig)
+	cm.typedConfig = cm.buildTypedConfig(cm.effectiveConfig)
+
+	// Apply job-level config (jobs có thể là array theo API v3.14 hoặc object)
+	jobsConfigMap := jobConfigsByName(cm.effectiveConfig["jobs"])
+	// Tạo/gỡ job động (job có "type") trước để bước dưới apply enabled/schedule như job thường
+	cm.syncDynamicJobs(jobsConfigMap)
+	if len(jobsConfigMap) > 0 {
 		appliedCount := 0
 		for jobName, jobConfigRaw := range jobsConfigMap {
 			jobConfig := cm.extractValue(jobConfigRaw)
@@ -975,19 +945,24 @@ func (cm *ConfigManager) GetJobConfig(jobName string) map[string]interface{} {
 		return nil
 	}
 
-	jobsConfig := cm.extractValue(cm.configData["jobs"])
-	if jobsConfigMap, ok := jobsConfig.(map[string]interface{}); ok {
-		if jobConfigRaw, exists := jobsConfigMap[jobName]; exists {
-			jobConfig := cm.extractValue(jobConfigRaw)
-			if jobConfigMap, ok := jobConfig.(map[string]interface{}); ok {
-				return jobConfigMap
-			}
+	if jobConfigRaw, exists := jobConfigsByName(cm.runtimeConfig()["jobs"])[jobName]; exists {
+		jobConfig := cm.extractValue(jobConfigRaw)
+		if jobConfigMap, ok := jobConfig.(map[string]interface{}); ok {
+			return jobConfigMap
 		}
 	}
 
 	return nil
 }
 
+// runtimeConfig trả về config jobs thực sự dùng (đã ghi đè ENV/CLI)
+func (cm *ConfigManager) runtimeConfig() map[string]interface{} {
+	if cm.effectiveConfig != nil {
+		return cm.effectiveConfig
+	}
+	return applyConfigOverrides(cm.configData, cm.overrides)
+}
+
 // GetJobConfigValue lấy giá trị config cho một field cụ thể của job
 // Hàm này tìm field trong config của job và trả về giá trị (đã extract từ metadata nếu có)
 // Tham số:
@@ -1102,12 +1077,8 @@ func (cm *ConfigManager) GetCheckInInterval() int {
 		return 60 // Default 60 giây
 	}
 
-	if agentConfig, ok := cm.configData["agent"].(map[string]interface{}); ok {
-		if checkInConfig, ok := agentConfig["checkIn"].(map[string]interface{}); ok {
-			if interval, ok := checkInConfig["interval"].(float64); ok {
-				return int(interval)
-			}
-		}
+	if interval := cm.TypedConfig().CheckIn.Interval; interval > 0 {
+		return interval
 	}
 
 	return 60 // Default
@@ -1161,13 +1132,178 @@ func (cm *ConfigManager) getFieldType(value interface{}) string {
 	}
 }
 
+// createAgentConfigWithMetadata tạo agent-level config với metadata đầy đủ (dùng cho default config và JSON Schema)
+// Lưu ý: Chỉ giữ lại các config thực sự được sử dụng và hợp logic cho agent-level
+func (cm *ConfigManager) createAgentConfigWithMetadata() map[string]interface{} {
+	agentConfig := make(map[string]interface{})
+
+	// Check-In Config (HOẠT ĐỘNG - được dùng trong main.go và checkin_service.go)
+	checkInConfig := make(map[string]interface{})
+	checkInConfig["interval"] = cm.createConfigField(
+		60,
+		"interval",
+		"Khoảng thời gian giữa các lần check-in với server (giây). Giảm giá trị để monitoring realtime hơn nhưng tốn tài nguyên hơn.",
+	)
+	checkInConfig["enabled"] = cm.createConfigField(
+		true,
+		"enabled",
+		"Bật/tắt check-in service. Nếu tắt, server sẽ không nhận được thông tin trạng thái của bot.",
+	)
+	checkInConfig["systemMetricsCacheInterval"] = cm.createConfigField(
+		300,
+		"systemMetricsCacheInterval",
+		"Khoảng thời gian cache system metrics (CPU, Memory, Disk) - giây. Giảm tải hệ thống bằng cách không thu thập metrics mỗi check-in.",
+	)
+	agentConfig["checkIn"] = checkInConfig
+
+	// Health Check Config (Đề xuất: Config cho health status calculation)
+	healthCheckConfig := make(map[string]interface{})
+	healthCheckConfig["cpuThreshold"] = cm.createConfigField(
+		90.0,
+		"cpuThreshold",
+		"Ngưỡng CPU usage (%) để đánh giá health. Nếu CPU > threshold → 'degraded' hoặc 'unhealthy'.",
+	)
+	healthCheckConfig["memoryThreshold"] = cm.createConfigField(
+		90.0,
+		"memoryThreshold",
+		"Ngưỡng Memory usage (%) để đánh giá health. Nếu Memory > threshold → 'degraded' hoặc 'unhealthy'.",
+	)
+	healthCheckConfig["diskThreshold"] = cm.createConfigField(
+		90.0,
+		"diskThreshold",
+		"Ngưỡng Disk usage (%) để đánh giá health. Nếu Disk > threshold → 'degraded' hoặc 'unhealthy'.",
+	)
+	healthCheckConfig["jobFailureThreshold"] = cm.createConfigField(
+		3,
+		"jobFailureThreshold",
+		"Số lần chạy thất bại liên tiếp của một job để health chuyển sang 'degraded'.",
+	)
+	healthCheckConfig["jobFailureCriticalThreshold"] = cm.createConfigField(
+		10,
+		"jobFailureCriticalThreshold",
+		"Số lần chạy thất bại liên tiếp của một job để health chuyển sang 'unhealthy'.",
+	)
+	healthCheckConfig["staleIntervalMultiplier"] = cm.createConfigField(
+		3.0,
+		"staleIntervalMultiplier",
+		"Job không chạy thành công trong khoảng (hệ số × chu kỳ lịch chạy) được coi là stale → 'degraded'.",
+	)
+	healthCheckConfig["slowDurationRatio"] = cm.createConfigField(
+		1.0,
+		"slowDurationRatio",
+		"Nếu p95 duration của job > (tỉ lệ × chu kỳ lịch chạy) → 'degraded' (job chạy không kịp lịch).",
+	)
+	healthCheckConfig["checkInFailureThreshold"] = cm.createConfigField(
+		3,
+		"checkInFailureThreshold",
+		"Số lần check-in lên server thất bại liên tiếp để coi là mất kết nối upstream → 'unhealthy'.",
+	)
+	healthCheckConfig["logDiskMinFreeMB"] = cm.createConfigField(
+		500,
+		"logDiskMinFreeMB",
+		"Dung lượng trống tối thiểu (MB) của ổ đĩa chứa thư mục log. Thấp hơn → 'unhealthy'.",
+	)
+	agentConfig["healthCheck"] = healthCheckConfig
+
+	// Error Reporting Config (Đề xuất: Config cho error reporting trong check-in)
+	errorReportingConfig := make(map[string]interface{})
+	errorReportingConfig["maxErrorsPerCheckIn"] = cm.createConfigField(
+		10,
+		"maxErrorsPerCheckIn",
+		"Số lượng errors tối đa được gửi trong mỗi check-in. Giảm để tránh payload quá lớn.",
+	)
+	errorReportingConfig["errorRetentionHours"] = cm.createConfigField(
+		24,
+		"errorRetentionHours",
+		"Thời gian giữ lại errors để báo cáo (giờ). Chỉ báo cáo errors xảy ra trong khoảng thời gian này.",
+	)
+	agentConfig["errorReporting"] = errorReportingConfig
+
+	// Config History (lịch sử config và rollback tự động)
+	configHistoryConfig := make(map[string]interface{})
+	configHistoryConfig["maxVersions"] = cm.createConfigField(
+		10,
+		"maxVersions",
+		"Số version config gần nhất được lưu trong ./config/history để rollback (command rollback_config).",
+	)
+	configHistoryConfig["autoRollback"] = cm.createConfigField(
+		true,
+		"autoRollback",
+		"Tự động rollback về version trước nếu tỉ lệ lỗi của jobs vượt ngưỡng trong thời gian thử sau khi apply config từ server.",
+	)
+	configHistoryConfig["probationMinutes"] = cm.createConfigField(
+		15,
+		"probationMinutes",
+		"Thời gian thử sau khi apply config từ server (phút). 0 = không theo dõi.",
+	)
+	configHistoryConfig["errorRateThreshold"] = cm.createConfigField(
+		0.5,
+		"errorRateThreshold",
+		"Ngưỡng tỉ lệ lỗi của jobs (0-1) trong thời gian thử. Vượt ngưỡng → tự động rollback.",
+	)
+	configHistoryConfig["minProbationRuns"] = cm.createConfigField(
+		3,
+		"minProbationRuns",
+		"Số lần chạy jobs tối thiểu trong thời gian thử trước khi đánh giá tỉ lệ lỗi (tránh rollback vì một lần lỗi lẻ).",
+	)
+	agentConfig["configHistory"] = configHistoryConfig
+
+	// Config Watch (hot reload file config local)
+	configWatchConfig := make(map[string]interface{})
+	configWatchConfig["enabled"] = cm.createConfigField(
+		true,
+		"enabled",
+		"Tự động reload khi file config local (agent-config.json, log filter) thay đổi trên đĩa.",
+	)
+	configWatchConfig["intervalSeconds"] = cm.createConfigField(
+		5,
+		"intervalSeconds",
+		"Chu kỳ kiểm tra thay đổi của file config local (giây). Thay đổi có hiệu lực sau khi khởi động lại agent.",
+	)
+	agentConfig["configWatch"] = configWatchConfig
+
+	// Commands (journal chống thực thi trùng command từ server)
+	commandsConfig := make(map[string]interface{})
+	commandsConfig["expirySeconds"] = cm.createConfigField(
+		900,
+		"expirySeconds",
+		"Command nhận được sau khi tạo quá số giây này sẽ không thực thi (báo failed: hết hạn). 0 = không hết hạn.",
+	)
+	commandsConfig["journalRetentionHours"] = cm.createConfigField(
+		72,
+		"journalRetentionHours",
+		"Thời gian giữ command đã xử lý trong journal local (giờ) để bỏ qua command bị gửi lại.",
+	)
+	commandsConfig["reportRetryMaxSeconds"] = cm.createConfigField(
+		300,
+		"reportRetryMaxSeconds",
+		"Khoảng chờ tối đa giữa các lần gửi lại trạng thái command lên server khi gửi lỗi (giây).",
+	)
+	commandsConfig["maxConcurrent"] = cm.createConfigField(
+		2,
+		"maxConcurrent",
+		"Số command chạy lâu (run_job) thực thi đồng thời, command vượt giới hạn xếp hàng chờ.",
+	)
+	commandsConfig["progressIntervalSeconds"] = cm.createConfigField(
+		15,
+		"progressIntervalSeconds",
+		"Chu kỳ gửi tiến độ (status executing kèm progress) của command đang thực thi lên server (giây).",
+	)
+	agentConfig["commands"] = commandsConfig
+
+	return agentConfig
+}
+
 // createJobConfigWithMetadata tạo config cho một job với metadata đầy đủ
 // Mỗi job sẽ có các config cụ thể tùy theo loại job
 func (cm *ConfigManager) createJobConfigWithMetadata(jobName string) map[string]interface{} {
 	jobConfig := make(map[string]interface{})
 
+	// Job động (config_dynamic_jobs.go) dùng mô tả và field của job template
+	templateName := cm.jobTemplateName(jobName)
+
 	// Mô tả tổng quan về job (giúp user hiểu job này làm gì)
-	jobDescription := cm.getJobDescription(jobName)
+	jobDescription := cm.getJobDescription(templateName)
 	if jobDescription != "" {
 		jobConfig["description"] = jobDescription
 	}
@@ -1192,7 +1328,7 @@ func (cm *ConfigManager) createJobConfigWithMetadata(jobName string) map[string]
 	}
 
 	// Config cụ thể cho từng loại job
-	switch jobName {
+	switch templateName {
 	// ========================================
 	// CONVERSATIONS JOBS
 	// ========================================
@@ -1556,6 +1692,71 @@ func (cm *ConfigManager) createJobConfigWithMetadata(jobName string) map[string]
 			"Số lượng conversations được kiểm tra mỗi lần.",
 		)
 
+	// ========================================
+	// AI WORKFLOW JOBS
+	// ========================================
+	case "workflow-commands-job":
+		jobConfig["claimLimit"] = cm.createConfigField(
+			5,
+			"claimLimit",
+			"Số lượng workflow commands tối đa được claim mỗi lần.",
+		)
+		jobConfig["maxConcurrentWorkflows"] = cm.createConfigField(
+			3,
+			"maxConcurrentWorkflows",
+			"Số workflow command chạy đồng thời tối đa. Job chỉ claim thêm khi còn slot trống (tối đa claimLimit mỗi lần).",
+		)
+		jobConfig["providerConcurrency"] = cm.createConfigField(
+			map[string]interface{}{},
+			"providerConcurrency",
+			"Số AI call đồng thời tối đa theo provider. Key là loại provider hoặc ID provider profile (ưu tiên), ví dụ: {\"openai\": 4, \"anthropic\": 2}. Call vượt giới hạn sẽ đợi slot.",
+		)
+		jobConfig["maxParallelSteps"] = cm.createConfigField(
+			4,
+			"maxParallelSteps",
+			"Số step (kể cả các nhánh fan-out) chạy song song tối đa trong một workflow run có khai báo dependsOn/forEach.",
+		)
+		jobConfig["heartbeatInterval"] = cm.createConfigField(
+			45,
+			"heartbeatInterval",
+			"Khoảng thời gian giữa các lần gửi heartbeat của command đang chạy (giây).",
+		)
+		jobConfig["progressInterval"] = cm.createConfigField(
+			5,
+			"progressInterval",
+			"Khoảng thời gian tối thiểu giữa các lần gửi tiến độ khi AI đang sinh response (giây).",
+		)
+		jobConfig["maxRunCost"] = cm.createConfigField(
+			0.0,
+			"maxRunCost",
+			"Ngân sách AI tối đa cho một workflow run (USD). Vượt ngân sách thì dừng run. 0 = không giới hạn. Command có thể ghi đè bằng params.maxCost.",
+		)
+		jobConfig["schemaRepairAttempts"] = cm.createConfigField(
+			2,
+			"schemaRepairAttempts",
+			"Số lần tối đa gửi lại AI để sửa output không khớp outputSchema của step (kèm danh sách lỗi validate). 0 = không sửa, step thất bại ngay.",
+		)
+		jobConfig["aiPricing"] = cm.createConfigField(
+			map[string]interface{}{},
+			"aiPricing",
+			"Bảng giá AI (USD cho 1 triệu token), ghi đè bảng giá mặc định. Key là 'provider/model' hoặc 'provider/*', ví dụ: {\"openai/gpt-4o\": {\"input\": 2.5, \"output\": 10, \"cachedInput\": 1.25}}; cacheWrite là giá ghi prompt cache (mặc định 1.25 × input).",
+		)
+		jobConfig["aiCacheEnabled"] = cm.createConfigField(
+			false,
+			"aiCacheEnabled",
+			"Bật cache response AI trên đĩa (AGENT_AI_CACHE_DIR). Cùng prompt và tham số thì dùng lại response, không gọi provider. Command có thể bỏ qua bằng params.aiCache = \"refresh\" hoặc \"off\".",
+		)
+		jobConfig["aiCacheTTLHours"] = cm.createConfigField(
+			24,
+			"aiCacheTTLHours",
+			"Thời gian sống của một entry cache response AI (giờ). 0 = không hết hạn.",
+		)
+		jobConfig["aiCacheMaxSizeMB"] = cm.createConfigField(
+			200,
+			"aiCacheMaxSizeMB",
+			"Dung lượng tối đa của cache response AI (MB). Vượt thì xóa các entry cũ nhất. 0 = không giới hạn.",
+		)
+
 	default:
 		// Config mặc định cho các job khác
 		jobConfig["timeout"] = cm.createConfigField(
@@ -1641,6 +1842,12 @@ func (cm *ConfigManager) getJobDescription(jobName string) string {
 	case "sync-warn-unreplied-conversations-job":
 		return "Cảnh báo các hội thoại chưa được trả lời trong vòng 5-300 phút. Job này chạy mỗi 1 phút và tự động kiểm tra khung giờ làm việc (8h30-22h30). Chỉ gửi cảnh báo trong giờ làm việc và có rate limit 5 phút cho mỗi conversation để tránh spam."
 
+	// ========================================
+	// AI WORKFLOW JOBS
+	// ========================================
+	case "workflow-commands-job":
+		return "Nhận và thực thi các workflow commands (AI) từ server. Job claim command đang chờ, chạy từng step của workflow (gọi AI provider theo chuỗi fallback), gửi heartbeat/tiến độ và tính chi phí AI theo bảng giá, dừng run khi vượt ngân sách."
+
 	default:
 		return "" // Không có mô tả cho job không xác định
 	}
@@ -0,0 +1,302 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần ghi đè config bằng biến môi trường và tham số dòng lệnh:
+  - ENV: AGENT_JOB__<JOB>__<FIELD>[__<SUBFIELD>] cho field của job,
+    AGENT_CONFIG__<GROUP>__<FIELD> cho field agent-level
+    Ví dụ: AGENT_JOB__SYNC_INCREMENTAL_CONVERSATIONS_JOB__PAGESIZE=100,
+    AGENT_JOB__SYNC_WARN_UNREPLIED_CONVERSATIONS_JOB__WORKHOURS__START=07:00,
+    AGENT_CONFIG__CHECKIN__INTERVAL=30
+    Tên job: chữ thường, "_" → "-"; tên field không phân biệt hoa thường, khớp theo field đã định nghĩa
+  - CLI: --set jobs.<job>.<field>=<value> hoặc --set agent.<group>.<field>=<value> (lặp lại được, CLI ưu tiên hơn ENV)
+  - Giá trị parse theo JSON (100, true, {"a":1}), không parse được thì là string
+  - Override nằm trên config từ server và local (ENV/CLI > server > local > default), không lưu vào file config,
+    được báo trong check-in (configLocks, field có locked/lockedBy) để server UI biết không đổi được từ xa
+  - Override không hợp lệ (field không tồn tại, sai kiểu, ngoài giới hạn) bị bỏ qua khi khởi động
+*/
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Prefix của biến môi trường ghi đè config
+const (
+	envJobOverridePrefix   = "AGENT_JOB__"
+	envAgentOverridePrefix = "AGENT_CONFIG__"
+)
+
+// ConfigOverride là một giá trị config bị ghi đè bởi ENV hoặc CLI (gửi lên server trong check-in)
+type ConfigOverride struct {
+	Path     string      `json:"path"`     // Ví dụ: "jobs.sync-incremental-conversations-job.pageSize"
+	Value    interface{} `json:"value"`    // Giá trị đang dùng
+	LockedBy string      `json:"lockedBy"` // "env" hoặc "cli"
+	Source   string      `json:"source"`   // Tên biến môi trường hoặc "--set"
+	segments []string
+}
+
+// loadConfigOverrides đọc override từ biến môi trường và tham số dòng lệnh, bỏ qua override không hợp lệ
+func (cm *ConfigManager) loadConfigOverrides(environ []string, args []string) []*ConfigOverride {
+	byPath := make(map[string]*ConfigOverride)
+
+	for _, entry := range environ {
+		name, rawValue, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		var segments []string
+		var err error
+		switch {
+		case strings.HasPrefix(name, envJobOverridePrefix):
+			segments, err = cm.resolveEnvOverridePath("jobs", strings.Split(strings.TrimPrefix(name, envJobOverridePrefix), "__"))
+		case strings.HasPrefix(name, envAgentOverridePrefix):
+			segments, err = cm.resolveEnvOverridePath("agent", strings.Split(strings.TrimPrefix(name, envAgentOverridePrefix), "__"))
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("[ConfigManager] ⚠️  Bỏ qua biến môi trường %s: %v", name, err)
+			continue
+		}
+		override := &ConfigOverride{
+			Path:     strings.Join(segments, "."),
+			Value:    parseOverrideValue(rawValue),
+			LockedBy: "env",
+			Source:   name,
+			segments: segments,
+		}
+		byPath[override.Path] = override
+	}
+
+	for _, assignment := range cliSetArguments(args) {
+		path, rawValue, ok := strings.Cut(assignment, "=")
+		segments := strings.Split(path, ".")
+		if !ok || len(segments) < 3 || (segments[0] != "jobs" && segments[0] != "agent") {
+			log.Printf("[ConfigManager] ⚠️  Bỏ qua --set %s: cần dạng jobs.<job>.<field>=<value> hoặc agent.<group>.<field>=<value>", assignment)
+			continue
+		}
+		byPath[path] = &ConfigOverride{
+			Path:     path,
+			Value:    parseOverrideValue(rawValue),
+			LockedBy: "cli",
+			Source:   "--set",
+			segments: segments,
+		}
+	}
+
+	overrides := make([]*ConfigOverride, 0, len(byPath))
+	for _, override := range byPath {
+		if err := cm.ValidateConfig(setConfigPath(map[string]interface{}{}, override.segments, override.Value)); err != nil {
+			log.Printf("[ConfigManager] ⚠️  Bỏ qua override %s (%s): %v", override.Path, override.Source, err)
+			continue
+		}
+		overrides = append(overrides, override)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Path < overrides[j].Path })
+	for _, override := range overrides {
+		log.Printf("[ConfigManager] 🔒 Config %s = %v (khóa bởi %s: %s)", override.Path, override.Value, override.LockedBy, override.Source)
+	}
+	return overrides
+}
+
+// resolveEnvOverridePath đổi các phần của tên biến môi trường thành đường dẫn field (đúng tên field đã định nghĩa)
+func (cm *ConfigManager) resolveEnvOverridePath(root string, parts []string) ([]string, error) {
+	var segments []string
+	var schema map[string]interface{}
+	if root == "jobs" {
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, fmt.Errorf("cần dạng %s<JOB>__<FIELD>", envJobOverridePrefix)
+		}
+		jobName := strings.ReplaceAll(strings.ToLower(parts[0]), "_", "-")
+		segments = []string{"jobs", jobName}
+		schema = cm.jobConfigSchema(jobName)
+		parts = parts[1:]
+	} else {
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("cần dạng %s<GROUP>__<FIELD>", envAgentOverridePrefix)
+		}
+		segments = []string{"agent"}
+		schema = configSchemaFromFields(cm.createAgentConfigWithMetadata())
+	}
+
+	for _, part := range parts {
+		properties, _ := schema["properties"].(map[string]interface{})
+		name := matchConfigFieldName(properties, part)
+		if name == "" {
+			return nil, fmt.Errorf("không có field %s trong %s", part, strings.Join(segments, "."))
+		}
+		segments = append(segments, name)
+		schema, _ = properties[name].(map[string]interface{})
+	}
+	return segments, nil
+}
+
+// matchConfigFieldName tìm field trong schema khớp với tên trong biến môi trường (không phân biệt hoa thường và "_")
+func matchConfigFieldName(properties map[string]interface{}, envName string) string {
+	normalize := func(name string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+	}
+	target := normalize(envName)
+	for name := range properties {
+		if normalize(name) == target {
+			return name
+		}
+	}
+	return ""
+}
+
+// cliSetArguments lấy các giá trị của --set (dạng "--set a=b" hoặc "--set=a=b")
+func cliSetArguments(args []string) []string {
+	values := []string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--set" || args[i] == "-set":
+			if i+1 < len(args) {
+				values = append(values, args[i+1])
+				i++
+			}
+		case strings.HasPrefix(args[i], "--set="):
+			values = append(values, strings.TrimPrefix(args[i], "--set="))
+		case strings.HasPrefix(args[i], "-set="):
+			values = append(values, strings.TrimPrefix(args[i], "-set="))
+		}
+	}
+	return values
+}
+
+// parseOverrideValue parse giá trị override theo JSON, không phải JSON thì giữ nguyên string
+func parseOverrideValue(raw string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err == nil {
+		return value
+	}
+	return raw
+}
+
+// setConfigPath ghi giá trị vào config theo đường dẫn (giữ metadata {value, name, description} nếu field có)
+// Jobs có thể là array (API v3.14) hoặc object, job chưa có thì được thêm vào
+func setConfigPath(configData map[string]interface{}, segments []string, value interface{}) map[string]interface{} {
+	var node map[string]interface{}
+	rest := segments[1:]
+	if segments[0] == "jobs" {
+		jobName := segments[1]
+		rest = segments[2:]
+		jobConfig, exists := jobConfigsByName(configData["jobs"])[jobName]
+		if !exists {
+			jobConfig = make(map[string]interface{})
+			if list, ok := configData["jobs"].([]interface{}); ok {
+				jobConfig["name"] = jobName
+				configData["jobs"] = append(list, jobConfig)
+			} else {
+				jobs, ok := configData["jobs"].(map[string]interface{})
+				if !ok {
+					jobs = make(map[string]interface{})
+					configData["jobs"] = jobs
+				}
+				jobs[jobName] = jobConfig
+			}
+		}
+		node = jobConfig
+	} else {
+		agent, ok := configData["agent"].(map[string]interface{})
+		if !ok {
+			agent = make(map[string]interface{})
+			configData["agent"] = agent
+		}
+		node = agent
+	}
+
+	for i, key := range rest {
+		// Field có metadata → ghi/đi vào "value"
+		if field, ok := node[key].(map[string]interface{}); ok {
+			if _, isField := field["value"]; isField {
+				if i == len(rest)-1 {
+					field["value"] = value
+					return configData
+				}
+				inner, ok := field["value"].(map[string]interface{})
+				if !ok {
+					inner = make(map[string]interface{})
+					field["value"] = inner
+				}
+				node = inner
+				continue
+			}
+		}
+		if i == len(rest)-1 {
+			node[key] = value
+			return configData
+		}
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[key] = child
+		}
+		node = child
+	}
+	return configData
+}
+
+// applyConfigOverrides trả về bản copy của config đã ghi đè bởi ENV/CLI (config gốc không đổi)
+func applyConfigOverrides(configData map[string]interface{}, overrides []*ConfigOverride) map[string]interface{} {
+	if len(overrides) == 0 {
+		return configData
+	}
+	effective := make(map[string]interface{})
+	data, _ := json.Marshal(configData)
+	json.Unmarshal(data, &effective)
+	for _, override := range overrides {
+		setConfigPath(effective, override.segments, override.Value)
+	}
+	return effective
+}
+
+// markLockedConfigFields đánh dấu field bị ghi đè trong config gửi lên server (locked, lockedBy, lockedValue)
+func markLockedConfigFields(configData map[string]interface{}, overrides []*ConfigOverride) {
+	for _, override := range overrides {
+		lock := map[string]interface{}{
+			"locked":      true,
+			"lockedBy":    override.LockedBy,
+			"lockedValue": override.Value,
+		}
+		// Đánh dấu field của job/group (jobs.<job>.<field>, agent.<group>.<field>), field lồng nhau thì đánh dấu field cha
+		if len(override.segments) < 3 {
+			continue
+		}
+		segments := override.segments[:3]
+		var node map[string]interface{}
+		if segments[0] == "jobs" {
+			node = jobConfigsByName(configData["jobs"])[segments[1]]
+		} else if agent, ok := configData["agent"].(map[string]interface{}); ok {
+			node, _ = agent[segments[1]].(map[string]interface{})
+		}
+		if node == nil {
+			continue
+		}
+		if field, ok := node[segments[2]].(map[string]interface{}); ok {
+			if _, isField := field["value"]; isField {
+				for key, value := range lock {
+					field[key] = value
+				}
+			}
+		}
+	}
+}
+
+// ConfigOverrides trả về các field config đang bị ghi đè bởi ENV/CLI
+func (cm *ConfigManager) ConfigOverrides() []*ConfigOverride {
+	return cm.overrides
+}
+
+// initConfigOverrides đọc override từ môi trường của process (gọi khi tạo ConfigManager)
+func (cm *ConfigManager) initConfigOverrides() {
+	args := []string{}
+	if len(os.Args) > 1 {
+		args = os.Args[1:]
+	}
+	cm.overrides = cm.loadConfigOverrides(os.Environ(), args)
+}
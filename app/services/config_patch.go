@@ -0,0 +1,537 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa các định dạng config update dạng patch và hash config:
+  - RFC 7396 JSON Merge Patch (configMergePatch): object merge đệ quy, null = xóa field, giá trị khác (kể cả array) thay thế
+  - RFC 6902 JSON Patch (configPatch): add, remove, replace, move, copy, test với JSON Pointer (RFC 6901)
+    Patch được apply nguyên vẹn hoặc không apply gì (lỗi ở op nào cũng giữ config cũ)
+  - Hash config = SHA256 của canonical JSON (RFC 8785 JCS): key sắp theo UTF-16, không khoảng trắng,
+    số theo định dạng ECMAScript, string chỉ escape ký tự bắt buộc → agent và server tính ra cùng configHash
+*/
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ========================================
+// CANONICAL JSON (RFC 8785)
+// ========================================
+
+// canonicalJSON serialize giá trị theo RFC 8785 (JSON Canonicalization Scheme)
+func canonicalJSON(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCanonicalJSON(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalHash là SHA256 (hex) của canonical JSON
+func canonicalHash(value interface{}) string {
+	data, err := canonicalJSON(value)
+	if err != nil {
+		// Giá trị không serialize được (NaN, Inf...) → hash theo json.Marshal để không panic
+		data, _ = json.Marshal(value)
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func writeCanonicalJSON(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(buf, v)
+	case float64:
+		number, err := formatCanonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32:
+		return writeCanonicalJSON(buf, normalizeJSONNumber(v))
+	case json.Number:
+		number, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return writeCanonicalJSON(buf, number)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// RFC 8785: sắp key theo UTF-16 code unit (khác thứ tự byte UTF-8 với ký tự ngoài BMP)
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonicalJSON(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		// Kiểu khác (struct, map/slice có kiểu) → đưa về dạng JSON chung rồi canonicalize
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		return writeCanonicalJSON(buf, generic)
+	}
+	return nil
+}
+
+// normalizeJSONNumber đưa các kiểu số của Go về float64 (giống giá trị sau khi đọc từ JSON)
+func normalizeJSONNumber(value interface{}) interface{} {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32:
+		return rv.Float()
+	}
+	return value
+}
+
+// formatCanonicalNumber định dạng số như Number.prototype.toString của ECMAScript (RFC 8785 mục 3.2.2.3)
+func formatCanonicalNumber(v float64) (string, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", fmt.Errorf("số không hợp lệ trong JSON: %v", v)
+	}
+	if v == 0 {
+		return "0", nil // Cả -0
+	}
+	abs := math.Abs(v)
+	if abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	// Dạng mũ: Go trả "1e-07", ECMAScript là "1e-7"; "1e+21" giữ nguyên dấu +
+	formatted := strconv.FormatFloat(v, 'e', -1, 64)
+	mantissa, exponent, _ := strings.Cut(formatted, "e")
+	sign := exponent[:1]
+	digits := strings.TrimLeft(exponent[1:], "0")
+	if digits == "" {
+		digits = "0"
+	}
+	return mantissa + "e" + sign + digits, nil
+}
+
+// writeCanonicalString ghi string JSON, chỉ escape ký tự bắt buộc (không escape HTML, không escape U+2028/2029)
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// lessUTF16 so sánh hai string theo UTF-16 code unit
+func lessUTF16(a, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+// ========================================
+// JSON MERGE PATCH (RFC 7396)
+// ========================================
+
+// applyMergePatch apply JSON Merge Patch vào target (target có thể bị sửa trực tiếp), trả về kết quả
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = make(map[string]interface{})
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+			continue
+		}
+		targetMap[key] = applyMergePatch(targetMap[key], value)
+	}
+	return targetMap
+}
+
+// ========================================
+// JSON PATCH (RFC 6902)
+// ========================================
+
+// applyJSONPatch apply danh sách operation vào doc, lỗi ở bất kỳ op nào → trả lỗi (doc có thể đã bị sửa một phần,
+// caller phải apply trên bản copy)
+func applyJSONPatch(doc interface{}, operations []interface{}) (interface{}, error) {
+	for i, raw := range operations {
+		op, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("op %d: phải là object", i)
+		}
+		opName, _ := op["op"].(string)
+		path, ok := op["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("op %d (%s): thiếu path", i, opName)
+		}
+		tokens, err := parseJSONPointer(path)
+		if err != nil {
+			return nil, fmt.Errorf("op %d (%s): %w", i, opName, err)
+		}
+
+		switch opName {
+		case "add", "replace":
+			value, exists := op["value"]
+			if !exists {
+				return nil, fmt.Errorf("op %d (%s %s): thiếu value", i, opName, path)
+			}
+			doc, err = jsonPatchSet(doc, tokens, deepCopyJSON(value), opName == "replace")
+		case "remove":
+			doc, _, err = jsonPatchRemove(doc, tokens)
+		case "move", "copy":
+			from, ok := op["from"].(string)
+			if !ok {
+				return nil, fmt.Errorf("op %d (%s %s): thiếu from", i, opName, path)
+			}
+			fromTokens, parseErr := parseJSONPointer(from)
+			if parseErr != nil {
+				return nil, fmt.Errorf("op %d (%s): %w", i, opName, parseErr)
+			}
+			var value interface{}
+			if opName == "move" {
+				if strings.HasPrefix(path+"/", from+"/") && path != from {
+					return nil, fmt.Errorf("op %d (move): không thể move %s vào con của chính nó (%s)", i, from, path)
+				}
+				doc, value, err = jsonPatchRemove(doc, fromTokens)
+			} else {
+				value, err = jsonPatchGet(doc, fromTokens)
+				value = deepCopyJSON(value)
+			}
+			if err == nil {
+				doc, err = jsonPatchSet(doc, tokens, value, false)
+			}
+		case "test":
+			var actual interface{}
+			actual, err = jsonPatchGet(doc, tokens)
+			if err == nil {
+				expected, _ := canonicalJSON(op["value"])
+				got, _ := canonicalJSON(actual)
+				if !bytes.Equal(expected, got) {
+					err = fmt.Errorf("giá trị tại %s là %s, cần %s", path, got, expected)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("op %d: op không hỗ trợ %q", i, opName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("op %d (%s %s): %w", i, opName, path, err)
+		}
+	}
+	return doc, nil
+}
+
+// parseJSONPointer tách JSON Pointer (RFC 6901) thành các token ("" = toàn bộ document)
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON Pointer phải bắt đầu bằng \"/\": %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// jsonPatchGet lấy giá trị tại đường dẫn
+func jsonPatchGet(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			value, exists := n[token]
+			if !exists {
+				return nil, fmt.Errorf("không tồn tại key %q", token)
+			}
+			node = value
+		case []interface{}:
+			index, err := jsonPatchIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[index]
+		default:
+			return nil, fmt.Errorf("không thể đi vào %q của giá trị không phải object/array", token)
+		}
+	}
+	return node, nil
+}
+
+// jsonPatchSet thêm (add) hoặc thay (replace, đích phải tồn tại) giá trị tại đường dẫn, trả về node mới
+func jsonPatchSet(node interface{}, tokens []string, value interface{}, replace bool) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	token, rest := tokens[0], tokens[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			if _, exists := n[token]; replace && !exists {
+				return nil, fmt.Errorf("không tồn tại key %q để replace", token)
+			}
+			n[token] = value
+			return n, nil
+		}
+		child, exists := n[token]
+		if !exists {
+			return nil, fmt.Errorf("không tồn tại key %q", token)
+		}
+		updated, err := jsonPatchSet(child, rest, value, replace)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []interface{}:
+		if len(rest) == 0 {
+			if replace {
+				index, err := jsonPatchIndex(token, len(n), false)
+				if err != nil {
+					return nil, err
+				}
+				n[index] = value
+				return n, nil
+			}
+			index, err := jsonPatchIndex(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[index+1:], n[index:])
+			n[index] = value
+			return n, nil
+		}
+		index, err := jsonPatchIndex(token, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := jsonPatchSet(n[index], rest, value, replace)
+		if err != nil {
+			return nil, err
+		}
+		n[index] = updated
+		return n, nil
+	}
+	return nil, fmt.Errorf("không thể đi vào %q của giá trị không phải object/array", token)
+}
+
+// jsonPatchRemove xóa giá trị tại đường dẫn, trả về node mới và giá trị đã xóa
+func jsonPatchRemove(node interface{}, tokens []string) (interface{}, interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("không thể xóa toàn bộ document")
+	}
+	token, rest := tokens[0], tokens[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, exists := n[token]
+		if !exists {
+			return nil, nil, fmt.Errorf("không tồn tại key %q", token)
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		updated, removed, err := jsonPatchRemove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = updated
+		return n, removed, nil
+	case []interface{}:
+		index, err := jsonPatchIndex(token, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[index]
+			return append(n[:index], n[index+1:]...), removed, nil
+		}
+		updated, removed, err := jsonPatchRemove(n[index], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[index] = updated
+		return n, removed, nil
+	}
+	return nil, nil, fmt.Errorf("không thể đi vào %q của giá trị không phải object/array", token)
+}
+
+// jsonPatchIndex parse index của array ("-" = cuối array, chỉ dùng cho add)
+func jsonPatchIndex(token string, length int, forAdd bool) (int, error) {
+	if token == "-" && forAdd {
+		return length, nil
+	}
+	// RFC 6901: index không có số 0 ở đầu
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("index không hợp lệ %q", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("index không hợp lệ %q", token)
+	}
+	maxIndex := length - 1
+	if forAdd {
+		maxIndex = length
+	}
+	if index > maxIndex {
+		return 0, fmt.Errorf("index %d vượt quá độ dài array (%d)", index, length)
+	}
+	return index, nil
+}
+
+// deepCopyJSON copy sâu giá trị dạng JSON (map/array) để patch không dùng chung dữ liệu với nguồn
+func deepCopyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = deepCopyJSON(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = deepCopyJSON(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// ========================================
+// APPLY PATCH VÀO CONFIG
+// ========================================
+
+// ApplyConfigMergePatch apply config update dạng RFC 7396 JSON Merge Patch (configMergePatch)
+func (cm *ConfigManager) ApplyConfigMergePatch(patch map[string]interface{}, version int64, configHash string) error {
+	if len(patch) == 0 {
+		return fmt.Errorf("config merge patch is empty")
+	}
+	patched, ok := applyMergePatch(cm.copyConfigData(), patch).(map[string]interface{})
+	if !ok {
+		return &ConfigValidationError{Errors: []string{"merge patch phải là object"}}
+	}
+	return cm.commitPatchedConfig(patched, version, configHash)
+}
+
+// ApplyConfigJSONPatch apply config update dạng RFC 6902 JSON Patch (configPatch)
+func (cm *ConfigManager) ApplyConfigJSONPatch(operations []interface{}, version int64, configHash string) error {
+	if len(operations) == 0 {
+		return fmt.Errorf("config patch is empty")
+	}
+	result, err := applyJSONPatch(cm.copyConfigData(), operations)
+	if err != nil {
+		return &ConfigValidationError{Errors: []string{fmt.Sprintf("JSON Patch lỗi: %v", err)}}
+	}
+	patched, ok := result.(map[string]interface{})
+	if !ok {
+		return &ConfigValidationError{Errors: []string{"JSON Patch làm config không còn là object"}}
+	}
+	return cm.commitPatchedConfig(patched, version, configHash)
+}
+
+// copyConfigData copy sâu config hiện tại (patch apply trên bản copy, lỗi thì config đang chạy không đổi)
+func (cm *ConfigManager) copyConfigData() map[string]interface{} {
+	copied := make(map[string]interface{})
+	data, _ := json.Marshal(cm.configData)
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+// commitPatchedConfig validate config sau patch, kiểm tra hash khớp với server rồi mới thay config đang chạy
+// Hash lệch nghĩa là config của agent đã khác server trước khi patch → từ chối và gửi full config để server đồng bộ lại
+func (cm *ConfigManager) commitPatchedConfig(patched map[string]interface{}, version int64, configHash string) error {
+	if err := cm.ValidateConfig(patched); err != nil {
+		return err
+	}
+	if configHash != "" {
+		if hash := cm.calculateHash(patched); hash != configHash {
+			cm.MarkNeedSubmitFullConfig()
+			return &ConfigValidationError{Errors: []string{fmt.Sprintf("configHash sau khi patch không khớp (agent: %s, server: %s)", hash, configHash)}}
+		}
+	}
+
+	cm.configData = patched
+	cm.currentVersion = version
+	cm.currentHash = configHash
+	if cm.currentHash == "" {
+		cm.currentHash = cm.calculateHash(patched)
+	}
+	cm.applyConfig()
+	if err := cm.SaveLocalConfig(); err != nil {
+		log.Printf("[ConfigManager] Warning: Failed to save local config after patch: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,448 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa model config có kiểu và phần validate config trước khi apply:
+  - JSON Schema của config được sinh từ định nghĩa field (createAgentConfigWithMetadata, createJobConfigWithMetadata)
+    cộng với ràng buộc theo tên field (configFieldRules: min/max, enum, pattern)
+  - Schema mô tả config đã bỏ metadata {value, name, description} và jobs dạng object theo tên job
+  - Ngoài schema còn kiểm tra cron của schedule và ràng buộc giữa các field (minDelayMinutes <= maxDelayMinutes)
+  - Config từ server không hợp lệ bị từ chối nguyên vẹn (không apply một phần), lý do gửi lại server
+    trong check-in (configApply.appliedStatus = "failed")
+*/
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ConfigValidationError là lỗi khi config không qua validate (danh sách lỗi theo từng field)
+type ConfigValidationError struct {
+	Errors []string
+}
+
+func (e *ConfigValidationError) Error() string {
+	return fmt.Sprintf("config không hợp lệ: %s", strings.Join(e.Errors, "; "))
+}
+
+// ConfigApplyResult là kết quả apply config update gần nhất từ server (gửi lại server trong check-in)
+type ConfigApplyResult struct {
+	Version       int64    `json:"version"`
+	ConfigHash    string   `json:"configHash,omitempty"`
+	AppliedStatus string   `json:"appliedStatus"` // "applied", "failed", "rolled_back"
+	Reason        string   `json:"reason,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
+	AppliedAt     int64    `json:"appliedAt"`
+}
+
+// TypedAgentConfig là config đã validate, đọc theo kiểu thay vì map[string]interface{}
+type TypedAgentConfig struct {
+	CheckIn struct {
+		Interval                   int  `json:"interval"`
+		Enabled                    bool `json:"enabled"`
+		SystemMetricsCacheInterval int  `json:"systemMetricsCacheInterval"`
+	} `json:"checkIn"`
+	HealthCheck    HealthCheckConfig `json:"healthCheck"`
+	ErrorReporting struct {
+		MaxErrorsPerCheckIn int `json:"maxErrorsPerCheckIn"`
+		ErrorRetentionHours int `json:"errorRetentionHours"`
+	} `json:"errorReporting"`
+	ConfigHistory struct {
+		MaxVersions        int     `json:"maxVersions"`
+		AutoRollback       bool    `json:"autoRollback"`
+		ProbationMinutes   int     `json:"probationMinutes"`
+		ErrorRateThreshold float64 `json:"errorRateThreshold"`
+		MinProbationRuns   int     `json:"minProbationRuns"`
+	} `json:"configHistory"`
+	ConfigWatch struct {
+		Enabled         bool `json:"enabled"`
+		IntervalSeconds int  `json:"intervalSeconds"`
+	} `json:"configWatch"`
+	Commands CommandsConfig             `json:"commands"`
+	Jobs     map[string]*TypedJobConfig `json:"-"`
+}
+
+// HealthCheckConfig là ngưỡng đánh giá health trong check-in (agent.healthCheck, xem health.go)
+type HealthCheckConfig struct {
+	CPUThreshold    float64 `json:"cpuThreshold"`
+	MemoryThreshold float64 `json:"memoryThreshold"`
+	DiskThreshold   float64 `json:"diskThreshold"`
+	// Rule trên job metrics, upstream và thư mục log
+	JobFailureThreshold         int     `json:"jobFailureThreshold"`
+	JobFailureCriticalThreshold int     `json:"jobFailureCriticalThreshold"`
+	StaleIntervalMultiplier     float64 `json:"staleIntervalMultiplier"`
+	SlowDurationRatio           float64 `json:"slowDurationRatio"`
+	CheckInFailureThreshold     int     `json:"checkInFailureThreshold"`
+	LogDiskMinFreeMB            int     `json:"logDiskMinFreeMB"`
+}
+
+// CommandsConfig là config xử lý command từ server (agent.commands)
+type CommandsConfig struct {
+	ExpirySeconds           int `json:"expirySeconds"`
+	JournalRetentionHours   int `json:"journalRetentionHours"`
+	ReportRetryMaxSeconds   int `json:"reportRetryMaxSeconds"`
+	MaxConcurrent           int `json:"maxConcurrent"`           // Số command chạy lâu (run_job...) thực thi đồng thời
+	ProgressIntervalSeconds int `json:"progressIntervalSeconds"` // Chu kỳ gửi tiến độ của command đang thực thi
+}
+
+// TypedJobConfig là config của một job (field chung có kiểu, field riêng của job trong Fields)
+type TypedJobConfig struct {
+	Name       string                 `json:"-"`
+	Enabled    bool                   `json:"enabled"`
+	Schedule   string                 `json:"schedule"`
+	Timeout    int                    `json:"timeout"`
+	MaxRetries int                    `json:"maxRetries"`
+	RetryDelay int                    `json:"retryDelay"`
+	PageSize   int                    `json:"pageSize"`
+	Fields     map[string]interface{} `json:"-"` // Tất cả field của job (đã bỏ metadata)
+}
+
+// configFieldRules là ràng buộc JSON Schema theo tên field (áp dụng cho field cùng tên trong định nghĩa của agent và các job)
+var configFieldRules = map[string]map[string]interface{}{
+	// Agent
+	"interval":                   {"minimum": 10, "maximum": 86400},
+	"systemMetricsCacheInterval": {"minimum": 0, "maximum": 86400},
+	"cpuThreshold":               {"minimum": 0, "maximum": 100},
+	"memoryThreshold":            {"minimum": 0, "maximum": 100},
+	"diskThreshold":              {"minimum": 0, "maximum": 100},
+	"maxErrorsPerCheckIn":        {"minimum": 0, "maximum": 1000},
+	"errorRetentionHours":        {"minimum": 0},
+	"maxVersions":                {"minimum": 1, "maximum": 100},
+	"probationMinutes":           {"minimum": 0, "maximum": 1440},
+	"errorRateThreshold":         {"minimum": 0, "maximum": 1},
+	"minProbationRuns":           {"minimum": 1},
+	"intervalSeconds":            {"minimum": 1, "maximum": 3600},
+	"expirySeconds":              {"minimum": 0},
+	"journalRetentionHours":      {"minimum": 1, "maximum": 8760},
+	"reportRetryMaxSeconds":      {"minimum": 1, "maximum": 86400},
+	"maxConcurrent":              {"minimum": 1, "maximum": 20},
+	"progressIntervalSeconds":    {"minimum": 1, "maximum": 3600},
+
+	// Health check (health.go)
+	"jobFailureThreshold":         {"minimum": 1},
+	"jobFailureCriticalThreshold": {"minimum": 1},
+	"staleIntervalMultiplier":     {"minimum": 1},
+	"slowDurationRatio":           {"minimum": 0.1},
+	"checkInFailureThreshold":     {"minimum": 1},
+	"logDiskMinFreeMB":            {"minimum": 0},
+
+	// Chung cho jobs
+	"schedule":   {"type": "string", "format": "cron"},
+	"enabled":    {"type": "boolean"},
+	"timeout":    {"type": "integer", "minimum": 1, "maximum": 86400},
+	"maxRetries": {"type": "integer", "minimum": 0, "maximum": 100},
+	"retryDelay": {"type": "integer", "minimum": 0, "maximum": 86400},
+	"pageSize":   {"type": "integer", "minimum": 1, "maximum": 1000},
+	"type":       {"type": "string", "minLength": 1}, // Job động: tên job template (config_dynamic_jobs.go)
+	"params":     {"type": "object"},                 // Job động: tham số riêng của instance
+
+	// Cảnh báo hội thoại chưa trả lời
+	"workHours": {
+		"type": "object",
+		"properties": map[string]interface{}{
+			"start": map[string]interface{}{"type": "string", "pattern": `^([01]?\d|2[0-3]):[0-5]\d$`},
+			"end":   map[string]interface{}{"type": "string", "pattern": `^([01]?\d|2[0-3]):[0-5]\d$`},
+		},
+	},
+	"minDelayMinutes":              {"minimum": 0},
+	"maxDelayMinutes":              {"minimum": 0},
+	"notificationRateLimitMinutes": {"minimum": 0},
+
+	// Workflow commands
+	"claimLimit":             {"minimum": 1, "maximum": 100},
+	"maxConcurrentWorkflows": {"minimum": 1, "maximum": 100},
+	"maxParallelSteps":       {"minimum": 1, "maximum": 64},
+	"heartbeatInterval":      {"minimum": 1, "maximum": 3600},
+	"progressInterval":       {"minimum": 1, "maximum": 3600},
+	"maxRunCost":             {"minimum": 0},
+	"schemaRepairAttempts":   {"minimum": 0, "maximum": 10},
+	"aiCacheTTLHours":        {"minimum": 0},
+	"aiCacheMaxSizeMB":       {"minimum": 0},
+	"providerConcurrency": {
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{"type": "integer", "minimum": 0},
+	},
+	"aiPricing": {
+		"type": "object",
+		"additionalProperties": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"input":       map[string]interface{}{"type": "number", "minimum": 0},
+				"output":      map[string]interface{}{"type": "number", "minimum": 0},
+				"cachedInput": map[string]interface{}{"type": "number", "minimum": 0},
+				"cacheWrite":  map[string]interface{}{"type": "number", "minimum": 0},
+			},
+		},
+	},
+}
+
+// configCronParser parse cron giống scheduler (6 trường, có giây) và cho phép descriptor (@every 5m, @daily...)
+var configCronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ConfigSchema trả về JSON Schema của config (sinh từ định nghĩa field của agent và các job đã đăng ký)
+// Config validate theo schema này là config đã bỏ metadata, jobs dạng object {jobName: {...}}
+func (cm *ConfigManager) ConfigSchema() map[string]interface{} {
+	jobProperties := make(map[string]interface{})
+	if cm.scheduler != nil {
+		for jobName := range cm.scheduler.GetJobs() {
+			jobProperties[jobName] = cm.jobConfigSchema(jobName)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"agent": configSchemaFromFields(cm.createAgentConfigWithMetadata()),
+			"jobs": map[string]interface{}{
+				"type":       "object",
+				"properties": jobProperties,
+				// Job chưa đăng ký (job mới từ server): dùng field mặc định của job
+				"additionalProperties": cm.jobConfigSchema(""),
+			},
+		},
+	}
+}
+
+// configSchemaFromFields sinh schema object từ map field có metadata (createConfigField) hoặc object lồng nhau
+func configSchemaFromFields(fields map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for name, raw := range fields {
+		field, ok := raw.(map[string]interface{})
+		if !ok {
+			continue // description...
+		}
+		if value, isField := field["value"]; isField {
+			properties[name] = configFieldSchema(name, value, getString(field, "description"))
+		} else {
+			properties[name] = configSchemaFromFields(field)
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// jobConfigSchema sinh schema config của một job, field chung (enabled, schedule, timeout...) luôn được kiểm tra
+// kể cả khi job không khai báo (ví dụ pageSize của job mặc định)
+func (cm *ConfigManager) jobConfigSchema(jobName string) map[string]interface{} {
+	schema := configSchemaFromFields(cm.createJobConfigWithMetadata(jobName))
+	properties := schema["properties"].(map[string]interface{})
+	for _, name := range []string{"enabled", "schedule", "timeout", "maxRetries", "retryDelay", "pageSize", "type", "params"} {
+		if _, exists := properties[name]; !exists {
+			properties[name] = copyContent(configFieldRules[name])
+		}
+	}
+	return schema
+}
+
+// configFieldSchema sinh schema của một field từ giá trị mặc định và ràng buộc theo tên
+func configFieldSchema(name string, defaultValue interface{}, description string) map[string]interface{} {
+	schema := map[string]interface{}{}
+	if fieldType := configValueSchemaType(defaultValue); fieldType != "" {
+		schema["type"] = fieldType
+	}
+	if description != "" {
+		schema["description"] = description
+	}
+	if defaultValue != nil {
+		schema["default"] = defaultValue
+	}
+	for key, value := range configFieldRules[name] {
+		schema[key] = value
+	}
+	return schema
+}
+
+// configValueSchemaType là kiểu JSON Schema của giá trị mặc định (số nguyên → "integer")
+func configValueSchemaType(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map:
+		return "object"
+	}
+	return ""
+}
+
+// jobConfigsByName lấy config của các job theo tên, jobs có thể là array (API v3.14, mỗi phần tử có "name")
+// hoặc object {jobName: {...}}
+func jobConfigsByName(jobs interface{}) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{})
+	switch list := jobs.(type) {
+	case map[string]interface{}:
+		for jobName, raw := range list {
+			if jobConfig, ok := raw.(map[string]interface{}); ok {
+				result[jobName] = jobConfig
+			}
+		}
+	case []interface{}:
+		for _, raw := range list {
+			jobConfig, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if jobName, _ := jobConfig["name"].(string); jobName != "" {
+				result[jobName] = jobConfig
+			}
+		}
+	}
+	return result
+}
+
+// normalizeConfigValues bỏ metadata {value, name, description} và chuyển jobs về object theo tên
+func (cm *ConfigManager) normalizeConfigValues(configData map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{})
+	if agent, ok := cm.extractValue(configData["agent"]).(map[string]interface{}); ok {
+		normalized["agent"] = agent
+	} else {
+		normalized["agent"] = map[string]interface{}{}
+	}
+	jobs := make(map[string]interface{})
+	for jobName, jobConfig := range jobConfigsByName(configData["jobs"]) {
+		values, _ := cm.extractValue(jobConfig).(map[string]interface{})
+		delete(values, "name")
+		delete(values, "description")
+		jobs[jobName] = values
+	}
+	normalized["jobs"] = jobs
+	return normalized
+}
+
+// ValidateConfig kiểm tra config (schema, cron, ràng buộc giữa các field), trả về *ConfigValidationError nếu không hợp lệ
+func (cm *ConfigManager) ValidateConfig(configData map[string]interface{}) error {
+	if configData == nil {
+		return &ConfigValidationError{Errors: []string{"configData rỗng"}}
+	}
+	normalized := cm.normalizeConfigValues(configData)
+	errs := validateJSONSchema(cm.ConfigSchema(), normalized)
+
+	if list, ok := configData["jobs"].([]interface{}); ok {
+		for i, raw := range list {
+			jobConfig, isMap := raw.(map[string]interface{})
+			if !isMap {
+				errs = append(errs, fmt.Sprintf("jobs.%d: phải là object", i))
+			} else if name, _ := jobConfig["name"].(string); name == "" {
+				errs = append(errs, fmt.Sprintf("jobs.%d: thiếu name", i))
+			}
+		}
+	}
+
+	jobs, _ := normalized["jobs"].(map[string]interface{})
+	jobNames := make([]string, 0, len(jobs))
+	for jobName := range jobs {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+	for _, jobName := range jobNames {
+		values, _ := jobs[jobName].(map[string]interface{})
+		if schedule, ok := values["schedule"].(string); ok && schedule != "" {
+			if _, err := configCronParser.Parse(schedule); err != nil {
+				errs = append(errs, fmt.Sprintf("jobs.%s.schedule: cron không hợp lệ %q: %v", jobName, schedule, err))
+			}
+		}
+		minDelay, hasMin := toFloat64(values["minDelayMinutes"])
+		maxDelay, hasMax := toFloat64(values["maxDelayMinutes"])
+		if hasMin && hasMax && minDelay > maxDelay {
+			errs = append(errs, fmt.Sprintf("jobs.%s: minDelayMinutes (%v) lớn hơn maxDelayMinutes (%v)", jobName, minDelay, maxDelay))
+		}
+	}
+
+	errs = append(errs, cm.validateDynamicJobs(jobs)...)
+
+	if len(errs) > 0 {
+		return &ConfigValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// buildTypedConfig đọc config đã bỏ metadata thành TypedAgentConfig (giá trị thiếu lấy theo định nghĩa mặc định)
+func (cm *ConfigManager) buildTypedConfig(configData map[string]interface{}) *TypedAgentConfig {
+	typed := &TypedAgentConfig{Jobs: make(map[string]*TypedJobConfig)}
+	defaults, _ := cm.extractValue(cm.createAgentConfigWithMetadata()).(map[string]interface{})
+	decodeConfigInto(defaults, typed)
+
+	normalized := cm.normalizeConfigValues(configData)
+	if agent, ok := normalized["agent"].(map[string]interface{}); ok {
+		decodeConfigInto(agent, typed)
+	}
+	jobs, _ := normalized["jobs"].(map[string]interface{})
+	for jobName, raw := range jobs {
+		values, _ := raw.(map[string]interface{})
+		job := &TypedJobConfig{Name: jobName, Enabled: true, Fields: values}
+		jobDefaults, _ := cm.extractValue(cm.createJobConfigWithMetadata(jobName)).(map[string]interface{})
+		decodeConfigInto(jobDefaults, job)
+		decodeConfigInto(values, job)
+		typed.Jobs[jobName] = job
+	}
+	return typed
+}
+
+// decodeConfigInto ghi các giá trị của map vào struct qua JSON, field sai kiểu được bỏ qua (giữ giá trị trước đó)
+func decodeConfigInto(values map[string]interface{}, target interface{}) {
+	for key, value := range values {
+		encoded, err := json.Marshal(map[string]interface{}{key: value})
+		if err != nil {
+			continue
+		}
+		_ = json.Unmarshal(encoded, target)
+	}
+}
+
+// TypedConfig trả về config hiện tại dạng có kiểu (cập nhật mỗi lần apply config)
+func (cm *ConfigManager) TypedConfig() *TypedAgentConfig {
+	if cm.typedConfig == nil {
+		cm.typedConfig = cm.buildTypedConfig(cm.runtimeConfig())
+	}
+	return cm.typedConfig
+}
+
+// RecordConfigApplyResult lưu kết quả apply config update từ server (gửi lại server trong check-in)
+func (cm *ConfigManager) RecordConfigApplyResult(version int64, configHash string, err error) {
+	result := &ConfigApplyResult{
+		Version:       version,
+		ConfigHash:    configHash,
+		AppliedStatus: "applied",
+		AppliedAt:     time.Now().Unix(),
+	}
+	if err != nil {
+		result.AppliedStatus = "failed"
+		result.Reason = err.Error()
+		if validationErr, ok := err.(*ConfigValidationError); ok {
+			result.Errors = validationErr.Errors
+		}
+	}
+	cm.lastApplyResult = result
+}
+
+// LastConfigApplyResult trả về kết quả apply config update gần nhất (nil nếu chưa có update nào)
+func (cm *ConfigManager) LastConfigApplyResult() *ConfigApplyResult {
+	return cm.lastApplyResult
+}
+
+// IsRejectedConfigUpdate kiểm tra update (version, hash) đã bị từ chối hoặc rollback trước đó (không apply/log lại mỗi lần check-in)
+func (cm *ConfigManager) IsRejectedConfigUpdate(version int64, configHash string) bool {
+	last := cm.lastApplyResult
+	if last == nil || last.Version != version || last.ConfigHash != configHash {
+		return false
+	}
+	return last.AppliedStatus == "failed" || last.AppliedStatus == "rolled_back"
+}
@@ -0,0 +1,210 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa watcher hot reload các file config local:
+  - Theo dõi ./config/agent-config.json và file log filter (LoadLogFilterConfig) bằng polling
+    (mod time, size, hash nội dung) để chạy được trên mọi hệ điều hành/filesystem
+  - agent-config.json thay đổi → validate và apply như config update từ server (ApplyFullConfig, lịch sử, probation)
+  - Log filter thay đổi → ReloadLogFilterConfig (file không hợp lệ thì giữ config cũ)
+  - Mỗi lần reload log diff các field thay đổi
+  - Bật/tắt và chu kỳ poll qua agent.configWatch
+*/
+package services
+
+import (
+	"agent_pancake/utility/logger"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchedFile là trạng thái của một file đang được theo dõi
+type watchedFile struct {
+	path     string
+	modTime  time.Time
+	size     int64
+	hash     [sha256.Size]byte
+	exists   bool
+	onChange func(data []byte) ([]string, error) // Apply nội dung mới, trả về changelog
+}
+
+// ConfigFileWatcher theo dõi thay đổi của các file config local bằng polling
+type ConfigFileWatcher struct {
+	configManager *ConfigManager
+	files         []*watchedFile
+	logger        *logrus.Logger
+	stopCh        chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewConfigFileWatcher tạo watcher cho file config agent và file log filter config
+func NewConfigFileWatcher(cm *ConfigManager) *ConfigFileWatcher {
+	w := &ConfigFileWatcher{
+		configManager: cm,
+		logger:        logger.GetLogger("config-watcher"),
+		stopCh:        make(chan struct{}),
+	}
+	w.addFile(cm.localConfigPath, w.reloadAgentConfig)
+	if path := logger.GetLogFilterConfigPath(); path != "" {
+		w.addFile(path, w.reloadLogFilterConfig)
+	}
+	return w
+}
+
+// addFile thêm file cần theo dõi (trạng thái hiện tại của file là mốc, không reload lúc bắt đầu)
+func (w *ConfigFileWatcher) addFile(path string, onChange func(data []byte) ([]string, error)) {
+	file := &watchedFile{path: path, onChange: onChange}
+	if info, data, err := readWatchedFile(path); err == nil {
+		file.exists = true
+		file.modTime = info.ModTime()
+		file.size = info.Size()
+		file.hash = sha256.Sum256(data)
+	}
+	w.files = append(w.files, file)
+}
+
+// Start bắt đầu poll các file theo agent.configWatch.intervalSeconds
+func (w *ConfigFileWatcher) Start() {
+	interval := time.Duration(w.configManager.TypedConfig().ConfigWatch.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	w.logger.WithField("interval", interval.String()).Info("👀 Khởi động config file watcher")
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stopCh:
+				return
+			case <-ticker.C:
+				if !w.configManager.TypedConfig().ConfigWatch.Enabled {
+					continue
+				}
+				w.Poll()
+			}
+		}
+	}()
+}
+
+// Stop dừng watcher
+func (w *ConfigFileWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+}
+
+// Poll kiểm tra các file một lần, reload file có nội dung thay đổi
+func (w *ConfigFileWatcher) Poll() {
+	for _, file := range w.files {
+		info, data, err := readWatchedFile(file.path)
+		if err != nil {
+			// File bị xóa hoặc đang được ghi dở → giữ config đang chạy, chờ lần poll sau
+			file.exists = false
+			continue
+		}
+		// Mod time và size không đổi → không cần đọc hash (trường hợp phổ biến)
+		if file.exists && info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+			continue
+		}
+		hash := sha256.Sum256(data)
+		changed := !file.exists || hash != file.hash
+		file.exists = true
+		file.modTime = info.ModTime()
+		file.size = info.Size()
+		file.hash = hash
+		if !changed {
+			continue
+		}
+
+		changes, err := file.onChange(data)
+		if err != nil {
+			w.logger.WithError(err).WithField("file", file.path).Error("❌ File config thay đổi nhưng không hợp lệ, giữ config đang chạy")
+			continue
+		}
+		if len(changes) == 0 {
+			continue // Nội dung giống config đang chạy (ví dụ agent tự lưu file)
+		}
+		w.logger.WithFields(logrus.Fields{
+			"file":    file.path,
+			"changes": changes,
+		}).Info("🔄 Đã hot reload file config")
+	}
+}
+
+// readWatchedFile đọc thông tin và nội dung file
+func readWatchedFile(path string) (os.FileInfo, []byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, data, nil
+}
+
+// reloadAgentConfig apply agent-config.json vừa thay đổi như một config update (validate, lịch sử, probation)
+func (w *ConfigFileWatcher) reloadAgentConfig(data []byte) ([]string, error) {
+	var localConfig struct {
+		Version    int64                  `json:"version"`
+		ConfigData map[string]interface{} `json:"configData"`
+	}
+	if err := json.Unmarshal(data, &localConfig); err != nil {
+		return nil, fmt.Errorf("lỗi parse file config: %w", err)
+	}
+	if localConfig.ConfigData == nil {
+		return nil, fmt.Errorf("file config thiếu configData")
+	}
+
+	cm := w.configManager
+	cm.updateMu.Lock()
+	defer cm.updateMu.Unlock()
+
+	// File do agent tự ghi (SaveLocalConfig) có configData giống config đang chạy
+	newHash := cm.calculateHash(localConfig.ConfigData)
+	if newHash == cm.calculateHash(cm.configData) {
+		return nil, nil
+	}
+
+	previous := cm.snapshotConfig()
+	if err := cm.ApplyFullConfig(localConfig.ConfigData, localConfig.Version, newHash); err != nil {
+		return nil, err
+	}
+	cm.recordAppliedConfig(previous, "local_file")
+	// Config local khác config trên server → gửi full config trong lần check-in tiếp theo
+	cm.MarkNeedSubmitFullConfig()
+
+	return diffConfigValues("", cm.normalizeConfigValues(previous.configData), cm.normalizeConfigValues(cm.configData)), nil
+}
+
+// reloadLogFilterConfig reload file log filter config (file không hợp lệ thì logger giữ config cũ)
+func (w *ConfigFileWatcher) reloadLogFilterConfig(data []byte) ([]string, error) {
+	before := logFilterConfigValues(logger.GetLogFilterConfig())
+	if err := logger.ReloadLogFilterConfig(); err != nil {
+		return nil, err
+	}
+	return diffConfigValues("", before, logFilterConfigValues(logger.GetLogFilterConfig())), nil
+}
+
+// logFilterConfigValues chuyển log filter config về map để so sánh
+func logFilterConfigValues(config *logger.LogFilterConfig) map[string]interface{} {
+	values := make(map[string]interface{})
+	if config == nil {
+		return values
+	}
+	data, _ := json.Marshal(config)
+	json.Unmarshal(data, &values)
+	return values
+}
+
+// StartConfigFileWatcher tạo và khởi động watcher cho các file config local
+func StartConfigFileWatcher(cm *ConfigManager) *ConfigFileWatcher {
+	w := NewConfigFileWatcher(cm)
+	w.Start()
+	return w
+}
@@ -0,0 +1,306 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này tính health status gửi trong check-in từ các rule (ngưỡng trong agent.healthCheck):
+  - Job: thất bại liên tiếp, không chạy thành công quá lâu so với lịch (stale), p95 duration vượt chu kỳ lịch chạy
+  - Upstream: job lỗi network/upstream liên tiếp, check-in lên server thất bại liên tiếp
+  - Đăng nhập: chưa có token FolkForm, token hết hạn, job bị từ chối (unauthorized)
+  - Hệ thống: dung lượng trống của ổ chứa thư mục log, memory/CPU/disk từ SystemInfoCollector
+
+Mỗi rule vi phạm sinh một HealthReason (degraded hoặc unhealthy), health status là mức nặng nhất.
+Job đang paused/disabled không được đánh giá.
+*/
+package services
+
+import (
+	"agent_pancake/app/integrations/apierror"
+	"agent_pancake/app/scheduler"
+	"agent_pancake/global"
+	"agent_pancake/utility/logger"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Các mức health status
+const (
+	HealthHealthy   = "healthy"
+	HealthDegraded  = "degraded"
+	HealthUnhealthy = "unhealthy"
+)
+
+// Giá trị mặc định khi chưa có config agent.healthCheck (config local cũ)
+var defaultHealthCheckConfig = HealthCheckConfig{
+	CPUThreshold:                90,
+	MemoryThreshold:             90,
+	DiskThreshold:               90,
+	JobFailureThreshold:         3,
+	JobFailureCriticalThreshold: 10,
+	StaleIntervalMultiplier:     3,
+	SlowDurationRatio:           1,
+	CheckInFailureThreshold:     3,
+	LogDiskMinFreeMB:            500,
+}
+
+// HealthReason là lý do health không "healthy" (gửi lên server trong healthReasons của check-in)
+type HealthReason struct {
+	Check    string `json:"check"`            // "job_failures", "job_stale", "job_slow", "upstream", "login", "log_disk", "memory", "cpu", "disk"
+	Severity string `json:"severity"`         // "degraded", "unhealthy"
+	Target   string `json:"target,omitempty"` // Tên job hoặc thư mục liên quan (nếu có)
+	Message  string `json:"message"`          // Mô tả dễ đọc
+}
+
+// HealthReport là kết quả đánh giá health
+type HealthReport struct {
+	Status  string
+	Reasons []HealthReason
+}
+
+// healthSeverityRank dùng để chọn mức nặng nhất
+var healthSeverityRank = map[string]int{
+	HealthHealthy:   0,
+	HealthDegraded:  1,
+	HealthUnhealthy: 2,
+}
+
+// add thêm lý do và nâng status nếu lý do nặng hơn
+func (r *HealthReport) add(check, severity, target, message string) {
+	r.Reasons = append(r.Reasons, HealthReason{Check: check, Severity: severity, Target: target, Message: message})
+	if healthSeverityRank[severity] > healthSeverityRank[r.Status] {
+		r.Status = severity
+	}
+}
+
+// healthCheckConfig trả về ngưỡng health, field chưa có (<= 0) dùng giá trị mặc định
+func (s *CheckInService) healthCheckConfig() HealthCheckConfig {
+	cfg := defaultHealthCheckConfig
+	if s.configManager == nil {
+		return cfg
+	}
+	typed := s.configManager.TypedConfig().HealthCheck
+	if typed.CPUThreshold > 0 {
+		cfg.CPUThreshold = typed.CPUThreshold
+	}
+	if typed.MemoryThreshold > 0 {
+		cfg.MemoryThreshold = typed.MemoryThreshold
+	}
+	if typed.DiskThreshold > 0 {
+		cfg.DiskThreshold = typed.DiskThreshold
+	}
+	if typed.JobFailureThreshold > 0 {
+		cfg.JobFailureThreshold = typed.JobFailureThreshold
+	}
+	if typed.JobFailureCriticalThreshold > 0 {
+		cfg.JobFailureCriticalThreshold = typed.JobFailureCriticalThreshold
+	}
+	if typed.StaleIntervalMultiplier > 0 {
+		cfg.StaleIntervalMultiplier = typed.StaleIntervalMultiplier
+	}
+	if typed.SlowDurationRatio > 0 {
+		cfg.SlowDurationRatio = typed.SlowDurationRatio
+	}
+	if typed.CheckInFailureThreshold > 0 {
+		cfg.CheckInFailureThreshold = typed.CheckInFailureThreshold
+	}
+	if typed.LogDiskMinFreeMB > 0 {
+		cfg.LogDiskMinFreeMB = typed.LogDiskMinFreeMB
+	}
+	return cfg
+}
+
+// evaluateHealth đánh giá health của agent từ job metrics, upstream, đăng nhập và system info
+func (s *CheckInService) evaluateHealth(systemInfo SystemInfo) HealthReport {
+	cfg := s.healthCheckConfig()
+	report := HealthReport{Status: HealthHealthy}
+	now := time.Now()
+
+	s.evaluateJobsHealth(&report, cfg, now)
+
+	if failures := s.checkInFailures.Load(); failures >= int64(cfg.CheckInFailureThreshold) {
+		report.add("upstream", HealthUnhealthy, "FolkForm",
+			fmt.Sprintf("%d lần check-in lên server thất bại liên tiếp", failures))
+	}
+
+	evaluateLoginHealth(&report, now)
+	evaluateSystemHealth(&report, cfg, systemInfo)
+	evaluateLogDiskHealth(&report, cfg, logger.LogDir())
+
+	sort.SliceStable(report.Reasons, func(i, j int) bool {
+		return healthSeverityRank[report.Reasons[i].Severity] > healthSeverityRank[report.Reasons[j].Severity]
+	})
+	return report
+}
+
+// evaluateJobsHealth đánh giá các job đang active (bỏ qua job paused/disabled)
+func (s *CheckInService) evaluateJobsHealth(report *HealthReport, cfg HealthCheckConfig, now time.Time) {
+	if s.scheduler == nil {
+		return
+	}
+	registeredJobs := s.scheduler.GetJobs()
+	pausedJobs := s.scheduler.GetPausedJobs()
+	disabledJobs := s.scheduler.GetDisabledJobs()
+
+	// Job chưa thành công lần nào được tính stale từ lúc agent khởi động
+	startedAt := s.systemInfoCollector.startTime
+
+	jobs := s.scheduler.GetAllJobObjects()
+	jobNames := make([]string, 0, len(jobs))
+	for jobName := range jobs {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+
+	for _, jobName := range jobNames {
+		job := jobs[jobName]
+		if _, ok := registeredJobs[jobName]; !ok {
+			continue
+		}
+		if _, ok := pausedJobs[jobName]; ok {
+			continue
+		}
+		if _, ok := disabledJobs[jobName]; ok {
+			continue
+		}
+		metricsProvider, ok := job.(scheduler.MetricsProvider)
+		if !ok {
+			continue
+		}
+		evaluateJobHealth(report, cfg, jobName, job.GetSchedule(), metricsProvider.GetMetrics(), startedAt, now)
+	}
+}
+
+// evaluateJobHealth áp dụng các rule job cho metrics của một job
+func evaluateJobHealth(report *HealthReport, cfg HealthCheckConfig, jobName string, schedule string, metrics scheduler.JobMetrics, startedAt time.Time, now time.Time) {
+	// Thất bại liên tiếp (lỗi đăng nhập/upstream được báo theo nhóm riêng)
+	if failures := metrics.ConsecutiveFailures; failures >= int64(cfg.JobFailureThreshold) {
+		severity := HealthDegraded
+		if failures >= int64(cfg.JobFailureCriticalThreshold) {
+			severity = HealthUnhealthy
+		}
+		check := "job_failures"
+		switch apierror.Category(metrics.LastErrorCategory) {
+		case apierror.CategoryUnauthorized:
+			check = "login"
+		case apierror.CategoryNetwork, apierror.CategoryUpstream:
+			check = "upstream"
+		}
+		report.add(check, severity, jobName,
+			fmt.Sprintf("Job thất bại %d lần liên tiếp: %s", failures, metrics.LastError))
+	}
+
+	shortestGap, longestGap, ok := scheduleGaps(schedule, now)
+	if !ok {
+		return
+	}
+
+	// Không chạy thành công quá lâu so với lịch
+	lastSuccess := metrics.LastSuccessAt
+	if lastSuccess.IsZero() {
+		lastSuccess = startedAt
+	}
+	staleAfter := time.Duration(cfg.StaleIntervalMultiplier * float64(longestGap))
+	if since := now.Sub(lastSuccess); since > staleAfter {
+		message := fmt.Sprintf("Job không chạy thành công %s (ngưỡng %s)", formatHealthDuration(since), formatHealthDuration(staleAfter))
+		if metrics.LastSuccessAt.IsZero() {
+			message = fmt.Sprintf("Job chưa chạy thành công lần nào sau %s kể từ khi agent khởi động", formatHealthDuration(since))
+		}
+		report.add("job_stale", HealthDegraded, jobName, message)
+	}
+
+	// p95 duration vượt chu kỳ lịch chạy → job chạy không kịp lịch (lần chạy tiếp theo bị bỏ qua)
+	p95 := metrics.DurationPercentile(95)
+	limit := cfg.SlowDurationRatio * shortestGap.Seconds()
+	if p95 > 0 && p95 > limit {
+		report.add("job_slow", HealthDegraded, jobName,
+			fmt.Sprintf("p95 thời gian chạy %.0fs vượt %.0fs (chu kỳ lịch chạy %s)", p95, limit, formatHealthDuration(shortestGap)))
+	}
+}
+
+// scheduleGaps trả về khoảng cách ngắn nhất và dài nhất giữa các lần chạy theo lịch trong 7 ngày tới
+// (lịch không đều như "0 0 8-18 * * *" có khoảng nghỉ ban đêm dài hơn chu kỳ ban ngày)
+func scheduleGaps(spec string, now time.Time) (time.Duration, time.Duration, bool) {
+	schedule, err := configCronParser.Parse(spec)
+	if err != nil {
+		return 0, 0, false
+	}
+	horizon := now.Add(7 * 24 * time.Hour)
+	var shortest, longest time.Duration
+	previous := schedule.Next(now)
+	for i := 0; i < 1000 && !previous.IsZero() && previous.Before(horizon); i++ {
+		next := schedule.Next(previous)
+		if next.IsZero() {
+			break
+		}
+		gap := next.Sub(previous)
+		if shortest == 0 || gap < shortest {
+			shortest = gap
+		}
+		if gap > longest {
+			longest = gap
+		}
+		previous = next
+	}
+	return shortest, longest, shortest > 0
+}
+
+// evaluateLoginHealth kiểm tra trạng thái đăng nhập FolkForm
+func evaluateLoginHealth(report *HealthReport, now time.Time) {
+	token, expiresAt := global.GetApiTokenWithExpiry()
+	if token == "" {
+		report.add("login", HealthUnhealthy, "FolkForm", "Chưa đăng nhập FolkForm (không có token)")
+		return
+	}
+	if !expiresAt.IsZero() && now.After(expiresAt) {
+		report.add("login", HealthDegraded, "FolkForm",
+			fmt.Sprintf("Token FolkForm đã hết hạn lúc %s, chưa đăng nhập lại", expiresAt.Format("2006-01-02 15:04:05")))
+	}
+}
+
+// evaluateSystemHealth so sánh memory/CPU/disk (%) với ngưỡng
+// Memory vượt quá nửa phần còn lại tới 100% (ví dụ ngưỡng 90 → 95%) là unhealthy
+func evaluateSystemHealth(report *HealthReport, cfg HealthCheckConfig, systemInfo SystemInfo) {
+	if systemInfo.MemoryUsage > cfg.MemoryThreshold {
+		severity := HealthDegraded
+		if systemInfo.MemoryUsage > (cfg.MemoryThreshold+100)/2 {
+			severity = HealthUnhealthy
+		}
+		report.add("memory", severity, "",
+			fmt.Sprintf("Memory sử dụng %.1f%% vượt ngưỡng %.0f%%", systemInfo.MemoryUsage, cfg.MemoryThreshold))
+	}
+	if systemInfo.CPUUsage > cfg.CPUThreshold {
+		report.add("cpu", HealthDegraded, "",
+			fmt.Sprintf("CPU sử dụng %.1f%% vượt ngưỡng %.0f%%", systemInfo.CPUUsage, cfg.CPUThreshold))
+	}
+	if systemInfo.DiskUsage > cfg.DiskThreshold {
+		report.add("disk", HealthDegraded, "/",
+			fmt.Sprintf("Disk sử dụng %.1f%% vượt ngưỡng %.0f%%", systemInfo.DiskUsage, cfg.DiskThreshold))
+	}
+}
+
+// evaluateLogDiskHealth kiểm tra dung lượng trống của ổ chứa thư mục log
+// Hết chỗ ghi log thì không còn log để điều tra → unhealthy
+func evaluateLogDiskHealth(report *HealthReport, cfg HealthCheckConfig, logDir string) {
+	usage, err := disk.Usage(logDir)
+	if err != nil {
+		return // Thư mục log chưa tạo (file logging tắt)
+	}
+	freeMB := usage.Free / 1024 / 1024
+	if freeMB < uint64(cfg.LogDiskMinFreeMB) {
+		report.add("log_disk", HealthUnhealthy, logDir,
+			fmt.Sprintf("Ổ chứa thư mục log chỉ còn %d MB trống (tối thiểu %d MB)", freeMB, cfg.LogDiskMinFreeMB))
+	}
+}
+
+// formatHealthDuration định dạng duration gọn cho message (ví dụ "2h30m0s" → "2h30m", "72h0m0s" → "72h")
+func formatHealthDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	text := strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
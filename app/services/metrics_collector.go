@@ -5,6 +5,7 @@ File này thu thập metrics từ scheduler và jobs.
 package services
 
 import (
+	"agent_pancake/app/integrations/apierror"
 	"agent_pancake/app/scheduler"
 	"time"
 )
@@ -31,6 +32,10 @@ type AgentMetrics struct {
 	AvgJobDuration float64 `json:"avgJobDuration"`
 	TotalAPICalls  int64   `json:"totalAPICalls"`
 	FailedAPICalls int64   `json:"failedAPICalls"`
+	// Chi phí AI cộng dồn từ lúc agent khởi động (nil nếu chưa có AI call nào)
+	AISpend *AISpendMetrics `json:"aiSpend,omitempty"`
+	// Worker đang chạy, hàng đợi của workflow commands
+	WorkflowWorkers *WorkflowWorkerMetrics `json:"workflowWorkers,omitempty"`
 }
 
 // JobStatus chứa trạng thái và metrics của một job
@@ -58,6 +63,8 @@ type JobStatus struct {
 	Color       string   `json:"color,omitempty"`       // Màu sắc của job
 	Category    string   `json:"category,omitempty"`    // Danh mục của job
 	Tags        []string `json:"tags,omitempty"`        // Tags của job
+	// Kết quả theo từng organization (chỉ có khi agent phục vụ nhiều organization - AGENT_ROLE_IDS)
+	Organizations []scheduler.OrganizationResult `json:"organizations,omitempty"`
 }
 
 // JobError chứa thông tin lỗi của một job
@@ -66,6 +73,7 @@ type JobError struct {
 	OccurredAt int64   `json:"occurredAt"`         // Thời điểm lỗi xảy ra (Unix timestamp)
 	RunCount   int64   `json:"runCount,omitempty"` // Số lần chạy tại thời điểm lỗi
 	Duration   float64 `json:"duration,omitempty"` // Thời gian chạy tại thời điểm lỗi (giây)
+	Category   string  `json:"category,omitempty"` // Nhóm lỗi (apierror.Category): unauthorized, rate_limited, not_found, validation, network, upstream, unknown
 }
 
 // ErrorReport chứa thông tin lỗi (dùng cho system errors, không phải job errors)
@@ -155,6 +163,9 @@ func (m *MetricsCollector) CollectJobStatuses() []JobStatus {
 			// Trong tương lai có thể cải thiện BaseJob để lưu error history
 			status.Errors = m.collectJobErrors(jobName, metrics)
 
+			// Kết quả theo organization context (sync job chạy cho từng role)
+			status.Organizations = scheduler.GetOrganizationResults(jobName)
+
 			// NextRunAt: Có thể tính từ cron schedule, nhưng tạm thời để 0
 			// TODO: Tính next run time từ cron schedule
 			status.NextRunAt = 0
@@ -232,6 +243,10 @@ func (m *MetricsCollector) CollectBotMetrics() AgentMetrics {
 	metrics.TotalAPICalls = 0
 	metrics.FailedAPICalls = 0
 
+	// Chi phí AI (token, cost theo provider/model) từ các workflow commands
+	metrics.AISpend = GetAISpendMetrics()
+	metrics.WorkflowWorkers = GetWorkflowWorkerPool().Metrics()
+
 	return metrics
 }
 
@@ -256,7 +271,7 @@ func (m *MetricsCollector) CollectErrors() []ErrorReport {
 				// Kiểm tra xem lỗi có gần đây không (trong vòng 1 giờ)
 				if time.Since(metrics.LastRunAt) < time.Hour {
 					errors = append(errors, ErrorReport{
-						Type:       "job_error",
+						Type:       errorReportType(metrics.LastErrorCategory),
 						Message:    metrics.LastError,
 						OccurredAt: metrics.LastRunAt.Unix(),
 						Context: map[string]interface{}{
@@ -264,6 +279,8 @@ func (m *MetricsCollector) CollectErrors() []ErrorReport {
 							"runCount":        metrics.RunCount,
 							"errorCount":      metrics.ErrorCount,
 							"lastRunDuration": metrics.LastRunDuration,
+							"category":        metrics.LastErrorCategory,
+							"retryable":       isRetryableCategory(metrics.LastErrorCategory),
 						},
 					})
 				}
@@ -274,6 +291,25 @@ func (m *MetricsCollector) CollectErrors() []ErrorReport {
 	return errors
 }
 
+// errorReportType xác định Type của ErrorReport từ nhóm lỗi của job
+// Lỗi có nhóm cụ thể (đến từ integration) là "api_error", còn lại là "job_error"
+func errorReportType(category string) string {
+	if category == "" || category == string(apierror.CategoryUnknown) {
+		return "job_error"
+	}
+	return "api_error"
+}
+
+// isRetryableCategory cho biết nhóm lỗi có tự hết khi job chạy lại không
+// (rate limit, lỗi mạng, lỗi upstream 5xx) - giúp server phân biệt lỗi tạm thời với lỗi cần can thiệp
+func isRetryableCategory(category string) bool {
+	switch apierror.Category(category) {
+	case apierror.CategoryRateLimited, apierror.CategoryNetwork, apierror.CategoryUpstream:
+		return true
+	}
+	return false
+}
+
 // JobMetadata chứa metadata của job (theo API v3.14)
 type JobMetadata struct {
 	DisplayName string
@@ -426,41 +462,16 @@ func (m *MetricsCollector) getJobMetadataFromConfig(jobName string) JobMetadata
 func (m *MetricsCollector) collectJobErrors(jobName string, metrics scheduler.JobMetrics) []JobError {
 	errors := make([]JobError, 0)
 
-	// Lấy max errors từ config (default: 10)
+	// Lấy max errors và error retention hours từ config agent.errorReporting (default: 10, 24)
 	maxErrors := 10
-	if m.configManager != nil {
-		// Lấy từ agent.errorReporting.maxErrorsPerCheckIn
-		if agentConfig, ok := m.configManager.GetConfigData()["agent"].(map[string]interface{}); ok {
-			if errorReportingConfig, ok := agentConfig["errorReporting"].(map[string]interface{}); ok {
-				if maxErrorsField, ok := errorReportingConfig["maxErrorsPerCheckIn"]; ok {
-					// Extract value từ config field (có thể là map với "value" hoặc trực tiếp là số)
-					if maxErrorsMap, ok := maxErrorsField.(map[string]interface{}); ok {
-						if value, ok := maxErrorsMap["value"].(float64); ok {
-							maxErrors = int(value)
-						}
-					} else if value, ok := maxErrorsField.(float64); ok {
-						maxErrors = int(value)
-					}
-				}
-			}
-		}
-	}
-
-	// Lấy error retention hours từ config (default: 24)
 	errorRetentionHours := 24
 	if m.configManager != nil {
-		if agentConfig, ok := m.configManager.GetConfigData()["agent"].(map[string]interface{}); ok {
-			if errorReportingConfig, ok := agentConfig["errorReporting"].(map[string]interface{}); ok {
-				if retentionField, ok := errorReportingConfig["errorRetentionHours"]; ok {
-					if retentionMap, ok := retentionField.(map[string]interface{}); ok {
-						if value, ok := retentionMap["value"].(float64); ok {
-							errorRetentionHours = int(value)
-						}
-					} else if value, ok := retentionField.(float64); ok {
-						errorRetentionHours = int(value)
-					}
-				}
-			}
+		errorReporting := m.configManager.TypedConfig().ErrorReporting
+		if errorReporting.MaxErrorsPerCheckIn > 0 {
+			maxErrors = errorReporting.MaxErrorsPerCheckIn
+		}
+		if errorReporting.ErrorRetentionHours > 0 {
+			errorRetentionHours = errorReporting.ErrorRetentionHours
 		}
 	}
 
@@ -473,6 +484,7 @@ func (m *MetricsCollector) collectJobErrors(jobName string, metrics scheduler.Jo
 				OccurredAt: metrics.LastRunAt.Unix(),
 				RunCount:   metrics.RunCount,
 				Duration:   metrics.LastRunDuration,
+				Category:   metrics.LastErrorCategory,
 			})
 		}
 	}
@@ -0,0 +1,96 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa ProgressTracker - lưu tiến độ hiện tại của một workflow command để gửi lên server qua heartbeat
+*/
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressTracker lưu tiến độ của một workflow command (step, percentage, message)
+// Executor cập nhật tiến độ, goroutine heartbeat đọc Snapshot để gửi lên server.
+// Mỗi step được gán một khoảng phần trăm (SetRange); StepExecutor báo tiến độ 0-100 trong step
+// và tracker quy đổi ra phần trăm của cả command. Phần trăm không bao giờ giảm.
+// Các method an toàn khi tracker là nil (không làm gì).
+type ProgressTracker struct {
+	mu         sync.Mutex
+	step       string
+	percentage int
+	message    string
+	rangeFrom  int
+	rangeTo    int
+	version    uint64 // Tăng mỗi lần tiến độ thay đổi, dùng để chỉ gửi heartbeat khi có thay đổi
+	updatedAt  time.Time
+}
+
+// NewProgressTracker tạo tracker mới với khoảng 0-100
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{rangeTo: 100}
+}
+
+// SetRange gán khoảng phần trăm [from, to] của command cho các lần Update tiếp theo
+func (p *ProgressTracker) SetRange(from, to int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	from, to = clampPercentage(from), clampPercentage(to)
+	if to < from {
+		to = from
+	}
+	p.rangeFrom, p.rangeTo = from, to
+}
+
+// Update cập nhật tiến độ; localPercentage (0-100) là tiến độ trong khoảng hiện tại
+func (p *ProgressTracker) Update(step string, localPercentage int, message string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	percentage := p.rangeFrom + (p.rangeTo-p.rangeFrom)*clampPercentage(localPercentage)/100
+	if percentage < p.percentage {
+		percentage = p.percentage
+	}
+	if step == p.step && percentage == p.percentage && message == p.message {
+		return
+	}
+	p.step = step
+	p.percentage = percentage
+	p.message = message
+	p.version++
+	p.updatedAt = time.Now()
+}
+
+// Snapshot trả về tiến độ hiện tại theo format progress của heartbeat (step, percentage, message)
+// cùng version để người gọi biết tiến độ có thay đổi kể từ lần gửi trước không
+func (p *ProgressTracker) Snapshot() (map[string]interface{}, uint64) {
+	if p == nil {
+		return nil, 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	progress := map[string]interface{}{
+		"step":       p.step,
+		"percentage": p.percentage,
+		"message":    p.message,
+	}
+	if !p.updatedAt.IsZero() {
+		progress["updatedAt"] = p.updatedAt.Format(time.RFC3339)
+	}
+	return progress, p.version
+}
+
+func clampPercentage(percentage int) int {
+	if percentage < 0 {
+		return 0
+	}
+	if percentage > 100 {
+		return 100
+	}
+	return percentage
+}
@@ -6,7 +6,10 @@ package services
 
 import (
 	"agent_pancake/app/integrations"
-	"encoding/json"
+	"agent_pancake/app/integrations/apierror"
+	"agent_pancake/utility/secrets"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -25,6 +28,11 @@ type StepResult struct {
 // StepExecutor là service để thực thi step
 type StepExecutor struct {
 	aiClient *AIClientService
+	progress *ProgressTracker // Tiến độ của command đang chạy (nil = không báo tiến độ, không stream)
+	budget    *AIBudget        // Ngân sách AI của workflow run (nil = không giới hạn)
+	cacheMode AICacheMode      // Cách dùng cache response AI (theo params của command)
+	ctx       context.Context  // Hủy khi command bị hủy từ server (nil = không hủy được)
+	resumeStepRunID string     // Step run đang chờ duyệt của lần chạy trước (chạy tiếp thay vì tạo step run mới)
 }
 
 // NewStepExecutor tạo một instance mới của StepExecutor
@@ -34,6 +42,44 @@ func NewStepExecutor(aiClient *AIClientService) *StepExecutor {
 	}
 }
 
+// WithProgress gắn tracker để báo tiến độ step (bao gồm tiến độ sinh khi gọi AI ở chế độ stream)
+func (e *StepExecutor) WithProgress(progress *ProgressTracker) *StepExecutor {
+	e.progress = progress
+	return e
+}
+
+// WithBudget gắn ngân sách AI của workflow run, chi phí của mỗi AI call được cộng vào budget
+func (e *StepExecutor) WithBudget(budget *AIBudget) *StepExecutor {
+	e.budget = budget
+	return e
+}
+
+// WithCacheMode đặt cách dùng cache response AI cho các AI call của step (xem AICacheModeFromParams)
+func (e *StepExecutor) WithCacheMode(mode AICacheMode) *StepExecutor {
+	e.cacheMode = mode
+	return e
+}
+
+// WithContext gắn context của command, hủy context thì AI call đang chạy bị dừng và step kết thúc với trạng thái "cancelled"
+func (e *StepExecutor) WithContext(ctx context.Context) *StepExecutor {
+	e.ctx = ctx
+	return e
+}
+
+// WithResumeStepRun chạy tiếp step run đã tạo từ lần chạy trước (step đang chờ duyệt) thay vì tạo step run mới
+func (e *StepExecutor) WithResumeStepRun(stepRunID string) *StepExecutor {
+	e.resumeStepRunID = stepRunID
+	return e
+}
+
+// cancelled trả về lỗi nếu command đã bị hủy
+func (e *StepExecutor) cancelled() error {
+	if e.ctx == nil {
+		return nil
+	}
+	return e.ctx.Err()
+}
+
 // ExecuteStep thực thi một step
 // Tham số:
 // - stepId: ID của step
@@ -51,8 +97,13 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 	log.Printf("[StepExecutor] WorkflowRunId: %s", workflowRunId)
 	log.Printf("[StepExecutor] ========================================")
 
+	if err := e.cancelled(); err != nil {
+		return nil, err
+	}
+
 	// 1. Load step definition (chỉ để lấy stepType, inputSchema, outputSchema)
 	log.Printf("[StepExecutor] [1/11] Đang load step definition từ backend...")
+	e.progress.Update("preparing_step", 0, fmt.Sprintf("Đang chuẩn bị step: %s", stepId))
 	stepResp, err := integrations.FolkForm_GetStep(stepId)
 	if err != nil {
 		log.Printf("[StepExecutor] ❌ Lỗi khi load step: %v", err)
@@ -72,9 +123,41 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 	log.Printf("[StepExecutor] ✅ Đã load step definition")
 	log.Printf("[StepExecutor] StepType: %s", stepType)
 
+	// Handler theo loại step (xem step_handlers.go), loại chưa đăng ký chỉ chạy AI và lưu output
+	handler := GetStepHandler(stepType)
+	if handler == nil {
+		log.Printf("[StepExecutor] ⚠️  Step type %s chưa có handler, chỉ gọi AI và lưu output", stepType)
+	}
+
 	// 2. Chuẩn bị input data và variables cho render-prompt
 	log.Printf("[StepExecutor] [2/11] Đang chuẩn bị input data và variables...")
 	stepInput := e.prepareStepInput(parentId, parentType, parentContent, inputSchema)
+
+	stepConfig, _ := stepData["config"].(map[string]interface{})
+	if stepConfig == nil {
+		stepConfig = map[string]interface{}{}
+	}
+	sc := &StepContext{
+		Executor:      e,
+		StepID:        stepId,
+		StepType:      stepType,
+		Step:          stepData,
+		Config:        stepConfig,
+		ParentID:      parentId,
+		ParentType:    parentType,
+		ParentContent: parentContent,
+		WorkflowRunID: workflowRunId,
+		Input:         stepInput,
+	}
+	if handler != nil {
+		if err := handler.ValidateInput(sc); err != nil {
+			log.Printf("[StepExecutor] ❌ Input của step không hợp lệ: %v", err)
+			return nil, fmt.Errorf("input của %s step không hợp lệ: %w", stepType, err)
+		}
+		if !handler.UsesAI() {
+			return e.executeLocalStep(handler, sc, outputSchema)
+		}
+	}
 	
 	// Chuẩn bị variables từ stepInput và parentContent để gửi cho render-prompt API
 	variables := e.prepareVariablesForRenderPrompt(stepInput, parentContent)
@@ -129,33 +212,21 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 
 	// 4. Load provider profile để lấy API key và config
 	log.Printf("[StepExecutor] [4/11] Đang load provider profile từ backend...")
-	providerResp, err := integrations.FolkForm_GetProviderProfile(providerProfileId)
+	providerProfile, err := loadProviderProfile(providerProfileId)
 	if err != nil {
-		log.Printf("[StepExecutor] ❌ Lỗi khi load provider profile: %v", err)
-		return nil, fmt.Errorf("lỗi khi load provider profile: %v", err)
-	}
-
-	providerData, ok := providerResp["data"].(map[string]interface{})
-	if !ok {
-		log.Printf("[StepExecutor] ❌ Provider profile response không hợp lệ")
-		return nil, fmt.Errorf("provider profile response không hợp lệ")
-	}
-
-	providerProfile := &AIProviderProfile{
-		ID:                getString(providerData, "id"),
-		Name:              getString(providerData, "name"),
-		Provider:          getString(providerData, "provider"),
-		APIKey:            getString(providerData, "apiKey"),
-		BaseURL:           getString(providerData, "baseUrl"),
-		OrganizationID:    getString(providerData, "organizationId"),
-		DefaultModel:      getString(providerData, "defaultModel"),
-		DefaultTemperature: getFloat64Ptr(providerData, "defaultTemperature"),
-		DefaultMaxTokens:   getIntPtr(providerData, "defaultMaxTokens"),
+		log.Printf("[StepExecutor] ❌ %v", err)
+		return nil, err
 	}
 
 	log.Printf("[StepExecutor] ✅ Đã load provider profile")
 	log.Printf("[StepExecutor] ProviderName: %s, ProviderType: %s", providerProfile.Name, providerProfile.Provider)
 
+	// Chuỗi provider dự phòng: cấu hình theo step (render-prompt / step definition) hoặc theo provider profile
+	fallbacks := e.resolveFallbackTargets(renderData, stepData, providerProfile)
+	if len(fallbacks) > 0 {
+		log.Printf("[StepExecutor] Fallback chain: %d provider dự phòng", len(fallbacks))
+	}
+
 	// 5. Tạo step run record
 	log.Printf("[StepExecutor] [5/11] Đang tạo step run record trong backend...")
 	stepRunResp, err := integrations.FolkForm_CreateStepRun(workflowRunId, stepId, stepInput)
@@ -209,59 +280,145 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 	}
 	log.Printf("[StepExecutor] Model: %s", modelToUse)
 	
+	// Step có outputSchema dạng JSON Schema → bật structured output của provider và validate output
+	responseSchema := outputSchemaForValidation(outputSchema)
 	aiReq := AICallRequest{
 		ProviderProfile: providerProfile,
 		Model:           modelToUse,
 		Prompt:          promptText,
 		Temperature:     temperature, // Từ render-prompt response
 		MaxTokens:       maxTokens,   // Từ render-prompt response
+		ResponseSchema:  responseSchema,
+		CacheMode:       e.cacheMode,
+		Context:         e.ctx,
+	}
+
+	e.progress.Update("calling_ai", 10, fmt.Sprintf("Đang gọi AI (%s, %s)...", providerProfile.Provider, modelToUse))
+	aiResp, attempts, err := e.callAI(aiReq, fallbacks)
+	if err != nil && e.cancelled() != nil {
+		log.Printf("[StepExecutor] ⛔ Step bị hủy khi đang gọi AI: %v", context.Cause(e.ctx))
+		cancelFields := map[string]interface{}{
+			"attempts": attempts,
+			"error":    context.Cause(e.ctx).Error(),
+		}
+		_, _ = integrations.FolkForm_UpdateAIRun(aiRunID, "", 0, 0, "cancelled", cancelFields)
+		_, _ = integrations.FolkForm_UpdateStepRun(stepRunID, nil, "cancelled")
+		return nil, fmt.Errorf("step bị hủy: %w", e.cancelled())
 	}
-
-	aiResp, err := e.aiClient.Call(aiReq)
 	if err != nil {
 		log.Printf("[StepExecutor] ❌ Lỗi khi gọi AI API: %v", err)
-		_, _ = integrations.FolkForm_UpdateAIRun(aiRunID, "", 0, 0, "failed")
-		return nil, fmt.Errorf("lỗi khi gọi AI API: %v", err)
-	}
-
-	if aiResp.Error != nil {
-		log.Printf("[StepExecutor] ❌ AI API trả về lỗi: %v", aiResp.Error)
-		_, _ = integrations.FolkForm_UpdateAIRun(aiRunID, aiResp.Content, 0, aiResp.Latency.Milliseconds(), "failed")
-		return nil, fmt.Errorf("AI API trả về lỗi: %v", aiResp.Error)
+		_, _ = integrations.FolkForm_UpdateAIRun(aiRunID, "", 0, 0, "failed", map[string]interface{}{
+			"attempts": attempts,
+			"error":    err.Error(),
+		})
+		return nil, fmt.Errorf("lỗi khi gọi AI API: %w", err)
 	}
 
 	log.Printf("[StepExecutor] ✅ AI API call thành công!")
-	log.Printf("[StepExecutor] Latency: %v", aiResp.Latency)
-	log.Printf("[StepExecutor] Response length: %d chars", len(aiResp.Content))
-	if aiResp.Usage != nil {
-		log.Printf("[StepExecutor] Token usage - Prompt: %d, Completion: %d, Total: %d", 
-			aiResp.Usage.PromptTokens, aiResp.Usage.CompletionTokens, aiResp.Usage.TotalTokens)
-	}
-	log.Printf("[StepExecutor] FinishReason: %s", aiResp.FinishReason)
-	log.Printf("[StepExecutor] Response preview (first 200 chars): %s", truncateString(aiResp.Content, 200))
-
-	// 9. Tính cost (tạm thời return 0, có thể tính từ pricing config sau)
-	cost := 0.0
-	log.Printf("[StepExecutor] Cost: $%.4f (tạm thời = 0)", cost)
+	e.logAIResponse(aiResp)
+	cost := e.accountAICall(providerProfile, fallbacks, aiResp)
+	totalCost := cost.Amount
+	totalLatency := aiResp.Latency
+	budgetErr := e.budget.Add(cost.Amount)
+
+	// 8. Parse AI response theo output schema, output sai schema thì gửi lại model kèm lỗi để sửa
+	log.Printf("[StepExecutor] [8/11] Đang parse AI response theo output schema...")
+	e.progress.Update("processing_output", 90, fmt.Sprintf("Đang xử lý kết quả AI của step: %s", stepId))
+	// Lấy templateType từ render response (đã lấy ở bước 3), handler kiểm tra thêm output theo loại step
+	parseOutput := func(content string) (map[string]interface{}, error) {
+		parsed, err := e.parseAIResponse(content, outputSchema, templateType)
+		if err == nil && handler != nil {
+			err = handler.ValidateOutput(sc, parsed)
+		}
+		return parsed, err
+	}
+	parsedOutput, outputErr := parseOutput(aiResp.Content)
+	maxRepairs := schemaRepairAttempts(renderData, stepData)
+	repairs := 0
+	var schemaErr *StructuredOutputError
+	for budgetErr == nil && errors.As(outputErr, &schemaErr) && repairs < maxRepairs {
+		repairs++
+		log.Printf("[StepExecutor] ⚠️  Output không khớp schema (%d lỗi), yêu cầu model sửa lần %d/%d", len(schemaErr.Errors), repairs, maxRepairs)
+		for _, validationErr := range schemaErr.Errors {
+			log.Printf("[StepExecutor]    - %s", validationErr)
+		}
+		e.progress.Update("repairing_output", 90, fmt.Sprintf("Output chưa đúng schema, đang yêu cầu AI sửa (lần %d/%d)", repairs, maxRepairs))
 
-	// 8. Update AI run record
-	log.Printf("[StepExecutor] [8/11] Đang update AI run record với response...")
-	_, err = integrations.FolkForm_UpdateAIRun(aiRunID, aiResp.Content, cost, aiResp.Latency.Milliseconds(), "completed")
+		repairReq := buildSchemaRepairRequest(aiReq, aiResp.Content, schemaErr.Errors, outputSchema)
+		repairResp, repairAttempts, err := e.callAI(repairReq, fallbacks)
+		attempts = append(attempts, repairAttempts...)
+		if err != nil {
+			log.Printf("[StepExecutor] ❌ Lỗi khi gọi AI để sửa output: %v", err)
+			break
+		}
+		aiResp = repairResp
+		e.logAIResponse(aiResp)
+		repairCost := e.accountAICall(providerProfile, fallbacks, aiResp)
+		totalCost += repairCost.Amount
+		totalLatency += aiResp.Latency
+		budgetErr = e.budget.Add(repairCost.Amount)
+		parsedOutput, outputErr = parseOutput(aiResp.Content)
+	}
+
+	// 9. Update AI run record (response cuối cùng, tổng cost/latency của cả các lần sửa)
+	log.Printf("[StepExecutor] [9/11] Đang update AI run record với response...")
+	aiRunStatus := "completed"
+	aiRunExtra := map[string]interface{}{
+		"provider":          aiResp.Provider,
+		"providerProfileId": aiResp.ProviderProfileID,
+		"model":             aiResp.Model,
+		"attempts":          attempts,
+		"fallbackUsed":      aiResp.ProviderProfileID != providerProfile.ID,
+		"usage":             aiResp.Usage,
+		"costPriced":        cost.Priced,
+		"structuredOutput":  nativeStructuredOutput(aiReq),
+		"schemaRepairs":     repairs,
+		"cacheHit":          aiResp.CacheHit,
+	}
+	if aiResp.CacheKey != "" {
+		aiRunExtra["cacheKey"] = aiResp.CacheKey
+	}
+	if cost.Priced {
+		aiRunExtra["pricing"] = map[string]interface{}{
+			"key":         cost.PriceKey,
+			"input":       cost.Price.Input,
+			"output":      cost.Price.Output,
+			"cachedInput": cost.Price.CachedInput,
+			"cacheWrite":  cost.Price.CacheWrite,
+		}
+	}
+	if errors.As(outputErr, &schemaErr) {
+		aiRunStatus = "failed"
+		aiRunExtra["validationErrors"] = schemaErr.Errors
+		aiRunExtra["error"] = schemaErr.Error()
+	}
+	// Bị hủy trong lúc sửa output
+	cancelErr := e.cancelled()
+	if cancelErr != nil {
+		aiRunStatus = "cancelled"
+		aiRunExtra["error"] = context.Cause(e.ctx).Error()
+	}
+	_, err = integrations.FolkForm_UpdateAIRun(aiRunID, aiResp.Content, totalCost, totalLatency.Milliseconds(), aiRunStatus, aiRunExtra)
 	if err != nil {
 		log.Printf("[StepExecutor] ⚠️  Lỗi khi update AI run: %v", err)
 	} else {
 		log.Printf("[StepExecutor] ✅ Đã update AI run record")
 	}
 
-	// 9. Parse AI response theo output schema
-	log.Printf("[StepExecutor] [9/11] Đang parse AI response theo output schema...")
-	// Lấy templateType từ render response (đã lấy ở bước 3)
-	parsedOutput, err := e.parseAIResponse(aiResp.Content, outputSchema, templateType)
-	if err != nil {
-		log.Printf("[StepExecutor] ❌ Lỗi khi parse AI response: %v", err)
-		return nil, fmt.Errorf("lỗi khi parse AI response: %v", err)
+	if cancelErr != nil {
+		_, _ = integrations.FolkForm_UpdateStepRun(stepRunID, nil, "cancelled")
+		return nil, fmt.Errorf("step bị hủy: %w", cancelErr)
 	}
-	
+	// Vượt ngân sách của workflow run → dừng ngay, không xử lý output
+	if budgetErr != nil {
+		log.Printf("[StepExecutor] ❌ %v", budgetErr)
+		return nil, budgetErr
+	}
+	if outputErr != nil {
+		log.Printf("[StepExecutor] ❌ Lỗi khi parse AI response (sau %d lần sửa): %v", repairs, outputErr)
+		return nil, fmt.Errorf("lỗi khi parse AI response: %w", outputErr)
+	}
+
 	// Thêm model và tokens vào parsed output nếu chưa có
 	if _, ok := parsedOutput["model"]; !ok {
 		parsedOutput["model"] = aiResp.Model
@@ -273,18 +430,7 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 			"total":    aiResp.Usage.TotalTokens,
 		}
 	}
-	
-	// Thêm generatedAt/judgedAt nếu chưa có (tùy theo step type)
-	if stepType == "GENERATE" {
-		if _, ok := parsedOutput["generatedAt"]; !ok {
-			parsedOutput["generatedAt"] = time.Now().Format(time.RFC3339)
-		}
-	} else if stepType == "JUDGE" {
-		if _, ok := parsedOutput["judgedAt"]; !ok {
-			parsedOutput["judgedAt"] = time.Now().Format(time.RFC3339)
-		}
-	}
-	
+
 	log.Printf("[StepExecutor] ✅ Đã parse AI response thành công")
 	log.Printf("[StepExecutor] Parsed output keys: %v", getMapKeys(parsedOutput))
 
@@ -293,24 +439,14 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 	var draftNodeID string
 	var selectedCandidateID string
 
-	if stepType == "GENERATE" {
-		log.Printf("[StepExecutor] Xử lý GENERATE step...")
-		// Tạo generation batch và candidates
-		draftNodeID, selectedCandidateID, err = e.handleGenerateStep(stepRunID, aiRunID, parsedOutput, parentType)
-		if err != nil {
-			log.Printf("[StepExecutor] ❌ Lỗi khi handle GENERATE step: %v", err)
-			return nil, fmt.Errorf("lỗi khi handle GENERATE step: %v", err)
-		}
-		log.Printf("[StepExecutor] ✅ GENERATE step hoàn thành - DraftNodeID: %s, SelectedCandidateID: %s", draftNodeID, selectedCandidateID)
-	} else if stepType == "JUDGE" {
-		log.Printf("[StepExecutor] Xử lý JUDGE step...")
-		// Judge candidates và select best
-		selectedCandidateID, err = e.handleJudgeStep(stepRunID, aiRunID, parsedOutput, parentId)
-		if err != nil {
-			log.Printf("[StepExecutor] ❌ Lỗi khi handle JUDGE step: %v", err)
-			return nil, fmt.Errorf("lỗi khi handle JUDGE step: %v", err)
+	if handler != nil {
+		log.Printf("[StepExecutor] Xử lý %s step...", stepType)
+		sc.StepRunID, sc.AIRunID, sc.Output = stepRunID, aiRunID, parsedOutput
+		if err := handler.Execute(sc); err != nil {
+			log.Printf("[StepExecutor] ❌ Lỗi khi handle %s step: %v", stepType, err)
+			return nil, fmt.Errorf("lỗi khi handle %s step: %v", stepType, err)
 		}
-		log.Printf("[StepExecutor] ✅ JUDGE step hoàn thành - SelectedCandidateID: %s", selectedCandidateID)
+		draftNodeID, selectedCandidateID, parsedOutput = sc.DraftNodeID, sc.SelectedCandidateID, sc.Output
 	} else {
 		log.Printf("[StepExecutor] ⚠️  Step type không được xử lý: %s", stepType)
 	}
@@ -325,6 +461,7 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 	}
 
 	log.Printf("[StepExecutor] ✅ HOÀN THÀNH EXECUTE STEP")
+	e.progress.Update("step_completed", 100, fmt.Sprintf("Step đã hoàn thành: %s", stepId))
 	log.Printf("[StepExecutor] StepRunID: %s", stepRunID)
 	if draftNodeID != "" {
 		log.Printf("[StepExecutor] DraftNodeID: %s", draftNodeID)
@@ -342,6 +479,247 @@ func (e *StepExecutor) ExecuteStep(stepId, parentId, parentType, workflowRunId s
 	}, nil
 }
 
+// executeLocalStep chạy step không dùng AI: tạo step run, handler tạo output, validate output theo outputSchema
+func (e *StepExecutor) executeLocalStep(handler StepHandler, sc *StepContext, outputSchema map[string]interface{}) (*StepResult, error) {
+	if e.resumeStepRunID != "" {
+		sc.StepRunID = e.resumeStepRunID
+		sc.Resumed = true
+		log.Printf("[StepExecutor] [3/4] Chạy tiếp step run %s của lần chạy trước", sc.StepRunID)
+	} else {
+		log.Printf("[StepExecutor] [3/4] Đang tạo step run record trong backend...")
+		stepRunResp, err := integrations.FolkForm_CreateStepRun(sc.WorkflowRunID, sc.StepID, sc.Input)
+		if err != nil {
+			log.Printf("[StepExecutor] ❌ Lỗi khi tạo step run: %v", err)
+			return nil, fmt.Errorf("lỗi khi tạo step run: %v", err)
+		}
+		stepRunData, _ := stepRunResp["data"].(map[string]interface{})
+		sc.StepRunID = getString(stepRunData, "id")
+		if sc.StepRunID == "" {
+			log.Printf("[StepExecutor] ❌ Step run không có ID")
+			return nil, fmt.Errorf("step run không có ID")
+		}
+		log.Printf("[StepExecutor] ✅ Đã tạo step run: %s", sc.StepRunID)
+	}
+
+	log.Printf("[StepExecutor] [4/4] Đang xử lý %s step (không dùng AI)...", sc.StepType)
+	e.progress.Update("running_step", 10, fmt.Sprintf("Đang chạy %s step: %s", sc.StepType, sc.StepID))
+	err := handler.Execute(sc)
+	// Step chờ duyệt: step run giữ trạng thái "waiting_review", workflow tạm dừng
+	var waitingErr *StepWaitingReviewError
+	if errors.As(err, &waitingErr) {
+		return nil, err
+	}
+	if err == nil {
+		if sc.Output == nil {
+			sc.Output = map[string]interface{}{}
+		}
+		if schema := outputSchemaForValidation(outputSchema); schema != nil {
+			if validationErrors := validateJSONSchema(schema, sc.Output); len(validationErrors) > 0 {
+				err = &StructuredOutputError{Errors: validationErrors}
+			}
+		}
+	}
+	if err == nil {
+		err = handler.ValidateOutput(sc, sc.Output)
+	}
+
+	if cancelErr := e.cancelled(); cancelErr != nil {
+		log.Printf("[StepExecutor] ⛔ Step bị hủy: %v", context.Cause(e.ctx))
+		_, _ = integrations.FolkForm_UpdateStepRun(sc.StepRunID, nil, "cancelled")
+		return nil, fmt.Errorf("step bị hủy: %w", cancelErr)
+	}
+	if err != nil {
+		log.Printf("[StepExecutor] ❌ Lỗi khi handle %s step: %v", sc.StepType, err)
+		_, _ = integrations.FolkForm_UpdateStepRun(sc.StepRunID, map[string]interface{}{"error": err.Error()}, "failed")
+		return nil, fmt.Errorf("lỗi khi handle %s step: %w", sc.StepType, err)
+	}
+
+	if _, err := integrations.FolkForm_UpdateStepRun(sc.StepRunID, sc.Output, "completed"); err != nil {
+		log.Printf("[StepExecutor] ⚠️  Lỗi khi update step run: %v", err)
+	}
+	log.Printf("[StepExecutor] ✅ HOÀN THÀNH EXECUTE STEP (%s) - StepRunID: %s", sc.StepType, sc.StepRunID)
+	e.progress.Update("step_completed", 100, fmt.Sprintf("Step đã hoàn thành: %s", sc.StepID))
+	log.Printf("[StepExecutor] ========================================")
+
+	return &StepResult{
+		StepRunID:           sc.StepRunID,
+		DraftNodeID:         sc.DraftNodeID,
+		SelectedCandidateID: sc.SelectedCandidateID,
+		Output:              sc.Output,
+	}, nil
+}
+
+// callAI gọi AI qua chuỗi provider (chính + fallbacks), dùng stream khi có tracker để báo tiến độ sinh thực tế
+// Provider profile có thể tắt stream bằng config {"stream": false} (ví dụ custom provider không hỗ trợ SSE)
+func (e *StepExecutor) callAI(aiReq AICallRequest, fallbacks []AIFallbackTarget) (*AICallResponse, []AIAttempt, error) {
+	var onDelta func(AIStreamDelta)
+	if e.progress != nil && streamingEnabled(aiReq.ProviderProfile) {
+		log.Printf("[StepExecutor] Gọi AI ở chế độ stream để báo tiến độ")
+		onDelta = func(delta AIStreamDelta) {
+			// Khoảng 10-90% của step dành cho việc sinh response
+			localPercentage := 10 + int(delta.EstimatedProgress()*80)
+			e.progress.Update("generating", localPercentage, fmt.Sprintf("AI đang sinh response (%d ký tự)", delta.AccumulatedChars))
+		}
+	}
+	resp, attempts, err := e.aiClient.CallWithFallback(aiReq, fallbacks, DefaultAIRetryPolicy(), onDelta)
+	if err != nil && nativeStructuredOutput(aiReq) && apierror.Classify(err) == apierror.CategoryValidation {
+		// Model/provider không hỗ trợ structured output (ví dụ model cũ không có json_schema) → gọi lại không kèm schema,
+		// output vẫn được validate và sửa như bình thường
+		log.Printf("[StepExecutor] ⚠️  Provider từ chối structured output (%v), gọi lại không kèm schema", err)
+		aiReq.ResponseSchema = nil
+		var retryAttempts []AIAttempt
+		resp, retryAttempts, err = e.aiClient.CallWithFallback(aiReq, fallbacks, DefaultAIRetryPolicy(), onDelta)
+		attempts = append(attempts, retryAttempts...)
+	}
+	return resp, attempts, err
+}
+
+// logAIResponse log thông tin response của một AI call
+func (e *StepExecutor) logAIResponse(aiResp *AICallResponse) {
+	log.Printf("[StepExecutor] Provider thực tế: %s (%s), Model: %s", aiResp.ProviderProfileID, aiResp.Provider, aiResp.Model)
+	log.Printf("[StepExecutor] Latency: %v", aiResp.Latency)
+	log.Printf("[StepExecutor] Response length: %d chars", len(aiResp.Content))
+	if aiResp.Usage != nil {
+		log.Printf("[StepExecutor] Token usage - Prompt: %d, Completion: %d, Total: %d",
+			aiResp.Usage.PromptTokens, aiResp.Usage.CompletionTokens, aiResp.Usage.TotalTokens)
+	}
+	log.Printf("[StepExecutor] FinishReason: %s", aiResp.FinishReason)
+	log.Printf("[StepExecutor] Response preview (first 200 chars): %s", truncateString(aiResp.Content, 200))
+}
+
+// accountAICall tính cost theo bảng giá của provider/model thực sự đã trả lời và cộng vào thống kê chi phí
+func (e *StepExecutor) accountAICall(primary *AIProviderProfile, fallbacks []AIFallbackTarget, aiResp *AICallResponse) AICost {
+	if aiResp.CacheHit {
+		log.Printf("[StepExecutor] Response lấy từ cache (key: %s), cost = 0", truncateString(aiResp.CacheKey, 12))
+		RecordAICacheHit()
+		return AICost{Priced: true}
+	}
+	cost := CalculateAICost(answeringProfile(primary, fallbacks, aiResp.ProviderProfileID), aiResp.Provider, aiResp.Model, aiResp.Usage)
+	RecordAISpend(aiResp.Provider, aiResp.Model, cost)
+	if cost.Priced {
+		log.Printf("[StepExecutor] Cost: $%.6f (giá: %s, input $%.4f/1M, output $%.4f/1M)", cost.Amount, cost.PriceKey, cost.Price.Input, cost.Price.Output)
+	} else {
+		log.Printf("[StepExecutor] ⚠️  Không có giá cho %s/%s, cost = 0 (thêm vào config aiPricing)", aiResp.Provider, aiResp.Model)
+	}
+	if cost.Estimated {
+		log.Printf("[StepExecutor] ⚠️  Provider không trả token usage, cost tính theo số token ước lượng")
+	}
+	return cost
+}
+
+// outputSchemaForValidation trả về outputSchema nếu là JSON Schema (dùng để validate và structured output), nil nếu không
+func outputSchemaForValidation(outputSchema map[string]interface{}) map[string]interface{} {
+	if len(outputSchema) == 0 || !isJSONSchema(outputSchema) {
+		return nil
+	}
+	return outputSchema
+}
+
+// schemaRepairAttempts lấy số lần sửa output tối đa: render-prompt response → step definition → config "schemaRepairAttempts"
+func schemaRepairAttempts(renderData, stepData map[string]interface{}) int {
+	for _, source := range []map[string]interface{}{renderData, stepData} {
+		if n := getIntPtr(source, "schemaRepairAttempts"); n != nil && *n >= 0 {
+			return *n
+		}
+	}
+	if cm := GetGlobalConfigManager(); cm != nil {
+		return cm.GetJobConfigInt(workflowCommandsJobName, "schemaRepairAttempts", defaultSchemaRepairAttempts)
+	}
+	return defaultSchemaRepairAttempts
+}
+
+// streamingEnabled kiểm tra provider profile có cho phép stream không (mặc định có)
+func streamingEnabled(profile *AIProviderProfile) bool {
+	if profile == nil || profile.Config == nil {
+		return true
+	}
+	if stream, ok := profile.Config["stream"].(bool); ok {
+		return stream
+	}
+	return true
+}
+
+// answeringProfile tìm provider profile đã trả lời (profile chính hoặc một fallback) để lấy config pricing
+func answeringProfile(primary *AIProviderProfile, fallbacks []AIFallbackTarget, profileID string) *AIProviderProfile {
+	for _, target := range fallbacks {
+		if target.ProviderProfile != nil && target.ProviderProfile.ID == profileID {
+			return target.ProviderProfile
+		}
+	}
+	return primary
+}
+
+// loadProviderProfile load provider profile từ backend (API key, base URL, config)
+func loadProviderProfile(providerProfileId string) (*AIProviderProfile, error) {
+	providerResp, err := integrations.FolkForm_GetProviderProfile(providerProfileId)
+	if err != nil {
+		return nil, fmt.Errorf("lỗi khi load provider profile %s: %w", providerProfileId, err)
+	}
+
+	providerData, ok := providerResp["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("provider profile response không hợp lệ (%s)", providerProfileId)
+	}
+
+	profile := &AIProviderProfile{
+		ID:                 getString(providerData, "id"),
+		Name:               getString(providerData, "name"),
+		Provider:           getString(providerData, "provider"),
+		APIKey:             getString(providerData, "apiKey"),
+		BaseURL:            getString(providerData, "baseUrl"),
+		OrganizationID:     getString(providerData, "organizationId"),
+		DefaultModel:       getString(providerData, "defaultModel"),
+		DefaultTemperature: getFloat64Ptr(providerData, "defaultTemperature"),
+		DefaultMaxTokens:   getIntPtr(providerData, "defaultMaxTokens"),
+	}
+	if config, ok := providerData["config"].(map[string]interface{}); ok {
+		profile.Config = config
+	}
+	if profile.ID == "" {
+		profile.ID = providerProfileId
+	}
+	secrets.Register(profile.APIKey)
+	return profile, nil
+}
+
+// resolveFallbackTargets lấy chuỗi provider dự phòng theo thứ tự ưu tiên:
+// "fallbacks" trong render-prompt response → trong step definition → trong config của provider profile chính
+// Mỗi phần tử là providerProfileId (string) hoặc {"providerProfileId": "...", "model": "..."}
+// Profile không load được thì bỏ qua (chỉ log), không làm step thất bại
+func (e *StepExecutor) resolveFallbackTargets(renderData, stepData map[string]interface{}, primary *AIProviderProfile) []AIFallbackTarget {
+	var entries []interface{}
+	for _, source := range []map[string]interface{}{renderData, stepData, primary.Config} {
+		if list, ok := source["fallbacks"].([]interface{}); ok && len(list) > 0 {
+			entries = list
+			break
+		}
+	}
+
+	var targets []AIFallbackTarget
+	for _, entry := range entries {
+		var profileId, model string
+		switch value := entry.(type) {
+		case string:
+			profileId = value
+		case map[string]interface{}:
+			profileId = getString(value, "providerProfileId")
+			model = getString(value, "model")
+		}
+		if profileId == "" || (profileId == primary.ID && model == "") {
+			continue
+		}
+
+		profile, err := loadProviderProfile(profileId)
+		if err != nil {
+			log.Printf("[StepExecutor] ⚠️  Bỏ qua provider dự phòng: %v", err)
+			continue
+		}
+		log.Printf("[StepExecutor] Fallback %d: %s (%s), model: %s", len(targets)+1, profile.Name, profile.Provider, displayModel(model))
+		targets = append(targets, AIFallbackTarget{ProviderProfile: profile, Model: model})
+	}
+	return targets
+}
+
 // prepareStepInput chuẩn bị input data cho step
 func (e *StepExecutor) prepareStepInput(parentId, parentType string, parentContent map[string]interface{}, inputSchema map[string]interface{}) map[string]interface{} {
 	input := map[string]interface{}{
@@ -429,77 +807,34 @@ func (e *StepExecutor) generatePrompt(template string, stepInput map[string]inte
 }
 
 // parseAIResponse parse AI response theo output schema
+// Template "generate"/"judge" hoặc step có outputSchema dạng JSON Schema → response phải là JSON object
+// (và khớp schema nếu có); không đạt thì trả về *StructuredOutputError để ExecuteStep yêu cầu model sửa
 func (e *StepExecutor) parseAIResponse(responseText string, outputSchema map[string]interface{}, templateType string) (map[string]interface{}, error) {
-	// Nếu template type là "generate" hoặc "judge", response thường là JSON
-	if templateType == "generate" || templateType == "judge" {
-		// Thử parse JSON trực tiếp
-		var parsed map[string]interface{}
-		if err := json.Unmarshal([]byte(responseText), &parsed); err != nil {
-			// Nếu không parse được, thử extract JSON từ markdown code block
-			jsonText := e.extractJSONFromMarkdown(responseText)
-			if jsonText != "" {
-				if err := json.Unmarshal([]byte(jsonText), &parsed); err != nil {
-					log.Printf("[StepExecutor] [parseAIResponse] ⚠️  Không thể parse JSON từ markdown: %v", err)
-					return map[string]interface{}{
-						"content": responseText,
-					}, nil
-				}
-			} else {
-				// Nếu không có JSON, return như plain text
-				log.Printf("[StepExecutor] [parseAIResponse] ⚠️  Response không phải JSON, trả về như plain text")
-				return map[string]interface{}{
-					"content": responseText,
-				}, nil
-			}
-		}
-		
-		// Thêm generatedAt nếu chưa có
-		if _, ok := parsed["generatedAt"]; !ok {
-			parsed["generatedAt"] = time.Now().Format(time.RFC3339)
-		}
-		
-		return parsed, nil
+	schema := outputSchemaForValidation(outputSchema)
+	if schema == nil && templateType != "generate" && templateType != "judge" {
+		// Default: return as text
+		return map[string]interface{}{
+			"content": responseText,
+		}, nil
 	}
 
-	// Default: return as text
-	return map[string]interface{}{
-		"content": responseText,
-	}, nil
-}
-
-// extractJSONFromMarkdown extract JSON từ markdown code block (```json ... ```)
-func (e *StepExecutor) extractJSONFromMarkdown(text string) string {
-	// Tìm code block với ```json hoặc ```
-	startMarker := "```json"
-	endMarker := "```"
-	
-	startIdx := strings.Index(text, startMarker)
-	if startIdx == -1 {
-		// Thử tìm với ``` thông thường
-		startMarker = "```"
-		startIdx = strings.Index(text, startMarker)
-		if startIdx == -1 {
-			return ""
-		}
-		startIdx += len(startMarker)
-	} else {
-		startIdx += len(startMarker)
+	parsed, err := parseJSONOutput(responseText)
+	if err != nil {
+		log.Printf("[StepExecutor] [parseAIResponse] ⚠️  %v", err)
+		return nil, &StructuredOutputError{Errors: []string{err.Error()}}
 	}
-	
-	// Bỏ qua whitespace sau start marker
-	for startIdx < len(text) && (text[startIdx] == ' ' || text[startIdx] == '\n' || text[startIdx] == '\r') {
-		startIdx++
+	if schema != nil {
+		if validationErrors := validateJSONSchema(schema, parsed); len(validationErrors) > 0 {
+			return parsed, &StructuredOutputError{Errors: validationErrors}
+		}
+		log.Printf("[StepExecutor] [parseAIResponse] ✅ Output khớp outputSchema")
 	}
-	
-	// Tìm end marker
-	endIdx := strings.Index(text[startIdx:], endMarker)
-	if endIdx == -1 {
-		return ""
+
+	// Thêm generatedAt nếu chưa có (sau khi validate để không vướng additionalProperties của schema)
+	if _, ok := parsed["generatedAt"]; !ok {
+		parsed["generatedAt"] = time.Now().Format(time.RFC3339)
 	}
-	endIdx += startIdx
-	
-	jsonText := strings.TrimSpace(text[startIdx:endIdx])
-	return jsonText
+	return parsed, nil
 }
 
 // handleGenerateStep xử lý GENERATE step: tạo candidates và draft node
@@ -567,6 +902,8 @@ func (e *StepExecutor) handleGenerateStep(stepRunID, aiRunID string, parsedOutpu
 		if candidateData, ok := candidateResp["data"].(map[string]interface{}); ok {
 			if candidateID, ok := candidateData["id"].(string); ok {
 				candidateIDs = append(candidateIDs, candidateID)
+				// Ghi candidateId vào output để step fan-out/fan-in phía sau tham chiếu được candidate
+				candidateMap["candidateId"] = candidateID
 				
 				// Lưu candidate data với đầy đủ thông tin (title, summary, metadata)
 				candidateInfo := map[string]interface{}{
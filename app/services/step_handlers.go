@@ -0,0 +1,341 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa registry xử lý theo loại step (step.type), thêm loại step mới không cần sửa StepExecutor:
+  - StepHandler dùng AI (GENERATE, JUDGE, CLASSIFY): ExecuteStep chạy pipeline AI chung (render prompt → gọi AI →
+    parse output + sửa output sai), handler kiểm tra output (lỗi có kiểu StructuredOutputError → yêu cầu model sửa)
+    rồi xử lý output (tạo candidates, chọn best...)
+  - StepHandler không dùng AI (TRANSFORM, EMBED, HTTP_CALL, HUMAN_REVIEW, xem step_handlers_external.go): handler tự tạo output,
+    output được validate theo outputSchema của step
+  - Tham số riêng của từng loại step nằm trong step.config
+  - Loại step chưa đăng ký: chạy pipeline AI và lưu output như trước
+*/
+package services
+
+import (
+	"agent_pancake/app/integrations"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StepContext là dữ liệu của step đang chạy, truyền cho StepHandler
+type StepContext struct {
+	Executor      *StepExecutor
+	StepID        string
+	StepType      string
+	Step          map[string]interface{} // Step definition từ backend
+	Config        map[string]interface{} // step.config - tham số riêng của loại step (không nil)
+	ParentID      string
+	ParentType    string
+	ParentContent map[string]interface{}
+	WorkflowRunID string
+	Input         map[string]interface{} // Input của step run
+
+	StepRunID string
+	Resumed   bool                   // true khi chạy tiếp step run đã tạo từ lần chạy trước (step đang chờ duyệt)
+	AIRunID   string                 // Chỉ có với step dùng AI
+	Output    map[string]interface{} // Output AI đã parse (step dùng AI) hoặc output do handler tạo
+
+	DraftNodeID         string
+	SelectedCandidateID string
+}
+
+// StepHandler xử lý một loại step
+type StepHandler interface {
+	// UsesAI: true → ExecuteStep chạy pipeline AI chung, Execute nhận output AI trong sc.Output;
+	// false → không gọi render-prompt/AI, Execute tự tạo sc.Output
+	UsesAI() bool
+	// ValidateInput kiểm tra config và input của step trước khi tạo step run
+	ValidateInput(sc *StepContext) error
+	// ValidateOutput kiểm tra (và chuẩn hóa) output, với step dùng AI trả về *StructuredOutputError để yêu cầu model sửa
+	ValidateOutput(sc *StepContext, output map[string]interface{}) error
+	// Execute xử lý step sau khi đã tạo step run
+	Execute(sc *StepContext) error
+}
+
+var (
+	stepHandlersMu sync.RWMutex
+	stepHandlers   = map[string]StepHandler{
+		"GENERATE":     generateStepHandler{},
+		"JUDGE":        judgeStepHandler{},
+		"TRANSFORM":    transformStepHandler{},
+		"CLASSIFY":     classifyStepHandler{},
+		"EMBED":        embedStepHandler{},
+		"HTTP_CALL":    httpCallStepHandler{},
+		"HUMAN_REVIEW": humanReviewStepHandler{},
+	}
+)
+
+// RegisterStepHandler đăng ký (hoặc thay) handler cho một loại step
+func RegisterStepHandler(stepType string, handler StepHandler) {
+	stepHandlersMu.Lock()
+	defer stepHandlersMu.Unlock()
+	stepHandlers[strings.ToUpper(stepType)] = handler
+}
+
+// GetStepHandler trả về handler của loại step (nil nếu chưa đăng ký)
+func GetStepHandler(stepType string) StepHandler {
+	stepHandlersMu.RLock()
+	defer stepHandlersMu.RUnlock()
+	return stepHandlers[strings.ToUpper(stepType)]
+}
+
+// RegisteredStepTypes trả về danh sách loại step đã đăng ký (đã sắp xếp)
+func RegisteredStepTypes() []string {
+	stepHandlersMu.RLock()
+	defer stepHandlersMu.RUnlock()
+	types := make([]string, 0, len(stepHandlers))
+	for stepType := range stepHandlers {
+		types = append(types, stepType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// stepTemplateVariable là placeholder trong template của step: {{name}} hoặc {{parentContent.field}}
+var stepTemplateVariable = regexp.MustCompile(`\{\{\s*([\w.]+)\s*\}\}`)
+
+// renderStepTemplate thay các placeholder trong template bằng giá trị từ variables (object/list → JSON)
+// Trả về lỗi nếu có placeholder không có giá trị
+func renderStepTemplate(template string, variables map[string]interface{}) (string, error) {
+	var missing []string
+	rendered := stepTemplateVariable.ReplaceAllStringFunc(template, func(match string) string {
+		path := stepTemplateVariable.FindStringSubmatch(match)[1]
+		value, ok := lookupOutputPath(variables, path)
+		if !ok || value == nil {
+			missing = append(missing, path)
+			return match
+		}
+		switch v := value.(type) {
+		case string:
+			return v
+		case map[string]interface{}, []interface{}:
+			encoded, _ := json.Marshal(v)
+			return string(encoded)
+		default:
+			return fmt.Sprintf("%v", v)
+		}
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("template thiếu biến: %s", strings.Join(missing, ", "))
+	}
+	return rendered, nil
+}
+
+// stepTemplateVariables là biến dùng cho template của step: variables của render-prompt + toàn bộ parent content
+func stepTemplateVariables(sc *StepContext) map[string]interface{} {
+	variables := sc.Executor.prepareVariablesForRenderPrompt(sc.Input, sc.ParentContent)
+	variables["parent"] = sc.ParentContent
+	variables["stepId"] = sc.StepID
+	variables["workflowRunId"] = sc.WorkflowRunID
+	return variables
+}
+
+// generateStepHandler xử lý GENERATE step: output có candidates → tạo generation batch, candidates và draft node
+type generateStepHandler struct{}
+
+func (generateStepHandler) UsesAI() bool { return true }
+
+func (generateStepHandler) ValidateInput(sc *StepContext) error { return nil }
+
+func (generateStepHandler) ValidateOutput(sc *StepContext, output map[string]interface{}) error {
+	candidates, ok := output["candidates"].([]interface{})
+	if !ok || len(candidates) == 0 {
+		return &StructuredOutputError{Errors: []string{"output phải có \"candidates\" là danh sách không rỗng"}}
+	}
+	return nil
+}
+
+func (generateStepHandler) Execute(sc *StepContext) error {
+	if _, ok := sc.Output["generatedAt"]; !ok {
+		sc.Output["generatedAt"] = time.Now().Format(time.RFC3339)
+	}
+	draftNodeID, selectedCandidateID, err := sc.Executor.handleGenerateStep(sc.StepRunID, sc.AIRunID, sc.Output, sc.ParentType)
+	if err != nil {
+		return err
+	}
+	sc.DraftNodeID, sc.SelectedCandidateID = draftNodeID, selectedCandidateID
+	log.Printf("[StepExecutor] ✅ GENERATE step hoàn thành - DraftNodeID: %s, SelectedCandidateID: %s", draftNodeID, selectedCandidateID)
+	return nil
+}
+
+// judgeStepHandler xử lý JUDGE step: chọn best candidate từ output (bestCandidate, rankings hoặc scores)
+type judgeStepHandler struct{}
+
+func (judgeStepHandler) UsesAI() bool { return true }
+
+func (judgeStepHandler) ValidateInput(sc *StepContext) error { return nil }
+
+func (judgeStepHandler) ValidateOutput(sc *StepContext, output map[string]interface{}) error {
+	return nil
+}
+
+func (judgeStepHandler) Execute(sc *StepContext) error {
+	if _, ok := sc.Output["judgedAt"]; !ok {
+		sc.Output["judgedAt"] = time.Now().Format(time.RFC3339)
+	}
+	selectedCandidateID, err := sc.Executor.handleJudgeStep(sc.StepRunID, sc.AIRunID, sc.Output, sc.ParentID)
+	if err != nil {
+		return err
+	}
+	sc.SelectedCandidateID = selectedCandidateID
+	log.Printf("[StepExecutor] ✅ JUDGE step hoàn thành - SelectedCandidateID: %s", selectedCandidateID)
+	return nil
+}
+
+// transformStepHandler xử lý TRANSFORM step: chỉ render template, không gọi AI
+// config.template (string) → output {"text": ...}; config.fields ({"field": "template"}) → output {"field": ...};
+// không có cả hai → render prompt template của step qua render-prompt API, output {"text": renderedPrompt}
+type transformStepHandler struct{}
+
+func (transformStepHandler) UsesAI() bool { return false }
+
+func (transformStepHandler) ValidateInput(sc *StepContext) error {
+	if template, ok := sc.Config["template"]; ok {
+		if _, isString := template.(string); !isString {
+			return fmt.Errorf("config.template phải là string")
+		}
+		return nil
+	}
+	if fields, ok := sc.Config["fields"]; ok {
+		fieldMap, isMap := fields.(map[string]interface{})
+		if !isMap || len(fieldMap) == 0 {
+			return fmt.Errorf("config.fields phải là object không rỗng")
+		}
+		for name, template := range fieldMap {
+			if _, isString := template.(string); !isString {
+				return fmt.Errorf("config.fields.%s phải là string", name)
+			}
+		}
+		return nil
+	}
+	if getString(sc.Step, "promptTemplateId") == "" {
+		return fmt.Errorf("TRANSFORM step cần config.template, config.fields hoặc promptTemplateId")
+	}
+	return nil
+}
+
+func (transformStepHandler) ValidateOutput(sc *StepContext, output map[string]interface{}) error {
+	return nil
+}
+
+func (transformStepHandler) Execute(sc *StepContext) error {
+	variables := stepTemplateVariables(sc)
+	output := make(map[string]interface{})
+
+	if template, ok := sc.Config["template"].(string); ok {
+		text, err := renderStepTemplate(template, variables)
+		if err != nil {
+			return err
+		}
+		output["text"] = text
+	} else if fields, ok := sc.Config["fields"].(map[string]interface{}); ok {
+		for name, template := range fields {
+			text, err := renderStepTemplate(template.(string), variables)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+			output[name] = text
+		}
+	} else {
+		renderResp, err := integrations.FolkForm_RenderPromptForStep(sc.StepID, sc.Executor.prepareVariablesForRenderPrompt(sc.Input, sc.ParentContent))
+		if err != nil {
+			return fmt.Errorf("lỗi khi render prompt: %v", err)
+		}
+		renderData, _ := renderResp["data"].(map[string]interface{})
+		output["text"] = getString(renderData, "renderedPrompt")
+	}
+
+	output["transformedAt"] = time.Now().Format(time.RFC3339)
+	sc.Output = output
+	log.Printf("[StepExecutor] ✅ TRANSFORM step hoàn thành - fields: %v", getMapKeys(output))
+	return nil
+}
+
+// classifyStepHandler xử lý CLASSIFY step: AI chọn nhãn trong config.labels
+// Output {"label": "..."} (hoặc {"labels": [...]} nếu config.multiLabel = true), response chỉ có text cũng được nhận là nhãn
+// Nhãn ngoài danh sách → yêu cầu model sửa
+type classifyStepHandler struct{}
+
+func (classifyStepHandler) UsesAI() bool { return true }
+
+func (classifyStepHandler) ValidateInput(sc *StepContext) error {
+	if len(toStringList(sc.Config["labels"])) == 0 {
+		return fmt.Errorf("CLASSIFY step cần config.labels (danh sách nhãn)")
+	}
+	return nil
+}
+
+func (classifyStepHandler) ValidateOutput(sc *StepContext, output map[string]interface{}) error {
+	labels := toStringList(sc.Config["labels"])
+	multiLabel, _ := sc.Config["multiLabel"].(bool)
+
+	// Response không phải JSON (không có outputSchema) → parseAIResponse trả về {"content": text}
+	if content, ok := output["content"].(string); ok && output["label"] == nil && output["labels"] == nil {
+		if parsed, err := parseJSONOutput(content); err == nil {
+			for key, value := range parsed {
+				output[key] = value
+			}
+		} else if multiLabel {
+			output["labels"] = strings.Split(content, ",")
+		} else {
+			output["label"] = content
+		}
+	}
+
+	var invalid []string
+	canonical := func(value string) (string, bool) {
+		value = strings.Trim(strings.TrimSpace(value), `"'.`)
+		for _, label := range labels {
+			if strings.EqualFold(label, value) {
+				return label, true
+			}
+		}
+		invalid = append(invalid, value)
+		return value, false
+	}
+
+	if multiLabel {
+		var selected []interface{}
+		for _, value := range toStringList(output["labels"]) {
+			if label, ok := canonical(value); ok {
+				selected = append(selected, label)
+			}
+		}
+		if len(selected) == 0 && len(invalid) == 0 {
+			return &StructuredOutputError{Errors: []string{"output phải có \"labels\" là danh sách nhãn"}}
+		}
+		output["labels"] = selected
+	} else {
+		value, _ := output["label"].(string)
+		if value == "" {
+			return &StructuredOutputError{Errors: []string{"output phải có \"label\" là một nhãn"}}
+		}
+		label, _ := canonical(value)
+		output["label"] = label
+	}
+
+	if len(invalid) > 0 {
+		return &StructuredOutputError{Errors: []string{
+			fmt.Sprintf("nhãn không hợp lệ: %s (chỉ được chọn: %s)", strings.Join(invalid, ", "), strings.Join(labels, ", ")),
+		}}
+	}
+	return nil
+}
+
+func (classifyStepHandler) Execute(sc *StepContext) error {
+	if _, ok := sc.Output["classifiedAt"]; !ok {
+		sc.Output["classifiedAt"] = time.Now().Format(time.RFC3339)
+	}
+	if labels, ok := sc.Output["labels"]; ok {
+		log.Printf("[StepExecutor] ✅ CLASSIFY step hoàn thành - Labels: %v", labels)
+	} else {
+		log.Printf("[StepExecutor] ✅ CLASSIFY step hoàn thành - Label: %v", sc.Output["label"])
+	}
+	return nil
+}
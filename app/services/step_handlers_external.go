@@ -0,0 +1,436 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa các StepHandler gọi ra ngoài, không qua pipeline AI (xem step_handlers.go):
+  - EMBED: lấy embedding của text trong parent content qua Embeddings API của provider (chi phí tính vào ngân sách run)
+  - HTTP_CALL: gọi webhook cấu hình trong step, response là output của step
+  - HUMAN_REVIEW: đánh dấu step run "waiting_review" rồi tạm dừng workflow (không giữ slot), workflow chạy tiếp
+    khi server giao lại command sau khi người duyệt cập nhật step run
+*/
+package services
+
+import (
+	"agent_pancake/app/integrations"
+	"agent_pancake/utility/secrets"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// embedStepHandler xử lý EMBED step
+// config: providerProfileId (hoặc providerProfileId của step), model, dimensions,
+// field (đường dẫn text trong parent content, mặc định "text" rồi "content"; giá trị là danh sách → nhiều vector)
+// Output: {"embedding": [...]} (hoặc {"embeddings": [[...], ...]} với nhiều text), model, dimensions, tokens
+type embedStepHandler struct{}
+
+func (embedStepHandler) UsesAI() bool { return false }
+
+func (embedStepHandler) ValidateInput(sc *StepContext) error {
+	if embedProviderProfileID(sc) == "" {
+		return fmt.Errorf("EMBED step cần config.providerProfileId")
+	}
+	if _, err := embedInputs(sc); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (embedStepHandler) ValidateOutput(sc *StepContext, output map[string]interface{}) error {
+	return nil
+}
+
+func (embedStepHandler) Execute(sc *StepContext) error {
+	e := sc.Executor
+	inputs, err := embedInputs(sc)
+	if err != nil {
+		return err
+	}
+	if err := e.budget.Check(); err != nil {
+		return err
+	}
+	profile, err := loadProviderProfile(embedProviderProfileID(sc))
+	if err != nil {
+		return err
+	}
+
+	model, _ := sc.Config["model"].(string)
+	dimensions, _ := toFloat64(sc.Config["dimensions"])
+	e.progress.Update("embedding", 30, fmt.Sprintf("Đang lấy embedding (%d đoạn text)...", len(inputs)))
+	log.Printf("[StepExecutor] Đang lấy embedding của %d đoạn text - Provider: %s (%s)", len(inputs), profile.Name, profile.Provider)
+	resp, err := e.aiClient.Embed(AIEmbeddingRequest{
+		ProviderProfile: profile,
+		Model:           model,
+		Inputs:          inputs,
+		Dimensions:      int(dimensions),
+		Context:         e.ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("lỗi khi gọi embedding API: %w", err)
+	}
+
+	cost := CalculateAICost(profile, profile.Provider, resp.Model, resp.Usage)
+	RecordAISpend(profile.Provider, resp.Model, cost)
+	if err := e.budget.Add(cost.Amount); err != nil {
+		return err
+	}
+
+	output := map[string]interface{}{
+		"model":      resp.Model,
+		"dimensions": len(resp.Vectors[0]),
+		"cost":       cost.Amount,
+		"embeddedAt": time.Now().Format(time.RFC3339),
+	}
+	if resp.Usage != nil {
+		output["tokens"] = resp.Usage.PromptTokens
+	}
+	if len(resp.Vectors) == 1 {
+		output["embedding"] = resp.Vectors[0]
+	} else {
+		output["embeddings"] = resp.Vectors
+	}
+	sc.Output = output
+	log.Printf("[StepExecutor] ✅ EMBED step hoàn thành - %d vector x %d chiều, cost: $%.6f", len(resp.Vectors), len(resp.Vectors[0]), cost.Amount)
+	return nil
+}
+
+func embedProviderProfileID(sc *StepContext) string {
+	if id, _ := sc.Config["providerProfileId"].(string); id != "" {
+		return id
+	}
+	return getString(sc.Step, "providerProfileId")
+}
+
+// embedInputs lấy các đoạn text cần embedding từ parent content
+func embedInputs(sc *StepContext) ([]string, error) {
+	fields := []string{"text", "content"}
+	if field, _ := sc.Config["field"].(string); field != "" {
+		fields = []string{field}
+	}
+	for _, field := range fields {
+		value, ok := lookupOutputPath(sc.ParentContent, field)
+		if !ok {
+			continue
+		}
+		if inputs := toStringList(value); len(inputs) > 0 {
+			return inputs, nil
+		}
+	}
+	return nil, fmt.Errorf("parent content không có text để embedding (field: %s)", strings.Join(fields, ", "))
+}
+
+// httpCallStepHandler xử lý HTTP_CALL step: gọi webhook cấu hình trong step
+// config: url (bắt buộc, http/https), method (mặc định POST), headers, body (mặc định gửi input của step),
+// timeoutSeconds (mặc định 30, tối đa 300). url, headers và các string trong body dùng được template {{...}}
+// Output: {"statusCode": ..., "body": response (JSON hoặc text)}; status ngoài 2xx → step thất bại
+type httpCallStepHandler struct{}
+
+const (
+	httpCallDefaultTimeout = 30 * time.Second
+	httpCallMaxTimeout     = 300 * time.Second
+	httpCallMaxResponse    = 1 << 20 // Giới hạn đọc response 1MB
+)
+
+var httpCallMethods = map[string]bool{"GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+func (httpCallStepHandler) UsesAI() bool { return false }
+
+func (httpCallStepHandler) ValidateInput(sc *StepContext) error {
+	rawURL, _ := sc.Config["url"].(string)
+	if rawURL == "" {
+		return fmt.Errorf("HTTP_CALL step cần config.url")
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("config.url không hợp lệ (cần http/https): %s", rawURL)
+	}
+	if method := httpCallMethod(sc); !httpCallMethods[method] {
+		return fmt.Errorf("config.method không được hỗ trợ: %s", method)
+	}
+	if headers, ok := sc.Config["headers"]; ok {
+		headerMap, isMap := headers.(map[string]interface{})
+		if !isMap {
+			return fmt.Errorf("config.headers phải là object")
+		}
+		for name, value := range headerMap {
+			if _, isString := value.(string); !isString {
+				return fmt.Errorf("config.headers.%s phải là string", name)
+			}
+		}
+	}
+	return nil
+}
+
+func (httpCallStepHandler) ValidateOutput(sc *StepContext, output map[string]interface{}) error {
+	return nil
+}
+
+func (httpCallStepHandler) Execute(sc *StepContext) error {
+	variables := stepTemplateVariables(sc)
+	targetURL, err := renderStepTemplate(sc.Config["url"].(string), variables)
+	if err != nil {
+		return fmt.Errorf("config.url: %w", err)
+	}
+	method := httpCallMethod(sc)
+
+	var body io.Reader
+	if method != "GET" && method != "DELETE" {
+		payload := interface{}(map[string]interface{}{
+			"stepId":        sc.StepID,
+			"workflowRunId": sc.WorkflowRunID,
+			"input":         sc.Input,
+		})
+		if configBody, ok := sc.Config["body"]; ok {
+			if payload, err = renderStepValue(configBody, variables); err != nil {
+				return fmt.Errorf("config.body: %w", err)
+			}
+		}
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("lỗi khi marshal body: %v", err)
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	timeout := httpCallDefaultTimeout
+	if seconds, ok := toFloat64(sc.Config["timeoutSeconds"]); ok && seconds > 0 {
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout > httpCallMaxTimeout {
+		timeout = httpCallMaxTimeout
+	}
+	ctx := sc.Executor.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, targetURL, body)
+	if err != nil {
+		return fmt.Errorf("lỗi khi tạo HTTP request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if headers, ok := sc.Config["headers"].(map[string]interface{}); ok {
+		for name, value := range headers {
+			rendered, err := renderStepTemplate(value.(string), variables)
+			if err != nil {
+				return fmt.Errorf("config.headers.%s: %w", name, err)
+			}
+			// Header thường chứa token → không để lộ trong log
+			secrets.Register(rendered)
+			req.Header.Set(name, rendered)
+		}
+	}
+
+	sc.Executor.progress.Update("http_call", 30, fmt.Sprintf("Đang gọi %s %s...", method, req.URL.Host))
+	log.Printf("[StepExecutor] Đang gọi HTTP %s %s (timeout: %s)", method, req.URL.Redacted(), timeout)
+	startTime := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("lỗi khi gọi %s %s: %w", method, req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, httpCallMaxResponse))
+	if err != nil {
+		return fmt.Errorf("lỗi khi đọc response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s trả về status %d: %s", method, req.URL.Host, resp.StatusCode, truncateString(string(respBody), 200))
+	}
+
+	var parsedBody interface{} = string(respBody)
+	var decoded interface{}
+	if len(bytes.TrimSpace(respBody)) > 0 && json.Unmarshal(respBody, &decoded) == nil {
+		parsedBody = decoded
+	}
+	sc.Output = map[string]interface{}{
+		"statusCode": resp.StatusCode,
+		"body":       parsedBody,
+		"latencyMs":  time.Since(startTime).Milliseconds(),
+		"calledAt":   startTime.Format(time.RFC3339),
+	}
+	log.Printf("[StepExecutor] ✅ HTTP_CALL step hoàn thành - status: %d, latency: %dms", resp.StatusCode, time.Since(startTime).Milliseconds())
+	return nil
+}
+
+func httpCallMethod(sc *StepContext) string {
+	method, _ := sc.Config["method"].(string)
+	if method == "" {
+		return "POST"
+	}
+	return strings.ToUpper(method)
+}
+
+// renderStepValue render template trong các string của value (object/list lồng nhau)
+// String chỉ gồm một placeholder ("{{parentContent}}") được thay bằng giá trị gốc (giữ kiểu object/number)
+func renderStepValue(value interface{}, variables map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if match := stepTemplateVariable.FindStringSubmatch(v); match != nil && match[0] == strings.TrimSpace(v) {
+			if raw, ok := lookupOutputPath(variables, match[1]); ok && raw != nil {
+				return raw, nil
+			}
+		}
+		return renderStepTemplate(v, variables)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			renderedItem, err := renderStepValue(item, variables)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			rendered[key] = renderedItem
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			renderedItem, err := renderStepValue(item, variables)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			rendered[i] = renderedItem
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+// humanReviewStepHandler xử lý HUMAN_REVIEW step: tạm dừng workflow đến khi người duyệt quyết định
+// Lần chạy đầu: step run chuyển sang "waiting_review" (output.review chứa instructions, hạn duyệt) rồi trả về
+// *StepWaitingReviewError → workflow run lưu checkpoint, command kết thúc ở trạng thái "waiting_review" và giải phóng slot.
+// Người duyệt (qua server) cập nhật step run: status "approved"/"rejected" hoặc review.decision = "approved"/"rejected"
+// (kèm reviewer, comment, output sửa nếu có), server giao lại command → workflow chạy tiếp (xem workflow_resume.go)
+// và step đọc kết quả duyệt của step run cũ.
+// config: instructions, timeoutMinutes (mặc định 1440, quá hạn mà chưa duyệt thì step lỗi khi chạy tiếp)
+// Output: {"approved": true, "review": {...}} + các field trong review.output (nếu người duyệt sửa nội dung)
+type humanReviewStepHandler struct{}
+
+const humanReviewDefaultTimeout = 24 * time.Hour
+
+// StepWaitingReviewError là "lỗi" của step đang chờ người duyệt: workflow tạm dừng chứ không thất bại
+// StepRunIDs là các step run đang chờ (nhiều phần tử khi fan-out), được lưu vào checkpoint để chạy tiếp
+type StepWaitingReviewError struct {
+	StepRunIDs []string
+}
+
+func (e *StepWaitingReviewError) Error() string {
+	return fmt.Sprintf("step đang chờ duyệt (step run: %s)", strings.Join(e.StepRunIDs, ", "))
+}
+
+func (humanReviewStepHandler) UsesAI() bool { return false }
+
+func (humanReviewStepHandler) ValidateInput(sc *StepContext) error {
+	if sc.WorkflowRunID == "" {
+		return fmt.Errorf("HUMAN_REVIEW step chỉ chạy trong workflow (cần workflow run để chạy tiếp sau khi duyệt)")
+	}
+	if instructions, ok := sc.Config["instructions"]; ok {
+		if _, isString := instructions.(string); !isString {
+			return fmt.Errorf("config.instructions phải là string")
+		}
+	}
+	if value, ok := sc.Config["timeoutMinutes"]; ok {
+		if number, isNumber := toFloat64(value); !isNumber || number <= 0 {
+			return fmt.Errorf("config.timeoutMinutes phải là số dương")
+		}
+	}
+	return nil
+}
+
+func (humanReviewStepHandler) ValidateOutput(sc *StepContext, output map[string]interface{}) error {
+	return nil
+}
+
+func (humanReviewStepHandler) Execute(sc *StepContext) error {
+	if sc.Resumed {
+		return resumeHumanReview(sc)
+	}
+
+	timeout := humanReviewDefaultTimeout
+	if minutes, ok := toFloat64(sc.Config["timeoutMinutes"]); ok {
+		timeout = time.Duration(minutes * float64(time.Minute))
+	}
+	requestedAt := time.Now()
+	review := map[string]interface{}{
+		"status":      "pending",
+		"requestedAt": requestedAt.Format(time.RFC3339),
+		"expiresAt":   requestedAt.Add(timeout).Format(time.RFC3339),
+	}
+	if instructions, _ := sc.Config["instructions"].(string); instructions != "" {
+		review["instructions"] = instructions
+	}
+	if _, err := integrations.FolkForm_UpdateStepRun(sc.StepRunID, map[string]interface{}{"review": review}, "waiting_review"); err != nil {
+		return fmt.Errorf("lỗi khi chuyển step run sang waiting_review: %v", err)
+	}
+	log.Printf("[StepExecutor] ⏸️  Step run %s đang chờ duyệt (hết hạn sau %s), tạm dừng workflow", sc.StepRunID, timeout)
+	sc.Executor.progress.Update("waiting_review", 100, fmt.Sprintf("Đang chờ duyệt step: %s", sc.StepID))
+	return &StepWaitingReviewError{StepRunIDs: []string{sc.StepRunID}}
+}
+
+// resumeHumanReview đọc kết quả duyệt của step run đang chờ khi workflow chạy tiếp
+// Chưa duyệt → tạm dừng lại (trừ khi đã quá hạn duyệt)
+func resumeHumanReview(sc *StepContext) error {
+	resp, err := integrations.FolkForm_GetStepRun(sc.StepRunID)
+	if err != nil {
+		return fmt.Errorf("lỗi khi đọc kết quả duyệt của step run %s: %w", sc.StepRunID, err)
+	}
+	stepRun, _ := resp["data"].(map[string]interface{})
+	decision, result := humanReviewDecision(stepRun)
+	switch decision {
+	case "approved":
+		output := map[string]interface{}{
+			"approved":   true,
+			"review":     result,
+			"reviewedAt": time.Now().Format(time.RFC3339),
+		}
+		if edited, ok := result["output"].(map[string]interface{}); ok {
+			for key, value := range edited {
+				output[key] = value
+			}
+		}
+		sc.Output = output
+		log.Printf("[StepExecutor] ✅ HUMAN_REVIEW step đã được duyệt (reviewer: %s)", getString(result, "reviewer"))
+		return nil
+	case "rejected":
+		comment := getString(result, "comment")
+		log.Printf("[StepExecutor] ❌ HUMAN_REVIEW step bị từ chối (reviewer: %s): %s", getString(result, "reviewer"), comment)
+		return fmt.Errorf("step bị từ chối khi duyệt: %s", comment)
+	}
+
+	if expiresAt, err := time.Parse(time.RFC3339, getString(result, "expiresAt")); err == nil && time.Now().After(expiresAt) {
+		return fmt.Errorf("hết thời gian chờ duyệt (hạn %s)", expiresAt.Format(time.RFC3339))
+	}
+	log.Printf("[StepExecutor] ⏸️  Step run %s vẫn chưa được duyệt, tạm dừng workflow", sc.StepRunID)
+	return &StepWaitingReviewError{StepRunIDs: []string{sc.StepRunID}}
+}
+
+// humanReviewDecision đọc kết quả duyệt từ step run ("" nếu chưa duyệt)
+func humanReviewDecision(stepRun map[string]interface{}) (string, map[string]interface{}) {
+	output, _ := stepRun["output"].(map[string]interface{})
+	review, _ := output["review"].(map[string]interface{})
+	if review == nil {
+		review, _ = stepRun["review"].(map[string]interface{})
+	}
+	if review == nil {
+		review = map[string]interface{}{}
+	}
+	decision := strings.ToLower(getString(review, "decision"))
+	if decision == "" {
+		decision = strings.ToLower(getString(stepRun, "status"))
+	}
+	switch decision {
+	case "approved", "approve":
+		return "approved", review
+	case "rejected", "reject":
+		return "rejected", review
+	}
+	return "", review
+}
@@ -0,0 +1,154 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần ép output của AI theo outputSchema của step:
+  - Parse JSON từ response (JSON thuần, code block ```json, hoặc object đầu tiên trong text)
+  - Validate theo JSON Schema (draft 4/6/7) bằng gojsonschema
+  - Tạo request sửa lỗi (repair) gửi lại cho model kèm danh sách lỗi validate
+*/
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// defaultSchemaRepairAttempts là số lần gửi lại model để sửa output không khớp schema (config "schemaRepairAttempts")
+const defaultSchemaRepairAttempts = 2
+
+// maxReportedSchemaErrors giới hạn số lỗi validate gửi lại model và lưu vào AI run
+const maxReportedSchemaErrors = 20
+
+// aiStructuredOutputName là tên schema/tool khi bật structured output của provider
+const aiStructuredOutputName = "step_output"
+
+// jsonSchemaKeywords là các keyword cho biết outputSchema là JSON Schema thật (không phải mô tả tự do)
+var jsonSchemaKeywords = []string{"$schema", "type", "properties", "required", "items", "oneOf", "anyOf", "allOf", "$ref", "enum"}
+
+// isJSONSchema kiểm tra outputSchema có phải JSON Schema không
+func isJSONSchema(schema map[string]interface{}) bool {
+	for _, keyword := range jsonSchemaKeywords {
+		if _, ok := schema[keyword]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// StructuredOutputError là lỗi khi output của AI không parse được hoặc không khớp outputSchema
+type StructuredOutputError struct {
+	Errors []string
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("output của AI không khớp outputSchema: %s", strings.Join(e.Errors, "; "))
+}
+
+// parseJSONOutput parse JSON object từ response của AI
+// Thứ tự: JSON thuần → code block ```json / ``` → đoạn từ '{' đầu tiên đến '}' cuối cùng
+func parseJSONOutput(text string) (map[string]interface{}, error) {
+	trimmed := strings.TrimSpace(text)
+	candidates := []string{trimmed}
+	if fenced := extractFencedJSON(trimmed); fenced != "" {
+		candidates = append(candidates, fenced)
+	}
+	if start, end := strings.Index(trimmed, "{"), strings.LastIndex(trimmed, "}"); start >= 0 && end > start {
+		candidates = append(candidates, trimmed[start:end+1])
+	}
+
+	var lastErr error
+	for _, candidate := range candidates {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(candidate), &parsed); err == nil {
+			return parsed, nil
+		} else {
+			lastErr = err
+		}
+	}
+	return nil, fmt.Errorf("response không phải JSON object hợp lệ: %v", lastErr)
+}
+
+var fencedJSONPattern = regexp.MustCompile("(?s)```(?:json|JSON)?\\s*(.*?)```")
+
+// extractFencedJSON lấy nội dung code block đầu tiên (```json ... ``` hoặc ``` ... ```)
+func extractFencedJSON(text string) string {
+	match := fencedJSONPattern.FindStringSubmatch(text)
+	if len(match) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(match[1])
+}
+
+// validateJSONSchema validate value theo JSON Schema, trả về danh sách lỗi (rỗng nếu hợp lệ)
+// Schema không hợp lệ (không compile được) được báo như một lỗi để người cấu hình step thấy
+func validateJSONSchema(schema map[string]interface{}, value interface{}) []string {
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewGoLoader(value))
+	if err != nil {
+		return []string{fmt.Sprintf("outputSchema không hợp lệ: %v", err)}
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	errs := make([]string, 0, len(result.Errors()))
+	for i, resultErr := range result.Errors() {
+		if i >= maxReportedSchemaErrors {
+			errs = append(errs, fmt.Sprintf("... và %d lỗi khác", len(result.Errors())-maxReportedSchemaErrors))
+			break
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", resultErr.Field(), resultErr.Description()))
+	}
+	return errs
+}
+
+// parseAndValidateOutput parse response và validate theo outputSchema
+// Trả về output đã parse (có thể nil nếu không parse được) và danh sách lỗi
+func parseAndValidateOutput(responseText string, outputSchema map[string]interface{}) (map[string]interface{}, []string) {
+	parsed, err := parseJSONOutput(responseText)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	return parsed, validateJSONSchema(outputSchema, parsed)
+}
+
+// buildSchemaRepairRequest tạo request yêu cầu model sửa output: giữ prompt gốc,
+// thêm response trước đó và một lượt user liệt kê lỗi validate kèm schema
+func buildSchemaRepairRequest(original AICallRequest, previousResponse string, validationErrors []string, outputSchema map[string]interface{}) AICallRequest {
+	schemaJSON, _ := json.MarshalIndent(outputSchema, "", "  ")
+
+	var repairPrompt strings.Builder
+	repairPrompt.WriteString("Response trước của bạn không khớp JSON Schema yêu cầu. Các lỗi:\n")
+	for _, validationErr := range validationErrors {
+		repairPrompt.WriteString("- ")
+		repairPrompt.WriteString(validationErr)
+		repairPrompt.WriteString("\n")
+	}
+	repairPrompt.WriteString("\nJSON Schema:\n")
+	repairPrompt.Write(schemaJSON)
+	repairPrompt.WriteString("\n\nHãy trả lời lại CHỈ bằng một JSON object hợp lệ theo schema trên, giữ nguyên nội dung đã sinh nếu có thể, không kèm giải thích hay markdown.")
+
+	repair := original
+	repair.Messages = append(append([]AIMessage{}, original.Messages...),
+		AIMessage{Role: "user", Content: original.Prompt},
+		AIMessage{Role: "assistant", Content: previousResponse},
+	)
+	repair.Prompt = repairPrompt.String()
+	return repair
+}
+
+// nativeStructuredOutput kiểm tra có dùng structured output của provider cho request không
+// Mặc định bật với OpenAI, Anthropic, Google, Cohere; Custom provider cần config {"structuredOutput": true}
+// Profile có thể tắt bằng {"structuredOutput": false}
+func nativeStructuredOutput(req AICallRequest) bool {
+	if len(req.ResponseSchema) == 0 || req.ProviderProfile == nil {
+		return false
+	}
+	enabled, configured := req.ProviderProfile.Config["structuredOutput"].(bool)
+	if configured {
+		return enabled
+	}
+	return req.ProviderProfile.Provider != AIProviderTypeCustom
+}
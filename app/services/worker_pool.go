@@ -0,0 +1,261 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần giới hạn đồng thời của workflow commands:
+  - WorkflowWorkerPool (dựa trên boundedPool, dùng chung với CommandExecutor): giới hạn số workflow command chạy cùng lúc (config "maxConcurrentWorkflows" của workflow-commands-job),
+    job chỉ claim đúng số slot còn trống, command nhận dư (nếu server trả nhiều hơn) được xếp hàng chờ slot
+  - Giới hạn số AI call đồng thời theo provider (config "providerConcurrency"), call vượt giới hạn đợi đến khi có slot
+    hoặc context của command bị hủy
+  - Số worker đang chạy, hàng đợi được gửi lên server trong check-in metrics
+  - Hủy command từ server: command đang chạy bị hủy context, command đang xếp hàng bị bỏ khỏi hàng đợi
+*/
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+)
+
+// defaultMaxConcurrentWorkflows là số workflow command chạy đồng thời tối đa mặc định
+const defaultMaxConcurrentWorkflows = 3
+
+// workflowCommandsJobName là job chứa config chạy workflow (số workflow/step song song, giới hạn provider, AI cache, sửa schema)
+const workflowCommandsJobName = "workflow-commands-job"
+
+// WorkflowWorkerMetrics là trạng thái worker pool gửi lên server trong check-in metrics
+type WorkflowWorkerMetrics struct {
+	MaxConcurrent    int            `json:"maxConcurrent"`              // Số workflow chạy đồng thời tối đa
+	ActiveWorkers    int            `json:"activeWorkers"`              // Số command đang chạy
+	QueueDepth       int            `json:"queueDepth"`                 // Số command đã claim đang đợi slot
+	WaitingAICalls   int            `json:"waitingAICalls"`             // Số AI call đang đợi slot của provider
+	ActiveCommands   []string       `json:"activeCommands,omitempty"`   // ID các command đang chạy
+	ProviderInFlight map[string]int `json:"providerInFlight,omitempty"` // Số AI call đang chạy theo provider
+	LongestRunningS  float64        `json:"longestRunningSeconds"`      // Thời gian chạy của command lâu nhất (giây)
+}
+
+// WorkflowWorkerPool giới hạn số workflow command chạy đồng thời
+type WorkflowWorkerPool struct {
+	pool *boundedPool
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc // commandID → hàm hủy context của command đang chạy
+}
+
+var (
+	globalWorkflowPool     *WorkflowWorkerPool
+	globalWorkflowPoolOnce sync.Once
+)
+
+// GetWorkflowWorkerPool trả về worker pool dùng chung của agent
+func GetWorkflowWorkerPool() *WorkflowWorkerPool {
+	globalWorkflowPoolOnce.Do(func() {
+		globalWorkflowPool = &WorkflowWorkerPool{
+			cancels: make(map[string]context.CancelCauseFunc),
+		}
+		globalWorkflowPool.pool = newBoundedPool("WorkerPool", globalWorkflowPool.MaxConcurrent)
+	})
+	return globalWorkflowPool
+}
+
+// MaxConcurrent đọc số workflow chạy đồng thời tối đa từ config (tối thiểu 1)
+func (p *WorkflowWorkerPool) MaxConcurrent() int {
+	limit := defaultMaxConcurrentWorkflows
+	if cm := GetGlobalConfigManager(); cm != nil {
+		limit = cm.GetJobConfigInt(workflowCommandsJobName, "maxConcurrentWorkflows", defaultMaxConcurrentWorkflows)
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// FreeSlots trả về số command có thể claim thêm (slot trống trừ đi command đang xếp hàng)
+func (p *WorkflowWorkerPool) FreeSlots() int {
+	return p.pool.freeSlots()
+}
+
+// Has kiểm tra command đang chạy hoặc đang xếp hàng (tránh xử lý trùng khi server trả lại command cũ)
+func (p *WorkflowWorkerPool) Has(id string) bool {
+	return p.pool.has(id)
+}
+
+// Submit chạy run trong goroutine riêng nếu còn slot, ngược lại xếp hàng đợi worker khác xong
+// Trả về false nếu command đã có trong pool (không submit lại)
+func (p *WorkflowWorkerPool) Submit(id string, run func()) bool {
+	return p.pool.submit(id, run)
+}
+
+// RegisterCancel đăng ký hàm hủy context của command đang chạy, trả về hàm hủy đăng ký (gọi khi command kết thúc)
+func (p *WorkflowWorkerPool) RegisterCancel(id string, cancel context.CancelCauseFunc) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cancels[id] = cancel
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.cancels, id)
+	}
+}
+
+// Cancel hủy command: command đang chạy bị hủy context (worker tự kết thúc và cập nhật trạng thái "cancelled"),
+// command đang xếp hàng bị bỏ khỏi hàng đợi (queued = true, người gọi cập nhật trạng thái)
+// Trả về found = false nếu command không có trong pool
+func (p *WorkflowWorkerPool) Cancel(id, reason string) (found bool, queued bool) {
+	p.mu.Lock()
+	cancel, running := p.cancels[id]
+	p.mu.Unlock()
+	if running {
+		log.Printf("[WorkerPool] ⛔ Hủy command đang chạy %s: %s", id, reason)
+		cancel(errors.New(reason))
+		return true, false
+	}
+	if p.pool.dequeue(id) {
+		log.Printf("[WorkerPool] ⛔ Bỏ command %s khỏi hàng đợi: %s", id, reason)
+		return true, true
+	}
+	return false, false
+}
+
+// Metrics trả về trạng thái hiện tại của pool và giới hạn provider
+func (p *WorkflowWorkerPool) Metrics() *WorkflowWorkerMetrics {
+	activeIDs, queueDepth, longestRunning := p.pool.snapshot()
+	metrics := &WorkflowWorkerMetrics{
+		MaxConcurrent:   p.MaxConcurrent(),
+		ActiveWorkers:   len(activeIDs),
+		QueueDepth:      queueDepth,
+		ActiveCommands:  activeIDs,
+		LongestRunningS: longestRunning.Seconds(),
+	}
+	metrics.WaitingAICalls, metrics.ProviderInFlight = globalProviderLimiter.snapshot()
+	return metrics
+}
+
+// providerLimiter giới hạn số AI call đồng thời theo provider
+// Key giới hạn là ID của provider profile hoặc loại provider (ví dụ {"openai": 4, "<profileId>": 1}), ID profile ưu tiên hơn
+// Slot được cấp theo số call đang chạy thực tế (inFlight) nên giới hạn giảm trong config có hiệu lực ngay với call mới,
+// kể cả khi các call cũ chưa xong. Call đang đợi slot được đánh thức mỗi khi có call giải phóng slot và hủy được theo context
+type providerLimiter struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+	waiting  int
+	released chan struct{} // Đóng (rồi thay mới) mỗi khi có call giải phóng slot
+}
+
+var globalProviderLimiter = newProviderLimiter()
+
+func newProviderLimiter() *providerLimiter {
+	return &providerLimiter{
+		inFlight: make(map[string]int),
+		released: make(chan struct{}),
+	}
+}
+
+// providerConcurrencyLimit đọc giới hạn của profile từ config "providerConcurrency" (0 = không giới hạn)
+func providerConcurrencyLimit(profile *AIProviderProfile) (string, int) {
+	cm := GetGlobalConfigManager()
+	if cm == nil || profile == nil {
+		return "", 0
+	}
+	value, ok := cm.GetJobConfigValue(workflowCommandsJobName, "providerConcurrency")
+	if !ok {
+		return "", 0
+	}
+	limits, ok := value.(map[string]interface{})
+	if !ok {
+		return "", 0
+	}
+	for _, key := range []string{profile.ID, strings.ToLower(profile.Provider)} {
+		if key == "" {
+			continue
+		}
+		if limit, ok := toFloat64(limits[key]); ok && limit > 0 {
+			return key, int(limit)
+		}
+	}
+	return "", 0
+}
+
+// tryAcquire cấp slot nếu key còn dưới giới hạn, ngược lại trả về channel được đóng khi có call giải phóng slot
+func (l *providerLimiter) tryAcquire(key string, limit int) (bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[key] < limit {
+		l.inFlight[key]++
+		return true, nil
+	}
+	return false, l.released
+}
+
+// release giải phóng slot của key và đánh thức các call đang đợi
+func (l *providerLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight[key]--
+	if l.inFlight[key] <= 0 {
+		delete(l.inFlight, key)
+	}
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// addWaiting cập nhật số call đang đợi slot
+func (l *providerLimiter) addWaiting(delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waiting += delta
+}
+
+// acquireProviderSlot đợi đến khi provider còn slot, trả về hàm giải phóng slot
+// Giới hạn được đọc lại sau mỗi lần được đánh thức (config thay đổi có hiệu lực với cả call đang đợi)
+// ctx bị hủy trong lúc đợi (command bị hủy từ server) → trả về lỗi, không chiếm slot
+func acquireProviderSlot(ctx context.Context, profile *AIProviderProfile) (func(), error) {
+	l := globalProviderLimiter
+	waiting := false
+	for {
+		key, limit := providerConcurrencyLimit(profile)
+		if limit <= 0 {
+			if waiting {
+				l.addWaiting(-1)
+			}
+			return func() {}, nil
+		}
+
+		acquired, released := l.tryAcquire(key, limit)
+		if acquired {
+			if waiting {
+				l.addWaiting(-1)
+			}
+			var once sync.Once
+			return func() {
+				once.Do(func() { l.release(key) })
+			}, nil
+		}
+
+		if !waiting {
+			waiting = true
+			l.addWaiting(1)
+			log.Printf("[AIClient] [Concurrency] ⏳ Provider %s đã đạt giới hạn %d call đồng thời, đợi slot...", key, limit)
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			l.addWaiting(-1)
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+func (l *providerLimiter) snapshot() (int, map[string]int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.inFlight) == 0 {
+		return l.waiting, nil
+	}
+	inFlight := make(map[string]int, len(l.inFlight))
+	for key, count := range l.inFlight {
+		inFlight[key] = count
+	}
+	return l.waiting, inFlight
+}
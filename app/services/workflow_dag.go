@@ -0,0 +1,831 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần thực thi workflow theo DAG (đồ thị phụ thuộc giữa các step).
+Mỗi phần tử của workflow.steps có thể khai báo thêm:
+  - "key": tên node trong workflow (mặc định = id của step)
+  - "dependsOn": danh sách key của các step phải xong trước
+  - "forEach": fan-out - chạy step song song cho từng phần tử trong output của step phụ thuộc,
+    ví dụ "candidates" hoặc "generate.candidates" (key.field khi có nhiều step phụ thuộc)
+  - "fanIn": true - gom output của tất cả step phụ thuộc (kể cả mọi nhánh fan-out) vào parentContent
+    ("inputs" và "candidates"), ví dụ JUDGE trên toàn bộ candidates
+  - "when": điều kiện theo output của step phụ thuộc, không thỏa thì bỏ qua step
+    ({"step": "judge", "field": "bestCandidate.score", "op": "gte", "value": 7}, hoặc danh sách điều kiện - thỏa tất cả)
+  - "continueOnError": true - step lỗi không làm hỏng workflow, các step phụ thuộc coi như step bị bỏ qua
+
+Workflow không khai báo các field trên chạy tuần tự như trước (step sau nhận draft node của step trước làm parent).
+Các step đủ điều kiện chạy song song, tối đa "maxParallelSteps" (config của workflow-commands-job).
+Step có phụ thuộc bị bỏ qua khi mọi step phụ thuộc đều bị bỏ qua hoặc lỗi.
+*/
+package services
+
+import (
+	"agent_pancake/app/integrations"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// defaultMaxParallelSteps là số step (kể cả nhánh fan-out) chạy song song tối đa trong một workflow run
+const defaultMaxParallelSteps = 4
+
+// Trạng thái của node trong DAG
+const (
+	dagNodePending   = "pending"
+	dagNodeRunning   = "running"
+	dagNodeCompleted = "completed"
+	dagNodeSkipped   = "skipped"
+	dagNodeFailed    = "failed"
+	// Step HUMAN_REVIEW đang chờ người duyệt: workflow tạm dừng, step chạy tiếp khi command được giao lại
+	dagNodeWaitingReview = "waiting_review"
+)
+
+// workflowNode là một step trong DAG của workflow
+type workflowNode struct {
+	Key             string
+	StepID          string
+	DependsOn       []string
+	ForEachStep     string // Key của step phụ thuộc cung cấp danh sách fan-out
+	ForEachField    string // Đường dẫn field (a.b.c) trong output của ForEachStep
+	FanIn           bool
+	When            []map[string]interface{}
+	ContinueOnError bool
+}
+
+// workflowNodeState là trạng thái thực thi của một node
+type workflowNodeState struct {
+	status  string
+	reason  string // Lý do bỏ qua / lỗi
+	results []*StepResult
+
+	reviewStepRunIDs []string // Step run đang chờ duyệt (theo thứ tự nhánh fan-out), dùng lại khi chạy tiếp
+
+	// Parent mà các step phụ thuộc nhận được (draft node do step tạo ra, hoặc parent của chính step)
+	parentId      string
+	parentType    string
+	parentContent map[string]interface{}
+}
+
+// outputs trả về output của tất cả lần chạy của node (nhiều phần tử khi fan-out)
+func (s *workflowNodeState) outputs() []map[string]interface{} {
+	outputs := make([]map[string]interface{}, 0, len(s.results))
+	for _, result := range s.results {
+		if result != nil && result.Output != nil {
+			outputs = append(outputs, result.Output)
+		}
+	}
+	return outputs
+}
+
+// parseWorkflowDAG đọc workflow.steps thành danh sách node, kiểm tra phụ thuộc và chu trình
+func parseWorkflowDAG(steps []interface{}) ([]*workflowNode, error) {
+	var nodes []*workflowNode
+	byKey := make(map[string]*workflowNode)
+	declared := false
+
+	for i, stepInterface := range steps {
+		stepMap, ok := stepInterface.(map[string]interface{})
+		if !ok {
+			log.Printf("[WorkflowExecutor] ⚠️  Step không phải là map, bỏ qua")
+			continue
+		}
+		stepId, _ := stepMap["id"].(string)
+		if stepId == "" {
+			log.Printf("[WorkflowExecutor] ⚠️  Step không có ID, bỏ qua")
+			continue
+		}
+
+		node := &workflowNode{StepID: stepId, Key: getString(stepMap, "key")}
+		if node.Key == "" {
+			node.Key = stepId
+			if _, exists := byKey[node.Key]; exists {
+				// Workflow tuần tự có thể dùng lại cùng một step nhiều lần
+				node.Key = fmt.Sprintf("%s#%d", stepId, i+1)
+			}
+		}
+		if _, exists := byKey[node.Key]; exists {
+			return nil, fmt.Errorf("key step bị trùng: %s", node.Key)
+		}
+
+		if deps, ok := stepMap["dependsOn"]; ok {
+			declared = true
+			node.DependsOn = toStringList(deps)
+		}
+		node.FanIn, _ = stepMap["fanIn"].(bool)
+		node.ContinueOnError, _ = stepMap["continueOnError"].(bool)
+		node.When = toConditionList(stepMap["when"])
+		forEach := getString(stepMap, "forEach")
+		if forEach != "" || node.FanIn || len(node.When) > 0 {
+			declared = true
+		}
+		if forEach != "" {
+			node.ForEachField = forEach
+		}
+
+		nodes = append(nodes, node)
+		byKey[node.Key] = node
+	}
+
+	// Workflow cũ (không khai báo phụ thuộc) → chạy tuần tự
+	if !declared {
+		for i := 1; i < len(nodes); i++ {
+			nodes[i].DependsOn = []string{nodes[i-1].Key}
+		}
+		return nodes, nil
+	}
+
+	for _, node := range nodes {
+		for _, dep := range node.DependsOn {
+			if _, ok := byKey[dep]; !ok {
+				return nil, fmt.Errorf("step %s phụ thuộc step không tồn tại: %s", node.Key, dep)
+			}
+			if dep == node.Key {
+				return nil, fmt.Errorf("step %s phụ thuộc chính nó", node.Key)
+			}
+		}
+		if node.ForEachField != "" {
+			if len(node.DependsOn) == 0 {
+				return nil, fmt.Errorf("step %s dùng forEach nhưng không có dependsOn", node.Key)
+			}
+			node.ForEachStep = node.DependsOn[0]
+			if prefix, field, ok := strings.Cut(node.ForEachField, "."); ok && containsString(node.DependsOn, prefix) {
+				node.ForEachStep, node.ForEachField = prefix, field
+			}
+		}
+		for _, cond := range node.When {
+			if len(node.DependsOn) == 0 {
+				return nil, fmt.Errorf("step %s có điều kiện when nhưng không có dependsOn", node.Key)
+			}
+			if step := getString(cond, "step"); step != "" && !containsString(node.DependsOn, step) {
+				return nil, fmt.Errorf("điều kiện của step %s tham chiếu step %s không nằm trong dependsOn", node.Key, step)
+			}
+		}
+	}
+
+	if cycle := findDAGCycle(nodes); cycle != "" {
+		return nil, fmt.Errorf("workflow có phụ thuộc vòng tại step %s", cycle)
+	}
+	return nodes, nil
+}
+
+// findDAGCycle trả về key của một node nằm trong chu trình (rỗng nếu không có) - thuật toán Kahn
+func findDAGCycle(nodes []*workflowNode) string {
+	indegree := make(map[string]int, len(nodes))
+	dependents := make(map[string][]string)
+	for _, node := range nodes {
+		indegree[node.Key] = len(node.DependsOn)
+		for _, dep := range node.DependsOn {
+			dependents[dep] = append(dependents[dep], node.Key)
+		}
+	}
+	var queue []string
+	for _, node := range nodes {
+		if indegree[node.Key] == 0 {
+			queue = append(queue, node.Key)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, dependent := range dependents[key] {
+			indegree[dependent]--
+			if indegree[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	if visited == len(nodes) {
+		return ""
+	}
+	for _, node := range nodes {
+		if indegree[node.Key] > 0 {
+			return node.Key
+		}
+	}
+	return ""
+}
+
+// workflowDAGRun là ngữ cảnh của một lần thực thi DAG
+type workflowDAGRun struct {
+	workflowRunID string
+	agentId       string
+	commandID     string
+	rootRefId     string
+	rootRefType   string
+	rootContent   map[string]interface{}
+	params        map[string]interface{}
+	budget        *AIBudget
+	restored      map[string]*workflowNodeState // Step đã kết thúc trước khi agent khởi động lại (xem workflow_resume.go)
+}
+
+// dagNodeInput là parent và danh sách fan-out đã resolve cho một node trước khi chạy
+type dagNodeInput struct {
+	parentId      string
+	parentType    string
+	parentContent map[string]interface{}
+	items         []interface{} // Phần tử fan-out (nil nếu không fan-out)
+
+	reviewStepRunIDs []string // Step run đang chờ duyệt của lần chạy trước (theo thứ tự nhánh fan-out)
+}
+
+// dagNodeDone là kết quả chạy một node gửi về vòng lặp điều phối
+type dagNodeDone struct {
+	node    *workflowNode
+	input   dagNodeInput
+	results []*StepResult
+	err     error
+}
+
+// executeDAG chạy các node theo phụ thuộc, song song tối đa maxParallelSteps
+// Chỉ goroutine điều phối (hàm này) đọc/ghi states, các node chạy trong goroutine riêng và gửi kết quả qua channel
+func (e *WorkflowExecutor) executeDAG(nodes []*workflowNode, run workflowDAGRun) (map[string]*workflowNodeState, error) {
+	states := make(map[string]*workflowNodeState, len(nodes))
+	finished := 0
+	for _, node := range nodes {
+		if state, ok := run.restored[node.Key]; ok && state.status == dagNodeWaitingReview {
+			// Step đang chờ duyệt chạy lại với step run cũ (đọc kết quả duyệt thay vì tạo yêu cầu duyệt mới)
+			log.Printf("[WorkflowExecutor] ▶️  Step %s đang chờ duyệt, kiểm tra kết quả duyệt", node.Key)
+			states[node.Key] = &workflowNodeState{status: dagNodePending, reviewStepRunIDs: state.reviewStepRunIDs}
+			continue
+		}
+		if state, ok := run.restored[node.Key]; ok {
+			if state.parentContent == nil {
+				state.parentContent = run.rootContent
+			}
+			log.Printf("[WorkflowExecutor] ⏩ Step %s đã %s trước đó, không chạy lại", node.Key, state.status)
+			states[node.Key] = state
+			finished++
+			continue
+		}
+		states[node.Key] = &workflowNodeState{status: dagNodePending}
+	}
+
+	maxParallel := defaultMaxParallelSteps
+	if cm := GetGlobalConfigManager(); cm != nil {
+		maxParallel = cm.GetJobConfigInt(workflowCommandsJobName, "maxParallelSteps", defaultMaxParallelSteps)
+	}
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	// Giới hạn chung cho cả node và nhánh fan-out
+	slots := make(chan struct{}, maxParallel)
+
+	totalNodes := len(nodes)
+	doneCh := make(chan dagNodeDone)
+	running := 0
+	trackerHolder := "" // Node đang dùng tracker (chỉ một node báo tiến độ chi tiết tại một thời điểm)
+	var runErr error
+
+	for {
+		// Lên lịch các node đã đủ điều kiện (lặp lại vì node bị bỏ qua có thể làm node khác đủ điều kiện)
+		for scheduled := true; scheduled && runErr == nil; {
+			scheduled = false
+			for _, node := range nodes {
+				state := states[node.Key]
+				if state.status != dagNodePending || !dagDepsSettled(node, states) {
+					continue
+				}
+				if reason := dagSkipReason(node, states); reason != "" {
+					state.status, state.reason = dagNodeSkipped, reason
+					finished++
+					scheduled = true
+					log.Printf("[WorkflowExecutor] ⏭️  Bỏ qua step %s: %s", node.Key, reason)
+					continue
+				}
+				input, err := dagResolveInput(node, states, run)
+				if err != nil {
+					runErr = fmt.Errorf("lỗi khi chuẩn bị step %s: %w", node.StepID, err)
+					break
+				}
+				input.reviewStepRunIDs = state.reviewStepRunIDs
+				if node.ForEachField != "" && len(input.items) == 0 {
+					state.status, state.reason = dagNodeSkipped, "không có phần tử để fan-out"
+					finished++
+					scheduled = true
+					log.Printf("[WorkflowExecutor] ⏭️  Bỏ qua step %s: %s", node.Key, state.reason)
+					continue
+				}
+				// Command bị hủy hoặc hết ngân sách thì không chạy step tiếp theo
+				if err := e.cancelled(); err != nil {
+					log.Printf("[WorkflowExecutor] ⛔ Command bị hủy, dừng trước step %s", node.StepID)
+					runErr = err
+					break
+				}
+				if err := run.budget.Check(); err != nil {
+					log.Printf("[WorkflowExecutor] ❌ %v, dừng trước step %s", err, node.StepID)
+					runErr = err
+					break
+				}
+
+				var tracker *ProgressTracker
+				if trackerHolder == "" && e.progress != nil {
+					trackerHolder = node.Key
+					tracker = e.progress
+					span := workflowStepsTo - workflowStepsFrom
+					tracker.SetRange(workflowStepsFrom+span*finished/totalNodes, workflowStepsFrom+span*(finished+1)/totalNodes)
+				}
+				state.status = dagNodeRunning
+				running++
+				scheduled = true
+				e.reportNodeStart(node, finished, totalNodes, tracker, run)
+				go func(node *workflowNode, input dagNodeInput, tracker *ProgressTracker) {
+					results, err := e.runDAGNode(node, input, tracker, slots, run)
+					doneCh <- dagNodeDone{node: node, input: input, results: results, err: err}
+				}(node, input, tracker)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		done := <-doneCh
+		running--
+		finished++
+		if trackerHolder == done.node.Key {
+			trackerHolder = ""
+		}
+		state := states[done.node.Key]
+		state.results = done.results
+		var waitingErr *StepWaitingReviewError
+		if errors.As(done.err, &waitingErr) {
+			// Không lên lịch step mới, đợi các step đang chạy xong rồi tạm dừng workflow (checkpoint giữ step run đang chờ)
+			state.status, state.reason = dagNodeWaitingReview, done.err.Error()
+			state.reviewStepRunIDs = waitingErr.StepRunIDs
+			log.Printf("[WorkflowExecutor] ⏸️  Step %s đang chờ duyệt, tạm dừng workflow sau khi các step đang chạy xong", done.node.Key)
+			if runErr == nil {
+				runErr = done.err
+			}
+			e.saveCheckpoint(run, nodes, states)
+			continue
+		}
+		if done.err != nil {
+			state.status, state.reason = dagNodeFailed, done.err.Error()
+			log.Printf("[WorkflowExecutor] ❌ Lỗi khi execute step %s: %v", done.node.StepID, done.err)
+			// Lỗi thật của step khác được ưu tiên hơn việc tạm dừng chờ duyệt
+			if (!done.node.ContinueOnError || errors.Is(done.err, context.Canceled)) && (runErr == nil || errors.As(runErr, &waitingErr)) {
+				runErr = fmt.Errorf("lỗi khi execute step %s: %w", done.node.StepID, done.err)
+			}
+			if done.node.ContinueOnError {
+				e.saveCheckpoint(run, nodes, states)
+			}
+			continue
+		}
+
+		state.status = dagNodeCompleted
+		state.parentId, state.parentType, state.parentContent = done.input.parentId, done.input.parentType, done.input.parentContent
+		// Step tạo đúng một draft node → draft node là parent của các step phụ thuộc (như khi chạy tuần tự)
+		if len(done.results) == 1 && done.results[0].DraftNodeID != "" {
+			result := done.results[0]
+			log.Printf("[WorkflowExecutor] Update parent cho step tiếp theo: %s", result.DraftNodeID)
+			state.parentId = result.DraftNodeID
+			state.parentType = e.determineNodeType(done.input.parentType)
+			state.parentContent = map[string]interface{}{
+				"id":   result.DraftNodeID,
+				"type": state.parentType,
+				"text": result.Output["content"],
+			}
+		}
+		log.Printf("[WorkflowExecutor] ✅ Step %s hoàn thành (%d/%d, %d lần chạy)", done.node.Key, finished, totalNodes, len(done.results))
+		e.saveCheckpoint(run, nodes, states)
+		if trackerHolder == "" && e.progress != nil {
+			span := workflowStepsTo - workflowStepsFrom
+			e.progress.SetRange(workflowStepsFrom+span*finished/totalNodes, workflowStepsFrom+span*finished/totalNodes)
+			e.progress.Update("executing_step", 100, fmt.Sprintf("Đã xong %d/%d step", finished, totalNodes))
+		}
+	}
+
+	if runErr == nil && finished < totalNodes {
+		runErr = fmt.Errorf("còn %d step không thể chạy (phụ thuộc không được thỏa)", totalNodes-finished)
+	}
+	return states, runErr
+}
+
+// reportNodeStart log và gửi heartbeat khi bắt đầu một node
+func (e *WorkflowExecutor) reportNodeStart(node *workflowNode, finished, totalNodes int, tracker *ProgressTracker, run workflowDAGRun) {
+	log.Printf("[WorkflowExecutor] ───────────────────────────────────────")
+	log.Printf("[WorkflowExecutor] 📍 EXECUTE STEP %s (%d/%d đã xong)", node.Key, finished, totalNodes)
+	log.Printf("[WorkflowExecutor] StepId: %s, DependsOn: %v", node.StepID, node.DependsOn)
+	log.Printf("[WorkflowExecutor] ───────────────────────────────────────")
+
+	message := fmt.Sprintf("Đang execute step %d/%d: %s", finished+1, totalNodes, node.Key)
+	progress := map[string]interface{}{
+		"step":       "executing_step",
+		"percentage": finished * 100 / totalNodes,
+		"message":    message,
+	}
+	if tracker != nil {
+		tracker.Update("executing_step", 0, message)
+		progress, _ = tracker.Snapshot()
+	} else if e.progress != nil {
+		progress, _ = e.progress.Snapshot()
+		progress["message"] = message
+	}
+	integrations.FolkForm_UpdateWorkflowCommandHeartbeat(run.agentId, run.commandID, progress)
+}
+
+// runDAGNode chạy một node: một lần, hoặc song song cho từng phần tử fan-out
+func (e *WorkflowExecutor) runDAGNode(node *workflowNode, input dagNodeInput, tracker *ProgressTracker, slots chan struct{}, run workflowDAGRun) ([]*StepResult, error) {
+	newStepExecutor := func(tracker *ProgressTracker, branch int) *StepExecutor {
+		executor := NewStepExecutor(e.aiClient).WithProgress(tracker).WithBudget(run.budget).WithCacheMode(AICacheModeFromParams(run.params)).WithContext(e.ctx)
+		if branch < len(input.reviewStepRunIDs) && input.reviewStepRunIDs[branch] != "" {
+			executor.WithResumeStepRun(input.reviewStepRunIDs[branch])
+		}
+		return executor
+	}
+
+	if input.items == nil {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		result, err := newStepExecutor(tracker, 0).ExecuteStep(node.StepID, input.parentId, input.parentType, run.workflowRunID, input.parentContent)
+		if err != nil {
+			return nil, err
+		}
+		return []*StepResult{result}, nil
+	}
+
+	log.Printf("[WorkflowExecutor] 🔀 Fan-out step %s cho %d phần tử", node.Key, len(input.items))
+	results := make([]*StepResult, len(input.items))
+	errs := make([]error, len(input.items))
+	var wg sync.WaitGroup
+	for i, item := range input.items {
+		wg.Add(1)
+		go func(i int, item interface{}) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			// Chỉ báo tiến độ chi tiết khi fan-out một phần tử, nhiều nhánh song song sẽ ghi đè lẫn nhau
+			var branchTracker *ProgressTracker
+			if len(input.items) == 1 {
+				branchTracker = tracker
+			}
+			if err := e.cancelled(); err != nil {
+				errs[i] = err
+				return
+			}
+			if err := run.budget.Check(); err != nil {
+				errs[i] = err
+				return
+			}
+			content := fanOutContent(input.parentContent, item, i)
+			results[i], errs[i] = newStepExecutor(branchTracker, i).ExecuteStep(node.StepID, input.parentId, input.parentType, run.workflowRunID, content)
+		}(i, item)
+	}
+	wg.Wait()
+
+	// Nhánh chờ duyệt: gom step run của mọi nhánh (nhánh đã duyệt xong đọc lại kết quả khi chạy tiếp)
+	var succeeded []*StepResult
+	waiting := &StepWaitingReviewError{StepRunIDs: make([]string, len(input.items))}
+	hasWaiting := false
+	for i, err := range errs {
+		var waitingErr *StepWaitingReviewError
+		if errors.As(err, &waitingErr) {
+			hasWaiting = true
+			if len(waitingErr.StepRunIDs) > 0 {
+				waiting.StepRunIDs[i] = waitingErr.StepRunIDs[0]
+			}
+			continue
+		}
+		if err != nil {
+			return succeeded, fmt.Errorf("nhánh fan-out %d/%d: %w", i+1, len(input.items), err)
+		}
+		succeeded = append(succeeded, results[i])
+		waiting.StepRunIDs[i] = results[i].StepRunID
+	}
+	if hasWaiting {
+		return nil, waiting
+	}
+	return succeeded, nil
+}
+
+// dagDepsSettled kiểm tra mọi step phụ thuộc đã kết thúc (xong, bỏ qua hoặc lỗi)
+func dagDepsSettled(node *workflowNode, states map[string]*workflowNodeState) bool {
+	for _, dep := range node.DependsOn {
+		switch states[dep].status {
+		case dagNodeCompleted, dagNodeSkipped, dagNodeFailed:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// dagSkipReason trả về lý do bỏ qua node (rỗng nếu node được chạy)
+func dagSkipReason(node *workflowNode, states map[string]*workflowNodeState) string {
+	if len(node.DependsOn) > 0 {
+		active := 0
+		for _, dep := range node.DependsOn {
+			if states[dep].status == dagNodeCompleted {
+				active++
+			}
+		}
+		if active == 0 {
+			return "mọi step phụ thuộc đều bị bỏ qua hoặc lỗi"
+		}
+	}
+	if node.ForEachStep != "" && states[node.ForEachStep].status != dagNodeCompleted {
+		return fmt.Sprintf("step fan-out %s không hoàn thành", node.ForEachStep)
+	}
+	for _, cond := range node.When {
+		step := getString(cond, "step")
+		if step == "" {
+			step = node.DependsOn[0]
+		}
+		if !evaluateStepCondition(cond, states[step].outputs()) {
+			condJSON, _ := json.Marshal(cond)
+			return fmt.Sprintf("điều kiện không thỏa: %s", condJSON)
+		}
+	}
+	return ""
+}
+
+// dagResolveInput xác định parent (và danh sách fan-out) cho node từ các step phụ thuộc đã xong
+func dagResolveInput(node *workflowNode, states map[string]*workflowNodeState, run workflowDAGRun) (dagNodeInput, error) {
+	input := dagNodeInput{parentId: run.rootRefId, parentType: run.rootRefType, parentContent: run.rootContent}
+
+	var active []*workflowNodeState
+	for _, dep := range node.DependsOn {
+		if state := states[dep]; state.status == dagNodeCompleted {
+			active = append(active, state)
+		}
+	}
+	if len(active) > 0 {
+		input.parentId, input.parentType, input.parentContent = active[0].parentId, active[0].parentType, active[0].parentContent
+	}
+
+	if node.FanIn {
+		var outputs []interface{}
+		var candidates []interface{}
+		for _, state := range active {
+			for _, output := range state.outputs() {
+				outputs = append(outputs, output)
+				if list, ok := output["candidates"].([]interface{}); ok {
+					candidates = append(candidates, list...)
+				}
+			}
+		}
+		content := copyContent(input.parentContent)
+		content["inputs"] = outputs
+		content["candidates"] = candidates
+		input.parentContent = content
+	}
+
+	if node.ForEachField != "" {
+		input.items = []interface{}{}
+		for _, output := range states[node.ForEachStep].outputs() {
+			value, ok := lookupOutputPath(output, node.ForEachField)
+			if !ok {
+				continue
+			}
+			list, ok := value.([]interface{})
+			if !ok {
+				return input, fmt.Errorf("forEach %s.%s không phải danh sách", node.ForEachStep, node.ForEachField)
+			}
+			input.items = append(input.items, list...)
+		}
+	}
+	return input, nil
+}
+
+// fanOutContent tạo parentContent cho một nhánh fan-out: text của phần tử, phần tử gốc và vị trí
+func fanOutContent(parentContent map[string]interface{}, item interface{}, index int) map[string]interface{} {
+	content := copyContent(parentContent)
+	content["item"] = item
+	content["index"] = index
+	switch value := item.(type) {
+	case string:
+		content["text"] = value
+	case map[string]interface{}:
+		if text := getString(value, "content"); text != "" {
+			content["text"] = text
+		} else if text := getString(value, "text"); text != "" {
+			content["text"] = text
+		}
+		if candidateId := getString(value, "candidateId"); candidateId != "" {
+			content["candidateId"] = candidateId
+		}
+	default:
+		if data, err := json.Marshal(value); err == nil {
+			content["text"] = string(data)
+		}
+	}
+	return content
+}
+
+// evaluateStepCondition kiểm tra điều kiện "when" với output của step (fan-out: thỏa nếu một nhánh bất kỳ thỏa)
+// op: eq (mặc định khi có value), ne, gt, gte, lt, lte, in, contains, exists, notExists, truthy (mặc định khi không có value)
+func evaluateStepCondition(cond map[string]interface{}, outputs []map[string]interface{}) bool {
+	op := strings.ToLower(getString(cond, "op"))
+	expected, hasValue := cond["value"]
+	if op == "" {
+		op = "truthy"
+		if hasValue {
+			op = "eq"
+		}
+	}
+	field := getString(cond, "field")
+
+	if op == "notexists" {
+		for _, output := range outputs {
+			if _, ok := lookupOutputPath(output, field); ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, output := range outputs {
+		actual, ok := lookupOutputPath(output, field)
+		if !ok {
+			continue
+		}
+		if compareConditionValue(op, actual, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareConditionValue(op string, actual, expected interface{}) bool {
+	switch op {
+	case "exists":
+		return true
+	case "truthy":
+		switch value := actual.(type) {
+		case nil:
+			return false
+		case bool:
+			return value
+		case string:
+			return value != ""
+		case float64:
+			return value != 0
+		case []interface{}:
+			return len(value) > 0
+		}
+		return true
+	case "eq":
+		return conditionValuesEqual(actual, expected)
+	case "ne":
+		return !conditionValuesEqual(actual, expected)
+	case "gt", "gte", "lt", "lte":
+		a, okA := toFloat64(actual)
+		b, okB := toFloat64(expected)
+		if !okA || !okB {
+			return false
+		}
+		switch op {
+		case "gt":
+			return a > b
+		case "gte":
+			return a >= b
+		case "lt":
+			return a < b
+		default:
+			return a <= b
+		}
+	case "in":
+		list, _ := expected.([]interface{})
+		for _, value := range list {
+			if conditionValuesEqual(actual, value) {
+				return true
+			}
+		}
+		return false
+	case "contains":
+		switch value := actual.(type) {
+		case string:
+			return strings.Contains(value, fmt.Sprint(expected))
+		case []interface{}:
+			for _, element := range value {
+				if conditionValuesEqual(element, expected) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	log.Printf("[WorkflowExecutor] ⚠️  Toán tử điều kiện không hỗ trợ: %s", op)
+	return false
+}
+
+func conditionValuesEqual(a, b interface{}) bool {
+	if x, ok := toFloat64(a); ok {
+		if y, ok := toFloat64(b); ok {
+			return x == y
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// lookupOutputPath lấy giá trị theo đường dẫn "a.b.c" (phần tử mảng theo chỉ số: "candidates.0.content")
+func lookupOutputPath(data map[string]interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return data, true
+	}
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			next, ok := value[part]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			var index int
+			if _, err := fmt.Sscanf(part, "%d", &index); err != nil || index < 0 || index >= len(value) {
+				return nil, false
+			}
+			current = value[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// dagStatusSummary là trạng thái từng step gửi kèm khi update workflow run
+func dagStatusSummary(nodes []*workflowNode, states map[string]*workflowNodeState) map[string]interface{} {
+	summary := make(map[string]interface{}, len(nodes))
+	for _, node := range nodes {
+		state := states[node.Key]
+		entry := map[string]interface{}{
+			"stepId": node.StepID,
+			"status": state.status,
+		}
+		if state.reason != "" {
+			entry["reason"] = state.reason
+		}
+		var stepRunIds []string
+		for _, result := range state.results {
+			if result != nil {
+				stepRunIds = append(stepRunIds, result.StepRunID)
+			}
+		}
+		if len(stepRunIds) > 0 {
+			entry["stepRunIds"] = stepRunIds
+		}
+		summary[node.Key] = entry
+	}
+	return summary
+}
+
+func copyContent(content map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(content)+3)
+	for key, value := range content {
+		copied[key] = value
+	}
+	return copied
+}
+
+func toStringList(value interface{}) []string {
+	switch list := value.(type) {
+	case string:
+		if list == "" {
+			return nil
+		}
+		return []string{list}
+	case []interface{}:
+		result := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	case []string:
+		return list
+	}
+	return nil
+}
+
+func toConditionList(value interface{}) []map[string]interface{} {
+	switch conds := value.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{conds}
+	case []interface{}:
+		var result []map[string]interface{}
+		for _, item := range conds {
+			if cond, ok := item.(map[string]interface{}); ok {
+				result = append(result, cond)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
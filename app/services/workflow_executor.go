@@ -6,13 +6,23 @@ package services
 
 import (
 	"agent_pancake/app/integrations"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 )
 
+// Khoảng phần trăm của command dành cho các step (trước đó là khởi động workflow, sau đó là hoàn tất)
+const (
+	workflowStepsFrom = 10
+	workflowStepsTo   = 95
+)
+
 // WorkflowExecutor là service để thực thi workflow
 type WorkflowExecutor struct {
 	aiClient *AIClientService
+	progress *ProgressTracker // Tiến độ của command đang chạy (nil = chỉ gửi heartbeat theo step)
+	ctx      context.Context  // Hủy khi command bị hủy từ server (nil = không hủy được)
 }
 
 // NewWorkflowExecutor tạo một instance mới của WorkflowExecutor
@@ -22,6 +32,27 @@ func NewWorkflowExecutor() *WorkflowExecutor {
 	}
 }
 
+// WithProgress gắn tracker để báo tiến độ chi tiết (từng step và tiến độ sinh của AI)
+func (e *WorkflowExecutor) WithProgress(progress *ProgressTracker) *WorkflowExecutor {
+	e.progress = progress
+	return e
+}
+
+// WithContext gắn context của command: hủy context thì không chạy step mới, step đang chạy dừng AI call
+// và workflow run kết thúc với trạng thái "cancelled"
+func (e *WorkflowExecutor) WithContext(ctx context.Context) *WorkflowExecutor {
+	e.ctx = ctx
+	return e
+}
+
+// cancelled trả về lỗi nếu command đã bị hủy
+func (e *WorkflowExecutor) cancelled() error {
+	if e.ctx == nil {
+		return nil
+	}
+	return e.ctx.Err()
+}
+
 // ExecuteWorkflow thực thi một workflow
 // Tham số:
 // - workflowId: ID của workflow
@@ -62,24 +93,47 @@ func (e *WorkflowExecutor) ExecuteWorkflow(workflowId, rootRefId, rootRefType st
 	}
 	log.Printf("[WorkflowExecutor] Workflow có %d step(s)", len(stepsInterface))
 
-	// 2. Tạo workflow run record
-	log.Printf("[WorkflowExecutor] [2/5] Đang tạo workflow run record trong backend...")
-	workflowRunResp, err := integrations.FolkForm_CreateWorkflowRun(workflowId, rootRefId, rootRefType, params)
+	nodes, err := parseWorkflowDAG(stepsInterface)
 	if err != nil {
-		return "", fmt.Errorf("lỗi khi tạo workflow run: %v", err)
+		log.Printf("[WorkflowExecutor] ❌ Workflow không hợp lệ: %v", err)
+		return "", fmt.Errorf("workflow không hợp lệ: %v", err)
 	}
-
-	workflowRunData, ok := workflowRunResp["data"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("workflow run response không hợp lệ")
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("workflow không có step hợp lệ")
 	}
 
-	workflowRunID, ok := workflowRunData["id"].(string)
-	if !ok {
-		return "", fmt.Errorf("workflow run không có ID")
+	// Ngân sách AI của run (params.maxCost → config maxRunCost → không giới hạn)
+	budget := NewAIBudget(ResolveAIBudgetLimit(params))
+	if budget.Limit() > 0 {
+		log.Printf("[WorkflowExecutor] Ngân sách AI của run: $%.4f", budget.Limit())
 	}
 
-	log.Printf("[WorkflowExecutor] ✅ Đã tạo workflow run: %s", workflowRunID)
+	// 2. Chạy tiếp run cũ của command (agent chết giữa chừng) hoặc tạo workflow run record mới
+	var workflowRunID string
+	var restored map[string]*workflowNodeState
+	if previousRun := e.findResumableRun(workflowId, commandID, params); previousRun != nil {
+		workflowRunID = getString(previousRun, "id")
+		log.Printf("[WorkflowExecutor] [2/5] Tìm thấy workflow run đang chạy dở của command: %s", workflowRunID)
+		restored = e.restoreWorkflowRun(nodes, previousRun, budget)
+	} else {
+		log.Printf("[WorkflowExecutor] [2/5] Đang tạo workflow run record trong backend...")
+		workflowRunResp, err := integrations.FolkForm_CreateWorkflowRun(workflowId, rootRefId, rootRefType, commandID, params)
+		if err != nil {
+			return "", fmt.Errorf("lỗi khi tạo workflow run: %v", err)
+		}
+
+		workflowRunData, ok := workflowRunResp["data"].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("workflow run response không hợp lệ")
+		}
+
+		workflowRunID, ok = workflowRunData["id"].(string)
+		if !ok {
+			return "", fmt.Errorf("workflow run không có ID")
+		}
+
+		log.Printf("[WorkflowExecutor] ✅ Đã tạo workflow run: %s", workflowRunID)
+	}
 
 	// 3. Load root content từ Module 1
 	log.Printf("[WorkflowExecutor] [3/5] Đang load root content từ Module 1...")
@@ -87,84 +141,37 @@ func (e *WorkflowExecutor) ExecuteWorkflow(workflowId, rootRefId, rootRefType st
 	rootContent, err := e.loadRootContent(rootRefId, rootRefType)
 	if err != nil {
 		log.Printf("[WorkflowExecutor] ❌ Lỗi khi load root content: %v", err)
-		_, _ = integrations.FolkForm_UpdateWorkflowRun(workflowRunID, "failed")
+		_, _ = integrations.FolkForm_UpdateWorkflowRun(workflowRunID, "failed", nil)
 		return workflowRunID, fmt.Errorf("lỗi khi load root content: %v", err)
 	}
 	log.Printf("[WorkflowExecutor] ✅ Đã load root content thành công")
 
-	// 4. Execute từng step theo thứ tự
-	totalSteps := len(stepsInterface)
-	log.Printf("[WorkflowExecutor] [4/5] Bắt đầu execute %d step(s)...", totalSteps)
-	currentParentId := rootRefId
-	currentParentType := rootRefType
-
-	for i, stepInterface := range stepsInterface {
-		stepMap, ok := stepInterface.(map[string]interface{})
-		if !ok {
-			log.Printf("[WorkflowExecutor] ⚠️  Step không phải là map, bỏ qua")
-			continue
-		}
-
-		stepId, ok := stepMap["id"].(string)
-		if !ok || stepId == "" {
-			log.Printf("[WorkflowExecutor] ⚠️  Step không có ID, bỏ qua")
-			continue
-		}
-
-		stepNumber := i + 1
-		log.Printf("[WorkflowExecutor] ───────────────────────────────────────")
-		log.Printf("[WorkflowExecutor] 📍 EXECUTE STEP %d/%d", stepNumber, totalSteps)
-		log.Printf("[WorkflowExecutor] StepId: %s", stepId)
-		log.Printf("[WorkflowExecutor] CurrentParentId: %s", currentParentId)
-		log.Printf("[WorkflowExecutor] CurrentParentType: %s", currentParentType)
-		log.Printf("[WorkflowExecutor] ───────────────────────────────────────")
-
-		// Update heartbeat
-		percentage := int((float64(stepNumber) / float64(totalSteps)) * 100)
-		log.Printf("[WorkflowExecutor] Update heartbeat - Step %d/%d (%d%%)", stepNumber, totalSteps, percentage)
-		integrations.FolkForm_UpdateWorkflowCommandHeartbeat(agentId, commandID, map[string]interface{}{
-			"step":       "executing_step",
-			"percentage": percentage,
-			"message":    fmt.Sprintf("Đang execute step %d/%d: %s", stepNumber, totalSteps, stepId),
-		})
-
-		// Execute step
-		log.Printf("[WorkflowExecutor] Đang gọi StepExecutor để execute step...")
-		stepExecutor := NewStepExecutor(e.aiClient)
-		stepResult, err := stepExecutor.ExecuteStep(stepId, currentParentId, currentParentType, workflowRunID, rootContent)
-		if err != nil {
-			log.Printf("[WorkflowExecutor] ❌ Lỗi khi execute step %s: %v", stepId, err)
-			integrations.FolkForm_UpdateWorkflowRun(workflowRunID, "failed")
-			return workflowRunID, fmt.Errorf("lỗi khi execute step %s: %v", stepId, err)
-		}
-
-		log.Printf("[WorkflowExecutor] ✅ Step %d/%d hoàn thành thành công", stepNumber, totalSteps)
-		log.Printf("[WorkflowExecutor] StepRunID: %s", stepResult.StepRunID)
-		if stepResult.DraftNodeID != "" {
-			log.Printf("[WorkflowExecutor] DraftNodeID được tạo: %s", stepResult.DraftNodeID)
-		}
-		if stepResult.SelectedCandidateID != "" {
-			log.Printf("[WorkflowExecutor] SelectedCandidateID: %s", stepResult.SelectedCandidateID)
-		}
-
-		// Update parent cho step tiếp theo (nếu có draft node được tạo)
-		if stepResult.DraftNodeID != "" {
-			log.Printf("[WorkflowExecutor] Update parent cho step tiếp theo: %s", stepResult.DraftNodeID)
-			currentParentId = stepResult.DraftNodeID
-			// Update rootContent với draft node mới
-			rootContent = map[string]interface{}{
-				"id":   stepResult.DraftNodeID,
-				"type": e.determineNodeType(currentParentType),
-				"text": stepResult.Output["content"],
-			}
-		}
-
-		log.Printf("[WorkflowExecutor] ───────────────────────────────────────")
+	// 4. Execute các step theo DAG (tuần tự nếu workflow không khai báo phụ thuộc)
+	log.Printf("[WorkflowExecutor] [4/5] Bắt đầu execute %d step(s)...", len(nodes))
+	states, err := e.executeDAG(nodes, workflowDAGRun{
+		workflowRunID: workflowRunID,
+		agentId:       agentId,
+		commandID:     commandID,
+		rootRefId:     rootRefId,
+		rootRefType:   rootRefType,
+		rootContent:   rootContent,
+		params:        params,
+		budget:        budget,
+		restored:      restored,
+	})
+	var waitingErr *StepWaitingReviewError
+	if errors.As(err, &waitingErr) {
+		return workflowRunID, e.pauseRun(workflowRunID, budget, waitingErr, dagStatusSummary(nodes, states))
+	}
+	if err != nil {
+		return workflowRunID, e.failRun(workflowRunID, budget, err, dagStatusSummary(nodes, states))
 	}
 
 	// 5. Update workflow run status = "completed"
 	log.Printf("[WorkflowExecutor] [5/5] Đang update workflow run status = completed...")
-	_, err = integrations.FolkForm_UpdateWorkflowRun(workflowRunID, "completed")
+	completedFields := runCostFields(budget)
+	completedFields["steps"] = dagStatusSummary(nodes, states)
+	_, err = integrations.FolkForm_UpdateWorkflowRun(workflowRunID, "completed", completedFields)
 	if err != nil {
 		log.Printf("[WorkflowExecutor] ⚠️  Lỗi khi update workflow run status: %v", err)
 	} else {
@@ -175,11 +182,75 @@ func (e *WorkflowExecutor) ExecuteWorkflow(workflowId, rootRefId, rootRefType st
 	log.Printf("[WorkflowExecutor] ✅ HOÀN THÀNH WORKFLOW")
 	log.Printf("[WorkflowExecutor] WorkflowId: %s", workflowId)
 	log.Printf("[WorkflowExecutor] WorkflowRunID: %s", workflowRunID)
-	log.Printf("[WorkflowExecutor] Tổng số steps đã execute: %d", totalSteps)
+	log.Printf("[WorkflowExecutor] Tổng số step runs đã execute: %d", countStepRuns(states))
+	totalCost, aiCalls := budget.Spent()
+	log.Printf("[WorkflowExecutor] Chi phí AI: $%.6f (%d AI call)", totalCost, aiCalls)
 	log.Printf("[WorkflowExecutor] ========================================")
 	return workflowRunID, nil
 }
 
+// failRun đánh dấu workflow run thất bại (kèm chi phí AI đã dùng, trạng thái từng step) và trả lại err
+// Lỗi vượt ngân sách được ghi nhận riêng (budgetExceeded) để server phân biệt với lỗi thực thi,
+// command bị hủy thì run kết thúc với trạng thái "cancelled"
+func (e *WorkflowExecutor) failRun(workflowRunID string, budget *AIBudget, err error, steps map[string]interface{}) error {
+	fields := runCostFields(budget)
+	if steps != nil {
+		fields["steps"] = steps
+	}
+	status := "failed"
+	if errors.Is(err, context.Canceled) {
+		status = "cancelled"
+		if e.ctx != nil {
+			err = fmt.Errorf("%w: %v", err, context.Cause(e.ctx))
+		}
+	}
+	var budgetErr *AIBudgetExceededError
+	if errors.As(err, &budgetErr) {
+		fields["budgetExceeded"] = true
+		RecordAIBudgetAbort()
+	}
+	fields["error"] = err.Error()
+	if _, updateErr := integrations.FolkForm_UpdateWorkflowRun(workflowRunID, status, fields); updateErr != nil {
+		log.Printf("[WorkflowExecutor] ⚠️  Lỗi khi update workflow run status: %v", updateErr)
+	}
+	return err
+}
+
+// pauseRun chuyển workflow run sang "waiting_review" (checkpoint đã lưu step run đang chờ duyệt) và trả lại err
+// để command kết thúc ở trạng thái chờ duyệt, run được chạy tiếp khi server giao lại command
+func (e *WorkflowExecutor) pauseRun(workflowRunID string, budget *AIBudget, err *StepWaitingReviewError, steps map[string]interface{}) error {
+	fields := runCostFields(budget)
+	fields["steps"] = steps
+	fields["waitingStepRunIds"] = err.StepRunIDs
+	if _, updateErr := integrations.FolkForm_UpdateWorkflowRun(workflowRunID, "waiting_review", fields); updateErr != nil {
+		log.Printf("[WorkflowExecutor] ⚠️  Lỗi khi update workflow run status: %v", updateErr)
+	}
+	log.Printf("[WorkflowExecutor] ⏸️  Workflow run %s tạm dừng chờ duyệt (step run: %v)", workflowRunID, err.StepRunIDs)
+	return err
+}
+
+// runCostFields là các field chi phí AI của run gửi kèm khi update workflow run
+func runCostFields(budget *AIBudget) map[string]interface{} {
+	totalCost, aiCalls := budget.Spent()
+	fields := map[string]interface{}{
+		"totalCost": totalCost,
+		"aiCalls":   aiCalls,
+	}
+	if budget.Limit() > 0 {
+		fields["budgetLimit"] = budget.Limit()
+	}
+	return fields
+}
+
+// countStepRuns đếm số step run đã chạy xong (fan-out tính từng nhánh)
+func countStepRuns(states map[string]*workflowNodeState) int {
+	count := 0
+	for _, state := range states {
+		count += len(state.results)
+	}
+	return count
+}
+
 // determineNodeType xác định node type từ parent type (helper function)
 func (e *WorkflowExecutor) determineNodeType(parentType string) string {
 	mapping := map[string]string{
@@ -0,0 +1,193 @@
+/*
+Package services chứa các services hỗ trợ cho agent.
+File này chứa phần chạy tiếp workflow run sau khi agent chết/khởi động lại giữa chừng:
+  - Sau mỗi step xong/bị bỏ qua, executor lưu checkpoint vào workflow run (field "checkpoint", kèm chi phí AI đã dùng)
+  - Khi command được claim lại, executor tìm run "running" của command (FolkForm_FindRunningWorkflowRun),
+    khôi phục các step đã xong từ checkpoint + output của StepRun, rồi chạy tiếp từ các step chưa xong
+  - StepRun còn "running" (đang chạy dở lúc agent chết) được đánh dấu "failed" và step đó chạy lại
+  - Command có params {"resume": false} luôn tạo run mới
+  - Step HUMAN_REVIEW tạm dừng workflow: checkpoint giữ step run đang chờ duyệt, run chuyển "waiting_review" và command
+    kết thúc (giải phóng slot); server giao lại command khi có kết quả duyệt → run được tìm lại và chạy tiếp từ step đó
+*/
+package services
+
+import (
+	"agent_pancake/app/integrations"
+	"log"
+)
+
+// workflowCheckpointField là field của workflow run lưu checkpoint
+const workflowCheckpointField = "checkpoint"
+
+// findResumableRun tìm workflow run đang chạy dở của command (nil nếu không có hoặc không được resume)
+func (e *WorkflowExecutor) findResumableRun(workflowId, commandID string, params map[string]interface{}) map[string]interface{} {
+	if commandID == "" {
+		return nil
+	}
+	if resume, ok := params["resume"].(bool); ok && !resume {
+		log.Printf("[WorkflowExecutor] params.resume = false, không chạy tiếp run cũ")
+		return nil
+	}
+	run, err := integrations.FolkForm_FindRunningWorkflowRun(commandID)
+	if err != nil {
+		// Không tìm được thì chạy lại từ đầu (như trước khi có resume)
+		log.Printf("[WorkflowExecutor] ⚠️  Không tìm được workflow run cũ của command %s: %v", commandID, err)
+		return nil
+	}
+	if run == nil {
+		return nil
+	}
+	if runWorkflowId := getString(run, "workflowId"); runWorkflowId != "" && runWorkflowId != workflowId {
+		log.Printf("[WorkflowExecutor] ⚠️  Run cũ %s thuộc workflow khác (%s), bỏ qua", getString(run, "id"), runWorkflowId)
+		return nil
+	}
+	return run
+}
+
+// restoreWorkflowRun khôi phục trạng thái các step từ checkpoint của run và StepRun đã lưu
+// StepRun "running" còn sót lại được đánh dấu "failed" (step tương ứng sẽ chạy lại)
+func (e *WorkflowExecutor) restoreWorkflowRun(nodes []*workflowNode, run map[string]interface{}, budget *AIBudget) map[string]*workflowNodeState {
+	workflowRunID := getString(run, "id")
+	checkpoint, _ := run[workflowCheckpointField].(map[string]interface{})
+
+	stepRuns := make(map[string]map[string]interface{})
+	items, err := integrations.FolkForm_GetStepRuns(workflowRunID)
+	if err != nil {
+		log.Printf("[WorkflowExecutor] ⚠️  Không load được step runs của run %s: %v, chỉ dùng checkpoint", workflowRunID, err)
+	}
+	for _, item := range items {
+		stepRun, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id := getString(stepRun, "id")
+		stepRuns[id] = stepRun
+		if getString(stepRun, "status") == "running" {
+			log.Printf("[WorkflowExecutor] Step run %s bị gián đoạn (agent khởi động lại), đánh dấu failed", id)
+			if _, err := integrations.FolkForm_UpdateStepRun(id, nil, "failed"); err != nil {
+				log.Printf("[WorkflowExecutor] ⚠️  Lỗi khi update step run %s: %v", id, err)
+			}
+		}
+	}
+
+	restored := make(map[string]*workflowNodeState)
+	for _, node := range nodes {
+		entry, ok := checkpoint[node.Key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		state, ok := restoreNodeState(entry, stepRuns)
+		if !ok {
+			log.Printf("[WorkflowExecutor] ⚠️  Checkpoint của step %s không đầy đủ, chạy lại step", node.Key)
+			continue
+		}
+		restored[node.Key] = state
+	}
+
+	cost, _ := toFloat64(run["totalCost"])
+	calls, _ := toFloat64(run["aiCalls"])
+	budget.Restore(cost, int(calls))
+
+	log.Printf("[WorkflowExecutor] ♻️  Chạy tiếp workflow run %s: %d/%d step đã xong, chi phí AI đã dùng $%.6f", workflowRunID, len(restored), len(nodes), cost)
+	return restored
+}
+
+// restoreNodeState dựng lại trạng thái của một node từ checkpoint, output lấy từ StepRun
+func restoreNodeState(entry map[string]interface{}, stepRuns map[string]map[string]interface{}) (*workflowNodeState, bool) {
+	state := &workflowNodeState{
+		status:     getString(entry, "status"),
+		reason:     getString(entry, "reason"),
+		parentId:   getString(entry, "parentId"),
+		parentType: getString(entry, "parentType"),
+	}
+	state.parentContent, _ = entry["parentContent"].(map[string]interface{})
+
+	switch state.status {
+	case dagNodeSkipped, dagNodeFailed:
+		return state, true
+	case dagNodeWaitingReview:
+		// Giữ nguyên vị trí (kể cả phần tử rỗng) để nhánh fan-out thứ i dùng lại đúng step run của nó
+		ids, _ := entry["reviewStepRunIds"].([]interface{})
+		for _, id := range ids {
+			stepRunID, _ := id.(string)
+			state.reviewStepRunIDs = append(state.reviewStepRunIDs, stepRunID)
+		}
+		return state, len(state.reviewStepRunIDs) > 0
+	case dagNodeCompleted:
+	default:
+		return nil, false
+	}
+
+	results, _ := entry["results"].([]interface{})
+	for _, item := range results {
+		saved, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		stepRunID := getString(saved, "stepRunId")
+		stepRun, ok := stepRuns[stepRunID]
+		if !ok || getString(stepRun, "status") != "completed" {
+			return nil, false
+		}
+		output, _ := stepRun["output"].(map[string]interface{})
+		state.results = append(state.results, &StepResult{
+			StepRunID:           stepRunID,
+			DraftNodeID:         getString(saved, "draftNodeId"),
+			SelectedCandidateID: getString(saved, "selectedCandidateId"),
+			Output:              output,
+		})
+	}
+	return state, true
+}
+
+// saveCheckpoint lưu trạng thái các step đã kết thúc vào workflow run (kèm chi phí AI đã dùng)
+// Lỗi chỉ log: mất checkpoint thì khi resume các step đó chạy lại
+func (e *WorkflowExecutor) saveCheckpoint(run workflowDAGRun, nodes []*workflowNode, states map[string]*workflowNodeState) {
+	checkpoint := make(map[string]interface{})
+	for _, node := range nodes {
+		state := states[node.Key]
+		switch state.status {
+		case dagNodeCompleted, dagNodeSkipped, dagNodeFailed, dagNodeWaitingReview:
+		default:
+			continue
+		}
+		entry := map[string]interface{}{
+			"stepId":     node.StepID,
+			"status":     state.status,
+			"parentId":   state.parentId,
+			"parentType": state.parentType,
+		}
+		if state.reason != "" {
+			entry["reason"] = state.reason
+		}
+		if len(state.reviewStepRunIDs) > 0 {
+			entry["reviewStepRunIds"] = state.reviewStepRunIDs
+		}
+		// Parent là root thì khi resume lấy lại root content, không cần lưu
+		if state.parentId != run.rootRefId && state.parentContent != nil {
+			entry["parentContent"] = state.parentContent
+		}
+		var results []interface{}
+		for _, result := range state.results {
+			if result == nil {
+				continue
+			}
+			saved := map[string]interface{}{"stepRunId": result.StepRunID}
+			if result.DraftNodeID != "" {
+				saved["draftNodeId"] = result.DraftNodeID
+			}
+			if result.SelectedCandidateID != "" {
+				saved["selectedCandidateId"] = result.SelectedCandidateID
+			}
+			results = append(results, saved)
+		}
+		entry["results"] = results
+		checkpoint[node.Key] = entry
+	}
+
+	fields := runCostFields(run.budget)
+	fields[workflowCheckpointField] = checkpoint
+	if _, err := integrations.FolkForm_UpdateWorkflowRun(run.workflowRunID, "running", fields); err != nil {
+		log.Printf("[WorkflowExecutor] ⚠️  Lỗi khi lưu checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Command encrypt-secrets tạo file secret mã hóa cho provider "file" (AGENT_SECRETS_PROVIDERS=file).
+
+Đọc các secret từ một file dạng .env và ghi ra file mã hóa AES-256-GCM:
+
+	go run ./cmd/encrypt-secrets -in secrets.env -out ./config/secrets.enc
+	go run ./cmd/encrypt-secrets -in secrets.env -out ./config/secrets.enc -key-file /etc/agent_pancake/secrets.key
+
+Không truyền -key-file thì key sinh từ Hardware ID, nên phải chạy lệnh trên chính máy sẽ chạy agent.
+*/
+package main
+
+import (
+	"agent_pancake/utility/secrets"
+	"flag"
+	"log"
+	"sort"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	in := flag.String("in", "", "File .env chứa secret cần mã hóa (FIREBASE_API_KEY=..., FIREBASE_PASSWORD=...)")
+	out := flag.String("out", "./config/secrets.enc", "File secret mã hóa cần ghi ra (AGENT_SECRETS_FILE)")
+	keyFile := flag.String("key-file", "", "File chứa key (AGENT_SECRETS_KEY_FILE), để trống = dùng Hardware ID của máy")
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatal("Thiếu tham số -in")
+	}
+
+	values, err := godotenv.Read(*in)
+	if err != nil {
+		log.Fatalf("Không đọc được file %s: %v", *in, err)
+	}
+	if len(values) == 0 {
+		log.Fatalf("File %s không có secret nào", *in)
+	}
+
+	key, err := secrets.LoadKey(*keyFile)
+	if err != nil {
+		log.Fatalf("Không lấy được key: %v", err)
+	}
+	keySource := "machine"
+	if *keyFile != "" {
+		keySource = "keyfile"
+	}
+
+	if err := secrets.WriteEncryptedFile(*out, key, keySource, values); err != nil {
+		log.Fatalf("Không ghi được file %s: %v", *out, err)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.Printf("✅ Đã ghi %d secret vào %s (key: %s): %v", len(values), *out, keySource, names)
+}
@@ -14,12 +14,34 @@ import (
 // Configuration chứa thông tin tĩnh cần thiết để chạy ứng dụng
 // Nó chứa thông tin cơ sở dữ liệu
 type Configuration struct {
-	FirebaseApiKey   string `env:"FIREBASE_API_KEY,required"`  // Firebase API Key để đăng nhập
-	FirebaseEmail    string `env:"FIREBASE_EMAIL,required"`    // Email để đăng nhập Firebase
-	FirebasePassword string `env:"FIREBASE_PASSWORD,required"` // Password để đăng nhập Firebase
+	FirebaseApiKey   string `env:"FIREBASE_API_KEY"`           // Firebase API Key để đăng nhập (secret, xem secrets.go)
+	FirebaseEmail    string `env:"FIREBASE_EMAIL"`             // Email để đăng nhập Firebase (secret, xem secrets.go)
+	FirebasePassword string `env:"FIREBASE_PASSWORD"`          // Password để đăng nhập Firebase (secret, xem secrets.go)
 	AgentId          string `env:"AGENT_ID,required"`          // ID của agent
-	ApiBaseUrl       string `env:"API_BASE_URL,required"`      // Địa chỉ server API
-	PancakeBaseUrl   string `env:"PANCAKE_BASE_URL,required"`  // Địa chỉ server Pancake
+	ApiBaseUrl       string `env:"API_BASE_URL"`               // Địa chỉ server API (FolkForm)
+	PancakeBaseUrl   string `env:"PANCAKE_BASE_URL"`           // Địa chỉ server Pancake
+
+	// Environment là môi trường chạy (prod, staging, local) - quyết định base URL mặc định của các upstream
+	// Xem environmentPresets trong endpoints.go
+	Environment          string `env:"AGENT_ENV"`
+	PancakePosBaseUrl    string `env:"PANCAKE_POS_BASE_URL"`    // Địa chỉ server Pancake POS
+	FirebaseAuthBaseUrl  string `env:"FIREBASE_AUTH_BASE_URL"`  // Địa chỉ Firebase Identity Toolkit (hoặc Auth Emulator)
+	FirebaseTokenBaseUrl string `env:"FIREBASE_TOKEN_BASE_URL"` // Địa chỉ Firebase Secure Token (làm mới ID Token, hoặc Auth Emulator)
+
+	// AgentRoleIds là danh sách role (organization) mà agent phục vụ, xem RoleIdList()
+	// - Rỗng: chỉ dùng role đầu tiên backend trả về (tương thích cấu hình cũ)
+	// - "all": tất cả roles của tài khoản
+	// - "id1,id2,...": các role cụ thể, role đầu tiên là role mặc định
+	AgentRoleIds string `env:"AGENT_ROLE_IDS"`
+
+	// Nguồn secret (FIREBASE_API_KEY, FIREBASE_EMAIL, FIREBASE_PASSWORD), xem secrets.go
+	SecretsProviders string `env:"AGENT_SECRETS_PROVIDERS" envDefault:"env"` // Thứ tự provider: env, file, command (phân cách bằng dấu phẩy)
+	SecretsFile      string `env:"AGENT_SECRETS_FILE" envDefault:"./config/secrets.enc"`
+	SecretsKeyFile   string `env:"AGENT_SECRETS_KEY_FILE"` // Rỗng = key sinh từ Hardware ID của máy
+	SecretsCommand   string `env:"AGENT_SECRETS_COMMAND"`
+
+	// Thư mục cache response AI (bật/tắt, TTL, dung lượng qua config của workflow-commands-job)
+	AICacheDir string `env:"AGENT_AI_CACHE_DIR" envDefault:"./cache/ai"`
 }
 
 // LogConfig trả về cấu hình logger từ environment variables
@@ -29,6 +51,8 @@ func LogConfig() *logger.Config {
 
 // NewConfig sẽ đọc dữ liệu cấu hình từ environment variables hoặc file .env
 // Ưu tiên: Environment variables (systemd EnvironmentFile) > File .env (development)
+// Base URL của các upstream chưa cấu hình sẽ lấy theo preset của AGENT_ENV (mặc định: prod)
+// Lưu ý: NewConfig không validate, gọi Validate() sau khi load để kiểm tra khi khởi động
 func NewConfig(files ...string) *Configuration {
 	log.Println("[Config] ========================================")
 	log.Println("[Config] Bắt đầu đọc cấu hình...")
@@ -53,16 +77,12 @@ func NewConfig(files ...string) *Configuration {
 		} else {
 			// Có env vars từ systemd, dùng luôn
 			log.Println("[Config] [Bước 1/2] ✅ Đã đọc cấu hình từ environment variables (systemd EnvironmentFile)")
+			cfg.loadSecrets()
 			log.Printf("[Config] [Bước 1/2] Config values:")
-			log.Printf("[Config]   • FIREBASE_API_KEY: %s...%s (length: %d)", 
-				maskString(cfg.FirebaseApiKey, 10, false), 
-				maskString(cfg.FirebaseApiKey, 10, true),
-				len(cfg.FirebaseApiKey))
-			log.Printf("[Config]   • FIREBASE_EMAIL: %s", cfg.FirebaseEmail)
-			log.Printf("[Config]   • FIREBASE_PASSWORD: %s (length: %d)", maskPassword(cfg.FirebasePassword), len(cfg.FirebasePassword))
+			cfg.logSecrets()
 			log.Printf("[Config]   • AGENT_ID: %s", cfg.AgentId)
-			log.Printf("[Config]   • API_BASE_URL: %s", cfg.ApiBaseUrl)
-			log.Printf("[Config]   • PANCAKE_BASE_URL: %s", cfg.PancakeBaseUrl)
+			cfg.applyEnvironmentDefaults()
+			cfg.logEndpoints()
 			log.Println("[Config] ========================================")
 			return &cfg
 		}
@@ -159,40 +179,16 @@ func NewConfig(files ...string) *Configuration {
 		fmt.Printf("Lỗi khi parse config: %+v\n", err)
 	} else {
 		log.Println("[Config] [Bước 2/2] ✅ Parse config thành công")
+		cfg.loadSecrets()
 		log.Printf("[Config] [Bước 2/2] Config values:")
-		log.Printf("[Config]   • FIREBASE_API_KEY: %s...%s (length: %d)", 
-			maskString(cfg.FirebaseApiKey, 10, false), 
-			maskString(cfg.FirebaseApiKey, 10, true),
-			len(cfg.FirebaseApiKey))
-		log.Printf("[Config]   • FIREBASE_EMAIL: %s", cfg.FirebaseEmail)
-		log.Printf("[Config]   • FIREBASE_PASSWORD: %s (length: %d)", maskPassword(cfg.FirebasePassword), len(cfg.FirebasePassword))
+		cfg.logSecrets()
 		log.Printf("[Config]   • AGENT_ID: %s", cfg.AgentId)
-		log.Printf("[Config]   • API_BASE_URL: %s", cfg.ApiBaseUrl)
-		log.Printf("[Config]   • PANCAKE_BASE_URL: %s", cfg.PancakeBaseUrl)
 	}
 
+	// Điền base URL mặc định theo môi trường (AGENT_ENV) cho các upstream chưa cấu hình
+	cfg.applyEnvironmentDefaults()
+	cfg.logEndpoints()
+
 	log.Println("[Config] ========================================")
 	return &cfg
 }
-
-// Helper function để mask string (chỉ hiển thị đầu và cuối)
-func maskString(s string, visibleLen int, fromEnd bool) string {
-	if len(s) <= visibleLen*2 {
-		return "***"
-	}
-	if fromEnd {
-		return s[len(s)-visibleLen:]
-	}
-	return s[:visibleLen]
-}
-
-// Helper function để mask password
-func maskPassword(pwd string) string {
-	if len(pwd) == 0 {
-		return "(empty)"
-	}
-	if len(pwd) <= 4 {
-		return "****"
-	}
-	return pwd[:2] + "****" + pwd[len(pwd)-2:]
-}
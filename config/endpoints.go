@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Tên các môi trường (AGENT_ENV) được hỗ trợ
+const (
+	EnvironmentProd    = "prod"    // Production: dùng các upstream thật
+	EnvironmentStaging = "staging" // Staging: FolkForm staging, Pancake/POS/Firebase thật
+	EnvironmentLocal   = "local"   // Local: trỏ toàn bộ upstream về stand-in chạy trên máy (fake server, Firebase emulator)
+)
+
+// EnvironmentEndpoints chứa base URL của các upstream cho một môi trường
+// Giá trị rỗng nghĩa là môi trường đó không có mặc định, bắt buộc phải cấu hình qua ENV
+type EnvironmentEndpoints struct {
+	ApiBaseUrl           string // FolkForm backend
+	PancakeBaseUrl       string // Pancake Pages API
+	PancakePosBaseUrl    string // Pancake POS API
+	FirebaseAuthBaseUrl  string // Firebase Identity Toolkit (đăng nhập email/password)
+	FirebaseTokenBaseUrl string // Firebase Secure Token (làm mới ID Token bằng refresh token)
+}
+
+// environmentPresets là bảng base URL mặc định theo từng môi trường
+// Các biến ENV (API_BASE_URL, PANCAKE_BASE_URL, PANCAKE_POS_BASE_URL, FIREBASE_AUTH_BASE_URL, FIREBASE_TOKEN_BASE_URL) luôn override preset
+var environmentPresets = map[string]EnvironmentEndpoints{
+	EnvironmentProd: {
+		ApiBaseUrl:           "", // Không có mặc định, bắt buộc cấu hình API_BASE_URL
+		PancakeBaseUrl:       "https://pages.fm/api",
+		PancakePosBaseUrl:    "https://pos.pages.fm/api/v1",
+		FirebaseAuthBaseUrl:  "https://identitytoolkit.googleapis.com",
+		FirebaseTokenBaseUrl: "https://securetoken.googleapis.com",
+	},
+	EnvironmentStaging: {
+		ApiBaseUrl:           "", // Không có mặc định, bắt buộc cấu hình API_BASE_URL
+		PancakeBaseUrl:       "https://pages.fm/api",
+		PancakePosBaseUrl:    "https://pos.pages.fm/api/v1",
+		FirebaseAuthBaseUrl:  "https://identitytoolkit.googleapis.com",
+		FirebaseTokenBaseUrl: "https://securetoken.googleapis.com",
+	},
+	EnvironmentLocal: {
+		ApiBaseUrl:           "http://localhost:8080/api",
+		PancakeBaseUrl:       "http://localhost:9090/api",
+		PancakePosBaseUrl:    "http://localhost:9090/pos/api/v1",
+		FirebaseAuthBaseUrl:  "http://localhost:9099/identitytoolkit.googleapis.com", // Firebase Auth Emulator
+		FirebaseTokenBaseUrl: "http://localhost:9099/securetoken.googleapis.com",
+	},
+}
+
+// KnownEnvironments trả về danh sách tên môi trường được hỗ trợ (đã sắp xếp)
+func KnownEnvironments() []string {
+	names := make([]string, 0, len(environmentPresets))
+	for name := range environmentPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyEnvironmentDefaults điền base URL từ preset của môi trường cho các field chưa được cấu hình qua ENV
+// Môi trường rỗng được coi là prod (tương thích với cấu hình cũ không có AGENT_ENV)
+func (c *Configuration) applyEnvironmentDefaults() {
+	c.Environment = strings.ToLower(strings.TrimSpace(c.Environment))
+	if c.Environment == "" {
+		c.Environment = EnvironmentProd
+	}
+
+	preset, ok := environmentPresets[c.Environment]
+	if !ok {
+		// Môi trường không hợp lệ sẽ bị báo lỗi trong Validate()
+		return
+	}
+
+	if c.ApiBaseUrl == "" {
+		c.ApiBaseUrl = preset.ApiBaseUrl
+	}
+	if c.PancakeBaseUrl == "" {
+		c.PancakeBaseUrl = preset.PancakeBaseUrl
+	}
+	if c.PancakePosBaseUrl == "" {
+		c.PancakePosBaseUrl = preset.PancakePosBaseUrl
+	}
+	if c.FirebaseAuthBaseUrl == "" {
+		c.FirebaseAuthBaseUrl = preset.FirebaseAuthBaseUrl
+	}
+	if c.FirebaseTokenBaseUrl == "" {
+		c.FirebaseTokenBaseUrl = preset.FirebaseTokenBaseUrl
+	}
+
+	// Bỏ dấu "/" cuối để ghép endpoint (bắt đầu bằng "/") không bị "//"
+	c.ApiBaseUrl = strings.TrimRight(c.ApiBaseUrl, "/")
+	c.PancakeBaseUrl = strings.TrimRight(c.PancakeBaseUrl, "/")
+	c.PancakePosBaseUrl = strings.TrimRight(c.PancakePosBaseUrl, "/")
+	c.FirebaseAuthBaseUrl = strings.TrimRight(c.FirebaseAuthBaseUrl, "/")
+	c.FirebaseTokenBaseUrl = strings.TrimRight(c.FirebaseTokenBaseUrl, "/")
+}
+
+// Validate kiểm tra cấu hình tĩnh khi khởi động
+// Kiểm tra: môi trường hợp lệ, các field bắt buộc có giá trị, các base URL là URL http(s) tuyệt đối
+// Trả về lỗi gộp tất cả các vấn đề tìm thấy (để sửa một lần thay vì từng lỗi một)
+func (c *Configuration) Validate() error {
+	var problems []string
+
+	if _, ok := environmentPresets[c.Environment]; !ok {
+		problems = append(problems, fmt.Sprintf("AGENT_ENV=%q không hợp lệ (hỗ trợ: %s)", c.Environment, strings.Join(KnownEnvironments(), ", ")))
+	}
+
+	required := map[string]string{
+		"FIREBASE_API_KEY":  c.FirebaseApiKey,
+		"FIREBASE_EMAIL":    c.FirebaseEmail,
+		"FIREBASE_PASSWORD": c.FirebasePassword,
+		"AGENT_ID":          c.AgentId,
+	}
+	for _, name := range []string{"FIREBASE_API_KEY", "FIREBASE_EMAIL", "FIREBASE_PASSWORD", "AGENT_ID"} {
+		if required[name] == "" {
+			problems = append(problems, name+" chưa được cấu hình")
+		}
+	}
+
+	endpoints := []struct {
+		name  string
+		value string
+	}{
+		{"API_BASE_URL", c.ApiBaseUrl},
+		{"PANCAKE_BASE_URL", c.PancakeBaseUrl},
+		{"PANCAKE_POS_BASE_URL", c.PancakePosBaseUrl},
+		{"FIREBASE_AUTH_BASE_URL", c.FirebaseAuthBaseUrl},
+		{"FIREBASE_TOKEN_BASE_URL", c.FirebaseTokenBaseUrl},
+	}
+	for _, ep := range endpoints {
+		if err := validateBaseUrl(ep.value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", ep.name, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("cấu hình không hợp lệ (AGENT_ENV=%s): %s", c.Environment, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateBaseUrl kiểm tra một base URL: không rỗng, scheme http/https, có host
+func validateBaseUrl(raw string) error {
+	if raw == "" {
+		return fmt.Errorf("chưa được cấu hình")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("không parse được URL %q: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %q phải dùng scheme http hoặc https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q thiếu host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("URL %q không được chứa query hoặc fragment", raw)
+	}
+	return nil
+}
+
+// logEndpoints log môi trường và các base URL đang dùng
+func (c *Configuration) logEndpoints() {
+	log.Printf("[Config]   • AGENT_ENV: %s", c.Environment)
+	log.Printf("[Config]   • API_BASE_URL: %s", c.ApiBaseUrl)
+	log.Printf("[Config]   • PANCAKE_BASE_URL: %s", c.PancakeBaseUrl)
+	log.Printf("[Config]   • PANCAKE_POS_BASE_URL: %s", c.PancakePosBaseUrl)
+	log.Printf("[Config]   • FIREBASE_AUTH_BASE_URL: %s", c.FirebaseAuthBaseUrl)
+	log.Printf("[Config]   • FIREBASE_TOKEN_BASE_URL: %s", c.FirebaseTokenBaseUrl)
+	if c.AgentRoleIds != "" {
+		log.Printf("[Config]   • AGENT_ROLE_IDS: %s", c.AgentRoleIds)
+	}
+}
@@ -0,0 +1,26 @@
+package config
+
+import "strings"
+
+// AgentRoleIdsAll là giá trị AGENT_ROLE_IDS để phục vụ tất cả roles của tài khoản
+const AgentRoleIdsAll = "all"
+
+// RoleIdList phân tích AGENT_ROLE_IDS
+// Trả về all=true nếu cấu hình "all", ngược lại trả về danh sách role ID (đã bỏ trùng, giữ thứ tự)
+// Danh sách rỗng và all=false nghĩa là chỉ dùng role đầu tiên backend trả về
+func (c *Configuration) RoleIdList() (all bool, roleIds []string) {
+	raw := strings.TrimSpace(c.AgentRoleIds)
+	if strings.EqualFold(raw, AgentRoleIdsAll) {
+		return true, nil
+	}
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(raw, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		roleIds = append(roleIds, id)
+	}
+	return false, roleIds
+}
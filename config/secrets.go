@@ -0,0 +1,69 @@
+package config
+
+import (
+	"agent_pancake/utility/secrets"
+	"log"
+	"strings"
+)
+
+// secretField là một field của Configuration nhận giá trị từ secrets provider
+// redact=true: giá trị được đăng ký để che trong log (email là định danh nên vẫn hiển thị)
+type secretField struct {
+	name   string
+	field  *string
+	redact bool
+}
+
+// secretFields trả về các field nhận giá trị từ secrets provider
+func (c *Configuration) secretFields() []secretField {
+	return []secretField{
+		{"FIREBASE_API_KEY", &c.FirebaseApiKey, true},
+		{"FIREBASE_EMAIL", &c.FirebaseEmail, false},
+		{"FIREBASE_PASSWORD", &c.FirebasePassword, true},
+	}
+}
+
+// loadSecrets lấy các secret qua chain provider cấu hình bởi AGENT_SECRETS_PROVIDERS (mặc định: env)
+// Provider đứng trước được ưu tiên; secret không provider nào có thì giữ giá trị đã parse từ env
+// Mọi secret được đăng ký với secrets.Register để logger tự che khi vô tình bị log
+func (c *Configuration) loadSecrets() {
+	chain, err := secrets.NewChain(secrets.Options{
+		Providers: strings.Split(c.SecretsProviders, ","),
+		FilePath:  c.SecretsFile,
+		KeyFile:   c.SecretsKeyFile,
+		Command:   c.SecretsCommand,
+	})
+	if err != nil {
+		log.Printf("[Config] ❌ Không khởi tạo được secrets provider (%s): %v", c.SecretsProviders, err)
+	}
+
+	for _, secret := range c.secretFields() {
+		value, source, err := chain.Lookup(secret.name)
+		if err != nil {
+			log.Printf("[Config] ⚠️  %v", err)
+		}
+		if value != "" {
+			*secret.field = value
+			if source != secrets.ProviderEnv {
+				log.Printf("[Config]   • %s: lấy từ provider %s", secret.name, source)
+			}
+		}
+		if secret.redact {
+			secrets.Register(*secret.field)
+		}
+	}
+}
+
+// logSecrets log trạng thái các secret (không bao giờ log giá trị, kể cả một phần)
+func (c *Configuration) logSecrets() {
+	for _, secret := range c.secretFields() {
+		switch {
+		case *secret.field == "":
+			log.Printf("[Config]   • %s: (chưa cấu hình)", secret.name)
+		case secret.redact:
+			log.Printf("[Config]   • %s: %s (fp: %s)", secret.name, secrets.RedactedPlaceholder, secrets.Fingerprint(*secret.field))
+		default:
+			log.Printf("[Config]   • %s: %s", secret.name, *secret.field)
+		}
+	}
+}
@@ -2,8 +2,8 @@
 Package global chứa các biến toàn cục được sử dụng trong toàn bộ ứng dụng.
 Các biến này bao gồm:
 - GlobalConfig: Cấu hình của ứng dụng
-- ApiToken: Token xác thực với FolkForm backend
-- ActiveRoleId: Role ID hiện tại đang làm việc (cho Organization Context System)
+- ApiToken: Token xác thực với FolkForm backend (truy cập qua GetApiToken/SetApiToken)
+- ActiveRoleId: Role ID hiện tại đang làm việc (cho Organization Context System, truy cập qua GetPrimaryRoleId/SetActiveRoleId)
 - PanCake_FbPages: Cache danh sách Facebook pages trong memory
 - NotificationRateLimiter: Rate limiter cho notifications
 Tất cả các biến được bảo vệ bởi mutex để đảm bảo thread-safe.
@@ -21,12 +21,72 @@ import (
 // GlobalConfig chứa cấu hình của ứng dụng (được load từ environment variables hoặc .env file)
 var GlobalConfig *config.Configuration
 
-// ApiToken là token xác thực với FolkForm backend (được set sau khi login)
-var ApiToken string = ""
+// apiToken là token xác thực (JWT) với FolkForm backend (được set sau khi login)
+// apiTokenExpiresAt là thời điểm token hết hạn (zero nếu không xác định được)
+// Không truy cập trực tiếp, dùng GetApiToken/SetApiToken/ClearApiToken để tránh race condition
+// giữa các job chạy song song và luồng đăng nhập lại khi token hết hạn
+var (
+	apiToken          string
+	apiTokenExpiresAt time.Time
+	apiTokenMu        sync.RWMutex
+)
+
+// GetApiToken trả về token FolkForm hiện tại (rỗng nếu chưa đăng nhập)
+func GetApiToken() string {
+	apiTokenMu.RLock()
+	defer apiTokenMu.RUnlock()
+	return apiToken
+}
+
+// GetApiTokenWithExpiry trả về token FolkForm hiện tại và thời điểm hết hạn
+func GetApiTokenWithExpiry() (string, time.Time) {
+	apiTokenMu.RLock()
+	defer apiTokenMu.RUnlock()
+	return apiToken, apiTokenExpiresAt
+}
+
+// SetApiToken lưu token FolkForm mới cùng thời điểm hết hạn
+func SetApiToken(token string, expiresAt time.Time) {
+	apiTokenMu.Lock()
+	defer apiTokenMu.Unlock()
+	apiToken = token
+	apiTokenExpiresAt = expiresAt
+}
 
-// ActiveRoleId là Role ID hiện tại đang làm việc (cho Organization Context System - API v3.2+)
+// ClearApiToken xóa token nếu token hiện tại vẫn là token được truyền vào
+// Tránh xóa nhầm token mới mà một luồng khác vừa đăng nhập lại được
+// Truyền token rỗng để xóa vô điều kiện
+func ClearApiToken(token string) {
+	apiTokenMu.Lock()
+	defer apiTokenMu.Unlock()
+	if token == "" || apiToken == token {
+		apiToken = ""
+		apiTokenExpiresAt = time.Time{}
+	}
+}
+
+// Role ID làm việc với FolkForm (cho Organization Context System - API v3.2+)
 // Header X-Active-Role-ID bắt buộc phải có trong mọi request đến FolkForm backend
-var ActiveRoleId string = ""
+// primaryRoleId là role mặc định của agent (check-in, config, workflow commands và các request không mang organization context)
+// Role của sync job chạy theo từng organization đi theo context.Context của lần chạy (xem integrations/folkform_org.go)
+var (
+	primaryRoleId string
+	roleIdMu      sync.RWMutex
+)
+
+// GetPrimaryRoleId trả về role mặc định của agent (không phụ thuộc organization context)
+func GetPrimaryRoleId() string {
+	roleIdMu.RLock()
+	defer roleIdMu.RUnlock()
+	return primaryRoleId
+}
+
+// SetActiveRoleId lưu role mặc định của agent
+func SetActiveRoleId(roleId string) {
+	roleIdMu.Lock()
+	defer roleIdMu.Unlock()
+	primaryRoleId = roleId
+}
 
 // FbPage là struct chứa thông tin của một Facebook page
 type FbPage struct {
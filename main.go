@@ -51,6 +51,9 @@ func main_() {
 	global.GlobalConfig = config.NewConfig()
 
 	AppLogger = logger.GetAppLogger()
+	if err := global.GlobalConfig.Validate(); err != nil {
+		AppLogger.WithError(err).Fatal("❌ Cấu hình không hợp lệ")
+	}
 	AppLogger.WithField("agentId", global.GlobalConfig.AgentId).Info("🚀 Khởi động agent")
 
 	// Khởi tạo scheduler
@@ -403,6 +406,8 @@ func main_() {
 	configManager := services.NewConfigManager(s)
 	// Set global ConfigManager để jobs có thể truy cập
 	services.SetGlobalConfigManager(configManager)
+	// Loại job server có thể tạo thêm instance qua config (phải đăng ký trước khi load config)
+	configManager.SetJobTemplates(jobs.JobTemplates())
 
 	// Login to backend TRƯỚC KHI load config (để có thể lấy config từ server nếu cần)
 	AppLogger.Info("🔐 Đang đăng nhập vào backend...")
@@ -467,11 +472,14 @@ func main_() {
 	logger.StartLogCleanupScheduler(24 * time.Hour)
 	AppLogger.Info("🧹 Đã khởi động log cleanup scheduler (chạy mỗi 24 giờ)")
 
+	// Hot reload agent-config.json và log filter config khi file thay đổi trên đĩa
+	services.StartConfigFileWatcher(configManager)
+
 	// ========================================
 	// TEST NOTIFICATION (Đã test thành công - comment lại)
 	// ========================================
 	// Uncomment dòng dưới để test gửi notification
-	// jobs.DoTestNotification()
+	// jobs.DoTestNotification(context.Background())
 
 	// Giữ chương trình chạy
 	// Trong thực tế, bạn có thể thêm các logic khác ở đây
@@ -509,6 +517,11 @@ func main() {
 	// Lấy logger cho application
 	AppLogger = logger.GetAppLogger()
 
+	// Kiểm tra cấu hình tĩnh (môi trường, base URL các upstream) trước khi chạy bất kỳ job nào
+	if err := global.GlobalConfig.Validate(); err != nil {
+		AppLogger.WithError(err).Fatal("❌ Cấu hình không hợp lệ")
+	}
+
 	// Log agentId khi cần debug (bật LOG_VERBOSE=1 để xem)
 	if os.Getenv("LOG_VERBOSE") == "1" {
 		AppLogger.WithField("agentId", global.GlobalConfig.AgentId).Info("[MAIN] AgentId từ config (LOG_VERBOSE=1)")
@@ -552,6 +565,8 @@ func main() {
 	configManager := services.NewConfigManager(s)
 	// Set global ConfigManager để jobs có thể truy cập
 	services.SetGlobalConfigManager(configManager)
+	// Loại job server có thể tạo thêm instance qua config (phải đăng ký trước khi load config)
+	configManager.SetJobTemplates(jobs.JobTemplates())
 
 	// Login to backend TRƯỚC KHI load config (để có thể lấy config từ server nếu cần)
 	AppLogger.Info("🔐 Đang đăng nhập vào backend...")
@@ -602,6 +617,9 @@ func main() {
 	logger.StartLogCleanupScheduler(24 * time.Hour)
 	AppLogger.Info("🧹 Đã khởi động log cleanup scheduler (chạy mỗi 24 giờ)")
 
+	// Hot reload agent-config.json và log filter config khi file thay đổi trên đĩa
+	services.StartConfigFileWatcher(configManager)
+
 	// Giữ chương trình chạy
 	select {}
 }
@@ -615,6 +633,9 @@ func main_test_job() {
 	global.GlobalConfig = config.NewConfig()
 
 	AppLogger = logger.GetAppLogger()
+	if err := global.GlobalConfig.Validate(); err != nil {
+		AppLogger.WithError(err).Fatal("❌ Cấu hình không hợp lệ")
+	}
 	AppLogger.Info("Đã đọc cấu hình từ file .env")
 
 	//jobs.DoSyncBackfillConversations_v2()
@@ -165,6 +165,13 @@ func GetLogFilterConfig() *LogFilterConfig {
 	return logFilterConfig
 }
 
+// GetLogFilterConfigPath trả về đường dẫn file log filter config đang dùng (rỗng nếu chưa load)
+func GetLogFilterConfigPath() string {
+	logFilterConfigMu.RLock()
+	defer logFilterConfigMu.RUnlock()
+	return logFilterConfigPath
+}
+
 // ReloadLogFilterConfig reload config từ file
 func ReloadLogFilterConfig() error {
 	if logFilterConfigPath == "" {
@@ -5,6 +5,7 @@ Hook này sẽ kiểm tra mỗi log entry và quyết định có nên ghi log h
 package logger
 
 import (
+	"agent_pancake/utility/secrets"
 	"io"
 	"os"
 	"strings"
@@ -316,7 +317,36 @@ func (f *FilteringFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	// Tạm thời, ta sẽ chỉ filter toàn bộ (không phân biệt console/file)
 	// Để filter riêng console/file, cần một cách tiếp cận khác (có thể dùng 2 logger riêng)
 
-	return formatted, nil
+	// Che mọi secret đã đăng ký (password, API key, token...) trước khi ghi ra console/file
+	return secrets.RedactBytes(formatted), nil
+}
+
+// RedactingFormatter bọc một formatter bất kỳ và che secret đã đăng ký trong output
+// Dùng cho logger tạo ngoài GetLogger (ví dụ logger fallback) để đảm bảo không log lộ secret
+type RedactingFormatter struct {
+	formatter logrus.Formatter
+}
+
+// NewRedactingFormatter tạo RedactingFormatter bọc formatter
+func NewRedactingFormatter(formatter logrus.Formatter) *RedactingFormatter {
+	return &RedactingFormatter{formatter: formatter}
+}
+
+// Format implement logrus.Formatter
+func (f *RedactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	formatted, err := f.formatter.Format(entry)
+	if err != nil {
+		return formatted, err
+	}
+	return secrets.RedactBytes(formatted), nil
+}
+
+// NewFallbackLogger tạo logrus logger mặc định (stderr) có che secret
+// Dùng thay cho logrus.New() khi không lấy được logger từ GetLogger
+func NewFallbackLogger() *logrus.Logger {
+	fallback := logrus.New()
+	fallback.SetFormatter(NewRedactingFormatter(fallback.Formatter))
+	return fallback
 }
 
 // FilteringMultiWriter là multi-writer với khả năng filter
@@ -149,6 +149,23 @@ func getRootDir() string {
 	return rootDir
 }
 
+// LogDir trả về thư mục lưu log files (đã resolve ./logs theo root directory)
+func LogDir() string {
+	cfg := globalCfg
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return resolveLogDir(cfg)
+}
+
+// resolveLogDir resolve thư mục log của cfg (mặc định: <root>/logs)
+func resolveLogDir(cfg *Config) string {
+	if cfg.LogDir == "" || cfg.LogDir == "./logs" {
+		return filepath.Join(getRootDir(), "logs")
+	}
+	return cfg.LogDir
+}
+
 // parseLogLevel chuyển đổi string sang logrus.Level
 func parseLogLevel(level string) logrus.Level {
 	switch strings.ToLower(level) {
@@ -307,10 +324,7 @@ func GetLogger(name string) *logrus.Logger {
 
 	// File writer với filter
 	if parseBool(cfg.EnableFile, true) {
-		logDir := cfg.LogDir
-		if logDir == "" || logDir == "./logs" {
-			logDir = filepath.Join(getRootDir(), "logs")
-		}
+		logDir := resolveLogDir(cfg)
 
 		// Đảm bảo thư mục logs tồn tại
 		if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -450,10 +464,7 @@ func CleanupOldLogs() error {
 		return nil
 	}
 
-	logDir := cfg.LogDir
-	if logDir == "" || logDir == "./logs" {
-		logDir = filepath.Join(getRootDir(), "logs")
-	}
+	logDir := resolveLogDir(cfg)
 
 	// Kiểm tra thư mục logs có tồn tại không
 	if _, err := os.Stat(logDir); os.IsNotExist(err) {
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// commandTimeout là thời gian tối đa cho một lần chạy lệnh lấy secret
+const commandTimeout = 15 * time.Second
+
+// CommandProvider lấy secret bằng cách chạy một lệnh bên ngoài và đọc stdout
+// Ví dụ: "vault kv get -field={key} secret/agent-pancake", "pass show agent/{key}"
+// - "{key}" trong lệnh được thay bằng tên secret; nếu không có "{key}", tên secret được thêm vào cuối làm tham số
+// - Tên secret cũng được truyền qua biến môi trường AGENT_SECRET_KEY
+// - Exit code khác 0 là lỗi; stdout rỗng nghĩa là không có secret
+// Lệnh được tách theo khoảng trắng và chạy trực tiếp (không qua shell)
+type CommandProvider struct {
+	Command string
+}
+
+func (p *CommandProvider) Name() string { return ProviderCommand }
+
+func (p *CommandProvider) Get(key string) (string, bool, error) {
+	args := strings.Fields(p.Command)
+	if len(args) == 0 {
+		return "", false, fmt.Errorf("lệnh lấy secret rỗng")
+	}
+	if strings.Contains(p.Command, "{key}") {
+		for i := range args {
+			args[i] = strings.ReplaceAll(args[i], "{key}", key)
+		}
+	} else {
+		args = append(args, key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "AGENT_SECRET_KEY="+key)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// Không đưa stdout vào lỗi (có thể chứa secret), stderr chỉ lấy dòng đầu
+		return "", false, fmt.Errorf("lệnh %s thất bại: %v %s", args[0], err, firstLine(stderr.String()))
+	}
+
+	value := strings.TrimRight(stdout.String(), "\r\n")
+	if value == "" {
+		return "", false, nil
+	}
+	return value, true, nil
+}
+
+// firstLine trả về dòng đầu tiên (đã trim) của s
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,20 @@
+package secrets
+
+import "os"
+
+// Tên các provider
+const (
+	ProviderEnv     = "env"
+	ProviderFile    = "file"
+	ProviderCommand = "command"
+)
+
+// EnvProvider đọc secret từ biến môi trường (đã gồm các biến load từ file .env/agent.env)
+type EnvProvider struct{}
+
+func (EnvProvider) Name() string { return ProviderEnv }
+
+func (EnvProvider) Get(key string) (string, bool, error) {
+	value, found := os.LookupEnv(key)
+	return value, found, nil
+}
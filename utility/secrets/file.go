@@ -0,0 +1,163 @@
+package secrets
+
+import (
+	"agent_pancake/utility/hwid"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedFileVersion là phiên bản định dạng file secret
+const encryptedFileVersion = 1
+
+// machineKeySalt được ghép với Hardware ID để tạo key khi không có key file
+const machineKeySalt = "agent_pancake/secrets:"
+
+// encryptedFile là định dạng file secret trên đĩa
+// Data là JSON map[tên secret]giá trị, mã hóa AES-256-GCM
+type encryptedFile struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"`
+	KeySource string `json:"keySource"` // "keyfile" hoặc "machine" (chỉ để tham khảo khi debug)
+	Nonce     string `json:"nonce"`     // base64
+	Data      string `json:"data"`      // base64
+}
+
+// EncryptedFileProvider đọc secret từ file mã hóa (giải mã một lần khi khởi tạo)
+type EncryptedFileProvider struct {
+	path   string
+	values map[string]string
+}
+
+// NewEncryptedFileProvider giải mã file secret tại path
+// keyFile rỗng → key sinh từ Hardware ID của máy (file chỉ giải mã được trên máy đã tạo ra nó)
+func NewEncryptedFileProvider(path, keyFile string) (*EncryptedFileProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("provider file cần cấu hình AGENT_SECRETS_FILE")
+	}
+	key, err := LoadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("không đọc được file secret %s: %w", path, err)
+	}
+	var file encryptedFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("file secret %s không đúng định dạng: %w", path, err)
+	}
+	if file.Version != encryptedFileVersion {
+		return nil, fmt.Errorf("file secret %s có version %d không được hỗ trợ", path, file.Version)
+	}
+
+	values, err := decrypt(key, file)
+	if err != nil {
+		return nil, fmt.Errorf("không giải mã được file secret %s (sai key hoặc file được tạo trên máy khác): %w", path, err)
+	}
+	return &EncryptedFileProvider{path: path, values: values}, nil
+}
+
+func (p *EncryptedFileProvider) Name() string { return ProviderFile }
+
+func (p *EncryptedFileProvider) Get(key string) (string, bool, error) {
+	value, found := p.values[key]
+	return value, found, nil
+}
+
+// LoadKey lấy key 32 byte để mã hóa/giải mã file secret
+// - keyFile có giá trị: SHA-256 của nội dung file (đã trim khoảng trắng)
+// - keyFile rỗng: SHA-256 của Hardware ID máy hiện tại
+func LoadKey(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("không đọc được key file %s: %w", keyFile, err)
+		}
+		material := strings.TrimSpace(string(content))
+		if material == "" {
+			return nil, fmt.Errorf("key file %s rỗng", keyFile)
+		}
+		sum := sha256.Sum256([]byte(material))
+		return sum[:], nil
+	}
+
+	machineId, err := hwid.GenerateHardwareID()
+	if err != nil {
+		return nil, fmt.Errorf("không lấy được Hardware ID để tạo key: %w", err)
+	}
+	sum := sha256.Sum256([]byte(machineKeySalt + machineId))
+	return sum[:], nil
+}
+
+// WriteEncryptedFile mã hóa values và ghi ra path (quyền 0600)
+// keySource chỉ ghi vào file để tham khảo ("keyfile" hoặc "machine")
+func WriteEncryptedFile(path string, key []byte, keySource string, values map[string]string) error {
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("không tạo được nonce: %w", err)
+	}
+
+	file := encryptedFile{
+		Version:   encryptedFileVersion,
+		Algorithm: "AES-256-GCM",
+		KeySource: keySource,
+		Nonce:     base64.StdEncoding.EncodeToString(nonce),
+		Data:      base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	}
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0600)
+}
+
+func decrypt(key []byte, file encryptedFile) (map[string]string, error) {
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(file.Data)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("nonce không hợp lệ")
+	}
+	plaintext, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// RedactedPlaceholder thay cho giá trị secret trong log
+	RedactedPlaceholder = "***"
+
+	// minSecretLength: giá trị ngắn hơn không được đăng ký (tránh che nhầm chữ thường gặp trong log)
+	minSecretLength = 6
+
+	// maxRegisteredSecrets giới hạn số secret lưu để che (token xoay vòng liên tục), bỏ secret cũ nhất khi đầy
+	maxRegisteredSecrets = 2000
+)
+
+// registry lưu các giá trị secret đã biết để che trong log
+var (
+	registry      = make(map[string]struct{})
+	registryOrder []string
+	registryMu    sync.RWMutex
+	// sortedCache là danh sách secret sắp xếp theo độ dài giảm dần (che chuỗi dài trước), nil khi cần tính lại
+	sortedCache []string
+)
+
+// Register đăng ký các giá trị secret để che trong mọi log
+// Giá trị rỗng hoặc quá ngắn (< minSecretLength) bị bỏ qua
+func Register(values ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, value := range values {
+		if len(value) < minSecretLength {
+			continue
+		}
+		if _, exists := registry[value]; exists {
+			continue
+		}
+		registry[value] = struct{}{}
+		registryOrder = append(registryOrder, value)
+		sortedCache = nil
+	}
+	for len(registryOrder) > maxRegisteredSecrets {
+		delete(registry, registryOrder[0])
+		registryOrder = registryOrder[1:]
+		sortedCache = nil
+	}
+}
+
+// Redact thay mọi secret đã đăng ký trong s bằng RedactedPlaceholder
+func Redact(s string) string {
+	for _, secret := range sortedSecrets() {
+		if strings.Contains(s, secret) {
+			s = strings.ReplaceAll(s, secret, RedactedPlaceholder)
+		}
+	}
+	return s
+}
+
+// RedactBytes giống Redact cho []byte (dùng trong formatter của logger)
+func RedactBytes(b []byte) []byte {
+	secrets := sortedSecrets()
+	if len(secrets) == 0 {
+		return b
+	}
+	s := string(b)
+	redacted := Redact(s)
+	if redacted == s {
+		return b
+	}
+	return []byte(redacted)
+}
+
+// Fingerprint trả về dấu vân tay ngắn của secret (8 ký tự đầu SHA-256)
+// Dùng trong log để phân biệt các key mà không lộ giá trị hay độ dài
+func Fingerprint(value string) string {
+	if value == "" {
+		return "(empty)"
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:4])
+}
+
+func sortedSecrets() []string {
+	registryMu.RLock()
+	cached := sortedCache
+	empty := len(registryOrder) == 0
+	registryMu.RUnlock()
+	if cached != nil || empty {
+		return cached
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if sortedCache == nil {
+		sorted := make([]string, len(registryOrder))
+		copy(sorted, registryOrder)
+		sort.Slice(sorted, func(i, k int) bool { return len(sorted[i]) > len(sorted[k]) })
+		sortedCache = sorted
+	}
+	return sortedCache
+}
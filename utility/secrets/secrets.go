@@ -0,0 +1,86 @@
+/*
+Package secrets cung cấp nguồn lấy secret (Firebase API key/password...) cho config và cơ chế che secret trong log.
+
+Provider:
+  - env: biến môi trường (mặc định, tương thích cấu hình cũ)
+  - file: file mã hóa AES-256-GCM, key lấy từ file riêng hoặc từ Hardware ID của máy
+  - command: chạy một lệnh bên ngoài (vault, pass, aws ssm...) và đọc secret từ stdout
+
+Redaction:
+  - Mọi secret đã biết (từ config hoặc nhận được lúc chạy như token, API key POS) được đăng ký bằng Register
+  - Logger thay mọi giá trị đã đăng ký bằng "***" trước khi ghi (xem Redact)
+*/
+package secrets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provider là một nguồn secret
+type Provider interface {
+	// Name trả về tên provider (dùng để log nguồn của secret, không log giá trị)
+	Name() string
+
+	// Get lấy secret theo tên (ví dụ: "FIREBASE_PASSWORD")
+	// Trả về found=false nếu provider không có secret này (không phải lỗi)
+	Get(key string) (value string, found bool, err error)
+}
+
+// Chain tra cứu secret lần lượt qua các provider, provider đứng trước được ưu tiên
+type Chain []Provider
+
+// Lookup trả về giá trị đầu tiên tìm thấy và tên provider cung cấp nó
+// Lỗi của một provider không dừng tra cứu, các lỗi được gộp lại nếu không provider nào có secret
+func (c Chain) Lookup(key string) (value string, source string, err error) {
+	var problems []string
+	for _, provider := range c {
+		value, found, err := provider.Get(key)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", provider.Name(), err))
+			continue
+		}
+		if found && value != "" {
+			return value, provider.Name(), nil
+		}
+	}
+	if len(problems) > 0 {
+		return "", "", fmt.Errorf("không lấy được secret %s (%s)", key, strings.Join(problems, "; "))
+	}
+	return "", "", nil
+}
+
+// Options là cấu hình để tạo chain provider (lấy từ AGENT_SECRETS_*)
+type Options struct {
+	Providers []string // Thứ tự provider: env, file, command
+	FilePath  string   // Đường dẫn file secret mã hóa (provider file)
+	KeyFile   string   // File chứa key giải mã (rỗng = dùng Hardware ID của máy)
+	Command   string   // Lệnh lấy secret (provider command), "{key}" được thay bằng tên secret
+}
+
+// NewChain tạo chain provider theo thứ tự trong opts.Providers
+func NewChain(opts Options) (Chain, error) {
+	var chain Chain
+	for _, name := range opts.Providers {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case ProviderEnv:
+			chain = append(chain, EnvProvider{})
+		case ProviderFile:
+			provider, err := NewEncryptedFileProvider(opts.FilePath, opts.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, provider)
+		case ProviderCommand:
+			if strings.TrimSpace(opts.Command) == "" {
+				return nil, fmt.Errorf("provider command cần cấu hình AGENT_SECRETS_COMMAND")
+			}
+			chain = append(chain, &CommandProvider{Command: opts.Command})
+		default:
+			return nil, fmt.Errorf("secrets provider %q không hợp lệ (hỗ trợ: env, file, command)", name)
+		}
+	}
+	return chain, nil
+}